@@ -0,0 +1,69 @@
+// Command bootstrap creates the users table and its accompanying tables
+// where they are missing, and adds the indexes, TTL and streams existing
+// tables lack. Deployed as its own Lambda, such as a custom resource of an
+// ephemeral stack, it does so for each invocation; run anywhere else it does
+// so once and prints the report. Point it at DynamoDB Local with -endpoint.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/bootstrap"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// Event is the payload the bootstrap Lambda is invoked with.
+type Event struct {
+	Table string `json:"table"`
+}
+
+func main() {
+	cfg := config.Load()
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		// Tables are created with the indexes named as the API queries them
+		a, err := app.New(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		lambda.Start(func(ctx context.Context, event Event) (*bootstrap.Report, error) {
+			table := event.Table
+			if table == "" {
+				table = a.TableName
+			}
+			return bootstrap.Ensure(table, a.Client)
+		})
+		return
+	}
+
+	table := flag.String("table", cfg.TableName, "users table to create")
+	region := flag.String("region", stringOr(os.Getenv("AWS_REGION"), "eu-west-2"), "AWS region of the tables")
+	endpoint := flag.String("endpoint", "", "DynamoDB endpoint, e.g. http://localhost:8000 for DynamoDB Local")
+	flag.Parse()
+	a, err := app.New(cfg, app.WithTableName(*table), app.WithRegion(*region), app.WithEndpoint(*endpoint), app.WithOutput(os.Stderr))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	report, err := bootstrap.Ensure(a.TableName, a.Client)
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func stringOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,85 @@
+// Command cognito answers a Cognito user pool's PostConfirmation and
+// PreTokenGeneration triggers with pkg/cognito, creating users as they sign
+// up and enriching their tokens as they sign in. Deployed as its own Lambda,
+// set as both triggers of the pool, it answers each trigger it is invoked
+// with; run anywhere else it answers the one trigger event read from stdin
+// and prints its response.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cognito"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/logger"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	// The App validates the users that sign up as the API validates those
+	// it creates
+	a, err := app.New(config.Load())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+			return handle(payload, a)
+		})
+		return
+	}
+
+	payload, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	response, err := handle(payload, a)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(response, "", "  ")
+	fmt.Println(string(out))
+}
+
+// handle answers the trigger event in payload by its trigger source,
+// returning the event with its response for Cognito.
+func handle(payload []byte, a *app.App) (interface{}, error) {
+	var header events.CognitoEventUserPoolsHeader
+	if err := json.Unmarshal(payload, &header); err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(header.TriggerSource, "PostConfirmation_"):
+		var event events.CognitoEventUserPoolsPostConfirmation
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		// Confirming a forgotten password changes nothing here
+		if event.TriggerSource != cognito.TriggerConfirmSignUp {
+			return event, nil
+		}
+		if err := cognito.PostConfirmation(event, a.TableName, a.Client); err != nil {
+			a.Log.Error("cognito sign up not synced", err, logger.Fields{"userName": event.UserName})
+		}
+		return event, nil
+	case strings.HasPrefix(header.TriggerSource, cognito.TriggerTokenGeneration):
+		var event events.CognitoEventUserPoolsPreTokenGen
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		return cognito.PreTokenGeneration(event, a.TableName, a.Client)
+	}
+	return nil, errors.New("unknown cognito trigger source " + header.TriggerSource)
+}
@@ -0,0 +1,113 @@
+// Command deadletter drains the dead-letter queue named by
+// DEADLETTER_QUEUE_URL with pkg/deadletter, creating imported users and
+// publishing notifications again, to OUTBOX_EVENT_BUS or else
+// OUTBOX_TOPIC_ARN, and parking what cannot be reprocessed under
+// QUARANTINE_PREFIX in QUARANTINE_BUCKET. Deployed as its own Lambda, on a
+// schedule or invoked by an operator, it drains the queue for each
+// invocation; run anywhere else it drains it once and prints the report.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/deadletter"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Event is the payload the reprocessor Lambda is invoked with. Max caps the
+// messages drained by one invocation; zero drains them all.
+type Event struct {
+	Table string `json:"table"`
+	Max   int    `json:"max"`
+}
+
+type clients struct {
+	app        *app.App
+	queue      deadletter.Queue
+	quarantine deadletter.Quarantine
+	publisher  outbox.Publisher
+}
+
+func main() {
+	c, err := newClients()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event Event) (*deadletter.Report, error) {
+			return handle(event, c)
+		})
+		return
+	}
+
+	var event Event
+	flag.StringVar(&event.Table, "table", c.app.TableName, "users table imported users are created in")
+	flag.IntVar(&event.Max, "max", 0, "most messages to drain, 0 for all")
+	flag.Parse()
+	report, err := handle(event, c)
+	if report != nil {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func handle(event Event, c *clients) (*deadletter.Report, error) {
+	table := event.Table
+	if table == "" {
+		table = c.app.TableName
+	}
+	return deadletter.Drain(c.queue, c.quarantine, c.publisher, table, c.app.Client, event.Max)
+}
+
+func newClients() (*clients, error) {
+	cfg := config.Load()
+	if cfg.DeadLetterQueueURL == "" || cfg.QuarantineBucket == "" {
+		return nil, errors.New("set " + config.EnvDeadLetterQueueURL + " and " + config.EnvQuarantineBucket + " to drain the dead-letter queue")
+	}
+	deadletter.Configure(deadletter.Config{
+		MaxAttempts: cfg.DeadLetterMaxAttempts,
+		RetryDelay:  cfg.DeadLetterRetryDelay,
+	})
+	// Imported users are created as the API creates them
+	a, err := app.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var publisher outbox.Publisher
+	switch {
+	case cfg.OutboxEventBus != "":
+		publisher = outbox.EventBridgePublisher{
+			Client: eventbridge.New(a.Session),
+			Bus:    cfg.OutboxEventBus,
+			Source: cfg.OutboxEventSource,
+		}
+	case cfg.OutboxTopicARN != "":
+		publisher = outbox.SNSPublisher{Client: sns.New(a.Session), TopicARN: cfg.OutboxTopicARN}
+	default:
+		return nil, errors.New("set " + config.EnvOutboxEventBus + " or " + config.EnvOutboxTopicARN + " to publish notifications again")
+	}
+	return &clients{
+		app:        a,
+		queue:      deadletter.Queue{SQS: sqs.New(a.Session), URL: cfg.DeadLetterQueueURL},
+		quarantine: deadletter.Quarantine{S3: s3.New(a.Session), Bucket: cfg.QuarantineBucket, Prefix: cfg.QuarantinePrefix},
+		publisher:  publisher,
+	}, nil
+}
@@ -0,0 +1,92 @@
+// Command lakewriter lands every change to a user in the data lake at
+// DATA_LAKE_BUCKET with pkg/datalake. Deployed as its own Lambda on the
+// users table's stream, with both new and old images and batch item failures
+// reported, it writes each batch's changes to the partitions of the days
+// they were made on; run anywhere else it prints the statement declaring the
+// Athena table that reads them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/datalake"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/stream"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func main() {
+	if err := configure(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event events.DynamoDBEvent) (stream.Response, error) {
+			return land(event), nil
+		})
+		return
+	}
+
+	table := flag.String("table", "user_changes", "name of the Athena table to declare")
+	flag.Parse()
+	fmt.Print(datalake.Table(*table))
+}
+
+// land lands the changes in event. A record that cannot be read fails after
+// the changes before it are landed; a batch that cannot be landed fails from
+// its first record, and as objects are named after the changes they start
+// with, landing it again replaces what it landed before.
+func land(event events.DynamoDBEvent) stream.Response {
+	failed := func(record events.DynamoDBEventRecord, err error) stream.Response {
+		fmt.Fprintln(os.Stderr, record.EventID, err)
+		return stream.Failed(record)
+	}
+	changes := []datalake.Change{}
+	for _, record := range event.Records {
+		change, ok, err := datalake.FromStreamRecord(record)
+		if err != nil {
+			if _, landErr := datalake.Land(changes); landErr != nil {
+				return failed(event.Records[0], landErr)
+			}
+			return failed(record, err)
+		}
+		if ok {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) > 0 {
+		if _, err := datalake.Land(changes); err != nil {
+			return failed(event.Records[0], err)
+		}
+	}
+	return stream.Done()
+}
+
+func configure() error {
+	cfg := config.Load()
+	if cfg.DataLakeBucket == "" {
+		return errors.New("set " + config.EnvDataLakeBucket + " to land user changes")
+	}
+	if cfg.DataLakeFormat != datalake.FormatJSON && cfg.DataLakeFormat != datalake.FormatParquet {
+		return errors.New(config.EnvDataLakeFormat + " must be " + datalake.FormatJSON + " or " + datalake.FormatParquet)
+	}
+	a, err := app.New(cfg)
+	if err != nil {
+		return err
+	}
+	datalake.Configure(datalake.Config{
+		Bucket: cfg.DataLakeBucket,
+		Prefix: cfg.DataLakePrefix,
+		Format: cfg.DataLakeFormat,
+		S3:     s3.New(a.Session),
+	})
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxBodySize is the largest body API Gateway passes on to a Lambda.
+const maxBodySize = 10 << 20
+
+// stage is the stage local requests claim to come through.
+const stage = "local"
+
+// requestID numbers local requests the way API Gateway would identify them.
+var requestID = func() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// toProxyRequest builds the request API Gateway's proxy integration would
+// have passed on for r. Bodies that are not valid UTF-8 are base64 encoded,
+// as API Gateway does for binary media types.
+func toProxyRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod:                      r.Method,
+		Path:                            r.URL.Path,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Stage:      stage,
+			RequestID:  requestID(),
+			HTTPMethod: r.Method,
+			Path:       "/" + stage + r.URL.Path,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP(r),
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+	for name, values := range r.Header {
+		req.Headers[name] = values[len(values)-1]
+		req.MultiValueHeaders[name] = values
+	}
+	for name, values := range r.URL.Query() {
+		req.QueryStringParameters[name] = values[len(values)-1]
+		req.MultiValueQueryStringParameters[name] = values
+	}
+	if utf8.Valid(body) {
+		req.Body = string(body)
+	} else {
+		req.Body = base64.StdEncoding.EncodeToString(body)
+		req.IsBase64Encoded = true
+	}
+	return req, nil
+}
+
+// writeResponse writes resp to w as API Gateway would send it to the client.
+func writeResponse(w http.ResponseWriter, resp *events.APIGatewayProxyResponse) {
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for name, value := range resp.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range resp.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			http.Error(w, "invalid base64 response body", http.StatusBadGateway)
+			return
+		}
+		body = decoded
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestToProxyRequest(t *testing.T) {
+	t.Run("expect the method, path, query, headers and body to be carried over", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/users/alan.oliver@ecs.co.uk/tags?tag=a&tag=b", strings.NewReader(`{"tags": ["beta"]}`))
+		r.Header.Set("Accept-Language", "fr")
+		r.Header.Set("User-Agent", "curl/8.0")
+		req, err := toProxyRequest(r)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if req.HTTPMethod != "POST" || req.Path != "/users/alan.oliver@ecs.co.uk/tags" {
+			t.Errorf("expected the method and path, got %s %s", req.HTTPMethod, req.Path)
+		}
+		if req.QueryStringParameters["tag"] != "b" || len(req.MultiValueQueryStringParameters["tag"]) != 2 {
+			t.Errorf("expected the last value and every value of the query, got %v %v", req.QueryStringParameters, req.MultiValueQueryStringParameters)
+		}
+		if req.Headers["Accept-Language"] != "fr" || req.RequestContext.Identity.UserAgent != "curl/8.0" {
+			t.Errorf("expected the headers, got %v", req.Headers)
+		}
+		if req.Body != `{"tags": ["beta"]}` || req.IsBase64Encoded {
+			t.Errorf("expected the body as text, got %q", req.Body)
+		}
+		if req.RequestContext.Identity.SourceIP != "192.0.2.1" || req.RequestContext.Stage != stage {
+			t.Errorf("expected the source IP and stage, got %+v", req.RequestContext)
+		}
+	})
+
+	t.Run("expect a binary body to be base64 encoded", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/imports", bytes.NewReader([]byte{0xff, 0xfe}))
+		req, _ := toProxyRequest(r)
+		if !req.IsBase64Encoded || req.Body != "//4=" {
+			t.Errorf("expected a base64 body, got %q", req.Body)
+		}
+	})
+}
+
+func TestWriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeResponse(w, &events.APIGatewayProxyResponse{
+		StatusCode:        201,
+		Headers:           map[string]string{"Content-Language": "en"},
+		MultiValueHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}},
+		Body:              `{"email":"alan.oliver@ecs.co.uk"}`,
+	})
+	if w.Code != 201 || w.Header().Get("Content-Language") != "en" || len(w.Header().Values("Set-Cookie")) != 2 {
+		t.Errorf("expected the status and headers, got %d %v", w.Code, w.Header())
+	}
+	if w.Body.String() != `{"email":"alan.oliver@ecs.co.uk"}` {
+		t.Errorf("expected the body, got %q", w.Body.String())
+	}
+}
+
+type userClient struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (c *userClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"email":     input.Key["email"],
+		"firstName": {S: aws.String("Alan")},
+		"lastName":  {S: aws.String("Oliver")},
+	}}, nil
+}
+
+func TestHandler(t *testing.T) {
+	application, err := app.New(config.Config{}, app.WithClient(&userClient{}), app.WithTableName("test"), app.WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err.Error())
+	}
+	server := httptest.NewServer(newHandler(application))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/?email=alan.oliver@ecs.co.uk")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `"firstName":"Alan"`) {
+		t.Errorf("expected the user, got %d %s", resp.StatusCode, body)
+	}
+}
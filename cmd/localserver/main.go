@@ -0,0 +1,109 @@
+// Command localserver serves the API over plain HTTP, passing each request
+// through the same App, router and handlers as the Lambda, so it can be run
+// and tried locally without deploying. Point it at DynamoDB Local with
+// -endpoint, and add -bootstrap to create the tables there first.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/bootstrap"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/logger"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func main() {
+	cfg := config.Load()
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	table := flag.String("table", cfg.TableName, "users table")
+	region := flag.String("region", stringOr(os.Getenv("AWS_REGION"), "eu-west-2"), "AWS region of the table")
+	endpoint := flag.String("endpoint", "", "DynamoDB endpoint, e.g. http://localhost:8000 for DynamoDB Local")
+	ensure := flag.Bool("bootstrap", false, "create the tables that do not exist before serving")
+	flag.Parse()
+
+	log := logger.New(os.Stdout, logger.NewSanitizer(cfg.LogRedactFields))
+	client, err := newClient(cfg, *region, *endpoint)
+	if err != nil {
+		log.Error("failed to create dynamodb client", err, nil)
+		os.Exit(1)
+	}
+	if *ensure {
+		report, err := bootstrap.Ensure(*table, client)
+		if err != nil {
+			log.Error("failed to bootstrap tables", err, nil)
+			os.Exit(1)
+		}
+		log.Info("bootstrapped tables", logger.Fields{"created": report.Created, "updated": report.Updated})
+	}
+	// A local OpenSearch takes requests unsigned
+	application, err := app.New(cfg, app.WithClient(client), app.WithTableName(*table), app.WithRegion(*region), app.WithUnsignedSearch())
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "serving %s on http://%s\n", *table, *addr)
+	if err := http.ListenAndServe(*addr, newHandler(application)); err != nil {
+		log.Error("server stopped", err, nil)
+		os.Exit(1)
+	}
+}
+
+// newHandler answers each HTTP request as application answers the API
+// Gateway request the Lambda would have been invoked with.
+func newHandler(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := toProxyRequest(r)
+		if err != nil {
+			http.Error(w, "failed to read request", http.StatusBadRequest)
+			return
+		}
+		resp, err := application.Handle(r.Context(), req)
+		if err != nil {
+			// The Lambda runtime answers handler errors with a 502, and the
+			// App has logged the error
+			http.Error(w, "internal server error", http.StatusBadGateway)
+			return
+		}
+		writeResponse(w, resp)
+	})
+}
+
+// newClient returns a DynamoDB client for the table in region, reached at
+// endpoint when one is given.
+func newClient(cfg config.Config, region string, endpoint string) (dynamodbiface.DynamoDBAPI, error) {
+	awsConfig := &aws.Config{Region: aws.String(region)}
+	if endpoint != "" {
+		awsConfig.Endpoint = aws.String(endpoint)
+	}
+	awsSession, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return store.New(awsSession, store.Config{
+		Retryer: store.NewRetryer(store.RetryConfig{
+			MaxAttempts:   cfg.DynamoMaxAttempts,
+			BaseDelay:     cfg.DynamoRetryBaseDelay,
+			MaxDelay:      cfg.DynamoRetryMaxDelay,
+			Jitter:        cfg.DynamoRetryJitter,
+			UntilDeadline: cfg.DynamoRetryThrottles,
+		}),
+		// Local requests have no invocation deadline, only the timeout of
+		// each call
+		Deadlines: store.NewDeadlines(store.TimeoutConfig{OperationTimeout: cfg.DynamoTimeout}),
+	})
+}
+
+func stringOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -1,47 +1,50 @@
 package main
 
 import (
-	"os"
+	"context"
+	"encoding/json"
+	"sync"
 
-	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/handlers"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
+func main() {
+	lambda.Start(invoke)
+}
+
 var (
-	dynaClient dynamodbiface.DynamoDBAPI
+	setupMu     sync.Mutex
+	application *app.App
+	// options are passed to the App as it is set up, so tests can give it
+	// a client of their own
+	options []app.Option
 )
 
-func main() {
-	region := os.Getenv("AWS_REGION")
-	awsSession, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+// ready sets the container up on its first invocation. The App is then
+// shared by every invocation the container serves. Setup that failed, such
+// as for a parameter SSM could not read just then, is tried again by the
+// next invocation rather than failing every one the container serves.
+func ready() (*app.App, error) {
+	setupMu.Lock()
+	defer setupMu.Unlock()
+	if application != nil {
+		return application, nil
+	}
+	a, err := app.New(config.Load(), options...)
 	if err != nil {
-		return
+		return nil, err
 	}
-	dynaClient = dynamodb.New(awsSession)
-	lambda.Start(handler)
+	application = a
+	return application, nil
 }
 
-const tableName = "LambdaInGoUser"
-
-func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
-	switch req.HTTPMethod {
-	case "GET":
-		return handlers.GetUser(req, tableName, dynaClient)
-	case "POST":
-		return handlers.CreateUser(req, tableName, dynaClient)
-	case "PUT":
-		return handlers.UpdateUser(req, tableName, dynaClient)
-	case "DELETE":
-		return handlers.DeleteUser(req, tableName, dynaClient)
-	default:
-		return handlers.UnhandledMethod()
+func invoke(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	a, err := ready()
+	if err != nil {
+		return nil, err
 	}
+	return a.Invoke(ctx, payload)
 }
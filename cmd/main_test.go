@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+)
+
+var ping = json.RawMessage(`{"warmup": true}`)
+
+// setUp points new containers at an in-memory client, so invocations make
+// no calls to AWS, and clears the App of the previous test.
+func setUp(tb testing.TB) {
+	os.Setenv("AWS_REGION", "eu-west-2")
+	options = []app.Option{app.WithClient(testutil.NewMemoryDynamoDB(testutil.Table{Name: app.DefaultTableName, PartitionKey: "email"}))}
+	application = nil
+	tb.Cleanup(func() {
+		options = nil
+		application = nil
+	})
+}
+
+func TestReady(t *testing.T) {
+	setUp(t)
+	t.Run("expect setup that failed to be tried again", func(t *testing.T) {
+		os.Setenv(config.EnvExportBucket, "exports")
+		os.Setenv(config.EnvExportPartSize, "1024")
+		if _, err := invoke(context.Background(), ping); err == nil {
+			t.Fatal("Expected the setup to fail")
+		}
+		os.Unsetenv(config.EnvExportBucket)
+		os.Unsetenv(config.EnvExportPartSize)
+		if _, err := invoke(context.Background(), ping); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+	})
+	t.Run("expect the App set up to be kept", func(t *testing.T) {
+		first, _ := ready()
+		if second, _ := ready(); first == nil || second != first {
+			t.Error("Expected every invocation to share the App")
+		}
+	})
+}
+
+// BenchmarkColdStart measures an invocation of a new container, which sets
+// the App up first, and BenchmarkWarmStart one of a container already set
+// up, which reuses it. The gap between them is what every invocation would
+// pay were the App set up for each.
+func BenchmarkColdStart(b *testing.B) {
+	setUp(b)
+	for i := 0; i < b.N; i++ {
+		application = nil
+		if _, err := invoke(context.Background(), ping); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWarmStart(b *testing.B) {
+	setUp(b)
+	if _, err := invoke(context.Background(), ping); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := invoke(context.Background(), ping); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
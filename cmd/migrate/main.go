@@ -0,0 +1,74 @@
+// Command migrate applies the pending migrations of the users table. Deployed
+// as its own Lambda it runs them for each invocation; run anywhere else it
+// runs them once and prints the report.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/migrate"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// Event is the payload the migrate Lambda is invoked with. With Pending set
+// the pending migrations are listed rather than run.
+type Event struct {
+	Table   string `json:"table"`
+	Pending bool   `json:"pending"`
+}
+
+func main() {
+	// Moving a user reseals their MFA secret for the new email, with the
+	// key the App is configured with
+	a, err := app.New(config.Load())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event Event) (interface{}, error) {
+			return handle(event, a)
+		})
+		return
+	}
+
+	var event Event
+	flag.StringVar(&event.Table, "table", a.TableName, "users table to migrate")
+	flag.BoolVar(&event.Pending, "pending", false, "list the pending migrations without running them")
+	flag.Parse()
+	result, err := handle(event, a)
+	if result != nil {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func handle(event Event, a *app.App) (interface{}, error) {
+	table := event.Table
+	if table == "" {
+		table = a.TableName
+	}
+	if event.Pending {
+		pending, err := migrate.Pending(migrate.Migrations, table, a.Client)
+		if err != nil {
+			return nil, err
+		}
+		versions := []string{}
+		for _, m := range pending {
+			versions = append(versions, fmt.Sprintf("%d %s", m.Version, m.Name))
+		}
+		return versions, nil
+	}
+	return migrate.Run(migrate.Migrations, table, a.Client)
+}
@@ -0,0 +1,97 @@
+// Command outboxrelay publishes the messages waiting in the outbox of the
+// users table, to the EventBridge bus named by OUTBOX_EVENT_BUS or else the
+// SNS topic named by OUTBOX_TOPIC_ARN, and delivers them to the registered
+// webhooks while WEBHOOKS_ENABLED is set. Deployed as its own Lambda, usually on
+// a schedule or a stream of the outbox table, it drains the outbox for each
+// invocation; run anywhere else it drains it once and prints the result.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// Event is the payload the relay Lambda is invoked with. Max caps the
+// messages published by one invocation; zero publishes them all.
+type Event struct {
+	Table string `json:"table"`
+	Max   int    `json:"max"`
+}
+
+func main() {
+	a, publishers, err := newClients()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event Event) (*outbox.DrainResult, error) {
+			return handle(event, a, publishers)
+		})
+		return
+	}
+
+	var event Event
+	flag.StringVar(&event.Table, "table", a.TableName, "users table whose outbox to drain")
+	flag.IntVar(&event.Max, "max", 0, "most messages to publish, 0 for all")
+	flag.Parse()
+	result, err := handle(event, a, publishers)
+	if result != nil {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func handle(event Event, a *app.App, publishers outbox.Publishers) (*outbox.DrainResult, error) {
+	table := event.Table
+	if table == "" {
+		table = a.TableName
+	}
+	if webhook.Enabled() {
+		// Webhooks are delivered after the messages are published on, as
+		// they are slower and only fail when they cannot be read
+		publishers = append(publishers[:len(publishers):len(publishers)], webhook.Publisher{TableName: table, Client: a.Client})
+	}
+	return outbox.Drain(table, a.Client, publishers, event.Max)
+}
+
+// newClients returns the App, which configures webhook delivery, and the
+// publishers messages are published on.
+func newClients() (*app.App, outbox.Publishers, error) {
+	cfg := config.Load()
+	a, err := app.New(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	publishers := outbox.Publishers{}
+	switch {
+	case cfg.OutboxEventBus != "":
+		publishers = append(publishers, outbox.EventBridgePublisher{
+			Client: eventbridge.New(a.Session),
+			Bus:    cfg.OutboxEventBus,
+			Source: cfg.OutboxEventSource,
+		})
+	case cfg.OutboxTopicARN != "":
+		publishers = append(publishers, outbox.SNSPublisher{Client: sns.New(a.Session), TopicARN: cfg.OutboxTopicARN})
+	case !cfg.WebhooksEnabled:
+		return nil, nil, errors.New("set " + config.EnvOutboxEventBus + ", " + config.EnvOutboxTopicARN + " or " + config.EnvWebhooksEnabled + " to publish the outbox")
+	}
+	return a, publishers, nil
+}
@@ -0,0 +1,61 @@
+// Command projector keeps the read models in pkg/projection up to date.
+// Deployed as its own Lambda on the users table's stream, with both new and
+// old images and batch item failures reported, it applies each change in
+// order; run anywhere else it rebuilds the read models from the table once
+// and prints the result.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/projection"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/stream"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func main() {
+	cfg := config.Load()
+	a, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	projection.Configure(projection.Config{RecentSize: cfg.ProjectionRecentSize})
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event events.DynamoDBEvent) (stream.Response, error) {
+			return apply(event, a.TableName, a.Client), nil
+		})
+		return
+	}
+
+	table := flag.String("table", a.TableName, "users table whose projections to rebuild")
+	flag.Parse()
+	result, err := projection.Rebuild(*table, a.Client)
+	if result != nil {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func apply(event events.DynamoDBEvent, table string, client dynamodbiface.DynamoDBAPI) stream.Response {
+	for _, record := range event.Records {
+		if err := projection.Apply(projection.FromStreamRecord(record), table, client); err != nil {
+			fmt.Fprintln(os.Stderr, record.EventID, err)
+			return stream.Failed(record)
+		}
+	}
+	return stream.Done()
+}
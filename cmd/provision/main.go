@@ -0,0 +1,71 @@
+// Command provision runs the steps of the user onboarding workflow in
+// pkg/provision. Deployed as its own Lambda, each task state of the state
+// machine invokes it with {"step": ..., "user": ...} and a failed step is
+// reported under its error name, for the state's Retry and Catch clauses;
+// run anywhere else it runs every step in order for the user read from
+// stdin and prints the final state.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/provision"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+func main() {
+	// The App validates provisioned users as the API validates those it
+	// creates
+	cfg := config.Load()
+	a, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.ProvisionEmailSender != "" {
+		provision.Configure(provision.Config{SES: ses.New(a.Session), Sender: cfg.ProvisionEmailSender})
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, input provision.Input) (*provision.State, error) {
+			state, err := provision.Run(input, a.TableName, a.Client)
+			return state, taskError(err)
+		})
+		return
+	}
+
+	state := provision.State{}
+	if err := json.NewDecoder(os.Stdin).Decode(&state.User); err != nil {
+		fmt.Fprintln(os.Stderr, errors.New(user.ErrorInvalidUserData))
+		os.Exit(1)
+	}
+	for _, step := range provision.Steps {
+		next, err := provision.Run(provision.Input{Step: step, State: state}, a.TableName, a.Client)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, step, err)
+			os.Exit(1)
+		}
+		state = *next
+	}
+	out, _ := json.MarshalIndent(state, "", "  ")
+	fmt.Println(string(out))
+}
+
+// taskError reports a failed step with its name as the error type, which is
+// what Step Functions matches ErrorEquals against.
+func taskError(err error) error {
+	var stepErr *provision.StepError
+	if errors.As(err, &stepErr) {
+		return messages.InvokeResponse_Error{Message: stepErr.Message, Type: stepErr.Name}
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+// Command s3import imports users from the CSV and JSON Lines objects that
+// land under IMPORT_PREFIX of an import bucket, with pkg/s3import. Deployed
+// as its own Lambda on the bucket's object created events, it imports each
+// object the event names and writes its manifest next to it; run anywhere
+// else it imports the one object named by its flags and prints the
+// manifest.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/s3import"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func main() {
+	// The App validates imported users as the API validates those it
+	// creates
+	cfg := config.Load()
+	a, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Objects are read from whichever bucket the event names
+	s3import.Configure(s3import.Config{S3: s3.New(a.Session), Prefix: cfg.ImportPrefix})
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event events.S3Event) ([]s3import.Manifest, error) {
+			return s3import.Handle(event, a.TableName, a.Client)
+		})
+		return
+	}
+
+	bucket := flag.String("bucket", os.Getenv(config.EnvImportBucket), "bucket holding the object to import")
+	key := flag.String("key", "", "key of the .csv or .jsonl object to import")
+	table := flag.String("table", a.TableName, "users table to import into")
+	flag.Parse()
+	format, ok := s3import.Format(*key)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "key must be a .csv or .jsonl object under the import prefix")
+		os.Exit(1)
+	}
+	manifest, err := s3import.Import(*bucket, *key, format, *table, a.Client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(manifest, "", "  ")
+	fmt.Println(string(out))
+}
@@ -0,0 +1,106 @@
+// Command searchindexer keeps the OpenSearch index of users at
+// SEARCH_ENDPOINT up to date with pkg/search. Deployed as its own Lambda on
+// the users table's stream, with both new and old images and batch item
+// failures reported, it indexes each change in order; run anywhere else it
+// creates or updates the index's mapping and reindexes every user in the
+// table once, printing the result.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/stream"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// ensured is set once the index's mapping is up to date, which is checked
+// on the first invocation of each Lambda instance.
+var ensured bool
+
+func main() {
+	a, err := newApp()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(func(ctx context.Context, event events.DynamoDBEvent) (stream.Response, error) {
+			return index(event), nil
+		})
+		return
+	}
+
+	table := flag.String("table", a.TableName, "users table to reindex")
+	flag.Parse()
+	if err := search.EnsureIndex(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	result, err := search.Reindex(*table, a.Client)
+	if result != nil {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func index(event events.DynamoDBEvent) stream.Response {
+	failed := func(record events.DynamoDBEventRecord, err error) stream.Response {
+		fmt.Fprintln(os.Stderr, record.EventID, err)
+		return stream.Failed(record)
+	}
+	if len(event.Records) == 0 {
+		return stream.Done()
+	}
+	if !ensured {
+		if err := search.EnsureIndex(); err != nil {
+			return failed(event.Records[0], err)
+		}
+		ensured = true
+	}
+
+	actions := []search.Action{}
+	records := []events.DynamoDBEventRecord{}
+	for _, record := range event.Records {
+		action, ok, err := search.FromStreamRecord(record)
+		if err != nil {
+			// Changes read before this one are indexed first
+			n, indexErr := search.Index(actions)
+			if indexErr != nil {
+				return failed(records[n], indexErr)
+			}
+			return failed(record, err)
+		}
+		if ok {
+			actions = append(actions, action)
+			records = append(records, record)
+		}
+	}
+	if n, err := search.Index(actions); err != nil {
+		return failed(records[n], err)
+	}
+	return stream.Done()
+}
+
+// newApp returns the App, which configures the search index from
+// SEARCH_ENDPOINT.
+func newApp() (*app.App, error) {
+	cfg := config.Load()
+	if cfg.SearchEndpoint == "" {
+		return nil, errors.New("set " + config.EnvSearchEndpoint + " to index users")
+	}
+	return app.New(cfg)
+}
@@ -0,0 +1,41 @@
+// Command seed loads the fixture users of pkg/seed into a users table, for
+// test environments. The same users can be loaded by invoking the API Lambda
+// with a seed event when SEED_ENABLED is set.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seed"
+)
+
+func main() {
+	cfg := config.Load()
+	var options seed.Options
+	table := flag.String("table", cfg.TableName, "users table to seed")
+	flag.IntVar(&options.Count, "count", seed.DefaultCount, "number of fixture users to load")
+	flag.BoolVar(&options.SkipExisting, "skip-existing", false, "leave fixture users that already exist instead of resetting them")
+	flag.Parse()
+
+	a, err := app.New(cfg, app.WithTableName(*table), app.WithOutput(os.Stderr))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	report, err := seed.Load(options, a.TableName, a.Client)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
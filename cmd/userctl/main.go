@@ -0,0 +1,72 @@
+// Command userctl manages users from a terminal through pkg/user, against any
+// users table in any region, for operators who would rather not go through
+// API Gateway.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/app"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/spf13/cobra"
+)
+
+// globals are the flags every command takes.
+type globals struct {
+	table    string
+	region   string
+	endpoint string
+	output   string
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	g := &globals{}
+	root := &cobra.Command{
+		Use:          "userctl",
+		Short:        "Manage the users of a LambdaInGo users table",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if g.output != outputJSON && g.output != outputTable {
+				return fmt.Errorf("unknown output format %q, expected %s or %s", g.output, outputJSON, outputTable)
+			}
+			return nil
+		},
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&g.table, "table", config.Load().TableName, "users table")
+	flags.StringVar(&g.region, "region", os.Getenv("AWS_REGION"), "AWS region of the table")
+	flags.StringVar(&g.endpoint, "endpoint", "", "DynamoDB endpoint, e.g. http://localhost:8000 for DynamoDB Local")
+	flags.StringVarP(&g.output, "output", "o", outputTable, "output format, json or table")
+
+	root.AddCommand(
+		newGetCommand(g),
+		newListCommand(g),
+		newCreateCommand(g),
+		newUpdateCommand(g),
+		newDeleteCommand(g),
+		newSeedCommand(g),
+	)
+	return root
+}
+
+// client connects to DynamoDB and sets the packages up through pkg/app as
+// the Lambda does, so calls are retried and writes are validated, event
+// sourced and sent through the outbox as they are there.
+// Logs and metrics go to standard error, leaving standard output to what
+// the command prints.
+func (g *globals) client() (dynamodbiface.DynamoDBAPI, error) {
+	application, err := app.New(config.Load(), app.WithTableName(g.table), app.WithRegion(g.region), app.WithEndpoint(g.endpoint), app.WithOutput(os.Stderr))
+	if err != nil {
+		return nil, err
+	}
+	return application.Client, nil
+}
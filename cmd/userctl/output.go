@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+)
+
+const (
+	outputJSON  = "json"
+	outputTable = "table"
+)
+
+// write prints users as a table, or value, which is what the API would have
+// answered, as indented JSON.
+func write(w io.Writer, format string, users []user.User, value interface{}) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EMAIL\tFIRST NAME\tLAST NAME\tSTATUS\tTAGS\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", u.Email, u.FirstName, u.LastName, dash(u.Status), dash(strings.Join(u.Tags, ",")), dash(u.CreatedAt))
+	}
+	return tw.Flush()
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// describe spells out each invalid field of a validation error, which
+// otherwise reads as a bare count.
+func describe(err error) error {
+	var fieldErrs validators.FieldErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+	lines := []string{user.ErrorInvalidUserData}
+	for _, fieldErr := range fieldErrs {
+		lines = append(lines, fmt.Sprintf("  %s: %s", fieldErr.Field, fieldErr.Message))
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
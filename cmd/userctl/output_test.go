@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/spf13/cobra"
+)
+
+func TestWrite(t *testing.T) {
+	users := []user.User{{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver", Tags: []string{"beta", "plan:pro"}}}
+
+	t.Run("expect a row per user under a header", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := write(&out, outputTable, users, users); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "EMAIL") {
+			t.Fatalf("expected a header and one row, got %q", out.String())
+		}
+		if fields := strings.Fields(lines[1]); len(fields) != 6 || fields[4] != "beta,plan:pro" || fields[5] != "-" {
+			t.Errorf("expected the tags and a dash for the missing createdAt, got %q", lines[1])
+		}
+	})
+
+	t.Run("expect json to print the value", func(t *testing.T) {
+		var out bytes.Buffer
+		write(&out, outputJSON, users, &users[0])
+		if !strings.HasPrefix(out.String(), "{\n  \"email\": \"alan.oliver@ecs.co.uk\"") {
+			t.Errorf("expected the user as indented JSON, got %q", out.String())
+		}
+	})
+}
+
+func TestDescribe(t *testing.T) {
+	err := describe(validators.FieldErrors{{Field: "firstName", Message: "is required"}})
+	if err.Error() != user.ErrorInvalidUserData+"\n  firstName: is required" {
+		t.Errorf("expected each field to be listed, got %q", err.Error())
+	}
+	if err := describe(errors.New(user.ErrorUserAlreadyExists)); err.Error() != user.ErrorUserAlreadyExists {
+		t.Errorf("expected other errors to be left alone, got %q", err.Error())
+	}
+}
+
+func TestProfileFlags(t *testing.T) {
+	t.Run("expect only the flags given to be set, over the document", func(t *testing.T) {
+		p := &profileFlags{}
+		cmd := &cobra.Command{Use: "update", RunE: func(*cobra.Command, []string) error { return nil }}
+		p.bind(cmd, false)
+		cmd.SetIn(strings.NewReader(`{"company": "ECS", "jobTitle": "Engineer"}`))
+		cmd.SetArgs([]string{"--file", "-", "--company", "Acme", "--tags", "beta,trial"})
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		body := map[string]interface{}{"firstName": "Alan"}
+		if err := p.apply(cmd, body); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if body["company"] != "Acme" || body["jobTitle"] != "Engineer" || body["firstName"] != "Alan" {
+			t.Errorf("expected the flag to override the document, got %v", body)
+		}
+		if _, ok := body["phone"]; ok {
+			t.Errorf("expected flags not given to be left out, got %v", body)
+		}
+		if tags, _ := body["tags"].([]string); len(tags) != 2 {
+			t.Errorf("expected two tags, got %v", body["tags"])
+		}
+	})
+}
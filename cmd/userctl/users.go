@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seed"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/spf13/cobra"
+)
+
+func newGetCommand(g *globals) *cobra.Command {
+	var consistent bool
+	cmd := &cobra.Command{
+		Use:   "get EMAIL",
+		Short: "Show a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			u, err := user.FetchUserWithOptions(args[0], g.table, client, user.FetchOptions{ConsistentRead: consistent})
+			if err != nil {
+				return err
+			}
+			if u.Email == "" {
+				return errors.New(user.ErrorUserNotFound)
+			}
+			return write(cmd.OutOrStdout(), g.output, []user.User{*u}, u)
+		},
+	}
+	cmd.Flags().BoolVar(&consistent, "consistent", false, "read the user with a strongly consistent read")
+	return cmd
+}
+
+func newListCommand(g *globals) *cobra.Command {
+	var options user.FetchOptions
+	var inactiveDays int
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if inactiveDays < 0 {
+				return errors.New(user.ErrorInvalidInactiveDays)
+			}
+			if inactiveDays > 0 {
+				options.InactiveSince = time.Now().AddDate(0, 0, -inactiveDays)
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			result, err := user.ScanUsersWithOptions(g.table, client, options)
+			if err != nil {
+				return err
+			}
+			if result.Truncated {
+				fmt.Fprintln(cmd.ErrOrStderr(), "warning: the scan stopped at a limit, so not every user is listed")
+			}
+			return write(cmd.OutOrStdout(), g.output, result.Users, result.Users)
+		},
+	}
+	cmd.Flags().StringVar(&options.Tag, "tag", "", "only list users carrying the tag")
+	cmd.Flags().IntVar(&inactiveDays, "inactive-days", 0, "only list users not seen for at least this many days")
+	return cmd
+}
+
+func newCreateCommand(g *globals) *cobra.Command {
+	profile := &profileFlags{}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user from flags or a JSON document",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			body := map[string]interface{}{}
+			if err := profile.apply(cmd, body); err != nil {
+				return err
+			}
+			var u user.User
+			if err := remarshal(body, &u); err != nil {
+				return errors.New(user.ErrorInvalidUserData)
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			created, err := user.Create(u, g.table, client)
+			if err != nil {
+				return describe(err)
+			}
+			return write(cmd.OutOrStdout(), g.output, []user.User{*created}, created)
+		},
+	}
+	profile.bind(cmd, true)
+	return cmd
+}
+
+func newUpdateCommand(g *globals) *cobra.Command {
+	profile := &profileFlags{}
+	cmd := &cobra.Command{
+		Use:   "update EMAIL",
+		Short: "Update a user, keeping the fields that are not given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			existing, err := user.FetchUserWithOptions(args[0], g.table, client, user.FetchOptions{ConsistentRead: true})
+			if err != nil {
+				return err
+			}
+			if existing.Email == "" {
+				return errors.New(user.ErrorUserNotFound)
+			}
+			body := map[string]interface{}{
+				"firstName": existing.FirstName,
+				"lastName":  existing.LastName,
+			}
+			if err := profile.apply(cmd, body); err != nil {
+				return err
+			}
+			body["email"] = existing.Email
+			encoded, _ := json.Marshal(body)
+			updated, err := user.UpdateUser(events.APIGatewayProxyRequest{Body: string(encoded)}, g.table, client)
+			if err != nil {
+				return describe(err)
+			}
+			return write(cmd.OutOrStdout(), g.output, []user.User{*updated}, updated)
+		},
+	}
+	profile.bind(cmd, false)
+	return cmd
+}
+
+func newDeleteCommand(g *globals) *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "delete EMAIL",
+		Short: "Delete a user with their preferences, credentials and sessions",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !yes {
+				return errors.New("refusing to delete without --yes")
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			existing, err := user.FetchUserWithOptions(args[0], g.table, client, user.FetchOptions{ConsistentRead: true})
+			if err != nil {
+				return err
+			}
+			if existing.Email == "" {
+				return errors.New(user.ErrorUserNotFound)
+			}
+			req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"email": existing.Email}}
+			if err := user.DeleteUser(req, g.table, client); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "deleted %s\n", existing.Email)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&yes, "yes", false, "confirm the deletion")
+	return cmd
+}
+
+// profileFlags are the user fields create and update take as flags. Only
+// the flags given are sent, so update keeps the stored value of the rest.
+type profileFlags struct {
+	file   string
+	fields map[string]*string
+	tags   []string
+}
+
+// profileFields maps each flag to the JSON field of user.User it sets.
+var profileFields = []struct{ flag, field, usage string }{
+	{"email", "email", "email address"},
+	{"first-name", "firstName", "first name"},
+	{"last-name", "lastName", "last name"},
+	{"phone", "phone", "international phone number"},
+	{"date-of-birth", "dateOfBirth", "date of birth, YYYY-MM-DD"},
+	{"job-title", "jobTitle", "job title"},
+	{"company", "company", "company"},
+}
+
+func (p *profileFlags) bind(cmd *cobra.Command, withEmail bool) {
+	p.fields = map[string]*string{}
+	for _, f := range profileFields {
+		if f.field == "email" && !withEmail {
+			continue
+		}
+		p.fields[f.flag] = cmd.Flags().String(f.flag, "", f.usage)
+	}
+	cmd.Flags().StringSliceVar(&p.tags, "tags", nil, "comma separated tags")
+	cmd.Flags().StringVarP(&p.file, "file", "f", "", "JSON document of user fields, - for stdin; flags override its fields")
+}
+
+// apply sets the fields of the JSON document and then of the flags given on
+// body.
+func (p *profileFlags) apply(cmd *cobra.Command, body map[string]interface{}) error {
+	if p.file != "" {
+		var r io.Reader = cmd.InOrStdin()
+		if p.file != "-" {
+			f, err := os.Open(p.file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := json.NewDecoder(r).Decode(&body); err != nil {
+			return errors.New(user.ErrorInvalidUserData)
+		}
+	}
+	for _, f := range profileFields {
+		if value, ok := p.fields[f.flag]; ok && cmd.Flags().Changed(f.flag) {
+			body[f.field] = *value
+		}
+	}
+	if cmd.Flags().Changed("tags") {
+		body["tags"] = p.tags
+	}
+	return nil
+}
+
+func remarshal(from interface{}, to interface{}) error {
+	encoded, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, to)
+}
+
+func newSeedCommand(g *globals) *cobra.Command {
+	var options seed.Options
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Load the fixture users of a test environment",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			report, err := seed.Load(options, g.table, client)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "created %d, skipped %d, failed %d\n", report.Created, report.Skipped, len(report.Failed))
+			for email, reason := range report.Failed {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %s\n", email, reason)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&options.Count, "count", seed.DefaultCount, "number of fixture users to load")
+	cmd.Flags().BoolVar(&options.SkipExisting, "skip-existing", false, "leave fixture users that already exist instead of resetting them")
+	return cmd
+}
@@ -3,7 +3,21 @@ module github.com/alrobwilloliver/aws-lambda-in-golang
 go 1.19
 
 require (
+	github.com/99designs/gqlgen v0.17.31
+	github.com/aws/aws-dax-go v1.2.12
 	github.com/aws/aws-lambda-go v1.34.1
-	github.com/aws/aws-sdk-go v1.44.93
+	github.com/aws/aws-sdk-go v1.44.171
+	github.com/spf13/cobra v1.8.0
+	github.com/vektah/gqlparser/v2 v2.5.1
+	golang.org/x/crypto v0.14.0
+)
+
+require (
+	github.com/agnivade/levenshtein v1.1.1 // indirect
+	github.com/antlr/antlr4 v0.0.0-20181218183524-be58ebffde8e // indirect
+	github.com/gofrs/uuid v3.3.0+incompatible // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
 )
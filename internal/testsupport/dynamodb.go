@@ -0,0 +1,125 @@
+// Package testsupport runs tests against DynamoDB Local, to catch what the
+// in-memory mocks cannot, such as attributes that do not marshal the way an
+// expression expects. Tests using it carry the integration build tag:
+//
+//	go test -tags integration ./...
+//
+// DYNAMODB_ENDPOINT points the tests at a running DynamoDB Local. Otherwise
+// one is started with Docker, and the tests are skipped when Docker is not
+// available.
+package testsupport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// EnvEndpoint points the tests at a DynamoDB Local that is already running
+	EnvEndpoint = "DYNAMODB_ENDPOINT"
+	// Image is the DynamoDB Local image started when EnvEndpoint is unset
+	Image = "amazon/dynamodb-local"
+)
+
+var (
+	startOnce sync.Once
+	endpoint  string
+	container string
+	startErr  error
+)
+
+// Run runs the tests of a package and stops the DynamoDB Local they started,
+// for use from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testsupport.Run(m))
+//	}
+func Run(m *testing.M) int {
+	code := m.Run()
+	if container != "" {
+		exec.Command("docker", "stop", container).Run()
+	}
+	return code
+}
+
+// Client returns a client of DynamoDB Local, starting it on first use. The
+// test is skipped when DynamoDB Local cannot be reached or started.
+func Client(t testing.TB) dynamodbiface.DynamoDBAPI {
+	t.Helper()
+	startOnce.Do(start)
+	if startErr != nil {
+		t.Skipf("DynamoDB Local is not available: %s", startErr)
+	}
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:   aws.String("eu-west-2"),
+		Endpoint: aws.String(endpoint),
+		// DynamoDB Local accepts any credentials
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+	return dynamodb.New(awsSession)
+}
+
+func start() {
+	if endpoint = os.Getenv(EnvEndpoint); endpoint != "" {
+		return
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		startErr = fmt.Errorf("%s is unset and docker was not found", EnvEndpoint)
+		return
+	}
+	out, err := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::8000", Image, "-jar", "DynamoDBLocal.jar", "-inMemory").Output()
+	if err != nil {
+		startErr = fmt.Errorf("failed to start %s: %w", Image, err)
+		return
+	}
+	container = strings.TrimSpace(string(out))
+	out, err = exec.Command("docker", "port", container, "8000").Output()
+	if err != nil {
+		startErr = fmt.Errorf("failed to find the port of %s: %w", Image, err)
+		return
+	}
+	// docker port prints a line per address, e.g. 127.0.0.1:49153
+	port := strings.TrimSpace(string(bytes.SplitN(out, []byte("\n"), 2)[0]))
+	endpoint = "http://" + port
+	startErr = waitReady()
+}
+
+// waitReady waits for the container to answer, which takes a few seconds
+// after it starts.
+func waitReady() error {
+	awsSession, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-2"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})
+	if err != nil {
+		return err
+	}
+	client := dynamodb.New(awsSession)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		_, err := client.ListTables(&dynamodb.ListTablesInput{})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("DynamoDB Local did not answer: %w", err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
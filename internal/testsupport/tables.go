@@ -0,0 +1,34 @@
+package testsupport
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/bootstrap"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var tableCount int64
+
+// CreateTables creates a users table and its accompanying tables under a
+// name no other test uses, and deletes them when the test ends. It returns
+// the name of the users table.
+func CreateTables(t testing.TB, client dynamodbiface.DynamoDBAPI) string {
+	t.Helper()
+	name := fmt.Sprintf("Test%d%d", time.Now().UnixNano(), atomic.AddInt64(&tableCount, 1))
+	for _, table := range bootstrap.Tables(name) {
+		table := table
+		t.Cleanup(func() {
+			client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(table.Name)})
+		})
+	}
+	if _, err := bootstrap.Ensure(name, client); err != nil {
+		t.Fatalf("failed to create tables: %s", err)
+	}
+	return name
+}
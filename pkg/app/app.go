@@ -0,0 +1,575 @@
+// Package app wires the function together. An App owns what serving needs
+// across invocations, the DynamoDB client, logger, retry and deadline
+// tracking, and the middleware every API request passes through, and
+// configures the packages it serves with from the configuration it is
+// created with. Its methods are the Lambda's handlers, so a command's main
+// only loads the configuration and starts the App.
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/backup"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/command"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/flags"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/handlers"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/invitation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/logger"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/s3import"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seed"
+	usersession "github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/tenant"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/warmup"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/appconfigdata"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// DefaultTableName is the users table an App serves when its configuration
+// names none.
+const DefaultTableName = "LambdaInGoUser"
+
+// Handler answers an API Gateway request.
+type Handler func(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error)
+
+// Middleware wraps a Handler, to act before or after it or in its place.
+type Middleware func(Handler) Handler
+
+// App serves the function's invocations.
+type App struct {
+	Config    config.Config
+	TableName string
+	Client    dynamodbiface.DynamoDBAPI
+	Log       *logger.Logger
+	Sampler   *logger.Sampler
+	Retryer   *store.Retryer
+	Deadlines *store.Deadlines
+	// Session is the AWS session the App's clients are made from, for
+	// commands that need clients of their own
+	Session *session.Session
+
+	region     string
+	endpoint   string
+	output     io.Writer
+	unsigned   bool
+	middleware []Middleware
+	validators []user.Validator
+	handler    Handler
+}
+
+// Option sets up an App differently from its configuration.
+type Option func(*App)
+
+// WithTableName serves name rather than the table the configuration names.
+func WithTableName(name string) Option {
+	return func(a *App) {
+		a.TableName = name
+	}
+}
+
+// WithRegion reaches AWS in region rather than the one in AWS_REGION.
+func WithRegion(region string) Option {
+	return func(a *App) {
+		a.region = region
+	}
+}
+
+// WithEndpoint reaches DynamoDB at endpoint, such as DynamoDB Local, rather
+// than at the region's endpoint. Other services are reached as usual.
+func WithEndpoint(endpoint string) Option {
+	return func(a *App) {
+		a.endpoint = endpoint
+	}
+}
+
+// WithClient serves through client rather than a DynamoDB client built from
+// the configuration.
+func WithClient(client dynamodbiface.DynamoDBAPI) Option {
+	return func(a *App) {
+		a.Client = client
+	}
+}
+
+// WithUnsignedSearch sends search requests unsigned, as a local OpenSearch
+// takes them.
+func WithUnsignedSearch() Option {
+	return func(a *App) {
+		a.unsigned = true
+	}
+}
+
+// WithOutput writes logs and metrics to w rather than standard output.
+func WithOutput(w io.Writer) Option {
+	return func(a *App) {
+		a.output = w
+	}
+}
+
+// WithMiddleware passes every API request through middleware, the first
+// outermost, ahead of the App's own request logging.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(a *App) {
+		a.middleware = append(a.middleware, middleware...)
+	}
+}
+
+// WithValidators checks users with validators as they are created and
+// updated, after those the configuration sets up.
+func WithValidators(validators ...user.Validator) Option {
+	return func(a *App) {
+		a.validators = append(a.validators, validators...)
+	}
+}
+
+// New returns an App serving with cfg, configuring the packages it serves
+// with as it goes. Those packages keep their configuration in package
+// variables, so only one App should be created in a process.
+func New(cfg config.Config, options ...Option) (*App, error) {
+	a := &App{Config: cfg, TableName: cfg.TableName, region: os.Getenv("AWS_REGION"), output: os.Stdout}
+	if a.TableName == "" {
+		a.TableName = DefaultTableName
+	}
+	for _, option := range options {
+		option(a)
+	}
+	a.Log = logger.New(a.output, logger.NewSanitizer(cfg.LogRedactFields))
+	a.Sampler = logger.NewSampler(cfg.LogBodySampleRate, cfg.LogDebugHeader)
+	a.Retryer = store.NewRetryer(store.RetryConfig{
+		MaxAttempts:   cfg.DynamoMaxAttempts,
+		BaseDelay:     cfg.DynamoRetryBaseDelay,
+		MaxDelay:      cfg.DynamoRetryMaxDelay,
+		Jitter:        cfg.DynamoRetryJitter,
+		UntilDeadline: cfg.DynamoRetryThrottles,
+	})
+	a.Deadlines = store.NewDeadlines(store.TimeoutConfig{
+		OperationTimeout: cfg.DynamoTimeout,
+		Margin:           cfg.DeadlineMargin,
+	})
+
+	awsSession, err := session.NewSession(&aws.Config{
+		Region: aws.String(a.region),
+	})
+	if err != nil {
+		a.Log.Error("failed to create aws session", err, nil)
+		return nil, err
+	}
+	a.Session = awsSession
+	if a.Client == nil {
+		if a.Client, err = a.newClient(awsSession); err != nil {
+			a.Log.Error("failed to create dynamodb client", err, nil)
+			return nil, err
+		}
+	}
+	if err := a.configure(awsSession); err != nil {
+		return nil, err
+	}
+
+	a.handler = a.logRequests(a.route)
+	for i := len(a.middleware) - 1; i >= 0; i-- {
+		a.handler = a.middleware[i](a.handler)
+	}
+	return a, nil
+}
+
+func (a *App) newClient(awsSession *session.Session) (dynamodbiface.DynamoDBAPI, error) {
+	cfg := a.Config
+	storeConfig := store.Config{
+		DAXEndpoint: cfg.DAXEndpoint,
+		Retryer:     a.Retryer,
+		Deadlines:   a.Deadlines,
+		Metrics:     cfg.MetricsEnabled,
+		Transport: &store.TransportConfig{
+			MaxIdleConns:        cfg.HTTPMaxIdleConns,
+			MaxIdleConnsPerHost: cfg.HTTPMaxIdleConnsPerHost,
+			IdleConnTimeout:     cfg.HTTPIdleConnTimeout,
+			TLSHandshakeTimeout: cfg.HTTPTLSHandshakeTimeout,
+			KeepAlive:           cfg.HTTPKeepAlive,
+		},
+	}
+	if cfg.CircuitFailureRate > 0 {
+		storeConfig.Breaker = store.NewBreaker(store.BreakerConfig{
+			FailureRate:      cfg.CircuitFailureRate,
+			MinRequests:      cfg.CircuitMinRequests,
+			Window:           cfg.CircuitWindow,
+			OpenTimeout:      cfg.CircuitOpenTimeout,
+			HalfOpenRequests: cfg.CircuitHalfOpenCalls,
+		})
+	}
+	if a.endpoint != "" {
+		awsSession = awsSession.Copy(&aws.Config{Endpoint: aws.String(a.endpoint)})
+	}
+	return store.New(awsSession, storeConfig)
+}
+
+// configure configures the packages the App serves with.
+func (a *App) configure(awsSession *session.Session) error {
+	cfg := a.Config
+	validators.DisposableEmailDomains.Add(cfg.DisposableEmailDomains...)
+	validation := user.ValidationConfig{
+		RejectDisposableEmails: cfg.BlockDisposableEmails,
+	}
+	if cfg.VerifyEmailMX {
+		validation.MXVerifier = validators.NewMXVerifier(nil, cfg.MXLookupTimeout, cfg.MXCacheTTL)
+	}
+	validation.Validators = append(Validators(cfg), a.validators...)
+	user.ConfigureValidation(validation)
+	schema.Configure(schema.Config{Strict: cfg.SchemaStrict, CacheTTL: cfg.SchemaCacheTTL})
+	user.ConfigureScan(user.ScanConfig{
+		TotalSegments: cfg.ScanSegments,
+		Concurrency:   cfg.ScanConcurrency,
+		MaxItems:      cfg.ScanMaxItems,
+		MaxDuration:   cfg.ScanTimeBudget,
+	})
+	store.ConfigureBackground(store.BackgroundConfig{ReadCapacity: cfg.BackgroundScanRCU})
+	user.ConfigureEventSourcing(user.EventSourcingConfig{Enabled: cfg.UserEventSourcing})
+	outbox.Configure(outbox.Config{Enabled: cfg.OutboxEnabled})
+	user.ConfigureCache(user.CacheConfig{
+		Size: cfg.UserCacheSize,
+		TTL:  cfg.UserCacheTTL,
+	})
+	invitation.Configure(invitation.Config{TTL: cfg.InvitationTTL})
+	usersession.Configure(usersession.Config{TTL: cfg.SessionTTL, Table: []store.TableOption{
+		store.WithIndex(usersession.EmailIndex, cfg.SessionEmailIndex),
+		store.WithTimeout(cfg.SessionTimeout),
+	}})
+	handlers.ConfigureAdmin(handlers.AdminConfig{Key: cfg.AdminKey})
+	tenants := tenant.Config{
+		Header:        cfg.TenantHeader,
+		Tables:        cfg.TenantTables,
+		ParameterPath: cfg.TenantParameterPath,
+		CacheTTL:      cfg.TenantCacheTTL,
+	}
+	if cfg.TenantParameterPath != "" {
+		tenants.SSM = ssm.New(awsSession)
+	}
+	tenant.Configure(tenants)
+	if cfg.AppConfigFlagsProfile != "" {
+		flags.Configure(flags.Config{
+			Application:  cfg.AppConfigApplication,
+			Environment:  cfg.AppConfigEnvironment,
+			Profile:      cfg.AppConfigFlagsProfile,
+			PollInterval: cfg.AppConfigPollInterval,
+			Client:       appconfigdata.New(awsSession),
+		})
+	}
+	handlers.ConfigureResponses(handlers.ResponseConfig{
+		Headers:         cfg.SecurityHeaders,
+		Problems:        cfg.ProblemDetails,
+		ProblemTypeBase: cfg.ProblemTypeBase,
+		RetryAfter:      cfg.DynamoThrottleRetryAfter,
+	})
+	oauth.Configure(oauth.Config{
+		JWKSURL:          cfg.OAuthJWKSURL,
+		IntrospectionURL: cfg.OAuthIntrospectionURL,
+		Issuer:           cfg.OAuthIssuer,
+		Audience:         cfg.OAuthAudience,
+		ClientID:         cfg.OAuthClientID,
+		ClientSecret:     cfg.OAuthClientSecret,
+		CacheTTL:         cfg.OAuthCacheTTL,
+		Timeout:          cfg.OAuthTimeout,
+	})
+	oidc.Configure(oidc.Config{
+		JWKSURL:  cfg.OIDCJWKSURL,
+		Issuer:   cfg.OIDCIssuer,
+		ClientID: cfg.OIDCClientID,
+		Header:   cfg.OIDCHeader,
+	})
+	quota.Configure(quota.Config{
+		KeyWrites:   cfg.QuotaKeyWrites,
+		EmailWrites: cfg.QuotaEmailWrites,
+		Window:      cfg.QuotaWindow,
+		KeyHeader:   cfg.QuotaKeyHeader,
+	})
+	handlers.ConfigureBodies(handlers.BodyConfig{MaxBytes: cfg.MaxBodySize})
+	handlers.ConfigureLimits(handlers.LimitConfig{Default: cfg.ListDefaultLimit, Max: cfg.ListMaxLimit})
+	handlers.ConfigureGraphQL(handlers.GraphQLConfig{Enabled: cfg.GraphQLEnabled})
+	deprecated := make(map[string]handlers.Deprecation, len(cfg.Deprecations))
+	for key, d := range cfg.Deprecations {
+		deprecated[key] = handlers.Deprecation(d)
+	}
+	handlers.ConfigureDeprecations(handlers.DeprecationConfig{Deprecations: deprecated})
+	if cfg.MetricsEnabled {
+		metrics.Configure(metrics.Config{Namespace: cfg.MetricsNamespace, Writer: a.output})
+	}
+	handlers.ConfigureSLO(handlers.SLOConfig{LatencyObjective: cfg.SLOLatencyObjective})
+	if cfg.WebhooksEnabled && !cfg.OutboxEnabled {
+		err := errors.New("set " + config.EnvOutboxEnabled + " to deliver webhooks")
+		a.Log.Error("webhooks need the outbox", err, nil)
+		return err
+	}
+	webhook.Configure(webhook.Config{
+		Enabled:     cfg.WebhooksEnabled,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		RetryDelay:  cfg.WebhookRetryDelay,
+		Timeout:     cfg.WebhookTimeout,
+		LogTTL:      cfg.WebhookLogTTL,
+	})
+	backup.Configure(backup.Config{
+		PollTimeout:  cfg.BackupPollTimeout,
+		PollInterval: cfg.BackupPollInterval,
+	})
+	if cfg.MFAEncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.MFAEncryptionKey)
+		if err != nil {
+			a.Log.Error("invalid mfa encryption key", err, nil)
+			return err
+		}
+		credentials.ConfigureMFA(credentials.MFAConfig{Key: key, Issuer: cfg.MFAIssuer})
+	}
+	if cfg.CursorKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.CursorKey)
+		if err != nil {
+			a.Log.Error("invalid cursor signing key", err, nil)
+			return err
+		}
+		cursor.Configure(cursor.Config{Key: key})
+	}
+	if cfg.ResetEmailSender != "" {
+		credentials.ConfigureReset(credentials.ResetConfig{
+			SES:    ses.New(awsSession),
+			Sender: cfg.ResetEmailSender,
+			URL:    cfg.ResetURL,
+			TTL:    cfg.ResetTokenTTL,
+		})
+	}
+	if cfg.EmailChangeKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.EmailChangeKey)
+		if err != nil {
+			a.Log.Error("invalid email change signing key", err, nil)
+			return err
+		}
+		user.ConfigureEmailChange(user.EmailChangeConfig{
+			Key:    key,
+			SES:    ses.New(awsSession),
+			Sender: cfg.EmailChangeSender,
+			URL:    cfg.EmailChangeURL,
+			TTL:    cfg.EmailChangeTTL,
+		})
+	}
+	if cfg.ExportBucket != "" {
+		if cfg.ExportPartSize < user.MinExportPartSize {
+			err := errors.New("set " + config.EnvExportPartSize + " to at least 5 MiB, the smallest part S3 takes")
+			a.Log.Error("invalid export part size", err, nil)
+			return err
+		}
+		// Exports run in an asynchronous invocation of this function
+		user.ConfigureExports(user.ExportConfig{
+			Bucket:       cfg.ExportBucket,
+			S3:           s3.New(awsSession),
+			PartSize:     cfg.ExportPartSize,
+			Lambda:       lambda.New(awsSession),
+			FunctionName: lambdacontext.FunctionName,
+		})
+	}
+	if cfg.ImportBucket != "" {
+		// Imports of objects the API names run in an asynchronous invocation
+		// of this function too
+		s3import.Configure(s3import.Config{
+			S3:           s3.New(awsSession),
+			Prefix:       cfg.ImportPrefix,
+			Bucket:       cfg.ImportBucket,
+			Lambda:       lambda.New(awsSession),
+			FunctionName: lambdacontext.FunctionName,
+		})
+	}
+	search.ConfigureSuggestions(search.SuggestConfig{
+		Timeout:   cfg.SuggestTimeout,
+		CacheSize: cfg.SuggestCacheSize,
+		CacheTTL:  cfg.SuggestCacheTTL,
+	})
+	if cfg.SearchEndpoint != "" {
+		searches := search.Config{
+			Endpoint:    cfg.SearchEndpoint,
+			Index:       cfg.SearchIndex,
+			Table:       a.TableName,
+			Client:      &http.Client{Timeout: cfg.SearchTimeout},
+			Region:      a.region,
+			MaxAttempts: cfg.SearchMaxAttempts,
+			RetryDelay:  cfg.SearchRetryDelay,
+		}
+		if !a.unsigned {
+			searches.Signer = v4.NewSigner(awsSession.Config.Credentials)
+		}
+		search.Configure(searches)
+	}
+	if cfg.AvatarBucket != "" {
+		user.ConfigureAvatars(user.AvatarConfig{
+			Bucket:    cfg.AvatarBucket,
+			S3:        s3.New(awsSession),
+			MaxSize:   int64(cfg.AvatarMaxSize),
+			URLExpiry: cfg.AvatarURLExpiry,
+		})
+	}
+	return nil
+}
+
+// Validators returns the validators cfg sets users up to be checked by.
+func Validators(cfg config.Config) []user.Validator {
+	configured := []user.Validator{}
+	if len(cfg.AllowedEmailDomains) > 0 {
+		configured = append(configured, user.EmailDomains(cfg.AllowedEmailDomains...))
+	}
+	if len(cfg.BannedNames) > 0 {
+		configured = append(configured, user.BannedNames(cfg.BannedNames...))
+	}
+	return configured
+}
+
+// Invoke answers keep-alive pings, seed events, the export and import jobs
+// the function starts and commands from other Lambdas itself and passes
+// everything else on to Handle as an API Gateway request.
+func (a *App) Invoke(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	defer metrics.Flush()
+	if warmup.IsEvent(payload) {
+		if err := warmup.Touch(a.TableName, a.Client); err != nil {
+			a.Log.Error("warmup failed", err, nil)
+		}
+		return nil, nil
+	}
+	if options, ok := seed.ParseEvent(payload); ok {
+		// Seeding replaces users, so only test environments turn it on
+		if !a.Config.SeedEnabled {
+			return nil, errors.New(seed.ErrorSeedingDisabled)
+		}
+		report, err := seed.Load(options, a.TableName, a.Client)
+		if err != nil {
+			a.Log.Error("seed failed", err, nil)
+			return nil, err
+		}
+		a.Log.Info("seeded", logger.Fields{"created": report.Created, "skipped": report.Skipped, "failed": len(report.Failed)})
+		return report, nil
+	}
+	if request, ok := user.ParseExportEvent(payload); ok {
+		job, err := user.RunExport(request, a.Client)
+		if err != nil {
+			a.Log.Error("export failed", err, nil)
+			return nil, err
+		}
+		a.Log.Info("exported", logger.Fields{"id": job.ID, "status": job.Status, "error": job.Error})
+		return job, nil
+	}
+	if request, ok := s3import.ParseEvent(payload); ok {
+		manifest, err := s3import.Import(request.Bucket, request.Key, request.Format, request.Table, a.Client)
+		if err != nil {
+			a.Log.Error("import failed", err, logger.Fields{"key": request.Key})
+			return nil, err
+		}
+		a.Log.Info("imported", logger.Fields{"key": manifest.Key, "error": manifest.Error})
+		return manifest, nil
+	}
+	if cmd, ok := command.ParseEvent(payload); ok {
+		return a.Run(ctx, cmd), nil
+	}
+	var req events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+	return a.Handle(ctx, req)
+}
+
+// Run answers a command invoked directly by another Lambda. A failed
+// command is answered in its result rather than failing the invocation.
+func (a *App) Run(ctx context.Context, cmd command.Command) command.Result {
+	a.Deadlines.Bind(ctx)
+	result := command.Handle(cmd, a.TableName, a.Client)
+	a.logRetries()
+	a.Log.Info("command", logger.Fields{"op": cmd.Op, "code": result.Code})
+	return result
+}
+
+// Handle answers an API Gateway request through the App's middleware.
+func (a *App) Handle(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	a.Deadlines.Bind(ctx)
+	return a.handler(ctx, req)
+}
+
+func (a *App) route(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	resp, err := handlers.Route(req, a.TableName, a.Client)
+	a.logRetries()
+	return resp, err
+}
+
+// logRequests logs each request and, when it is sampled or failed, its
+// response, with their bodies when sampled.
+func (a *App) logRequests(next Handler) Handler {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+		sampled := a.Sampler.Sample(req.Headers)
+		fields := logger.Fields{
+			"method":  req.HTTPMethod,
+			"path":    req.Path,
+			"query":   queryFields(req.QueryStringParameters),
+			"sampled": sampled,
+		}
+		if sampled {
+			fields["body"] = a.Log.Sanitizer().Body(req.Body)
+		}
+		a.Log.Info("request", fields)
+		resp, err := next(ctx, req)
+		if err != nil {
+			a.Log.Error("request failed", err, nil)
+			return resp, err
+		}
+		// Error responses are always logged, sampled responses with their body
+		if resp != nil && (sampled || resp.StatusCode >= 400) {
+			fields := logger.Fields{"status": resp.StatusCode}
+			if sampled {
+				fields["body"] = a.Log.Sanitizer().Body(resp.Body)
+			}
+			msg := "response"
+			if resp.StatusCode >= 400 {
+				msg = "error response"
+			}
+			a.Log.Info(msg, fields)
+		}
+		return resp, nil
+	}
+}
+
+// logRetries logs the DynamoDB calls retried and throttled since it was last
+// called. Lambda runs one invocation per container at a time, so these are
+// the calls made for the invocation being served.
+func (a *App) logRetries() {
+	if retries := a.Retryer.TakeRetries(); retries > 0 {
+		a.Log.Info("dynamodb retries", logger.Fields{"retries": retries})
+	}
+	if throttles := a.Retryer.TakeThrottles(); throttles > 0 {
+		a.Log.Info("dynamodb throttles", logger.Fields{"throttles": throttles})
+	}
+}
+
+func queryFields(params map[string]string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		fields[key] = value
+	}
+	return fields
+}
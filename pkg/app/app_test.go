@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seed"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestApp(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-2")
+	newApp := func(t *testing.T, options ...Option) (*App, *testutil.MockDynamoDB, *bytes.Buffer) {
+		client := &testutil.MockDynamoDB{}
+		var out bytes.Buffer
+		a, err := New(config.Load(), append([]Option{WithClient(client), WithOutput(&out), WithTableName("test")}, options...)...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		return a, client, &out
+	}
+
+	t.Run("expect requests to pass through the middleware in order", func(t *testing.T) {
+		order := []string{}
+		named := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return func(ctx context.Context, req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+					order = append(order, name)
+					return next(ctx, req)
+				}
+			}
+		}
+		a, client, out := newApp(t, WithMiddleware(named("first"), named("second")))
+
+		resp, err := a.Handle(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if strings.Join(order, ",") != "first,second" {
+			t.Errorf("Expected the first middleware outermost, got %v", order)
+		}
+		if resp.StatusCode != http.StatusOK || *client.GetItemInputs()[0].TableName != "test" {
+			t.Errorf("Expected the user to be read from the App's table, got %d", resp.StatusCode)
+		}
+		if !strings.Contains(out.String(), `"msg":"request"`) {
+			t.Errorf("Expected the request to be logged, got %s", out.String())
+		}
+	})
+	t.Run("expect a keep-alive ping to touch the table", func(t *testing.T) {
+		a, client, _ := newApp(t)
+		if _, err := a.Invoke(context.Background(), json.RawMessage(`{"warmup": true}`)); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if client.Count("DescribeTable") != 1 {
+			t.Errorf("Expected the table to be described once, got %d", client.Count("DescribeTable"))
+		}
+	})
+	t.Run("expect seeding to be refused unless enabled", func(t *testing.T) {
+		a, client, _ := newApp(t)
+		a.Config.SeedEnabled = false
+		_, err := a.Invoke(context.Background(), json.RawMessage(`{"seed": {}}`))
+		if err == nil || err.Error() != seed.ErrorSeedingDisabled {
+			t.Fatalf("Expected error %s, got %v", seed.ErrorSeedingDisabled, err)
+		}
+		if len(client.Calls()) != 0 {
+			t.Errorf("Expected nothing to be written, got %d calls", len(client.Calls()))
+		}
+	})
+	t.Run("expect webhooks to be refused without the outbox", func(t *testing.T) {
+		cfg := config.Load()
+		cfg.WebhooksEnabled = true
+		cfg.OutboxEnabled = false
+		var out bytes.Buffer
+		if _, err := New(cfg, WithClient(&testutil.MockDynamoDB{}), WithOutput(&out)); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+	t.Run("expect the table the configuration names to be served", func(t *testing.T) {
+		cfg := config.Load()
+		cfg.TableName = "AcmeUser"
+		var out bytes.Buffer
+		a, err := New(cfg, WithClient(&testutil.MockDynamoDB{}), WithOutput(&out))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if a.TableName != "AcmeUser" {
+			t.Errorf("Expected table AcmeUser, got %s", a.TableName)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package audit
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+	ActionErased  = "erased"
+	ActionMerged  = "merged"
+)
+
+var (
+	ErrorFailedToDeleteEntry    = "failed to delete audit entry"
+	ErrorFailedToFetchEntries   = "failed to fetch audit entries"
+	ErrorFailedToMarshalEntry   = "failed to marshal audit entry"
+	ErrorFailedToUnmarshalEntry = "failed to unmarshal audit entry"
+	ErrorFailedToWriteEntry     = "failed to write audit entry"
+)
+
+// Kinds of actor a change can be made by.
+const (
+	ActorCognito = "cognito"
+	ActorAPIKey  = "apikey"
+	ActorIP      = "ip"
+)
+
+// Entry is a single audit/event record. Subject is the partition key and
+// Timestamp the sort key of the audit table. Actor is who made the change,
+// as Actor.String gives it, and Request the request they made it with, when
+// they are known.
+type Entry struct {
+	Subject   string            `json:"subject"`
+	Timestamp string            `json:"timestamp"`
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor,omitempty"`
+	Request   *Request          `json:"request,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// Request is what API Gateway knew of the request a change was made with:
+// its request id, to find its logs by, the address it came from and the
+// user agent that sent it.
+type Request struct {
+	ID        string `json:"id,omitempty"`
+	SourceIP  string `json:"sourceIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// Actor is who made a change: a Cognito user by their sub, an API key by
+// its id or, when the caller is neither, the address the request came from.
+// The zero Actor is an unknown one, such as a command invoked by another
+// Lambda. Request is the request the change was made with, when there was
+// one.
+type Actor struct {
+	Kind    string
+	ID      string
+	Request *Request
+}
+
+// ActorOf returns who made req from its API Gateway request context,
+// preferring the Cognito user pool authorizer's claims, then the API key.
+func ActorOf(req events.APIGatewayProxyRequest) Actor {
+	actor := Actor{Request: requestOf(req)}
+	identity := req.RequestContext.Identity
+	if claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		if sub, _ := claims["sub"].(string); sub != "" {
+			actor.Kind, actor.ID = ActorCognito, sub
+			return actor
+		}
+	}
+	switch {
+	case identity.APIKeyID != "":
+		actor.Kind, actor.ID = ActorAPIKey, identity.APIKeyID
+	case identity.SourceIP != "":
+		actor.Kind, actor.ID = ActorIP, identity.SourceIP
+	}
+	return actor
+}
+
+// requestOf returns what is known of req, or nil when it carries no request
+// context, as when a request is made up for a direct invocation.
+func requestOf(req events.APIGatewayProxyRequest) *Request {
+	r := &Request{
+		ID:        req.RequestContext.RequestID,
+		SourceIP:  req.RequestContext.Identity.SourceIP,
+		UserAgent: req.RequestContext.Identity.UserAgent,
+	}
+	if r.UserAgent == "" {
+		for name, value := range req.Headers {
+			if strings.EqualFold(name, "User-Agent") {
+				r.UserAgent = value
+			}
+		}
+	}
+	if *r == (Request{}) {
+		return nil
+	}
+	return r
+}
+
+// String returns the actor as kind:id, such as apikey:a1b2c3, or nothing
+// when they are not known.
+func (a Actor) String() string {
+	if a.ID == "" {
+		return ""
+	}
+	return a.Kind + ":" + a.ID
+}
+
+// UpdatedBy adds recording actor as who last changed an item to an update
+// expression, or removing who did when actor is not known, so the item
+// never names an earlier actor for a later change. A REMOVE clause in
+// expression must be its last.
+func UpdatedBy(expression string, values map[string]*dynamodb.AttributeValue, actor Actor) string {
+	remove := strings.Index(expression, "REMOVE ")
+	if actor.ID == "" {
+		if remove >= 0 {
+			return expression + ", updatedBy"
+		}
+		return expression + " REMOVE updatedBy"
+	}
+	values[":updatedBy"] = &dynamodb.AttributeValue{S: aws.String(actor.String())}
+	if !strings.HasPrefix(expression, "SET ") {
+		return expression + " SET updatedBy = :updatedBy"
+	}
+	if remove >= 0 {
+		return strings.TrimSpace(expression[:remove]) + ", updatedBy = :updatedBy " + expression[remove:]
+	}
+	return expression + ", updatedBy = :updatedBy"
+}
+
+// TableName returns the audit table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Audit"
+}
+
+func Record(subject string, action string, data map[string]string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return RecordAs(subject, action, data, Actor{}, tableName, dynaClient)
+}
+
+// RecordAs records action as Record does, made by actor.
+func RecordAs(subject string, action string, data map[string]string, actor Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return Put(Entry{
+		Subject:   subject,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Action:    action,
+		Actor:     actor.String(),
+		Request:   actor.Request,
+		Data:      data,
+	}, tableName, dynaClient)
+}
+
+func Put(entry Entry, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	av, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return errors.New(ErrorFailedToMarshalEntry)
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(tableName),
+	}
+	_, err = dynaClient.PutItem(input)
+	if err != nil {
+		return errors.New(ErrorFailedToWriteEntry)
+	}
+	return nil
+}
+
+func FetchEntries(subject string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Entry, error) {
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("subject = :subject"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":subject": {
+				S: aws.String(subject),
+			},
+		},
+		TableName: aws.String(tableName),
+	}
+
+	entries := []Entry{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToFetchEntries)
+		}
+		page := []Entry{}
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalEntry)
+		}
+		entries = append(entries, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return entries, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Scrub moves every entry recorded against subject to pseudonym, dropping the
+// entry data and who made it from where. Each entry is rewritten before the original is deleted so the
+// operation can simply be run again after a partial failure.
+func Scrub(subject string, pseudonym string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	entries, err := FetchEntries(subject, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		scrubbed := Entry{
+			Subject:   pseudonym,
+			Timestamp: entry.Timestamp,
+			Action:    entry.Action,
+		}
+		if err := Put(scrubbed, tableName, dynaClient); err != nil {
+			return err
+		}
+
+		input := &dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"subject": {
+					S: aws.String(entry.Subject),
+				},
+				"timestamp": {
+					S: aws.String(entry.Timestamp),
+				},
+			},
+			TableName: aws.String(tableName),
+		}
+		_, err = dynaClient.DeleteItem(input)
+		if err != nil {
+			return errors.New(ErrorFailedToDeleteEntry)
+		}
+	}
+	return nil
+}
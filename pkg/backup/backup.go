@@ -0,0 +1,411 @@
+package backup
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/bootstrap"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorBackupAlreadyExists   = "a backup with this name already exists"
+	ErrorBackupNotAvailable    = "backup is not available yet"
+	ErrorBackupNotFound        = "backup not found"
+	ErrorFailedToCreateBackup  = "failed to create backup"
+	ErrorFailedToFetchBackup   = "failed to fetch backup"
+	ErrorFailedToRestoreBackup = "failed to restore backup"
+	ErrorFailedToVerifyRestore = "failed to verify restore"
+	ErrorInvalidBackupName     = "backup names must be 3 to 255 letters, digits, underscores, hyphens or dots"
+	ErrorInvalidTableName      = "table names must be 3 to 255 letters, digits, underscores, hyphens or dots"
+	ErrorRestoreNotFound       = "restored table not found"
+	ErrorTableAlreadyExists    = "a table with this name already exists"
+)
+
+// names matches the backup and table names DynamoDB accepts.
+var names = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// Config sets how long requests wait on a backup or restore to finish before
+// answering with its status so far.
+type Config struct {
+	PollTimeout  time.Duration
+	PollInterval time.Duration
+}
+
+var config = Config{PollTimeout: 10 * time.Second, PollInterval: time.Second}
+
+func Configure(c Config) {
+	config = c
+}
+
+// Backup is an on-demand backup of a users table. Tables holds the backups
+// of the tables accompanying it, such as its credentials, sessions and
+// audit trail, made under the same name, so they are restored together.
+// Lists leave it empty.
+type Backup struct {
+	Name      string   `json:"name"`
+	ARN       string   `json:"arn"`
+	Table     string   `json:"table"`
+	Status    string   `json:"status"`
+	CreatedAt string   `json:"createdAt,omitempty"`
+	SizeBytes int64    `json:"sizeBytes"`
+	ItemCount int64    `json:"itemCount"`
+	Tables    []Backup `json:"tables,omitempty"`
+
+	// created orders backups made within the same second of CreatedAt
+	created time.Time
+}
+
+// Available reports whether the backup and those of its tables can be
+// restored.
+func (b *Backup) Available() bool {
+	for _, t := range b.Tables {
+		if !t.Available() {
+			return false
+		}
+	}
+	return b.Status == dynamodb.BackupStatusAvailable
+}
+
+func (b *Backup) creating() bool {
+	for _, t := range b.Tables {
+		if t.creating() {
+			return true
+		}
+	}
+	return b.Status == dynamodb.BackupStatusCreating
+}
+
+// Restore is a table restored from a backup, with Tables, the tables
+// restored from the backups of its tables. Verified is set once every table
+// is active and was restored from its backup. DynamoDB refreshes item
+// counts about every six hours, so the counts are only a guide.
+type Restore struct {
+	Backup          string    `json:"backup"`
+	Table           string    `json:"table"`
+	Status          string    `json:"status"`
+	ItemCount       int64     `json:"itemCount"`
+	BackupItemCount int64     `json:"backupItemCount"`
+	Verified        bool      `json:"verified"`
+	Tables          []Restore `json:"tables,omitempty"`
+}
+
+func (r *Restore) active() bool {
+	for _, t := range r.Tables {
+		if !t.active() {
+			return false
+		}
+	}
+	return r.Status == dynamodb.TableStatusActive
+}
+
+// Create starts a backup named name of tableName and of each table that
+// accompanies it, and waits for them to become available, returning them
+// with the status they reached in time. Accompanying tables that do not
+// exist, as for features that are not set up, are left out.
+func Create(name string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Backup, error) {
+	if !names.MatchString(name) {
+		return nil, errors.New(ErrorInvalidBackupName)
+	}
+	if _, err := find(name, tableName, dynaClient); err == nil {
+		return nil, errors.New(ErrorBackupAlreadyExists)
+	} else if err.Error() != ErrorBackupNotFound {
+		return nil, err
+	}
+
+	arns := []string{}
+	for i, table := range append([]string{tableName}, accompanying(tableName)...) {
+		result, err := dynaClient.CreateBackup(&dynamodb.CreateBackupInput{
+			BackupName: aws.String(name),
+			TableName:  aws.String(table),
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if i > 0 && errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeTableNotFoundException {
+				continue
+			}
+			return nil, store.Error(err, ErrorFailedToCreateBackup)
+		}
+		arns = append(arns, aws.StringValue(result.BackupDetails.BackupArn))
+	}
+
+	var b *Backup
+	err := poll(func() (bool, error) {
+		var err error
+		b, err = describe(arns[0], dynaClient)
+		for _, arn := range arns[1:] {
+			if err != nil {
+				break
+			}
+			var t *Backup
+			if t, err = describe(arn, dynaClient); err == nil {
+				b.Tables = append(b.Tables, *t)
+			}
+		}
+		return err == nil && !b.creating(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// List returns the backups of tableName, newest first.
+func List(tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Backup, error) {
+	backups := []Backup{}
+	input := &dynamodb.ListBackupsInput{
+		TableName:  aws.String(tableName),
+		BackupType: aws.String(dynamodb.BackupTypeFilterUser),
+	}
+	for {
+		result, err := dynaClient.ListBackups(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchBackup)
+		}
+		for _, summary := range result.BackupSummaries {
+			backups = append(backups, fromSummary(summary))
+		}
+		if result.LastEvaluatedBackupArn == nil {
+			break
+		}
+		input.ExclusiveStartBackupArn = result.LastEvaluatedBackupArn
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].created.After(backups[j].created)
+	})
+	return backups, nil
+}
+
+// Fetch returns the backup of tableName named name, with the backups of
+// its tables made under the same name.
+func Fetch(name string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Backup, error) {
+	arn, err := find(name, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	b, err := describe(arn, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range accompanying(tableName) {
+		arn, err := find(name, table, dynaClient)
+		if err != nil {
+			if err.Error() == ErrorBackupNotFound {
+				continue
+			}
+			return nil, err
+		}
+		t, err := describe(arn, dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		b.Tables = append(b.Tables, *t)
+	}
+	return b, nil
+}
+
+// RestoreTable restores the backup of tableName named name to a new table
+// called target, and the backups of its tables to new tables named as they
+// are with target in place of tableName, so an App serving target finds
+// them. It waits for the tables to become active, returning their status
+// so far. Nothing is restored when any of the tables already exists.
+func RestoreTable(name string, target string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Restore, error) {
+	if !names.MatchString(target) {
+		return nil, errors.New(ErrorInvalidTableName)
+	}
+	b, err := Fetch(name, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if !b.Available() {
+		return nil, errors.New(ErrorBackupNotAvailable)
+	}
+	backups := append([]Backup{*b}, b.Tables...)
+	targets := make([]string, len(backups))
+	for i, backup := range backups {
+		targets[i] = target + strings.TrimPrefix(backup.Table, tableName)
+		if !names.MatchString(targets[i]) {
+			return nil, errors.New(ErrorInvalidTableName)
+		}
+		if _, err := verify(&backup, targets[i], dynaClient); err == nil {
+			return nil, errors.New(ErrorTableAlreadyExists)
+		} else if err.Error() != ErrorRestoreNotFound {
+			return nil, err
+		}
+	}
+
+	for i, backup := range backups {
+		_, err = dynaClient.RestoreTableFromBackup(&dynamodb.RestoreTableFromBackupInput{
+			BackupArn:       aws.String(backup.ARN),
+			TargetTableName: aws.String(targets[i]),
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeTableAlreadyExistsException {
+				return nil, errors.New(ErrorTableAlreadyExists)
+			}
+			return nil, store.Error(err, ErrorFailedToRestoreBackup)
+		}
+	}
+
+	var r *Restore
+	err = poll(func() (bool, error) {
+		r, err = verifyAll(b, target, tableName, dynaClient)
+		return err == nil && r.active(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Verify reports how the restore of the backup of tableName named name to
+// target, and of its tables, is progressing.
+func Verify(name string, target string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Restore, error) {
+	if !names.MatchString(target) {
+		return nil, errors.New(ErrorInvalidTableName)
+	}
+	b, err := Fetch(name, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	return verifyAll(b, target, tableName, dynaClient)
+}
+
+// verifyAll verifies the restore of b to target and of each of its tables,
+// which is verified only once they all are.
+func verifyAll(b *Backup, target string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Restore, error) {
+	r, err := verify(b, target, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	for i := range b.Tables {
+		t, err := verify(&b.Tables[i], target+strings.TrimPrefix(b.Tables[i].Table, tableName), dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		r.Tables = append(r.Tables, *t)
+		r.Verified = r.Verified && t.Verified
+	}
+	return r, nil
+}
+
+func verify(b *Backup, target string, dynaClient dynamodbiface.DynamoDBAPI) (*Restore, error) {
+	result, err := dynaClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(target),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return nil, errors.New(ErrorRestoreNotFound)
+		}
+		return nil, store.Error(err, ErrorFailedToVerifyRestore)
+	}
+	table := result.Table
+	r := &Restore{
+		Backup:          b.Name,
+		Table:           target,
+		Status:          aws.StringValue(table.TableStatus),
+		ItemCount:       aws.Int64Value(table.ItemCount),
+		BackupItemCount: b.ItemCount,
+	}
+	restore := table.RestoreSummary
+	if restore == nil || aws.StringValue(restore.SourceBackupArn) != b.ARN {
+		return r, nil
+	}
+	r.Verified = r.Status == dynamodb.TableStatusActive && !aws.BoolValue(restore.RestoreInProgress)
+	return r, nil
+}
+
+// accompanying returns the names of the tables that accompany the users
+// table tableName, each named tableName followed by its own suffix.
+func accompanying(tableName string) []string {
+	tables := []string{}
+	for _, table := range bootstrap.Tables(tableName) {
+		if table.Name != tableName {
+			tables = append(tables, table.Name)
+		}
+	}
+	return tables
+}
+
+// find returns the ARN of the backup of tableName named name. DynamoDB does
+// not keep backup names unique, so Create refuses to reuse one.
+func find(name string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (string, error) {
+	backups, err := List(tableName, dynaClient)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range backups {
+		if b.Name == name {
+			return b.ARN, nil
+		}
+	}
+	return "", errors.New(ErrorBackupNotFound)
+}
+
+func describe(arn string, dynaClient dynamodbiface.DynamoDBAPI) (*Backup, error) {
+	result, err := dynaClient.DescribeBackup(&dynamodb.DescribeBackupInput{
+		BackupArn: aws.String(arn),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeBackupNotFoundException {
+			return nil, errors.New(ErrorBackupNotFound)
+		}
+		return nil, store.Error(err, ErrorFailedToFetchBackup)
+	}
+	details := result.BackupDescription.BackupDetails
+	b := &Backup{
+		Name:      aws.StringValue(details.BackupName),
+		ARN:       aws.StringValue(details.BackupArn),
+		Status:    aws.StringValue(details.BackupStatus),
+		SizeBytes: aws.Int64Value(details.BackupSizeBytes),
+		CreatedAt: timestamp(details.BackupCreationDateTime),
+	}
+	if source := result.BackupDescription.SourceTableDetails; source != nil {
+		b.Table = aws.StringValue(source.TableName)
+		b.ItemCount = aws.Int64Value(source.ItemCount)
+	}
+	return b, nil
+}
+
+func fromSummary(summary *dynamodb.BackupSummary) Backup {
+	return Backup{
+		Name:      aws.StringValue(summary.BackupName),
+		ARN:       aws.StringValue(summary.BackupArn),
+		Table:     aws.StringValue(summary.TableName),
+		Status:    aws.StringValue(summary.BackupStatus),
+		SizeBytes: aws.Int64Value(summary.BackupSizeBytes),
+		CreatedAt: timestamp(summary.BackupCreationDateTime),
+		created:   aws.TimeValue(summary.BackupCreationDateTime),
+	}
+}
+
+func timestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// poll calls check until it reports done or fails, or the poll timeout
+// passes.
+func poll(check func() (bool, error)) error {
+	deadline := time.Now().Add(config.PollTimeout)
+	for {
+		done, err := check()
+		if err != nil || done || !time.Now().Before(deadline) {
+			return err
+		}
+		time.Sleep(config.PollInterval)
+	}
+}
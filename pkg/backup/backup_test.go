@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newClient returns a client holding a test table of 3 items, whose backups
+// are described as being created pending times.
+func newClient(pending int) *testutil.MemoryDynamoDB {
+	client := testutil.NewMemoryDynamoDB(testutil.Table{Name: "test", PartitionKey: "email"})
+	for _, email := range []string{"alan.oliver@ecs.co.uk", "al@ecs.co.uk", "someone.else@ecs.co.uk"} {
+		client.Put("test", map[string]*dynamodb.AttributeValue{"email": {S: aws.String(email)}})
+	}
+	client.Pending = pending
+	return client
+}
+
+func TestBackups(t *testing.T) {
+	defer Configure(config)
+	Configure(Config{PollTimeout: time.Second, PollInterval: time.Millisecond})
+
+	t.Run("expect a backup to be polled until it is available", func(t *testing.T) {
+		client := newClient(2)
+		b, err := Create("nightly", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if !b.Available() || b.ItemCount != 3 || b.Table != "test" {
+			t.Errorf("Expected an available backup of 3 items from test, got %+v", b)
+		}
+		if describes := client.Count("DescribeBackup"); describes != 3 {
+			t.Errorf("Expected the backup to be described 3 times, got %d", describes)
+		}
+	})
+
+	t.Run("expect a backup still being created once the poll timeout passes", func(t *testing.T) {
+		Configure(Config{PollTimeout: 0, PollInterval: time.Millisecond})
+		defer Configure(Config{PollTimeout: time.Second, PollInterval: time.Millisecond})
+		b, err := Create("nightly", "test", newClient(5))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if b.Available() {
+			t.Errorf("Expected the backup to still be creating, got %s", b.Status)
+		}
+	})
+
+	t.Run("expect backup names to be unique and valid", func(t *testing.T) {
+		client := newClient(0)
+		if _, err := Create("nightly", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := Create("nightly", "test", client); err == nil || err.Error() != ErrorBackupAlreadyExists {
+			t.Errorf("Expected %q, got %v", ErrorBackupAlreadyExists, err)
+		}
+		if _, err := Create("a b", "test", client); err == nil || err.Error() != ErrorInvalidBackupName {
+			t.Errorf("Expected %q, got %v", ErrorInvalidBackupName, err)
+		}
+	})
+
+	t.Run("expect backups to be listed newest first", func(t *testing.T) {
+		client := newClient(0)
+		Create("first", "test", client)
+		Create("second", "test", client)
+		backups, err := List("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(backups) != 2 || backups[0].Name != "second" {
+			t.Errorf("Expected second then first, got %+v", backups)
+		}
+	})
+
+	t.Run("expect a restore to be verified once its table is active", func(t *testing.T) {
+		client := newClient(0)
+		Create("nightly", "test", client)
+		client.Pending = 1
+		r, err := RestoreTable("nightly", "test-restored", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if !r.Verified || r.ItemCount != 3 || r.BackupItemCount != 3 {
+			t.Errorf("Expected a verified restore of 3 items, got %+v", r)
+		}
+		r, err = Verify("nightly", "test-restored", "test", client)
+		if err != nil || !r.Verified {
+			t.Errorf("Expected the restore to verify, got %+v, %v", r, err)
+		}
+		if _, err := RestoreTable("nightly", "test-restored", "test", client); err == nil || err.Error() != ErrorTableAlreadyExists {
+			t.Errorf("Expected %q, got %v", ErrorTableAlreadyExists, err)
+		}
+	})
+
+	t.Run("expect the tables accompanying the users table to be backed up and restored with it", func(t *testing.T) {
+		client := newClient(1)
+		client.CreateTable(&dynamodb.CreateTableInput{
+			TableName: aws.String(session.TableName("test")),
+			KeySchema: []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String(dynamodb.KeyTypeHash)}},
+		})
+		client.Put(session.TableName("test"), map[string]*dynamodb.AttributeValue{"id": {S: aws.String("abc")}})
+		b, err := Create("nightly", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if !b.Available() || len(b.Tables) != 1 || b.Tables[0].Table != session.TableName("test") {
+			t.Fatalf("Expected the sessions table backed up too, got %+v", b)
+		}
+		if fetched, _ := Fetch("nightly", "test", client); len(fetched.Tables) != 1 {
+			t.Errorf("Expected the backup fetched with its sessions table, got %+v", fetched)
+		}
+
+		client.Pending = 0
+		r, err := RestoreTable("nightly", "restored", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if !r.Verified || len(r.Tables) != 1 || r.Tables[0].Table != session.TableName("restored") {
+			t.Errorf("Expected the sessions table restored beside the users table, got %+v", r)
+		}
+		if items := client.Items(session.TableName("restored")); len(items) != 1 {
+			t.Errorf("Expected the session restored, got %v", items)
+		}
+		client.CreateTable(&dynamodb.CreateTableInput{
+			TableName: aws.String(session.TableName("clash")),
+			KeySchema: []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String(dynamodb.KeyTypeHash)}},
+		})
+		if _, err := RestoreTable("nightly", "clash", "test", client); err == nil || err.Error() != ErrorTableAlreadyExists {
+			t.Errorf("Expected %q, got %v", ErrorTableAlreadyExists, err)
+		}
+		if _, err := client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String("clash")}); err == nil {
+			t.Error("Expected nothing restored while a table of the restore exists")
+		}
+	})
+	t.Run("expect a restore from a missing backup or to a missing table to fail", func(t *testing.T) {
+		client := newClient(0)
+		if _, err := RestoreTable("nightly", "test-restored", "test", client); err == nil || err.Error() != ErrorBackupNotFound {
+			t.Errorf("Expected %q, got %v", ErrorBackupNotFound, err)
+		}
+		Create("nightly", "test", client)
+		if _, err := Verify("nightly", "test-restored", "test", client); err == nil || err.Error() != ErrorRestoreNotFound {
+			t.Errorf("Expected %q, got %v", ErrorRestoreNotFound, err)
+		}
+	})
+
+	t.Run("expect a backup that is still being created not to be restored", func(t *testing.T) {
+		Configure(Config{PollTimeout: 0, PollInterval: time.Millisecond})
+		defer Configure(Config{PollTimeout: time.Second, PollInterval: time.Millisecond})
+		client := newClient(5)
+		Create("nightly", "test", client)
+		if _, err := RestoreTable("nightly", "test-restored", "test", client); err == nil || err.Error() != ErrorBackupNotAvailable {
+			t.Errorf("Expected %q, got %v", ErrorBackupNotAvailable, err)
+		}
+	})
+}
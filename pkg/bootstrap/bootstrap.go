@@ -0,0 +1,322 @@
+// Package bootstrap creates the users table and the tables that accompany
+// it, with their indexes, TTL attributes and streams, so a local or
+// ephemeral environment can set itself up. Tables that already exist are
+// brought up to date where DynamoDB allows it and otherwise left alone.
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/group"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/invitation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/migrate"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/organization"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/projection"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/relation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Table describes a table as it is deployed: its keys, global secondary
+// indexes, TTL attribute and the view type of its stream, if it has one.
+// Key attributes are strings unless Types says otherwise.
+type Table struct {
+	Name    string
+	Hash    string
+	Range   string
+	Types   map[string]string
+	Indexes []Index
+	TTL     string
+	Stream  string
+}
+
+// Index is a global secondary index projecting every attribute.
+type Index struct {
+	Name  string
+	Hash  string
+	Range string
+}
+
+// Tables returns the users table and every table that accompanies it, as
+// described in the README. The users table streams new and old images for
+// the projector and search indexer, and the outbox new images for the relay.
+func Tables(userTableName string) []Table {
+	sessions := session.Table(userTableName)
+	return []Table{
+		{Name: userTableName, Hash: "email", Stream: dynamodb.StreamViewTypeNewAndOldImages},
+		{Name: audit.TableName(userTableName), Hash: "subject", Range: "timestamp"},
+		{Name: user.ErasureTableName(userTableName), Hash: "id"},
+		{Name: user.ExportTableName(userTableName), Hash: "id", TTL: "ttl"},
+		{Name: user.PreferencesTableName(userTableName), Hash: "email"},
+		{Name: invitation.TableName(userTableName), Hash: "id", TTL: "ttl", Indexes: []Index{{Name: invitation.StatusIndex, Hash: "status", Range: "createdAt"}}},
+		{Name: credentials.TableName(userTableName), Hash: "email"},
+		{Name: sessions.Name, Hash: "id", TTL: "ttl", Indexes: []Index{{Name: sessions.Index(session.EmailIndex), Hash: "email"}}},
+		{Name: group.TableName(userTableName), Hash: "pk", Range: "sk", Indexes: []Index{{Name: store.SortKeyIndex, Hash: "sk", Range: "pk"}}},
+		{Name: organization.TableName(userTableName), Hash: "id"},
+		{Name: relation.TableName(userTableName), Hash: "pk", Range: "sk"},
+		{Name: schema.TableName(userTableName), Hash: "name"},
+		{Name: migrate.TableName(userTableName), Hash: "id"},
+		{Name: user.EventTableName(userTableName), Hash: "email", Range: "sequence", Types: map[string]string{"sequence": dynamodb.ScalarAttributeTypeN}},
+		{Name: outbox.TableName(userTableName), Hash: "id", Stream: dynamodb.StreamViewTypeNewImage, Indexes: []Index{{Name: outbox.StatusIndex, Hash: "status", Range: "id"}}},
+		{Name: projection.TableName(userTableName), Hash: "pk", Range: "sk", TTL: "ttl"},
+		{Name: webhook.TableName(userTableName), Hash: "pk", Range: "sk", TTL: "ttl", Indexes: []Index{{Name: store.SortKeyIndex, Hash: "sk", Range: "pk"}}},
+		{Name: quota.TableName(userTableName), Hash: "id", TTL: "ttl"},
+	}
+}
+
+// Report lists the tables Ensure created, those it changed and those it
+// found as described.
+type Report struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// Ensure ensures the users table named userTableName and its accompanying
+// tables exist as described by Tables, stopping at the first that cannot be
+// made to.
+func Ensure(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Report, error) {
+	report := &Report{Created: []string{}, Updated: []string{}, Unchanged: []string{}}
+	for _, table := range Tables(userTableName) {
+		created, updated, err := EnsureTable(table, dynaClient)
+		if err != nil {
+			return report, err
+		}
+		switch {
+		case created:
+			report.Created = append(report.Created, table.Name)
+		case updated:
+			report.Updated = append(report.Updated, table.Name)
+		default:
+			report.Unchanged = append(report.Unchanged, table.Name)
+		}
+	}
+	return report, nil
+}
+
+// PollInterval is how often a table being created or changed is checked
+// until it is active, for at most MaxWait.
+var (
+	PollInterval = 2 * time.Second
+	MaxWait      = 10 * time.Minute
+)
+
+// EnsureTable creates table, on demand, when it does not exist, and
+// otherwise adds the indexes, TTL and stream it lacks, reporting which it
+// did. A table with other keys, or a TTL attribute or stream view type
+// other than table's, cannot be changed in place and is reported as an
+// error.
+func EnsureTable(table Table, dynaClient dynamodbiface.DynamoDBAPI) (created bool, updated bool, err error) {
+	output, err := dynaClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table.Name)})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		if err := createTable(table, dynaClient); err != nil {
+			return false, false, err
+		}
+		_, err := ensureTTL(table, dynaClient)
+		return true, false, err
+	}
+	if err != nil {
+		return false, false, err
+	}
+	description := output.Table
+	if !sameKeys(description.KeySchema, table.Hash, table.Range) {
+		return false, false, fmt.Errorf("table %s has keys other than %s", table.Name, keyNames(table.Hash, table.Range))
+	}
+	if err := waitActive(table.Name, dynaClient); err != nil {
+		return false, false, err
+	}
+
+	if table.Stream != "" {
+		spec := description.StreamSpecification
+		switch {
+		case spec == nil || !aws.BoolValue(spec.StreamEnabled):
+			_, err := dynaClient.UpdateTable(&dynamodb.UpdateTableInput{
+				TableName:           aws.String(table.Name),
+				StreamSpecification: streamSpecification(table.Stream),
+			})
+			if err != nil {
+				return false, updated, err
+			}
+			if err := waitActive(table.Name, dynaClient); err != nil {
+				return false, updated, err
+			}
+			updated = true
+		case aws.StringValue(spec.StreamViewType) != table.Stream:
+			return false, updated, fmt.Errorf("table %s streams %s rather than %s", table.Name, aws.StringValue(spec.StreamViewType), table.Stream)
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, index := range description.GlobalSecondaryIndexes {
+		existing[aws.StringValue(index.IndexName)] = true
+	}
+	for _, index := range table.Indexes {
+		if existing[index.Name] {
+			continue
+		}
+		// DynamoDB creates one index at a time on an existing table
+		attributes := map[string]bool{}
+		gsi := globalSecondaryIndex(index, attributes)
+		_, err := dynaClient.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName:                   aws.String(table.Name),
+			AttributeDefinitions:        attributeDefinitions(attributes, table.Types),
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{{Create: &dynamodb.CreateGlobalSecondaryIndexAction{IndexName: gsi.IndexName, KeySchema: gsi.KeySchema, Projection: gsi.Projection}}},
+		})
+		if err != nil {
+			return false, updated, err
+		}
+		if err := waitActive(table.Name, dynaClient); err != nil {
+			return false, updated, err
+		}
+		updated = true
+	}
+
+	enabled, err := ensureTTL(table, dynaClient)
+	return false, updated || enabled, err
+}
+
+func createTable(table Table, dynaClient dynamodbiface.DynamoDBAPI) error {
+	attributes := map[string]bool{}
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(table.Name),
+		KeySchema:   keySchema(table.Hash, table.Range, attributes),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	}
+	for _, index := range table.Indexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, globalSecondaryIndex(index, attributes))
+	}
+	input.AttributeDefinitions = attributeDefinitions(attributes, table.Types)
+	if table.Stream != "" {
+		input.StreamSpecification = streamSpecification(table.Stream)
+	}
+	if _, err := dynaClient.CreateTable(input); err != nil {
+		var awsErr awserr.Error
+		// Another bootstrap got there first
+		if !errors.As(err, &awsErr) || awsErr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return err
+		}
+	}
+	return waitActive(table.Name, dynaClient)
+}
+
+// ensureTTL enables expiry on the table's TTL attribute, reporting whether
+// it had to.
+func ensureTTL(table Table, dynaClient dynamodbiface.DynamoDBAPI) (bool, error) {
+	if table.TTL == "" {
+		return false, nil
+	}
+	output, err := dynaClient.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{TableName: aws.String(table.Name)})
+	if err != nil {
+		return false, err
+	}
+	if description := output.TimeToLiveDescription; description != nil {
+		switch aws.StringValue(description.TimeToLiveStatus) {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			if attribute := aws.StringValue(description.AttributeName); attribute != table.TTL {
+				return false, fmt.Errorf("table %s expires items by %s rather than %s", table.Name, attribute, table.TTL)
+			}
+			return false, nil
+		}
+	}
+	_, err = dynaClient.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table.Name),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(table.TTL),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err == nil, err
+}
+
+func globalSecondaryIndex(index Index, attributes map[string]bool) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName:  aws.String(index.Name),
+		KeySchema:  keySchema(index.Hash, index.Range, attributes),
+		Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+	}
+}
+
+// keySchema returns the key schema of hash and an optional range key,
+// adding both to attributes.
+func keySchema(hash string, rangeKey string, attributes map[string]bool) []*dynamodb.KeySchemaElement {
+	attributes[hash] = true
+	schema := []*dynamodb.KeySchemaElement{{AttributeName: aws.String(hash), KeyType: aws.String(dynamodb.KeyTypeHash)}}
+	if rangeKey != "" {
+		attributes[rangeKey] = true
+		schema = append(schema, &dynamodb.KeySchemaElement{AttributeName: aws.String(rangeKey), KeyType: aws.String(dynamodb.KeyTypeRange)})
+	}
+	return schema
+}
+
+func attributeDefinitions(attributes map[string]bool, types map[string]string) []*dynamodb.AttributeDefinition {
+	definitions := []*dynamodb.AttributeDefinition{}
+	for name := range attributes {
+		attributeType := dynamodb.ScalarAttributeTypeS
+		if t, ok := types[name]; ok {
+			attributeType = t
+		}
+		definitions = append(definitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(attributeType),
+		})
+	}
+	return definitions
+}
+
+func streamSpecification(viewType string) *dynamodb.StreamSpecification {
+	return &dynamodb.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: aws.String(viewType)}
+}
+
+func sameKeys(schema []*dynamodb.KeySchemaElement, hash string, rangeKey string) bool {
+	found := map[string]string{}
+	for _, element := range schema {
+		found[aws.StringValue(element.KeyType)] = aws.StringValue(element.AttributeName)
+	}
+	return len(found) == len(schema) && found[dynamodb.KeyTypeHash] == hash && found[dynamodb.KeyTypeRange] == rangeKey
+}
+
+func keyNames(hash string, rangeKey string) string {
+	if rangeKey == "" {
+		return hash
+	}
+	return hash + " and " + rangeKey
+}
+
+// waitActive waits for the table and its indexes to become active.
+func waitActive(name string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	deadline := time.Now().Add(MaxWait)
+	for {
+		output, err := dynaClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+		if err != nil {
+			return err
+		}
+		active := aws.StringValue(output.Table.TableStatus) == dynamodb.TableStatusActive
+		for _, index := range output.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(index.IndexStatus) != dynamodb.IndexStatusActive {
+				active = false
+			}
+		}
+		if active {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("table %s did not become active", name)
+		}
+		time.Sleep(PollInterval)
+	}
+}
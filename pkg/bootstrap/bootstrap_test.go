@@ -0,0 +1,100 @@
+package bootstrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// timeToLive returns the TTL description of tableName in client.
+func timeToLive(client *testutil.MemoryDynamoDB, tableName string) *dynamodb.TimeToLiveDescription {
+	output, err := client.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil
+	}
+	return output.TimeToLiveDescription
+}
+
+// existing creates tableName in client keyed by id alone, as a table made
+// before bootstrap was.
+func existing(client *testutil.MemoryDynamoDB, tableName string) {
+	client.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		KeySchema: []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String(dynamodb.KeyTypeHash)}},
+	})
+}
+
+func TestEnsure(t *testing.T) {
+	PollInterval = 0
+
+	t.Run("expect every table to be created once", func(t *testing.T) {
+		client := testutil.NewMemoryDynamoDB()
+		client.Pending = 1
+		report, err := Ensure("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(report.Created) != len(Tables("test")) || len(report.Updated)+len(report.Unchanged) != 0 {
+			t.Errorf("Expected every table to be created, got %+v", report)
+		}
+		if spec := client.Description("test").StreamSpecification; spec == nil || *spec.StreamViewType != dynamodb.StreamViewTypeNewAndOldImages {
+			t.Errorf("Expected the users table to stream new and old images, got %v", spec)
+		}
+		if ttl := timeToLive(client, "testSession"); ttl == nil || ttl.AttributeName == nil || *ttl.AttributeName != "ttl" {
+			t.Errorf("Expected sessions to expire by ttl, got %v", ttl)
+		}
+		for _, definition := range client.Description("testEvent").AttributeDefinitions {
+			if *definition.AttributeName == "sequence" && *definition.AttributeType != dynamodb.ScalarAttributeTypeN {
+				t.Errorf("Expected sequence to be a number, got %s", *definition.AttributeType)
+			}
+		}
+
+		report, err = Ensure("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(report.Unchanged) != len(Tables("test")) {
+			t.Errorf("Expected every table to be left unchanged, got %+v", report)
+		}
+	})
+
+	t.Run("expect what an existing table lacks to be added", func(t *testing.T) {
+		client := testutil.NewMemoryDynamoDB()
+		existing(client, "testSession")
+		client.Pending = 1
+		var table Table
+		for _, table = range Tables("test") {
+			if table.Name == "testSession" {
+				break
+			}
+		}
+		created, updated, err := EnsureTable(table, client)
+		if err != nil || created || !updated {
+			t.Fatalf("Expected the table to be updated, got %t %t %v", created, updated, err)
+		}
+		indexes := client.Description("testSession").GlobalSecondaryIndexes
+		if client.Count("UpdateTable") != 1 || len(indexes) != 1 || *indexes[0].IndexName != "email-index" {
+			t.Errorf("Expected the index to be added, got %v", indexes)
+		}
+		if ttl := timeToLive(client, "testSession"); !reflect.DeepEqual(ttl.AttributeName, aws.String("ttl")) {
+			t.Errorf("Expected expiry to be enabled, got %v", ttl)
+		}
+	})
+
+	t.Run("expect a table with other keys to be refused", func(t *testing.T) {
+		client := testutil.NewMemoryDynamoDB()
+		existing(client, "test")
+		_, err := Ensure("test", client)
+		if err == nil || !strings.Contains(err.Error(), "keys other than email") {
+			t.Errorf("Expected the keys to be refused, got %v", err)
+		}
+		if updates := client.Count("UpdateTable"); updates != 0 {
+			t.Errorf("Expected the table not to be changed, got %d updates", updates)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+// Package cognito keeps the users table in step with a Cognito user pool
+// through its triggers. Confirming a sign up creates the user from their
+// Cognito attributes, and each token issued enriches it: the user is created
+// if the sign up was missed, their sign in is recorded, and their status and
+// tags are added to the ID token's claims.
+package cognito
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Trigger sources handled. Token generation has one per way of signing in,
+// all starting with TriggerTokenGeneration.
+const (
+	TriggerConfirmSignUp   = "PostConfirmation_ConfirmSignUp"
+	TriggerTokenGeneration = "TokenGeneration_"
+	TriggerRefreshTokens   = "TokenGeneration_RefreshTokens"
+)
+
+const (
+	// MetadataSub is the metadata key a user's Cognito sub is kept under.
+	MetadataSub = "cognito.sub"
+
+	ClaimStatus = "status"
+	ClaimTags   = "tags"
+)
+
+// PostConfirmation creates the user who has just confirmed their sign up,
+// or links an existing user with the same email to them. Its error is for
+// logging rather than failing the trigger: that would show the person an
+// error after they were confirmed, and their user is created when they first
+// sign in instead.
+func PostConfirmation(event events.CognitoEventUserPoolsPostConfirmation, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := sync(event.Request.UserAttributes, tableName, dynaClient)
+	return err
+}
+
+// PreTokenGeneration adds the user's status and tags to the token's claims
+// and records the sign in, creating the user first if they are missing. A
+// suspended or deactivated user is refused a token. A user whose Cognito
+// attributes are not a valid user, such as one without names, is issued a
+// token without the claims.
+func PreTokenGeneration(event events.CognitoEventUserPoolsPreTokenGen, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (events.CognitoEventUserPoolsPreTokenGen, error) {
+	u, err := sync(event.Request.UserAttributes, tableName, dynaClient)
+	if err != nil {
+		var fieldErrs validators.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			return event, nil
+		}
+		return event, err
+	}
+	switch u.Status {
+	case user.StatusSuspended:
+		return event, errors.New(user.ErrorAccountSuspended)
+	case user.StatusDeactivated:
+		return event, errors.New(user.ErrorAccountDeactivated)
+	}
+	if event.TriggerSource == TriggerRefreshTokens {
+		err = user.RecordActivity(u.Email, tableName, dynaClient)
+	} else {
+		err = user.RecordLogin(u.Email, tableName, dynaClient)
+	}
+	if err != nil {
+		return event, err
+	}
+
+	claims := event.Response.ClaimsOverrideDetails.ClaimsToAddOrOverride
+	if claims == nil {
+		claims = map[string]string{}
+	}
+	claims[ClaimStatus] = u.Status
+	if claims[ClaimStatus] == "" {
+		claims[ClaimStatus] = user.StatusActive
+	}
+	claims[ClaimTags] = strings.Join(u.Tags, ",")
+	event.Response.ClaimsOverrideDetails.ClaimsToAddOrOverride = claims
+	return event, nil
+}
+
+// sync returns the user with the email of attributes, creating them if they
+// do not exist and keeping their Cognito sub in their metadata.
+func sync(attributes map[string]string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*user.User, error) {
+	attributed := fromAttributes(attributes)
+	if attributed.Email == "" {
+		return nil, errors.New(user.ErrorEmailRequired)
+	}
+	existing, err := user.FetchUserWithOptions(attributed.Email, tableName, dynaClient, user.FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	if existing.Email == "" {
+		// Users confirming their sign up create themselves
+		actor := audit.Actor{Kind: audit.ActorCognito, ID: attributes["sub"]}
+		created, err := user.CreateAs(attributed, actor, tableName, dynaClient)
+		if err != nil && err.Error() != user.ErrorUserAlreadyExists {
+			return nil, err
+		}
+		if err == nil {
+			return created, nil
+		}
+		// Created by a trigger running alongside this one
+		if existing, err = user.FetchUserWithOptions(attributed.Email, tableName, dynaClient, user.FetchOptions{ConsistentRead: true}); err != nil {
+			return nil, err
+		}
+	}
+	sub := attributes["sub"]
+	if sub == "" || existing.Metadata[MetadataSub] == sub {
+		return existing, nil
+	}
+	metadata := map[string]string{MetadataSub: sub}
+	for key, value := range existing.Metadata {
+		if key != MetadataSub {
+			metadata[key] = value
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"email": existing.Email, "metadata": metadata})
+	if err != nil {
+		return nil, err
+	}
+	updated, err := user.UpdateUser(events.APIGatewayProxyRequest{Body: string(body)}, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// fromAttributes returns the user described by Cognito's standard
+// attributes. Without given and family names, the full name is split at its
+// last space.
+func fromAttributes(attributes map[string]string) user.User {
+	u := user.User{
+		Email:       strings.ToLower(strings.TrimSpace(attributes["email"])),
+		FirstName:   attributes["given_name"],
+		LastName:    attributes["family_name"],
+		Phone:       user.Optional(attributes["phone_number"]),
+		DateOfBirth: user.Optional(attributes["birthdate"]),
+	}
+	if name := strings.TrimSpace(attributes["name"]); u.FirstName == "" && u.LastName == "" && name != "" {
+		if i := strings.LastIndex(name, " "); i > 0 {
+			u.FirstName, u.LastName = name[:i], name[i+1:]
+		}
+	}
+	if sub := attributes["sub"]; sub != "" {
+		u.Metadata = map[string]string{MetadataSub: sub}
+	}
+	return u
+}
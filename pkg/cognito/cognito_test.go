@@ -0,0 +1,156 @@
+package cognito
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// newTable keeps the users table's one user in memory, starting as stored
+// when it is set. Writes to any other table, such as audit entries, are
+// dropped.
+func newTable(t *testing.T, stored *user.User) (*testutil.MockDynamoDB, func() user.User) {
+	var item map[string]*dynamodb.AttributeValue
+	if stored != nil {
+		var err error
+		if item, err = dynamodbattribute.MarshalMap(stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := &testutil.MockDynamoDB{
+		GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if *input.TableName != "test" {
+				return &dynamodb.GetItemOutput{}, nil
+			}
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		},
+		PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if *input.TableName == "test" {
+				item = input.Item
+			}
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		// Updates set attributes to the value named after them and remove
+		// the attributes listed after REMOVE
+		UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			if *input.TableName != "test" {
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+			set, remove, _ := strings.Cut(*input.UpdateExpression, "REMOVE ")
+			for _, name := range input.ExpressionAttributeNames {
+				if value, ok := input.ExpressionAttributeValues[":"+*name]; ok && strings.Contains(set, "#"+*name+" = ") {
+					item[*name] = value
+				}
+				if strings.Contains(remove, "#"+*name) {
+					delete(item, *name)
+				}
+			}
+			return &dynamodb.UpdateItemOutput{}, nil
+		},
+	}
+	return table, func() user.User {
+		var u user.User
+		if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+}
+
+func TestPostConfirmation(t *testing.T) {
+	confirmed := func(attributes map[string]string) events.CognitoEventUserPoolsPostConfirmation {
+		var event events.CognitoEventUserPoolsPostConfirmation
+		event.TriggerSource = TriggerConfirmSignUp
+		event.Request.UserAttributes = attributes
+		return event
+	}
+
+	t.Run("expect the user created from their attributes", func(t *testing.T) {
+		table, stored := newTable(t, nil)
+		event := confirmed(map[string]string{"sub": "abc-123", "email": "Alan.Oliver@ecs.co.uk", "name": "Alan Robert Oliver"})
+		if err := PostConfirmation(event, "test", table); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u := stored()
+		if u.Email != "alan.oliver@ecs.co.uk" || u.FirstName != "Alan Robert" || u.LastName != "Oliver" || u.Metadata[MetadataSub] != "abc-123" {
+			t.Errorf("Expected the user created with their sub, got %+v", u)
+		}
+	})
+	t.Run("expect an existing user linked to their sub", func(t *testing.T) {
+		table, stored := newTable(t, &user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver", Metadata: map[string]string{"team": "core"}})
+		event := confirmed(map[string]string{"sub": "abc-123", "email": "alan.oliver@ecs.co.uk", "given_name": "Al", "family_name": "O"})
+		if err := PostConfirmation(event, "test", table); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u := stored()
+		if u.FirstName != "Alan" || u.Metadata[MetadataSub] != "abc-123" || u.Metadata["team"] != "core" {
+			t.Errorf("Expected the stored user kept and linked, got %+v", u)
+		}
+	})
+	t.Run("expect attributes that are not a valid user to be an error", func(t *testing.T) {
+		table, _ := newTable(t, nil)
+		if err := PostConfirmation(confirmed(map[string]string{"email": "alan.oliver@ecs.co.uk"}), "test", table); err == nil {
+			t.Error("Expected an error for a user without names")
+		}
+		if table.Count("PutItem") != 0 {
+			t.Errorf("Expected no user created, got %d writes", table.Count("PutItem"))
+		}
+	})
+}
+
+func TestPreTokenGeneration(t *testing.T) {
+	signingIn := func(source string) events.CognitoEventUserPoolsPreTokenGen {
+		var event events.CognitoEventUserPoolsPreTokenGen
+		event.TriggerSource = source
+		event.Request.UserAttributes = map[string]string{"sub": "abc-123", "email": "alan.oliver@ecs.co.uk", "given_name": "Alan", "family_name": "Oliver"}
+		return event
+	}
+
+	t.Run("expect claims added and the sign in recorded", func(t *testing.T) {
+		table, _ := newTable(t, &user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver", Tags: []string{"admin", "beta"}, Metadata: map[string]string{MetadataSub: "abc-123"}})
+		event, err := PreTokenGeneration(signingIn("TokenGeneration_Authentication"), "test", table)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		claims := event.Response.ClaimsOverrideDetails.ClaimsToAddOrOverride
+		if claims[ClaimStatus] != user.StatusActive || claims[ClaimTags] != "admin,beta" {
+			t.Errorf("Expected status and tags claims, got %v", claims)
+		}
+		if table.Count("UpdateItem") != 1 || table.Count("PutItem") != 0 {
+			t.Errorf("Expected only the sign in written, got %d updates and %d puts", table.Count("UpdateItem"), table.Count("PutItem"))
+		}
+	})
+	t.Run("expect a missing user created", func(t *testing.T) {
+		table, stored := newTable(t, nil)
+		if _, err := PreTokenGeneration(signingIn("TokenGeneration_HostedAuth"), "test", table); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if u := stored(); u.Email != "alan.oliver@ecs.co.uk" || u.Metadata[MetadataSub] != "abc-123" {
+			t.Errorf("Expected the user created, got %+v", u)
+		}
+	})
+	t.Run("expect suspended and deactivated users refused", func(t *testing.T) {
+		for status, want := range map[string]string{user.StatusSuspended: user.ErrorAccountSuspended, user.StatusDeactivated: user.ErrorAccountDeactivated} {
+			table, _ := newTable(t, &user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver", Status: status, Metadata: map[string]string{MetadataSub: "abc-123"}})
+			_, err := PreTokenGeneration(signingIn("TokenGeneration_Authentication"), "test", table)
+			if err == nil || err.Error() != want {
+				t.Errorf("Expected %q for a %s user, got %v", want, status, err)
+			}
+		}
+	})
+	t.Run("expect a token without claims for attributes that are not a valid user", func(t *testing.T) {
+		table, _ := newTable(t, nil)
+		event := signingIn("TokenGeneration_Authentication")
+		delete(event.Request.UserAttributes, "given_name")
+		event, err := PreTokenGeneration(event, "test", table)
+		if err != nil || len(event.Response.ClaimsOverrideDetails.ClaimsToAddOrOverride) != 0 {
+			t.Errorf("Expected the token issued as it was, got %v and %v", event.Response.ClaimsOverrideDetails, err)
+		}
+	})
+}
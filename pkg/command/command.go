@@ -0,0 +1,141 @@
+// Package command answers other Lambdas invoking the function directly with
+// a compact command, such as {"op": "getUser", "email": "..."}, instead of
+// an API Gateway request made up for the purpose. pkg/userclient sends them.
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	OpGetUser    = "getUser"
+	OpListUsers  = "listUsers"
+	OpCreateUser = "createUser"
+	OpUpdateUser = "updateUser"
+	OpDeleteUser = "deleteUser"
+)
+
+var (
+	ErrorInvalidCommandFields = "command has invalid fields"
+	ErrorUnknownOperation     = "unknown command operation"
+)
+
+// Command is one operation on users. Which fields are read depends on Op:
+// getUser and deleteUser name the user by Email, listUsers pages through
+// users with Tag, Limit and After, and createUser and updateUser take User,
+// read as the body of the REST request would be.
+type Command struct {
+	Op     string             `json:"op"`
+	Email  string             `json:"email,omitempty"`
+	User   json.RawMessage    `json:"user,omitempty"`
+	Tag    string             `json:"tag,omitempty"`
+	Fields []string           `json:"fields,omitempty"`
+	Limit  int                `json:"limit,omitempty"`
+	After  *user.ScanPosition `json:"after,omitempty"`
+}
+
+// Result answers a command. A failed command is answered with its error and
+// code, as the REST endpoints word them in English, rather than failing the
+// invocation, so callers can tell it from the function failing.
+type Result struct {
+	User  *user.User  `json:"user,omitempty"`
+	Users []user.User `json:"users,omitempty"`
+	// Next is where listUsers stopped when there are more users, for the
+	// next command's After
+	Next   *user.ScanPosition     `json:"next,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Code   string                 `json:"code,omitempty"`
+	Errors validators.FieldErrors `json:"errors,omitempty"`
+}
+
+// ParseEvent returns the command a payload carries, reporting whether it is
+// one. API Gateway requests have no "op" so are never taken for one.
+func ParseEvent(payload []byte) (Command, bool) {
+	var c Command
+	if err := json.Unmarshal(payload, &c); err != nil || c.Op == "" {
+		return Command{}, false
+	}
+	return c, true
+}
+
+// Handle runs c against the users table. Changes are announced to webhooks
+// as they are for the REST endpoints.
+func Handle(c Command, tableName string, dynaClient dynamodbiface.DynamoDBAPI) Result {
+	result, err := run(c, tableName, dynaClient)
+	if err != nil {
+		return failed(err)
+	}
+	return result
+}
+
+func run(c Command, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (Result, error) {
+	switch c.Op {
+	case OpGetUser:
+		email := strings.ToLower(strings.TrimSpace(c.Email))
+		if email == "" {
+			return Result{}, errors.New(user.ErrorEmailRequired)
+		}
+		u, err := user.FetchUserWithOptions(email, tableName, dynaClient, user.FetchOptions{Fields: c.Fields})
+		if err != nil {
+			return Result{}, err
+		}
+		if u.Email == "" {
+			return Result{}, errors.New(user.ErrorUserNotFound)
+		}
+		return Result{User: u}, nil
+	case OpListUsers:
+		options := user.FetchOptions{Tag: c.Tag, Fields: c.Fields, Limit: c.Limit}
+		if c.After != nil {
+			if !c.After.Valid() {
+				return Result{}, errors.New(cursor.ErrorInvalidCursor)
+			}
+			options.Start = c.After
+		}
+		scanned, err := user.ScanUsersWithOptions(tableName, dynaClient, options)
+		if err != nil {
+			return Result{}, err
+		}
+		result := Result{Users: scanned.Users}
+		if scanned.Truncated {
+			result.Next = scanned.Next
+		}
+		return result, nil
+	case OpCreateUser:
+		u, err := user.CreateUser(events.APIGatewayProxyRequest{Body: string(c.User)}, tableName, dynaClient)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{User: u}, nil
+	case OpUpdateUser:
+		u, err := user.UpdateUser(events.APIGatewayProxyRequest{Body: string(c.User)}, tableName, dynaClient)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{User: u}, nil
+	case OpDeleteUser:
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": c.Email}}
+		if err := user.DeleteUser(req, tableName, dynaClient); err != nil {
+			return Result{}, err
+		}
+		return Result{}, nil
+	}
+	return Result{}, errors.New(ErrorUnknownOperation)
+}
+
+func failed(err error) Result {
+	var fieldErrs validators.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		return Result{Error: ErrorInvalidCommandFields, Code: i18n.Code(ErrorInvalidCommandFields), Errors: fieldErrs}
+	}
+	return Result{Error: err.Error(), Code: i18n.Code(err.Error())}
+}
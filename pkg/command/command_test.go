@@ -0,0 +1,72 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestParseEvent(t *testing.T) {
+	cases := map[string]bool{
+		`{"op": "getUser", "email": "alan.oliver@ecs.co.uk"}`:                 true,
+		`{"httpMethod": "GET", "path": "/", "body": "{\"op\": \"getUser\"}"}`: false,
+		`{"seed": {}}`: false,
+		`not json`:     false,
+	}
+	for payload, expected := range cases {
+		if _, ok := ParseEvent([]byte(payload)); ok != expected {
+			t.Errorf("expected %t for %s, got %t", expected, payload, ok)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	t.Run("expect a user to be fetched by email", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+		}}}
+		result := Handle(Command{Op: OpGetUser, Email: "Alan.Oliver@ecs.co.uk"}, "test", mockDb)
+		if result.Code != "" || result.User == nil || result.User.FirstName != "Alan" {
+			t.Errorf("Expected the user, got %+v", result)
+		}
+	})
+	t.Run("expect a missing user to be answered with its code", func(t *testing.T) {
+		result := Handle(Command{Op: OpGetUser, Email: "alan.oliver@ecs.co.uk"}, "test", &testutil.MockDynamoDB{})
+		if result.Code != "user_not_found" || result.User != nil {
+			t.Errorf("Expected user_not_found, got %+v", result)
+		}
+	})
+	t.Run("expect an invalid user to be answered with each invalid field", func(t *testing.T) {
+		result := Handle(Command{Op: OpCreateUser, User: []byte(`{"email": "alan.oliver@ecs.co.uk"}`)}, "test", &testutil.MockDynamoDB{})
+		if result.Code != "command_has_invalid_fields" || len(result.Errors) == 0 {
+			t.Errorf("Expected the invalid fields, got %+v", result)
+		}
+	})
+	t.Run("expect a suspended user not to be updated", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":  {S: aws.String("alan.oliver@ecs.co.uk")},
+			"status": {S: aws.String("suspended")},
+		}}}
+		result := Handle(Command{Op: OpUpdateUser, User: []byte(`{"email": "alan.oliver@ecs.co.uk", "firstName": "Al"}`)}, "test", mockDb)
+		if result.Code != "account_is_suspended" || result.User != nil {
+			t.Errorf("Expected account_is_suspended, got %+v", result)
+		}
+		if mockDb.Count("UpdateItem") != 0 {
+			t.Errorf("Expected the user not to be changed, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("expect an unknown operation to be refused", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		result := Handle(Command{Op: "dropTable"}, "test", mockDb)
+		if result.Code != "unknown_command_operation" {
+			t.Errorf("Expected unknown_command_operation, got %+v", result)
+		}
+		if calls := mockDb.Calls(); len(calls) != 0 {
+			t.Errorf("Expected no calls, got %v", calls)
+		}
+	})
+}
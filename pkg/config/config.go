@@ -0,0 +1,520 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvAdminKey                 = "ADMIN_API_KEY"
+	EnvAllowedEmailDomains      = "ALLOWED_EMAIL_DOMAINS"
+	EnvAppConfigApplication     = "APPCONFIG_APPLICATION"
+	EnvAppConfigEnvironment     = "APPCONFIG_ENVIRONMENT"
+	EnvAppConfigFlagsProfile    = "APPCONFIG_FLAGS_PROFILE"
+	EnvAppConfigPollInterval    = "APPCONFIG_POLL_INTERVAL"
+	EnvAvatarBucket             = "AVATAR_BUCKET"
+	EnvAvatarMaxSize            = "AVATAR_MAX_SIZE"
+	EnvAvatarURLExpiry          = "AVATAR_URL_EXPIRY"
+	EnvBackgroundScanRCU        = "BACKGROUND_SCAN_RCU"
+	EnvBackupPollInterval       = "BACKUP_POLL_INTERVAL"
+	EnvBackupPollTimeout        = "BACKUP_POLL_TIMEOUT"
+	EnvBannedNames              = "BANNED_NAMES"
+	EnvBlockDisposableEmails    = "BLOCK_DISPOSABLE_EMAILS"
+	EnvCircuitFailureRate       = "CIRCUIT_FAILURE_RATE"
+	EnvCircuitHalfOpenCalls     = "CIRCUIT_HALF_OPEN_REQUESTS"
+	EnvCircuitMinRequests       = "CIRCUIT_MIN_REQUESTS"
+	EnvCircuitOpenTimeout       = "CIRCUIT_OPEN_TIMEOUT"
+	EnvCircuitWindow            = "CIRCUIT_WINDOW"
+	EnvCursorKey                = "CURSOR_SIGNING_KEY"
+	EnvDataLakeBucket           = "DATA_LAKE_BUCKET"
+	EnvDataLakeFormat           = "DATA_LAKE_FORMAT"
+	EnvDataLakePrefix           = "DATA_LAKE_PREFIX"
+	EnvDAXEndpoint              = "DAX_ENDPOINT"
+	EnvDeadLetterMaxAttempts    = "DEADLETTER_MAX_ATTEMPTS"
+	EnvDeadLetterQueueURL       = "DEADLETTER_QUEUE_URL"
+	EnvDeadLetterRetryDelay     = "DEADLETTER_RETRY_DELAY"
+	EnvDisposableEmailDomains   = "DISPOSABLE_EMAIL_DOMAINS"
+	EnvDynamoMaxAttempts        = "DYNAMODB_MAX_ATTEMPTS"
+	EnvDynamoRetryBaseDelay     = "DYNAMODB_RETRY_BASE_DELAY"
+	EnvDynamoRetryJitter        = "DYNAMODB_RETRY_JITTER"
+	EnvDynamoRetryMaxDelay      = "DYNAMODB_RETRY_MAX_DELAY"
+	EnvDynamoRetryThrottles     = "DYNAMODB_RETRY_THROTTLES_UNTIL_DEADLINE"
+	EnvDynamoThrottleRetryAfter = "DYNAMODB_THROTTLE_RETRY_AFTER"
+	EnvDynamoTimeout            = "DYNAMODB_TIMEOUT"
+	EnvGraphQLEnabled           = "GRAPHQL_ENABLED"
+	EnvHTTPIdleConnTimeout      = "DYNAMODB_HTTP_IDLE_CONN_TIMEOUT"
+	EnvHTTPKeepAlive            = "DYNAMODB_HTTP_KEEP_ALIVE"
+	EnvHTTPMaxIdleConns         = "DYNAMODB_HTTP_MAX_IDLE_CONNS"
+	EnvHTTPMaxIdleConnsPerHost  = "DYNAMODB_HTTP_MAX_IDLE_CONNS_PER_HOST"
+	EnvHTTPTLSHandshakeTimeout  = "DYNAMODB_HTTP_TLS_HANDSHAKE_TIMEOUT"
+	EnvDeadlineMargin           = "DEADLINE_MARGIN"
+	EnvDeprecations             = "DEPRECATIONS"
+	EnvEmailChangeKey           = "EMAIL_CHANGE_SIGNING_KEY"
+	EnvEmailChangeSender        = "EMAIL_CHANGE_SENDER"
+	EnvEmailChangeTTL           = "EMAIL_CHANGE_TTL"
+	EnvEmailChangeURL           = "EMAIL_CHANGE_URL"
+	EnvExportBucket             = "EXPORT_BUCKET"
+	EnvExportPartSize           = "EXPORT_PART_SIZE"
+	EnvImportBucket             = "IMPORT_BUCKET"
+	EnvImportPrefix             = "IMPORT_PREFIX"
+	EnvInvitationTTL            = "INVITATION_TTL"
+	EnvListDefaultLimit         = "LIST_DEFAULT_LIMIT"
+	EnvListMaxLimit             = "LIST_MAX_LIMIT"
+	EnvLogRedactFields          = "LOG_REDACT_FIELDS"
+	EnvLogBodySampleRate        = "LOG_BODY_SAMPLE_RATE"
+	EnvLogDebugHeader           = "LOG_DEBUG_HEADER"
+	EnvMaxBodySize              = "MAX_BODY_SIZE"
+	EnvMetricsEnabled           = "METRICS_ENABLED"
+	EnvMetricsNamespace         = "METRICS_NAMESPACE"
+	EnvSLOLatencyObjective      = "SLO_LATENCY_OBJECTIVE"
+	EnvMFAEncryptionKey         = "MFA_ENCRYPTION_KEY"
+	EnvMFAIssuer                = "MFA_ISSUER"
+	EnvMXCacheTTL               = "MX_CACHE_TTL"
+	EnvMXLookupTimeout          = "MX_LOOKUP_TIMEOUT"
+	EnvOAuthAudience            = "OAUTH_AUDIENCE"
+	EnvOAuthCacheTTL            = "OAUTH_CACHE_TTL"
+	EnvOAuthClientID            = "OAUTH_CLIENT_ID"
+	EnvOAuthClientSecret        = "OAUTH_CLIENT_SECRET"
+	EnvOAuthIntrospectionURL    = "OAUTH_INTROSPECTION_URL"
+	EnvOAuthIssuer              = "OAUTH_ISSUER"
+	EnvOAuthJWKSURL             = "OAUTH_JWKS_URL"
+	EnvOAuthTimeout             = "OAUTH_TIMEOUT"
+	EnvOIDCClientID             = "OIDC_CLIENT_ID"
+	EnvOIDCHeader               = "OIDC_HEADER"
+	EnvOIDCIssuer               = "OIDC_ISSUER"
+	EnvOIDCJWKSURL              = "OIDC_JWKS_URL"
+	EnvOutboxEnabled            = "OUTBOX_ENABLED"
+	EnvOutboxEventBus           = "OUTBOX_EVENT_BUS"
+	EnvOutboxEventSource        = "OUTBOX_EVENT_SOURCE"
+	EnvOutboxTopicARN           = "OUTBOX_TOPIC_ARN"
+	EnvProblemDetails           = "PROBLEM_DETAILS"
+	EnvProjectionRecentSize     = "PROJECTION_RECENT_SIZE"
+	EnvProvisionEmailSender     = "PROVISION_EMAIL_SENDER"
+	EnvQuarantineBucket         = "QUARANTINE_BUCKET"
+	EnvQuarantinePrefix         = "QUARANTINE_PREFIX"
+	EnvQuotaEmailWrites         = "QUOTA_EMAIL_WRITES"
+	EnvQuotaKeyHeader           = "QUOTA_KEY_HEADER"
+	EnvQuotaKeyWrites           = "QUOTA_KEY_WRITES"
+	EnvQuotaWindow              = "QUOTA_WINDOW"
+	EnvProblemTypeBase          = "PROBLEM_TYPE_BASE_URL"
+	EnvResetEmailSender         = "RESET_EMAIL_SENDER"
+	EnvResetTokenTTL            = "RESET_TOKEN_TTL"
+	EnvResetURL                 = "RESET_URL"
+	EnvSecurityHeaders          = "SECURITY_HEADERS"
+	EnvSeedEnabled              = "SEED_ENABLED"
+	EnvSessionEmailIndex        = "SESSION_EMAIL_INDEX"
+	EnvSessionTimeout           = "SESSION_TIMEOUT"
+	EnvSessionTTL               = "SESSION_TTL"
+	EnvScanConcurrency          = "SCAN_CONCURRENCY"
+	EnvScanMaxItems             = "SCAN_MAX_ITEMS"
+	EnvScanTimeBudget           = "SCAN_TIME_BUDGET"
+	EnvScanSegments             = "SCAN_SEGMENTS"
+	EnvSchemaCacheTTL           = "SCHEMA_CACHE_TTL"
+	EnvSchemaStrict             = "SCHEMA_STRICT"
+	EnvSearchEndpoint           = "SEARCH_ENDPOINT"
+	EnvSearchIndex              = "SEARCH_INDEX"
+	EnvSearchMaxAttempts        = "SEARCH_MAX_ATTEMPTS"
+	EnvSearchRetryDelay         = "SEARCH_RETRY_DELAY"
+	EnvSearchTimeout            = "SEARCH_TIMEOUT"
+	EnvSuggestCacheSize         = "SUGGEST_CACHE_SIZE"
+	EnvSuggestCacheTTL          = "SUGGEST_CACHE_TTL"
+	EnvSuggestTimeout           = "SUGGEST_TIMEOUT"
+	EnvTableName                = "TABLE_NAME"
+	EnvTenantCacheTTL           = "TENANT_CACHE_TTL"
+	EnvTenantHeader             = "TENANT_HEADER"
+	EnvTenantParameterPath      = "TENANT_PARAMETER_PATH"
+	EnvTenantTables             = "TENANT_TABLES"
+	EnvUserCacheSize            = "USER_CACHE_SIZE"
+	EnvUserCacheTTL             = "USER_CACHE_TTL"
+	EnvUserEventSourcing        = "USER_EVENT_SOURCING"
+	EnvVerifyEmailMX            = "VERIFY_EMAIL_MX"
+	EnvWebhookLogTTL            = "WEBHOOK_LOG_TTL"
+	EnvWebhookMaxAttempts       = "WEBHOOK_MAX_ATTEMPTS"
+	EnvWebhookRetryDelay        = "WEBHOOK_RETRY_DELAY"
+	EnvWebhookTimeout           = "WEBHOOK_TIMEOUT"
+	EnvWebhooksEnabled          = "WEBHOOKS_ENABLED"
+)
+
+var DefaultLogRedactFields = []string{"address", "dateOfBirth", "email", "firstName", "lastName", "phone"}
+
+// DefaultSecurityHeaders are sent on every response. The API only answers
+// JSON, so nothing it sends should be cached, framed or rendered.
+var DefaultSecurityHeaders = map[string]string{
+	"Cache-Control":             "no-store",
+	"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+	"Referrer-Policy":           "no-referrer",
+	"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+}
+
+// Deprecation is when something the API serves was deprecated and will be
+// removed, and where what replaces it is documented.
+type Deprecation struct {
+	Since  time.Time `json:"since"`
+	Sunset time.Time `json:"sunset"`
+	Link   string    `json:"link"`
+}
+
+type Config struct {
+	AdminKey                 string
+	AllowedEmailDomains      []string
+	AppConfigApplication     string
+	AppConfigEnvironment     string
+	AppConfigFlagsProfile    string
+	AppConfigPollInterval    time.Duration
+	AvatarBucket             string
+	AvatarMaxSize            int
+	AvatarURLExpiry          time.Duration
+	BackgroundScanRCU        float64
+	BackupPollInterval       time.Duration
+	BackupPollTimeout        time.Duration
+	BannedNames              []string
+	BlockDisposableEmails    bool
+	CircuitFailureRate       float64
+	CircuitHalfOpenCalls     int
+	CircuitMinRequests       int
+	CircuitOpenTimeout       time.Duration
+	CircuitWindow            time.Duration
+	CursorKey                string
+	DataLakeBucket           string
+	DataLakeFormat           string
+	DataLakePrefix           string
+	DAXEndpoint              string
+	DeadLetterMaxAttempts    int
+	DeadLetterQueueURL       string
+	DeadLetterRetryDelay     time.Duration
+	DeadlineMargin           time.Duration
+	Deprecations             map[string]Deprecation
+	DisposableEmailDomains   []string
+	DynamoMaxAttempts        int
+	DynamoRetryBaseDelay     time.Duration
+	DynamoRetryJitter        float64
+	DynamoRetryMaxDelay      time.Duration
+	DynamoRetryThrottles     bool
+	DynamoThrottleRetryAfter time.Duration
+	DynamoTimeout            time.Duration
+	GraphQLEnabled           bool
+	HTTPIdleConnTimeout      time.Duration
+	HTTPKeepAlive            time.Duration
+	HTTPMaxIdleConns         int
+	HTTPMaxIdleConnsPerHost  int
+	HTTPTLSHandshakeTimeout  time.Duration
+	EmailChangeKey           string
+	EmailChangeSender        string
+	EmailChangeTTL           time.Duration
+	EmailChangeURL           string
+	ExportBucket             string
+	ExportPartSize           int
+	ImportBucket             string
+	ImportPrefix             string
+	InvitationTTL            time.Duration
+	ListDefaultLimit         int
+	ListMaxLimit             int
+	LogRedactFields          []string
+	LogBodySampleRate        float64
+	LogDebugHeader           string
+	MaxBodySize              int
+	MetricsEnabled           bool
+	MetricsNamespace         string
+	SLOLatencyObjective      time.Duration
+	MFAEncryptionKey         string
+	MFAIssuer                string
+	MXCacheTTL               time.Duration
+	MXLookupTimeout          time.Duration
+	OAuthAudience            string
+	OAuthCacheTTL            time.Duration
+	OAuthClientID            string
+	OAuthClientSecret        string
+	OAuthIntrospectionURL    string
+	OAuthIssuer              string
+	OAuthJWKSURL             string
+	OAuthTimeout             time.Duration
+	OIDCClientID             string
+	OIDCHeader               string
+	OIDCIssuer               string
+	OIDCJWKSURL              string
+	OutboxEnabled            bool
+	OutboxEventBus           string
+	OutboxEventSource        string
+	OutboxTopicARN           string
+	ProblemDetails           bool
+	ProblemTypeBase          string
+	ProjectionRecentSize     int
+	ProvisionEmailSender     string
+	QuarantineBucket         string
+	QuarantinePrefix         string
+	QuotaEmailWrites         int
+	QuotaKeyHeader           string
+	QuotaKeyWrites           int
+	QuotaWindow              time.Duration
+	ResetEmailSender         string
+	ResetTokenTTL            time.Duration
+	ResetURL                 string
+	SecurityHeaders          map[string]string
+	SeedEnabled              bool
+	SessionEmailIndex        string
+	SessionTimeout           time.Duration
+	SessionTTL               time.Duration
+	ScanConcurrency          int
+	ScanMaxItems             int
+	ScanTimeBudget           time.Duration
+	ScanSegments             int
+	SchemaCacheTTL           time.Duration
+	SchemaStrict             bool
+	SearchEndpoint           string
+	SearchIndex              string
+	SearchMaxAttempts        int
+	SearchRetryDelay         time.Duration
+	SearchTimeout            time.Duration
+	SuggestCacheSize         int
+	SuggestCacheTTL          time.Duration
+	SuggestTimeout           time.Duration
+	TableName                string
+	TenantCacheTTL           time.Duration
+	TenantHeader             string
+	TenantParameterPath      string
+	TenantTables             map[string]string
+	UserCacheSize            int
+	UserCacheTTL             time.Duration
+	UserEventSourcing        bool
+	VerifyEmailMX            bool
+	WebhookLogTTL            time.Duration
+	WebhookMaxAttempts       int
+	WebhookRetryDelay        time.Duration
+	WebhookTimeout           time.Duration
+	WebhooksEnabled          bool
+}
+
+// Load reads the configuration from the environment, falling back to defaults
+// for anything that is not set.
+func Load() Config {
+	return Config{
+		AdminKey:                 os.Getenv(EnvAdminKey),
+		AllowedEmailDomains:      stringList(EnvAllowedEmailDomains, nil),
+		AppConfigApplication:     os.Getenv(EnvAppConfigApplication),
+		AppConfigEnvironment:     os.Getenv(EnvAppConfigEnvironment),
+		AppConfigFlagsProfile:    os.Getenv(EnvAppConfigFlagsProfile),
+		AppConfigPollInterval:    duration(EnvAppConfigPollInterval, 45*time.Second),
+		AvatarBucket:             os.Getenv(EnvAvatarBucket),
+		AvatarMaxSize:            integer(EnvAvatarMaxSize, 5*1024*1024),
+		AvatarURLExpiry:          duration(EnvAvatarURLExpiry, 15*time.Minute),
+		BackgroundScanRCU:        float(EnvBackgroundScanRCU, 0),
+		BackupPollInterval:       duration(EnvBackupPollInterval, time.Second),
+		BackupPollTimeout:        duration(EnvBackupPollTimeout, 10*time.Second),
+		BannedNames:              stringList(EnvBannedNames, nil),
+		BlockDisposableEmails:    boolean(EnvBlockDisposableEmails, false),
+		CircuitFailureRate:       float(EnvCircuitFailureRate, 0.5),
+		CircuitHalfOpenCalls:     integer(EnvCircuitHalfOpenCalls, 1),
+		CircuitMinRequests:       integer(EnvCircuitMinRequests, 10),
+		CircuitOpenTimeout:       duration(EnvCircuitOpenTimeout, 15*time.Second),
+		CircuitWindow:            duration(EnvCircuitWindow, 30*time.Second),
+		CursorKey:                os.Getenv(EnvCursorKey),
+		DataLakeBucket:           os.Getenv(EnvDataLakeBucket),
+		DataLakeFormat:           stringValue(EnvDataLakeFormat, "json"),
+		DataLakePrefix:           stringValue(EnvDataLakePrefix, "lake/users/"),
+		DAXEndpoint:              os.Getenv(EnvDAXEndpoint),
+		DeadLetterMaxAttempts:    integer(EnvDeadLetterMaxAttempts, 3),
+		DeadLetterQueueURL:       os.Getenv(EnvDeadLetterQueueURL),
+		DeadLetterRetryDelay:     duration(EnvDeadLetterRetryDelay, 200*time.Millisecond),
+		DeadlineMargin:           duration(EnvDeadlineMargin, 500*time.Millisecond),
+		Deprecations:             deprecations(EnvDeprecations),
+		DisposableEmailDomains:   stringList(EnvDisposableEmailDomains, []string{}),
+		DynamoMaxAttempts:        integer(EnvDynamoMaxAttempts, 4),
+		DynamoRetryBaseDelay:     duration(EnvDynamoRetryBaseDelay, 25*time.Millisecond),
+		DynamoRetryJitter:        float(EnvDynamoRetryJitter, 1),
+		DynamoRetryMaxDelay:      duration(EnvDynamoRetryMaxDelay, time.Second),
+		DynamoRetryThrottles:     boolean(EnvDynamoRetryThrottles, true),
+		DynamoThrottleRetryAfter: duration(EnvDynamoThrottleRetryAfter, time.Second),
+		DynamoTimeout:            duration(EnvDynamoTimeout, 3*time.Second),
+		GraphQLEnabled:           boolean(EnvGraphQLEnabled, false),
+		HTTPIdleConnTimeout:      duration(EnvHTTPIdleConnTimeout, 90*time.Second),
+		HTTPKeepAlive:            duration(EnvHTTPKeepAlive, 30*time.Second),
+		HTTPMaxIdleConns:         integer(EnvHTTPMaxIdleConns, 100),
+		HTTPMaxIdleConnsPerHost:  integer(EnvHTTPMaxIdleConnsPerHost, 100),
+		HTTPTLSHandshakeTimeout:  duration(EnvHTTPTLSHandshakeTimeout, 5*time.Second),
+		EmailChangeKey:           os.Getenv(EnvEmailChangeKey),
+		EmailChangeSender:        os.Getenv(EnvEmailChangeSender),
+		EmailChangeTTL:           duration(EnvEmailChangeTTL, 24*time.Hour),
+		EmailChangeURL:           os.Getenv(EnvEmailChangeURL),
+		ExportBucket:             os.Getenv(EnvExportBucket),
+		ExportPartSize:           integer(EnvExportPartSize, 8*1024*1024),
+		ImportBucket:             os.Getenv(EnvImportBucket),
+		ImportPrefix:             stringValue(EnvImportPrefix, "imports/"),
+		InvitationTTL:            duration(EnvInvitationTTL, 7*24*time.Hour),
+		ListDefaultLimit:         integer(EnvListDefaultLimit, 50),
+		ListMaxLimit:             integer(EnvListMaxLimit, 100),
+		LogRedactFields:          stringList(EnvLogRedactFields, DefaultLogRedactFields),
+		LogBodySampleRate:        float(EnvLogBodySampleRate, 1),
+		LogDebugHeader:           stringValue(EnvLogDebugHeader, "X-Debug-Log"),
+		MaxBodySize:              integer(EnvMaxBodySize, 64*1024),
+		MetricsEnabled:           boolean(EnvMetricsEnabled, true),
+		MetricsNamespace:         stringValue(EnvMetricsNamespace, "LambdaInGoUser"),
+		SLOLatencyObjective:      duration(EnvSLOLatencyObjective, time.Second),
+		MFAEncryptionKey:         os.Getenv(EnvMFAEncryptionKey),
+		MFAIssuer:                stringValue(EnvMFAIssuer, "LambdaInGo"),
+		MXCacheTTL:               duration(EnvMXCacheTTL, time.Hour),
+		MXLookupTimeout:          duration(EnvMXLookupTimeout, 2*time.Second),
+		OAuthAudience:            os.Getenv(EnvOAuthAudience),
+		OAuthCacheTTL:            duration(EnvOAuthCacheTTL, 5*time.Minute),
+		OAuthClientID:            os.Getenv(EnvOAuthClientID),
+		OAuthClientSecret:        os.Getenv(EnvOAuthClientSecret),
+		OAuthIntrospectionURL:    os.Getenv(EnvOAuthIntrospectionURL),
+		OAuthIssuer:              os.Getenv(EnvOAuthIssuer),
+		OAuthJWKSURL:             os.Getenv(EnvOAuthJWKSURL),
+		OAuthTimeout:             duration(EnvOAuthTimeout, 3*time.Second),
+		OIDCClientID:             os.Getenv(EnvOIDCClientID),
+		OIDCHeader:               stringValue(EnvOIDCHeader, "X-ID-Token"),
+		OIDCIssuer:               os.Getenv(EnvOIDCIssuer),
+		OIDCJWKSURL:              os.Getenv(EnvOIDCJWKSURL),
+		OutboxEnabled:            boolean(EnvOutboxEnabled, false),
+		OutboxEventBus:           os.Getenv(EnvOutboxEventBus),
+		OutboxEventSource:        stringValue(EnvOutboxEventSource, "lambda-in-go.users"),
+		OutboxTopicARN:           os.Getenv(EnvOutboxTopicARN),
+		ProblemDetails:           boolean(EnvProblemDetails, false),
+		ProblemTypeBase:          os.Getenv(EnvProblemTypeBase),
+		ProjectionRecentSize:     integer(EnvProjectionRecentSize, 50),
+		ProvisionEmailSender:     os.Getenv(EnvProvisionEmailSender),
+		QuarantineBucket:         os.Getenv(EnvQuarantineBucket),
+		QuarantinePrefix:         stringValue(EnvQuarantinePrefix, "quarantine/"),
+		QuotaEmailWrites:         integer(EnvQuotaEmailWrites, 0),
+		QuotaKeyHeader:           stringValue(EnvQuotaKeyHeader, "X-API-Key"),
+		QuotaKeyWrites:           integer(EnvQuotaKeyWrites, 0),
+		QuotaWindow:              duration(EnvQuotaWindow, time.Minute),
+		ResetEmailSender:         os.Getenv(EnvResetEmailSender),
+		ResetTokenTTL:            duration(EnvResetTokenTTL, time.Hour),
+		ResetURL:                 os.Getenv(EnvResetURL),
+		SecurityHeaders:          headerMap(EnvSecurityHeaders, DefaultSecurityHeaders),
+		SeedEnabled:              boolean(EnvSeedEnabled, false),
+		SessionEmailIndex:        stringValue(EnvSessionEmailIndex, "email-index"),
+		SessionTimeout:           duration(EnvSessionTimeout, 0),
+		SessionTTL:               duration(EnvSessionTTL, 24*time.Hour),
+		ScanConcurrency:          integer(EnvScanConcurrency, 4),
+		ScanMaxItems:             integer(EnvScanMaxItems, 10000),
+		ScanTimeBudget:           duration(EnvScanTimeBudget, 5*time.Second),
+		ScanSegments:             integer(EnvScanSegments, 1),
+		SchemaCacheTTL:           duration(EnvSchemaCacheTTL, time.Minute),
+		SchemaStrict:             boolean(EnvSchemaStrict, false),
+		SearchEndpoint:           os.Getenv(EnvSearchEndpoint),
+		SearchIndex:              stringValue(EnvSearchIndex, "users"),
+		SearchMaxAttempts:        integer(EnvSearchMaxAttempts, 3),
+		SearchRetryDelay:         duration(EnvSearchRetryDelay, 200*time.Millisecond),
+		SearchTimeout:            duration(EnvSearchTimeout, 2*time.Second),
+		SuggestCacheSize:         integer(EnvSuggestCacheSize, 1000),
+		SuggestCacheTTL:          duration(EnvSuggestCacheTTL, 30*time.Second),
+		SuggestTimeout:           duration(EnvSuggestTimeout, 300*time.Millisecond),
+		TableName:                stringValue(EnvTableName, "LambdaInGoUser"),
+		TenantCacheTTL:           duration(EnvTenantCacheTTL, 5*time.Minute),
+		TenantHeader:             stringValue(EnvTenantHeader, "X-Tenant-ID"),
+		TenantParameterPath:      os.Getenv(EnvTenantParameterPath),
+		TenantTables:             stringMap(EnvTenantTables),
+		UserCacheSize:            integer(EnvUserCacheSize, 0),
+		UserCacheTTL:             duration(EnvUserCacheTTL, time.Minute),
+		UserEventSourcing:        boolean(EnvUserEventSourcing, false),
+		VerifyEmailMX:            boolean(EnvVerifyEmailMX, false),
+		WebhookLogTTL:            duration(EnvWebhookLogTTL, 30*24*time.Hour),
+		WebhookMaxAttempts:       integer(EnvWebhookMaxAttempts, 3),
+		WebhookRetryDelay:        duration(EnvWebhookRetryDelay, 200*time.Millisecond),
+		WebhookTimeout:           duration(EnvWebhookTimeout, 5*time.Second),
+		WebhooksEnabled:          boolean(EnvWebhooksEnabled, false),
+	}
+}
+
+func stringList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// headerMap reads a JSON object of headers, such as {"X-Frame-Options":
+// "SAMEORIGIN"}, over fallback. A header set to "" is left out.
+func headerMap(key string, fallback map[string]string) map[string]string {
+	headers := map[string]string{}
+	for name, value := range fallback {
+		headers[name] = value
+	}
+	var set map[string]string
+	if err := json.Unmarshal([]byte(os.Getenv(key)), &set); err != nil {
+		return headers
+	}
+	for name, value := range set {
+		if value == "" {
+			delete(headers, name)
+		} else {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// stringMap reads a JSON object of strings, such as {"acme": "AcmeUser"}.
+// Anything else reads as no entries.
+func stringMap(key string) map[string]string {
+	entries := map[string]string{}
+	if err := json.Unmarshal([]byte(os.Getenv(key)), &entries); err != nil {
+		return map[string]string{}
+	}
+	return entries
+}
+
+// deprecations reads a JSON object of deprecations by what is deprecated,
+// such as {"v1": {"since": "2026-07-01T00:00:00Z", "sunset":
+// "2027-01-01T00:00:00Z", "link": "https://example.com/migrating"}}.
+// Anything else reads as no entries.
+func deprecations(key string) map[string]Deprecation {
+	entries := map[string]Deprecation{}
+	if err := json.Unmarshal([]byte(os.Getenv(key)), &entries); err != nil {
+		return map[string]Deprecation{}
+	}
+	return entries
+}
+
+func stringValue(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func boolean(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func duration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func float(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func integer(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,226 @@
+package credentials
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxPasswordBytes is the longest password bcrypt hashes in full.
+const MaxPasswordBytes = 72
+
+var (
+	ErrorFailedToDeleteCredentials    = "failed to delete credentials"
+	ErrorFailedToFetchCredentials     = "failed to fetch credentials"
+	ErrorFailedToHashPassword         = "failed to hash password"
+	ErrorFailedToSaveCredentials      = "failed to save credentials"
+	ErrorFailedToUnmarshalCredentials = "failed to unmarshal credentials"
+	ErrorInvalidCredentials           = "invalid credentials"
+	ErrorPasswordNotSet               = "password has not been set"
+)
+
+// Config sets the bcrypt cost passwords are hashed with.
+type Config struct {
+	Cost int
+}
+
+var config = Config{Cost: bcrypt.DefaultCost}
+
+func Configure(c Config) {
+	config = c
+}
+
+// Credentials are kept apart from the user, in their own table, so nothing
+// that reads or returns users can expose them. They are never serialised to
+// JSON.
+type Credentials struct {
+	Email        string `json:"-" dynamodbav:"email"`
+	PasswordHash string `json:"-" dynamodbav:"passwordHash"`
+	UpdatedAt    string `json:"-" dynamodbav:"updatedAt"`
+	// The outstanding password reset, if any
+	ResetTokenHash string `json:"-" dynamodbav:"resetTokenHash,omitempty"`
+	ResetExpiresAt string `json:"-" dynamodbav:"resetExpiresAt,omitempty"`
+	// MFASecret is the TOTP secret, encrypted with the MFA key
+	MFASecret   string `json:"-" dynamodbav:"mfaSecret,omitempty"`
+	MFAEnabled  bool   `json:"-" dynamodbav:"mfaEnabled"`
+	MFALastStep int64  `json:"-" dynamodbav:"mfaLastStep,omitempty"`
+	// MFAPendingSecret is a secret enrolled but not yet confirmed, which
+	// replaces MFASecret once a code from it is
+	MFAPendingSecret string `json:"-" dynamodbav:"mfaPendingSecret,omitempty"`
+}
+
+// password validates a new password.
+type password struct {
+	Password string `json:"password" validate:"required,min=12,max=72"`
+}
+
+// TableName returns the credentials table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Credentials"
+}
+
+// HasPassword reports whether a password has been set for email.
+func HasPassword(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, error) {
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return false, err
+	}
+	return creds != nil && creds.PasswordHash != "", nil
+}
+
+// SetPassword hashes and stores a new password for email, returning
+// validators.FieldErrors when it does not meet the password rules. Any
+// outstanding password reset is cancelled.
+func SetPassword(email string, newPassword string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return setPassword(email, newPassword, nil, userTableName, dynaClient)
+}
+
+// ValidatePassword returns validators.FieldErrors when newPassword does not
+// meet the password rules, so it can be checked before anything is saved.
+func ValidatePassword(newPassword string) error {
+	if errs := validators.ValidateStruct(password{newPassword}); errs != nil {
+		return errs
+	}
+	if len(newPassword) > MaxPasswordBytes {
+		return validators.FieldErrors{{
+			Field:   "password",
+			Rule:    validators.RuleMax,
+			Code:    validators.CodeTooLong,
+			Message: "must be at most 72 characters",
+			Param:   "72",
+		}}
+	}
+	return nil
+}
+
+// setPassword updates rather than replaces the credentials, keeping the other
+// attributes stored with the password, and only when condition holds if one
+// is given.
+func setPassword(email string, newPassword string, condition *expression, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), config.Cost)
+	if err != nil {
+		return errors.New(ErrorFailedToHashPassword)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		Key:              key(email),
+		TableName:        aws.String(TableName(userTableName)),
+		UpdateExpression: aws.String("SET passwordHash = :hash, updatedAt = :updatedAt REMOVE resetTokenHash, resetExpiresAt"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":hash":      {S: aws.String(string(hash))},
+			":updatedAt": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	}
+	if condition != nil {
+		input.ConditionExpression = aws.String(condition.text)
+		for name, value := range condition.values {
+			input.ExpressionAttributeValues[name] = value
+		}
+	}
+	_, err = dynaClient.UpdateItem(input)
+	if err != nil {
+		var awsErr awserr.Error
+		if condition != nil && errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(condition.failure)
+		}
+		return store.Error(err, ErrorFailedToSaveCredentials)
+	}
+	return nil
+}
+
+// expression is a condition an update must meet, and the error reported
+// when it does not.
+type expression struct {
+	text    string
+	values  map[string]*dynamodb.AttributeValue
+	failure string
+}
+
+func key(email string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"email": {S: aws.String(email)},
+	}
+}
+
+// VerifyPassword checks password against the one stored for email. Unknown
+// emails and wrong passwords both fail with ErrorInvalidCredentials, and take
+// as long, so callers cannot tell which emails have a password.
+func VerifyPassword(email string, candidate string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	return checkPassword(creds, candidate)
+}
+
+// checkPassword compares candidate with the hash in creds, which may be nil.
+func checkPassword(creds *Credentials, candidate string) error {
+	stored := creds != nil && creds.PasswordHash != ""
+	hash := dummyHash()
+	if stored {
+		hash = []byte(creds.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(candidate)); err != nil || !stored {
+		return errors.New(ErrorInvalidCredentials)
+	}
+	return nil
+}
+
+// Delete removes the credentials of email.
+func Delete(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       key(email),
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return store.Error(err, ErrorFailedToDeleteCredentials)
+	}
+	return nil
+}
+
+// fetch returns nil when no credentials are stored for email.
+func fetch(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Credentials, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:            key(email),
+		TableName:      aws.String(TableName(userTableName)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchCredentials)
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	creds := new(Credentials)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, creds); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalCredentials)
+	}
+	return creds, nil
+}
+
+var (
+	dummy     []byte
+	dummyOnce sync.Once
+)
+
+// dummyHash is compared against when there is no stored hash, hashed at the
+// configured cost so the comparison takes as long as a real one.
+func dummyHash() []byte {
+	dummyOnce.Do(func() {
+		dummy, _ = bcrypt.GenerateFromPassword([]byte("not a password"), config.Cost)
+	})
+	return dummy
+}
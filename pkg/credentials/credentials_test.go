@@ -0,0 +1,119 @@
+package credentials
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tableClient keeps the credentials table in memory, and the audit entries
+// put alongside it.
+type tableClient struct {
+	dynamodbiface.DynamoDBAPI
+	items   map[string]map[string]*dynamodb.AttributeValue
+	entries []map[string]*dynamodb.AttributeValue
+}
+
+func (c *tableClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	c.entries = append(c.entries, input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *tableClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: c.items[*input.Key["email"].S]}, nil
+}
+
+// UpdateItem applies "SET a = :a, ... REMOVE b, ..." updates.
+func (c *tableClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	email := *input.Key["email"].S
+	item, ok := c.items[email]
+	if !ok {
+		item = map[string]*dynamodb.AttributeValue{"email": input.Key["email"]}
+	}
+	if input.ConditionExpression != nil && !c.holds(item, *input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+	}
+	update := *input.UpdateExpression
+	remove := ""
+	if i := strings.Index(update, " REMOVE "); i >= 0 {
+		update, remove = update[:i], update[i+len(" REMOVE "):]
+	}
+	for _, assignment := range strings.Split(strings.TrimPrefix(update, "SET "), ", ") {
+		parts := strings.Split(assignment, " = ")
+		item[parts[0]] = input.ExpressionAttributeValues[parts[1]]
+	}
+	if remove != "" {
+		for _, name := range strings.Split(remove, ", ") {
+			delete(item, name)
+		}
+	}
+	c.items[email] = item
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// holds checks the "a = :a" and "attribute_not_exists(a) OR a < :a"
+// conditions the package uses.
+func (c *tableClient) holds(item map[string]*dynamodb.AttributeValue, condition string, values map[string]*dynamodb.AttributeValue) bool {
+	if strings.HasPrefix(condition, "attribute_not_exists(") {
+		parts := strings.Split(condition[strings.Index(condition, " OR ")+len(" OR "):], " < ")
+		current, ok := item[parts[0]]
+		if !ok {
+			return true
+		}
+		have, _ := strconv.ParseInt(*current.N, 10, 64)
+		want, _ := strconv.ParseInt(*values[parts[1]].N, 10, 64)
+		return have < want
+	}
+	parts := strings.Split(condition, " = ")
+	current, ok := item[parts[0]]
+	return ok && *current.S == *values[parts[1]].S
+}
+
+func TestPasswords(t *testing.T) {
+	Configure(Config{Cost: bcrypt.MinCost})
+	defer Configure(Config{Cost: bcrypt.DefaultCost})
+	client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+
+	t.Run("expect a password to be hashed and verified", func(t *testing.T) {
+		if err := SetPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		stored := *client.items["alan.oliver@ecs.co.uk"]["passwordHash"].S
+		if strings.Contains(stored, "correct horse battery") {
+			t.Error("Expected only the hash to be stored")
+		}
+		if err := VerifyPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client); err != nil {
+			t.Errorf("Expected the password to verify, got %s", err.Error())
+		}
+	})
+	t.Run("expect wrong passwords and unknown emails to fail alike", func(t *testing.T) {
+		for _, email := range []string{"alan.oliver@ecs.co.uk", "alan.shearer@ecs.co.uk"} {
+			err := VerifyPassword(email, "wrong horse battery", "test", client)
+			if err == nil || err.Error() != ErrorInvalidCredentials {
+				t.Errorf("Expected error %s for %s, got %v", ErrorInvalidCredentials, email, err)
+			}
+		}
+	})
+	t.Run("expect short and overlong passwords to be rejected", func(t *testing.T) {
+		for _, password := range []string{"short", strings.Repeat("é", 40)} {
+			err := SetPassword("alan.oliver@ecs.co.uk", password, "test", client)
+			if _, ok := err.(validators.FieldErrors); !ok {
+				t.Errorf("Expected validation errors for %q, got %v", password, err)
+			}
+		}
+	})
+	t.Run("expect credentials never to be serialised", func(t *testing.T) {
+		body, _ := json.Marshal(Credentials{Email: "alan.oliver@ecs.co.uk", PasswordHash: "$2a$10$hash"})
+		if string(body) != "{}" {
+			t.Errorf("Expected nothing to be serialised, got %s", body)
+		}
+	})
+}
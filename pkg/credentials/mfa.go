@@ -0,0 +1,254 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seal"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToEnrollMFA = "failed to enroll multi-factor authentication"
+	ErrorInvalidMFACode    = "invalid multi-factor authentication code"
+	ErrorMFANotConfigured  = "multi-factor authentication is not configured"
+	ErrorMFANotEnrolled    = "multi-factor authentication is not enrolled"
+	ErrorMFARequired       = "multi-factor authentication code required"
+)
+
+// MFAConfig holds the key TOTP secrets are sealed with at rest, and the
+// issuer authenticator apps show.
+type MFAConfig struct {
+	Key    []byte
+	Issuer string
+}
+
+// mfaPurpose prefixes the email a secret is sealed for.
+const mfaPurpose = "mfa:"
+
+var mfa MFAConfig
+
+func ConfigureMFA(config MFAConfig) {
+	mfa = config
+}
+
+// Enrollment is returned once when MFA is enrolled, for the user to add to
+// their authenticator app, usually by scanning URI as a QR code.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// EnrollMFA creates a new TOTP secret for email after checking their
+// password and, when they have already enabled MFA, a code from the secret
+// they confirmed, so the password alone cannot replace it. The new secret is
+// kept pending: whatever was confirmed before stays in use, MFA enabled, until
+// a code from the new secret is confirmed with ConfirmMFA. Enrolling again
+// before then replaces the pending secret.
+func EnrollMFA(email string, password string, code string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Enrollment, error) {
+	return EnrollMFAAs(email, password, code, audit.Actor{}, userTableName, dynaClient)
+}
+
+// EnrollMFAAs enrolls MFA as EnrollMFA does, recording actor as who last
+// changed the credentials and in the user's history.
+func EnrollMFAAs(email string, password string, code string, actor audit.Actor, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Enrollment, error) {
+	if len(mfa.Key) == 0 {
+		return nil, errors.New(ErrorMFANotConfigured)
+	}
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(creds, password); err != nil {
+		return nil, err
+	}
+	if creds.MFAEnabled {
+		if code == "" {
+			return nil, errors.New(ErrorMFARequired)
+		}
+		if err := useCode(creds, code, false, userTableName, dynaClient); err != nil {
+			return nil, err
+		}
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.New(ErrorFailedToEnrollMFA)
+	}
+	encrypted, err := encrypt(secret, email)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToEnrollMFA)
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":secret": {S: aws.String(encrypted)},
+	}
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       key(email),
+		TableName:                 aws.String(TableName(userTableName)),
+		UpdateExpression:          aws.String(audit.UpdatedBy("SET mfaPendingSecret = :secret", values, actor)),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToEnrollMFA)
+	}
+	recordAudit(email, map[string]string{"mfa": "enrolled"}, actor, userTableName, dynaClient)
+	return &Enrollment{
+		Secret: secretEncoding.EncodeToString(secret),
+		URI:    provisioningURI(mfa.Issuer, email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA for email once they have sent a valid code from the
+// secret they enrolled, which then replaces any they confirmed before.
+func ConfirmMFA(email string, code string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	return ConfirmMFAAs(email, code, audit.Actor{}, userTableName, dynaClient)
+}
+
+// ConfirmMFAAs confirms MFA as ConfirmMFA does, recording actor as who last
+// changed the credentials and in the user's history.
+func ConfirmMFAAs(email string, code string, actor audit.Actor, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if creds == nil || (creds.MFAPendingSecret == "" && creds.MFASecret == "") {
+		return errors.New(ErrorMFANotEnrolled)
+	}
+	if creds.MFAPendingSecret == "" {
+		// Enrolled before secrets were kept pending, so the secret to
+		// confirm is the one stored but not yet enabled
+		err = useCode(creds, code, true, userTableName, dynaClient)
+	} else {
+		err = confirmPending(creds, code, actor, userTableName, dynaClient)
+	}
+	if err != nil {
+		return err
+	}
+	recordAudit(email, map[string]string{"mfa": "enabled"}, actor, userTableName, dynaClient)
+	return nil
+}
+
+// recordAudit is best effort, as the change to the credentials has already
+// been made.
+func recordAudit(email string, data map[string]string, actor audit.Actor, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	_ = audit.RecordAs(email, audit.ActionUpdated, data, actor, audit.TableName(userTableName), dynaClient)
+}
+
+// confirmPending makes the pending secret the one in use once code is valid
+// for it, recording the code's time step so it cannot be used again. The
+// update only applies while the secret checked is still the pending one.
+func confirmPending(creds *Credentials, code string, actor audit.Actor, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if len(mfa.Key) == 0 {
+		return errors.New(ErrorMFANotConfigured)
+	}
+	secret, err := decrypt(creds.MFAPendingSecret, creds.Email)
+	if err != nil {
+		return errors.New(ErrorInvalidMFACode)
+	}
+	step, ok := totpStep(secret, code, time.Now())
+	if !ok {
+		return errors.New(ErrorInvalidMFACode)
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":secret":  {S: aws.String(creds.MFAPendingSecret)},
+		":enabled": {BOOL: aws.Bool(true)},
+		":step":    {N: aws.String(strconv.FormatInt(step, 10))},
+	}
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       key(creds.Email),
+		TableName:                 aws.String(TableName(userTableName)),
+		UpdateExpression:          aws.String(audit.UpdatedBy("SET mfaSecret = :secret, mfaEnabled = :enabled, mfaLastStep = :step REMOVE mfaPendingSecret", values, actor)),
+		ConditionExpression:       aws.String("mfaPendingSecret = :secret"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if store.IsConditionFailed(err) {
+			return errors.New(ErrorInvalidMFACode)
+		}
+		return store.Error(err, ErrorFailedToSaveCredentials)
+	}
+	return nil
+}
+
+// Authenticate verifies the password of email and, when they have enabled
+// MFA, the code from their authenticator app.
+func Authenticate(email string, password string, code string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(creds, password); err != nil {
+		return err
+	}
+	if !creds.MFAEnabled {
+		return nil
+	}
+	if code == "" {
+		return errors.New(ErrorMFARequired)
+	}
+	return useCode(creds, code, false, userTableName, dynaClient)
+}
+
+// useCode checks code against the user's secret and records the time step it
+// was valid for, so the same code cannot be used again, enabling MFA in the
+// same update when enable is set.
+func useCode(creds *Credentials, code string, enable bool, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if len(mfa.Key) == 0 {
+		return errors.New(ErrorMFANotConfigured)
+	}
+	secret, err := decrypt(creds.MFASecret, creds.Email)
+	if err != nil {
+		return errors.New(ErrorInvalidMFACode)
+	}
+	step, ok := totpStep(secret, code, time.Now())
+	if !ok {
+		return errors.New(ErrorInvalidMFACode)
+	}
+
+	update := "SET mfaLastStep = :step"
+	values := map[string]*dynamodb.AttributeValue{
+		":step": {N: aws.String(strconv.FormatInt(step, 10))},
+	}
+	if enable {
+		update += ", mfaEnabled = :enabled"
+		values[":enabled"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       key(creds.Email),
+		TableName:                 aws.String(TableName(userTableName)),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_not_exists(mfaLastStep) OR mfaLastStep < :step"),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrorInvalidMFACode)
+		}
+		return store.Error(err, ErrorFailedToSaveCredentials)
+	}
+	return nil
+}
+
+// encrypt seals secret for the user with email, so a secret copied onto
+// another user's credentials does not open.
+func encrypt(secret []byte, email string) (string, error) {
+	return seal.Seal(mfa.Key, mfaPurpose+email, secret)
+}
+
+func decrypt(sealed string, email string) ([]byte, error) {
+	var secret []byte
+	if err := seal.Open(mfa.Key, mfaPurpose+email, sealed, &secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
@@ -0,0 +1,162 @@
+package credentials
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	}
+	for unix, expected := range cases {
+		if got := totpCode(secret, unix/totpPeriod); got != expected {
+			t.Errorf("expected %s at %d, got %s", expected, unix, got)
+		}
+	}
+}
+
+func TestMFA(t *testing.T) {
+	Configure(Config{Cost: bcrypt.MinCost})
+	defer Configure(Config{Cost: bcrypt.DefaultCost})
+	ConfigureMFA(MFAConfig{Key: []byte("0123456789abcdef0123456789abcdef"), Issuer: "LambdaInGo"})
+	defer ConfigureMFA(MFAConfig{})
+
+	enroll := func(t *testing.T) (*tableClient, []byte) {
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		SetPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client)
+		enrollment, err := EnrollMFA("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		secret, err := secretEncoding.DecodeString(enrollment.Secret)
+		if err != nil {
+			t.Fatalf("Expected a base32 secret, got %s", enrollment.Secret)
+		}
+		return client, secret
+	}
+	now := func(secret []byte) string {
+		return totpCode(secret, time.Now().Unix()/totpPeriod)
+	}
+
+	t.Run("expect the secret to be encrypted and provisioned", func(t *testing.T) {
+		enrollment, _ := EnrollMFA("alan.oliver@ecs.co.uk", "wrong horse battery", "", "test", &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}})
+		if enrollment != nil {
+			t.Error("Expected enrollment to need the password")
+		}
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		SetPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client)
+		enrollment, err := EnrollMFA("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if strings.Contains(*client.items["alan.oliver@ecs.co.uk"]["mfaPendingSecret"].S, enrollment.Secret) {
+			t.Error("Expected the secret to be stored encrypted")
+		}
+		uri, err := url.Parse(enrollment.URI)
+		if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret {
+			t.Errorf("Expected an otpauth URI carrying the secret, got %s", enrollment.URI)
+		}
+	})
+	t.Run("expect enrolling and confirming to record who made them", func(t *testing.T) {
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		SetPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client)
+		actor := audit.Actor{Kind: audit.ActorCognito, ID: "alan"}
+		enrollment, err := EnrollMFAAs("alan.oliver@ecs.co.uk", "correct horse battery", "", actor, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		secret, _ := secretEncoding.DecodeString(enrollment.Secret)
+		if err := ConfirmMFAAs("alan.oliver@ecs.co.uk", now(secret), actor, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		item := client.items["alan.oliver@ecs.co.uk"]
+		if item["updatedBy"] == nil || *item["updatedBy"].S != actor.String() || item["mfaPendingSecret"] != nil {
+			t.Errorf("Expected the credentials to be updated by %s, got %v", actor.String(), item)
+		}
+		if len(client.entries) != 2 || *client.entries[1]["actor"].S != actor.String() {
+			t.Errorf("Expected both changes to be audited as made by %s, got %v", actor.String(), client.entries)
+		}
+	})
+	t.Run("expect login to need a code only once MFA is confirmed", func(t *testing.T) {
+		client, secret := enroll(t)
+		if err := Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client); err != nil {
+			t.Fatalf("Expected no code to be needed before confirming, got %s", err.Error())
+		}
+		if err := ConfirmMFA("alan.oliver@ecs.co.uk", now(secret), "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		err := Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client)
+		if err == nil || err.Error() != ErrorMFARequired {
+			t.Errorf("Expected error %s, got %v", ErrorMFARequired, err)
+		}
+		err = Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", "000000", "test", client)
+		if err == nil || err.Error() != ErrorInvalidMFACode {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidMFACode, err)
+		}
+	})
+	t.Run("expect a code to be used only once", func(t *testing.T) {
+		client, secret := enroll(t)
+		// Confirm with the previous period's code so the current one is
+		// still unused
+		previous := totpCode(secret, time.Now().Unix()/totpPeriod-1)
+		if err := ConfirmMFA("alan.oliver@ecs.co.uk", previous, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		code := now(secret)
+		if err := Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", code, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		err := Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", code, "test", client)
+		if err == nil || err.Error() != ErrorInvalidMFACode {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidMFACode, err)
+		}
+	})
+	t.Run("expect enrolling again to keep MFA enabled until the new secret is confirmed", func(t *testing.T) {
+		client, secret := enroll(t)
+		previous := totpCode(secret, time.Now().Unix()/totpPeriod-1)
+		if err := ConfirmMFA("alan.oliver@ecs.co.uk", previous, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		_, err := EnrollMFA("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client)
+		if err == nil || err.Error() != ErrorMFARequired {
+			t.Fatalf("Expected the password alone to be refused with %s, got %v", ErrorMFARequired, err)
+		}
+		enrollment, err := EnrollMFA("alan.oliver@ecs.co.uk", "correct horse battery", now(secret), "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		err = Authenticate("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", client)
+		if err == nil || err.Error() != ErrorMFARequired {
+			t.Errorf("Expected MFA to stay enabled while the new secret is pending, got %v", err)
+		}
+		replacement, _ := secretEncoding.DecodeString(enrollment.Secret)
+		if err := ConfirmMFA("alan.oliver@ecs.co.uk", totpCode(replacement, time.Now().Unix()/totpPeriod+1), "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		stored := client.items["alan.oliver@ecs.co.uk"]
+		if _, pending := stored["mfaPendingSecret"]; pending || !*stored["mfaEnabled"].BOOL {
+			t.Errorf("Expected the new secret to be in use and MFA enabled, got %v", stored)
+		}
+	})
+	t.Run("expect error when MFA is not configured", func(t *testing.T) {
+		ConfigureMFA(MFAConfig{})
+		defer ConfigureMFA(MFAConfig{Key: []byte("0123456789abcdef0123456789abcdef"), Issuer: "LambdaInGo"})
+		_, err := EnrollMFA("alan.oliver@ecs.co.uk", "correct horse battery", "", "test", &tableClient{})
+		if err == nil || err.Error() != ErrorMFANotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorMFANotConfigured, err)
+		}
+	})
+}
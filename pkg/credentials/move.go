@@ -0,0 +1,76 @@
+package credentials
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// MoveItems returns the transaction writes that move the credentials of from
+// to the email to, or none when from has no credentials. The MFA secret is
+// sealed again for the new email, and any outstanding password reset is
+// dropped as its link carries the old email, as is a secret enrolled but not
+// yet confirmed, which the user enrolls again.
+func MoveItems(from string, to string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	creds, err := fetch(from, userTableName, dynaClient)
+	if err != nil || creds == nil {
+		return nil, err
+	}
+	if creds.MFASecret != "" {
+		if len(mfa.Key) == 0 {
+			return nil, errors.New(ErrorMFANotConfigured)
+		}
+		secret, err := decrypt(creds.MFASecret, from)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToSaveCredentials)
+		}
+		if creds.MFASecret, err = encrypt(secret, to); err != nil {
+			return nil, errors.New(ErrorFailedToSaveCredentials)
+		}
+	}
+	creds.Email = to
+	creds.ResetTokenHash, creds.ResetExpiresAt = "", ""
+	creds.MFAPendingSecret = ""
+	av, err := dynamodbattribute.MarshalMap(creds)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveCredentials)
+	}
+	table := aws.String(TableName(userTableName))
+	return []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           table,
+			ConditionExpression: aws.String("attribute_not_exists(email)"),
+		}},
+		{Delete: &dynamodb.Delete{
+			Key:       key(from),
+			TableName: table,
+		}},
+	}, nil
+}
+
+// MergeItems returns the transaction writes that fold the credentials of from
+// into those of into, for users being merged: moved as MoveItems would when
+// into has none, and otherwise deleted, into keeping its own.
+func MergeItems(from string, into string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	kept, err := fetch(into, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if kept == nil {
+		return MoveItems(from, into, userTableName, dynaClient)
+	}
+	creds, err := fetch(from, userTableName, dynaClient)
+	if err != nil || creds == nil {
+		return nil, err
+	}
+	return []*dynamodb.TransactWriteItem{
+		{Delete: &dynamodb.Delete{
+			Key:       key(from),
+			TableName: aws.String(TableName(userTableName)),
+		}},
+	}, nil
+}
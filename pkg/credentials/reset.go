@@ -0,0 +1,137 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+var (
+	ErrorFailedToSendResetEmail     = "failed to send password reset email"
+	ErrorInvalidResetToken          = "invalid or expired password reset token"
+	ErrorPasswordResetNotConfigured = "password reset is not configured"
+)
+
+// ResetConfig sets how password reset tokens are delivered. The token and
+// email are added to the query of URL, the page the emailed link opens.
+type ResetConfig struct {
+	SES    sesiface.SESAPI
+	Sender string
+	URL    string
+	TTL    time.Duration
+}
+
+var resets ResetConfig
+
+func ConfigureReset(config ResetConfig) {
+	resets = config
+}
+
+// ResetEnabled reports whether reset emails can be sent.
+func ResetEnabled() bool {
+	return resets.SES != nil
+}
+
+// RequestReset emails a single use reset link to email. Only a hash of the
+// token is stored, and requesting another reset replaces it, so only the
+// latest link works.
+func RequestReset(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if !ResetEnabled() {
+		return errors.New(ErrorPasswordResetNotConfigured)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.New(ErrorFailedToSaveCredentials)
+	}
+	token := hex.EncodeToString(b)
+
+	_, err := dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:              key(email),
+		TableName:        aws.String(TableName(userTableName)),
+		UpdateExpression: aws.String("SET resetTokenHash = :hash, resetExpiresAt = :expiresAt"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":hash":      {S: aws.String(hashToken(token))},
+			":expiresAt": {S: aws.String(time.Now().UTC().Add(resets.TTL).Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return store.Error(err, ErrorFailedToSaveCredentials)
+	}
+	return sendResetEmail(email, token)
+}
+
+// ConfirmReset sets a new password for email when token is the outstanding,
+// unexpired reset token. Setting the password uses up the token.
+func ConfirmReset(email string, token string, newPassword string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	creds, err := fetch(email, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if creds == nil || creds.ResetTokenHash == "" {
+		return errors.New(ErrorInvalidResetToken)
+	}
+	hash := hashToken(token)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(creds.ResetTokenHash)) != 1 {
+		return errors.New(ErrorInvalidResetToken)
+	}
+	expires, err := time.Parse(time.RFC3339, creds.ResetExpiresAt)
+	if err != nil || !time.Now().Before(expires) {
+		return errors.New(ErrorInvalidResetToken)
+	}
+	// The condition stops a token being used twice by concurrent requests
+	return setPassword(email, newPassword, &expression{
+		text:    "resetTokenHash = :token",
+		values:  map[string]*dynamodb.AttributeValue{":token": {S: aws.String(hash)}},
+		failure: ErrorInvalidResetToken,
+	}, userTableName, dynaClient)
+}
+
+func sendResetEmail(email string, token string) error {
+	link, err := url.Parse(resets.URL)
+	if err != nil {
+		return errors.New(ErrorPasswordResetNotConfigured)
+	}
+	query := link.Query()
+	query.Set("email", email)
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	body := strings.Join([]string{
+		"Someone asked to reset the password for this email address.",
+		"",
+		"Follow this link within " + resets.TTL.String() + " to choose a new password:",
+		link.String(),
+		"",
+		"If you did not ask to reset your password you can ignore this email.",
+	}, "\n")
+	_, err = resets.SES.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(resets.Sender),
+		Destination: &ses.Destination{ToAddresses: aws.StringSlice([]string{email})},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String("Reset your password")},
+			Body:    &ses.Body{Text: &ses.Content{Data: aws.String(body)}},
+		},
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToSendResetEmail)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
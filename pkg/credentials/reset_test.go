@@ -0,0 +1,104 @@
+package credentials
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type mockSES struct {
+	sesiface.SESAPI
+	sent []*ses.SendEmailInput
+}
+
+func (m *mockSES) SendEmail(input *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+	m.sent = append(m.sent, input)
+	return &ses.SendEmailOutput{}, nil
+}
+
+// token reads the reset token from the link in the last email sent.
+func (m *mockSES) token(t *testing.T) string {
+	body := *m.sent[len(m.sent)-1].Message.Body.Text.Data
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "https://") {
+			link, err := url.Parse(line)
+			if err != nil {
+				t.Fatalf("Expected a valid link, got %s", line)
+			}
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("Expected a link in %q", body)
+	return ""
+}
+
+func configureTestReset(ttl time.Duration) *mockSES {
+	client := &mockSES{}
+	ConfigureReset(ResetConfig{SES: client, Sender: "no-reply@ecs.co.uk", URL: "https://example.com/reset", TTL: ttl})
+	return client
+}
+
+func TestReset(t *testing.T) {
+	Configure(Config{Cost: bcrypt.MinCost})
+	defer Configure(Config{Cost: bcrypt.DefaultCost})
+	defer ConfigureReset(ResetConfig{})
+
+	t.Run("expect a reset token to set the password once", func(t *testing.T) {
+		mail := configureTestReset(time.Hour)
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		SetPassword("alan.oliver@ecs.co.uk", "correct horse battery", "test", client)
+
+		if err := RequestReset("alan.oliver@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		token := mail.token(t)
+		if strings.Contains(*client.items["alan.oliver@ecs.co.uk"]["resetTokenHash"].S, token) {
+			t.Error("Expected only a hash of the token to be stored")
+		}
+		if err := ConfirmReset("alan.oliver@ecs.co.uk", token, "battery staple horse", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if err := VerifyPassword("alan.oliver@ecs.co.uk", "battery staple horse", "test", client); err != nil {
+			t.Errorf("Expected the new password to verify, got %s", err.Error())
+		}
+		err := ConfirmReset("alan.oliver@ecs.co.uk", token, "another horse battery", "test", client)
+		if err == nil || err.Error() != ErrorInvalidResetToken {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidResetToken, err)
+		}
+	})
+	t.Run("expect a new request to replace the earlier token", func(t *testing.T) {
+		mail := configureTestReset(time.Hour)
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		RequestReset("alan.oliver@ecs.co.uk", "test", client)
+		first := mail.token(t)
+		RequestReset("alan.oliver@ecs.co.uk", "test", client)
+
+		err := ConfirmReset("alan.oliver@ecs.co.uk", first, "battery staple horse", "test", client)
+		if err == nil || err.Error() != ErrorInvalidResetToken {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidResetToken, err)
+		}
+	})
+	t.Run("expect expired tokens to be rejected", func(t *testing.T) {
+		mail := configureTestReset(-time.Minute)
+		client := &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+		RequestReset("alan.oliver@ecs.co.uk", "test", client)
+
+		err := ConfirmReset("alan.oliver@ecs.co.uk", mail.token(t), "battery staple horse", "test", client)
+		if err == nil || err.Error() != ErrorInvalidResetToken {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidResetToken, err)
+		}
+	})
+	t.Run("expect error when reset is not configured", func(t *testing.T) {
+		ConfigureReset(ResetConfig{})
+		err := RequestReset("alan.oliver@ecs.co.uk", "test", &tableClient{})
+		if err == nil || err.Error() != ErrorPasswordResetNotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorPasswordResetNotConfigured, err)
+		}
+	})
+}
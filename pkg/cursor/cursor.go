@@ -0,0 +1,55 @@
+// Package cursor turns pagination state into opaque tokens clients hand back
+// to fetch the next page. Tokens are sealed with pkg/seal, so a client can
+// neither alter where a listing resumes nor read the keys it resumes from.
+package cursor
+
+import (
+	"errors"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seal"
+)
+
+var (
+	ErrorCursorsNotConfigured = "pagination cursors are not configured"
+	ErrorInvalidCursor        = "invalid cursor"
+)
+
+// purpose is what cursors are sealed for.
+const purpose = "cursor"
+
+// Config holds the key cursors are sealed with. Without one no cursors
+// are issued or accepted.
+type Config struct {
+	Key []byte
+}
+
+var cursors Config
+
+func Configure(config Config) {
+	cursors = config
+}
+
+// Enabled reports whether a key is configured.
+func Enabled() bool {
+	return len(cursors.Key) > 0
+}
+
+// Encode returns state as a cursor sealed with the key.
+func Encode(state interface{}) (string, error) {
+	if !Enabled() {
+		return "", errors.New(ErrorCursorsNotConfigured)
+	}
+	return seal.Seal(cursors.Key, purpose, state)
+}
+
+// Decode reads a cursor made by Encode into state, rejecting any that was
+// altered or sealed with another key.
+func Decode(token string, state interface{}) error {
+	if !Enabled() {
+		return errors.New(ErrorCursorsNotConfigured)
+	}
+	if err := seal.Open(cursors.Key, purpose, token, state); err != nil {
+		return errors.New(ErrorInvalidCursor)
+	}
+	return nil
+}
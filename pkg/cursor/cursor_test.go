@@ -0,0 +1,69 @@
+package cursor
+
+import (
+	"strings"
+	"testing"
+)
+
+type state struct {
+	After string `json:"after"`
+}
+
+func TestCursor(t *testing.T) {
+	Configure(Config{Key: []byte("test key")})
+	defer Configure(Config{})
+
+	t.Run("should decode the state it encoded", func(t *testing.T) {
+		token, err := Encode(state{After: "alan.oliver@ecs.co.uk"})
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if strings.Contains(token, "alan") {
+			t.Errorf("Expected an opaque cursor, got %s", token)
+		}
+		var got state
+		if err := Decode(token, &got); err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if got.After != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected %s, got %s", "alan.oliver@ecs.co.uk", got.After)
+		}
+	})
+	t.Run("expect error when the cursor is tampered with", func(t *testing.T) {
+		token, _ := Encode(state{After: "alan.oliver@ecs.co.uk"})
+		middle := len(token) / 2
+		replacement := "A"
+		if token[middle] == 'A' {
+			replacement = "B"
+		}
+		altered := token[:middle] + replacement + token[middle+1:]
+
+		for _, bad := range []string{altered, token[:len(token)/2], "", "!!!.!!!"} {
+			var got state
+			err := Decode(bad, &got)
+			if err == nil || err.Error() != ErrorInvalidCursor {
+				t.Errorf("Expected error %s for %q, got %v", ErrorInvalidCursor, bad, err)
+			}
+		}
+	})
+	t.Run("expect error when the cursor was sealed with another key", func(t *testing.T) {
+		token, _ := Encode(state{After: "alan.oliver@ecs.co.uk"})
+		Configure(Config{Key: []byte("rotated key")})
+		defer Configure(Config{Key: []byte("test key")})
+
+		var got state
+		err := Decode(token, &got)
+		if err == nil || err.Error() != ErrorInvalidCursor {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidCursor, err)
+		}
+	})
+}
+
+func TestCursorNotConfigured(t *testing.T) {
+	if _, err := Encode(state{}); err == nil || err.Error() != ErrorCursorsNotConfigured {
+		t.Errorf("Expected error %s, got %v", ErrorCursorsNotConfigured, err)
+	}
+	if err := Decode("a.b", &state{}); err == nil || err.Error() != ErrorCursorsNotConfigured {
+		t.Errorf("Expected error %s, got %v", ErrorCursorsNotConfigured, err)
+	}
+}
@@ -0,0 +1,276 @@
+// Package datalake lands each change to a user, read from the users table's
+// stream, in S3 under year, month and day partitions, for Athena and Glue to
+// query the history of users with SQL. Changes are written as
+// newline-delimited JSON or as Parquet with the same columns, so the table
+// declared over them is the same whichever format they are in.
+package datalake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/parquet"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var (
+	ErrorFailedToLandChanges = "failed to land user changes"
+	ErrorNotConfigured       = "data lake is not configured"
+	ErrorUnknownFormat       = "unknown data lake format"
+)
+
+// Formats changes can be landed in.
+const (
+	FormatJSON    = "json"
+	FormatParquet = "parquet"
+)
+
+// DefaultPrefix is where changes are landed in the bucket when Config
+// leaves Prefix unset.
+const DefaultPrefix = "lake/users/"
+
+// eventRemove is the name of a change that removed a user.
+const eventRemove = "REMOVE"
+
+// Config sets the bucket changes are landed in, under Prefix, and the
+// format they are written in.
+type Config struct {
+	Bucket string
+	Prefix string
+	Format string
+	S3     s3iface.S3API
+}
+
+var config = Config{Prefix: DefaultPrefix, Format: FormatJSON}
+
+func Configure(c Config) {
+	if c.Prefix == "" {
+		c.Prefix = DefaultPrefix
+	}
+	if !strings.HasSuffix(c.Prefix, "/") {
+		c.Prefix += "/"
+	}
+	if c.Format == "" {
+		c.Format = FormatJSON
+	}
+	config = c
+}
+
+// Enabled reports whether a bucket has been configured.
+func Enabled() bool {
+	return config.Bucket != "" && config.S3 != nil
+}
+
+// changeColumns are the columns describing a change, which come before the
+// user's.
+var changeColumns = []string{"eventId", "eventName", "sequenceNumber", "changedAt"}
+
+// Columns returns the columns of the changes landed: the change, and then
+// the user as it was after it, or as it was before it for a removal.
+func Columns() []string {
+	return append(append([]string{}, changeColumns...), user.ExportColumns...)
+}
+
+// Change is one change to a user. User is the user after it, or before it
+// when it removed them.
+type Change struct {
+	ID             string
+	Name           string
+	SequenceNumber string
+	ChangedAt      time.Time
+	User           user.User
+}
+
+// FromStreamRecord reads the change a record of a stream that holds both old
+// and new images made to a user, reporting whether it made one. A user left
+// behind as the tombstone of an email change was removed, as far as the
+// history of that email goes, and a change to a tombstone is none.
+func FromStreamRecord(record events.DynamoDBEventRecord) (Change, bool, error) {
+	before, err := imageOf(record.Change.OldImage)
+	if err != nil {
+		return Change{}, false, err
+	}
+	after, err := imageOf(record.Change.NewImage)
+	if err != nil {
+		return Change{}, false, err
+	}
+	change := Change{
+		ID:             record.EventID,
+		Name:           record.EventName,
+		SequenceNumber: record.Change.SequenceNumber,
+		ChangedAt:      record.Change.ApproximateCreationDateTime.UTC(),
+	}
+	switch {
+	case live(after):
+		change.User = *after
+	case live(before):
+		change.Name = eventRemove
+		change.User = *before
+	default:
+		return Change{}, false, nil
+	}
+	return change, true, nil
+}
+
+func imageOf(item map[string]events.DynamoDBAttributeValue) (*user.User, error) {
+	if len(item) == 0 {
+		return nil, nil
+	}
+	// Stream images marshal as the same JSON the SDK's attribute values read
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var av map[string]*dynamodb.AttributeValue
+	if err := json.Unmarshal(data, &av); err != nil {
+		return nil, err
+	}
+	var u user.User
+	if err := dynamodbattribute.UnmarshalMap(av, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func live(u *user.User) bool {
+	return u != nil && u.Email != "" && u.MovedTo == ""
+}
+
+// Land writes changes, in order, to an object in the partition of each day
+// they were made on, returning the keys written. Each object is named after
+// the sequence number of its first change, so a batch the stream delivers
+// again replaces the objects it wrote before rather than landing its changes
+// twice.
+func Land(changes []Change) ([]string, error) {
+	if !Enabled() {
+		return nil, errors.New(ErrorNotConfigured)
+	}
+	keys := []string{}
+	days := []string{}
+	byDay := map[string][]Change{}
+	for _, c := range changes {
+		day := Partition(c.ChangedAt)
+		if _, ok := byDay[day]; !ok {
+			days = append(days, day)
+		}
+		byDay[day] = append(byDay[day], c)
+	}
+	for _, day := range days {
+		body, err := encode(byDay[day])
+		if err != nil {
+			return keys, err
+		}
+		key := config.Prefix + day + byDay[day][0].SequenceNumber + "." + config.Format
+		_, err = config.S3.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(config.Bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(body),
+		})
+		if err != nil {
+			return keys, errors.New(ErrorFailedToLandChanges)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Partition returns the partition of the changes made at t, as the path
+// under the prefix that Athena reads the year, month and day from.
+func Partition(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("year=%04d/month=%02d/day=%02d/", t.Year(), t.Month(), t.Day())
+}
+
+// row returns the value of each of the Columns for c.
+func row(c Change) ([]*string, error) {
+	values, err := user.ExportRow(c.User)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToLandChanges)
+	}
+	changedAt := c.ChangedAt.Format(time.RFC3339)
+	return append([]*string{&c.ID, &c.Name, &c.SequenceNumber, &changedAt}, values...), nil
+}
+
+// encode writes changes in the configured format.
+func encode(changes []Change) ([]byte, error) {
+	switch config.Format {
+	case FormatJSON:
+		return encodeJSON(changes)
+	case FormatParquet:
+		return encodeParquet(changes)
+	}
+	return nil, errors.New(ErrorUnknownFormat)
+}
+
+func encodeParquet(changes []Change) ([]byte, error) {
+	file := parquet.New(Columns()...)
+	for _, c := range changes {
+		values, err := row(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := file.Add(values); err != nil {
+			return nil, errors.New(ErrorFailedToLandChanges)
+		}
+	}
+	return file.Bytes(), nil
+}
+
+// encodeJSON writes each change as a JSON object on a line of its own,
+// leaving out the columns it has no value for.
+func encodeJSON(changes []Change) ([]byte, error) {
+	columns := Columns()
+	var out bytes.Buffer
+	for _, c := range changes {
+		values, err := row(c)
+		if err != nil {
+			return nil, err
+		}
+		object := map[string]string{}
+		for i, value := range values {
+			if value != nil {
+				object[columns[i]] = *value
+			}
+		}
+		line, err := json.Marshal(object)
+		if err != nil {
+			return nil, errors.New(ErrorFailedToLandChanges)
+		}
+		out.Write(append(line, '\n'))
+	}
+	return out.Bytes(), nil
+}
+
+// Table returns the statement declaring the Athena table, called name, that
+// reads the changes landed. New partitions are found by running MSCK REPAIR
+// TABLE, or by a Glue crawler over the same location.
+func Table(name string) string {
+	var b strings.Builder
+	b.WriteString("CREATE EXTERNAL TABLE `" + name + "` (\n")
+	for i, column := range Columns() {
+		b.WriteString("  `" + column + "` string")
+		if i < len(Columns())-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")\nPARTITIONED BY (`year` string, `month` string, `day` string)\n")
+	if config.Format == FormatParquet {
+		b.WriteString("STORED AS PARQUET\n")
+	} else {
+		b.WriteString("ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'\n")
+	}
+	b.WriteString("LOCATION 's3://" + config.Bucket + "/" + config.Prefix + "';\n")
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package datalake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// objectS3 keeps the objects put to it, by key.
+type objectS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+	err     error
+}
+
+func (m *objectS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	body, _ := io.ReadAll(input.Body)
+	m.objects[*input.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func record(id string, name string, at time.Time, before, after map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventID:   id,
+		EventName: name,
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber:              id,
+			ApproximateCreationDateTime: events.SecondsEpochTime{Time: at},
+			OldImage:                    before,
+			NewImage:                    after,
+		},
+	}
+}
+
+func TestFromStreamRecord(t *testing.T) {
+	at := time.Date(2023, 3, 9, 23, 59, 0, 0, time.UTC)
+	alan := map[string]events.DynamoDBAttributeValue{
+		"email":     events.NewStringAttribute("alan.oliver@ecs.co.uk"),
+		"firstName": events.NewStringAttribute("Alan"),
+	}
+	tombstone := map[string]events.DynamoDBAttributeValue{
+		"email":   events.NewStringAttribute("alan.oliver@ecs.co.uk"),
+		"movedTo": events.NewStringAttribute("alan@ecs.co.uk"),
+	}
+
+	cases := []struct {
+		name   string
+		record events.DynamoDBEventRecord
+		ok     bool
+		event  string
+	}{
+		{"a user written is the user after the change", record("1", "INSERT", at, nil, alan), true, "INSERT"},
+		{"a user deleted is the user before it", record("2", "REMOVE", at, alan, nil), true, "REMOVE"},
+		{"a user moved away is removed from their old email", record("3", "MODIFY", at, alan, tombstone), true, "REMOVE"},
+		{"a tombstone deleted is no change", record("4", "REMOVE", at, tombstone, nil), false, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			change, ok, err := FromStreamRecord(c.record)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err.Error())
+			}
+			if ok != c.ok || change.Name != c.event {
+				t.Fatalf("expected %v and %q, got %v and %q", c.ok, c.event, ok, change.Name)
+			}
+			if ok && (change.User.Email != "alan.oliver@ecs.co.uk" || !change.ChangedAt.Equal(at)) {
+				t.Errorf("expected alan's change at %v, got %+v", at, change)
+			}
+		})
+	}
+}
+
+func TestLand(t *testing.T) {
+	defer Configure(Config{})
+	change := func(id string, at time.Time) Change {
+		c := Change{ID: id, Name: "INSERT", SequenceNumber: id, ChangedAt: at}
+		c.User.Email = id + "@ecs.co.uk"
+		return c
+	}
+	late := time.Date(2023, 3, 9, 23, 59, 0, 0, time.UTC)
+	early := time.Date(2023, 3, 10, 0, 1, 0, 0, time.UTC)
+
+	t.Run("should land each day's changes in its own partition", func(t *testing.T) {
+		storage := &objectS3{objects: map[string][]byte{}}
+		Configure(Config{Bucket: "lake", S3: storage})
+		keys, err := Land([]Change{change("1", late), change("2", late), change("3", early)})
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if len(keys) != 2 || keys[0] != "lake/users/year=2023/month=03/day=09/1.json" || keys[1] != "lake/users/year=2023/month=03/day=10/3.json" {
+			t.Fatalf("expected an object for each day named after its first change, got %v", keys)
+		}
+		lines := strings.Split(strings.TrimSpace(string(storage.objects[keys[0]])), "\n")
+		var first map[string]string
+		json.Unmarshal([]byte(lines[0]), &first)
+		if len(lines) != 2 || first["eventName"] != "INSERT" || first["email"] != "1@ecs.co.uk" || first["changedAt"] != "2023-03-09T23:59:00Z" {
+			t.Errorf("expected a line for each change, got %q", lines)
+		}
+		if _, ok := first["phone"]; ok {
+			t.Errorf("expected columns without a value to be left out, got %v", first)
+		}
+	})
+	t.Run("should land Parquet with the same columns", func(t *testing.T) {
+		storage := &objectS3{objects: map[string][]byte{}}
+		Configure(Config{Bucket: "lake", Prefix: "history", Format: FormatParquet, S3: storage})
+		keys, err := Land([]Change{change("1", late)})
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if keys[0] != "history/year=2023/month=03/day=09/1.parquet" || !bytes.HasPrefix(storage.objects[keys[0]], []byte("PAR1")) {
+			t.Errorf("expected a Parquet object, got %v", keys)
+		}
+	})
+	t.Run("should fail when an object cannot be written", func(t *testing.T) {
+		Configure(Config{Bucket: "lake", S3: &objectS3{err: errors.New("test error")}})
+		if _, err := Land([]Change{change("1", late)}); err == nil || err.Error() != ErrorFailedToLandChanges {
+			t.Errorf("expected error %s, got %v", ErrorFailedToLandChanges, err)
+		}
+	})
+	t.Run("should not land anything unless configured", func(t *testing.T) {
+		Configure(Config{})
+		if _, err := Land([]Change{change("1", late)}); err == nil || err.Error() != ErrorNotConfigured {
+			t.Errorf("expected error %s, got %v", ErrorNotConfigured, err)
+		}
+	})
+}
+
+func TestTable(t *testing.T) {
+	defer Configure(Config{})
+	Configure(Config{Bucket: "lake", Format: FormatParquet, S3: &objectS3{}})
+	statement := Table("user_changes")
+	for _, want := range []string{"CREATE EXTERNAL TABLE `user_changes`", "`eventName` string,", "`identity` string\n)", "PARTITIONED BY (`year` string, `month` string, `day` string)", "STORED AS PARQUET", "LOCATION 's3://lake/lake/users/'"} {
+		if !strings.Contains(statement, want) {
+			t.Errorf("expected the statement to contain %q, got %s", want, statement)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package deadletter reprocesses the messages that ended up on a dead-letter
+// queue: users an import failed to create and outbox notifications that
+// failed to be delivered. Each message is checked again, retried with
+// backoff, and parked under a quarantine prefix in S3 when it cannot be
+// reprocessed, so the queue is always left empty of what was drained.
+package deadletter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Kinds of message the queue holds.
+const (
+	KindImport       = "import"
+	KindNotification = "notification"
+)
+
+var (
+	ErrorFailedToDeleteMessage   = "failed to delete dead-letter message"
+	ErrorFailedToQuarantine      = "failed to quarantine dead-letter message"
+	ErrorFailedToReceiveMessages = "failed to receive dead-letter messages"
+	ErrorFailedToSaveReport      = "failed to save quarantine report"
+	ErrorInvalidNotification     = "notification has no subject or an unknown event"
+	ErrorUnknownMessageKind      = "unknown dead-letter message kind"
+	ErrorUnreadableMessage       = "dead-letter message is not a readable message"
+)
+
+// Config sets how often a message is retried before it is quarantined:
+// up to MaxAttempts times, waiting RetryDelay before the first retry and
+// twice as long before each one after it. Zero values take the defaults.
+type Config struct {
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultRetryDelay  = 200 * time.Millisecond
+)
+
+var config = Config{MaxAttempts: DefaultMaxAttempts, RetryDelay: DefaultRetryDelay}
+
+func Configure(c Config) {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = DefaultRetryDelay
+	}
+	config = c
+}
+
+// Message is a dead-letter message's body. An import carries the user it
+// failed to create, as {"kind": "import", "user": {...}}. A notification
+// is the outbox message itself, as SNS delivers it, so its kind may be left
+// out.
+type Message struct {
+	Kind string     `json:"kind,omitempty"`
+	User *user.User `json:"user,omitempty"`
+	outbox.Message
+}
+
+// Queue is the dead-letter queue to drain.
+type Queue struct {
+	SQS sqsiface.SQSAPI
+	URL string
+}
+
+// Quarantine is where messages that cannot be reprocessed are parked, each
+// as Prefix followed by its message ID, with a report of each drain that
+// parked any under Prefix + "reports/".
+type Quarantine struct {
+	S3     s3iface.S3API
+	Bucket string
+	Prefix string
+}
+
+// Parked is a message that was quarantined, and why.
+type Parked struct {
+	MessageID string `json:"messageId"`
+	Kind      string `json:"kind,omitempty"`
+	Reason    string `json:"reason"`
+	Attempts  int    `json:"attempts"`
+	Key       string `json:"key"`
+}
+
+// Report counts the messages a drain received and reprocessed and lists
+// those it quarantined. Key is the report's own object, when any were.
+type Report struct {
+	Received    int      `json:"received"`
+	Reprocessed int      `json:"reprocessed"`
+	Quarantined []Parked `json:"quarantined"`
+	Remaining   bool     `json:"remaining"`
+	Key         string   `json:"key,omitempty"`
+}
+
+// quarantined is the object a parked message is kept as.
+type quarantined struct {
+	Parked
+	Body          string `json:"body"`
+	QuarantinedAt string `json:"quarantinedAt"`
+}
+
+// Drain receives up to max messages from the queue, zero for all of them,
+// and reprocesses each: imported users are created, unless they already
+// exist, and notifications are published again. Each message is deleted
+// once reprocessed or quarantined. Drain stops at the first message it can
+// neither reprocess nor quarantine, leaving it on the queue.
+func Drain(queue Queue, quarantine Quarantine, publisher outbox.Publisher, userTableName string, dynaClient dynamodbiface.DynamoDBAPI, max int) (*Report, error) {
+	report := &Report{Quarantined: []Parked{}}
+	err := drain(queue, quarantine, publisher, userTableName, dynaClient, max, report)
+	if len(report.Quarantined) > 0 {
+		if saveErr := saveReport(quarantine, report); err == nil {
+			err = saveErr
+		}
+	}
+	return report, err
+}
+
+func drain(queue Queue, quarantine Quarantine, publisher outbox.Publisher, userTableName string, dynaClient dynamodbiface.DynamoDBAPI, max int, report *Report) error {
+	for {
+		batch := int64(10)
+		if max > 0 {
+			if report.Received >= max {
+				report.Remaining = true
+				return nil
+			}
+			if left := int64(max - report.Received); left < batch {
+				batch = left
+			}
+		}
+		received, err := queue.SQS.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queue.URL),
+			MaxNumberOfMessages: aws.Int64(batch),
+		})
+		if err != nil {
+			return errors.New(ErrorFailedToReceiveMessages)
+		}
+		if len(received.Messages) == 0 {
+			return nil
+		}
+		for _, m := range received.Messages {
+			report.Received++
+			kind, attempts, err := reprocess(aws.StringValue(m.Body), publisher, userTableName, dynaClient)
+			if err != nil {
+				parked, err := park(quarantine, m, kind, attempts, err)
+				if err != nil {
+					report.Remaining = true
+					return err
+				}
+				report.Quarantined = append(report.Quarantined, parked)
+			} else {
+				report.Reprocessed++
+			}
+			_, err = queue.SQS.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queue.URL),
+				ReceiptHandle: m.ReceiptHandle,
+			})
+			if err != nil {
+				report.Remaining = true
+				return errors.New(ErrorFailedToDeleteMessage)
+			}
+		}
+	}
+}
+
+// reprocess checks body again and retries it, returning its kind and the
+// attempts made. An error means it should be quarantined.
+func reprocess(body string, publisher outbox.Publisher, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (string, int, error) {
+	var m Message
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return "", 0, errors.New(ErrorUnreadableMessage)
+	}
+	if m.Kind == "" && m.Event != "" {
+		m.Kind = KindNotification
+	}
+	var attempt func() error
+	switch m.Kind {
+	case KindImport:
+		if m.User == nil {
+			return m.Kind, 0, errors.New(ErrorUnreadableMessage)
+		}
+		u, err := user.Validate(*m.User)
+		if err != nil {
+			return m.Kind, 0, err
+		}
+		attempt = func() error {
+			_, err := user.Create(*u, userTableName, dynaClient)
+			if err != nil && err.Error() == user.ErrorUserAlreadyExists {
+				return nil
+			}
+			return err
+		}
+	case KindNotification:
+		switch m.Event {
+		case outbox.EventUserCreated, outbox.EventUserUpdated, outbox.EventUserDeleted:
+		default:
+			return m.Kind, 0, errors.New(ErrorInvalidNotification)
+		}
+		if m.Subject == "" || !json.Valid([]byte(m.Payload)) {
+			return m.Kind, 0, errors.New(ErrorInvalidNotification)
+		}
+		attempt = func() error {
+			return publisher.Publish([]outbox.Message{m.Message})
+		}
+	default:
+		return m.Kind, 0, errors.New(ErrorUnknownMessageKind)
+	}
+
+	delay := config.RetryDelay
+	var err error
+	for n := 1; n <= config.MaxAttempts; n++ {
+		if n > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = attempt(); err == nil {
+			return m.Kind, n, nil
+		}
+	}
+	return m.Kind, config.MaxAttempts, err
+}
+
+func park(quarantine Quarantine, m *sqs.Message, kind string, attempts int, reason error) (Parked, error) {
+	parked := Parked{
+		MessageID: aws.StringValue(m.MessageId),
+		Kind:      kind,
+		Reason:    reason.Error(),
+		Attempts:  attempts,
+		Key:       quarantine.Prefix + aws.StringValue(m.MessageId) + ".json",
+	}
+	body, err := json.Marshal(quarantined{
+		Parked:        parked,
+		Body:          aws.StringValue(m.Body),
+		QuarantinedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return Parked{}, errors.New(ErrorFailedToQuarantine)
+	}
+	if err := put(quarantine, parked.Key, body); err != nil {
+		return Parked{}, errors.New(ErrorFailedToQuarantine)
+	}
+	return parked, nil
+}
+
+func saveReport(quarantine Quarantine, report *Report) error {
+	report.Key = quarantine.Prefix + "reports/" + time.Now().UTC().Format("20060102T150405.000Z") + ".json"
+	body, err := json.Marshal(report)
+	if err != nil {
+		return errors.New(ErrorFailedToSaveReport)
+	}
+	if err := put(quarantine, report.Key, body); err != nil {
+		report.Key = ""
+		return errors.New(ErrorFailedToSaveReport)
+	}
+	return nil
+}
+
+func put(quarantine Quarantine, key string, body []byte) error {
+	_, err := quarantine.S3.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(quarantine.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
@@ -0,0 +1,139 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// queueClient holds the queue's messages, handing out up to the number
+// asked for and forgetting each once deleted.
+type queueClient struct {
+	sqsiface.SQSAPI
+	messages []*sqs.Message
+}
+
+func newQueue(bodies ...string) *queueClient {
+	q := &queueClient{}
+	for i, body := range bodies {
+		id := strconv.Itoa(i + 1)
+		q.messages = append(q.messages, &sqs.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: aws.String(body)})
+	}
+	return q
+}
+
+func (q *queueClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	n := int(*input.MaxNumberOfMessages)
+	if n > len(q.messages) {
+		n = len(q.messages)
+	}
+	return &sqs.ReceiveMessageOutput{Messages: q.messages[:n]}, nil
+}
+
+func (q *queueClient) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	for i, m := range q.messages {
+		if *m.ReceiptHandle == *input.ReceiptHandle {
+			q.messages = append(q.messages[:i:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+type bucketClient struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (b *bucketClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, _ := io.ReadAll(input.Body)
+	b.objects[*input.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+type publisher struct {
+	published []outbox.Message
+	failures  int
+}
+
+func (p *publisher) Publish(messages []outbox.Message) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New(outbox.ErrorFailedToPublish)
+	}
+	p.published = append(p.published, messages...)
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	Configure(Config{MaxAttempts: 3, RetryDelay: time.Millisecond})
+	defer Configure(Config{})
+	notification := `{"id": "1", "event": "user.updated", "subject": "alan.oliver@ecs.co.uk", "payload": "{}"}`
+
+	t.Run("expect messages to be reprocessed and the rest quarantined with a report", func(t *testing.T) {
+		queue := newQueue(
+			`{"kind": "import", "user": {"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}}`,
+			notification,
+			`{"kind": "import", "user": {"email": "alan"}}`,
+			`not json`,
+		)
+		bucket := &bucketClient{objects: map[string][]byte{}}
+		pub := &publisher{failures: 1}
+		mockDb := &testutil.MockDynamoDB{}
+		report, err := Drain(Queue{SQS: queue}, Quarantine{S3: bucket, Prefix: "quarantine/"}, pub, "test", mockDb, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Received != 4 || report.Reprocessed != 2 || len(report.Quarantined) != 2 || len(queue.messages) != 0 {
+			t.Errorf("Expected 2 reprocessed and 2 quarantined with the queue emptied, got %+v and %d left", *report, len(queue.messages))
+		}
+		if len(pub.published) != 1 || mockDb.Count("PutItem") == 0 {
+			t.Errorf("Expected the notification published again and the user created")
+		}
+		var parked quarantined
+		json.Unmarshal(bucket.objects["quarantine/4.json"], &parked)
+		if parked.Reason != ErrorUnreadableMessage || parked.Body != "not json" {
+			t.Errorf("Expected the unreadable message quarantined with its body, got %+v", parked)
+		}
+		if _, ok := bucket.objects[report.Key]; !ok || report.Key == "" {
+			t.Errorf("Expected the report saved, got %q among %d objects", report.Key, len(bucket.objects))
+		}
+	})
+	t.Run("expect a message failing every attempt to be quarantined", func(t *testing.T) {
+		bucket := &bucketClient{objects: map[string][]byte{}}
+		report, _ := Drain(Queue{SQS: newQueue(notification)}, Quarantine{S3: bucket}, &publisher{failures: 3}, "test", &testutil.MockDynamoDB{}, 0)
+		if len(report.Quarantined) != 1 || report.Quarantined[0].Attempts != 3 || report.Quarantined[0].Reason != outbox.ErrorFailedToPublish {
+			t.Errorf("Expected the notification quarantined after 3 attempts, got %+v", report.Quarantined)
+		}
+	})
+	t.Run("expect an imported user that already exists to be reprocessed", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String("alan.oliver@ecs.co.uk")},
+		}}}
+		queue := newQueue(`{"kind": "import", "user": {"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}}`)
+		report, _ := Drain(Queue{SQS: queue}, Quarantine{S3: &bucketClient{objects: map[string][]byte{}}}, &publisher{}, "test", mockDb, 0)
+		if report.Reprocessed != 1 || mockDb.Count("PutItem") != 0 {
+			t.Errorf("Expected the user left as they are, got %+v", *report)
+		}
+	})
+	t.Run("expect a drain to stop at max", func(t *testing.T) {
+		queue := newQueue(notification, notification, notification)
+		report, _ := Drain(Queue{SQS: queue}, Quarantine{}, &publisher{}, "test", &testutil.MockDynamoDB{}, 2)
+		if report.Received != 2 || !report.Remaining || len(queue.messages) != 1 {
+			t.Errorf("Expected 2 messages drained and 1 remaining, got %+v", *report)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// Package flags evaluates feature flags kept in an AWS AppConfig feature
+// flag profile, so new behaviour can be rolled out to some callers, or all,
+// and rolled back without a deploy. The profile is polled as flags are
+// evaluated, at most once per poll interval, and the last configuration
+// read is kept when a poll fails.
+package flags
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/appconfigdata"
+	"github.com/aws/aws-sdk-go/service/appconfigdata/appconfigdataiface"
+)
+
+// Flags the API gates behaviour behind.
+const (
+	// StrictValidation refuses metadata keys the schema does not define, as
+	// SCHEMA_STRICT does for every request
+	StrictValidation = "strict-validation"
+	// SoftDelete deactivates users that are deleted rather than deleting
+	// them, so they can be reactivated
+	SoftDelete = "soft-delete"
+	// Envelope wraps v2 response bodies in an envelope
+	Envelope = "v2-envelope"
+)
+
+// Defaults are the values of flags the profile does not define, or all
+// flags while none has been read.
+var Defaults = map[string]bool{
+	Envelope: true,
+}
+
+// DefaultPollInterval is how often the profile is polled, when no other
+// interval is configured. AppConfig allows no less than 15 seconds.
+const DefaultPollInterval = 45 * time.Second
+
+// Config names the AppConfig application, environment and feature flag
+// profile flags are read from. Flags take their defaults while any of them,
+// or Client, is not set.
+type Config struct {
+	Application  string
+	Environment  string
+	Profile      string
+	PollInterval time.Duration
+	Client       appconfigdataiface.AppConfigDataAPI
+}
+
+// Flag is a flag as the profile defines it: whether it is on and, when
+// Percentage is set, for what percentage of callers.
+type Flag struct {
+	Enabled    bool     `json:"enabled"`
+	Percentage *float64 `json:"percentage,omitempty"`
+}
+
+var (
+	mu       sync.Mutex
+	config   Config
+	current  map[string]Flag
+	token    string
+	nextPoll time.Time
+	now      = time.Now
+)
+
+func Configure(c Config) {
+	if c.PollInterval < 15*time.Second {
+		c.PollInterval = DefaultPollInterval
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+	current, token, nextPoll = nil, "", time.Time{}
+}
+
+func configured() bool {
+	return config.Application != "" && config.Environment != "" && config.Profile != "" && config.Client != nil
+}
+
+// Enabled reports whether flag name is on for key, the caller a rollout is
+// made to, such as an API key or an email. A caller is always in or out of
+// a percentage rollout as long as the percentage stays the same; without a
+// key, each evaluation is rolled for separately.
+func Enabled(name string, key string) bool {
+	flag, ok := lookup(name)
+	if !ok {
+		return Defaults[name]
+	}
+	if !flag.Enabled {
+		return false
+	}
+	if flag.Percentage == nil {
+		return true
+	}
+	return bucket(name, key) < *flag.Percentage
+}
+
+func lookup(name string) (Flag, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if configured() && !now().Before(nextPoll) {
+		poll()
+	}
+	flag, ok := current[name]
+	return flag, ok
+}
+
+// bucket places key in [0, 100) for flag name, so each flag rolls out to
+// its own callers.
+func bucket(name string, key string) float64 {
+	if key == "" {
+		return rand.Float64() * 100
+	}
+	sum := sha256.Sum256([]byte(name + "\x00" + key))
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) / 100
+}
+
+// poll reads the profile when it has changed, starting a configuration
+// session first when there is none. A failed poll keeps the flags already
+// read and is tried again after the poll interval.
+func poll() {
+	nextPoll = now().Add(config.PollInterval)
+	if token == "" {
+		session, err := config.Client.StartConfigurationSession(&appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:                aws.String(config.Application),
+			EnvironmentIdentifier:                aws.String(config.Environment),
+			ConfigurationProfileIdentifier:       aws.String(config.Profile),
+			RequiredMinimumPollIntervalInSeconds: aws.Int64(int64(config.PollInterval / time.Second)),
+		})
+		if err != nil {
+			return
+		}
+		token = aws.StringValue(session.InitialConfigurationToken)
+	}
+	latest, err := config.Client.GetLatestConfiguration(&appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: aws.String(token),
+	})
+	if err != nil {
+		// Tokens expire after a day unused, so the session is started again
+		token = ""
+		return
+	}
+	token = aws.StringValue(latest.NextPollConfigurationToken)
+	if interval := time.Duration(aws.Int64Value(latest.NextPollIntervalInSeconds)) * time.Second; interval > config.PollInterval {
+		nextPoll = now().Add(interval)
+	}
+	// An empty configuration means it has not changed since the last poll
+	if len(latest.Configuration) == 0 {
+		return
+	}
+	var read map[string]Flag
+	if err := json.Unmarshal(latest.Configuration, &read); err != nil {
+		return
+	}
+	current = read
+}
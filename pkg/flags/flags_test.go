@@ -0,0 +1,113 @@
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/appconfigdata"
+	"github.com/aws/aws-sdk-go/service/appconfigdata/appconfigdataiface"
+)
+
+// fakeAppConfig answers polls with the configurations queued in it, the
+// configuration it last answered being read as unchanged once they run out.
+type fakeAppConfig struct {
+	appconfigdataiface.AppConfigDataAPI
+	configurations []string
+	sessions       int
+	polls          int
+	err            error
+}
+
+func (f *fakeAppConfig) StartConfigurationSession(input *appconfigdata.StartConfigurationSessionInput) (*appconfigdata.StartConfigurationSessionOutput, error) {
+	f.sessions++
+	return &appconfigdata.StartConfigurationSessionOutput{InitialConfigurationToken: aws.String("initial")}, nil
+}
+
+func (f *fakeAppConfig) GetLatestConfiguration(input *appconfigdata.GetLatestConfigurationInput) (*appconfigdata.GetLatestConfigurationOutput, error) {
+	f.polls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := &appconfigdata.GetLatestConfigurationOutput{NextPollConfigurationToken: aws.String(fmt.Sprint("next", f.polls))}
+	if len(f.configurations) > 0 {
+		out.Configuration = []byte(f.configurations[0])
+		f.configurations = f.configurations[1:]
+	}
+	return out, nil
+}
+
+func TestEnabled(t *testing.T) {
+	defer Configure(Config{})
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	configure := func(client *fakeAppConfig) {
+		Configure(Config{Application: "users", Environment: "staging", Profile: "flags", Client: client})
+	}
+
+	t.Run("expect defaults while flags are not configured", func(t *testing.T) {
+		Configure(Config{})
+		if !Enabled(Envelope, "") || Enabled(SoftDelete, "") {
+			t.Error("Expected flags to take their defaults")
+		}
+	})
+
+	t.Run("expect flags to be read from the profile and polled again after the interval", func(t *testing.T) {
+		client := &fakeAppConfig{configurations: []string{
+			`{"soft-delete": {"enabled": true}, "v2-envelope": {"enabled": false}}`,
+			`{"soft-delete": {"enabled": false}}`,
+		}}
+		configure(client)
+		if !Enabled(SoftDelete, "") || Enabled(Envelope, "") {
+			t.Error("Expected the profile's flags")
+		}
+		if !Enabled(SoftDelete, "") || client.polls != 1 {
+			t.Errorf("Expected the flags to be cached, got %d polls", client.polls)
+		}
+		clock = clock.Add(DefaultPollInterval)
+		if Enabled(SoftDelete, "") || !Enabled(Envelope, "") {
+			t.Error("Expected the changed profile, with the flag it no longer defines defaulted")
+		}
+		clock = clock.Add(DefaultPollInterval)
+		if Enabled(SoftDelete, "") || client.polls != 3 || client.sessions != 1 {
+			t.Errorf("Expected an unchanged profile to keep its flags, got %d polls in %d sessions", client.polls, client.sessions)
+		}
+	})
+
+	t.Run("expect a failed poll to keep the flags and start a new session", func(t *testing.T) {
+		client := &fakeAppConfig{configurations: []string{`{"soft-delete": {"enabled": true}}`}}
+		configure(client)
+		Enabled(SoftDelete, "")
+		client.err = errors.New("BadRequestException: token expired")
+		clock = clock.Add(DefaultPollInterval)
+		if !Enabled(SoftDelete, "") {
+			t.Error("Expected the flags read before to be kept")
+		}
+		client.err = nil
+		clock = clock.Add(DefaultPollInterval)
+		Enabled(SoftDelete, "")
+		if client.sessions != 2 {
+			t.Errorf("Expected a new session, got %d", client.sessions)
+		}
+	})
+
+	t.Run("expect percentage rollouts to keep each caller in or out", func(t *testing.T) {
+		configure(&fakeAppConfig{configurations: []string{`{"soft-delete": {"enabled": true, "percentage": 25}}`}})
+		on := 0
+		for n := 0; n < 1000; n++ {
+			key := fmt.Sprint("caller", n)
+			if Enabled(SoftDelete, key) != Enabled(SoftDelete, key) {
+				t.Fatalf("Expected %s to be rolled for once", key)
+			}
+			if Enabled(SoftDelete, key) {
+				on++
+			}
+		}
+		if on < 200 || on > 300 {
+			t.Errorf("Expected about a quarter of callers, got %d of 1000", on)
+		}
+	})
+}
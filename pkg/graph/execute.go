@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/executor"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Request is the body of a GraphQL request.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// ReadOnly reports whether the operation request runs is a query. A request
+// whose operation cannot be told, because its query does not parse or names
+// no single operation, is not.
+func ReadOnly(request Request) bool {
+	doc, err := parser.ParseQuery(&ast.Source{Input: request.Query})
+	if err != nil {
+		return false
+	}
+	op := doc.Operations.ForName(request.OperationName)
+	return op != nil && op.Operation == ast.Query
+}
+
+// Execute runs request's operation with r, presenting each error with
+// present. The schema can be introspected.
+func Execute(ctx context.Context, r *Resolver, request Request, present graphql.ErrorPresenterFunc) *graphql.Response {
+	exec := executor.New(NewExecutableSchema(Config{Resolvers: r}))
+	exec.Use(extension.Introspection{})
+	if present != nil {
+		exec.SetErrorPresenter(present)
+	}
+
+	ctx = graphql.StartOperationTrace(ctx)
+	now := graphql.Now()
+	rc, errs := exec.CreateOperationContext(ctx, &graphql.RawParams{
+		Query:         request.Query,
+		OperationName: request.OperationName,
+		Variables:     request.Variables,
+		Extensions:    request.Extensions,
+		ReadTime:      graphql.TraceTiming{Start: now, End: now},
+	})
+	if errs != nil {
+		return exec.DispatchError(graphql.WithOperationContext(ctx, rc), errs)
+	}
+	responses, ctx := exec.DispatchOperation(ctx, rc)
+	return responses(ctx)
+}
@@ -0,0 +1,30 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+)
+
+type CreateUserInput struct {
+	Email       string        `json:"email"`
+	FirstName   string        `json:"firstName"`
+	LastName    string        `json:"lastName"`
+	Phone       *string       `json:"phone,omitempty"`
+	DateOfBirth *string       `json:"dateOfBirth,omitempty"`
+	Address     *user.Address `json:"address,omitempty"`
+	JobTitle    *string       `json:"jobTitle,omitempty"`
+	Company     *string       `json:"company,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+}
+
+type PageInfo struct {
+	HasNextPage bool `json:"hasNextPage"`
+	// Pass as after to read the next page. Null when there is none, or cursors are not configured.
+	EndCursor *string `json:"endCursor,omitempty"`
+}
+
+type UserConnection struct {
+	Nodes    []user.User `json:"nodes"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
@@ -0,0 +1,80 @@
+// Package graph answers GraphQL requests about users. The schema is in
+// schema.graphqls; after changing it, regenerate the executable schema with
+// go generate ./pkg/graph and fill in any new resolvers.
+package graph
+
+//go:generate go run github.com/99designs/gqlgen@v0.17.31 generate
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Resolver resolves one request's operations against a users table. Limit
+// and MaxLimit bound how many users a page holds, as for GET All. Changes
+// are made by the actor RequestContext, the request's context, names, and
+// when Identity is set, only to the user it owns.
+type Resolver struct {
+	TableName      string
+	Client         dynamodbiface.DynamoDBAPI
+	Limit          int
+	MaxLimit       int
+	RequestContext events.APIGatewayProxyRequestContext
+	Identity       *oidc.Identity
+}
+
+var ErrorInvalidFirst = "first must be a whole number between 1 and the maximum page size"
+
+// requireOwner fails with oidc.ErrorNotOwnUser when the request has an
+// identity that does not own the user with email.
+func (r *Resolver) requireOwner(email string) error {
+	if r.Identity != nil && !r.Identity.Owns(strings.TrimSpace(email)) {
+		return errors.New(oidc.ErrorNotOwnUser)
+	}
+	return nil
+}
+
+// limit returns the page size for first, the number of users asked for.
+func (r *Resolver) limit(first *int) (int, error) {
+	if first == nil {
+		return r.Limit, nil
+	}
+	if *first < 1 || r.MaxLimit > 0 && *first > r.MaxLimit {
+		return 0, errors.New(ErrorInvalidFirst)
+	}
+	return *first, nil
+}
+
+// nodeFields returns the user fields the users query selects of its nodes,
+// so only those are read, or nil to read them all. Fields resolved from
+// other tables are left out.
+func nodeFields(ctx context.Context) []string {
+	op := graphql.GetOperationContext(ctx)
+	for _, field := range graphql.CollectFieldsCtx(ctx, nil) {
+		if field.Name != "nodes" {
+			continue
+		}
+		fields := []string{"email"}
+		for _, node := range graphql.CollectFields(op, field.Selections, nil) {
+			if node.Name != "email" && node.Name != "preferences" && node.Name != "__typename" {
+				fields = append(fields, node.Name)
+			}
+		}
+		return fields
+	}
+	return []string{"email"}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
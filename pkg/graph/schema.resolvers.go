@@ -0,0 +1,136 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.31
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// CreateUser is the resolver for the createUser field.
+func (r *mutationResolver) CreateUser(ctx context.Context, input CreateUserInput) (*user.User, error) {
+	u := user.User{
+		Email:       input.Email,
+		FirstName:   input.FirstName,
+		LastName:    input.LastName,
+		Phone:       input.Phone,
+		DateOfBirth: input.DateOfBirth,
+		Address:     input.Address,
+		JobTitle:    input.JobTitle,
+		Company:     input.Company,
+		Tags:        input.Tags,
+	}
+	if err := r.requireOwner(input.Email); err != nil {
+		return nil, err
+	}
+	actor := audit.ActorOf(events.APIGatewayProxyRequest{RequestContext: r.RequestContext})
+	created, err := user.CreateAs(u, actor, r.TableName, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateUser is the resolver for the updateUser field.
+func (r *mutationResolver) UpdateUser(ctx context.Context, input map[string]interface{}) (*user.User, error) {
+	// The user package merges the fields present in a body, so the input
+	// is passed on as one
+	email, _ := input["email"].(string)
+	if err := r.requireOwner(email); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.New(user.ErrorInvalidUserData)
+	}
+	req := events.APIGatewayProxyRequest{Body: string(body), RequestContext: r.RequestContext}
+	updated, err := user.UpdateUser(req, r.TableName, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, email string) (bool, error) {
+	if err := r.requireOwner(email); err != nil {
+		return false, err
+	}
+	req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": email}, RequestContext: r.RequestContext}
+	if err := user.DeleteUser(req, r.TableName, r.Client); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, email string) (*user.User, error) {
+	u, err := user.FetchUser(strings.ToLower(email), r.TableName, r.Client)
+	if err != nil {
+		return nil, err
+	}
+	if u.Email == "" {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, tag *string, first *int, after *string) (*UserConnection, error) {
+	options := user.FetchOptions{Tag: stringValue(tag), Fields: nodeFields(ctx)}
+	n, err := r.limit(first)
+	if err != nil {
+		return nil, err
+	}
+	options.Limit = n
+	if after != nil {
+		var start user.ScanPosition
+		if err := cursor.Decode(*after, &start); err != nil {
+			return nil, err
+		}
+		if !start.Valid() {
+			return nil, errors.New(cursor.ErrorInvalidCursor)
+		}
+		options.Start = &start
+	}
+	result, err := user.ScanUsersWithOptions(r.TableName, r.Client, options)
+	if err != nil {
+		return nil, err
+	}
+	page := &UserConnection{Nodes: result.Users, PageInfo: &PageInfo{HasNextPage: result.Truncated}}
+	if result.Truncated && result.Next != nil && cursor.Enabled() {
+		next, err := cursor.Encode(result.Next)
+		if err != nil {
+			return nil, err
+		}
+		page.PageInfo.EndCursor = &next
+	}
+	return page, nil
+}
+
+// Preferences is the resolver for the preferences field.
+func (r *userResolver) Preferences(ctx context.Context, obj *user.User) (*user.Preferences, error) {
+	return user.FetchPreferences(obj.Email, r.TableName, r.Client)
+}
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// User returns UserResolver implementation.
+func (r *Resolver) User() UserResolver { return &userResolver{r} }
+
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
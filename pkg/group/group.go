@@ -0,0 +1,240 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Groups and their members share a table. Every item of a group is stored
+// under the group's partition key: the group itself with the sort key
+// metadataKey and each member with memberPrefix followed by their email, so a
+// single query reads a group's members.
+const (
+	groupPrefix  = "GROUP#"
+	memberPrefix = "MEMBER#"
+	metadataKey  = "METADATA"
+)
+
+var (
+	ErrorFailedToDeleteGroup      = "failed to delete group"
+	ErrorFailedToFetchGroup       = "failed to fetch group"
+	ErrorFailedToMarshalGroup     = "failed to marshal group"
+	ErrorFailedToSaveGroup        = "failed to save group"
+	ErrorFailedToUnmarshalGroup   = "failed to unmarshal group"
+	ErrorFailedToUpdateMembership = "failed to update group membership"
+	ErrorGroupNotFound            = "group not found"
+	ErrorInvalidGroupData         = "invalid group data"
+)
+
+type Group struct {
+	ID          string `json:"id"`
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description,omitempty" validate:"max=500"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// Member records that a user belongs to a group.
+type Member struct {
+	Email   string `json:"email"`
+	AddedAt string `json:"addedAt"`
+}
+
+// memberItem is a member as stored, under the group they belong to.
+type memberItem struct {
+	Group string `json:"-"`
+	Member
+}
+
+// TableName returns the groups table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Group"
+}
+
+// itemErrors are the messages for groups and members that cannot be
+// marshalled or unmarshalled.
+var itemErrors = store.ItemErrors{Marshal: ErrorFailedToMarshalGroup, Unmarshal: ErrorFailedToUnmarshalGroup}
+
+// groups returns the repository of the items describing groups.
+func groups(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) *store.Repository[Group] {
+	keys := store.KeySpec{PartitionKey: "pk", PartitionPrefix: groupPrefix, SortKey: "sk", SortPrefix: metadataKey, Index: store.SortKeyIndex}
+	r := store.NewRepository(store.NewTable(TableName(userTableName)), keys, func(g Group) store.Key {
+		return store.Key{Partition: g.ID}
+	}, dynaClient)
+	r.Errors = itemErrors
+	return r
+}
+
+// members returns the repository of group members.
+func members(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) *store.Repository[memberItem] {
+	keys := store.KeySpec{PartitionKey: "pk", PartitionPrefix: groupPrefix, SortKey: "sk", SortPrefix: memberPrefix}
+	r := store.NewRepository(store.NewTable(TableName(userTableName)), keys, func(m memberItem) store.Key {
+		return store.Key{Partition: m.Group, Sort: m.Email}
+	}, dynaClient)
+	r.Errors = itemErrors
+	return r
+}
+
+func CreateGroup(g Group, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Group, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveGroup)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	g.ID = id
+	g.CreatedAt = now
+	g.UpdatedAt = now
+	if err := groups(userTableName, dynaClient).Put(g, ""); err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveGroup)
+	}
+	return &g, nil
+}
+
+func FetchGroup(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Group, error) {
+	g, err := groups(userTableName, dynaClient).Get(store.Key{Partition: id})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchGroup)
+	}
+	if g == nil {
+		return nil, errors.New(ErrorGroupNotFound)
+	}
+	return g, nil
+}
+
+// FetchAllGroups reads every group, leaving out their members.
+func FetchAllGroups(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Group, error) {
+	all, err := groups(userTableName, dynaClient).List()
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchGroup)
+	}
+	return all, nil
+}
+
+// UpdateGroup replaces the name and description of an existing group.
+func UpdateGroup(g Group, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Group, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	existing, err := FetchGroup(g.ID, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	g.CreatedAt = existing.CreatedAt
+	g.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := groups(userTableName, dynaClient).Replace(g); err != nil {
+		if store.IsConditionFailed(err) {
+			return nil, errors.New(ErrorGroupNotFound)
+		}
+		return nil, store.Error(err, ErrorFailedToSaveGroup)
+	}
+	return &g, nil
+}
+
+// DeleteGroup deletes the group's members before the group, so a failed
+// delete can simply be run again.
+func DeleteGroup(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	all, err := FetchMembers(id, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	for _, member := range all {
+		if err := members(userTableName, dynaClient).Delete(store.Key{Partition: id, Sort: member.Email}); err != nil {
+			return store.Error(err, ErrorFailedToDeleteGroup)
+		}
+	}
+	if err := groups(userTableName, dynaClient).Delete(store.Key{Partition: id}); err != nil {
+		return store.Error(err, ErrorFailedToDeleteGroup)
+	}
+	return nil
+}
+
+// AddMember adds an existing user to an existing group. Adding a member
+// again only updates when they were added.
+func AddMember(id string, email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Member, error) {
+	if _, err := FetchGroup(id, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+	u, err := user.FetchUser(email, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Email) == 0 {
+		return nil, errors.New(user.ErrorUserNotFound)
+	}
+
+	member := Member{Email: u.Email, AddedAt: time.Now().UTC().Format(time.RFC3339)}
+	if err := members(userTableName, dynaClient).Put(memberItem{Group: id, Member: member}, ""); err != nil {
+		return nil, store.Error(err, ErrorFailedToUpdateMembership)
+	}
+	return &member, nil
+}
+
+// RemoveMember removes a user from a group. Removing someone who is not a
+// member succeeds.
+func RemoveMember(id string, email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if _, err := FetchGroup(id, userTableName, dynaClient); err != nil {
+		return err
+	}
+	if err := members(userTableName, dynaClient).Delete(store.Key{Partition: id, Sort: email}); err != nil {
+		return store.Error(err, ErrorFailedToUpdateMembership)
+	}
+	return nil
+}
+
+func FetchMembers(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Member, error) {
+	items, err := members(userTableName, dynaClient).Query(id)
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchGroup)
+	}
+	all := make([]Member, 0, len(items))
+	for _, item := range items {
+		all = append(all, item.Member)
+	}
+	return all, nil
+}
+
+// FetchUsers reads the users who are members of the group. Members whose user
+// has since been deleted are left out.
+func FetchUsers(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]user.User, error) {
+	if _, err := FetchGroup(id, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+	members, err := FetchMembers(id, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]string, len(members))
+	for i, member := range members {
+		emails[i] = member.Email
+	}
+	return user.FetchUsers(emails, userTableName, dynaClient)
+}
+
+func (g *Group) validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	g.Description = strings.TrimSpace(g.Description)
+	if errs := validators.ValidateStruct(g); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,111 @@
+package group
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newClient returns a client keeping the users and groups tables in memory,
+// with a user for each of emails.
+func newClient(emails ...string) *testutil.MemoryDynamoDB {
+	client := testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: TableName("test"), PartitionKey: "pk", SortKey: "sk", Indexes: []testutil.Index{{Name: store.SortKeyIndex, PartitionKey: "sk", SortKey: "pk"}}},
+	)
+	for _, email := range emails {
+		client.Put("test", map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String(email)},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		})
+	}
+	return client
+}
+
+func TestGroups(t *testing.T) {
+	t.Run("expect a created group to be read back", func(t *testing.T) {
+		client := newClient()
+		created, err := CreateGroup(Group{Name: " Beta testers "}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if created.ID == "" || created.Name != "Beta testers" {
+			t.Errorf("Expected an id and a trimmed name, got %+v", *created)
+		}
+		fetched, err := FetchGroup(created.ID, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if *fetched != *created {
+			t.Errorf("Expected %+v, got %+v", *created, *fetched)
+		}
+		groups, _ := FetchAllGroups("test", client)
+		if len(groups) != 1 {
+			t.Errorf("Expected one group, got %d", len(groups))
+		}
+	})
+	t.Run("expect validation errors for a group without a name", func(t *testing.T) {
+		_, err := CreateGroup(Group{}, "test", newClient())
+		if _, ok := err.(validators.FieldErrors); !ok {
+			t.Errorf("Expected validation errors, got %v", err)
+		}
+	})
+	t.Run("expect error for a missing group", func(t *testing.T) {
+		client := newClient("alan.oliver@ecs.co.uk")
+		if _, err := FetchGroup("missing", "test", client); err == nil || err.Error() != ErrorGroupNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorGroupNotFound, err)
+		}
+		if _, err := AddMember("missing", "alan.oliver@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorGroupNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorGroupNotFound, err)
+		}
+	})
+	t.Run("expect members to be added, listed and removed", func(t *testing.T) {
+		client := newClient("alan.oliver@ecs.co.uk", "alan.shearer@ecs.co.uk")
+		g, _ := CreateGroup(Group{Name: "Engineering"}, "test", client)
+		for _, email := range []string{"alan.oliver@ecs.co.uk", "alan.shearer@ecs.co.uk"} {
+			if _, err := AddMember(g.ID, email, "test", client); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+		}
+		users, err := FetchUsers(g.ID, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(users) != 2 || users[0].Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected both members, got %+v", users)
+		}
+		if err := RemoveMember(g.ID, "alan.oliver@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		members, _ := FetchMembers(g.ID, "test", client)
+		if len(members) != 1 || members[0].Email != "alan.shearer@ecs.co.uk" {
+			t.Errorf("Expected only alan.shearer@ecs.co.uk to remain, got %+v", members)
+		}
+	})
+	t.Run("expect error when adding a user that does not exist", func(t *testing.T) {
+		client := newClient()
+		g, _ := CreateGroup(Group{Name: "Engineering"}, "test", client)
+		_, err := AddMember(g.ID, "alan.oliver@ecs.co.uk", "test", client)
+		if err == nil || err.Error() != user.ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", user.ErrorUserNotFound, err)
+		}
+	})
+	t.Run("expect deleting a group to delete its members", func(t *testing.T) {
+		client := newClient("alan.oliver@ecs.co.uk")
+		g, _ := CreateGroup(Group{Name: "Engineering"}, "test", client)
+		AddMember(g.ID, "alan.oliver@ecs.co.uk", "test", client)
+		if err := DeleteGroup(g.ID, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if items := client.Items(TableName("test")); len(items) != 0 {
+			t.Errorf("Expected every item to be deleted, got %d left", len(items))
+		}
+	})
+}
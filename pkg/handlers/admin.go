@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// HeaderAdminKey carries the key admin-only requests are authorised by.
+const HeaderAdminKey = "X-Admin-Key"
+
+var ErrorAdminAccessRequired = "admin access required"
+
+// AdminConfig sets the key admin-only requests must present. Admin-only
+// requests are refused while it is empty.
+type AdminConfig struct {
+	Key string
+}
+
+var admin AdminConfig
+
+func ConfigureAdmin(config AdminConfig) {
+	admin = config
+}
+
+// RequireAdmin wraps a handler for operator-only operations, answering 403
+// unless the request carries the admin key.
+func RequireAdmin(next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		if !isAdmin(req) {
+			return errorResponse(req, errors.New(ErrorAdminAccessRequired), http.StatusForbidden)
+		}
+		return next(req, tableName, dynaClient)
+	}
+}
+
+// isAdmin reports whether req carries the admin key.
+func isAdmin(req events.APIGatewayProxyRequest) bool {
+	key := headerValue(req, HeaderAdminKey)
+	return admin.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(admin.Key)) == 1
+}
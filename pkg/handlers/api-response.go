@@ -2,15 +2,42 @@ package handlers
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
+// ContentTypeJSON is the Content-Type of every response, as every body is JSON.
+const ContentTypeJSON = "application/json; charset=utf-8"
+
+// ResponseConfig sets the headers sent on every response, such as security
+// headers. A handler's own headers take precedence. Problems sends every
+// error as RFC 7807 problem details rather than only to requests that
+// accept them, and ProblemTypeBase is the URL their error codes are
+// appended to for their type. RetryAfter is sent as Retry-After with
+// requests answered 429 because the store was throttled.
+type ResponseConfig struct {
+	Headers         map[string]string
+	Problems        bool
+	ProblemTypeBase string
+	RetryAfter      time.Duration
+}
+
+var responses ResponseConfig
+
+func ConfigureResponses(config ResponseConfig) {
+	responses = config
+}
+
 func apiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse, error) {
+	return apiResponseWithHeaders(status, body, nil)
+}
+
+// apiResponseWithHeaders answers with body and the handler's own headers on
+// top of the configured ones.
+func apiResponseWithHeaders(status int, body interface{}, headers map[string]string) (*events.APIGatewayProxyResponse, error) {
 	resp := events.APIGatewayProxyResponse{
-		Headers: map[string]string{
-			"Application-Type": "application/json",
-		},
+		Headers: responseHeaders(headers),
 	}
 	resp.StatusCode = status
 
@@ -18,3 +45,16 @@ func apiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse,
 	resp.Body = string(stringBody)
 	return &resp, nil
 }
+
+func responseHeaders(headers map[string]string) map[string]string {
+	built := make(map[string]string, len(responses.Headers)+len(headers)+1)
+	for name, value := range responses.Headers {
+		built[name] = value
+	}
+	// Every body is JSON, but problem details are JSON of their own type
+	built["Content-Type"] = ContentTypeJSON
+	for name, value := range headers {
+		built[name] = value
+	}
+	return built
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// The attribute handlers read the attribute's name, where there is one, from
+// the "name" path parameter.
+
+func GetAttributes(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	attributes, err := schema.FetchAll(tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, attributes)
+}
+
+func GetAttribute(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	a, err := schema.Fetch(req.PathParameters["name"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, a)
+}
+
+// PutAttribute registers the attribute in the body under the name in the
+// path, replacing any registered under it.
+func PutAttribute(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body schema.Attribute
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(schema.ErrorInvalidAttributeData), http.StatusBadRequest)
+	}
+	body.Name = req.PathParameters["name"]
+	a, err := schema.Put(body, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, a)
+}
+
+func DeleteAttribute(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if err := schema.Delete(req.PathParameters["name"], tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
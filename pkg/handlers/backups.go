@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/backup"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// CreateBackup backs the users table and the tables accompanying it up
+// under the name in the body, or a timestamped name when there is none. It
+// answers 201 once the backups are available and 202 while they are still
+// being created.
+func CreateBackup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if req.Body != "" {
+		if err := jsonbody.Decode(req.Body, &body); err != nil {
+			return errorResponse(req, errors.New(backup.ErrorInvalidBackupName), http.StatusBadRequest)
+		}
+	}
+	if body.Name == "" {
+		body.Name = tableName + "-" + time.Now().UTC().Format("20060102150405")
+	}
+	b, err := backup.Create(body.Name, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	if !b.Available() {
+		return apiResponse(http.StatusAccepted, b)
+	}
+	return apiResponse(http.StatusCreated, b)
+}
+
+func GetBackups(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	backups, err := backup.List(tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, backups)
+}
+
+func GetBackup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	b, err := backup.Fetch(req.PathParameters["name"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, b)
+}
+
+// RestoreBackup restores the backup in the path to the table named in the
+// body, and its tables beside it. It answers 201 once the tables are active
+// and 202 while the restore is still running.
+func RestoreBackup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Table string `json:"table"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(backup.ErrorInvalidTableName), http.StatusBadRequest)
+	}
+	r, err := backup.RestoreTable(req.PathParameters["name"], body.Table, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	if !r.Verified {
+		return apiResponse(http.StatusAccepted, r)
+	}
+	return apiResponse(http.StatusCreated, r)
+}
+
+// VerifyRestore reports on the restore of the backup in the path to the
+// table in the table query parameter.
+func VerifyRestore(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	r, err := backup.Verify(req.PathParameters["name"], req.QueryStringParameters["table"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, r)
+}
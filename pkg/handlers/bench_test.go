@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// benchClient answers with fixed outputs and, unlike testutil.MockDynamoDB,
+// records nothing, so only the handler's own allocations are reported.
+type benchClient struct {
+	dynamodbiface.DynamoDBAPI
+	get  *dynamodb.GetItemOutput
+	scan *dynamodb.ScanOutput
+}
+
+func (c *benchClient) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return c.get, nil
+}
+
+func (c *benchClient) PutItem(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *benchClient) Scan(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return c.scan, nil
+}
+
+func (c *benchClient) Query(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+var benchUser = user.User{
+	Email:       "alan.oliver@ecs.co.uk",
+	FirstName:   "Alan",
+	LastName:    "Oliver",
+	Phone:       aws.String("+447700900123"),
+	DateOfBirth: aws.String("1980-01-01"),
+	Address:     &user.Address{Line1: "1 High Street", City: "London", PostalCode: "SW1A 1AA", Country: "GB"},
+	Company:     aws.String("ECS"),
+	Metadata:    map[string]string{"source": "benchmark"},
+	Tags:        []string{"beta", "staff"},
+}
+
+func benchItem(b *testing.B, u user.User) map[string]*dynamodb.AttributeValue {
+	item, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return item
+}
+
+// BenchmarkCreateUser measures the whole write path: decoding and
+// validating the body, marshalling the item and encoding the response.
+func BenchmarkCreateUser(b *testing.B) {
+	body := `{"email":"alan.oliver@ecs.co.uk","firstName":"Alan","lastName":"Oliver","phone":"+447700900123",` +
+		`"dateOfBirth":"1980-01-01","address":{"line1":"1 High Street","city":"London","postalCode":"SW1A 1AA","country":"GB"},` +
+		`"company":"ECS","metadata":{"source":"benchmark"},"tags":["beta","staff"]}`
+	req := events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: body}
+	client := &benchClient{get: &dynamodb.GetItemOutput{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp, _ := Route(req, "test", client); resp.StatusCode != 201 {
+			b.Fatalf("Expected 201, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// BenchmarkGetUser measures the read path: unmarshalling the item and
+// encoding the response.
+func BenchmarkGetUser(b *testing.B) {
+	req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": benchUser.Email}}
+	client := &benchClient{get: &dynamodb.GetItemOutput{Item: benchItem(b, benchUser)}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp, _ := Route(req, "test", client); resp.StatusCode != 200 {
+			b.Fatalf("Expected 200, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// BenchmarkListUsers measures listing a page of 100 users: unmarshalling the
+// scan and encoding the response.
+func BenchmarkListUsers(b *testing.B) {
+	items := make([]map[string]*dynamodb.AttributeValue, 100)
+	for i := range items {
+		u := benchUser
+		u.Email = "user" + strconv.Itoa(i) + "@ecs.co.uk"
+		items[i] = benchItem(b, u)
+	}
+	req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/"}
+	client := &benchClient{scan: &dynamodb.ScanOutput{Items: items}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp, _ := Route(req, "test", client); resp.StatusCode != 200 {
+			b.Fatalf("Expected 200, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/base64"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// BodyConfig sets the largest request body, in bytes, the API reads. Larger
+// bodies are refused with 413 before any handler decodes them. Zero leaves
+// only the limit jsonbody.Decode applies itself.
+type BodyConfig struct {
+	MaxBytes int
+}
+
+var bodies BodyConfig
+
+func ConfigureBodies(config BodyConfig) {
+	bodies = config
+}
+
+// bodyTooLarge reports whether req's body is over the configured limit,
+// measured decoded when API Gateway passed it base64 encoded.
+func bodyTooLarge(req events.APIGatewayProxyRequest) bool {
+	if bodies.MaxBytes <= 0 {
+		return false
+	}
+	size := len(req.Body)
+	if req.IsBase64Encoded {
+		size = base64.StdEncoding.DecodedLen(size)
+	}
+	return size > bodies.MaxBytes
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/config"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// The contract tests snapshot the response Route gives each canonical
+// request into testdata/contract, so a change to what goes over the wire
+// shows up in review as a change to a golden file. After an intended change,
+// regenerate them with:
+//
+//	go test ./pkg/handlers -run TestContract -update
+var update = flag.Bool("update", false, "rewrite the contract golden files")
+
+// timestamps are replaced in golden files, as they change on every run.
+var timestamps = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?Z`)
+
+type contract struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+var contractUser = user.User{
+	Email:     "alan.oliver@ecs.co.uk",
+	FirstName: "Alan",
+	LastName:  "Oliver",
+	Tags:      []string{"beta"},
+	Status:    user.StatusActive,
+	CreatedAt: "2021-01-01T00:00:00Z",
+}
+
+func userItem(t *testing.T, u user.User) map[string]*dynamodb.AttributeValue {
+	item, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestContract(t *testing.T) {
+	ConfigureResponses(ResponseConfig{Headers: config.DefaultSecurityHeaders})
+	defer ConfigureResponses(ResponseConfig{})
+	ConfigureAdmin(AdminConfig{Key: "contract"})
+	defer ConfigureAdmin(AdminConfig{})
+
+	tests := []struct {
+		name string
+		req  events.APIGatewayProxyRequest
+		db   func(t *testing.T) *testutil.MockDynamoDB
+	}{
+		{
+			name: "get-user",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": contractUser.Email}},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: userItem(t, contractUser)}}
+			},
+		},
+		{
+			name: "get-user-fields",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": contractUser.Email, "fields": "email,lastName,tags"}},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: userItem(t, contractUser)}}
+			},
+		},
+		{
+			name: "get-user-missing",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": contractUser.Email}},
+		},
+		{
+			name: "get-user-translated-error",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Path:                  "/",
+				Headers:               map[string]string{"Accept-Language": "es"},
+				QueryStringParameters: map[string]string{"email": contractUser.Email},
+			},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemErr: errors.New("connection reset")}
+			},
+		},
+		{
+			name: "get-user-store-unavailable",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": contractUser.Email}},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemErr: errors.New(store.ErrorUnavailable)}
+			},
+		},
+		{
+			name: "list-users",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/"},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{userItem(t, contractUser)}}}
+			},
+		},
+		{
+			name: "list-users-invalid-inactive-days",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"inactiveDays": "0"}},
+		},
+		{
+			name: "list-users-invalid-limit",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"limit": "0"}},
+		},
+		{
+			name: "list-users-cursors-not-configured",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"cursor": "e30.c2ln"}},
+		},
+		{
+			name: "create-user",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: `{"email":"Alan.Oliver@ecs.co.uk","firstName":"Alan","lastName":"Oliver"}`},
+		},
+		{
+			name: "create-user-invalid-body",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: `{`},
+		},
+		{
+			name: "create-user-validation-errors",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: `{"email":"not-an-email","lastName":"Oliver"}`},
+		},
+		{
+			name: "create-user-already-exists",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: `{"email":"alan.oliver@ecs.co.uk","firstName":"Alan","lastName":"Oliver"}`},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: userItem(t, contractUser)}}
+			},
+		},
+		{
+			name: "update-user",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "PUT", Path: "/", Body: `{"email":"alan.oliver@ecs.co.uk","firstName":"Alan","lastName":"Shearer"}`},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: userItem(t, contractUser)}}
+			},
+		},
+		{
+			name: "update-user-not-found",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "PUT", Path: "/", Body: `{"email":"alan.oliver@ecs.co.uk","lastName":"Shearer"}`},
+		},
+		{
+			name: "update-user-email-required",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "PUT", Path: "/", Body: `{"lastName":"Shearer"}`},
+		},
+		{
+			name: "delete-user",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Path: "/users/alan.oliver@ecs.co.uk"},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+					return &dynamodb.DeleteItemOutput{Attributes: userItem(t, contractUser)}, nil
+				}}
+			},
+		},
+		{
+			name: "delete-user-not-found",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Path: "/users/alan.oliver@ecs.co.uk"},
+		},
+		{
+			name: "delete-user-email-required",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Path: "/"},
+		},
+		{
+			name: "delete-users-none-selected",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/deletions", Headers: map[string]string{HeaderAdminKey: "contract"}, Body: `{}`},
+		},
+		{
+			name: "add-tags",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/users/alan.oliver@ecs.co.uk/tags", Body: `{"tags":["beta"]}`},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: userItem(t, contractUser)}}
+			},
+		},
+		{
+			name: "get-preferences-user-not-found",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/preferences"},
+		},
+		{
+			name: "get-sessions",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/sessions", Headers: map[string]string{HeaderAdminKey: "contract"}},
+		},
+		{
+			name: "get-sessions-session-required",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/sessions"},
+		},
+		{
+			name: "login-invalid-credentials",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/login", Body: `{"email":"alan.oliver@ecs.co.uk","password":"wrong"}`},
+		},
+		{
+			name: "list-groups",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/groups"},
+		},
+		{
+			name: "get-group-not-found",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/groups/engineering"},
+		},
+		{
+			name: "list-backups-admin-required",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/backups"},
+		},
+		{
+			name: "get-user-events-not-configured",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/events"},
+		},
+		{
+			name: "list-webhooks-admin-required",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/webhooks"},
+		},
+		{
+			name: "get-domain-counts",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/projections/domains"},
+			db: func(t *testing.T) *testutil.MockDynamoDB {
+				return &testutil.MockDynamoDB{QueryOutput: &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+					{"pk": {S: aws.String("DOMAINS")}, "sk": {S: aws.String("ecs.co.uk")}, "users": {N: aws.String("2")}},
+				}}}
+			},
+		},
+		{
+			name: "export-users-not-configured",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/exports", Headers: map[string]string{HeaderAdminKey: "contract"}},
+		},
+		{
+			name: "graphql-not-configured",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/graphql", Body: `{"query":"{ user(email: \"alan.oliver@ecs.co.uk\") { email } }"}`},
+		},
+		{
+			name: "search-invalid-query",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/search"},
+		},
+		{
+			name: "unhandled-method",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "PATCH", Path: "/"},
+		},
+		{
+			name: "unhandled-method-group-member",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/groups/engineering/members/alan.oliver@ecs.co.uk"},
+		},
+		{
+			name: "options-user-tags",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/users/alan.oliver@ecs.co.uk/tags"},
+		},
+		{
+			name: "route-not-found-problem",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: "GET",
+				Path:       "/users/alan.oliver@ecs.co.uk/unknown",
+				Headers:    map[string]string{"Accept": "application/problem+json", "Accept-Language": "fr"},
+			},
+		},
+		{
+			name: "route-not-found",
+			req:  events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &testutil.MockDynamoDB{}
+			if tt.db != nil {
+				db = tt.db(t)
+			}
+			tt.req.RequestContext = events.APIGatewayProxyRequestContext{DomainName: "api.example.com", Stage: "staging"}
+			resp, err := Route(tt.req, "test", db)
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+
+			got := snapshot(t, resp)
+			path := filepath.Join("testdata", "contract", tt.name+".json")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Expected a golden file, run with -update to create it: %s", err.Error())
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Response differs from %s, run with -update if intended\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// snapshot renders resp as indented JSON, embedding a JSON body as JSON so
+// golden files diff field by field.
+func snapshot(t *testing.T, resp *events.APIGatewayProxyResponse) []byte {
+	body := json.RawMessage(resp.Body)
+	if !json.Valid(body) {
+		body, _ = json.Marshal(resp.Body)
+	}
+	out, err := json.MarshalIndent(contract{Status: resp.StatusCode, Headers: resp.Headers, Body: body}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(timestamps.ReplaceAll(out, []byte("<timestamp>")), '\n')
+}
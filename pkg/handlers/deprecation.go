@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// MetricDeprecatedRequests counts the requests answered by something
+// deprecated, by what it was.
+const MetricDeprecatedRequests = "DeprecatedRequests"
+
+// Deprecation marks a version or route clients should move off: Since is
+// when it was deprecated and Sunset when it stops being served, either zero
+// when not announced, and Link documents what replaces it.
+type Deprecation struct {
+	Since  time.Time
+	Sunset time.Time
+	Link   string
+}
+
+// DeprecationConfig marks what is deprecated, by version, such as v1, by
+// route pattern, such as /users/{email}, or by method and route pattern,
+// such as DELETE /users/{email}. A route's own deprecation takes precedence
+// over its version's.
+type DeprecationConfig struct {
+	Deprecations map[string]Deprecation
+}
+
+var deprecations DeprecationConfig
+
+func ConfigureDeprecations(config DeprecationConfig) {
+	deprecations = config
+}
+
+// deprecation returns what req, answered by the route with pattern, is
+// deprecated as and how, if it is.
+func deprecation(req events.APIGatewayProxyRequest, pattern string) (string, *Deprecation) {
+	for _, key := range []string{req.HTTPMethod + " " + pattern, pattern, requestVersion(req)} {
+		if d, ok := deprecations.Deprecations[key]; ok {
+			return key, &d
+		}
+	}
+	return "", nil
+}
+
+// deprecate announces on resp that what it answered is deprecated, with the
+// Deprecation and Sunset headers of RFC 9745 and RFC 8594, a deprecation
+// link and a Warning, which it returns, and counts the request.
+func deprecate(resp *events.APIGatewayProxyResponse, key string, d *Deprecation) string {
+	metrics.Count(MetricDeprecatedRequests, metrics.Dimensions{"Deprecated": key})
+	warning := key + " is deprecated"
+	if !d.Sunset.IsZero() {
+		warning += " and will be removed after " + d.Sunset.UTC().Format(time.RFC3339)
+	}
+	if resp == nil {
+		return warning
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	// Deprecations without a date are announced as earlier drafts did
+	resp.Headers["Deprecation"] = "true"
+	if !d.Since.IsZero() {
+		resp.Headers["Deprecation"] = "@" + strconv.FormatInt(d.Since.Unix(), 10)
+	}
+	if !d.Sunset.IsZero() {
+		resp.Headers["Sunset"] = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	if d.Link != "" {
+		link := `<` + d.Link + `>; rel="deprecation"`
+		if existing := resp.Headers["Link"]; existing != "" {
+			link = existing + ", " + link
+		}
+		resp.Headers["Link"] = link
+	}
+	resp.Headers["Warning"] = `299 - "` + warning + `"`
+	return warning
+}
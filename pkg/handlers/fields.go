@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// fields returns the attributes req asks for with its comma separated fields
+// query parameter, such as ?fields=email,lastName, or nil for all of them.
+func fields(req events.APIGatewayProxyRequest) []string {
+	var names []string
+	for _, name := range strings.Split(req.QueryStringParameters["fields"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// withEmail returns names with email added, so a projected read still has
+// the key a list resumes from.
+func withEmail(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if name == "email" {
+			return names
+		}
+	}
+	return append(names[:len(names):len(names)], "email")
+}
+
+// sparse renders v, an object or a list of objects, with only the named
+// fields of each object, so fields left empty are not sent at all. Links are
+// kept, as they are not attributes. It returns v as it is when no fields are
+// named.
+func sparse(v interface{}, names []string) interface{} {
+	if len(names) == 0 {
+		return v
+	}
+	// Like apiResponse, this relies on the API's types always marshalling
+	encoded, _ := json.Marshal(v)
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &list); err == nil {
+		for i, object := range list {
+			list[i] = only(object, names)
+		}
+		return list
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return v
+	}
+	return only(object, names)
+}
+
+func only(object map[string]json.RawMessage, names []string) map[string]json.RawMessage {
+	kept := make(map[string]json.RawMessage, len(names)+1)
+	for _, name := range append(names[:len(names):len(names)], "links") {
+		if value, ok := object[name]; ok {
+			kept[name] = value
+		}
+	}
+	return kept
+}
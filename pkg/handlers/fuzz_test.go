@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// FuzzRoute sends arbitrary bodies to every route that reads one, checking a
+// malformed or adversarial body is answered rather than panicking.
+func FuzzRoute(f *testing.F) {
+	routes := []struct{ method, path string }{
+		{"POST", "/"},
+		{"PUT", "/"},
+		{"POST", "/deletions"},
+		{"POST", "/login"},
+		{"POST", "/password-resets"},
+		{"POST", "/password-resets/confirm"},
+		{"POST", "/email-changes/confirm"},
+		{"POST", "/erasures"},
+		{"POST", "/avatars"},
+		{"PUT", "/avatars"},
+		{"POST", "/invitations"},
+		{"POST", "/invitations/redeem"},
+		{"POST", "/groups"},
+		{"PUT", "/groups/engineering"},
+		{"POST", "/groups/engineering/members"},
+		{"POST", "/organizations"},
+		{"PUT", "/organizations/ecs"},
+		{"PUT", "/users/alan.oliver@ecs.co.uk/preferences"},
+		{"POST", "/users/alan.oliver@ecs.co.uk/tags"},
+		{"PUT", "/users/alan.oliver@ecs.co.uk/password"},
+		{"POST", "/users/alan.oliver@ecs.co.uk/email"},
+	}
+	for i := range routes {
+		f.Add(uint8(i), `{"email":"alan.oliver@ecs.co.uk","firstName":"Alan","lastName":"Oliver","tags":["beta"]}`)
+		f.Add(uint8(i), `{"email":["not","a","string"],"address":{"line1":{}}}`)
+	}
+	f.Add(uint8(0), `{"metadata":{"a":"b"},"address":[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]}`)
+	f.Add(uint8(0), `null`)
+
+	f.Fuzz(func(t *testing.T, route uint8, body string) {
+		r := routes[int(route)%len(routes)]
+		resp, err := Route(events.APIGatewayProxyRequest{HTTPMethod: r.method, Path: r.path, Body: body}, "test", &testutil.MockDynamoDB{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if resp == nil || resp.StatusCode == 0 {
+			t.Fatalf("Expected a response to %s %s", r.method, r.path)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/graph"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+var (
+	ErrorGraphQLNotConfigured  = "graphql is not configured"
+	ErrorInvalidGraphQLRequest = "invalid graphql request"
+)
+
+// GraphQLConfig enables the GraphQL endpoint.
+type GraphQLConfig struct {
+	Enabled bool
+}
+
+var graphQL GraphQLConfig
+
+func ConfigureGraphQL(config GraphQLConfig) {
+	graphQL = config
+}
+
+// GraphQL answers a GraphQL request with 200 and its result, errors
+// included. Pages of users are bounded by the same limits as GET All. A
+// request with an identity token, and without the admin key, may only change
+// the user the identity owns.
+func GraphQL(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if !graphQL.Enabled {
+		return errorResponse(req, errors.New(ErrorGraphQLNotConfigured), http.StatusNotImplemented)
+	}
+	var body graph.Request
+	if err := jsonbody.Decode(req.Body, &body); err != nil || body.Query == "" {
+		return errorResponse(req, errors.New(ErrorInvalidGraphQLRequest), http.StatusBadRequest)
+	}
+	resolver := &graph.Resolver{
+		TableName:      tableName,
+		Client:         dynaClient,
+		Limit:          limits.Default,
+		MaxLimit:       limits.Max,
+		RequestContext: req.RequestContext,
+	}
+	if token := headerValue(req, oidc.Header()); oidc.Enabled() && token != "" {
+		identity, err := oidc.Verify(token)
+		if err != nil {
+			return errorResponse(req, err, http.StatusUnauthorized)
+		}
+		if !isAdmin(req) {
+			resolver.Identity = identity
+		}
+	}
+	lang := i18n.Negotiate(headerValue(req, "Accept-Language"))
+	result := graph.Execute(context.Background(), resolver, body, graphQLErrorPresenter(lang))
+	return apiResponseWithHeaders(http.StatusOK, result, map[string]string{"Content-Language": lang})
+}
+
+// graphQLErrorPresenter presents the errors resolvers return as the REST
+// endpoints do: translated into lang, with their code, and with each invalid
+// field for validation errors. Errors in the request itself are presented
+// as gqlgen words them.
+func graphQLErrorPresenter(lang string) graphql.ErrorPresenterFunc {
+	return func(ctx context.Context, err error) *gqlerror.Error {
+		presented := graphql.DefaultErrorPresenter(ctx, err)
+		cause := presented.Unwrap()
+		if cause == nil {
+			return presented
+		}
+		var fieldErrs validators.FieldErrors
+		if errors.As(cause, &fieldErrs) {
+			code := i18n.Code(ErrorValidationFailed)
+			presented.Message = i18n.Translate(lang, code, "", ErrorValidationFailed)
+			presented.Extensions = map[string]interface{}{"code": code, "errors": translateFieldErrors(lang, fieldErrs)}
+			return presented
+		}
+		code := i18n.Code(cause.Error())
+		presented.Message = i18n.Translate(lang, code, "", cause.Error())
+		presented.Extensions = map[string]interface{}{"code": code}
+		return presented
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/group"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// The group handlers read the group id, and the member's email where there
+// is one, from the "id" and "email" path parameters.
+
+func GetGroup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	g, err := group.FetchGroup(req.PathParameters["id"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, g)
+}
+
+func GetGroups(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	groups, err := group.FetchAllGroups(tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, groups)
+}
+
+func CreateGroup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body group.Group
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(group.ErrorInvalidGroupData), http.StatusBadRequest)
+	}
+	g, err := group.CreateGroup(body, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, g)
+}
+
+func UpdateGroup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body group.Group
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(group.ErrorInvalidGroupData), http.StatusBadRequest)
+	}
+	body.ID = req.PathParameters["id"]
+	g, err := group.UpdateGroup(body, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, g)
+}
+
+func DeleteGroup(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if err := group.DeleteGroup(req.PathParameters["id"], tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func GetGroupUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	users, err := group.FetchUsers(req.PathParameters["id"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, users)
+}
+
+func AddGroupMember(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(group.ErrorInvalidGroupData), http.StatusBadRequest)
+	}
+	member, err := group.AddMember(req.PathParameters["id"], body.Email, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, member)
+}
+
+func RemoveGroupMember(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if err := group.RemoveMember(req.PathParameters["id"], req.PathParameters["email"], tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
@@ -1,9 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/backup"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/flags"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/group"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/invitation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/organization"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/relation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/tenant"
 	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,49 +37,553 @@ import (
 
 var ErrorMethodNotAllowed = "Error Method Not Allowed"
 
+const (
+	// HeaderResultsTruncated is set on list responses that stopped at a scan limit.
+	HeaderResultsTruncated = "X-Results-Truncated"
+	// HeaderNextCursor carries the cursor a truncated list resumes from, passed
+	// back as the cursor query parameter.
+	HeaderNextCursor = "X-Next-Cursor"
+)
+
 type ErrorBody struct {
 	ErrorMsg *string `json:"error,omitempty"`
+	Code     *string `json:"code,omitempty"`
+}
+
+type ValidationErrorBody struct {
+	Errors validators.FieldErrors `json:"errors"`
+}
+
+// errorStatuses maps errors from the user, group, invitation and relation packages to a response status when it
+// differs from the handler's default.
+var errorStatuses = map[string]int{
+	ErrorAdminAccessRequired:                    http.StatusForbidden,
+	ErrorGraphQLNotConfigured:                   http.StatusNotImplemented,
+	ErrorInvalidGraphQLRequest:                  http.StatusBadRequest,
+	ErrorRouteNotFound:                          http.StatusNotFound,
+	backup.ErrorBackupAlreadyExists:             http.StatusConflict,
+	backup.ErrorBackupNotAvailable:              http.StatusConflict,
+	backup.ErrorBackupNotFound:                  http.StatusNotFound,
+	backup.ErrorInvalidBackupName:               http.StatusBadRequest,
+	backup.ErrorInvalidTableName:                http.StatusBadRequest,
+	backup.ErrorRestoreNotFound:                 http.StatusNotFound,
+	backup.ErrorTableAlreadyExists:              http.StatusConflict,
+	credentials.ErrorInvalidCredentials:         http.StatusUnauthorized,
+	credentials.ErrorInvalidMFACode:             http.StatusUnauthorized,
+	credentials.ErrorMFANotConfigured:           http.StatusNotImplemented,
+	credentials.ErrorMFANotEnrolled:             http.StatusBadRequest,
+	credentials.ErrorMFARequired:                http.StatusUnauthorized,
+	credentials.ErrorPasswordNotSet:             http.StatusForbidden,
+	cursor.ErrorCursorsNotConfigured:            http.StatusNotImplemented,
+	user.ErrorAccountDeactivated:                http.StatusForbidden,
+	user.ErrorAccountSuspended:                  http.StatusForbidden,
+	user.ErrorInvalidStatusTransition:           http.StatusConflict,
+	user.ErrorMergeSameUser:                     http.StatusBadRequest,
+	user.ErrorEmailChangeNotConfigured:          http.StatusNotImplemented,
+	user.ErrorEmailRequired:                     http.StatusBadRequest,
+	user.ErrorEmailUnchanged:                    http.StatusBadRequest,
+	user.ErrorInvalidEmailChangeToken:           http.StatusBadRequest,
+	user.ErrorInvalidInactiveDays:               http.StatusBadRequest,
+	user.ErrorExportNotFound:                    http.StatusNotFound,
+	user.ErrorExportsNotConfigured:              http.StatusNotImplemented,
+	user.ErrorUnknownExportFormat:               http.StatusBadRequest,
+	user.ErrorImportNotFound:                    http.StatusNotFound,
+	user.ErrorImportsNotConfigured:              http.StatusNotImplemented,
+	user.ErrorInvalidCSVHeader:                  http.StatusBadRequest,
+	user.ErrorNoUsersSelected:                   http.StatusBadRequest,
+	user.ErrorTooManyUsers:                      http.StatusBadRequest,
+	user.ErrorUserAlreadyExists:                 http.StatusConflict,
+	user.ErrorConditionFailed:                   http.StatusPreconditionFailed,
+	schema.ErrorAttributeNotFound:               http.StatusNotFound,
+	schema.ErrorInvalidAttributeData:            http.StatusBadRequest,
+	search.ErrorInvalidQuery:                    http.StatusBadRequest,
+	search.ErrorUnavailable:                     http.StatusServiceUnavailable,
+	session.ErrorInvalidSession:                 http.StatusUnauthorized,
+	session.ErrorNotOwnSession:                  http.StatusForbidden,
+	session.ErrorSessionNotFound:                http.StatusNotFound,
+	credentials.ErrorInvalidResetToken:          http.StatusBadRequest,
+	credentials.ErrorPasswordResetNotConfigured: http.StatusNotImplemented,
+	group.ErrorGroupNotFound:                    http.StatusNotFound,
+	group.ErrorInvalidGroupData:                 http.StatusBadRequest,
+	organization.ErrorInvalidOrganizationData:   http.StatusBadRequest,
+	organization.ErrorOrganizationNotFound:      http.StatusNotFound,
+	invitation.ErrorInvalidInvitationData:       http.StatusBadRequest,
+	invitation.ErrorInvitationExpired:           http.StatusGone,
+	invitation.ErrorInvitationNotFound:          http.StatusNotFound,
+	invitation.ErrorInvitationRedeemed:          http.StatusConflict,
+	relation.ErrorInvalidRelationData:           http.StatusBadRequest,
+	relation.ErrorInvalidRelationType:           http.StatusBadRequest,
+	relation.ErrorRelationCycle:                 http.StatusConflict,
+	relation.ErrorSelfRelation:                  http.StatusBadRequest,
+	user.ErrorAvatarNotUploaded:                 http.StatusBadRequest,
+	user.ErrorAvatarTooLarge:                    http.StatusBadRequest,
+	user.ErrorAvatarsNotConfigured:              http.StatusNotImplemented,
+	user.ErrorConcurrentUpdate:                  http.StatusConflict,
+	user.ErrorEventSourcingNotConfigured:        http.StatusNotImplemented,
+	user.ErrorInvalidAvatarKey:                  http.StatusBadRequest,
+	user.ErrorUnsupportedAvatarType:             http.StatusBadRequest,
+	user.ErrorUserNotFound:                      http.StatusNotFound,
+	oauth.ErrorFailedToValidateToken:            http.StatusServiceUnavailable,
+	oauth.ErrorInsufficientScope:                http.StatusForbidden,
+	oauth.ErrorInvalidToken:                     http.StatusUnauthorized,
+	oauth.ErrorTokenRequired:                    http.StatusUnauthorized,
+	oidc.ErrorFailedToVerifyIdentity:            http.StatusServiceUnavailable,
+	oidc.ErrorInvalidIdentity:                   http.StatusUnauthorized,
+	oidc.ErrorNotOwnUser:                        http.StatusForbidden,
+	quota.ErrorQuotaExceeded:                    http.StatusTooManyRequests,
+	store.ErrorThrottled:                        http.StatusTooManyRequests,
+	store.ErrorTimeout:                          http.StatusGatewayTimeout,
+	store.ErrorUnavailable:                      http.StatusServiceUnavailable,
+	user.ErrorDisposableEmail:                   http.StatusBadRequest,
+	user.ErrorErasureNotFound:                   http.StatusNotFound,
+	user.ErrorInvalidEmail:                      http.StatusBadRequest,
+	user.ErrorUndeliverableEmail:                http.StatusBadRequest,
+	user.ErrorUnknownField:                      http.StatusBadRequest,
+	webhook.ErrorInvalidWebhookData:             http.StatusBadRequest,
+	tenant.ErrorFailedToResolveTenant:           http.StatusServiceUnavailable,
+	tenant.ErrorInvalidTenant:                   http.StatusBadRequest,
+	tenant.ErrorUnknownTenant:                   http.StatusNotFound,
+	webhook.ErrorInvalidWebhookEvents:           http.StatusBadRequest,
+	webhook.ErrorInvalidWebhookURL:              http.StatusBadRequest,
+	webhook.ErrorWebhookNotFound:                http.StatusNotFound,
+	webhook.ErrorWebhooksNotConfigured:          http.StatusNotImplemented,
+}
+
+// errorResponse writes err in the language negotiated from the request's
+// Accept-Language header, alongside the stable code clients should match on,
+// as problem details when the request wants them.
+func errorResponse(req events.APIGatewayProxyRequest, err error, defaultStatus int) (*events.APIGatewayProxyResponse, error) {
+	lang := i18n.Negotiate(headerValue(req, "Accept-Language"))
+
+	headers := map[string]string{"Content-Language": lang}
+	var fieldErrs validators.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		if wantsProblem(req) {
+			code := i18n.Code(ErrorValidationFailed)
+			return problemResponse(req, http.StatusBadRequest, code, i18n.Translate(lang, code, "", ErrorValidationFailed), translateFieldErrors(lang, fieldErrs), headers)
+		}
+		return apiResponseWithHeaders(http.StatusBadRequest, ValidationErrorBody{translateFieldErrors(lang, fieldErrs)}, headers)
+	}
+	status, ok := errorStatuses[err.Error()]
+	if !ok {
+		status = defaultStatus
+	}
+	if status == http.StatusTooManyRequests && responses.RetryAfter > 0 {
+		headers["Retry-After"] = strconv.Itoa(int((responses.RetryAfter + time.Second - 1) / time.Second))
+	}
+	code := i18n.Code(err.Error())
+	message := i18n.Translate(lang, code, "", err.Error())
+	if wantsProblem(req) {
+		return problemResponse(req, status, code, message, nil, headers)
+	}
+	return apiResponseWithHeaders(status, ErrorBody{
+		ErrorMsg: aws.String(message),
+		Code:     aws.String(code),
+	}, headers)
+}
+
+func translateFieldErrors(lang string, fieldErrs validators.FieldErrors) validators.FieldErrors {
+	translated := make(validators.FieldErrors, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		t := *fieldErr
+		t.Message = i18n.Translate(lang, "validation."+t.Rule+"."+t.Code, t.Param, t.Message)
+		translated[i] = &t
+	}
+	return translated
+}
+
+func headerValue(req events.APIGatewayProxyRequest, name string) string {
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
 }
 
 func GetUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
-	email := req.QueryStringParameters["email"]
+	email := strings.ToLower(strings.TrimSpace(req.QueryStringParameters["email"]))
+	names := fields(req)
 	if len(email) > 0 {
+		if _, ok := req.QueryStringParameters["asOf"]; ok {
+			return getUserAsOf(req, email, tableName, dynaClient)
+		}
 		// Get single user
-		result, err := user.FetchUser(email, tableName, dynaClient)
+		consistent, _ := strconv.ParseBool(req.QueryStringParameters["consistent"])
+		result, err := user.FetchUserWithOptions(email, tableName, dynaClient, user.FetchOptions{
+			ConsistentRead: consistent,
+			Fields:         names,
+		})
 		if err != nil {
-			return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+			return errorResponse(req, err, http.StatusInternalServerError)
 		}
-		return apiResponse(http.StatusOK, result)
+		return apiResponse(http.StatusOK, sparse(userResource(req, result), names))
 	}
 
 	// Get all users
-	result, err := user.FetchAllUsers(tableName, dynaClient)
+	options := user.FetchOptions{Tag: req.QueryStringParameters["tag"], Fields: withEmail(names)}
+	if days := req.QueryStringParameters["inactiveDays"]; days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return errorResponse(req, errors.New(user.ErrorInvalidInactiveDays), http.StatusBadRequest)
+		}
+		options.InactiveSince = time.Now().AddDate(0, 0, -n)
+	}
+	n, err := limit(req)
+	if err != nil {
+		return errorResponse(req, err, http.StatusBadRequest)
+	}
+	options.Limit = n
+	if token := req.QueryStringParameters["cursor"]; token != "" {
+		var start user.ScanPosition
+		if err := cursor.Decode(token, &start); err != nil {
+			return errorResponse(req, err, http.StatusBadRequest)
+		}
+		if !start.Valid() {
+			return errorResponse(req, errors.New(cursor.ErrorInvalidCursor), http.StatusBadRequest)
+		}
+		options.Start = &start
+	}
+	result, err := user.ScanUsersWithOptions(tableName, dynaClient, options)
 	if err != nil {
-		return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+		return errorResponse(req, err, http.StatusInternalServerError)
 	}
-	return apiResponse(http.StatusOK, result)
+	headers := map[string]string{}
+	var next string
+	if result.Truncated {
+		headers[HeaderResultsTruncated] = "true"
+		if result.Next != nil && cursor.Enabled() {
+			next, err = cursor.Encode(result.Next)
+			if err != nil {
+				return errorResponse(req, err, http.StatusInternalServerError)
+			}
+			headers[HeaderNextCursor] = next
+		}
+	}
+	headers["Link"] = listLinks(req, next)
+	return apiResponseWithHeaders(http.StatusOK, sparse(userResources(req, result.Users), names), headers)
 }
 
 func CreateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
-	newUser, err := user.CreateUser(req, tableName, dynaClient)
+	newUser, err := user.CreateUserAs(withoutLinks(req), requestIdentity(req), tableName, dynaClient)
 	if err != nil {
-		return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+		return errorResponse(req, err, http.StatusInternalServerError)
 	}
-	return apiResponse(http.StatusCreated, newUser)
+	return apiResponse(http.StatusCreated, userResource(req, newUser))
 }
 
 func UpdateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
-	newUser, err := user.UpdateUser(req, tableName, dynaClient)
+	newUser, err := user.UpdateUserAs(withoutLinks(req), requestIdentity(req), tableName, dynaClient)
 	if err != nil {
-		return apiResponse(http.StatusInternalServerError, ErrorBody{aws.String(err.Error())})
+		return errorResponse(req, err, http.StatusInternalServerError)
 	}
-	return apiResponse(http.StatusOK, newUser)
+	return apiResponse(http.StatusOK, userResource(req, newUser))
 }
 
+// DeleteUser deletes the user the request names or, while the soft delete
+// flag is on for them, deactivates them so they can be reactivated.
 func DeleteUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	email := req.PathParameters["email"]
+	if email == "" {
+		email = req.QueryStringParameters["email"]
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email != "" && flags.Enabled(flags.SoftDelete, email) {
+		if _, err := user.SetStatusAs(email, user.StatusDeactivated, audit.ActorOf(req), tableName, dynaClient); err != nil {
+			return errorResponse(req, err, http.StatusBadRequest)
+		}
+		return apiResponse(http.StatusOK, nil)
+	}
 	err := user.DeleteUser(req, tableName, dynaClient)
 	if err != nil {
-		return apiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		return errorResponse(req, err, http.StatusBadRequest)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func DeleteUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body user.BulkDelete
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	result, err := user.DeleteUsersAs(body, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	lang := i18n.Negotiate(headerValue(req, "Accept-Language"))
+	for i, outcome := range result.Outcomes {
+		if outcome.Error != "" {
+			result.Outcomes[i].Code = i18n.Code(outcome.Error)
+			result.Outcomes[i].Error = i18n.Translate(lang, result.Outcomes[i].Code, "", outcome.Error)
+		}
+	}
+	return apiResponseWithHeaders(http.StatusOK, result, map[string]string{"Content-Language": lang})
+}
+
+func ExportUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	job, err := user.StartExport(req.QueryStringParameters["format"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponseWithHeaders(http.StatusAccepted, job, map[string]string{"Location": "/exports/" + job.ID})
+}
+
+func GetExport(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	job, err := user.FetchExport(req.PathParameters["id"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, job)
+}
+
+func EraseUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	erasure, err := user.EraseUser(body.Email, tableName, dynaClient)
+	if err != nil {
+		if err.Error() == user.ErrorErasureIncomplete {
+			// The erasure has been recorded and will complete when retried
+			return apiResponse(http.StatusAccepted, erasure)
+		}
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, erasure)
+}
+
+func GetErasure(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	erasure, err := user.FetchErasure(req.QueryStringParameters["id"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, erasure)
+}
+
+func RequestAvatarUpload(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body user.AvatarUploadRequest
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	upload, err := user.RequestAvatarUpload(body, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, upload)
+}
+
+func AttachAvatar(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+		Key   string `json:"key"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	updatedUser, err := user.AttachAvatarAs(body.Email, body.Key, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, updatedUser))
+}
+
+func GetPreferences(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	preferences, err := user.FetchPreferences(req.PathParameters["email"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, preferences)
+}
+
+func UpdatePreferences(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body user.Preferences
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	preferences, err := user.SavePreferencesAs(req.PathParameters["email"], body, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, preferences)
+}
+
+type tagsBody struct {
+	Tags []string `json:"tags"`
+}
+
+func AddTags(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body tagsBody
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	updatedUser, err := user.AddTagsAs(req.PathParameters["email"], body.Tags, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, updatedUser))
+}
+
+func RemoveTags(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body tagsBody
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	updatedUser, err := user.RemoveTagsAs(req.PathParameters["email"], body.Tags, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, updatedUser))
+}
+
+func SetPassword(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Password        string `json:"password"`
+		CurrentPassword string `json:"currentPassword"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	err := user.ChangePassword(req.PathParameters["email"], body.CurrentPassword, body.Password, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func RequestPasswordReset(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	if err := user.RequestPasswordReset(body.Email, tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusAccepted, nil)
+}
+
+func ConfirmPasswordReset(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email    string `json:"email"`
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	if err := user.ResetPassword(body.Email, body.Token, body.Password, tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func RequestEmailChange(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	if err := user.RequestEmailChange(req.PathParameters["email"], body.Email, tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusAccepted, nil)
+}
+
+func ConfirmEmailChange(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	updatedUser, err := user.ConfirmEmailChangeAs(body.Token, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, updatedUser))
+}
+
+func EnrollMFA(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Password string `json:"password"`
+		// TOTP is a code from the secret already confirmed, needed to
+		// enroll again once MFA is enabled
+		TOTP string `json:"totp"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	enrollment, err := credentials.EnrollMFAAs(req.PathParameters["email"], body.Password, body.TOTP, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, enrollment)
+}
+
+func ConfirmMFA(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		TOTP string `json:"totp"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	if err := credentials.ConfirmMFAAs(req.PathParameters["email"], body.TOTP, audit.ActorOf(req), tableName, dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func Login(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	loggedIn, err := user.Login(body.Email, body.Password, body.TOTP, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	s, err := session.Create(loggedIn.Email, session.Device{
+		UserAgent: headerValue(req, "User-Agent"),
+		SourceIP:  req.RequestContext.Identity.SourceIP,
+	}, session.Table(tableName), dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, LoginBody{User: loggedIn, Session: s})
+}
+
+// LoginBody answers a successful login with the session it started.
+type LoginBody struct {
+	User    *user.User       `json:"user"`
+	Session *session.Session `json:"session"`
+}
+
+func GetSessions(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	sessions, err := session.FetchSessions(req.PathParameters["email"], session.Table(tableName), dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, sessions)
+}
+
+func RevokeSession(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if err := session.Revoke(req.PathParameters["email"], req.PathParameters["id"], session.Table(tableName), dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
+
+func RevokeSessions(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if err := session.RevokeAll(req.PathParameters["email"], session.Table(tableName), dynaClient); err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
 	}
 	return apiResponse(http.StatusOK, nil)
 }
@@ -1,39 +1,57 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/flags"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/tenant"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-type mockDynamoDBClient struct {
-	dynamodbiface.DynamoDBAPI
-	fetchUser *dynamodb.GetItemOutput
-	fetchErr  error
-	scanRes   *dynamodb.ScanOutput
-	scanErr   error
-}
-
-func (m mockDynamoDBClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
-	return m.fetchUser, m.fetchErr
-}
-
-func (m mockDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
-	return nil, nil
-}
-
-func (m mockDynamoDBClient) Scan(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
-	return m.scanRes, m.scanErr
+// userLinks is the links member of a user's body for requests made without
+// a domain or stage, as the handler tests make them.
+func userLinks(email string) string {
+	return `"links":{"delete":{"href":"/users/` + email + `","method":"DELETE"},` +
+		`"self":{"href":"/?email=` + url.QueryEscape(email) + `","method":"GET"},` +
+		`"update":{"href":"/","method":"PUT"}}`
 }
 
 func TestGetUser(t *testing.T) {
 	t.Run("should return a 500 response when failure to fetch record", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			fetchErr: errors.New("user not found"),
+		mockDb := &testutil.MockDynamoDB{
+			GetItemErr: errors.New("user not found"),
 		}
 		resp, _ := GetUser(events.APIGatewayProxyRequest{
 			QueryStringParameters: map[string]string{
@@ -43,13 +61,66 @@ func TestGetUser(t *testing.T) {
 		if resp.StatusCode != 500 {
 			t.Errorf("expected status code to be %d, got %d", 500, resp.StatusCode)
 		}
-		if resp.Body != "{\"error\":\"failed to fetch record\"}" {
-			t.Errorf("expected body to be %q, got %q", "{\"error\":\"failed to fetch record\"}", resp.Body)
+		if resp.Body != "{\"error\":\"failed to fetch record\",\"code\":\"failed_to_fetch_record\"}" {
+			t.Errorf("expected body to be %q, got %q", "{\"error\":\"failed to fetch record\",\"code\":\"failed_to_fetch_record\"}", resp.Body)
+		}
+	})
+	t.Run("should return a 503 response when the data store is unavailable", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{
+			GetItemErr: errors.New(store.ErrorUnavailable),
+		}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{
+				"email": "alan.oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
+		if resp.StatusCode != 503 {
+			t.Errorf("expected status code to be %d, got %d", 503, resp.StatusCode)
+		}
+		if resp.Body != "{\"error\":\"data store unavailable\",\"code\":\"data_store_unavailable\"}" {
+			t.Errorf("expected body to be %q, got %q", "{\"error\":\"data store unavailable\",\"code\":\"data_store_unavailable\"}", resp.Body)
+		}
+	})
+	t.Run("should return a 429 response with Retry-After when the data store is throttled", func(t *testing.T) {
+		ConfigureResponses(ResponseConfig{RetryAfter: 1500 * time.Millisecond})
+		defer ConfigureResponses(ResponseConfig{})
+		mockDb := &testutil.MockDynamoDB{
+			GetItemErr: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throughput exceeded", nil),
+		}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{
+				"email": "alan.oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
+		if resp.StatusCode != 429 {
+			t.Errorf("expected status code to be %d, got %d", 429, resp.StatusCode)
+		}
+		if resp.Headers["Retry-After"] != "2" {
+			t.Errorf("expected Retry-After to be %q, got %q", "2", resp.Headers["Retry-After"])
+		}
+		if resp.Body != "{\"error\":\"data store throttled\",\"code\":\"data_store_throttled\"}" {
+			t.Errorf("expected body to be %q, got %q", "{\"error\":\"data store throttled\",\"code\":\"data_store_throttled\"}", resp.Body)
+		}
+	})
+	t.Run("should return a translated error with a stable code", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{
+			GetItemErr: errors.New("user not found"),
+		}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{
+			Headers: map[string]string{
+				"Accept-Language": "es",
+			},
+			QueryStringParameters: map[string]string{
+				"email": "alan.oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
+		if resp.Body != "{\"error\":\"no se pudo obtener el registro\",\"code\":\"failed_to_fetch_record\"}" {
+			t.Errorf("expected body to be %q, got %q", "{\"error\":\"no se pudo obtener el registro\",\"code\":\"failed_to_fetch_record\"}", resp.Body)
 		}
 	})
 	t.Run("should return a user", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			fetchUser: &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{
+			GetItemOutput: &dynamodb.GetItemOutput{
 				Item: map[string]*dynamodb.AttributeValue{
 					"email": {
 						S: aws.String("alan.oliver@ecs.co.uk"),
@@ -71,25 +142,26 @@ func TestGetUser(t *testing.T) {
 		if resp.StatusCode != 200 {
 			t.Errorf("expected status code to be %d, got %d", 200, resp.StatusCode)
 		}
-		if resp.Body != "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"}" {
-			t.Errorf("expected body to be %q, got %q", "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"}", resp.Body)
+		want := "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"," + userLinks("alan.oliver@ecs.co.uk") + "}"
+		if resp.Body != want {
+			t.Errorf("expected body to be %q, got %q", want, resp.Body)
 		}
 	})
 	t.Run("should fail to find all users when no users are found", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			scanErr: errors.New("no users found"),
+		mockDb := &testutil.MockDynamoDB{
+			ScanErr: errors.New("no users found"),
 		}
 		resp, _ := GetUser(events.APIGatewayProxyRequest{}, "test", mockDb)
 		if resp.StatusCode != 500 {
 			t.Errorf("expected status code to be %d, got %d", 500, resp.StatusCode)
 		}
-		if resp.Body != "{\"error\":\"failed to fetch record\"}" {
-			t.Errorf("expected body to be %q, got %q", "{\"error\":\"failed to fetch record\"}", resp.Body)
+		if resp.Body != "{\"error\":\"failed to fetch record\",\"code\":\"failed_to_fetch_record\"}" {
+			t.Errorf("expected body to be %q, got %q", "{\"error\":\"failed to fetch record\",\"code\":\"failed_to_fetch_record\"}", resp.Body)
 		}
 	})
 	t.Run("should return all users", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			scanRes: &dynamodb.ScanOutput{
+		mockDb := &testutil.MockDynamoDB{
+			ScanOutput: &dynamodb.ScanOutput{
 				Items: []map[string]*dynamodb.AttributeValue{
 					{
 						"email": {
@@ -120,8 +192,196 @@ func TestGetUser(t *testing.T) {
 		if resp.StatusCode != 200 {
 			t.Errorf("expected status code to be %d, got %d", 200, resp.StatusCode)
 		}
-		if resp.Body != "[{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"},{\"email\":\"alan.shearer@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Shearer\"}]" {
-			t.Errorf("expected body to be %q, got %q", "[[{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"},{\"email\":\"alan.shearer@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Shearer\"}]", resp.Body)
+		want := "[{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"," + userLinks("alan.oliver@ecs.co.uk") + "}," +
+			"{\"email\":\"alan.shearer@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Shearer\"," + userLinks("alan.shearer@ecs.co.uk") + "}]"
+		if resp.Body != want {
+			t.Errorf("expected body to be %q, got %q", want, resp.Body)
+		}
+	})
+}
+
+func TestGetUserTruncated(t *testing.T) {
+	t.Run("should flag a list response that stopped at the scan limit", func(t *testing.T) {
+		user.ConfigureScan(user.ScanConfig{MaxItems: 1})
+		defer user.ConfigureScan(user.ScanConfig{})
+		mockDb := &testutil.MockDynamoDB{
+			ScanOutput: &dynamodb.ScanOutput{
+				Items: []map[string]*dynamodb.AttributeValue{
+					{"email": {S: aws.String("alan.oliver@ecs.co.uk")}},
+					{"email": {S: aws.String("alan.shearer@ecs.co.uk")}},
+				},
+			},
+		}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Errorf("expected status code to be %d, got %d", 200, resp.StatusCode)
+		}
+		if resp.Body != "[{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"\",\"lastName\":\"\","+userLinks("alan.oliver@ecs.co.uk")+"}]" {
+			t.Errorf("expected a single user, got %q", resp.Body)
+		}
+		if resp.Headers[HeaderResultsTruncated] != "true" {
+			t.Errorf("expected %s header to be %q, got %q", HeaderResultsTruncated, "true", resp.Headers[HeaderResultsTruncated])
+		}
+	})
+}
+
+func TestGetUserFields(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{
+		"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+		"firstName": {S: aws.String("Alan")},
+		"lastName":  {S: aws.String("Oliver")},
+	}
+
+	t.Run("should only send the requested fields of a user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: item}}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{
+			"email":  "alan.oliver@ecs.co.uk",
+			"fields": "lastName, phone",
+		}}, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Errorf("expected status code to be %d, got %d", 200, resp.StatusCode)
+		}
+		if want := `{"lastName":"Oliver",` + userLinks("alan.oliver@ecs.co.uk") + `}`; resp.Body != want {
+			t.Errorf("expected body to be %q, got %q", want, resp.Body)
+		}
+		if mockDb.GetItemInputs()[0].ProjectionExpression == nil {
+			t.Error("expected the read to be projected")
+		}
+	})
+	t.Run("should only send the requested fields of each listed user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{item}}}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "firstName"}}, "test", mockDb)
+		if want := `[{"firstName":"Alan",` + userLinks("alan.oliver@ecs.co.uk") + `}]`; resp.Body != want {
+			t.Errorf("expected body to be %q, got %q", want, resp.Body)
+		}
+		names := mockDb.ScanInputs()[0].ExpressionAttributeNames
+		if aws.StringValue(names["#f1"]) != "email" {
+			t.Errorf("expected email to be read for the cursor, got %v", names)
+		}
+	})
+	t.Run("should answer a 400 for a field users do not have", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "password"}}, "test", mockDb)
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+		if mockDb.Count("Scan") != 0 {
+			t.Errorf("expected no scan, got %d", mockDb.Count("Scan"))
+		}
+	})
+}
+
+func TestGetUserLimit(t *testing.T) {
+	ConfigureLimits(LimitConfig{Default: 1, Max: 2})
+	defer ConfigureLimits(LimitConfig{})
+	mockDb := &testutil.MockDynamoDB{
+		ScanOutput: &dynamodb.ScanOutput{
+			Items: []map[string]*dynamodb.AttributeValue{
+				{"email": {S: aws.String("alan.oliver@ecs.co.uk")}},
+				{"email": {S: aws.String("alan.shearer@ecs.co.uk")}},
+				{"email": {S: aws.String("alan.smith@ecs.co.uk")}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		limit  *string
+		status int
+		users  int
+	}{
+		{"default limit", nil, 200, 1},
+		{"limit within the maximum", aws.String("2"), 200, 2},
+		{"limit above the maximum", aws.String("3"), 400, 0},
+		{"zero limit", aws.String("0"), 400, 0},
+		{"limit not a number", aws.String("ten"), 400, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{}
+			if tt.limit != nil {
+				req.QueryStringParameters = map[string]string{"limit": *tt.limit}
+			}
+			resp, _ := GetUser(req, "test", mockDb)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status code to be %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.status != 200 {
+				if !strings.Contains(resp.Body, ErrorInvalidLimit) {
+					t.Errorf("expected error %q, got %s", ErrorInvalidLimit, resp.Body)
+				}
+				return
+			}
+			if got := strings.Count(resp.Body, "\"email\""); got != tt.users {
+				t.Errorf("expected %d users, got %d", tt.users, got)
+			}
+			if resp.Headers[HeaderResultsTruncated] != "true" {
+				t.Errorf("expected %s header to be %q, got %q", HeaderResultsTruncated, "true", resp.Headers[HeaderResultsTruncated])
+			}
+		})
+	}
+}
+
+func TestGetUserCursor(t *testing.T) {
+	user.ConfigureScan(user.ScanConfig{MaxItems: 1})
+	defer user.ConfigureScan(user.ScanConfig{})
+	scanOutput := &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("alan.oliver@ecs.co.uk")}},
+			{"email": {S: aws.String("alan.shearer@ecs.co.uk")}},
+		},
+	}
+
+	t.Run("should answer a 501 when cursors are not configured", func(t *testing.T) {
+		resp, _ := GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"cursor": "a.b"}}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 501 {
+			t.Errorf("expected status code to be %d, got %d", 501, resp.StatusCode)
+		}
+	})
+
+	cursor.Configure(cursor.Config{Key: []byte("test key")})
+	defer cursor.Configure(cursor.Config{})
+
+	t.Run("should resume a list from the cursor of the previous page", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: scanOutput}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+		next := resp.Headers[HeaderNextCursor]
+		if next == "" || strings.Contains(next, "alan") {
+			t.Fatalf("expected an opaque %s header, got %q", HeaderNextCursor, next)
+		}
+
+		resp, _ = GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"cursor": next}}, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Errorf("expected status code to be %d, got %d", 200, resp.StatusCode)
+		}
+		start := mockDb.ScanInputs()[1].ExclusiveStartKey
+		if aws.StringValue(start["email"].S) != "alan.oliver@ecs.co.uk" {
+			t.Errorf("expected the scan to resume after %s, got %v", "alan.oliver@ecs.co.uk", start)
+		}
+	})
+	t.Run("should answer a 400 when the cursor has been tampered with", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: scanOutput}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+		next := resp.Headers[HeaderNextCursor]
+
+		resp, _ = GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"cursor": "x" + next}}, "test", mockDb)
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+		if mockDb.Count("Scan") != 1 {
+			t.Errorf("expected only the first page to be scanned, got %d scans", mockDb.Count("Scan"))
+		}
+	})
+	t.Run("should answer a 400 when the scan is now split differently", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: scanOutput}
+		resp, _ := GetUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+		next := resp.Headers[HeaderNextCursor]
+
+		user.ConfigureScan(user.ScanConfig{TotalSegments: 4, MaxItems: 1})
+		defer user.ConfigureScan(user.ScanConfig{MaxItems: 1})
+		resp, _ = GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"cursor": next}}, "test", mockDb)
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
 		}
 	})
 }
@@ -138,16 +398,70 @@ func TestCreateUser(t *testing.T) {
 		if resp.StatusCode != 500 {
 			t.Fatalf("expected status code 500, got %d", resp.StatusCode)
 		}
-		if resp.Body != "{\"error\":\"invalid user data\"}" {
-			t.Fatalf("expected body to be %q, got %q", "{\"error\":\"invalid user data\"}", resp.Body)
+		if resp.Body != "{\"error\":\"invalid user data\",\"code\":\"invalid_user_data\"}" {
+			t.Fatalf("expected body to be %q, got %q", "{\"error\":\"invalid user data\",\"code\":\"invalid_user_data\"}", resp.Body)
+		}
+		if resp.Headers["Content-Type"] != ContentTypeJSON {
+			t.Fatalf("expected header to be %q, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
+		}
+	})
+	t.Run("should return a 400 error response listing each invalid field", func(t *testing.T) {
+		resp, _ := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver", "firstName": "", "lastName": "O'Brien\u0007"}`,
+		}, "test", nil)
+
+		if resp == nil {
+			t.Fatalf("expected a response, got nil")
+		}
+		if resp.StatusCode != 400 {
+			t.Fatalf("expected status code 400, got %d", resp.StatusCode)
 		}
-		if resp.Headers["Application-Type"] != "application/json" {
-			t.Fatalf("expected header to be %q, got %q", "application/json", resp.Headers["Application-Type"])
+		expected := `{"errors":[` +
+			`{"field":"email","rule":"email","code":"invalid_format","message":"must be a valid email address"},` +
+			`{"field":"firstName","rule":"required","code":"required","message":"is required"},` +
+			`{"field":"lastName","rule":"name","code":"control_characters","message":"must not contain control characters"}]}`
+		if resp.Body != expected {
+			t.Fatalf("expected body to be %q, got %q", expected, resp.Body)
+		}
+	})
+	t.Run("should translate errors into the requested language", func(t *testing.T) {
+		resp, _ := CreateUser(events.APIGatewayProxyRequest{
+			Headers: map[string]string{
+				"accept-language": "de-DE, fr-CH;q=0.9, en;q=0.8",
+			},
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "` + strings.Repeat("o", 51) + `"}`,
+		}, "test", nil)
+
+		if resp == nil {
+			t.Fatalf("expected a response, got nil")
+		}
+		expected := `{"errors":[{"field":"lastName","rule":"max","code":"too_long","message":"doit contenir au plus 50 caractères"}]}`
+		if resp.Body != expected {
+			t.Fatalf("expected body to be %q, got %q", expected, resp.Body)
+		}
+		if resp.Headers["Content-Language"] != "fr" {
+			t.Fatalf("expected Content-Language to be %q, got %q", "fr", resp.Headers["Content-Language"])
+		}
+	})
+	t.Run("should return a 400 error response when the phone number is invalid", func(t *testing.T) {
+		resp, _ := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "phone": "12345"}`,
+		}, "test", nil)
+
+		if resp == nil {
+			t.Fatalf("expected a response, got nil")
+		}
+		if resp.StatusCode != 400 {
+			t.Fatalf("expected status code 400, got %d", resp.StatusCode)
+		}
+		expected := `{"errors":[{"field":"phone","rule":"phone","code":"invalid_format","message":"must be a valid E.164 phone number"}]}`
+		if resp.Body != expected {
+			t.Fatalf("expected body to be %q, got %q", expected, resp.Body)
 		}
 	})
 	t.Run("should return a 201 response when the request body is valid", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			fetchUser: &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{
+			GetItemOutput: &dynamodb.GetItemOutput{
 				Item: map[string]*dynamodb.AttributeValue{},
 			},
 		}
@@ -161,11 +475,12 @@ func TestCreateUser(t *testing.T) {
 		if resp.StatusCode != 201 {
 			t.Fatalf("expected status code 201, got %d", resp.StatusCode)
 		}
-		if resp.Body != "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"}" {
-			t.Fatalf("expected body to be %q, got %q", "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"}", resp.Body)
+		expected := "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\",\"status\":\"active\",\"createdAt\":\""
+		if !strings.HasPrefix(resp.Body, expected) {
+			t.Fatalf("expected body to start with %q, got %q", expected, resp.Body)
 		}
-		if resp.Headers["Application-Type"] != "application/json" {
-			t.Fatalf("expected header to be %q, got %q", "application/json", resp.Headers["Application-Type"])
+		if resp.Headers["Content-Type"] != ContentTypeJSON {
+			t.Fatalf("expected header to be %q, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
 		}
 	})
 }
@@ -182,16 +497,16 @@ func TestUpdateUser(t *testing.T) {
 		if resp.StatusCode != 500 {
 			t.Fatalf("expected status code 500, got %d", resp.StatusCode)
 		}
-		if resp.Body != "{\"error\":\"invalid user data\"}" {
-			t.Fatalf("expected body to be %q, got %q", "{\"error\":\"invalid user data\"}", resp.Body)
+		if resp.Body != "{\"error\":\"invalid user data\",\"code\":\"invalid_user_data\"}" {
+			t.Fatalf("expected body to be %q, got %q", "{\"error\":\"invalid user data\",\"code\":\"invalid_user_data\"}", resp.Body)
 		}
-		if resp.Headers["Application-Type"] != "application/json" {
-			t.Fatalf("expected header to be %q, got %q", "application/json", resp.Headers["Application-Type"])
+		if resp.Headers["Content-Type"] != ContentTypeJSON {
+			t.Fatalf("expected header to be %q, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
 		}
 	})
 	t.Run("should return a 200 response when the request body is valid", func(t *testing.T) {
-		mockDb := mockDynamoDBClient{
-			fetchUser: &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{
+			GetItemOutput: &dynamodb.GetItemOutput{
 				Item: map[string]*dynamodb.AttributeValue{
 					"email": {
 						S: aws.String("alan.oliver@ecs.co.uk"),
@@ -216,11 +531,1214 @@ func TestUpdateUser(t *testing.T) {
 		if resp.StatusCode != 200 {
 			t.Fatalf("expected status code 200, got %d", resp.StatusCode)
 		}
-		if resp.Body != "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Al\",\"lastName\":\"O\"}" {
-			t.Fatalf("expected body to be %q, got %q", "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Alan\",\"lastName\":\"Oliver\"}", resp.Body)
+		want := "{\"email\":\"alan.oliver@ecs.co.uk\",\"firstName\":\"Al\",\"lastName\":\"O\"," + userLinks("alan.oliver@ecs.co.uk") + "}"
+		if resp.Body != want {
+			t.Fatalf("expected body to be %q, got %q", want, resp.Body)
+		}
+		if resp.Headers["Content-Type"] != ContentTypeJSON {
+			t.Fatalf("expected header to be %q, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
+		}
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	flags.Defaults[flags.SoftDelete] = true
+	defer delete(flags.Defaults, flags.SoftDelete)
+	mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}}}
+	req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "Alan.Oliver@ecs.co.uk"}}
+	resp, _ := DeleteUser(req, "test", mockDb)
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status code to be %d, got %d %s", 200, resp.StatusCode, resp.Body)
+	}
+	inputs := mockDb.UpdateItemInputs()
+	if len(inputs) != 1 || *inputs[0].ExpressionAttributeValues[":to"].S != user.StatusDeactivated {
+		t.Errorf("expected the user to be deactivated, got %v", inputs)
+	}
+	if mockDb.Count("DeleteItem") != 0 || mockDb.Count("TransactWriteItems") != 0 {
+		t.Errorf("expected the user not to be deleted, got %v", mockDb.Calls())
+	}
+}
+
+func TestRequireActive(t *testing.T) {
+	suspended := &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"email":  {S: aws.String("alan.oliver@ecs.co.uk")},
+		"status": {S: aws.String(user.StatusSuspended)},
+	}}
+	for _, req := range []events.APIGatewayProxyRequest{
+		{HTTPMethod: "PUT", Path: "/", Body: `{"email":"Alan.Oliver@ecs.co.uk","firstName":"Al"}`},
+		{HTTPMethod: "POST", Path: "/users/alan.oliver@ecs.co.uk/tags", Body: `{"tags":["beta"]}`},
+		{HTTPMethod: "DELETE", Path: "/users/alan.oliver@ecs.co.uk/tags", Body: `{"tags":["beta"]}`},
+		{HTTPMethod: "POST", Path: "/avatars", Body: `{"email":"alan.oliver@ecs.co.uk","contentType":"image/png","size":512}`},
+		{HTTPMethod: "PUT", Path: "/avatars", Body: `{"email":"alan.oliver@ecs.co.uk","key":"avatars/abc.png"}`},
+	} {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: suspended}
+		resp, _ := Route(req, "test", mockDb)
+		if resp.StatusCode != 403 {
+			t.Errorf("expected status code to be %d for %s %s, got %d %s", 403, req.HTTPMethod, req.Path, resp.StatusCode, resp.Body)
+		}
+		if mockDb.Count("UpdateItem") != 0 || mockDb.Count("TransactWriteItems") != 0 {
+			t.Errorf("expected the suspended user not to be changed by %s %s, got %v", req.HTTPMethod, req.Path, mockDb.Calls())
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	defer ConfigureAdmin(AdminConfig{})
+	next := func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		return apiResponse(200, nil)
+	}
+
+	t.Run("should refuse every request while no admin key is configured", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{})
+		req := events.APIGatewayProxyRequest{Headers: map[string]string{HeaderAdminKey: ""}}
+		resp, _ := RequireAdmin(next)(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 403 {
+			t.Errorf("Expected status code 403, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should refuse a request with the wrong key", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		req := events.APIGatewayProxyRequest{Headers: map[string]string{HeaderAdminKey: "guess"}}
+		resp, _ := RequireAdmin(next)(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 403 {
+			t.Errorf("Expected status code 403, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should pass a request with the admin key on", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		req := events.APIGatewayProxyRequest{Headers: map[string]string{"x-admin-key": "secret"}}
+		resp, _ := RequireAdmin(next)(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 200 {
+			t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should refuse bulk operations without the admin key", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		mockDb := &testutil.MockDynamoDB{}
+		for _, path := range []string{"/deletions", "/exports", "/imports"} {
+			resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: path, Body: `{"emails":["alan.oliver@ecs.co.uk"]}`}, "test", mockDb)
+			if resp.StatusCode != 403 {
+				t.Errorf("Expected status code 403 for %s, got %d", path, resp.StatusCode)
+			}
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should refuse erasures without the admin key", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		mockDb := &testutil.MockDynamoDB{}
+		for _, req := range []events.APIGatewayProxyRequest{
+			{HTTPMethod: "POST", Path: "/erasures", Body: `{"email":"alan.oliver@ecs.co.uk"}`},
+			{HTTPMethod: "GET", Path: "/erasures", QueryStringParameters: map[string]string{"id": "abc"}},
+		} {
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != 403 {
+				t.Errorf("Expected status code 403 for %s %s, got %d", req.HTTPMethod, req.Path, resp.StatusCode)
+			}
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should refuse invitations without the admin key", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		mockDb := &testutil.MockDynamoDB{}
+		for _, req := range []events.APIGatewayProxyRequest{
+			{HTTPMethod: "POST", Path: "/invitations", Body: `{"email":"alan.oliver@ecs.co.uk"}`},
+			{HTTPMethod: "GET", Path: "/invitations"},
+		} {
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != 403 {
+				t.Errorf("Expected status code 403 for %s %s, got %d", req.HTTPMethod, req.Path, resp.StatusCode)
+			}
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should refuse status changes without the admin key", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		mockDb := &testutil.MockDynamoDB{}
+		for _, action := range []string{"suspend", "deactivate", "reactivate"} {
+			resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/users/alan.oliver@ecs.co.uk/" + action}, "test", mockDb)
+			if resp.StatusCode != 403 {
+				t.Errorf("Expected status code 403 for %s, got %d", action, resp.StatusCode)
+			}
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected no calls, got %v", mockDb.Calls())
+		}
+	})
+}
+
+func TestRequireSession(t *testing.T) {
+	next := func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		return apiResponse(200, nil)
+	}
+	stored := func(email string) *testutil.MockDynamoDB {
+		return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String(email)},
+			"expiresAt": {S: aws.String(time.Now().Add(time.Hour).UTC().Format(time.RFC3339))},
+		}}}
+	}
+	path := map[string]string{"email": "alan.oliver@ecs.co.uk"}
+
+	t.Run("should refuse a request without a session token", func(t *testing.T) {
+		mockDb := stored("alan.oliver@ecs.co.uk")
+		resp, _ := RequireSession(next)(events.APIGatewayProxyRequest{PathParameters: path}, "test", mockDb)
+		if resp.StatusCode != 401 {
+			t.Errorf("Expected status code 401, got %d", resp.StatusCode)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should refuse a session that is not found", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{PathParameters: path, Headers: map[string]string{HeaderSessionToken: "token"}}
+		resp, _ := RequireSession(next)(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 401 {
+			t.Errorf("Expected status code 401, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should refuse another user's session", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{PathParameters: path, Headers: map[string]string{HeaderSessionToken: "token"}}
+		resp, _ := RequireSession(next)(req, "test", stored("ada@ecs.co.uk"))
+		if resp.StatusCode != 403 {
+			t.Errorf("Expected status code 403, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should pass a request in the user's own session", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{PathParameters: path, Headers: map[string]string{"x-session-token": "token"}}
+		resp, _ := RequireSession(next)(req, "test", stored("alan.oliver@ecs.co.uk"))
+		if resp.StatusCode != 200 {
+			t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("should pass a request with the admin key on", func(t *testing.T) {
+		ConfigureAdmin(AdminConfig{Key: "secret"})
+		defer ConfigureAdmin(AdminConfig{})
+		req := events.APIGatewayProxyRequest{PathParameters: path, Headers: map[string]string{HeaderAdminKey: "secret"}}
+		resp, _ := RequireSession(next)(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 200 {
+			t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestResponseHeaders(t *testing.T) {
+	ConfigureResponses(ResponseConfig{Headers: map[string]string{
+		"X-Frame-Options": "DENY",
+		"Content-Type":    "text/html",
+		"Cache-Control":   "no-store",
+	}})
+	defer ConfigureResponses(ResponseConfig{})
+
+	resp, _ := apiResponseWithHeaders(200, nil, map[string]string{"Cache-Control": "max-age=60"})
+	if resp.Headers["Content-Type"] != ContentTypeJSON {
+		t.Errorf("Expected Content-Type %q to win over configured headers, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
+	}
+	if resp.Headers["X-Frame-Options"] != "DENY" {
+		t.Errorf("Expected configured headers to be sent, got %v", resp.Headers)
+	}
+	if resp.Headers["Cache-Control"] != "max-age=60" {
+		t.Errorf("Expected the handler's headers to win over configured ones, got %q", resp.Headers["Cache-Control"])
+	}
+	if _, ok := resp.Headers["Application-Type"]; ok {
+		t.Error("Expected no Application-Type header")
+	}
+}
+
+func TestProblems(t *testing.T) {
+	decode := func(t *testing.T, resp *events.APIGatewayProxyResponse) Problem {
+		if resp.Headers["Content-Type"] != ContentTypeProblem {
+			t.Errorf("expected Content-Type %q, got %q", ContentTypeProblem, resp.Headers["Content-Type"])
+		}
+		var problem Problem
+		if err := json.Unmarshal([]byte(resp.Body), &problem); err != nil {
+			t.Fatalf("expected problem details, got %s", resp.Body)
+		}
+		return problem
+	}
+
+	t.Run("should send problem details to requests that accept them", func(t *testing.T) {
+		resp, _ := Route(events.APIGatewayProxyRequest{
+			HTTPMethod: "GET",
+			Path:       "/users/alan.oliver@ecs.co.uk/unknown",
+			Headers:    map[string]string{"accept": "application/json, application/problem+json;q=0.9"},
+		}, "test", &testutil.MockDynamoDB{})
+		problem := decode(t, resp)
+		want := Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: ErrorRouteNotFound, Instance: "/users/alan.oliver@ecs.co.uk/unknown", Code: "route_not_found"}
+		if !reflect.DeepEqual(problem, want) {
+			t.Errorf("expected %+v, got %+v", want, problem)
+		}
+	})
+	t.Run("should type problems by code when a base URL is configured", func(t *testing.T) {
+		ConfigureResponses(ResponseConfig{Problems: true, ProblemTypeBase: "https://example.com/problems/"})
+		defer ConfigureResponses(ResponseConfig{})
+
+		resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "PATCH", Path: "/"}, "test", &testutil.MockDynamoDB{})
+		problem := decode(t, resp)
+		if problem.Type != "https://example.com/problems/error_method_not_allowed" || problem.Title != ErrorMethodNotAllowed || problem.Status != 405 {
+			t.Errorf("expected a typed method not allowed problem, got %+v", problem)
+		}
+		if resp.Headers["Allow"] == "" {
+			t.Error("expected an Allow header")
+		}
+	})
+	t.Run("should list the fields that failed validation", func(t *testing.T) {
+		resp, _ := CreateUser(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"Accept": "application/problem+json"},
+			Body:    `{"email":"not-an-email","lastName":"Oliver"}`,
+		}, "test", &testutil.MockDynamoDB{})
+		problem := decode(t, resp)
+		if problem.Status != 400 || problem.Code != "request_has_invalid_fields" || len(problem.Errors) == 0 {
+			t.Errorf("expected a validation problem with its fields, got %+v", problem)
+		}
+	})
+	t.Run("should keep error bodies for other requests", func(t *testing.T) {
+		resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/unknown"}, "test", &testutil.MockDynamoDB{})
+		if resp.Headers["Content-Type"] != ContentTypeJSON {
+			t.Errorf("expected Content-Type %q, got %q", ContentTypeJSON, resp.Headers["Content-Type"])
+		}
+		if resp.Body != `{"error":"route not found","code":"route_not_found"}` {
+			t.Errorf("expected an error body, got %s", resp.Body)
+		}
+	})
+}
+
+func TestBodyLimit(t *testing.T) {
+	ConfigureBodies(BodyConfig{MaxBytes: 64})
+	defer ConfigureBodies(BodyConfig{})
+	body := `{"email":"alan.oliver@ecs.co.uk","firstName":"Alan","lastName":"Oliver"}`
+
+	t.Run("should answer a 413 before decoding a body over the limit", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: body}, "test", mockDb)
+		if resp.StatusCode != 413 {
+			t.Errorf("expected status code to be %d, got %d", 413, resp.StatusCode)
+		}
+		if resp.Body != `{"error":"request body too large","code":"request_body_too_large"}` {
+			t.Errorf("expected body too large error, got %s", resp.Body)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should measure base64 encoded bodies decoded", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString([]byte(body[:60]))
+		resp, _ := Route(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: encoded, IsBase64Encoded: true}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode == 413 {
+			t.Errorf("expected a %d byte body to be read, got %d", 60, resp.StatusCode)
+		}
+	})
+}
+
+func TestTenants(t *testing.T) {
+	tenant.Configure(tenant.Config{Tables: map[string]string{"acme": "AcmeUser"}})
+	defer tenant.Configure(tenant.Config{})
+
+	t.Run("should serve a tenant from its table", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  "/",
+			Headers:               map[string]string{"x-tenant-id": "acme"},
+			QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"},
+		}
+		Route(req, "test", mockDb)
+		inputs := mockDb.GetItemInputs()
+		if len(inputs) == 0 || *inputs[0].TableName != "AcmeUser" {
+			t.Errorf("expected the tenant's table to be read, got %v", inputs)
+		}
+	})
+	t.Run("should answer 404 for an unknown tenant", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", Headers: map[string]string{"X-Tenant-ID": "globex"}}
+		resp, _ := Route(req, "test", mockDb)
+		if resp.StatusCode != 404 || !strings.Contains(resp.Body, "unknown_tenant") {
+			t.Errorf("expected an unknown tenant, got %d %s", resp.StatusCode, resp.Body)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+}
+
+func TestQuotas(t *testing.T) {
+	quota.Configure(quota.Config{KeyWrites: 100, EmailWrites: 5})
+	defer quota.Configure(quota.Config{})
+
+	t.Run("should count writes against the API key and report the quota", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{"count": {N: aws.String("3")}}}, nil
+		}}
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:     "DELETE",
+			Path:           "/users/alan.oliver@ecs.co.uk",
+			RequestContext: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{APIKey: "integration"}},
+		}
+		resp, _ := Route(req, "test", mockDb)
+		inputs := mockDb.UpdateItemInputs()
+		if len(inputs) == 0 || *inputs[0].TableName != "testQuota" || !strings.HasPrefix(*inputs[0].Key["id"].S, "key#") {
+			t.Fatalf("expected the write to be counted against the key, got %v", inputs)
+		}
+		if resp.Headers[HeaderQuotaLimit] != "100" || resp.Headers[HeaderQuotaRemaining] != "97" {
+			t.Errorf("expected 97 of 100 writes to remain, got %v", resp.Headers)
+		}
+	})
+	t.Run("should answer 429 once the user written has used its quota", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)}
+		req := events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/", Body: `{"email":"alan.oliver@ecs.co.uk"}`}
+		resp, _ := Route(req, "test", mockDb)
+		if resp.StatusCode != 429 || !strings.Contains(resp.Body, "write_quota_exceeded") {
+			t.Errorf("expected the write to be refused, got %d %s", resp.StatusCode, resp.Body)
+		}
+		if resp.Headers["Retry-After"] == "" || resp.Headers[HeaderQuotaRemaining] != "0" {
+			t.Errorf("expected when to retry, got %v", resp.Headers)
+		}
+		if inputs := mockDb.UpdateItemInputs(); len(inputs) != 1 || !strings.HasPrefix(*inputs[0].Key["id"].S, "email#alan.oliver@ecs.co.uk#") {
+			t.Errorf("expected the write to be counted against the user, got %v", inputs)
+		}
+		if len(mockDb.PutItemInputs()) != 0 {
+			t.Errorf("expected the user not to be written, got %v", mockDb.PutItemInputs())
+		}
+	})
+	t.Run("should not trust an API key the request sends itself", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)}
+		for _, key := range []string{"first", "second"} {
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Path:       "/",
+				Headers:    map[string]string{quota.KeyHeader(): key},
+				Body:       `{"email":"alan.oliver@ecs.co.uk"}`,
+			}
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != 429 {
+				t.Errorf("expected the write with key %s to be refused, got %d %s", key, resp.StatusCode, resp.Body)
+			}
+		}
+		for _, input := range mockDb.UpdateItemInputs() {
+			if !strings.HasPrefix(*input.Key["id"].S, "email#alan.oliver@ecs.co.uk#") {
+				t.Errorf("expected each write to be counted against the user, got %s", *input.Key["id"].S)
+			}
+		}
+	})
+	t.Run("should not count reads", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/", QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}
+		Route(req, "test", mockDb)
+		if len(mockDb.UpdateItemInputs()) != 0 {
+			t.Errorf("expected reads not to be counted, got %v", mockDb.UpdateItemInputs())
+		}
+	})
+}
+
+func TestAccessTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("token") != "reader" {
+			w.Write([]byte(`{"active":false}`))
+			return
+		}
+		w.Write([]byte(`{"active":true,"scope":"users:read","client_id":"reporting"}`))
+	}))
+	defer server.Close()
+	oauth.Configure(oauth.Config{IntrospectionURL: server.URL})
+	defer oauth.Configure(oauth.Config{})
+
+	cases := []struct {
+		name          string
+		method        string
+		authorization string
+		status        int
+		challenge     string
+	}{
+		{"should answer 401 without a token", "GET", "", 401, "Bearer"},
+		{"should answer 401 for an invalid token", "GET", "Bearer revoked", 401, `Bearer error="invalid_token"`},
+		{"should answer 403 without the scope", "DELETE", "Bearer reader", 403, `Bearer error="insufficient_scope", scope="users:write"`},
+		{"should answer with the scope", "GET", "bearer reader", 200, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}}}
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod:            c.method,
+				Path:                  "/",
+				Headers:               map[string]string{"Authorization": c.authorization},
+				QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"},
+			}
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != c.status || resp.Headers["WWW-Authenticate"] != c.challenge {
+				t.Errorf("expected %d %q, got %d %q %s", c.status, c.challenge, resp.StatusCode, resp.Headers["WWW-Authenticate"], resp.Body)
+			}
+			if c.status != 200 && len(mockDb.Calls()) != 0 {
+				t.Errorf("expected no calls, got %v", mockDb.Calls())
+			}
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	cases := []struct {
+		name    string
+		method  string
+		pattern string
+		body    string
+		scope   string
+	}{
+		{"should read with GET", "GET", "/", "", oauth.ScopeRead},
+		{"should write with any other method", "DELETE", "/users/{email}", "", oauth.ScopeWrite},
+		{"should read to export", "POST", "/exports", `{"format":"csv"}`, oauth.ScopeRead},
+		{"should read for a GraphQL query", "POST", "/graphql", `{"query":"{ user(email: \"a@ecs.co.uk\") { email } }"}`, oauth.ScopeRead},
+		{"should write for a GraphQL mutation", "POST", "/graphql", `{"query":"mutation { deleteUser(email: \"a@ecs.co.uk\") }"}`, oauth.ScopeWrite},
+		{"should write for the mutation a GraphQL request names", "POST", "/graphql", `{"query":"query Q { users { email } } mutation M { deleteUser(email: \"a@ecs.co.uk\") }","operationName":"M"}`, oauth.ScopeWrite},
+		{"should write for a GraphQL request that does not parse", "POST", "/graphql", `{"query":"{"}`, oauth.ScopeWrite},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{HTTPMethod: c.method, Body: c.body}
+			if scope := requiredScope(req, c.pattern); scope != c.scope {
+				t.Errorf("expected %s, got %s", c.scope, scope)
+			}
+		})
+	}
+}
+
+func TestIdentityTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "id",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer server.Close()
+	oidc.Configure(oidc.Config{JWKSURL: server.URL, Issuer: "https://id.example.com"})
+	defer oidc.Configure(oidc.Config{})
+	sign := func(email string, verified bool) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"id"}`))
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss":            "https://id.example.com",
+			"sub":            "248289761001",
+			"email":          email,
+			"email_verified": verified,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		})
+		signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	stored := map[string]*dynamodb.AttributeValue{
+		"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+		"firstName": {S: aws.String("Alan")},
+		"lastName":  {S: aws.String("Oliver")},
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"should answer 401 for an invalid token", "not-a-token", 401},
+		{"should answer 403 for another user's token", sign("someone@ecs.co.uk", true), 403},
+		{"should answer 403 for an unverified email", sign("alan.oliver@ecs.co.uk", false), 403},
+		{"should update the caller's own user", sign("Alan.Oliver@ecs.co.uk", true), 200},
+		{"should update without a token", "", 200},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: stored}}
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod: "PUT",
+				Path:       "/",
+				Headers:    map[string]string{"x-id-token": c.token},
+				Body:       `{"email":"alan.oliver@ecs.co.uk","jobTitle":"Engineer","identity":{"sub":"forged","iss":"https://evil.example.com"}}`,
+			}
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != c.status {
+				t.Fatalf("expected status code to be %d, got %d %s", c.status, resp.StatusCode, resp.Body)
+			}
+			if c.status != 200 {
+				if len(mockDb.Calls()) != 0 {
+					t.Errorf("expected no calls, got %v", mockDb.Calls())
+				}
+				return
+			}
+			var saved *user.Identity
+			if written := mockDb.UpdateItemInputs()[0].ExpressionAttributeValues[":identity"]; written != nil {
+				dynamodbattribute.Unmarshal(written, &saved)
+			}
+			want := &user.Identity{Subject: "248289761001", Issuer: "https://id.example.com"}
+			if c.token == "" {
+				want = nil
+			}
+			if !reflect.DeepEqual(saved, want) {
+				t.Errorf("expected the identity to be %+v, got %+v", want, saved)
+			}
+		})
+	}
+	t.Run("should answer 403 for a write naming no user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		for _, req := range []events.APIGatewayProxyRequest{
+			{HTTPMethod: "POST", Path: "/deletions", Body: `{"tag":"beta"}`},
+			{HTTPMethod: "POST", Path: "/email-changes/confirm", Body: `{"token":"abc"}`},
+		} {
+			req.Headers = map[string]string{"x-id-token": sign("alan.oliver@ecs.co.uk", true)}
+			resp, _ := Route(req, "test", mockDb)
+			if resp.StatusCode != 403 {
+				t.Errorf("expected status code to be %d for %s, got %d %s", 403, req.Path, resp.StatusCode, resp.Body)
+			}
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should check each GraphQL mutation against the identity", func(t *testing.T) {
+		ConfigureGraphQL(GraphQLConfig{Enabled: true})
+		defer ConfigureGraphQL(GraphQLConfig{})
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: stored}}
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       "/graphql",
+			Headers:    map[string]string{"x-id-token": sign("alan.oliver@ecs.co.uk", true)},
+			Body:       `{"query":"mutation { deleteUser(email: \"someone@ecs.co.uk\") }"}`,
+		}
+		resp, _ := Route(req, "test", mockDb)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, `"code":"identity_may_only_change_its_own_user"`) {
+			t.Fatalf("expected the mutation to be refused, got %d %s", resp.StatusCode, resp.Body)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+}
+
+func TestUserHistory(t *testing.T) {
+	t.Run("should answer 501 while users are not event sourced", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}
+		resp, _ := GetUserEvents(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 501 {
+			t.Errorf("expected status code to be %d, got %d", 501, resp.StatusCode)
+		}
+	})
+	t.Run("should answer 400 for an asOf that is not a time", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk", "asOf": "yesterday"}}
+		resp, _ := GetUser(req, "test", mockDb)
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Run("should answer 501 while webhooks are not enabled", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		resp, _ := GetWebhooks(events.APIGatewayProxyRequest{}, "test", mockDb)
+		if resp.StatusCode != 501 {
+			t.Errorf("expected status code to be %d, got %d", 501, resp.StatusCode)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("expected no calls, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should register a webhook and return its secret", func(t *testing.T) {
+		webhook.Configure(webhook.Config{Enabled: true})
+		defer webhook.Configure(webhook.Config{})
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{Body: `{"url":"https://example.com/hooks","secret":"shh","events":["user.deleted"]}`}
+		resp, _ := RegisterWebhook(req, "test", mockDb)
+		if resp.StatusCode != 201 {
+			t.Fatalf("expected status code to be %d, got %d: %s", 201, resp.StatusCode, resp.Body)
+		}
+		if !strings.Contains(resp.Body, `"secret":"shh"`) {
+			t.Errorf("expected the secret in the response, got %s", resp.Body)
 		}
-		if resp.Headers["Application-Type"] != "application/json" {
-			t.Fatalf("expected header to be %q, got %q", "application/json", resp.Headers["Application-Type"])
+		if table := *mockDb.PutItemInputs()[0].TableName; table != "testWebhook" {
+			t.Errorf("expected the webhook to be saved to %q, got %q", "testWebhook", table)
 		}
 	})
+	t.Run("should answer 400 for an invalid url", func(t *testing.T) {
+		webhook.Configure(webhook.Config{Enabled: true})
+		defer webhook.Configure(webhook.Config{})
+		req := events.APIGatewayProxyRequest{Body: `{"url":"ftp://example.com","events":["user.deleted"]}`}
+		resp, _ := RegisterWebhook(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+}
+
+func TestProjections(t *testing.T) {
+	t.Run("should list the recently created users from one item", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String("RECENT")},
+			"sk": {S: aws.String("CREATED")},
+			"users": {L: []*dynamodb.AttributeValue{{M: map[string]*dynamodb.AttributeValue{
+				"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+				"createdAt": {S: aws.String("2023-01-01T00:00:00Z")},
+			}}}},
+		}}}
+		resp, _ := GetRecentUsers(events.APIGatewayProxyRequest{}, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code to be %d, got %d: %s", 200, resp.StatusCode, resp.Body)
+		}
+		if !strings.Contains(resp.Body, `"email":"alan.oliver@ecs.co.uk"`) {
+			t.Errorf("expected the recent user in the response, got %s", resp.Body)
+		}
+		if calls := mockDb.Calls(); len(calls) != 1 {
+			t.Errorf("expected a single read, got %v", calls)
+		}
+	})
+	t.Run("should answer an empty list before any user is projected", func(t *testing.T) {
+		resp, _ := GetRecentUsers(events.APIGatewayProxyRequest{}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 200 || resp.Body != "[]" {
+			t.Errorf("expected an empty list, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+func TestRouteMatch(t *testing.T) {
+	r := route{pattern: "/groups/{id}/members/{email}"}
+	params, ok := r.match("/groups/engineering/members/alan.oliver%40ecs.co.uk/")
+	if !ok || params["id"] != "engineering" || params["email"] != "alan.oliver@ecs.co.uk" {
+		t.Errorf("Expected the path parameters to be captured, got %v %t", params, ok)
+	}
+	for _, path := range []string{"/groups/engineering/members", "/groups//members/alan", "/groups/engineering/owners/alan", "/groups/a/members/b/c"} {
+		if _, ok := r.match(path); ok {
+			t.Errorf("Expected %s not to match %s", path, r.pattern)
+		}
+	}
+	if _, ok := (route{pattern: "/"}).match(""); !ok {
+		t.Error("Expected an empty path to match the root")
+	}
+}
+
+func TestRouteEmail(t *testing.T) {
+	mockDb := &testutil.MockDynamoDB{}
+	Route(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/Alan.Oliver%40ECS.co.uk/preferences"}, "test", mockDb)
+	gets := mockDb.GetItemInputs()
+	if len(gets) == 0 || *gets[0].Key["email"].S != "alan.oliver@ecs.co.uk" {
+		t.Errorf("Expected the user to be looked up by their lowercased email, got %v", gets)
+	}
+}
+
+func TestRouteRegistry(t *testing.T) {
+	seen := map[string]bool{}
+	for _, r := range routes {
+		if seen[r.pattern] {
+			t.Errorf("Expected %s to be registered once", r.pattern)
+		}
+		seen[r.pattern] = true
+		if len(r.methods) == 0 {
+			t.Errorf("Expected %s to answer at least one method", r.pattern)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	stored := &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}}
+	get := func(path string) *events.APIGatewayProxyResponse {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  path,
+			QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"},
+		}
+		resp, _ := Route(req, "test", &testutil.MockDynamoDB{GetItemOutput: stored})
+		return resp
+	}
+
+	t.Run("should answer v1 and unprefixed paths in the legacy format", func(t *testing.T) {
+		for _, path := range []string{"/", "/v1", "/v1/"} {
+			resp := get(path)
+			var body UserResource
+			json.Unmarshal([]byte(resp.Body), &body)
+			if resp.StatusCode != 200 || body.User == nil || body.Email != "alan.oliver@ecs.co.uk" {
+				t.Errorf("expected the user from %s, got %d %s", path, resp.StatusCode, resp.Body)
+			}
+		}
+		if got := userResource(events.APIGatewayProxyRequest{Path: "/v1/"}, &user.User{Email: "a@b.co"}).Links["update"].Href; got != "/v1/" {
+			t.Errorf("expected links to stay within v1, got %q", got)
+		}
+	})
+	t.Run("should wrap v2 responses in an envelope", func(t *testing.T) {
+		resp := get("/v2/")
+		var body struct {
+			Data UserResource `json:"data"`
+		}
+		json.Unmarshal([]byte(resp.Body), &body)
+		if resp.StatusCode != 200 || body.Data.User == nil || body.Data.Email != "alan.oliver@ecs.co.uk" {
+			t.Fatalf("expected the user under data, got %d %s", resp.StatusCode, resp.Body)
+		}
+		if body.Data.Links["update"].Href != "/v2/" {
+			t.Errorf("expected links to stay within v2, got %+v", body.Data.Links)
+		}
+	})
+	t.Run("should send v2 errors as problem details", func(t *testing.T) {
+		resp := get("/v2/nowhere")
+		if resp.StatusCode != 404 || resp.Headers["Content-Type"] != ContentTypeProblem {
+			t.Errorf("expected a 404 problem, got %d %q %s", resp.StatusCode, resp.Headers["Content-Type"], resp.Body)
+		}
+	})
+	t.Run("should not match unknown versions", func(t *testing.T) {
+		if resp := get("/v3/"); resp.StatusCode != 404 {
+			t.Errorf("expected status code to be %d, got %d", 404, resp.StatusCode)
+		}
+	})
+	t.Run("should describe list pages in the envelope", func(t *testing.T) {
+		resp := envelope(&events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": ContentTypeJSON, HeaderNextCursor: "abc", HeaderResultsTruncated: "true"},
+			Body:       `[]`,
+		}, "/users/search", nil)
+		if resp.Body != `{"data":[],"meta":{"nextCursor":"abc","truncated":true}}` {
+			t.Errorf("expected the list in an envelope, got %s", resp.Body)
+		}
+	})
+}
+
+func TestDeprecations(t *testing.T) {
+	var emitted bytes.Buffer
+	metrics.Configure(metrics.Config{Writer: &emitted})
+	defer metrics.Configure(metrics.Config{})
+	since := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	ConfigureDeprecations(DeprecationConfig{Deprecations: map[string]Deprecation{
+		VersionV1:     {Since: since, Sunset: sunset, Link: "https://example.com/migrating"},
+		"GET /groups": {},
+	}})
+	defer ConfigureDeprecations(DeprecationConfig{})
+	stored := &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}}
+	get := func(path string) *events.APIGatewayProxyResponse {
+		req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: path, QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}
+		resp, _ := Route(req, "test", &testutil.MockDynamoDB{GetItemOutput: stored})
+		return resp
+	}
+
+	t.Run("should announce a deprecated version and count its use", func(t *testing.T) {
+		emitted.Reset()
+		resp := get("/")
+		metrics.Flush()
+		want := map[string]string{
+			"Deprecation": "@1782864000",
+			"Sunset":      "Fri, 01 Jan 2027 00:00:00 GMT",
+			"Link":        `<https://example.com/migrating>; rel="deprecation"`,
+			"Warning":     `299 - "v1 is deprecated and will be removed after 2027-01-01T00:00:00Z"`,
+		}
+		for name, value := range want {
+			if resp.Headers[name] != value {
+				t.Errorf("expected %s to be %q, got %q", name, value, resp.Headers[name])
+			}
+		}
+		if !strings.Contains(emitted.String(), `"DeprecatedRequests":1`) || !strings.Contains(emitted.String(), `"Deprecated":"v1"`) {
+			t.Errorf("expected the request to be counted, got %s", emitted.String())
+		}
+	})
+	t.Run("should not announce anything for a version that is not deprecated", func(t *testing.T) {
+		emitted.Reset()
+		resp := get("/v2/")
+		metrics.Flush()
+		if _, ok := resp.Headers["Deprecation"]; ok || strings.Contains(emitted.String(), MetricDeprecatedRequests) || strings.Contains(resp.Body, "warnings") {
+			t.Errorf("expected no deprecation, got %v %s %s", resp.Headers, resp.Body, emitted.String())
+		}
+	})
+	t.Run("should warn in the envelope of a deprecated v2 route", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/v2/groups"}
+		resp, _ := Route(req, "test", &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{}, QueryOutput: &dynamodb.QueryOutput{}})
+		if resp.Headers["Deprecation"] != "true" || resp.Headers["Sunset"] != "" {
+			t.Errorf("expected an undated deprecation, got %v", resp.Headers)
+		}
+		var body Envelope
+		json.Unmarshal([]byte(resp.Body), &body)
+		if len(body.Warnings) != 1 || body.Warnings[0] != "GET /groups is deprecated" {
+			t.Errorf("expected a warning in the envelope, got %d %s", resp.StatusCode, resp.Body)
+		}
+	})
+}
+
+func TestRouteLatency(t *testing.T) {
+	var emitted bytes.Buffer
+	metrics.Configure(metrics.Config{Writer: &emitted})
+	defer metrics.Configure(metrics.Config{})
+	req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/events"}
+	Route(req, "test", &testutil.MockDynamoDB{})
+	Route(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/nowhere"}, "test", &testutil.MockDynamoDB{})
+	metrics.Flush()
+	var entry map[string]interface{}
+	line, _, _ := strings.Cut(emitted.String(), "\n")
+	if err := json.Unmarshal([]byte(line), &entry); err != nil || strings.Contains(emitted.String(), "/nowhere") {
+		t.Fatalf("expected only the matched route to be measured, got %q", emitted.String())
+	}
+	// Event history is not enabled, which is a server error
+	if entry["Route"] != "GET /users/{email}/events" || entry["Outcome"] != metrics.OutcomeError {
+		t.Errorf("expected the route's latency as an error, got %v", entry)
+	}
+	if _, ok := entry[MetricRouteLatency].(float64); !ok {
+		t.Errorf("expected a latency, got %v", entry[MetricRouteLatency])
+	}
+}
+
+func TestServiceLevelIndicators(t *testing.T) {
+	var emitted bytes.Buffer
+	metrics.Configure(metrics.Config{Writer: &emitted})
+	defer metrics.Configure(metrics.Config{})
+	defer ConfigureSLO(SLOConfig{})
+	indicators := func() map[string]float64 {
+		metrics.Flush()
+		defer emitted.Reset()
+		sums := map[string]float64{}
+		for _, line := range strings.Split(strings.TrimSpace(emitted.String()), "\n") {
+			var entry map[string]interface{}
+			json.Unmarshal([]byte(line), &entry)
+			route, _ := entry["Route"].(string)
+			for _, name := range []string{MetricRequests, MetricAvailableRequests, MetricFastRequests} {
+				switch value := entry[name].(type) {
+				case float64:
+					sums[route+" "+name] += value
+				case []interface{}:
+					for _, v := range value {
+						sums[route+" "+name] += v.(float64)
+					}
+				}
+			}
+		}
+		return sums
+	}
+	get := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users/alan.oliver@ecs.co.uk/events"}
+
+	t.Run("should count good requests against every request, by route and in total", func(t *testing.T) {
+		ConfigureSLO(SLOConfig{})
+		Route(get, "test", &testutil.MockDynamoDB{})
+		Route(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/groups"}, "test", &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{}, QueryOutput: &dynamodb.QueryOutput{}})
+		want := map[string]float64{
+			"GET /users/{email}/events Requests":          1,
+			"GET /users/{email}/events AvailableRequests": 0,
+			"GET /users/{email}/events FastRequests":      1,
+			"GET /groups AvailableRequests":               1,
+			" Requests":                                   2,
+			" AvailableRequests":                          1,
+			" FastRequests":                               2,
+		}
+		got := indicators()
+		for name, value := range want {
+			if got[name] != value {
+				t.Errorf("expected %s to be %v, got %v", name, value, got[name])
+			}
+		}
+	})
+	t.Run("should not count requests slower than the latency objective as fast", func(t *testing.T) {
+		ConfigureSLO(SLOConfig{LatencyObjective: time.Nanosecond})
+		Route(get, "test", &testutil.MockDynamoDB{})
+		if got := indicators(); got[" Requests"] != 1 || got[" FastRequests"] != 0 {
+			t.Errorf("expected a slow request, got %v", got)
+		}
+	})
+}
+
+func TestLinks(t *testing.T) {
+	t.Run("should link a user from the stage the request was made to", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{
+			DomainName: "api.example.com",
+			Stage:      "staging",
+		}}
+		links := userResource(req, &user.User{Email: "alan.oliver@ecs.co.uk"}).Links
+		if links["self"].Href != "https://api.example.com/staging/?email=alan.oliver%40ecs.co.uk" {
+			t.Errorf("expected self link %q, got %q", "https://api.example.com/staging/?email=alan.oliver%40ecs.co.uk", links["self"].Href)
+		}
+		if links["delete"].Href != "https://api.example.com/staging/users/alan.oliver@ecs.co.uk" || links["delete"].Method != "DELETE" {
+			t.Errorf("expected delete link to the user, got %+v", links["delete"])
+		}
+	})
+	t.Run("should not prefix the default stage", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{
+			DomainName: "api.example.com",
+			Stage:      "$default",
+		}}
+		if got := userResource(req, &user.User{Email: "alan.oliver@ecs.co.uk"}).Links["update"].Href; got != "https://api.example.com/" {
+			t.Errorf("expected update link %q, got %q", "https://api.example.com/", got)
+		}
+	})
+	t.Run("should not link an empty user", func(t *testing.T) {
+		if links := userResource(events.APIGatewayProxyRequest{}, &user.User{}).Links; links != nil {
+			t.Errorf("expected no links, got %+v", links)
+		}
+	})
+	t.Run("should link the next page with the same parameters from the cursor", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{
+			Path:                  "/",
+			QueryStringParameters: map[string]string{"limit": "20", "cursor": "old"},
+			RequestContext:        events.APIGatewayProxyRequestContext{Stage: "staging"},
+		}
+		want := `</staging/?cursor=old&limit=20>; rel="self", </staging/?cursor=new&limit=20>; rel="next"`
+		if got := listLinks(req, "new"); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+	t.Run("should accept a user sent back as it was read, links and all", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+			"status":    {S: aws.String("active")},
+			"createdAt": {S: aws.String("2023-01-01T00:00:00Z")},
+		}}}
+		read, _ := GetUser(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}, "test", mockDb)
+		if !strings.Contains(read.Body, `"links"`) {
+			t.Fatalf("expected the user to be read with links, got %s", read.Body)
+		}
+		written, _ := UpdateUser(events.APIGatewayProxyRequest{Body: read.Body}, "test", mockDb)
+		if written.StatusCode != 200 {
+			t.Errorf("expected status code to be %d, got %d: %s", 200, written.StatusCode, written.Body)
+		}
+	})
+}
+
+func TestGraphQL(t *testing.T) {
+	ConfigureGraphQL(GraphQLConfig{Enabled: true})
+	defer ConfigureGraphQL(GraphQLConfig{})
+	t.Run("should resolve a user with only the fields asked for", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		}}}
+		resp, _ := GraphQL(events.APIGatewayProxyRequest{
+			Body: `{"query":"query($email: String!) { user(email: $email) { firstName } }","variables":{"email":"Alan.Oliver@ecs.co.uk"}}`,
+		}, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code to be %d, got %d: %s", 200, resp.StatusCode, resp.Body)
+		}
+		if resp.Body != `{"data":{"user":{"firstName":"Alan"}}}` {
+			t.Errorf("expected only the first name, got %s", resp.Body)
+		}
+	})
+	t.Run("should present a resolver error with its translated code", func(t *testing.T) {
+		resp, _ := GraphQL(events.APIGatewayProxyRequest{
+			Headers: map[string]string{"Accept-Language": "fr"},
+			Body:    `{"query":"{ users(first: 0) { nodes { email } } }"}`,
+		}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code to be %d, got %d: %s", 200, resp.StatusCode, resp.Body)
+		}
+		if !strings.Contains(resp.Body, `"code":"first_must_be_a_whole_number_between_1_and_the_maximum_page_size"`) || resp.Headers["Content-Language"] != "fr" {
+			t.Errorf("expected the error's code in French, got %v: %s", resp.Headers, resp.Body)
+		}
+	})
+	t.Run("should refuse to update a suspended user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":  {S: aws.String("alan.oliver@ecs.co.uk")},
+			"status": {S: aws.String(user.StatusSuspended)},
+		}}}
+		resp, _ := GraphQL(events.APIGatewayProxyRequest{
+			Body: `{"query":"mutation { updateUser(input: {email: \"alan.oliver@ecs.co.uk\", firstName: \"Al\"}) { firstName } }"}`,
+		}, "test", mockDb)
+		if resp.StatusCode != 200 || !strings.Contains(resp.Body, `"code":"account_is_suspended"`) {
+			t.Fatalf("expected the update to be refused, got %d %s", resp.StatusCode, resp.Body)
+		}
+		if mockDb.Count("UpdateItem") != 0 || mockDb.Count("TransactWriteItems") != 0 {
+			t.Errorf("expected the suspended user not to be changed, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("should return a 400 response without a query", func(t *testing.T) {
+		resp, _ := GraphQL(events.APIGatewayProxyRequest{Body: `{}`}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+}
+
+// searchDomain answers every request to the search index with status and
+// body.
+type searchDomain struct {
+	status int
+	body   string
+}
+
+func (d searchDomain) Do(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: d.status, Body: io.NopCloser(strings.NewReader(d.body))}, nil
+}
+
+func TestSearchUsers(t *testing.T) {
+	stored := &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{{
+		"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+		"firstName": {S: aws.String("Alan")},
+		"lastName":  {S: aws.String("Oliver")},
+	}}}
+	configure := func(t *testing.T, d searchDomain) {
+		search.Configure(search.Config{Endpoint: "https://search.example.com", Client: d, MaxAttempts: 1})
+		t.Cleanup(func() { search.Configure(search.Config{}) })
+	}
+
+	t.Run("should return a 400 response without a query", func(t *testing.T) {
+		resp, _ := SearchUsers(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": " "}}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+	t.Run("should answer from the index with scores and highlights", func(t *testing.T) {
+		configure(t, searchDomain{status: 200, body: `{"hits": {"total": {"value": 1}, "hits": [` +
+			`{"_score": 2.5, "_source": {"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"},` +
+			` "highlight": {"email.text": ["<em>alan</em>.oliver@ecs.co.uk"]}}]}}`})
+		mockDb := &testutil.MockDynamoDB{}
+		resp, _ := SearchUsers(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "alna"}}, "test", mockDb)
+		if resp.StatusCode != 200 || resp.Headers[HeaderSearchBackend] != search.BackendIndex {
+			t.Fatalf("expected the index to answer, got %d %v: %s", resp.StatusCode, resp.Headers, resp.Body)
+		}
+		var results []SearchResult
+		json.Unmarshal([]byte(resp.Body), &results)
+		if len(results) != 1 || results[0].Score != 2.5 || !reflect.DeepEqual(results[0].Highlights, map[string][]string{"email": {"<em>alan</em>.oliver@ecs.co.uk"}}) {
+			t.Errorf("expected the score and highlight, got %s", resp.Body)
+		}
+		if mockDb.Count("Scan") != 0 {
+			t.Errorf("expected the table not to be scanned, got %d scans", mockDb.Count("Scan"))
+		}
+	})
+	t.Run("should fall back to a prefix match while the index is unavailable", func(t *testing.T) {
+		configure(t, searchDomain{status: 503})
+		mockDb := &testutil.MockDynamoDB{ScanOutput: stored}
+		resp, _ := SearchUsers(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "ala"}}, "test", mockDb)
+		if resp.StatusCode != 200 || resp.Headers[HeaderSearchBackend] != search.BackendPrefix {
+			t.Fatalf("expected a prefix match, got %d %v: %s", resp.StatusCode, resp.Headers, resp.Body)
+		}
+		input := mockDb.Calls()[0].Input.(*dynamodb.ScanInput)
+		if !strings.Contains(aws.StringValue(input.FilterExpression), "begins_with(email, :emailPrefix)") || aws.StringValue(input.ExpressionAttributeValues[":namePrefix"].S) != "Ala" {
+			t.Errorf("expected emails and names filtered by the prefix, got %v", input)
+		}
+		if !strings.Contains(resp.Body, `"email":"alan.oliver@ecs.co.uk"`) || strings.Contains(resp.Body, `"score"`) {
+			t.Errorf("expected the user without a score, got %s", resp.Body)
+		}
+	})
+	t.Run("should fall back when no index is configured", func(t *testing.T) {
+		resp, _ := SearchUsers(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "ala"}}, "test", &testutil.MockDynamoDB{ScanOutput: stored})
+		if resp.StatusCode != 200 || resp.Headers[HeaderSearchBackend] != search.BackendPrefix {
+			t.Errorf("expected a prefix match, got %d %v", resp.StatusCode, resp.Headers)
+		}
+	})
+	t.Run("should return a 500 response when the index rejects the query", func(t *testing.T) {
+		configure(t, searchDomain{status: 400, body: `{"error": {"type": "parsing_exception"}}`})
+		resp, _ := SearchUsers(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "ala"}}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 500 {
+			t.Errorf("expected status code to be %d, got %d", 500, resp.StatusCode)
+		}
+	})
+}
+
+func TestGetSuggestions(t *testing.T) {
+	t.Run("should return a 400 response without a query", func(t *testing.T) {
+		resp, _ := GetSuggestions(events.APIGatewayProxyRequest{}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+	t.Run("should return a 400 response for more than the most suggestions", func(t *testing.T) {
+		resp, _ := GetSuggestions(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "ala", "limit": "21"}}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+	t.Run("should complete names and emails from the table without an index", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		}}}}
+		resp, _ := GetSuggestions(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "ala"}}, "test", mockDb)
+		if resp.StatusCode != 200 || resp.Headers[HeaderSearchBackend] != search.BackendPrefix {
+			t.Fatalf("expected a prefix match, got %d %v: %s", resp.StatusCode, resp.Headers, resp.Body)
+		}
+		if resp.Body != `[{"email":"alan.oliver@ecs.co.uk","name":"Alan Oliver"}]` {
+			t.Errorf("expected the one completion, got %s", resp.Body)
+		}
+		if input := mockDb.Calls()[0].Input.(*dynamodb.ScanInput); input.ProjectionExpression == nil {
+			t.Errorf("expected only the names and email read, got %v", input)
+		}
+	})
+}
+
+func TestMergeUsers(t *testing.T) {
+	t.Run("should return a 400 response without both emails", func(t *testing.T) {
+		resp, _ := MergeUsers(events.APIGatewayProxyRequest{Body: `{"from": "alan.oliver@ecs.co.uk"}`}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+	t.Run("should return a 400 response merging a user into themselves", func(t *testing.T) {
+		resp, _ := MergeUsers(events.APIGatewayProxyRequest{Body: `{"from": "alan@ecs.co.uk", "into": "alan@ecs.co.uk"}`}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, user.ErrorMergeSameUser) {
+			t.Errorf("expected a 400 response, got %d: %s", resp.StatusCode, resp.Body)
+		}
+	})
+	t.Run("should return a 404 response for a missing user", func(t *testing.T) {
+		resp, _ := MergeUsers(events.APIGatewayProxyRequest{Body: `{"from": "alan.oliver@ecs.co.uk", "into": "alan@ecs.co.uk"}`}, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 404 {
+			t.Errorf("expected status code to be %d, got %d", 404, resp.StatusCode)
+		}
+	})
+}
+
+func TestCreateRelation(t *testing.T) {
+	t.Run("should return a 400 response for an invalid type", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "alan@ecs.co.uk"}, Body: `{"type": "Line Manager", "target": "boss@ecs.co.uk"}`}
+		resp, _ := CreateRelation(req, "test", &testutil.MockDynamoDB{})
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+	})
+	t.Run("should return a 404 response for a missing user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			return nil, &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+			}}
+		}}
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "alan@ecs.co.uk"}, Body: `{"type": "mentor", "target": "boss@ecs.co.uk"}`}
+		resp, _ := CreateRelation(req, "test", mockDb)
+		if resp.StatusCode != 404 {
+			t.Errorf("expected status code to be %d, got %d", 404, resp.StatusCode)
+		}
+	})
+}
+
+func TestPutAttribute(t *testing.T) {
+	t.Run("should return a 400 response for an invalid attribute", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"name": "tier"}, Body: `{"type": "enum"}`}
+		resp, _ := PutAttribute(req, "test", mockDb)
+		if resp.StatusCode != 400 {
+			t.Errorf("expected status code to be %d, got %d", 400, resp.StatusCode)
+		}
+		if mockDb.Count("PutItem") != 0 {
+			t.Error("expected the attribute not to be saved")
+		}
+	})
+	t.Run("should register the attribute under the name in the path", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"name": "tier"}, Body: `{"name": "other", "type": "enum", "enum": ["gold", "silver"]}`}
+		resp, _ := PutAttribute(req, "test", mockDb)
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code to be %d, got %d", 200, resp.StatusCode)
+		}
+		if !strings.Contains(resp.Body, `"name":"tier"`) {
+			t.Errorf("expected the attribute to be named from the path, got %s", resp.Body)
+		}
+	})
+}
+
+func TestDeleteAttribute(t *testing.T) {
+	req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"name": "tier"}}
+	resp, _ := DeleteAttribute(req, "test", &testutil.MockDynamoDB{})
+	if resp.StatusCode != 404 {
+		t.Errorf("expected status code to be %d, got %d", 404, resp.StatusCode)
+	}
 }
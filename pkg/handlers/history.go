@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorInvalidAsOf = "asOf must be an RFC 3339 time"
+
+// GetUserEvents lists the changes made to the user named by the "email" path
+// parameter, oldest first, when users are event sourced.
+func GetUserEvents(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	history, err := user.FetchEvents(req.PathParameters["email"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, history)
+}
+
+// getUserAsOf answers GET by email with asOf: the user as they were then,
+// rebuilt from their events.
+func getUserAsOf(req events.APIGatewayProxyRequest, email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	at, err := time.Parse(time.RFC3339Nano, req.QueryStringParameters["asOf"])
+	if err != nil {
+		return errorResponse(req, errors.New(ErrorInvalidAsOf), http.StatusBadRequest)
+	}
+	names := fields(req)
+	result, err := user.FetchUserAsOf(email, at, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, sparse(userResource(req, result), names))
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// RequireOwnUser wraps a handler for requests that may carry an OpenID
+// Connect identity token. Requests without one go ahead as before. A token
+// that does not verify answers 401. A write naming a user other than the one
+// whose verified email the token carries answers 403, as does a write whose
+// user cannot be told from its path, query or body, such as a bulk
+// operation, unless it also carries the admin key.
+func RequireOwnUser(next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		token := headerValue(req, oidc.Header())
+		if token == "" {
+			return next(req, tableName, dynaClient)
+		}
+		identity, err := oidc.Verify(token)
+		if err != nil {
+			return errorResponse(req, err, http.StatusUnauthorized)
+		}
+		if req.HTTPMethod != http.MethodGet && !isAdmin(req) {
+			if email := targetEmail(req); email == "" || !identity.Owns(email) {
+				return errorResponse(req, errors.New(oidc.ErrorNotOwnUser), http.StatusForbidden)
+			}
+		}
+		return next(req, tableName, dynaClient)
+	}
+}
+
+// requestIdentity returns the verified identity req carries, or nil when it
+// carries none.
+func requestIdentity(req events.APIGatewayProxyRequest) *user.Identity {
+	token := headerValue(req, oidc.Header())
+	if !oidc.Enabled() || token == "" {
+		return nil
+	}
+	identity, err := oidc.Verify(token)
+	if err != nil {
+		return nil
+	}
+	return &user.Identity{Subject: identity.Subject, Issuer: identity.Issuer}
+}
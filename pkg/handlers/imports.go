@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/s3import"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ImportUsers imports the CSV sent as the body, up to
+// user.MaxRequestImportRows rows. A JSON body's key names an object of the
+// import bucket instead, which is imported in the background and answered
+// with 202 and where its manifest will be written.
+func ImportUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return errorResponse(req, errors.New(user.ErrorInvalidCSVHeader), http.StatusBadRequest)
+		}
+		body = string(decoded)
+	}
+
+	if strings.HasPrefix(headerValue(req, "Content-Type"), "application/json") {
+		var ref struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(body), &ref); err != nil {
+			return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+		}
+		request, err := s3import.Start(ref.Key, tableName)
+		if err != nil {
+			return errorResponse(req, err, http.StatusInternalServerError)
+		}
+		return apiResponse(http.StatusAccepted, request)
+	}
+
+	report, err := user.ImportUsersAs(strings.NewReader(body), audit.ActorOf(req), user.MaxRequestImportRows, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	lang := i18n.Negotiate(headerValue(req, "Accept-Language"))
+	for i, row := range report.Rows {
+		report.Rows[i].Code = i18n.Code(row.Error)
+		report.Rows[i].Error = i18n.Translate(lang, report.Rows[i].Code, "", row.Error)
+		if row.Fields != nil {
+			report.Rows[i].Fields = translateFieldErrors(lang, row.Fields)
+		}
+	}
+	return apiResponseWithHeaders(http.StatusOK, report, map[string]string{"Content-Language": lang})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/invitation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func CreateInvitation(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(invitation.ErrorInvalidInvitationData), http.StatusBadRequest)
+	}
+	inv, err := invitation.Create(body.Email, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, inv)
+}
+
+// RedeemInvitation creates the invitee's user from the body, which holds the
+// invitation token and the user's first password alongside the user's fields.
+func RedeemInvitation(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+		user.User
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(invitation.ErrorInvalidInvitationData), http.StatusBadRequest)
+	}
+	newUser, err := invitation.Redeem(body.Token, body.User, body.Password, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, userResource(req, newUser))
+}
+
+func GetInvitations(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	invitations, err := invitation.FetchAll(req.QueryStringParameters["status"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, invitations)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var ErrorInvalidLimit = "limit must be a whole number between 1 and the maximum page size"
+
+// LimitConfig sets how many items a list returns per page: Default when the
+// request has no limit parameter, and at most Max whatever it asks for. Zero
+// disables either.
+type LimitConfig struct {
+	Default int
+	Max     int
+}
+
+var limits LimitConfig
+
+func ConfigureLimits(config LimitConfig) {
+	limits = config
+}
+
+// limit returns the page size req asks for with its limit query parameter,
+// or the default when it has none. A limit below one or above the maximum
+// is refused rather than clamped, so a client never silently gets fewer
+// items than it asked for.
+func limit(req events.APIGatewayProxyRequest) (int, error) {
+	param, ok := req.QueryStringParameters["limit"]
+	if !ok {
+		return limits.Default, nil
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 1 || limits.Max > 0 && n > limits.Max {
+		return 0, errors.New(ErrorInvalidLimit)
+	}
+	return n, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Link is a request a client can make next: its URL and method.
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+}
+
+// UserResource is a user as responses send it, with links to read, update
+// and delete it, so clients need not build the API's URLs themselves.
+type UserResource struct {
+	*user.User
+	Links map[string]Link `json:"links,omitempty"`
+}
+
+// userResource links u from the API req was made to. An empty user, read
+// for an email that has none, is sent without links.
+func userResource(req events.APIGatewayProxyRequest, u *user.User) UserResource {
+	resource := UserResource{User: u}
+	if u == nil || u.Email == "" {
+		return resource
+	}
+	base := baseURL(req)
+	resource.Links = map[string]Link{
+		"self":   {Href: base + "/?email=" + url.QueryEscape(u.Email), Method: http.MethodGet},
+		"update": {Href: base + "/", Method: http.MethodPut},
+		"delete": {Href: base + "/users/" + url.PathEscape(u.Email), Method: http.MethodDelete},
+	}
+	return resource
+}
+
+// withoutLinks returns req with the links member of its body dropped, so a
+// user read with the links userResource adds can be sent back as it was
+// read. A body that is not a JSON object is left for the decoder to refuse.
+func withoutLinks(req events.APIGatewayProxyRequest) events.APIGatewayProxyRequest {
+	if !strings.Contains(req.Body, `"links"`) {
+		return req
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(req.Body), &fields); err != nil {
+		return req
+	}
+	if _, ok := fields["links"]; !ok {
+		return req
+	}
+	delete(fields, "links")
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return req
+	}
+	req.Body = string(body)
+	return req
+}
+
+func userResources(req events.APIGatewayProxyRequest, users []user.User) []UserResource {
+	resources := make([]UserResource, len(users))
+	for i := range users {
+		resources[i] = userResource(req, &users[i])
+	}
+	return resources
+}
+
+// listLinks returns the Link header of a list page: itself and, when the
+// list continues, the next page, which is the same request from cursor on.
+// Lists are sent as arrays, so their links go in the header, as RFC 8288
+// describes, rather than in the body.
+func listLinks(req events.APIGatewayProxyRequest, cursor string) string {
+	query := url.Values{}
+	for name, value := range req.QueryStringParameters {
+		query.Set(name, value)
+	}
+	_, path := splitVersion(req.Path)
+	page := baseURL(req) + "/" + strings.TrimPrefix(path, "/")
+	links := []string{`<` + withQuery(page, query) + `>; rel="self"`}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+		links = append(links, `<`+withQuery(page, query)+`>; rel="next"`)
+	}
+	return strings.Join(links, ", ")
+}
+
+func withQuery(page string, query url.Values) string {
+	if len(query) == 0 {
+		return page
+	}
+	return page + "?" + query.Encode()
+}
+
+// baseURL is where the API req was made to is served from: the stage of the
+// domain API Gateway received it on, and the version req named, so links
+// stay within it. Without a domain, as when running locally, links are
+// relative.
+func baseURL(req events.APIGatewayProxyRequest) string {
+	base := ""
+	if domain := req.RequestContext.DomainName; domain != "" {
+		base = "https://" + domain
+	}
+	// HTTP APIs serve their $default stage without a path prefix
+	if stage := req.RequestContext.Stage; stage != "" && stage != "$default" {
+		base += "/" + stage
+	}
+	if version, _ := splitVersion(req.Path); version != "" {
+		base += "/" + version
+	}
+	return base
+}
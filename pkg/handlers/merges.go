@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func GetDuplicates(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	report, err := user.FindDuplicates(tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, report)
+}
+
+// MergeUsers merges the user at the body's from into the user at its into.
+// Emails are taken as given, so users stored under an email with capitals
+// can be merged into its lowercase form.
+func MergeUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		From string `json:"from"`
+		Into string `json:"into"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(user.ErrorInvalidUserData), http.StatusBadRequest)
+	}
+	from, into := strings.TrimSpace(body.From), strings.TrimSpace(body.Into)
+	if from == "" || into == "" {
+		return errorResponse(req, errors.New(user.ErrorEmailRequired), http.StatusBadRequest)
+	}
+	merged, err := user.MergeUsersAs(from, into, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, merged))
+}
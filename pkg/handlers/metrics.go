@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// MetricRouteLatency is how long each route took to answer, by route
+	// and outcome: an error when it failed or answered with a server error.
+	MetricRouteLatency = "RouteLatency"
+	// MetricRequests counts requests, by route and for the whole API, as
+	// the total service level indicators are measured against.
+	MetricRequests = "Requests"
+	// MetricAvailableRequests counts the requests that did not fail or
+	// answer with a server error, recording 0 for those that did.
+	MetricAvailableRequests = "AvailableRequests"
+	// MetricFastRequests counts the requests answered within the latency
+	// objective, recording 0 for those that were not.
+	MetricFastRequests = "FastRequests"
+)
+
+// DefaultLatencyObjective is how quickly requests should be answered, when
+// no other objective is configured.
+const DefaultLatencyObjective = time.Second
+
+// SLOConfig sets the service level objectives requests are measured
+// against: LatencyObjective is how quickly each should be answered.
+type SLOConfig struct {
+	LatencyObjective time.Duration
+}
+
+var slo = SLOConfig{LatencyObjective: DefaultLatencyObjective}
+
+func ConfigureSLO(config SLOConfig) {
+	if config.LatencyObjective <= 0 {
+		config.LatencyObjective = DefaultLatencyObjective
+	}
+	slo = config
+}
+
+// measure wraps the handler for route, a method and pattern such as
+// GET /users/{email}, recording how long it takes to answer and whether it
+// met the availability and latency objectives. Good requests of each are
+// counted alongside every request, by route and for the whole API, so
+// CloudWatch SLOs and alarms can divide one by the other.
+func measure(route string, next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		resp, err := next(req, tableName, dynaClient)
+		elapsed := time.Since(start)
+		available := err == nil && resp != nil && resp.StatusCode < http.StatusInternalServerError
+		outcome := metrics.OutcomeSuccess
+		if !available {
+			outcome = metrics.OutcomeError
+		}
+		metrics.Duration(MetricRouteLatency, elapsed, metrics.Dimensions{"Route": route, "Outcome": outcome})
+		for _, dimensions := range []metrics.Dimensions{{"Route": route}, nil} {
+			metrics.Count(MetricRequests, dimensions)
+			metrics.Record(MetricAvailableRequests, metrics.UnitCount, good(available), dimensions)
+			metrics.Record(MetricFastRequests, metrics.UnitCount, good(elapsed <= slo.LatencyObjective), dimensions)
+		}
+		return resp, err
+	}
+}
+
+func good(met bool) float64 {
+	if met {
+		return 1
+	}
+	return 0
+}
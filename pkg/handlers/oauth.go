@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/graph"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// readRoutes are the routes that only read users although their method is
+// not GET, by method and pattern.
+var readRoutes = map[string]bool{
+	"POST /exports": true,
+}
+
+// requiredScope returns the scope req needs for the route matching pattern:
+// users:read for GET, the readRoutes and GraphQL queries, and users:write
+// for any other.
+func requiredScope(req events.APIGatewayProxyRequest, pattern string) string {
+	if req.HTTPMethod == http.MethodGet || readRoutes[req.HTTPMethod+" "+pattern] {
+		return oauth.ScopeRead
+	}
+	if pattern == "/graphql" {
+		var body graph.Request
+		if json.Unmarshal([]byte(req.Body), &body) == nil && graph.ReadOnly(body) {
+			return oauth.ScopeRead
+		}
+	}
+	return oauth.ScopeWrite
+}
+
+// RequireToken wraps a handler for requests that must present an access
+// token granting scope. It answers 401 without a valid token and 403
+// without the scope, saying why in WWW-Authenticate.
+func RequireToken(scope string, next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		bearer := bearerToken(req)
+		token, err := oauth.Validate(bearer)
+		if err != nil {
+			resp, err := errorResponse(req, err, http.StatusUnauthorized)
+			if resp.StatusCode == http.StatusUnauthorized {
+				// A request without a token is told only how to authenticate
+				resp.Headers["WWW-Authenticate"] = "Bearer"
+				if bearer != "" {
+					resp.Headers["WWW-Authenticate"] = `Bearer error="invalid_token"`
+				}
+			}
+			return resp, err
+		}
+		if !token.HasScope(scope) {
+			resp, err := errorResponse(req, errors.New(oauth.ErrorInsufficientScope), http.StatusForbidden)
+			resp.Headers["WWW-Authenticate"] = `Bearer error="insufficient_scope", scope="` + scope + `"`
+			return resp, err
+		}
+		return next(req, tableName, dynaClient)
+	}
+}
+
+// bearerToken returns the token req's Authorization header carries.
+func bearerToken(req events.APIGatewayProxyRequest) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(headerValue(req, "Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ContentTypeProblem is the Content-Type of errors sent as problem details.
+const ContentTypeProblem = "application/problem+json; charset=utf-8"
+
+var ErrorValidationFailed = "request has invalid fields"
+
+// Problem is an error as RFC 7807 problem details. Code is the same stable
+// code error bodies carry, and Errors the failed fields of a validation
+// error, both extension members.
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code,omitempty"`
+	Errors   validators.FieldErrors `json:"errors,omitempty"`
+}
+
+// wantsProblem reports whether errors should be sent to req as problem
+// details: when configured for every request, for every request made to
+// v2, or when req accepts them.
+func wantsProblem(req events.APIGatewayProxyRequest) bool {
+	if responses.Problems || requestVersion(req) == VersionV2 {
+		return true
+	}
+	for _, accepted := range strings.Split(headerValue(req, "Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/problem+json") {
+			return true
+		}
+	}
+	return false
+}
+
+// problemResponse answers with the problem code describes, and the fields
+// that failed validation, if any. Its type is the code under the configured
+// base URL, or about:blank without one, in which case the title is the
+// status text as RFC 7807 asks.
+func problemResponse(req events.APIGatewayProxyRequest, status int, code string, detail string, fieldErrs validators.FieldErrors, headers map[string]string) (*events.APIGatewayProxyResponse, error) {
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.Path,
+		Code:     code,
+		Errors:   fieldErrs,
+	}
+	if responses.ProblemTypeBase != "" {
+		problem.Type = responses.ProblemTypeBase + code
+		problem.Title = detail
+	}
+	withType := map[string]string{"Content-Type": ContentTypeProblem}
+	for name, value := range headers {
+		withType[name] = value
+	}
+	return apiResponseWithHeaders(status, problem, withType)
+}
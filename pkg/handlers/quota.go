@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// HeaderQuotaLimit is the writes the caller may make per window.
+	HeaderQuotaLimit = "X-Quota-Limit"
+	// HeaderQuotaRemaining is the writes left to the caller in this window.
+	HeaderQuotaRemaining = "X-Quota-Remaining"
+	// HeaderQuotaReset is when the window ends, in Unix seconds.
+	HeaderQuotaReset = "X-Quota-Reset"
+)
+
+// RequireQuota wraps a handler for writes, answering 429 once the caller has
+// made its quota of writes for the window. Writes go ahead when they cannot
+// be counted, so the quota table failing does not fail the API.
+func RequireQuota(next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		usage, err := quota.Take(caller(req), tableName, dynaClient)
+		if err != nil && err.Error() == quota.ErrorQuotaExceeded {
+			resp, err := errorResponse(req, err, http.StatusTooManyRequests)
+			setQuotaHeaders(resp, usage)
+			resp.Headers["Retry-After"] = strconv.Itoa(int((time.Until(usage.Reset) + time.Second - 1) / time.Second))
+			return resp, err
+		}
+		resp, err := next(req, tableName, dynaClient)
+		setQuotaHeaders(resp, usage)
+		return resp, err
+	}
+}
+
+// caller returns who a write is counted against: the API key API Gateway
+// identified, or else the user it writes, whom an identity token must own.
+// A key the request carries itself is not trusted, as a caller sending a new
+// one each time would never reach either quota.
+func caller(req events.APIGatewayProxyRequest) quota.Identity {
+	if key := req.RequestContext.Identity.APIKey; key != "" {
+		return quota.Key(key)
+	}
+	if email := targetEmail(req); email != "" {
+		return quota.Email(email)
+	}
+	return quota.Identity{}
+}
+
+// targetEmail returns the email of the user a request is made to, named in
+// its path, query or body, or "" when it names none.
+func targetEmail(req events.APIGatewayProxyRequest) string {
+	if email := req.PathParameters["email"]; email != "" {
+		return email
+	}
+	if email := req.QueryStringParameters["email"]; email != "" {
+		return email
+	}
+	body := []byte(req.Body)
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return ""
+		}
+		body = decoded
+	}
+	var named struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &named) != nil {
+		return ""
+	}
+	return named.Email
+}
+
+func setQuotaHeaders(resp *events.APIGatewayProxyResponse, usage *quota.Usage) {
+	if resp == nil || usage == nil {
+		return
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers[HeaderQuotaLimit] = strconv.Itoa(usage.Limit)
+	resp.Headers[HeaderQuotaRemaining] = strconv.Itoa(usage.Remaining)
+	resp.Headers[HeaderQuotaReset] = strconv.FormatInt(usage.Reset.Unix(), 10)
+}
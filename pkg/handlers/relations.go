@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/relation"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// The relation handlers read the user's email, and the relation's type and
+// target where there are any, from the "email", "type" and "target" path
+// parameters.
+
+// GetRelations answers the relations the user is on either end of, only
+// those of the "type" query string parameter when it is given.
+func GetRelations(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	relations, err := relation.FetchRelations(req.PathParameters["email"], req.QueryStringParameters["type"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, relations)
+}
+
+func CreateRelation(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	var body struct {
+		Type   string `json:"type"`
+		Target string `json:"target"`
+	}
+	if err := jsonbody.Decode(req.Body, &body); err != nil {
+		return errorResponse(req, errors.New(relation.ErrorInvalidRelationData), http.StatusBadRequest)
+	}
+	r, err := relation.Relate(req.PathParameters["email"], body.Type, body.Target, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusCreated, r)
+}
+
+func DeleteRelation(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	err := relation.Unrelate(req.PathParameters["email"], req.PathParameters["type"], req.PathParameters["target"], tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, nil)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/flags"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/tenant"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorRouteNotFound = "route not found"
+
+// methods maps the HTTP methods a route answers to their handler.
+type methods map[string]Handler
+
+// route is a path pattern, such as /users/{email}/tags, with the handlers
+// for its methods. Segments in braces match any one non-empty segment, which
+// is passed to the handler as the path parameter of that name.
+type route struct {
+	pattern string
+	methods methods
+}
+
+// routes lists every path the API answers. A request is answered by the
+// first route matching its path: with the handler for its method, the
+// methods allowed for OPTIONS, and 405 for any other method.
+var routes = []route{
+	{"/", methods{"GET": GetUser, "POST": CreateUser, "PUT": RequireActive(UpdateUser), "DELETE": DeleteUser}},
+	{"/avatars", methods{"POST": RequireActive(RequestAvatarUpload), "PUT": RequireActive(AttachAvatar)}},
+	{"/users/search", methods{"GET": SearchUsers}},
+	{"/users/suggest", methods{"GET": GetSuggestions}},
+	{"/users/{email}", methods{"DELETE": DeleteUser}},
+	{"/users/{email}/events", methods{"GET": GetUserEvents}},
+	{"/users/{email}/sessions", methods{"GET": RequireSession(GetSessions), "DELETE": RequireSession(RevokeSessions)}},
+	{"/users/{email}/sessions/{id}", methods{"DELETE": RequireSession(RevokeSession)}},
+	{"/users/{email}/preferences", methods{"GET": RequireActive(GetPreferences), "PUT": RequireActive(UpdatePreferences)}},
+	{"/users/{email}/mfa", methods{"POST": RequireActive(EnrollMFA), "PUT": RequireActive(ConfirmMFA)}},
+	{"/users/{email}/password", methods{"PUT": RequireActive(SetPassword)}},
+	{"/users/{email}/email", methods{"POST": RequireActive(RequestEmailChange)}},
+	{"/users/{email}/suspend", methods{"POST": RequireAdmin(SuspendUser)}},
+	{"/users/{email}/deactivate", methods{"POST": RequireAdmin(DeactivateUser)}},
+	{"/users/{email}/reactivate", methods{"POST": RequireAdmin(ReactivateUser)}},
+	{"/users/{email}/tags", methods{"POST": RequireActive(AddTags), "DELETE": RequireActive(RemoveTags)}},
+	{"/users/{email}/relations", methods{"GET": GetRelations, "POST": CreateRelation}},
+	{"/users/{email}/relations/{type}/{target}", methods{"DELETE": DeleteRelation}},
+	{"/groups", methods{"GET": GetGroups, "POST": CreateGroup}},
+	{"/groups/{id}", methods{"GET": GetGroup, "PUT": UpdateGroup, "DELETE": DeleteGroup}},
+	{"/groups/{id}/members", methods{"GET": GetGroupUsers, "POST": AddGroupMember}},
+	{"/groups/{id}/members/{email}", methods{"DELETE": RemoveGroupMember}},
+	{"/invitations", methods{"GET": RequireAdmin(GetInvitations), "POST": RequireAdmin(CreateInvitation)}},
+	{"/organizations", methods{"GET": GetOrganizations, "POST": CreateOrganization}},
+	{"/organizations/{id}", methods{"GET": GetOrganization, "PUT": UpdateOrganization, "DELETE": DeleteOrganization}},
+	{"/invitations/redeem", methods{"POST": RedeemInvitation}},
+	{"/graphql", methods{"POST": GraphQL}},
+	{"/login", methods{"POST": Login}},
+	{"/password-resets", methods{"POST": RequestPasswordReset}},
+	{"/password-resets/confirm", methods{"POST": ConfirmPasswordReset}},
+	{"/email-changes/confirm", methods{"POST": ConfirmEmailChange}},
+	{"/exports", methods{"POST": RequireAdmin(ExportUsers)}},
+	{"/exports/{id}", methods{"GET": RequireAdmin(GetExport)}},
+	{"/imports", methods{"POST": RequireAdmin(ImportUsers)}},
+	{"/deletions", methods{"POST": RequireAdmin(DeleteUsers)}},
+	{"/attributes", methods{"GET": RequireAdmin(GetAttributes)}},
+	{"/attributes/{name}", methods{"GET": RequireAdmin(GetAttribute), "PUT": RequireAdmin(PutAttribute), "DELETE": RequireAdmin(DeleteAttribute)}},
+	{"/duplicates", methods{"GET": RequireAdmin(GetDuplicates)}},
+	{"/merges", methods{"POST": RequireAdmin(MergeUsers)}},
+	{"/backups", methods{"GET": RequireAdmin(GetBackups), "POST": RequireAdmin(CreateBackup)}},
+	{"/backups/{name}", methods{"GET": RequireAdmin(GetBackup)}},
+	{"/backups/{name}/restore", methods{"GET": RequireAdmin(VerifyRestore), "POST": RequireAdmin(RestoreBackup)}},
+	{"/erasures", methods{"GET": RequireAdmin(GetErasure), "POST": RequireAdmin(EraseUser)}},
+	{"/projections/domains", methods{"GET": GetDomainCounts}},
+	{"/projections/recent", methods{"GET": GetRecentUsers}},
+	{"/webhooks", methods{"GET": RequireAdmin(GetWebhooks), "POST": RequireAdmin(RegisterWebhook)}},
+	{"/webhooks/{id}", methods{"GET": RequireAdmin(GetWebhook), "DELETE": RequireAdmin(DeleteWebhook)}},
+	{"/webhooks/{id}/deliveries", methods{"GET": RequireAdmin(GetWebhookDeliveries)}},
+}
+
+// Route answers req with the handler for its method and path. A body over
+// the configured limit is refused before any route is matched. The handler
+// is given the table of the tenant the request names, or tableName when it
+// names none. Every method but GET is a write, counted against the
+// caller's quota once its access token, when tokens are required, has been
+// validated. Paths may start with the version they are answered as, and
+// are answered as v1 when they do not. Responses from deprecated versions
+// and routes say so.
+func Route(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	if bodyTooLarge(req) {
+		return errorResponse(req, errors.New(jsonbody.ErrorBodyTooLarge), http.StatusRequestEntityTooLarge)
+	}
+	tableName, err := tenant.Table(headerValue(req, tenant.Header()), tableName)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	version, path := splitVersion(req.Path)
+	for _, r := range routes {
+		params, ok := r.match(path)
+		if !ok {
+			continue
+		}
+		if handler, ok := r.methods[req.HTTPMethod]; ok {
+			if len(params) > 0 {
+				// Emails are stored lowercased, so every handler looks up
+				// the user the path names the same way
+				if email, ok := params["email"]; ok {
+					params["email"] = strings.ToLower(strings.TrimSpace(email))
+				}
+				req.PathParameters = params
+			}
+			if req.HTTPMethod != http.MethodGet {
+				handler = RequireQuota(handler)
+			}
+			// A GraphQL request may change several users, so its resolvers
+			// check the identity against each user they change
+			if oidc.Enabled() && r.pattern != "/graphql" {
+				handler = RequireOwnUser(handler)
+			}
+			if oauth.Enabled() {
+				handler = RequireToken(requiredScope(req, r.pattern), handler)
+			}
+			handler = measure(req.HTTPMethod+" "+r.pattern, handler)
+			resp, err := handler(req, tableName, dynaClient)
+			var warnings []string
+			if key, d := deprecation(req, r.pattern); d != nil {
+				warnings = append(warnings, deprecate(resp, key, d))
+			}
+			if version == VersionV2 && flags.Enabled(flags.Envelope, client(req)) {
+				resp = envelope(resp, path, warnings)
+			}
+			return resp, err
+		}
+		if req.HTTPMethod == http.MethodOptions {
+			return optionsResponse(r.allowed())
+		}
+		return methodNotAllowed(req, r.allowed())
+	}
+	return errorResponse(req, errors.New(ErrorRouteNotFound), http.StatusNotFound)
+}
+
+// match reports whether path matches the route's pattern, returning the
+// path parameters it captured.
+func (r route) match(path string) (map[string]string, bool) {
+	want, got := segments(r.pattern), segments(path)
+	if len(want) != len(got) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range want {
+		if !strings.HasPrefix(segment, "{") {
+			if got[i] != segment {
+				return nil, false
+			}
+			continue
+		}
+		value, err := url.PathUnescape(got[i])
+		if err != nil || value == "" {
+			return nil, false
+		}
+		params[strings.Trim(segment, "{}")] = value
+	}
+	return params, true
+}
+
+// allowed returns the methods the route answers, OPTIONS included, sorted.
+func (r route) allowed() []string {
+	allowed := []string{http.MethodOptions}
+	for method := range r.methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+func segments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// optionsResponse answers OPTIONS with the methods allowed and no body.
+func optionsResponse(allowed []string) (*events.APIGatewayProxyResponse, error) {
+	return &events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    responseHeaders(map[string]string{"Allow": strings.Join(allowed, ", ")}),
+	}, nil
+}
+
+func methodNotAllowed(req events.APIGatewayProxyRequest, allowed []string) (*events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{"Allow": strings.Join(allowed, ", ")}
+	if wantsProblem(req) {
+		lang := i18n.Negotiate(headerValue(req, "Accept-Language"))
+		code := i18n.Code(ErrorMethodNotAllowed)
+		headers["Content-Language"] = lang
+		return problemResponse(req, http.StatusMethodNotAllowed, code, i18n.Translate(lang, code, "", ErrorMethodNotAllowed), nil, headers)
+	}
+	return apiResponseWithHeaders(http.StatusMethodNotAllowed, ErrorMethodNotAllowed, headers)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// HeaderSearchBackend names what answered a search: the search index, or a
+// prefix match over the table when the index is unavailable.
+const HeaderSearchBackend = "X-Search-Backend"
+
+// defaultSearchLimit is the page size of a search without a limit when no
+// default page size is configured, as relevance past it is seldom useful.
+const defaultSearchLimit = 20
+
+// SearchResult is a user matching a search. Results from the index carry
+// their relevance and the fragments that matched; prefix matches carry
+// neither.
+type SearchResult struct {
+	UserResource
+	Score      float64             `json:"score,omitempty"`
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// searchPosition is where a search's next page starts: an offset into the
+// index's results, or the scan of a prefix match.
+type searchPosition struct {
+	From int                `json:"from,omitempty"`
+	Scan *user.ScanPosition `json:"scan,omitempty"`
+}
+
+// SearchUsers answers the users matching q, from the search index when it
+// is available and otherwise by the beginning of their email or names. A
+// page of the index's results can only be continued by the index.
+func SearchUsers(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	q, err := search.ValidQuery(req.QueryStringParameters["q"])
+	if err != nil {
+		return errorResponse(req, err, http.StatusBadRequest)
+	}
+	n, err := limit(req)
+	if err != nil {
+		return errorResponse(req, err, http.StatusBadRequest)
+	}
+	if n == 0 {
+		n = defaultSearchLimit
+	}
+	var position searchPosition
+	if token := req.QueryStringParameters["cursor"]; token != "" {
+		if err := cursor.Decode(token, &position); err != nil {
+			return errorResponse(req, err, http.StatusBadRequest)
+		}
+		if position.Scan != nil && !position.Scan.Valid() {
+			return errorResponse(req, errors.New(cursor.ErrorInvalidCursor), http.StatusBadRequest)
+		}
+	}
+
+	if position.Scan == nil {
+		results, err := search.Query(q, position.From, n, tableName)
+		if err == nil {
+			matches := make([]SearchResult, len(results.Hits))
+			for i := range results.Hits {
+				hit := &results.Hits[i]
+				matches[i] = SearchResult{UserResource: userResource(req, &hit.User), Score: hit.Score, Highlights: hit.Highlights}
+			}
+			var next searchPosition
+			next.From = results.Next(position.From)
+			return searchResponse(req, matches, search.BackendIndex, next.From > 0, next)
+		}
+		if err.Error() != search.ErrorUnavailable || position.From > 0 {
+			return errorResponse(req, err, http.StatusInternalServerError)
+		}
+	}
+
+	result, err := user.ScanUsersWithOptions(tableName, dynaClient, user.FetchOptions{Prefix: q, Limit: n, Start: position.Scan})
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	matches := make([]SearchResult, len(result.Users))
+	for i := range result.Users {
+		matches[i] = SearchResult{UserResource: userResource(req, &result.Users[i])}
+	}
+	return searchResponse(req, matches, search.BackendPrefix, result.Next != nil, searchPosition{Scan: result.Next})
+}
+
+// searchResponse sends a page of matches, with the cursor of the next page
+// when there is one and cursors are enabled.
+func searchResponse(req events.APIGatewayProxyRequest, matches []SearchResult, backend string, more bool, next searchPosition) (*events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{HeaderSearchBackend: backend}
+	var token string
+	if more {
+		headers[HeaderResultsTruncated] = "true"
+		if cursor.Enabled() {
+			var err error
+			if token, err = cursor.Encode(next); err != nil {
+				return errorResponse(req, err, http.StatusInternalServerError)
+			}
+			headers[HeaderNextCursor] = token
+		}
+	}
+	headers["Link"] = listLinks(req, token)
+	return apiResponseWithHeaders(http.StatusOK, matches, headers)
+}
+
+// defaultSuggestions is how many completions a suggestion without a limit
+// returns.
+const defaultSuggestions = 5
+
+// GetSuggestions answers the users whose names or email begin with q, for
+// completing what is typed into a search box, within a tight time budget.
+func GetSuggestions(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	q, err := search.ValidQuery(req.QueryStringParameters["q"])
+	if err != nil {
+		return errorResponse(req, err, http.StatusBadRequest)
+	}
+	n := defaultSuggestions
+	if _, ok := req.QueryStringParameters["limit"]; ok {
+		if n, err = limit(req); err != nil || n > search.MaxSuggestions {
+			return errorResponse(req, errors.New(ErrorInvalidLimit), http.StatusBadRequest)
+		}
+	}
+	found, backend, err := search.Suggest(q, n, tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponseWithHeaders(http.StatusOK, found, map[string]string{HeaderSearchBackend: backend})
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// HeaderSessionToken carries the token of the session a request is made in.
+const HeaderSessionToken = "X-Session-Token"
+
+// RequireSession wraps a handler for a user's own operations, answering 401
+// unless the request carries the token of one of their unexpired sessions,
+// and 403 when the session is another user's. Requests carrying the admin
+// key go ahead without one.
+func RequireSession(next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		if isAdmin(req) {
+			return next(req, tableName, dynaClient)
+		}
+		token := headerValue(req, HeaderSessionToken)
+		if token == "" {
+			return errorResponse(req, errors.New(session.ErrorInvalidSession), http.StatusUnauthorized)
+		}
+		s, err := session.Validate(token, session.Table(tableName), dynaClient)
+		if err != nil {
+			return errorResponse(req, err, http.StatusInternalServerError)
+		}
+		if s.Email != req.PathParameters["email"] {
+			return errorResponse(req, errors.New(session.ErrorNotOwnSession), http.StatusForbidden)
+		}
+		return next(req, tableName, dynaClient)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Handler answers a request routed to it.
+type Handler func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error)
+
+// RequireActive wraps a handler for operations performed on behalf of the
+// user the request names, in its path, query or body, answering 403 while
+// their account is suspended or deactivated. Requests naming no user are
+// left for the handler to refuse.
+func RequireActive(next Handler) Handler {
+	return func(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+		if email := strings.ToLower(strings.TrimSpace(targetEmail(req))); email != "" {
+			if err := user.RequireActive(email, tableName, dynaClient); err != nil {
+				return errorResponse(req, err, http.StatusInternalServerError)
+			}
+		}
+		return next(req, tableName, dynaClient)
+	}
+}
+
+func SuspendUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	return setStatus(req, user.StatusSuspended, tableName, dynaClient)
+}
+
+func DeactivateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	return setStatus(req, user.StatusDeactivated, tableName, dynaClient)
+}
+
+func ReactivateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	return setStatus(req, user.StatusActive, tableName, dynaClient)
+}
+
+func setStatus(req events.APIGatewayProxyRequest, status string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*events.APIGatewayProxyResponse, error) {
+	updatedUser, err := user.SetStatusAs(req.PathParameters["email"], status, audit.ActorOf(req), tableName, dynaClient)
+	if err != nil {
+		return errorResponse(req, err, http.StatusInternalServerError)
+	}
+	return apiResponse(http.StatusOK, userResource(req, updatedUser))
+}
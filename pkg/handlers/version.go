@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Versions of the API, chosen by the prefix of a request's path. Both answer
+// the same routes with the same handlers and differ only in how responses
+// are shaped: v1 keeps the legacy format, and is what paths without a
+// prefix are answered as, while v2 wraps bodies in an Envelope, for the
+// callers the envelope flag is on for, and sends every error as problem
+// details.
+const (
+	VersionV1 = "v1"
+	VersionV2 = "v2"
+)
+
+// versions lists the prefixes a path may start with.
+var versions = []string{VersionV1, VersionV2}
+
+// splitVersion returns the version path names and the path it names within
+// that version, or "" and path itself when it names none.
+func splitVersion(path string) (string, string) {
+	for _, version := range versions {
+		prefix := "/" + version
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			if rest := strings.TrimPrefix(path, prefix); rest != "" {
+				return version, rest
+			}
+			return version, "/"
+		}
+	}
+	return "", path
+}
+
+// requestVersion returns the version req is answered as.
+func requestVersion(req events.APIGatewayProxyRequest) string {
+	if version, _ := splitVersion(req.Path); version != "" {
+		return version
+	}
+	return VersionV1
+}
+
+// Envelope is the body of a successful v2 response: what v1 sends as the
+// whole body under data, what v1 sends in headers about a list under meta,
+// and warnings, such as that the route is deprecated.
+type Envelope struct {
+	Data     json.RawMessage `json:"data"`
+	Meta     *EnvelopeMeta   `json:"meta,omitempty"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+// EnvelopeMeta describes a list page: the cursor the next page starts from
+// and whether the page stopped at the scan limit.
+type EnvelopeMeta struct {
+	NextCursor string `json:"nextCursor,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+}
+
+// envelope wraps the body of a successful JSON response in an Envelope
+// with warnings.
+// Errors, responses without a body and GraphQL, whose responses have a
+// shape of their own, are answered as they are.
+func envelope(resp *events.APIGatewayProxyResponse, path string, warnings []string) *events.APIGatewayProxyResponse {
+	if resp == nil || resp.StatusCode >= http.StatusMultipleChoices || resp.Body == "" || path == "/graphql" {
+		return resp
+	}
+	if resp.Headers["Content-Type"] != ContentTypeJSON || !json.Valid([]byte(resp.Body)) {
+		return resp
+	}
+	wrapped := Envelope{Data: json.RawMessage(resp.Body), Warnings: warnings}
+	if cursor, truncated := resp.Headers[HeaderNextCursor], resp.Headers[HeaderResultsTruncated] == "true"; cursor != "" || truncated {
+		wrapped.Meta = &EnvelopeMeta{NextCursor: cursor, Truncated: truncated}
+	}
+	body, err := json.Marshal(wrapped)
+	if err != nil {
+		return resp
+	}
+	resp.Body = string(body)
+	return resp
+}
+
+// client returns who req is from, for flags rolled out to some callers: the
+// API key API Gateway identified or the request carries, or else its source
+// IP.
+func client(req events.APIGatewayProxyRequest) string {
+	if key := req.RequestContext.Identity.APIKey; key != "" {
+		return key
+	}
+	if key := headerValue(req, quota.KeyHeader()); key != "" {
+		return key
+	}
+	return req.RequestContext.Identity.SourceIP
+}
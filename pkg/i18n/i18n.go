@@ -0,0 +1,108 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const DefaultLanguage = "en"
+
+//go:embed bundles/*.json
+var bundleFiles embed.FS
+
+// bundles holds the messages for each supported language keyed by the stable
+// machine code, e.g. bundles["fr"]["user_already_exists"].
+var bundles = loadBundles()
+
+func loadBundles() map[string]map[string]string {
+	entries, err := bundleFiles.ReadDir("bundles")
+	if err != nil {
+		panic(err)
+	}
+	loaded := map[string]map[string]string{}
+	for _, entry := range entries {
+		data, err := bundleFiles.ReadFile(path.Join("bundles", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic("i18n: invalid bundle " + entry.Name() + ": " + err.Error())
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// Code turns an error message into its stable machine code, e.g.
+// "user already exists" becomes "user_already_exists".
+func Code(message string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(message) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			underscore = false
+			continue
+		}
+		underscore = true
+	}
+	return b.String()
+}
+
+// Translate returns the message for code in lang with any {param} placeholder
+// replaced, or fallback when the language has no message for the code.
+func Translate(lang string, code string, param string, fallback string) string {
+	message, ok := bundles[lang][code]
+	if !ok {
+		return fallback
+	}
+	return strings.ReplaceAll(message, "{param}", param)
+}
+
+// Negotiate picks the best supported language for an Accept-Language header,
+// defaulting to English.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	candidates := []candidate{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(lang, "-"); i >= 0 {
+			lang = lang[:i]
+		}
+		if _, ok := bundles[lang]; !ok {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{lang, quality})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLanguage
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].lang
+}
@@ -0,0 +1,273 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/backup"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/cursor"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/graph"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/group"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/invitation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oauth"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/oidc"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/organization"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/projection"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/quota"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/relation"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/search"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/tenant"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/webhook"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                             "en",
+		"fr":                           "fr",
+		"es-MX":                        "es",
+		"de-DE, fr-CH;q=0.9, en;q=0.8": "fr",
+		"en;q=0.5, es;q=0.9":           "es",
+		"fr;q=0, es;q=0.1":             "es",
+		"de, it":                       "en",
+		"*":                            "en",
+		" FR-ca ; q=0.7 , EN ; q=0.6 ": "fr",
+	}
+	for header, expected := range cases {
+		if got := Negotiate(header); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, header, got)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	cases := map[string]string{
+		"user already exists":                 "user_already_exists",
+		"erasure incomplete, retry to resume": "erasure_incomplete_retry_to_resume",
+		"Error Method Not Allowed":            "error_method_not_allowed",
+	}
+	for message, expected := range cases {
+		if got := Code(message); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, message, got)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := Translate("fr", "validation.max.too_long", "50", "fallback"); got != "doit contenir au plus 50 caractères" {
+		t.Errorf("expected the French message, got %q", got)
+	}
+	if got := Translate("fr", "unknown_code", "", "fallback"); got != "fallback" {
+		t.Errorf("expected the fallback, got %q", got)
+	}
+}
+
+func TestBundles(t *testing.T) {
+	t.Run("should translate every user error in every language", func(t *testing.T) {
+		messages := []string{
+			backup.ErrorBackupAlreadyExists,
+			backup.ErrorBackupNotAvailable,
+			backup.ErrorBackupNotFound,
+			backup.ErrorFailedToCreateBackup,
+			backup.ErrorFailedToFetchBackup,
+			backup.ErrorFailedToRestoreBackup,
+			backup.ErrorFailedToVerifyRestore,
+			backup.ErrorInvalidBackupName,
+			backup.ErrorInvalidTableName,
+			backup.ErrorRestoreNotFound,
+			backup.ErrorTableAlreadyExists,
+			credentials.ErrorFailedToDeleteCredentials,
+			credentials.ErrorFailedToEnrollMFA,
+			credentials.ErrorFailedToFetchCredentials,
+			credentials.ErrorFailedToHashPassword,
+			credentials.ErrorFailedToSaveCredentials,
+			credentials.ErrorFailedToUnmarshalCredentials,
+			credentials.ErrorFailedToSendResetEmail,
+			credentials.ErrorInvalidCredentials,
+			credentials.ErrorInvalidMFACode,
+			credentials.ErrorInvalidResetToken,
+			credentials.ErrorMFANotConfigured,
+			credentials.ErrorMFANotEnrolled,
+			credentials.ErrorMFARequired,
+			credentials.ErrorPasswordNotSet,
+			credentials.ErrorPasswordResetNotConfigured,
+			cursor.ErrorCursorsNotConfigured,
+			cursor.ErrorInvalidCursor,
+			group.ErrorFailedToDeleteGroup,
+			group.ErrorFailedToFetchGroup,
+			group.ErrorFailedToMarshalGroup,
+			group.ErrorFailedToSaveGroup,
+			group.ErrorFailedToUnmarshalGroup,
+			group.ErrorFailedToUpdateMembership,
+			group.ErrorGroupNotFound,
+			group.ErrorInvalidGroupData,
+			graph.ErrorInvalidFirst,
+			invitation.ErrorFailedToFetchInvitation,
+			invitation.ErrorFailedToSaveInvitation,
+			invitation.ErrorFailedToUnmarshalInvitation,
+			invitation.ErrorInvalidInvitationData,
+			invitation.ErrorInvitationExpired,
+			invitation.ErrorInvitationNotFound,
+			invitation.ErrorInvitationRedeemed,
+			jsonbody.ErrorBodyTooLarge,
+			oauth.ErrorFailedToValidateToken,
+			oauth.ErrorInsufficientScope,
+			oauth.ErrorInvalidToken,
+			oauth.ErrorTokenRequired,
+			oidc.ErrorFailedToVerifyIdentity,
+			oidc.ErrorInvalidIdentity,
+			oidc.ErrorNotOwnUser,
+			organization.ErrorFailedToDeleteOrganization,
+			organization.ErrorFailedToFetchOrganization,
+			organization.ErrorFailedToMarshalOrganization,
+			organization.ErrorFailedToSaveOrganization,
+			organization.ErrorFailedToUnmarshalOrganization,
+			organization.ErrorInvalidOrganizationData,
+			organization.ErrorOrganizationNotFound,
+			projection.ErrorFailedToFetchProjection,
+			quota.ErrorQuotaExceeded,
+			relation.ErrorFailedToFetchRelations,
+			relation.ErrorFailedToSaveRelation,
+			relation.ErrorFailedToUnmarshalRelation,
+			relation.ErrorInvalidRelationData,
+			relation.ErrorInvalidRelationType,
+			relation.ErrorRelationCycle,
+			relation.ErrorSelfRelation,
+			schema.ErrorAttributeNotFound,
+			schema.ErrorFailedToDeleteAttribute,
+			schema.ErrorFailedToFetchAttributes,
+			schema.ErrorFailedToSaveAttribute,
+			schema.ErrorFailedToUnmarshalAttribute,
+			schema.ErrorInvalidAttributeData,
+			search.ErrorFailedToSearch,
+			search.ErrorInvalidQuery,
+			search.ErrorUnavailable,
+			session.ErrorFailedToDeleteSession,
+			session.ErrorFailedToFetchSession,
+			session.ErrorFailedToSaveSession,
+			session.ErrorFailedToUnmarshalSession,
+			session.ErrorInvalidSession,
+			session.ErrorNotOwnSession,
+			session.ErrorSessionNotFound,
+			store.ErrorThrottled,
+			store.ErrorTimeout,
+			store.ErrorUnavailable,
+			tenant.ErrorFailedToResolveTenant,
+			tenant.ErrorInvalidTenant,
+			tenant.ErrorUnknownTenant,
+			user.ErrorAccountDeactivated,
+			user.ErrorAccountSuspended,
+			user.ErrorAvatarNotUploaded,
+			user.ErrorAvatarTooLarge,
+			user.ErrorAvatarsNotConfigured,
+			user.ErrorConcurrentUpdate,
+			user.ErrorConditionFailed,
+			user.ErrorConditionNotSupported,
+			user.ErrorCouldNotDynamoPutItem,
+			user.ErrorCouldNotMarshalItem,
+			user.ErrorDisposableEmail,
+			user.ErrorEmailChangeIncomplete,
+			user.ErrorEmailChangeNotConfigured,
+			user.ErrorEmailRequired,
+			user.ErrorEmailUnchanged,
+			user.ErrorErasureIncomplete,
+			user.ErrorErasureNotFound,
+			user.ErrorEventSourcingNotConfigured,
+			user.ErrorExportNotFound,
+			user.ErrorExportsNotConfigured,
+			user.ErrorFailedToAppendEvent,
+			user.ErrorFailedToAttachAvatar,
+			user.ErrorFailedToChangeEmail,
+			user.ErrorFailedToDeleteRecord,
+			user.ErrorFailedToExport,
+			user.ErrorFailedToFetchErasure,
+			user.ErrorFailedToFetchEvents,
+			user.ErrorFailedToFetchExport,
+			user.ErrorFailedToFetchPreferences,
+			user.ErrorFailedToFetchRecord,
+			user.ErrorFailedToMarshalExport,
+			user.ErrorFailedToMarshalPreferences,
+			user.ErrorFailedToMergeUsers,
+			user.ErrorFailedToMoveUser,
+			user.ErrorFailedToPresignAvatar,
+			user.ErrorFailedToRecordActivity,
+			user.ErrorFailedToReadImport,
+			user.ErrorFailedToSaveErasure,
+			user.ErrorFailedToSaveExport,
+			user.ErrorFailedToSendEmailConfirmation,
+			user.ErrorFailedToSavePreferences,
+			user.ErrorFailedToStartImport,
+			user.ErrorFailedToUnmarshalErasure,
+			user.ErrorFailedToUnmarshalExport,
+			user.ErrorFailedToUnmarshalEvent,
+			user.ErrorFailedToUnmarshalPreferences,
+			user.ErrorFailedToUnmarshalRecord,
+			user.ErrorFailedToUpdateStatus,
+			user.ErrorFailedToUpdateTags,
+			user.ErrorImportNotFound,
+			user.ErrorImportsNotConfigured,
+			user.ErrorInvalidAvatarKey,
+			user.ErrorInvalidCSVHeader,
+			user.ErrorInvalidCSVRow,
+			user.ErrorInvalidJSONRow,
+			user.ErrorInvalidEmail,
+			user.ErrorInvalidEmailChangeToken,
+			user.ErrorInvalidInactiveDays,
+			user.ErrorInvalidStatusTransition,
+			user.ErrorInvalidUserData,
+			user.ErrorMergeIncomplete,
+			user.ErrorMergeSameUser,
+			user.ErrorNoUsersSelected,
+			user.ErrorTooManyUsers,
+			user.ErrorUndeliverableEmail,
+			user.ErrorUnknownExportFormat,
+			user.ErrorUnknownField,
+			user.ErrorUnsupportedAvatarType,
+			user.ErrorUserAlreadyExists,
+			user.ErrorUserNotFound,
+			webhook.ErrorFailedToDeleteWebhook,
+			webhook.ErrorFailedToFetchDeliveries,
+			webhook.ErrorFailedToFetchWebhook,
+			webhook.ErrorFailedToLogDelivery,
+			webhook.ErrorFailedToMarshalWebhook,
+			webhook.ErrorFailedToSaveWebhook,
+			webhook.ErrorFailedToUnmarshalWebhook,
+			webhook.ErrorInvalidWebhookData,
+			webhook.ErrorInvalidWebhookEvents,
+			webhook.ErrorInvalidWebhookURL,
+			webhook.ErrorWebhookNotFound,
+			webhook.ErrorWebhooksNotConfigured,
+		}
+		for lang, bundle := range bundles {
+			for _, message := range messages {
+				if _, ok := bundle[Code(message)]; !ok {
+					t.Errorf("expected %s bundle to translate %q", lang, message)
+				}
+			}
+		}
+	})
+	t.Run("should keep English messages the same as the source", func(t *testing.T) {
+		for code, message := range bundles["en"] {
+			if !strings.HasPrefix(code, "validation.") && Code(message) != code {
+				t.Errorf("expected English message %q to have code %q", message, code)
+			}
+		}
+	})
+	t.Run("should have the same keys in every language", func(t *testing.T) {
+		for lang, bundle := range bundles {
+			if len(bundle) != len(bundles["en"]) {
+				t.Errorf("expected %s bundle to have %d messages, got %d", lang, len(bundles["en"]), len(bundle))
+			}
+			for code := range bundles["en"] {
+				if _, ok := bundle[code]; !ok {
+					t.Errorf("expected %s bundle to translate %q", lang, code)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package invitation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRedeemed = "redeemed"
+	// StatusExpired is reported for pending invitations past their expiry. It
+	// is never stored.
+	StatusExpired = "expired"
+)
+
+var (
+	ErrorFailedToFetchInvitation     = "failed to fetch invitation"
+	ErrorFailedToSaveInvitation      = "failed to save invitation"
+	ErrorFailedToUnmarshalInvitation = "failed to unmarshal invitation"
+	ErrorInvalidInvitationData       = "invalid invitation data"
+	ErrorInvitationExpired           = "invitation has expired"
+	ErrorInvitationNotFound          = "invitation not found"
+	ErrorInvitationRedeemed          = "invitation has already been redeemed"
+)
+
+// Config controls how long invitations stay valid.
+type Config struct {
+	TTL time.Duration
+}
+
+var config = Config{TTL: 7 * 24 * time.Hour}
+
+func Configure(c Config) {
+	config = c
+}
+
+// Invitation lets the invitee create their user. Only a hash of the token is
+// stored: the token itself is returned once, when the invitation is created.
+type Invitation struct {
+	ID         string `json:"id"`
+	Email      string `json:"email"`
+	Status     string `json:"status"`
+	CreatedAt  string `json:"createdAt"`
+	ExpiresAt  string `json:"expiresAt"`
+	RedeemedAt string `json:"redeemedAt,omitempty"`
+	Token      string `json:"token,omitempty" dynamodbav:"-"`
+	// TTL lets DynamoDB remove invitations a while after they expire
+	TTL int64 `json:"-" dynamodbav:"ttl"`
+}
+
+// TableName returns the invitations table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Invitation"
+}
+
+// Create invites email to create a user. The email must not belong to an
+// existing user.
+func Create(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Invitation, error) {
+	if !validators.IsEmailValid(email) {
+		return nil, errors.New(user.ErrorInvalidEmail)
+	}
+	existing, err := user.FetchUser(email, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing.Email) != 0 {
+		return nil, errors.New(user.ErrorUserAlreadyExists)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveInvitation)
+	}
+	now := time.Now().UTC()
+	expires := now.Add(config.TTL)
+	inv := Invitation{
+		ID:        tokenID(token),
+		Email:     email,
+		Status:    StatusPending,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: expires.Format(time.RFC3339),
+		TTL:       expires.Add(30 * 24 * time.Hour).Unix(),
+	}
+	av, err := dynamodbattribute.MarshalMap(inv)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveInvitation)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveInvitation)
+	}
+	inv.Token = token
+	return &inv, nil
+}
+
+// Redeem creates the invitee's user from u, whose email is taken from the
+// invitation, with password as their first password when one is given. The
+// invitation is claimed before the user is created so it can only be
+// redeemed once, and released again if the user cannot be created.
+func Redeem(token string, u user.User, password string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*user.User, error) {
+	if password != "" {
+		if err := credentials.ValidatePassword(password); err != nil {
+			return nil, err
+		}
+	}
+	inv, err := fetch(tokenID(token), userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	switch inv.status(time.Now()) {
+	case StatusRedeemed:
+		return nil, errors.New(ErrorInvitationRedeemed)
+	case StatusExpired:
+		return nil, errors.New(ErrorInvitationExpired)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := setStatus(inv.ID, StatusPending, StatusRedeemed, now, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+	u.Email = inv.Email
+	created, err := user.Create(u, userTableName, dynaClient)
+	if err != nil {
+		_ = setStatus(inv.ID, StatusRedeemed, StatusPending, "", userTableName, dynaClient)
+		return nil, err
+	}
+	if password != "" {
+		if err := credentials.SetPassword(created.Email, password, userTableName, dynaClient); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// StatusIndex is the index of the invitations table keyed by their stored
+// status and then when they were created, which FetchAll queries.
+const StatusIndex = "status-index"
+
+// FetchAll lists invitations, oldest first, only those in status when it is
+// not empty. Each stored status is read from StatusIndex, so listing the
+// pending invitations reads none of those redeemed.
+func FetchAll(status string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Invitation, error) {
+	var stored []string
+	switch status {
+	case "":
+		stored = []string{StatusPending, StatusRedeemed}
+	case StatusPending, StatusExpired:
+		// Expired invitations are stored as pending
+		stored = []string{StatusPending}
+	case StatusRedeemed:
+		stored = []string{StatusRedeemed}
+	}
+	now := time.Now()
+	invitations := []Invitation{}
+	for _, s := range stored {
+		page, err := fetchByStatus(s, userTableName, dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range page {
+			inv.Status = inv.status(now)
+			if status == "" || inv.Status == status {
+				invitations = append(invitations, inv)
+			}
+		}
+	}
+	sort.SliceStable(invitations, func(i, j int) bool { return invitations[i].CreatedAt < invitations[j].CreatedAt })
+	return invitations, nil
+}
+
+// fetchByStatus reads the invitations stored with status.
+func fetchByStatus(status string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Invitation, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(TableName(userTableName)),
+		IndexName:                aws.String(StatusIndex),
+		KeyConditionExpression:   aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {S: aws.String(status)},
+		},
+	}
+	invitations := []Invitation{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchInvitation)
+		}
+		page := []Invitation{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalInvitation)
+		}
+		invitations = append(invitations, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return invitations, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func fetch(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Invitation, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+		TableName:      aws.String(TableName(userTableName)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchInvitation)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorInvitationNotFound)
+	}
+	inv := new(Invitation)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, inv); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalInvitation)
+	}
+	return inv, nil
+}
+
+// setStatus moves the invitation from one status to another, failing with
+// ErrorInvitationRedeemed when it is no longer in the status it moves from.
+func setStatus(id string, from string, to string, redeemedAt string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+		TableName:                aws.String(TableName(userTableName)),
+		UpdateExpression:         aws.String("SET #status = :to, redeemedAt = :redeemedAt"),
+		ConditionExpression:      aws.String("#status = :from"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from":       {S: aws.String(from)},
+			":to":         {S: aws.String(to)},
+			":redeemedAt": {S: aws.String(redeemedAt)},
+		},
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrorInvitationRedeemed)
+		}
+		return store.Error(err, ErrorFailedToSaveInvitation)
+	}
+	return nil
+}
+
+func (inv Invitation) status(now time.Time) string {
+	if inv.Status != StatusPending {
+		return inv.Status
+	}
+	expires, err := time.Parse(time.RFC3339, inv.ExpiresAt)
+	if err != nil || !now.Before(expires) {
+		return StatusExpired
+	}
+	return StatusPending
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// tokenID is the key an invitation is stored under, so the table never holds
+// a token that could be redeemed.
+func tokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
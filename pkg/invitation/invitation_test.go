@@ -0,0 +1,179 @@
+package invitation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// tableClient keeps the users and invitations tables in memory.
+type tableClient struct {
+	dynamodbiface.DynamoDBAPI
+	users       map[string]map[string]*dynamodb.AttributeValue
+	invitations map[string]map[string]*dynamodb.AttributeValue
+	passwords   map[string]*dynamodb.AttributeValue
+	userPutErr  error
+}
+
+func newTableClient() *tableClient {
+	return &tableClient{
+		users:       map[string]map[string]*dynamodb.AttributeValue{},
+		invitations: map[string]map[string]*dynamodb.AttributeValue{},
+		passwords:   map[string]*dynamodb.AttributeValue{},
+	}
+}
+
+func (c *tableClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	if *input.TableName == "test" {
+		return &dynamodb.GetItemOutput{Item: c.users[*input.Key["email"].S]}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: c.invitations[*input.Key["id"].S]}, nil
+}
+
+func (c *tableClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	switch *input.TableName {
+	case "test":
+		if c.userPutErr != nil {
+			return nil, c.userPutErr
+		}
+		c.users[*input.Item["email"].S] = input.Item
+	case TableName("test"):
+		c.invitations[*input.Item["id"].S] = input.Item
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *tableClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if *input.TableName == credentials.TableName("test") {
+		c.passwords[*input.Key["email"].S] = input.ExpressionAttributeValues[":hash"]
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	item := c.invitations[*input.Key["id"].S]
+	if *item["status"].S != *input.ExpressionAttributeValues[":from"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+	}
+	item["status"] = input.ExpressionAttributeValues[":to"]
+	item["redeemedAt"] = input.ExpressionAttributeValues[":redeemedAt"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// Scan answers the scan of the attribute registry users are checked against,
+// which is empty.
+func (c *tableClient) Scan(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{}, nil
+}
+
+func (c *tableClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, item := range c.invitations {
+		if *item["status"].S == *input.ExpressionAttributeValues[":status"].S {
+			items = append(items, item)
+		}
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+func TestInvitations(t *testing.T) {
+	invitee := user.User{FirstName: "Al", LastName: "Oliver"}
+
+	t.Run("expect an invitation to be redeemed once", func(t *testing.T) {
+		client := newTableClient()
+		inv, err := Create("alan.oliver@ecs.co.uk", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if inv.Token == "" || inv.Status != StatusPending {
+			t.Fatalf("Expected a pending invitation with a token, got %+v", *inv)
+		}
+		if _, ok := client.invitations[inv.ID]["token"]; ok {
+			t.Error("Expected the token not to be stored")
+		}
+
+		created, err := Redeem(inv.Token, invitee, "", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if created.Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected the user to be created with the invited email, got %s", created.Email)
+		}
+		_, err = Redeem(inv.Token, invitee, "", "test", client)
+		if err == nil || err.Error() != ErrorInvitationRedeemed {
+			t.Errorf("Expected error %s, got %v", ErrorInvitationRedeemed, err)
+		}
+	})
+	t.Run("expect the invitee's first password to be set", func(t *testing.T) {
+		client := newTableClient()
+		inv, _ := Create("alan.oliver@ecs.co.uk", "test", client)
+
+		if _, err := Redeem(inv.Token, invitee, "correct horse battery", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if client.passwords["alan.oliver@ecs.co.uk"] == nil {
+			t.Error("Expected a password to be set")
+		}
+	})
+	t.Run("expect a weak password to leave the invitation pending", func(t *testing.T) {
+		client := newTableClient()
+		inv, _ := Create("alan.oliver@ecs.co.uk", "test", client)
+
+		if _, err := Redeem(inv.Token, invitee, "short", "test", client); err == nil {
+			t.Fatal("Expected an error")
+		}
+		stored := Invitation{}
+		dynamodbattribute.UnmarshalMap(client.invitations[inv.ID], &stored)
+		if stored.Status != StatusPending || len(client.users) != 0 {
+			t.Errorf("Expected the invitation to be pending and no user created, got %s", stored.Status)
+		}
+	})
+	t.Run("expect expired invitations to be rejected", func(t *testing.T) {
+		Configure(Config{TTL: -time.Minute})
+		defer Configure(Config{TTL: 7 * 24 * time.Hour})
+		client := newTableClient()
+		inv, _ := Create("alan.oliver@ecs.co.uk", "test", client)
+
+		_, err := Redeem(inv.Token, invitee, "", "test", client)
+		if err == nil || err.Error() != ErrorInvitationExpired {
+			t.Errorf("Expected error %s, got %v", ErrorInvitationExpired, err)
+		}
+		invitations, _ := FetchAll(StatusExpired, "test", client)
+		if len(invitations) != 1 {
+			t.Errorf("Expected the invitation to be listed as expired, got %+v", invitations)
+		}
+	})
+	t.Run("expect an unknown token to be rejected", func(t *testing.T) {
+		_, err := Redeem("unknown", invitee, "", "test", newTableClient())
+		if err == nil || err.Error() != ErrorInvitationNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorInvitationNotFound, err)
+		}
+	})
+	t.Run("expect the invitation to be released when the user cannot be created", func(t *testing.T) {
+		client := newTableClient()
+		inv, _ := Create("alan.oliver@ecs.co.uk", "test", client)
+		client.userPutErr = awserr.New("InternalServerError", "", nil)
+
+		if _, err := Redeem(inv.Token, invitee, "", "test", client); err == nil {
+			t.Fatal("Expected an error")
+		}
+		stored := Invitation{}
+		dynamodbattribute.UnmarshalMap(client.invitations[inv.ID], &stored)
+		if stored.Status != StatusPending {
+			t.Errorf("Expected the invitation to be pending again, got %s", stored.Status)
+		}
+	})
+	t.Run("expect no invitation for an existing user", func(t *testing.T) {
+		client := newTableClient()
+		client.users["alan.oliver@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}
+		_, err := Create("alan.oliver@ecs.co.uk", "test", client)
+		if err == nil || err.Error() != user.ErrorUserAlreadyExists {
+			t.Errorf("Expected error %s, got %v", user.ErrorUserAlreadyExists, err)
+		}
+	})
+}
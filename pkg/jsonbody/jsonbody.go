@@ -0,0 +1,77 @@
+package jsonbody
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// MaxBytes is the largest body Decode reads. API Gateway accepts bodies
+	// up to 10MB, far more than any JSON request this API takes.
+	MaxBytes = 1 << 20
+	// MaxDepth is the deepest nesting of objects and arrays Decode accepts.
+	MaxDepth = 32
+)
+
+var (
+	ErrorBodyTooLarge  = "request body too large"
+	ErrorBodyTooDeep   = "request body nested too deeply"
+	ErrorUnknownField  = "request body has an unknown field"
+	ErrorTrailingData  = "request body has data after its JSON value"
+	ErrorMalformedBody = "request body is not valid JSON"
+)
+
+// Decode reads the JSON value in body into v, strictly: the body must be a
+// single JSON value of at most MaxBytes, nested at most MaxDepth deep, with
+// no fields v does not have. The limits are checked before anything is
+// allocated for v, so an adversarial body costs no more than its length.
+func Decode(body string, v interface{}) error {
+	if len(body) > MaxBytes {
+		return errors.New(ErrorBodyTooLarge)
+	}
+	if depth(body) > MaxDepth {
+		return errors.New(ErrorBodyTooDeep)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if strings.HasPrefix(err.Error(), "json: unknown field") {
+			return errors.New(ErrorUnknownField)
+		}
+		return errors.New(ErrorMalformedBody)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New(ErrorTrailingData)
+	}
+	return nil
+}
+
+// depth returns the deepest nesting of objects and arrays in body, ignoring
+// brackets inside strings. It does not otherwise check body is valid JSON.
+func depth(body string) int {
+	var current, deepest int
+	inString, escaped := false, false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			current++
+			if current > deepest {
+				deepest = current
+			}
+		case c == '}' || c == ']':
+			current--
+		}
+	}
+	return deepest
+}
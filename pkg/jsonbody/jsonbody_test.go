@@ -0,0 +1,74 @@
+package jsonbody
+
+import (
+	"strings"
+	"testing"
+)
+
+type body struct {
+	Email string            `json:"email"`
+	Tags  []string          `json:"tags"`
+	Meta  map[string]string `json:"meta"`
+	Any   interface{}       `json:"any"`
+}
+
+func TestDecode(t *testing.T) {
+	cases := map[string]struct {
+		body string
+		err  string
+	}{
+		"valid":                    {`{"email": "a@b.com", "tags": ["x"]}`, ""},
+		"surrounding whitespace":   {" \n{\"email\": \"a@b.com\"}\n ", ""},
+		"brackets inside a string": {`{"email": "` + strings.Repeat("[", MaxDepth*2) + `"}`, ""},
+		"nested to the limit":      {`{"any": ` + strings.Repeat("[", MaxDepth-1) + strings.Repeat("]", MaxDepth-1) + `}`, ""},
+		"nested past the limit":    {`{"any": ` + strings.Repeat("[", MaxDepth) + strings.Repeat("]", MaxDepth) + `}`, ErrorBodyTooDeep},
+		"too large":                {`{"email": "` + strings.Repeat("a", MaxBytes) + `"}`, ErrorBodyTooLarge},
+		"unknown field":            {`{"email": "a@b.com", "admin": true}`, ErrorUnknownField},
+		"trailing value":           {`{"email": "a@b.com"} {"email": "c@d.com"}`, ErrorTrailingData},
+		"trailing garbage":         {`{"email": "a@b.com"}]`, ErrorTrailingData},
+		"truncated":                {`{"email": "a@b.com"`, ErrorMalformedBody},
+		"empty":                    {``, ErrorMalformedBody},
+		"wrong type":               {`{"tags": "x"}`, ErrorMalformedBody},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var v body
+			err := Decode(c.body, &v)
+			if c.err == "" && err != nil {
+				t.Errorf("Expected no error, got %s", err.Error())
+			}
+			if c.err != "" && (err == nil || err.Error() != c.err) {
+				t.Errorf("Expected %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		`{"email": "a@b.com", "tags": ["x"], "meta": {"k": "v"}, "any": [1, {"a": null}]}`,
+		`{"email": "\"[{\\"}`,
+		`[[[[[[[[`,
+		`{"email": "a@b.com"} trailing`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		var v body
+		if err := Decode(input, &v); err == nil && depth(input) > MaxDepth {
+			t.Errorf("Expected a body nested %d deep to be refused", depth(input))
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	input := `{"email": "alan.oliver@ecs.co.uk", "tags": ["beta", "staff"], "meta": {"source": "benchmark"}}`
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v body
+		if err := Decode(input, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
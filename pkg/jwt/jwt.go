@@ -0,0 +1,338 @@
+// Package jwt verifies JSON Web Tokens signed with the keys an issuer
+// publishes as a JSON Web Key Set, as access tokens from an authorization
+// server and OpenID Connect identity tokens are. RSA and ECDSA signatures
+// are supported; unsigned tokens never are.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorFailedToFetchKeys = "failed to fetch signing keys"
+	ErrorInvalidToken      = "invalid token"
+)
+
+// leeway is the clock skew tolerated when checking when a token expires or
+// becomes valid.
+const leeway = 30 * time.Second
+
+// refreshInterval is the least time between fetches of the key set made
+// because a token was signed with a key it did not hold.
+const refreshInterval = 30 * time.Second
+
+// HTTPClient fetches key sets. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config sets where a Verifier finds its keys and what it expects of the
+// tokens it verifies. Issuer and Audience are checked when set. Keys are
+// cached for CacheTTL, and fetched again sooner when a token names a key
+// that is not cached, as happens when the issuer rotates its keys. Each
+// fetch is given Timeout.
+type Config struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	CacheTTL time.Duration
+	Timeout  time.Duration
+	Client   HTTPClient
+}
+
+const (
+	DefaultCacheTTL = time.Hour
+	DefaultTimeout  = 3 * time.Second
+)
+
+// Claims are the claims of a verified token.
+type Claims map[string]interface{}
+
+// String returns the claim name when it is a string.
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns the claim name as a list, whether it is a single string or
+// an array of them.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time returns the claim name when it is a NumericDate.
+func (c Claims) Time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// Verifier verifies tokens against the key set of one issuer. It is safe
+// for concurrent use.
+type Verifier struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewVerifier(config Config) *Verifier {
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = DefaultCacheTTL
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &Verifier{config: config, now: time.Now}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks token's signature, expiry, issuer and audience, returning
+// its claims. It fails with ErrorInvalidToken for a token that does not
+// pass, and ErrorFailedToFetchKeys when the key set cannot be read.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	hash, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	key, err := v.key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(h.Alg, hash, key, parts[0]+"."+parts[1], signature) {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	if err := v.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// check refuses claims that have expired, are not yet valid, or were issued
+// by or for someone else.
+func (v *Verifier) check(claims Claims) error {
+	now := v.now()
+	expiry, ok := claims.Time("exp")
+	if !ok || !now.Before(expiry.Add(leeway)) {
+		return errors.New(ErrorInvalidToken)
+	}
+	if notBefore, ok := claims.Time("nbf"); ok && now.Add(leeway).Before(notBefore) {
+		return errors.New(ErrorInvalidToken)
+	}
+	if v.config.Issuer != "" && claims.String("iss") != v.config.Issuer {
+		return errors.New(ErrorInvalidToken)
+	}
+	if v.config.Audience != "" && !contains(claims.Strings("aud"), v.config.Audience) {
+		return errors.New(ErrorInvalidToken)
+	}
+	return nil
+}
+
+// key returns the key kid names, fetching the key set when it is not cached
+// or has expired.
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	stale := v.keys == nil || now.Sub(v.fetched) >= v.config.CacheTTL
+	if key, ok := v.keys[kid]; ok && !stale {
+		return key, nil
+	}
+	if !stale && now.Sub(v.fetched) < refreshInterval {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	keys, err := v.fetch()
+	if err != nil {
+		return nil, err
+	}
+	v.keys, v.fetched = keys, now
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetch reads the key set, keeping the signing keys it can use.
+func (v *Verifier) fetch() (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, nil)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchKeys)
+	}
+	resp, err := v.config.Client.Do(req)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToFetchKeys)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(ErrorFailedToFetchKeys)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, errors.New(ErrorFailedToFetchKeys)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key := k.publicKey(); key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns the key k describes, or nil when it is not an RSA or
+// ECDSA key this package can use.
+func (k jwk) publicKey() crypto.PublicKey {
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return nil
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		curve, ok := curves[k.Crv]
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if !ok || errX != nil || errY != nil {
+			return nil
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+// algorithms maps the signing algorithms accepted to their hashes.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// signingCurves maps the ECDSA algorithms to the curve each signs with.
+var signingCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) bool {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if key.Curve.Params().Name != signingCurves[alg] || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
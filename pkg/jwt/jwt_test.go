@@ -0,0 +1,159 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// issuer publishes its keys as a key set and signs tokens with them.
+type issuer struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	keys   []map[string]string
+	server *httptest.Server
+	calls  int
+}
+
+func newIssuer(t *testing.T) *issuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &issuer{rsaKey: rsaKey, ecKey: ecKey}
+	i.keys = []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.FillBytes(make([]byte, 32))), "y": encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}
+	i.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.calls++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": i.keys})
+	}))
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+func (i *issuer) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	var signature []byte
+	switch alg {
+	case "RS256":
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + encode(signature)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func claims(overrides map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"iss": "https://auth.example.com",
+		"aud": []string{"users-api"},
+		"sub": "integration",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		c[name] = value
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	i := newIssuer(t)
+	newVerifier := func() *Verifier {
+		return NewVerifier(Config{JWKSURL: i.server.URL, Issuer: "https://auth.example.com", Audience: "users-api"})
+	}
+
+	t.Run("expect tokens signed with the issuer's keys to be verified", func(t *testing.T) {
+		v := newVerifier()
+		for _, alg := range []string{"RS256", "ES256"} {
+			kid := map[string]string{"RS256": "rsa", "ES256": "ec"}[alg]
+			verified, err := v.Verify(i.sign(t, alg, kid, claims(nil)))
+			if err != nil || verified.String("sub") != "integration" {
+				t.Errorf("Expected the %s token to be verified, got %v %v", alg, verified, err)
+			}
+		}
+	})
+
+	t.Run("expect tokens that do not pass to be refused", func(t *testing.T) {
+		v := newVerifier()
+		valid := i.sign(t, "RS256", "rsa", claims(nil))
+		cases := map[string]string{
+			"expired":        i.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+			"without expiry": i.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})),
+			"not yet valid":  i.sign(t, "RS256", "rsa", claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+			"other issuer":   i.sign(t, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+			"other audience": i.sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": "other-api"})),
+			"tampered":       valid[:len(valid)-4] + "AAAA",
+			"unsigned":       encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(`{"sub":"integration"}`)) + ".",
+			"wrong key type": i.sign(t, "ES256", "rsa", claims(nil)),
+			"malformed":      "not-a-token",
+		}
+		for name, token := range cases {
+			if _, err := v.Verify(token); err == nil || err.Error() != ErrorInvalidToken {
+				t.Errorf("Expected the %s token to be refused, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("expect keys to be cached and fetched again for an unknown key", func(t *testing.T) {
+		v := newVerifier()
+		now := time.Now()
+		v.now = func() time.Time { return now }
+		i.calls = 0
+		v.Verify(i.sign(t, "RS256", "rsa", claims(nil)))
+		v.Verify(i.sign(t, "RS256", "rsa", claims(nil)))
+		if i.calls != 1 {
+			t.Errorf("Expected the keys to be fetched once, got %d fetches", i.calls)
+		}
+		if _, err := v.Verify(i.sign(t, "RS256", "rotated", claims(nil))); err == nil {
+			t.Error("Expected a token signed with an unknown key to be refused")
+		}
+		if i.calls != 1 {
+			t.Errorf("Expected the keys not to be fetched again so soon, got %d fetches", i.calls)
+		}
+		i.keys[0]["kid"] = "rotated"
+		defer func() { i.keys[0]["kid"] = "rsa" }()
+		now = now.Add(refreshInterval)
+		if _, err := v.Verify(i.sign(t, "RS256", "rotated", claims(nil))); err != nil {
+			t.Errorf("Expected the rotated key to be fetched, got %v", err)
+		}
+	})
+
+	t.Run("expect an unreachable key set to be reported", func(t *testing.T) {
+		v := NewVerifier(Config{JWKSURL: "http://127.0.0.1:1/jwks"})
+		if _, err := v.Verify(i.sign(t, "RS256", "rsa", claims(nil))); err == nil || err.Error() != ErrorFailedToFetchKeys {
+			t.Errorf("Expected %q, got %v", ErrorFailedToFetchKeys, err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+type Fields map[string]interface{}
+
+// Logger writes one JSON object per line, which CloudWatch Logs Insights
+// can query directly. Every field passes through the sanitizer.
+type Logger struct {
+	mu        sync.Mutex
+	out       io.Writer
+	sanitizer *Sanitizer
+}
+
+func New(out io.Writer, sanitizer *Sanitizer) *Logger {
+	return &Logger{out: out, sanitizer: sanitizer}
+}
+
+func (l *Logger) Info(msg string, fields Fields) {
+	l.write(LevelInfo, msg, fields)
+}
+
+func (l *Logger) Error(msg string, err error, fields Fields) {
+	if fields == nil {
+		fields = Fields{}
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	l.write(LevelError, msg, fields)
+}
+
+func (l *Logger) Sanitizer() *Sanitizer {
+	return l.sanitizer
+}
+
+func (l *Logger) write(level string, msg string, fields Fields) {
+	entry := map[string]interface{}{}
+	for key, value := range fields {
+		if l.sanitizer.redacts(key) {
+			entry[key] = redacted
+			continue
+		}
+		entry[key] = l.sanitizer.Value(value)
+	}
+	entry["level"] = level
+	entry["msg"] = l.sanitizer.String(msg)
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
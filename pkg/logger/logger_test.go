@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizer(t *testing.T) {
+	sanitizer := NewSanitizer([]string{"email", "firstName", "lastName"})
+
+	t.Run("should mask email addresses in free text", func(t *testing.T) {
+		got := sanitizer.String("user alan.oliver@ecs.co.uk not found")
+		if got != "user a***@*** not found" {
+			t.Errorf("expected %q, got %q", "user a***@*** not found", got)
+		}
+	})
+	t.Run("should redact configured fields in a JSON body", func(t *testing.T) {
+		got := sanitizer.Body(`{"email": "alan.oliver@ecs.co.uk", "FirstName": "Alan", "lastName": "Oliver", "role": "admin"}`)
+		expected := `{"FirstName":"[REDACTED]","email":"[REDACTED]","lastName":"[REDACTED]","role":"admin"}`
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("should redact configured fields in nested documents", func(t *testing.T) {
+		got := sanitizer.Body(`[{"email": "alan.oliver@ecs.co.uk"}, {"note": "contact alan.shearer@ecs.co.uk"}]`)
+		expected := `[{"email":"[REDACTED]"},{"note":"contact a***@***"}]`
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("should mask emails in a body that is not JSON", func(t *testing.T) {
+		got := sanitizer.Body(`{"email": "alan.oliver@ecs.co.uk"`)
+		expected := `{"email": "a***@***"`
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("should only redact the fields it is configured with", func(t *testing.T) {
+		got := NewSanitizer([]string{"lastName"}).Body(`{"firstName": "Alan", "lastName": "Oliver"}`)
+		expected := `{"firstName":"Alan","lastName":"[REDACTED]"}`
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("should always redact secrets", func(t *testing.T) {
+		got := NewSanitizer(nil).Body(`{"currentPassword": "hunter2hunter2", "password": "correct horse battery"}`)
+		expected := `{"currentPassword":"[REDACTED]","password":"[REDACTED]"}`
+		if got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	})
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("should write sanitized JSON lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := New(&buf, NewSanitizer([]string{"email"}))
+
+		log.Error("failed to fetch alan.oliver@ecs.co.uk", errors.New("no record for alan.oliver@ecs.co.uk"), Fields{
+			"email": "alan.oliver@ecs.co.uk",
+		})
+
+		if !strings.HasSuffix(buf.String(), "\n") {
+			t.Fatalf("expected a new line terminated entry, got %q", buf.String())
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("expected valid JSON, got %s", err.Error())
+		}
+		if entry["level"] != LevelError {
+			t.Errorf("expected level %q, got %q", LevelError, entry["level"])
+		}
+		if entry["msg"] != "failed to fetch a***@***" {
+			t.Errorf("expected msg %q, got %q", "failed to fetch a***@***", entry["msg"])
+		}
+		if entry["error"] != "no record for a***@***" {
+			t.Errorf("expected error %q, got %q", "no record for a***@***", entry["error"])
+		}
+		if entry["email"] != "[REDACTED]" {
+			t.Errorf("expected email %q, got %q", "[REDACTED]", entry["email"])
+		}
+	})
+}
+
+func TestSampler(t *testing.T) {
+	t.Run("should sample the configured rate of requests", func(t *testing.T) {
+		sampler := NewSampler(0.25, "")
+		rolls := []float64{0.1, 0.3, 0.24, 0.9}
+		sampler.roll = func() float64 {
+			roll := rolls[0]
+			rolls = rolls[1:]
+			return roll
+		}
+		var got []bool
+		for n := 0; n < 4; n++ {
+			got = append(got, sampler.Sample(nil))
+		}
+		if !got[0] || got[1] || !got[2] || got[3] {
+			t.Errorf("expected rolls under the rate to be sampled, got %v", got)
+		}
+	})
+	t.Run("should sample all or none of the requests", func(t *testing.T) {
+		if !NewSampler(1, "").Sample(nil) || NewSampler(0, "").Sample(nil) {
+			t.Error("expected a rate of 1 to sample every request and 0 none")
+		}
+	})
+	t.Run("should always sample requests sending the debug header", func(t *testing.T) {
+		sampler := NewSampler(0, "")
+		if !sampler.Sample(map[string]string{"x-debug-log": "1"}) {
+			t.Error("expected the debug header to be matched whatever its case")
+		}
+		if sampler.Sample(map[string]string{DefaultDebugHeader: "false"}) || sampler.Sample(map[string]string{DefaultDebugHeader: ""}) {
+			t.Error("expected a debug header of false or nothing to be ignored")
+		}
+		if !NewSampler(0, "X-Trace-Bodies").Sample(map[string]string{"X-Trace-Bodies": "true"}) {
+			t.Error("expected the configured header")
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// DefaultDebugHeader is the request header that has a request's bodies
+// logged whatever the sample rate.
+const DefaultDebugHeader = "X-Debug-Log"
+
+// Sampler picks the requests whose request and response bodies are logged:
+// a rate of them, from 0 for none to 1 for all, and any that send the debug
+// header. Bodies cost the most to log and carry the most personal data, so
+// the rest are logged without them.
+type Sampler struct {
+	rate   float64
+	header string
+	roll   func() float64
+}
+
+func NewSampler(rate float64, header string) *Sampler {
+	if header == "" {
+		header = DefaultDebugHeader
+	}
+	return &Sampler{rate: rate, header: header, roll: rand.Float64}
+}
+
+// Sample reports whether the bodies of the request with headers are logged.
+// The debug header is honoured with any value but empty or false.
+func (s *Sampler) Sample(headers map[string]string) bool {
+	for key, value := range headers {
+		if strings.EqualFold(key, s.header) && value != "" && !strings.EqualFold(value, "false") {
+			return true
+		}
+	}
+	return s.rate >= 1 || (s.rate > 0 && s.roll() < s.rate)
+}
@@ -0,0 +1,157 @@
+// Package metrics emits CloudWatch metrics in the embedded metric format:
+// JSON objects written to the logs, which CloudWatch Logs turns into metrics
+// as the function's logs arrive, so no call to CloudWatch is made while
+// serving. Values are buffered and written by Flush, all the values of a
+// metric with the same dimensions on one line, which CloudWatch reads as a
+// distribution it can compute percentiles from.
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNamespace is the CloudWatch namespace metrics are emitted under.
+const DefaultNamespace = "LambdaInGoUser"
+
+// Outcomes metrics are recorded with, as the Outcome dimension.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// maxValues is the most values the embedded metric format takes for a
+// metric on one line.
+const maxValues = 100
+
+// Unit is the CloudWatch unit of a metric.
+type Unit string
+
+const (
+	UnitCount        Unit = "Count"
+	UnitMilliseconds Unit = "Milliseconds"
+)
+
+// Dimensions name what a metric was recorded for, such as the route.
+type Dimensions map[string]string
+
+// Config sets where metrics are written and the namespace they are emitted
+// under. Nothing is recorded while Writer is nil.
+type Config struct {
+	Namespace string
+	Writer    io.Writer
+}
+
+// series is the values recorded for one metric with the same dimensions.
+type series struct {
+	name       string
+	unit       Unit
+	dimensions Dimensions
+	values     []float64
+}
+
+var (
+	mu       sync.Mutex
+	config   Config
+	buffered = map[string]*series{}
+	order    []string
+	now      = time.Now
+)
+
+func Configure(c Config) {
+	if c.Namespace == "" {
+		c.Namespace = DefaultNamespace
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+	buffered, order = map[string]*series{}, nil
+}
+
+// Count records one occurrence of name.
+func Count(name string, dimensions Dimensions) {
+	Record(name, UnitCount, 1, dimensions)
+}
+
+// Duration records elapsed for name, in milliseconds.
+func Duration(name string, elapsed time.Duration, dimensions Dimensions) {
+	Record(name, UnitMilliseconds, float64(elapsed)/float64(time.Millisecond), dimensions)
+}
+
+// Record records value for name, in unit, with dimensions, to be written by
+// the next Flush.
+func Record(name string, unit Unit, value float64, dimensions Dimensions) {
+	mu.Lock()
+	defer mu.Unlock()
+	if config.Writer == nil {
+		return
+	}
+	key := seriesKey(name, dimensions)
+	s, ok := buffered[key]
+	if !ok {
+		s = &series{name: name, unit: unit, dimensions: dimensions}
+		buffered[key] = s
+		order = append(order, key)
+	}
+	s.values = append(s.values, value)
+}
+
+func seriesKey(name string, dimensions Dimensions) string {
+	parts := make([]string, 0, len(dimensions))
+	for dimension, value := range dimensions {
+		parts = append(parts, dimension+"="+value)
+	}
+	sort.Strings(parts)
+	return name + "\x00" + strings.Join(parts, "\x00")
+}
+
+// Flush writes the values recorded since it was last called, in the order
+// their metrics were first recorded. Lambda runs one invocation per
+// container at a time, so flushing at the end of each sends its metrics
+// with its logs.
+func Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range order {
+		s := buffered[key]
+		for start := 0; start < len(s.values); start += maxValues {
+			end := start + maxValues
+			if end > len(s.values) {
+				end = len(s.values)
+			}
+			write(s, s.values[start:end])
+		}
+	}
+	buffered, order = map[string]*series{}, nil
+}
+
+func write(s *series, values []float64) {
+	names := make([]string, 0, len(s.dimensions))
+	entry := map[string]interface{}{}
+	for dimension, v := range s.dimensions {
+		names = append(names, dimension)
+		entry[dimension] = v
+	}
+	sort.Strings(names)
+	entry[s.name] = values
+	if len(values) == 1 {
+		entry[s.name] = values[0]
+	}
+	entry["_aws"] = map[string]interface{}{
+		"Timestamp": now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  config.Namespace,
+			"Dimensions": [][]string{names},
+			"Metrics":    []map[string]string{{"Name": s.name, "Unit": string(s.unit)}},
+		}},
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	config.Writer.Write(append(line, '\n'))
+}
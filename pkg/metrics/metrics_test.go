@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	defer Configure(Config{})
+	now = func() time.Time { return time.UnixMilli(1700000000000) }
+	defer func() { now = time.Now }()
+
+	t.Run("expect metrics to be emitted in the embedded metric format", func(t *testing.T) {
+		var out bytes.Buffer
+		Configure(Config{Writer: &out})
+		Count("DeprecatedRequests", Dimensions{"Version": "v1", "Route": "/"})
+		if out.Len() != 0 {
+			t.Fatalf("Expected nothing to be written before a flush, got %q", out.String())
+		}
+		Flush()
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected one JSON line, got %q", out.String())
+		}
+		if entry["DeprecatedRequests"] != 1.0 || entry["Version"] != "v1" || entry["Route"] != "/" {
+			t.Errorf("Expected the value and dimensions as members, got %v", entry)
+		}
+		want := `{"CloudWatchMetrics":[{"Dimensions":[["Route","Version"]],"Metrics":[{"Name":"DeprecatedRequests","Unit":"Count"}],"Namespace":"LambdaInGoUser"}],"Timestamp":1700000000000}`
+		if got, _ := json.Marshal(entry["_aws"]); string(got) != want {
+			t.Errorf("Expected metadata %s, got %s", want, got)
+		}
+	})
+
+	t.Run("expect the values of a metric to be written together", func(t *testing.T) {
+		var out bytes.Buffer
+		Configure(Config{Writer: &out})
+		for n := 0; n < 150; n++ {
+			Duration("DynamoDBLatency", 12*time.Millisecond, Dimensions{"Operation": "GetItem", "Outcome": OutcomeSuccess})
+		}
+		Duration("DynamoDBLatency", 40*time.Millisecond, Dimensions{"Outcome": OutcomeError, "Operation": "GetItem"})
+		Flush()
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		if len(lines) != 3 {
+			t.Fatalf("Expected 100 and 50 successes and one error on 3 lines, got %d", len(lines))
+		}
+		var first struct {
+			DynamoDBLatency []float64
+		}
+		json.Unmarshal(lines[0], &first)
+		if len(first.DynamoDBLatency) != 100 || first.DynamoDBLatency[0] != 12 {
+			t.Errorf("Expected 100 values of 12ms, got %s", lines[0])
+		}
+		out.Reset()
+		Flush()
+		if out.Len() != 0 {
+			t.Errorf("Expected values to be written once, got %q", out.String())
+		}
+	})
+
+	t.Run("expect nothing to be recorded without a writer", func(t *testing.T) {
+		Configure(Config{})
+		Record("Latency", UnitMilliseconds, 12, nil)
+		Flush()
+	})
+}
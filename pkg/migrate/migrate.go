@@ -0,0 +1,239 @@
+package migrate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// itemID is the id of the item applied versions are tracked in.
+const itemID = "migrations"
+
+// lease is how long a run holds the migrations item, long enough for the
+// longest Lambda invocation.
+const lease = 15 * time.Minute
+
+var (
+	ErrorFailedToFetchMigrations = "failed to fetch applied migrations"
+	ErrorFailedToSaveMigration   = "failed to save applied migration"
+	ErrorFailedToScanUsers       = "failed to scan users"
+	ErrorMigrationFailed         = "migration failed"
+	ErrorMigrationInProgress     = "another migration run is in progress"
+)
+
+// Migration is a versioned change to the users table. A run that fails part
+// way through is retried from the start of the migration, so Up must be safe
+// to run again. It returns how many items it changed.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (int, error)
+}
+
+// Result is the outcome of running one migration.
+type Result struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Changed int    `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report lists the versions applied before a run and what the run did.
+type Report struct {
+	Applied []int    `json:"applied"`
+	Ran     []Result `json:"ran"`
+}
+
+// TableName returns the table migrations are tracked in for a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Migration"
+}
+
+// Applied returns the versions already applied to tableName, in order.
+func Applied(tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]int, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:            key(),
+		TableName:      aws.String(TableName(tableName)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchMigrations)
+	}
+	versions := []int{}
+	if value, ok := result.Item["versions"]; ok {
+		for _, n := range value.NS {
+			v, err := strconv.Atoi(aws.StringValue(n))
+			if err != nil {
+				return nil, errors.New(ErrorFailedToFetchMigrations)
+			}
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// Pending returns the migrations not yet applied to tableName, in version
+// order.
+func Pending(migrations []Migration, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Migration, error) {
+	applied, err := Applied(tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		done[v] = true
+	}
+	pending := []Migration{}
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+	return pending, nil
+}
+
+// Run applies the pending migrations in version order, recording each
+// version once it succeeds. It stops at the first migration to fail, so later
+// migrations can rely on earlier ones. Only one run at a time holds the
+// migrations item.
+func Run(migrations []Migration, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Report, error) {
+	owner, err := acquire(tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	defer release(owner, tableName, dynaClient)
+
+	applied, err := Applied(tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := Pending(migrations, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Applied: applied, Ran: []Result{}}
+	for _, m := range pending {
+		changed, err := m.Up(tableName, dynaClient)
+		result := Result{Version: m.Version, Name: m.Name, Changed: changed}
+		if err != nil {
+			result.Error = err.Error()
+			report.Ran = append(report.Ran, result)
+			return report, errors.New(ErrorMigrationFailed)
+		}
+		if err := record(m.Version, tableName, dynaClient); err != nil {
+			return report, err
+		}
+		report.Ran = append(report.Ran, result)
+	}
+	return report, nil
+}
+
+func record(version int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:              key(),
+		TableName:        aws.String(TableName(tableName)),
+		UpdateExpression: aws.String("ADD versions :version SET updatedAt = :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {NS: []*string{aws.String(strconv.Itoa(version))}},
+			":now":     {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return store.Error(err, ErrorFailedToSaveMigration)
+	}
+	return nil
+}
+
+// acquire takes a lease on the migrations item, failing while another run
+// holds one that has not expired, and returns the owner the lease is held
+// under.
+func acquire(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New(ErrorFailedToSaveMigration)
+	}
+	owner := hex.EncodeToString(b)
+	now := time.Now().UTC()
+	_, err := dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 key(),
+		TableName:           aws.String(TableName(tableName)),
+		UpdateExpression:    aws.String("SET lockedUntil = :until, lockedBy = :owner"),
+		ConditionExpression: aws.String("attribute_not_exists(lockedUntil) OR lockedUntil < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {S: aws.String(now.Add(lease).Format(time.RFC3339))},
+			":now":   {S: aws.String(now.Format(time.RFC3339))},
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return "", errors.New(ErrorMigrationInProgress)
+		}
+		return "", store.Error(err, ErrorFailedToSaveMigration)
+	}
+	return owner, nil
+}
+
+// release is best effort: an unreleased lease expires on its own. A run that
+// outlived its lease leaves alone the one another run has taken since.
+func release(owner string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	_, _ = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 key(),
+		TableName:           aws.String(TableName(tableName)),
+		UpdateExpression:    aws.String("REMOVE lockedUntil, lockedBy"),
+		ConditionExpression: aws.String("lockedBy = :owner"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+}
+
+func key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(itemID)},
+	}
+}
+
+// scanItems calls fn with every item in tableName that matches filter, with
+// the attributes named in projection.
+func scanItems(tableName string, filter string, projection string, dynaClient dynamodbiface.DynamoDBAPI, fn func(map[string]*dynamodb.AttributeValue) error) error {
+	store.RecordScan(tableName, store.ScanMigrate)
+	dynaClient = store.Background(dynaClient)
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(tableName),
+		ProjectionExpression: aws.String(projection),
+	}
+	if filter != "" {
+		input.FilterExpression = aws.String(filter)
+	}
+	for {
+		result, err := dynaClient.Scan(input)
+		if err != nil {
+			return store.Error(err, ErrorFailedToScanUsers)
+		}
+		for _, item := range result.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
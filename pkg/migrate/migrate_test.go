@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: TableName("test"), PartitionKey: "id"},
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: audit.TableName("test"), PartitionKey: "subject", SortKey: "timestamp"},
+	)
+}
+
+func userItem(client *testutil.MemoryDynamoDB, email string) map[string]*dynamodb.AttributeValue {
+	return client.Item("test", map[string]*dynamodb.AttributeValue{"email": {S: aws.String(email)}})
+}
+
+func counting(version int, ran *[]int, err error) Migration {
+	return Migration{Version: version, Name: "test", Up: func(string, dynamodbiface.DynamoDBAPI) (int, error) {
+		*ran = append(*ran, version)
+		return 1, err
+	}}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("expect pending migrations to run in version order and be recorded", func(t *testing.T) {
+		client := newClient()
+		ran := []int{}
+		report, err := Run([]Migration{counting(2, &ran, nil), counting(1, &ran, nil)}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(ran) != 2 || ran[0] != 1 || ran[1] != 2 || len(report.Ran) != 2 {
+			t.Errorf("Expected versions 1 then 2 to run, got %v", ran)
+		}
+		applied, _ := Applied("test", client)
+		if len(applied) != 2 {
+			t.Errorf("Expected 2 applied versions, got %v", applied)
+		}
+		if _, locked := client.Item(TableName("test"), key())["lockedUntil"]; locked {
+			t.Error("Expected the lease to be released")
+		}
+
+		ran = []int{}
+		report, _ = Run([]Migration{counting(1, &ran, nil), counting(2, &ran, nil), counting(3, &ran, nil)}, "test", client)
+		if len(ran) != 1 || ran[0] != 3 {
+			t.Errorf("Expected only version 3 to run, got %v", ran)
+		}
+		if len(report.Applied) != 2 {
+			t.Errorf("Expected the report to list 2 versions applied before the run, got %v", report.Applied)
+		}
+	})
+
+	t.Run("expect a run to stop at a failed migration without recording it", func(t *testing.T) {
+		client := newClient()
+		ran := []int{}
+		report, err := Run([]Migration{counting(1, &ran, errors.New("boom")), counting(2, &ran, nil)}, "test", client)
+		if err == nil || err.Error() != ErrorMigrationFailed {
+			t.Fatalf("Expected %q, got %v", ErrorMigrationFailed, err)
+		}
+		if len(ran) != 1 || report.Ran[0].Error != "boom" {
+			t.Errorf("Expected only the failed migration to run, got %v", report.Ran)
+		}
+		if applied, _ := Applied("test", client); len(applied) != 0 {
+			t.Errorf("Expected nothing to be applied, got %v", applied)
+		}
+	})
+
+	t.Run("expect a run to be refused while another holds the lease", func(t *testing.T) {
+		client := newClient()
+		client.Put(TableName("test"), map[string]*dynamodb.AttributeValue{
+			"id":          {S: aws.String(itemID)},
+			"lockedUntil": {S: aws.String("9999-01-01T00:00:00Z")},
+		})
+		if _, err := Run(Migrations, "test", client); err == nil || err.Error() != ErrorMigrationInProgress {
+			t.Errorf("Expected %q, got %v", ErrorMigrationInProgress, err)
+		}
+	})
+
+	t.Run("expect a run not to release a lease another run has taken since", func(t *testing.T) {
+		client := newClient()
+		owner, err := acquire("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		client.Item(TableName("test"), key())["lockedBy"] = &dynamodb.AttributeValue{S: aws.String("another run")}
+		release(owner, "test", client)
+		if _, locked := client.Item(TableName("test"), key())["lockedUntil"]; !locked {
+			t.Error("Expected the other run's lease to be kept")
+		}
+	})
+}
+
+func TestBackfillCreatedAt(t *testing.T) {
+	t.Run("expect createdAt to be taken from the created audit entry", func(t *testing.T) {
+		client := newClient()
+		for _, email := range []string{"alan.oliver@ecs.co.uk", "al@ecs.co.uk"} {
+			client.Put("test", map[string]*dynamodb.AttributeValue{"email": {S: aws.String(email)}})
+		}
+		for _, entry := range []audit.Entry{
+			{Subject: "alan.oliver@ecs.co.uk", Timestamp: "2020-01-01T00:00:00.123Z", Action: audit.ActionCreated},
+			{Subject: "alan.oliver@ecs.co.uk", Timestamp: "2021-01-01T00:00:00Z", Action: audit.ActionUpdated},
+		} {
+			av, _ := dynamodbattribute.MarshalMap(entry)
+			client.Put(audit.TableName("test"), av)
+		}
+		changed, err := BackfillCreatedAt("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if changed != 2 {
+			t.Errorf("Expected 2 users to change, got %d", changed)
+		}
+		if got := *userItem(client, "alan.oliver@ecs.co.uk")["createdAt"].S; got != "2020-01-01T00:00:00Z" {
+			t.Errorf("Expected createdAt from the created entry, got %s", got)
+		}
+		if userItem(client, "al@ecs.co.uk")["createdAt"] == nil {
+			t.Error("Expected a user with no history to be given a createdAt")
+		}
+		if changed, _ := BackfillCreatedAt("test", client); changed != 0 {
+			t.Errorf("Expected a second run to change nothing, got %d", changed)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorEmailConflicts        = "some emails differ only in case from an existing user and were not lowercased"
+	ErrorFailedToBackfillField = "failed to backfill field"
+)
+
+// Migrations are the migrations of the users table. Versions are never
+// reused or reordered once released.
+var Migrations = []Migration{
+	{Version: 1, Name: "backfill createdAt", Up: BackfillCreatedAt},
+	{Version: 2, Name: "lowercase emails", Up: LowercaseEmails},
+}
+
+// BackfillCreatedAt sets createdAt on users created before it was recorded,
+// from the user's created audit entry, or their earliest entry when that has
+// been lost. Users with no history at all are given the time of the
+// migration.
+func BackfillCreatedAt(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (int, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	changed := 0
+	err := scanItems(tableName, "attribute_not_exists(createdAt) AND attribute_not_exists(movedTo)", "email", dynaClient, func(item map[string]*dynamodb.AttributeValue) error {
+		email := aws.StringValue(item["email"].S)
+		entries, err := audit.FetchEntries(email, audit.TableName(tableName), dynaClient)
+		if err != nil {
+			return err
+		}
+		createdAt := now
+		if len(entries) > 0 {
+			createdAt = entries[0].Timestamp
+		}
+		for _, entry := range entries {
+			if entry.Action == audit.ActionCreated {
+				createdAt = entry.Timestamp
+				break
+			}
+		}
+		if t, err := time.Parse(time.RFC3339Nano, createdAt); err == nil {
+			createdAt = t.UTC().Format(time.RFC3339)
+		}
+		_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+			Key:                 map[string]*dynamodb.AttributeValue{"email": item["email"]},
+			TableName:           aws.String(tableName),
+			UpdateExpression:    aws.String("SET createdAt = :createdAt"),
+			ConditionExpression: aws.String("attribute_exists(email) AND attribute_not_exists(createdAt)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":createdAt": {S: aws.String(createdAt)},
+			},
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				// Deleted or given a createdAt since it was scanned
+				return nil
+			}
+			return store.Error(err, ErrorFailedToBackfillField)
+		}
+		changed++
+		return nil
+	})
+	return changed, err
+}
+
+// LowercaseEmails moves users stored under an email with capitals to its
+// lowercase form, which is how new users are stored. Users whose lowercase
+// email is already taken are left for an operator to merge, and fail the
+// migration so that it runs again once they have been.
+func LowercaseEmails(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (int, error) {
+	changed, conflicts := 0, 0
+	err := scanItems(tableName, "attribute_not_exists(movedTo)", "email", dynaClient, func(item map[string]*dynamodb.AttributeValue) error {
+		email := aws.StringValue(item["email"].S)
+		lower := strings.ToLower(email)
+		if lower == email {
+			return nil
+		}
+		_, err := user.MoveUser(email, lower, tableName, dynaClient)
+		switch {
+		case err == nil:
+			changed++
+		case err.Error() == user.ErrorUserAlreadyExists:
+			conflicts++
+		case err.Error() != user.ErrorUserNotFound:
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+	if conflicts > 0 {
+		return changed, errors.New(ErrorEmailConflicts)
+	}
+	return changed, nil
+}
@@ -0,0 +1,321 @@
+// Package oauth validates the OAuth2 access tokens clients present, such as
+// those an authorization server issues through the client credentials
+// grant, and the scopes they were granted. Tokens are verified locally
+// against the server's signing keys when it issues JWTs, or else sent to
+// its introspection endpoint.
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jwt"
+)
+
+// Scopes the API grants access by.
+const (
+	ScopeRead  = "users:read"
+	ScopeWrite = "users:write"
+)
+
+var (
+	ErrorFailedToValidateToken = "failed to validate access token"
+	ErrorInsufficientScope     = "insufficient scope"
+	ErrorInvalidToken          = "invalid or expired access token"
+	ErrorTokenRequired         = "access token required"
+)
+
+// HTTPClient calls the authorization server. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config sets the authorization server tokens are validated with. When
+// JWKSURL is set tokens are verified as JWTs signed with its keys, issued
+// by Issuer for Audience when they are set. Otherwise, when
+// IntrospectionURL is set, each token is introspected as RFC 7662
+// describes, authenticated as ClientID and ClientSecret. Keys and
+// introspected tokens are cached for CacheTTL, tokens no longer than they
+// remain valid, and each call to the server is given Timeout. Tokens are
+// not required while neither URL is set.
+type Config struct {
+	JWKSURL          string
+	IntrospectionURL string
+	Issuer           string
+	Audience         string
+	ClientID         string
+	ClientSecret     string
+	CacheTTL         time.Duration
+	Timeout          time.Duration
+	Client           HTTPClient
+}
+
+const (
+	DefaultCacheTTL = 5 * time.Minute
+	DefaultTimeout  = 3 * time.Second
+)
+
+var (
+	config   Config
+	verifier *jwt.Verifier
+)
+
+func Configure(c Config) {
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = DefaultCacheTTL
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	config = c
+	verifier = nil
+	if c.JWKSURL != "" {
+		verifier = jwt.NewVerifier(jwt.Config{
+			JWKSURL:  c.JWKSURL,
+			Issuer:   c.Issuer,
+			Audience: c.Audience,
+			CacheTTL: c.CacheTTL,
+			Timeout:  c.Timeout,
+			Client:   c.Client,
+		})
+	}
+	introspections.clear()
+}
+
+// Enabled reports whether requests must present an access token.
+func Enabled() bool {
+	return config.JWKSURL != "" || config.IntrospectionURL != ""
+}
+
+// Token is a validated access token: the client it was issued to, the
+// subject it was issued for, which for the client credentials grant is the
+// client itself, and the scopes it grants.
+type Token struct {
+	ClientID string
+	Subject  string
+	Scopes   []string
+}
+
+// HasScope reports whether the token grants scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns the token bearer, failing with ErrorInvalidToken when it
+// is not a valid access token, and ErrorFailedToValidateToken when the
+// authorization server cannot be reached.
+func Validate(bearer string) (*Token, error) {
+	if bearer == "" {
+		return nil, errors.New(ErrorTokenRequired)
+	}
+	if verifier != nil {
+		claims, err := verifier.Verify(bearer)
+		if err != nil {
+			if err.Error() == jwt.ErrorFailedToFetchKeys {
+				return nil, errors.New(ErrorFailedToValidateToken)
+			}
+			return nil, errors.New(ErrorInvalidToken)
+		}
+		return &Token{
+			ClientID: clientID(claims.String("client_id"), claims.String("azp"), claims.String("cid")),
+			Subject:  claims.String("sub"),
+			Scopes:   scopes(claims),
+		}, nil
+	}
+	if config.IntrospectionURL == "" {
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	return introspect(bearer)
+}
+
+// scopes reads the scopes a JWT grants from its space separated scope
+// claim, or the scp array some servers issue instead.
+func scopes(claims jwt.Claims) []string {
+	if scope := claims.String("scope"); scope != "" {
+		return strings.Fields(scope)
+	}
+	return claims.Strings("scp")
+}
+
+func clientID(candidates ...string) string {
+	for _, c := range candidates {
+		if c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// introspection is the part of an introspection response the API reads.
+type introspection struct {
+	Active   bool     `json:"active"`
+	Scope    string   `json:"scope"`
+	ClientID string   `json:"client_id"`
+	Subject  string   `json:"sub"`
+	Issuer   string   `json:"iss"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+}
+
+// audience is the aud member, which is a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// introspect asks the authorization server whether bearer is active,
+// caching its answer.
+func introspect(bearer string) (*Token, error) {
+	sum := sha256.Sum256([]byte(bearer))
+	id := hex.EncodeToString(sum[:])
+	if token, ok := introspections.get(id); ok {
+		if token == nil {
+			return nil, errors.New(ErrorInvalidToken)
+		}
+		return token, nil
+	}
+	result, err := postIntrospection(bearer)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(config.CacheTTL)
+	if !result.valid() {
+		introspections.store(id, nil, expires)
+		return nil, errors.New(ErrorInvalidToken)
+	}
+	if result.Expiry > 0 && time.Unix(result.Expiry, 0).Before(expires) {
+		expires = time.Unix(result.Expiry, 0)
+	}
+	token := &Token{ClientID: result.ClientID, Subject: result.Subject, Scopes: strings.Fields(result.Scope)}
+	introspections.store(id, token, expires)
+	return token, nil
+}
+
+// valid reports whether the token introspected is active, unexpired and,
+// when they are configured, issued by the issuer for the audience.
+func (i introspection) valid() bool {
+	if !i.Active || i.Expiry > 0 && !time.Now().Before(time.Unix(i.Expiry, 0)) {
+		return false
+	}
+	if config.Issuer != "" && i.Issuer != config.Issuer {
+		return false
+	}
+	if config.Audience != "" {
+		for _, a := range i.Audience {
+			if a == config.Audience {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func postIntrospection(bearer string) (*introspection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	form := url.Values{"token": {bearer}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.New(ErrorFailedToValidateToken)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+	resp, err := config.Client.Do(req)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToValidateToken)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(ErrorFailedToValidateToken)
+	}
+	var result introspection
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.New(ErrorFailedToValidateToken)
+	}
+	return &result, nil
+}
+
+// maxIntrospections bounds the tokens a container caches, as each client
+// may fetch a new one as often as it likes.
+const maxIntrospections = 10000
+
+// introspectionCache holds the answers of the introspection endpoint by
+// the hash of the token they are for: the token, or nil for one that is
+// not valid. Failed calls are not cached.
+type introspectionCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]introspectionEntry
+}
+
+type introspectionEntry struct {
+	token   *Token
+	expires time.Time
+}
+
+var introspections = &introspectionCache{now: time.Now, entries: map[string]introspectionEntry{}}
+
+func (c *introspectionCache) get(id string) (*Token, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || !c.now().Before(entry.expires) {
+		delete(c.entries, id)
+		return nil, false
+	}
+	return entry.token, true
+}
+
+func (c *introspectionCache) store(id string, token *Token, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxIntrospections {
+		now := c.now()
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxIntrospections {
+			c.entries = map[string]introspectionEntry{}
+		}
+	}
+	c.entries[id] = introspectionEntry{token: token, expires: expires}
+}
+
+func (c *introspectionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]introspectionEntry{}
+}
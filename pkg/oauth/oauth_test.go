@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// authorizationServer answers introspection requests from its tokens and
+// counts them.
+type authorizationServer struct {
+	tokens map[string]map[string]interface{}
+	calls  int
+}
+
+func (s *authorizationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.calls++
+	if id, secret, ok := r.BasicAuth(); !ok || id != "users-api" || secret != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	answer, ok := s.tokens[r.PostFormValue("token")]
+	if !ok {
+		answer = map[string]interface{}{"active": false}
+	}
+	json.NewEncoder(w).Encode(answer)
+}
+
+func TestValidate(t *testing.T) {
+	defer Configure(Config{})
+	server := &authorizationServer{tokens: map[string]map[string]interface{}{
+		"reader": {"active": true, "scope": "users:read", "client_id": "reporting", "iss": "https://auth.example.com", "aud": "users-api", "exp": time.Now().Add(time.Hour).Unix()},
+		"other":  {"active": true, "scope": "users:read users:write", "client_id": "crm", "iss": "https://auth.example.com", "aud": []string{"billing-api"}, "exp": time.Now().Add(time.Hour).Unix()},
+	}}
+	endpoint := httptest.NewServer(server)
+	defer endpoint.Close()
+	configure := func() {
+		Configure(Config{
+			IntrospectionURL: endpoint.URL,
+			Issuer:           "https://auth.example.com",
+			Audience:         "users-api",
+			ClientID:         "users-api",
+			ClientSecret:     "s3cret",
+		})
+	}
+
+	t.Run("expect introspected tokens to be validated and cached", func(t *testing.T) {
+		configure()
+		server.calls = 0
+		for n := 0; n < 2; n++ {
+			token, err := Validate("reader")
+			if err != nil || token.ClientID != "reporting" {
+				t.Fatalf("Expected the token to be valid, got %+v %v", token, err)
+			}
+			if !token.HasScope(ScopeRead) || token.HasScope(ScopeWrite) {
+				t.Errorf("Expected only %s to be granted, got %v", ScopeRead, token.Scopes)
+			}
+		}
+		if server.calls != 1 {
+			t.Errorf("Expected the token to be introspected once, got %d calls", server.calls)
+		}
+	})
+
+	t.Run("expect inactive tokens and tokens for others to be refused", func(t *testing.T) {
+		configure()
+		for _, bearer := range []string{"revoked", "other"} {
+			if _, err := Validate(bearer); err == nil || err.Error() != ErrorInvalidToken {
+				t.Errorf("Expected %q for %s, got %v", ErrorInvalidToken, bearer, err)
+			}
+		}
+		if _, err := Validate(""); err == nil || err.Error() != ErrorTokenRequired {
+			t.Errorf("Expected %q, got %v", ErrorTokenRequired, err)
+		}
+	})
+
+	t.Run("expect a failing authorization server to be reported and not cached", func(t *testing.T) {
+		Configure(Config{IntrospectionURL: endpoint.URL, ClientID: "users-api", ClientSecret: "wrong"})
+		server.calls = 0
+		for n := 0; n < 2; n++ {
+			if _, err := Validate("reader"); err == nil || err.Error() != ErrorFailedToValidateToken {
+				t.Errorf("Expected %q, got %v", ErrorFailedToValidateToken, err)
+			}
+		}
+		if server.calls != 2 {
+			t.Errorf("Expected each failure to be retried, got %d calls", server.calls)
+		}
+	})
+
+	t.Run("expect unreachable signing keys to be reported", func(t *testing.T) {
+		Configure(Config{JWKSURL: "http://127.0.0.1:1/jwks"})
+		if !Enabled() {
+			t.Error("Expected tokens to be required")
+		}
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k"}`))
+		if _, err := Validate(header + ".e30.c2ln"); err == nil || err.Error() != ErrorFailedToValidateToken {
+			t.Errorf("Expected %q, got %v", ErrorFailedToValidateToken, err)
+		}
+	})
+}
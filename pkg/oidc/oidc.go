@@ -0,0 +1,131 @@
+// Package oidc verifies the OpenID Connect identity tokens end users present
+// alongside a request, telling the API who the person making it is. Users
+// record the identity that created or last changed them, and an identity
+// may only change its own user.
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jwt"
+)
+
+// DefaultHeader is the request header identity tokens are read from.
+const DefaultHeader = "X-ID-Token"
+
+var (
+	ErrorFailedToVerifyIdentity = "failed to verify identity token"
+	ErrorInvalidIdentity        = "invalid or expired identity token"
+	ErrorNotOwnUser             = "identity may only change its own user"
+)
+
+// Config sets the OpenID provider identity tokens are verified with: tokens
+// must be signed with the keys published at JWKSURL, issued by Issuer and,
+// when ClientID is set, issued to it. They are read from Header. Keys are
+// cached for CacheTTL and each fetch of them is given Timeout. Identity
+// tokens are ignored while JWKSURL is not set.
+type Config struct {
+	JWKSURL  string
+	Issuer   string
+	ClientID string
+	Header   string
+	CacheTTL time.Duration
+	Timeout  time.Duration
+	Client   jwt.HTTPClient
+}
+
+var (
+	config   Config
+	verifier *jwt.Verifier
+)
+
+func Configure(c Config) {
+	if c.Header == "" {
+		c.Header = DefaultHeader
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	config = c
+	verifier = nil
+	if c.JWKSURL != "" {
+		verifier = jwt.NewVerifier(jwt.Config{
+			JWKSURL:  c.JWKSURL,
+			Issuer:   c.Issuer,
+			Audience: c.ClientID,
+			CacheTTL: c.CacheTTL,
+			Timeout:  c.Timeout,
+			Client:   c.Client,
+		})
+	}
+}
+
+// Enabled reports whether identity tokens are verified.
+func Enabled() bool {
+	return verifier != nil
+}
+
+// Header returns the request header identity tokens are read from.
+func Header() string {
+	if config.Header == "" {
+		return DefaultHeader
+	}
+	return config.Header
+}
+
+// Identity is who a verified identity token says the caller is: the
+// subject the provider knows them by, the provider itself, and their email
+// when the token carries one.
+type Identity struct {
+	Subject       string
+	Issuer        string
+	Email         string
+	EmailVerified bool
+}
+
+// Owns reports whether the identity is the user with email. Only an email
+// the provider has verified is trusted to name the caller's user.
+func (i *Identity) Owns(email string) bool {
+	return i.EmailVerified && i.Email != "" && strings.EqualFold(i.Email, email)
+}
+
+// Verify returns the identity token says the caller has, failing with
+// ErrorInvalidIdentity when it is not a valid identity token, and
+// ErrorFailedToVerifyIdentity when the provider's keys cannot be fetched.
+func Verify(token string) (*Identity, error) {
+	if verifier == nil {
+		return nil, errors.New(ErrorInvalidIdentity)
+	}
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		if err.Error() == jwt.ErrorFailedToFetchKeys {
+			return nil, errors.New(ErrorFailedToVerifyIdentity)
+		}
+		return nil, errors.New(ErrorInvalidIdentity)
+	}
+	identity := &Identity{
+		Subject:       claims.String("sub"),
+		Issuer:        claims.String("iss"),
+		Email:         claims.String("email"),
+		EmailVerified: verified(claims["email_verified"]),
+	}
+	if identity.Subject == "" || identity.Issuer == "" {
+		return nil, errors.New(ErrorInvalidIdentity)
+	}
+	return identity, nil
+}
+
+// verified reads the email_verified claim, which some providers issue as
+// the string "true" rather than a boolean.
+func verified(claim interface{}) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
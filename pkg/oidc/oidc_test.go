@@ -0,0 +1,122 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// provider publishes one RSA key and signs identity tokens with it.
+type provider struct {
+	key    *rsa.PrivateKey
+	server *httptest.Server
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "id",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *provider) sign(t *testing.T, overrides map[string]interface{}) string {
+	claims := map[string]interface{}{
+		"iss":            "https://id.example.com",
+		"aud":            "users-web",
+		"sub":            "248289761001",
+		"email":          "alan.oliver@ecs.co.uk",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		claims[name] = value
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "id"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + encode(signature)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestVerify(t *testing.T) {
+	defer Configure(Config{})
+	p := newProvider(t)
+	Configure(Config{JWKSURL: p.server.URL, Issuer: "https://id.example.com", ClientID: "users-web"})
+
+	t.Run("expect identity tokens from the provider to be verified", func(t *testing.T) {
+		identity, err := Verify(p.sign(t, nil))
+		if err != nil || identity.Subject != "248289761001" || identity.Issuer != "https://id.example.com" {
+			t.Fatalf("Expected the identity to be verified, got %+v %v", identity, err)
+		}
+		if !identity.Owns("Alan.Oliver@ecs.co.uk") || identity.Owns("someone@ecs.co.uk") {
+			t.Errorf("Expected the identity to own only its own user")
+		}
+	})
+
+	t.Run("expect unverified emails not to own a user", func(t *testing.T) {
+		for _, claim := range []interface{}{false, "false", nil} {
+			identity, err := Verify(p.sign(t, map[string]interface{}{"email_verified": claim}))
+			if err != nil || identity.Owns("alan.oliver@ecs.co.uk") {
+				t.Errorf("Expected email_verified %v not to own the user, got %+v %v", claim, identity, err)
+			}
+		}
+		identity, _ := Verify(p.sign(t, map[string]interface{}{"email_verified": "true"}))
+		if !identity.Owns("alan.oliver@ecs.co.uk") {
+			t.Error("Expected email_verified \"true\" to own the user")
+		}
+	})
+
+	t.Run("expect tokens for other clients or without a subject to be refused", func(t *testing.T) {
+		cases := map[string]map[string]interface{}{
+			"other client":    {"aud": "other-web"},
+			"other issuer":    {"iss": "https://evil.example.com"},
+			"without subject": {"sub": nil},
+		}
+		for name, overrides := range cases {
+			if _, err := Verify(p.sign(t, overrides)); err == nil || err.Error() != ErrorInvalidIdentity {
+				t.Errorf("Expected the %s token to be refused, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("expect unreachable keys to be reported", func(t *testing.T) {
+		Configure(Config{JWKSURL: "http://127.0.0.1:1/jwks"})
+		if _, err := Verify(p.sign(t, nil)); err == nil || err.Error() != ErrorFailedToVerifyIdentity {
+			t.Errorf("Expected %q, got %v", ErrorFailedToVerifyIdentity, err)
+		}
+	})
+
+	t.Run("expect identity tokens to be ignored while not configured", func(t *testing.T) {
+		Configure(Config{})
+		if Enabled() || Header() != DefaultHeader {
+			t.Errorf("Expected identity tokens to be ignored and read from %s", DefaultHeader)
+		}
+	})
+}
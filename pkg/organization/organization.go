@@ -0,0 +1,137 @@
+// Package organization stores organizations, such as the companies users
+// work for. They are stored through store.Repository alone: the Organization struct and its
+// KeySpec are all the plumbing it has, so it is the package to copy when
+// adding another entity.
+package organization
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToDeleteOrganization    = "failed to delete organization"
+	ErrorFailedToFetchOrganization     = "failed to fetch organization"
+	ErrorFailedToMarshalOrganization   = "failed to marshal organization"
+	ErrorFailedToSaveOrganization      = "failed to save organization"
+	ErrorFailedToUnmarshalOrganization = "failed to unmarshal organization"
+	ErrorInvalidOrganizationData       = "invalid organization data"
+	ErrorOrganizationNotFound          = "organization not found"
+)
+
+type Organization struct {
+	ID   string `json:"id"`
+	Name string `json:"name" validate:"required,max=100"`
+	// Domain is the email domain the organization's users sign in from
+	Domain    string `json:"domain,omitempty" validate:"max=253"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// TableName returns the organizations table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Organization"
+}
+
+var keys = store.KeySpec{PartitionKey: "id"}
+
+func organizations(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) *store.Repository[Organization] {
+	r := store.NewRepository(store.NewTable(TableName(userTableName)), keys, func(o Organization) store.Key {
+		return store.Key{Partition: o.ID}
+	}, dynaClient)
+	r.Errors = store.ItemErrors{Marshal: ErrorFailedToMarshalOrganization, Unmarshal: ErrorFailedToUnmarshalOrganization}
+	return r
+}
+
+func CreateOrganization(o Organization, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Organization, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveOrganization)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	o.ID = id
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	if err := organizations(userTableName, dynaClient).Create(o); err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveOrganization)
+	}
+	return &o, nil
+}
+
+func FetchOrganization(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Organization, error) {
+	o, err := organizations(userTableName, dynaClient).Get(store.Key{Partition: id})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchOrganization)
+	}
+	if o == nil {
+		return nil, errors.New(ErrorOrganizationNotFound)
+	}
+	return o, nil
+}
+
+func FetchAllOrganizations(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Organization, error) {
+	all, err := organizations(userTableName, dynaClient).List()
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchOrganization)
+	}
+	return all, nil
+}
+
+// UpdateOrganization replaces the name and domain of an existing
+// organization.
+func UpdateOrganization(o Organization, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Organization, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	existing, err := FetchOrganization(o.ID, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	o.CreatedAt = existing.CreatedAt
+	o.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := organizations(userTableName, dynaClient).Replace(o); err != nil {
+		if store.IsConditionFailed(err) {
+			return nil, errors.New(ErrorOrganizationNotFound)
+		}
+		return nil, store.Error(err, ErrorFailedToSaveOrganization)
+	}
+	return &o, nil
+}
+
+func DeleteOrganization(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if _, err := FetchOrganization(id, userTableName, dynaClient); err != nil {
+		return err
+	}
+	if err := organizations(userTableName, dynaClient).Delete(store.Key{Partition: id}); err != nil {
+		return store.Error(err, ErrorFailedToDeleteOrganization)
+	}
+	return nil
+}
+
+func (o *Organization) validate() error {
+	o.Name = strings.TrimSpace(o.Name)
+	o.Domain = strings.ToLower(strings.TrimSpace(o.Domain))
+	if errs := validators.ValidateStruct(o); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
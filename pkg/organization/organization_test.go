@@ -0,0 +1,86 @@
+package organization
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestOrganizations(t *testing.T) {
+	stored := &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"id":        {S: aws.String("ecs")},
+		"name":      {S: aws.String("ECS")},
+		"createdAt": {S: aws.String("2021-01-01T00:00:00Z")},
+		"updatedAt": {S: aws.String("2021-01-01T00:00:00Z")},
+	}}
+
+	t.Run("expect a created organization to be written once under a new id", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		created, err := CreateOrganization(Organization{Name: " ECS ", Domain: "ECS.co.uk"}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if created.ID == "" || created.Name != "ECS" || created.Domain != "ecs.co.uk" {
+			t.Errorf("Expected an id and a normalised name and domain, got %+v", *created)
+		}
+		input := client.PutItemInputs()[0]
+		if *input.TableName != "testOrganization" || *input.Item["id"].S != created.ID {
+			t.Errorf("Expected the organization in its own table, got %v", input.Item)
+		}
+		if *input.ConditionExpression != "attribute_not_exists(#id)" {
+			t.Errorf("Expected the write conditioned on a new id, got %s", *input.ConditionExpression)
+		}
+	})
+	t.Run("expect an organization without a name to be refused", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		_, err := CreateOrganization(Organization{Domain: "ecs.co.uk"}, "test", client)
+		if errs, ok := err.(validators.FieldErrors); !ok || errs[0].Field != "name" {
+			t.Fatalf("Expected the name to be required, got %v", err)
+		}
+		if client.Count("PutItem") != 0 {
+			t.Errorf("Expected nothing to be written, got %d writes", client.Count("PutItem"))
+		}
+	})
+	t.Run("expect a missing organization not to be found", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		if _, err := FetchOrganization("ecs", "test", client); err == nil || err.Error() != ErrorOrganizationNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorOrganizationNotFound, err)
+		}
+		if err := DeleteOrganization("ecs", "test", client); err == nil || err.Error() != ErrorOrganizationNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorOrganizationNotFound, err)
+		}
+		if client.Count("DeleteItem") != 0 {
+			t.Errorf("Expected nothing to be deleted, got %d deletes", client.Count("DeleteItem"))
+		}
+	})
+	t.Run("expect an update to keep when the organization was created", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{GetItemOutput: stored}
+		updated, err := UpdateOrganization(Organization{ID: "ecs", Name: "ECS Digital"}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if updated.Name != "ECS Digital" || updated.CreatedAt != "2021-01-01T00:00:00Z" || updated.UpdatedAt == updated.CreatedAt {
+			t.Errorf("Expected the new name and the original creation, got %+v", *updated)
+		}
+		if *client.PutItemInputs()[0].ConditionExpression != "attribute_exists(#id)" {
+			t.Errorf("Expected the write conditioned on the organization existing, got %s", *client.PutItemInputs()[0].ConditionExpression)
+		}
+	})
+	t.Run("expect every organization to be listed", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{stored.Item}}}
+		all, err := FetchAllOrganizations("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(all) != 1 || all[0].ID != "ecs" {
+			t.Errorf("Expected the stored organization, got %+v", all)
+		}
+		if client.ScanInputs()[0].FilterExpression != nil {
+			t.Errorf("Expected a table of its own not to be filtered, got %s", *client.ScanInputs()[0].FilterExpression)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Package outbox publishes what happened to users as reliably as it
+// happened. The message announcing a change is written to the outbox table
+// in the same transaction as the change itself, and a relay drains the
+// outbox to EventBridge or SNS, so no change goes unannounced and no message
+// announces a change that was not made.
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Events messages announce.
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+	EventUserMerged  = "user.merged"
+)
+
+// StatusPending is the status of every message in the outbox, as messages
+// are deleted once published. It keys StatusIndex.
+const StatusPending = "pending"
+
+// StatusIndex is the index of the outbox table keyed by the status of its
+// messages and then their IDs, which sort in the order they were created,
+// so the relay reads the oldest messages first without scanning the table.
+const StatusIndex = "status-index"
+
+// Config enables writing messages alongside user changes.
+type Config struct {
+	Enabled bool
+}
+
+var config Config
+
+func Configure(c Config) {
+	config = c
+}
+
+// Enabled reports whether user changes are written with their messages.
+func Enabled() bool {
+	return config.Enabled
+}
+
+// Message announces event about Subject, the user's email. Payload is the
+// JSON of what changed. IDs sort in the order messages were created.
+type Message struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	Subject   string `json:"subject"`
+	Payload   string `json:"payload"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// TableName returns the outbox table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Outbox"
+}
+
+// New builds the message announcing event about subject, with data as its
+// payload.
+func New(event string, subject string, data interface{}) (Message, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Message{}, err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Message{}, err
+	}
+	now := time.Now().UTC()
+	return Message{
+		ID:        now.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(b),
+		Event:     event,
+		Subject:   subject,
+		Payload:   string(payload),
+		Status:    StatusPending,
+		CreatedAt: now.Format(time.RFC3339Nano),
+	}, nil
+}
+
+// Put returns the write adding m to the outbox, for the transaction making
+// the change m announces.
+func Put(m Message, userTableName string) (*dynamodb.TransactWriteItem, error) {
+	av, err := dynamodbattribute.MarshalMap(m)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		Item:      av,
+		TableName: aws.String(TableName(userTableName)),
+	}}, nil
+}
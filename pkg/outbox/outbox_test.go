@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+// newClient returns a client keeping the outbox table in memory, holding
+// count messages.
+func newClient(t *testing.T, count int) *testutil.MemoryDynamoDB {
+	client := testutil.NewMemoryDynamoDB(testutil.Table{
+		Name:         TableName("test"),
+		PartitionKey: "id",
+		Indexes:      []testutil.Index{{Name: StatusIndex, PartitionKey: "status", SortKey: "id"}},
+	})
+	for i := 0; i < count; i++ {
+		m, err := New(EventUserCreated, fmt.Sprintf("user%d@ecs.co.uk", i), map[string]int{"n": i})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		put, _ := Put(m, "test")
+		client.Put(TableName("test"), put.Put.Item)
+	}
+	return client
+}
+
+// publisher keeps what it was sent, refusing batches once succeed have been
+// taken. A negative succeed takes every batch.
+type publisher struct {
+	succeed   int
+	published []Message
+}
+
+func (p *publisher) Publish(messages []Message) error {
+	if p.succeed == 0 {
+		return errors.New(ErrorFailedToPublish)
+	}
+	p.succeed--
+	p.published = append(p.published, messages...)
+	return nil
+}
+
+type eventBridgeClient struct {
+	eventbridgeiface.EventBridgeAPI
+	failed int64
+	inputs []*eventbridge.PutEventsInput
+}
+
+func (c *eventBridgeClient) PutEvents(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(c.failed)}, nil
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("expect every message to be published oldest first and removed", func(t *testing.T) {
+		client := newClient(t, 25)
+		p := &publisher{succeed: -1}
+		result, err := Drain("test", client, p, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if result.Published != 25 || result.Remaining || len(client.Items(TableName("test"))) != 0 {
+			t.Errorf("Expected all 25 messages published and removed, got %+v with %d left", *result, len(client.Items(TableName("test"))))
+		}
+		for i := 1; i < len(p.published); i++ {
+			if p.published[i-1].ID >= p.published[i].ID {
+				t.Fatalf("Expected messages in the order they were written")
+			}
+		}
+	})
+	t.Run("expect a failed batch and those after it to be kept", func(t *testing.T) {
+		client := newClient(t, 25)
+		p := &publisher{succeed: 1}
+		result, err := Drain("test", client, p, 0)
+		if err == nil || err.Error() != ErrorFailedToPublish {
+			t.Errorf("Expected error %s, got %v", ErrorFailedToPublish, err)
+		}
+		if result.Published != batchSize || !result.Remaining || len(client.Items(TableName("test"))) != 25-batchSize {
+			t.Errorf("Expected only the first batch published and removed, got %+v with %d left", *result, len(client.Items(TableName("test"))))
+		}
+	})
+	t.Run("expect at most max messages to be published", func(t *testing.T) {
+		client := newClient(t, 5)
+		result, _ := Drain("test", client, &publisher{succeed: -1}, 3)
+		if result.Published != 3 || !result.Remaining {
+			t.Errorf("Expected 3 published with more remaining, got %+v", *result)
+		}
+		if queries := client.QueryInputs(); len(queries) != 1 || aws.StringValue(queries[0].IndexName) != StatusIndex || aws.Int64Value(queries[0].Limit) != 4 {
+			t.Errorf("Expected one query of the status index for just one more than max, got %v", queries)
+		}
+	})
+	t.Run("expect max messages to leave none remaining when there are no more", func(t *testing.T) {
+		client := newClient(t, 3)
+		result, _ := Drain("test", client, &publisher{succeed: -1}, 3)
+		if result.Published != 3 || result.Remaining {
+			t.Errorf("Expected 3 published with none remaining, got %+v", *result)
+		}
+	})
+}
+
+func TestPublishers(t *testing.T) {
+	t.Run("expect a batch to be published with every publisher", func(t *testing.T) {
+		first, second := &publisher{succeed: -1}, &publisher{succeed: -1}
+		client := newClient(t, 3)
+		if _, err := Drain("test", client, Publishers{first, second}, 0); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(first.published) != 3 || len(second.published) != 3 {
+			t.Errorf("Expected both publishers to be sent 3 messages, got %d and %d", len(first.published), len(second.published))
+		}
+	})
+	t.Run("expect a batch that fails with any publisher to be kept", func(t *testing.T) {
+		client := newClient(t, 3)
+		result, err := Drain("test", client, Publishers{&publisher{succeed: -1}, &publisher{}}, 0)
+		if err == nil || err.Error() != ErrorFailedToPublish {
+			t.Errorf("Expected error %s, got %v", ErrorFailedToPublish, err)
+		}
+		if result.Published != 0 || len(client.Items(TableName("test"))) != 3 {
+			t.Errorf("Expected every message to be kept, got %+v with %d left", *result, len(client.Items(TableName("test"))))
+		}
+	})
+}
+
+func TestEventBridgePublisher(t *testing.T) {
+	m, _ := New(EventUserDeleted, "alan.oliver@ecs.co.uk", map[string]string{"email": "alan.oliver@ecs.co.uk"})
+	client := &eventBridgeClient{}
+	p := EventBridgePublisher{Client: client, Bus: "users", Source: "lambda-in-go.users"}
+	if err := p.Publish([]Message{m}); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	entry := client.inputs[0].Entries[0]
+	if *entry.DetailType != EventUserDeleted || *entry.EventBusName != "users" {
+		t.Errorf("Expected a %s event on the users bus, got %+v", EventUserDeleted, entry)
+	}
+
+	client.failed = 1
+	if err := p.Publish([]Message{m}); err == nil || err.Error() != ErrorFailedToPublish {
+		t.Errorf("Expected error %s, got %v", ErrorFailedToPublish, err)
+	}
+}
@@ -0,0 +1,181 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+var (
+	ErrorFailedToDeleteMessage = "failed to delete outbox message"
+	ErrorFailedToPublish       = "failed to publish outbox messages"
+	ErrorFailedToReadOutbox    = "failed to read outbox"
+)
+
+// batchSize is the most entries EventBridge takes in one PutEvents call.
+const batchSize = 10
+
+// Publisher sends messages on. A batch that fails, even in part, is sent
+// again whole by the next drain.
+type Publisher interface {
+	Publish(messages []Message) error
+}
+
+// EventBridgePublisher puts each message on Bus as an event from Source,
+// with the message's event as its detail type and the message as its detail.
+type EventBridgePublisher struct {
+	Client eventbridgeiface.EventBridgeAPI
+	Bus    string
+	Source string
+}
+
+func (p EventBridgePublisher) Publish(messages []Message) error {
+	entries := make([]*eventbridge.PutEventsRequestEntry, len(messages))
+	for i, m := range messages {
+		detail, err := json.Marshal(m)
+		if err != nil {
+			return errors.New(ErrorFailedToPublish)
+		}
+		entries[i] = &eventbridge.PutEventsRequestEntry{
+			EventBusName: aws.String(p.Bus),
+			Source:       aws.String(p.Source),
+			DetailType:   aws.String(m.Event),
+			Detail:       aws.String(string(detail)),
+		}
+	}
+	result, err := p.Client.PutEvents(&eventbridge.PutEventsInput{Entries: entries})
+	if err != nil || aws.Int64Value(result.FailedEntryCount) > 0 {
+		return errors.New(ErrorFailedToPublish)
+	}
+	return nil
+}
+
+// SNSPublisher publishes each message to TopicARN, with the message's event
+// as the "event" attribute subscriptions can filter on.
+type SNSPublisher struct {
+	Client   snsiface.SNSAPI
+	TopicARN string
+}
+
+func (p SNSPublisher) Publish(messages []Message) error {
+	for _, m := range messages {
+		body, err := json.Marshal(m)
+		if err != nil {
+			return errors.New(ErrorFailedToPublish)
+		}
+		_, err = p.Client.Publish(&sns.PublishInput{
+			TopicArn: aws.String(p.TopicARN),
+			Message:  aws.String(string(body)),
+			MessageAttributes: map[string]*sns.MessageAttributeValue{
+				"event": {DataType: aws.String("String"), StringValue: aws.String(m.Event)},
+			},
+		})
+		if err != nil {
+			return errors.New(ErrorFailedToPublish)
+		}
+	}
+	return nil
+}
+
+// Publishers publishes each batch with every one of its publishers in turn,
+// such as to EventBridge and then to webhooks. A batch that fails with any
+// of them is published again with all of them.
+type Publishers []Publisher
+
+func (p Publishers) Publish(messages []Message) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(messages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DrainResult reports a drain: how many messages were published, and
+// whether messages were left for the next drain.
+type DrainResult struct {
+	Published int  `json:"published"`
+	Remaining bool `json:"remaining"`
+}
+
+// Drain publishes up to max messages, oldest first, deleting each batch
+// once it has been published. Delivery is at least once: a message whose
+// delete failed is published again, so consumers should ignore message IDs
+// they have seen. Drain stops at the first batch that fails to publish, so
+// later messages are never published ahead of it.
+func Drain(userTableName string, dynaClient dynamodbiface.DynamoDBAPI, publisher Publisher, max int) (*DrainResult, error) {
+	messages, remaining, err := pending(userTableName, dynaClient, max)
+	if err != nil {
+		return nil, err
+	}
+	result := &DrainResult{Remaining: remaining}
+	for start := 0; start < len(messages); start += batchSize {
+		end := start + batchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		batch := messages[start:end]
+		if err := publisher.Publish(batch); err != nil {
+			result.Remaining = true
+			return result, err
+		}
+		result.Published += len(batch)
+		for _, m := range batch {
+			_, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+				Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(m.ID)}},
+				TableName: aws.String(TableName(userTableName)),
+			})
+			if err != nil {
+				return result, errors.New(ErrorFailedToDeleteMessage)
+			}
+		}
+	}
+	return result, nil
+}
+
+// pending reads up to max messages, oldest first, from StatusIndex,
+// reporting whether there were more. Zero reads them all. The index is read
+// eventually consistently, so a message written moments ago may wait for the
+// next drain, and one just deleted may be published again.
+func pending(userTableName string, dynaClient dynamodbiface.DynamoDBAPI, max int) ([]Message, bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(TableName(userTableName)),
+		IndexName:                aws.String(StatusIndex),
+		KeyConditionExpression:   aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending": {S: aws.String(StatusPending)},
+		},
+	}
+	messages := []Message{}
+	for {
+		if max > 0 {
+			// One more than is left tells whether any would remain
+			input.Limit = aws.Int64(int64(max - len(messages) + 1))
+		}
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, false, errors.New(ErrorFailedToReadOutbox)
+		}
+		page := []Message{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, false, errors.New(ErrorFailedToReadOutbox)
+		}
+		messages = append(messages, page...)
+		if max > 0 && len(messages) > max {
+			return messages[:max], true, nil
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return messages, false, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
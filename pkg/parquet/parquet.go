@@ -0,0 +1,189 @@
+// Package parquet writes Parquet files of text columns, enough for Athena and
+// Glue to query data landed in S3 without converting it first. Every column
+// is an optional UTF8 string, written as a single uncompressed page in a
+// single row group; values that are not text, such as a list, are written as
+// JSON for queries to read with json_extract.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrorWrongNumberOfValues = "row has the wrong number of values"
+
+const magic = "PAR1"
+
+// Values of the enums of the Parquet format the files are written with.
+const (
+	typeByteArray        = 6
+	repetitionOptional   = 1
+	convertedUTF8        = 0
+	encodingPlain        = 0
+	encodingRLE          = 3
+	codecUncompressed    = 0
+	pageData             = 0
+	formatVersion        = 1
+	definitionLevelWidth = 1
+)
+
+// createdBy names the writer in the footer of the files it writes.
+const createdBy = "aws-lambda-in-golang"
+
+// File holds the rows of one file until Bytes encodes them.
+type File struct {
+	columns []string
+	// values holds each column's values, nil where a row has none
+	values [][]*string
+	rows   int
+	size   int
+}
+
+// New returns an empty file with columns, in order.
+func New(columns ...string) *File {
+	return &File{columns: columns, values: make([][]*string, len(columns))}
+}
+
+// Add adds a row holding a value, or nil, for each column.
+func (f *File) Add(row []*string) error {
+	if len(row) != len(f.columns) {
+		return errors.New(ErrorWrongNumberOfValues)
+	}
+	for i, value := range row {
+		f.values[i] = append(f.values[i], value)
+		if value != nil {
+			f.size += 4 + len(*value)
+		}
+	}
+	f.rows++
+	return nil
+}
+
+// Rows returns the number of rows added.
+func (f *File) Rows() int {
+	return f.rows
+}
+
+// Size returns the size of the values added, which the file is a little
+// larger than once encoded.
+func (f *File) Size() int {
+	return f.size
+}
+
+// chunk is where a column's page was written in the file.
+type chunk struct {
+	offset int64
+	size   int64
+}
+
+// Bytes returns the file encoded.
+func (f *File) Bytes() []byte {
+	var out bytes.Buffer
+	out.WriteString(magic)
+	chunks := make([]chunk, len(f.columns))
+	for i := range f.columns {
+		page := f.page(i)
+		header := newCompact()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(f.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+		chunks[i] = chunk{offset: int64(out.Len()), size: int64(header.buf.Len() + len(page))}
+		out.Write(header.buf.Bytes())
+		out.Write(page)
+	}
+	footer := f.footer(chunks)
+	out.Write(footer)
+	binary.Write(&out, binary.LittleEndian, uint32(len(footer)))
+	out.WriteString(magic)
+	return out.Bytes()
+}
+
+// page encodes column i as a data page: the definition level of each row,
+// 1 where it has a value and 0 where it has none, followed by the values.
+func (f *File) page(i int) []byte {
+	levels := levels(f.values[i])
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+	page.Write(levels)
+	for _, value := range f.values[i] {
+		if value == nil {
+			continue
+		}
+		binary.Write(&page, binary.LittleEndian, uint32(len(*value)))
+		page.WriteString(*value)
+	}
+	return page.Bytes()
+}
+
+// levels encodes the definition levels of values as a single bit-packed run
+// of the RLE hybrid encoding, padded to a whole number of groups of eight.
+func levels(values []*string) []byte {
+	groups := (len(values) + 7) / 8
+	var b [binary.MaxVarintLen64]byte
+	out := b[:binary.PutUvarint(b[:], uint64(groups)<<1|1)]
+	packed := make([]byte, groups*definitionLevelWidth)
+	for i, value := range values {
+		if value != nil {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append(out, packed...)
+}
+
+// footer encodes the file's metadata: its schema, of a root holding the
+// columns, and the one row group with a chunk for each column.
+func (f *File) footer(chunks []chunk) []byte {
+	c := newCompact()
+	c.i32(1, formatVersion)
+	c.list(2, typeStruct, len(f.columns)+1)
+	c.begin()
+	c.binary(4, "schema")
+	c.i32(5, int32(len(f.columns)))
+	c.end()
+	for _, column := range f.columns {
+		c.begin()
+		c.i32(1, typeByteArray)
+		c.i32(3, repetitionOptional)
+		c.binary(4, column)
+		c.i32(6, convertedUTF8)
+		c.end()
+	}
+	c.i64(3, int64(f.rows))
+	c.list(4, typeStruct, 1)
+	c.begin()
+	c.list(1, typeStruct, len(f.columns))
+	var total int64
+	for i, column := range f.columns {
+		total += chunks[i].size
+		c.begin()
+		c.i64(2, chunks[i].offset)
+		c.structField(3)
+		c.i32(1, typeByteArray)
+		c.list(2, typeI32, 2)
+		c.i32Element(encodingPlain)
+		c.i32Element(encodingRLE)
+		c.list(3, typeBinary, 1)
+		c.binaryElement(column)
+		c.i32(4, codecUncompressed)
+		c.i64(5, int64(f.rows))
+		c.i64(6, chunks[i].size)
+		c.i64(7, chunks[i].size)
+		c.i64(9, chunks[i].offset)
+		c.end()
+		c.end()
+	}
+	c.i64(2, total)
+	c.i64(3, int64(f.rows))
+	c.end()
+	c.binary(6, createdBy)
+	c.end()
+	return c.buf.Bytes()
+}
@@ -0,0 +1,105 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// decoder reads the Thrift compact protocol back into maps of field ids to
+// values, so the tests can check what a reader of the file would see.
+type decoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *decoder) varint() uint64 {
+	v, n := binary.Uvarint(d.b[d.pos:])
+	d.pos += n
+	return v
+}
+
+func (d *decoder) value(fieldType byte) interface{} {
+	switch fieldType {
+	case typeI32, typeI64:
+		v := d.varint()
+		return int64(v>>1) ^ -int64(v&1)
+	case typeBinary:
+		n := int(d.varint())
+		d.pos += n
+		return string(d.b[d.pos-n : d.pos])
+	case typeList:
+		header := d.b[d.pos]
+		d.pos++
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(d.varint())
+		}
+		list := []interface{}{}
+		for i := 0; i < n; i++ {
+			list = append(list, d.value(header&0x0f))
+		}
+		return list
+	case typeStruct:
+		fields := map[int16]interface{}{}
+		var last int16
+		for {
+			header := d.b[d.pos]
+			d.pos++
+			if header == 0 {
+				return fields
+			}
+			last += int16(header >> 4)
+			fields[last] = d.value(header & 0x0f)
+		}
+	}
+	panic("unexpected type")
+}
+
+func TestFile(t *testing.T) {
+	text := func(s string) *string { return &s }
+	f := New("email", "firstName")
+	f.Add([]*string{text("alan.oliver@ecs.co.uk"), text("Alan")})
+	f.Add([]*string{text("ada@ecs.co.uk"), nil})
+	out := f.Bytes()
+
+	t.Run("should begin and end with the magic number", func(t *testing.T) {
+		if !bytes.HasPrefix(out, []byte(magic)) || !bytes.HasSuffix(out, []byte(magic)) {
+			t.Errorf("expected PAR1 at both ends, got %q", out)
+		}
+	})
+	t.Run("should describe the columns and rows in the footer", func(t *testing.T) {
+		size := int(binary.LittleEndian.Uint32(out[len(out)-8:]))
+		footer := (&decoder{b: out[len(out)-8-size : len(out)-8]}).value(typeStruct).(map[int16]interface{})
+		schema := footer[2].([]interface{})
+		if len(schema) != 3 || schema[0].(map[int16]interface{})[5] != int64(2) || schema[2].(map[int16]interface{})[4] != "firstName" {
+			t.Errorf("expected a root holding both columns, got %v", schema)
+		}
+		if footer[3] != int64(2) {
+			t.Errorf("expected 2 rows, got %v", footer[3])
+		}
+		chunks := footer[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+		meta := chunks[1].(map[int16]interface{})[3].(map[int16]interface{})
+		offset := meta[9].(int64)
+		d := &decoder{b: out, pos: int(offset)}
+		header := d.value(typeStruct).(map[int16]interface{})
+		if header[5].(map[int16]interface{})[1] != int64(2) {
+			t.Errorf("expected a page of 2 values, got %v", header)
+		}
+		if int64(d.pos)+header[3].(int64)-offset != meta[7].(int64) {
+			t.Errorf("expected the chunk to be the header and the page, got %v", meta)
+		}
+	})
+	t.Run("should leave out the values a row does not have", func(t *testing.T) {
+		page := f.page(1)
+		// a length, the run's header, one group of levels, then one value
+		if page[4] != 0x03 || page[5] != 0x01 || string(page[10:]) != "Alan" {
+			t.Errorf("expected only the first row to have a value, got %v", page)
+		}
+	})
+	t.Run("should refuse a row with a value missing", func(t *testing.T) {
+		if err := f.Add([]*string{nil}); err == nil || err.Error() != ErrorWrongNumberOfValues {
+			t.Errorf("expected error %s, got %v", ErrorWrongNumberOfValues, err)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the fields of Thrift's compact protocol, which Parquet encodes
+// its page headers and footer with.
+const (
+	typeI32    = 5
+	typeI64    = 6
+	typeBinary = 8
+	typeList   = 9
+	typeStruct = 12
+)
+
+// compact writes a Thrift struct in the compact protocol. Fields must be
+// written in order of their ids, and a nested struct ends with end.
+type compact struct {
+	buf bytes.Buffer
+	// last holds the id of the field last written in each open struct, as
+	// field ids are written as the delta from it
+	last []int16
+}
+
+func newCompact() *compact {
+	return &compact{last: []int16{0}}
+}
+
+func (c *compact) field(id int16, fieldType byte) {
+	last := c.last[len(c.last)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		c.buf.WriteByte(fieldType)
+		c.varint(uint64(zigzag(int64(id))))
+	}
+	c.last[len(c.last)-1] = id
+}
+
+func (c *compact) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	c.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, typeI32)
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, typeI64)
+	c.varint(zigzag(v))
+}
+
+func (c *compact) binary(id int16, v string) {
+	c.field(id, typeBinary)
+	c.varint(uint64(len(v)))
+	c.buf.WriteString(v)
+}
+
+// list starts a list of n elements of elemType, whose elements are written
+// without field headers.
+func (c *compact) list(id int16, elemType byte, n int) {
+	c.field(id, typeList)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	c.buf.WriteByte(0xf0 | elemType)
+	c.varint(uint64(n))
+}
+
+// structField starts a struct held in field id.
+func (c *compact) structField(id int16) {
+	c.field(id, typeStruct)
+	c.begin()
+}
+
+// begin starts a struct, such as an element of a list.
+func (c *compact) begin() {
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) end() {
+	c.buf.WriteByte(0)
+	c.last = c.last[:len(c.last)-1]
+}
+
+func (c *compact) i32Element(v int32) {
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) binaryElement(v string) {
+	c.varint(uint64(len(v)))
+	c.buf.WriteString(v)
+}
@@ -0,0 +1,348 @@
+// Package projection keeps read models of the users table up to date from
+// its stream of changes, so questions such as how many users each email
+// domain has, or who signed up most recently, are answered by reading one or
+// a few items rather than scanning every user.
+package projection
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Every read model shares a table. Domain counts are stored one item per
+// domain under domainsKey, the recently created users as a single item under
+// recentKey, and each stream record once applied under appliedKey, so a
+// record delivered twice is only counted once.
+const (
+	domainsKey = "DOMAINS"
+	recentKey  = "RECENT"
+	createdKey = "CREATED"
+	appliedKey = "APPLIED"
+)
+
+// DefaultRecentSize is how many recently created users are kept when Config
+// leaves it unset.
+const DefaultRecentSize = 50
+
+// appliedTTL is how long applied records are remembered: longer than the
+// 24 hours a stream keeps them.
+const appliedTTL = 48 * time.Hour
+
+// applyAttempts bounds how often a change is retried after another changed
+// the recently created list first.
+const applyAttempts = 5
+
+var (
+	ErrorFailedToApplyChange     = "failed to apply user change to projections"
+	ErrorFailedToFetchProjection = "failed to fetch projection"
+	ErrorFailedToRebuild         = "failed to rebuild projections"
+)
+
+// Config sets how many recently created users are kept.
+type Config struct {
+	RecentSize int
+}
+
+var config = Config{RecentSize: DefaultRecentSize}
+
+func Configure(c Config) {
+	if c.RecentSize <= 0 {
+		c.RecentSize = DefaultRecentSize
+	}
+	config = c
+}
+
+// TableName returns the projections table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Projection"
+}
+
+// DomainCount is the number of users whose email is at Domain.
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Users  int64  `json:"users"`
+}
+
+// RecentUser is a user on the recently created list.
+type RecentUser struct {
+	Email     string `json:"email"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type domainItem struct {
+	PK    string `json:"pk"`
+	SK    string `json:"sk"`
+	Users int64  `json:"users"`
+}
+
+type recentItem struct {
+	PK      string       `json:"pk"`
+	SK      string       `json:"sk"`
+	Users   []RecentUser `json:"users"`
+	Version int64        `json:"version"`
+}
+
+// Image is what projections need of a stored user.
+type Image struct {
+	Email     string
+	CreatedAt string
+	MovedTo   string
+}
+
+// live reports whether the image is a user, rather than nothing or the
+// tombstone an email change leaves behind.
+func (i *Image) live() bool {
+	return i != nil && i.Email != "" && i.MovedTo == ""
+}
+
+// Change is one record of the users table's stream, with the item before
+// and after it. ID identifies the record, so it is applied only once.
+type Change struct {
+	ID  string
+	Old *Image
+	New *Image
+}
+
+// FromStreamRecord reads the change from a record of a stream that holds
+// both old and new images.
+func FromStreamRecord(record events.DynamoDBEventRecord) Change {
+	return Change{
+		ID:  record.EventID,
+		Old: imageOf(record.Change.OldImage),
+		New: imageOf(record.Change.NewImage),
+	}
+}
+
+func imageOf(item map[string]events.DynamoDBAttributeValue) *Image {
+	if len(item) == 0 {
+		return nil
+	}
+	text := func(name string) string {
+		if value, ok := item[name]; ok && value.DataType() == events.DataTypeString {
+			return value.String()
+		}
+		return ""
+	}
+	return &Image{Email: text("email"), CreatedAt: text("createdAt"), MovedTo: text("movedTo")}
+}
+
+// Apply brings the projections up to date with c. Only a user appearing or
+// disappearing changes them; edits to a user who stays are ignored.
+func Apply(c Change, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	wasLive, isLive := c.Old.live(), c.New.live()
+	if wasLive == isLive {
+		return nil
+	}
+	image, delta := c.Old, int64(-1)
+	if isLive {
+		image, delta = c.New, 1
+	}
+	table := aws.String(TableName(userTableName))
+	for attempt := 0; attempt < applyAttempts; attempt++ {
+		recent, err := fetchRecent(userTableName, dynaClient, true)
+		if err != nil {
+			return store.Error(err, ErrorFailedToApplyChange)
+		}
+		writes := []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{
+				Item: map[string]*dynamodb.AttributeValue{
+					"pk":  {S: aws.String(appliedKey)},
+					"sk":  {S: aws.String(c.ID)},
+					"ttl": {N: aws.String(strconv.FormatInt(time.Now().Add(appliedTTL).Unix(), 10))},
+				},
+				TableName:           table,
+				ConditionExpression: aws.String("attribute_not_exists(pk)"),
+			}},
+			{Update: &dynamodb.Update{
+				Key:                       key(domainsKey, domainOf(image.Email)),
+				TableName:                 table,
+				UpdateExpression:          aws.String("ADD #users :delta"),
+				ExpressionAttributeNames:  map[string]*string{"#users": aws.String("users")},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":delta": {N: aws.String(strconv.FormatInt(delta, 10))}},
+			}},
+		}
+		users := withoutUser(recent.Users, image.Email)
+		if isLive {
+			users = withUser(users, RecentUser{Email: image.Email, CreatedAt: image.CreatedAt})
+		}
+		if !sameUsers(users, recent.Users) {
+			put, err := recentPut(users, recent.Version, userTableName)
+			if err != nil {
+				return errors.New(ErrorFailedToApplyChange)
+			}
+			writes = append(writes, put)
+		}
+		_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: writes})
+		if err == nil {
+			return nil
+		}
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || len(canceled.CancellationReasons) < len(writes) {
+			return store.Error(err, ErrorFailedToApplyChange)
+		}
+		if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			// The record was applied before
+			return nil
+		}
+		if len(writes) < 3 || aws.StringValue(canceled.CancellationReasons[2].Code) != "ConditionalCheckFailed" {
+			return store.Error(err, ErrorFailedToApplyChange)
+		}
+		// Another change updated the list since it was read
+	}
+	return errors.New(ErrorFailedToApplyChange)
+}
+
+// Domains returns the number of users at each email domain that has any,
+// largest first.
+func Domains(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]DomainCount, error) {
+	items, err := domainItems(userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	counts := []DomainCount{}
+	for _, item := range items {
+		// A domain whose users have all gone is left at zero
+		if item.Users > 0 {
+			counts = append(counts, DomainCount{Domain: item.SK, Users: item.Users})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Users != counts[j].Users {
+			return counts[i].Users > counts[j].Users
+		}
+		return counts[i].Domain < counts[j].Domain
+	})
+	return counts, nil
+}
+
+func domainItems(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]domainItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(TableName(userTableName)),
+		KeyConditionExpression:    aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String(domainsKey)}},
+	}
+	items := []domainItem{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchProjection)
+		}
+		page := []domainItem{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToFetchProjection)
+		}
+		items = append(items, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Recent returns the most recently created users, newest first.
+func Recent(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]RecentUser, error) {
+	recent, err := fetchRecent(userTableName, dynaClient, false)
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchProjection)
+	}
+	return recent.Users, nil
+}
+
+func fetchRecent(userTableName string, dynaClient dynamodbiface.DynamoDBAPI, consistent bool) (*recentItem, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:            key(recentKey, createdKey),
+		TableName:      aws.String(TableName(userTableName)),
+		ConsistentRead: aws.Bool(consistent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	recent := &recentItem{Users: []RecentUser{}}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, recent); err != nil {
+		return nil, errors.New(ErrorFailedToFetchProjection)
+	}
+	if recent.Users == nil {
+		recent.Users = []RecentUser{}
+	}
+	return recent, nil
+}
+
+// recentPut returns the write replacing the list read at version with
+// users, provided no other write has replaced it since.
+func recentPut(users []RecentUser, version int64, userTableName string) (*dynamodb.TransactWriteItem, error) {
+	av, err := dynamodbattribute.MarshalMap(recentItem{PK: recentKey, SK: createdKey, Users: users, Version: version + 1})
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		Item:                      av,
+		TableName:                 aws.String(TableName(userTableName)),
+		ConditionExpression:       aws.String("attribute_not_exists(#version) OR #version = :version"),
+		ExpressionAttributeNames:  map[string]*string{"#version": aws.String("version")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.FormatInt(version, 10))}},
+	}}, nil
+}
+
+// withUser returns users with u added, newest first and no longer than the
+// configured size.
+func withUser(users []RecentUser, u RecentUser) []RecentUser {
+	users = append(append([]RecentUser{}, users...), u)
+	sortRecent(users)
+	if len(users) > config.RecentSize {
+		users = users[:config.RecentSize]
+	}
+	return users
+}
+
+func withoutUser(users []RecentUser, email string) []RecentUser {
+	kept := []RecentUser{}
+	for _, u := range users {
+		if u.Email != email {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+func sortRecent(users []RecentUser) {
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].CreatedAt != users[j].CreatedAt {
+			return users[i].CreatedAt > users[j].CreatedAt
+		}
+		return users[i].Email < users[j].Email
+	})
+}
+
+func sameUsers(a []RecentUser, b []RecentUser) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func domainOf(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+func key(pk string, sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(pk)},
+		"sk": {S: aws.String(sk)},
+	}
+}
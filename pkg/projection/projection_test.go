@@ -0,0 +1,131 @@
+package projection
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newClient returns a client keeping the users and projections tables in
+// memory.
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: TableName("test"), PartitionKey: "pk", SortKey: "sk"},
+	)
+}
+
+func created(id string, email string, createdAt string) Change {
+	return Change{ID: id, New: &Image{Email: email, CreatedAt: createdAt}}
+}
+
+func TestApply(t *testing.T) {
+	t.Run("expect users to be counted by domain and listed newest first", func(t *testing.T) {
+		client := newClient()
+		changes := []Change{
+			created("1", "alan.oliver@ecs.co.uk", "2023-01-01T00:00:00Z"),
+			created("2", "alan.shearer@ecs.co.uk", "2023-01-02T00:00:00Z"),
+			created("3", "alan@example.com", "2023-01-03T00:00:00Z"),
+			{ID: "4", Old: &Image{Email: "alan@example.com"}, New: &Image{Email: "alan@example.com", CreatedAt: "2023-01-03T00:00:00Z"}},
+		}
+		for _, c := range changes {
+			if err := Apply(c, "test", client); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+		}
+		counts, err := Domains("test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if fmt.Sprint(counts) != "[{ecs.co.uk 2} {example.com 1}]" {
+			t.Errorf("Expected 2 users at ecs.co.uk and 1 at example.com, got %v", counts)
+		}
+		recent, _ := Recent("test", client)
+		if len(recent) != 3 || recent[0].Email != "alan@example.com" || recent[2].Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected the three users newest first, got %v", recent)
+		}
+	})
+	t.Run("expect a deleted or moved user to be taken off", func(t *testing.T) {
+		client := newClient()
+		Apply(created("1", "alan.oliver@ecs.co.uk", "2023-01-01T00:00:00Z"), "test", client)
+		Apply(created("2", "alan@example.com", "2023-01-02T00:00:00Z"), "test", client)
+		Apply(Change{ID: "3", Old: &Image{Email: "alan.oliver@ecs.co.uk"}}, "test", client)
+		Apply(Change{ID: "4", Old: &Image{Email: "alan@example.com"}, New: &Image{Email: "alan@example.com", MovedTo: "alan@ecs.co.uk"}}, "test", client)
+
+		if counts, _ := Domains("test", client); len(counts) != 0 {
+			t.Errorf("Expected no domains with users, got %v", counts)
+		}
+		if recent, _ := Recent("test", client); len(recent) != 0 {
+			t.Errorf("Expected no recent users, got %v", recent)
+		}
+	})
+	t.Run("expect a record delivered twice to be applied once", func(t *testing.T) {
+		client := newClient()
+		c := created("1", "alan.oliver@ecs.co.uk", "2023-01-01T00:00:00Z")
+		Apply(c, "test", client)
+		if err := Apply(c, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if counts, _ := Domains("test", client); len(counts) != 1 || counts[0].Users != 1 {
+			t.Errorf("Expected the user counted once, got %v", counts)
+		}
+	})
+	t.Run("expect the list to keep only the newest users", func(t *testing.T) {
+		Configure(Config{RecentSize: 2})
+		defer Configure(Config{})
+		client := newClient()
+		for i := 1; i <= 3; i++ {
+			Apply(created(strconv.Itoa(i), fmt.Sprintf("user%d@ecs.co.uk", i), fmt.Sprintf("2023-01-0%dT00:00:00Z", i)), "test", client)
+		}
+		recent, _ := Recent("test", client)
+		if len(recent) != 2 || recent[0].Email != "user3@ecs.co.uk" || recent[1].Email != "user2@ecs.co.uk" {
+			t.Errorf("Expected the two newest users, got %v", recent)
+		}
+	})
+}
+
+func TestFromStreamRecord(t *testing.T) {
+	record := events.DynamoDBEventRecord{
+		EventID: "1",
+		Change: events.DynamoDBStreamRecord{
+			NewImage: map[string]events.DynamoDBAttributeValue{
+				"email":     events.NewStringAttribute("alan.oliver@ecs.co.uk"),
+				"createdAt": events.NewStringAttribute("2023-01-01T00:00:00Z"),
+				"version":   events.NewNumberAttribute("1"),
+			},
+		},
+	}
+	c := FromStreamRecord(record)
+	if c.Old != nil || c.New == nil || c.New.Email != "alan.oliver@ecs.co.uk" || c.New.CreatedAt != "2023-01-01T00:00:00Z" {
+		t.Errorf("Expected a created user, got %+v", c)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	client := newClient()
+	Apply(created("1", "gone@example.com", "2023-01-01T00:00:00Z"), "test", client)
+	client.Put("test",
+		map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}, "createdAt": {S: aws.String("2023-01-02T00:00:00Z")}},
+		map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.shearer@ecs.co.uk")}, "createdAt": {S: aws.String("2023-01-03T00:00:00Z")}},
+		map[string]*dynamodb.AttributeValue{"email": {S: aws.String("al@ecs.co.uk")}, "movedTo": {S: aws.String("alan.oliver@ecs.co.uk")}},
+	)
+	result, err := Rebuild("test", client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if result.Users != 2 || result.Domains != 1 {
+		t.Errorf("Expected 2 users at 1 domain, got %+v", *result)
+	}
+	if counts, _ := Domains("test", client); fmt.Sprint(counts) != "[{ecs.co.uk 2}]" {
+		t.Errorf("Expected only ecs.co.uk to have users, got %v", counts)
+	}
+	if recent, _ := Recent("test", client); len(recent) != 2 || recent[0].Email != "alan.shearer@ecs.co.uk" {
+		t.Errorf("Expected the two users newest first, got %v", recent)
+	}
+}
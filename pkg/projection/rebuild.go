@@ -0,0 +1,139 @@
+package projection
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// batchSize is the most writes BatchWriteItem takes at once.
+const batchSize = 25
+
+// batchAttempts bounds how often writes BatchWriteItem leaves unprocessed
+// are sent again, after batchRetryDelay doubled for each attempt.
+const (
+	batchAttempts   = 5
+	batchRetryDelay = 50 * time.Millisecond
+)
+
+// RebuildResult reports the users a rebuild counted and the domains they
+// were counted under.
+type RebuildResult struct {
+	Users   int `json:"users"`
+	Domains int `json:"domains"`
+}
+
+// Rebuild replaces the projections with ones computed from every user in
+// the table, for a table whose stream was not consumed from its start or
+// a list left short by deletions. Changes applied while it runs may be lost,
+// so the stream's consumer should be paused until it returns.
+func Rebuild(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*RebuildResult, error) {
+	counts := map[string]int64{}
+	users := []RecentUser{}
+	store.RecordScan(userTableName, store.ScanRebuild)
+	dynaClient = store.Background(dynaClient)
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(userTableName),
+		ProjectionExpression: aws.String("email, createdAt, movedTo"),
+		ConsistentRead:       aws.Bool(true),
+	}
+	result := &RebuildResult{}
+	for {
+		page, err := dynaClient.Scan(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToRebuild)
+		}
+		images := []struct {
+			Email     string `json:"email"`
+			CreatedAt string `json:"createdAt"`
+			MovedTo   string `json:"movedTo"`
+		}{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &images); err != nil {
+			return nil, errors.New(ErrorFailedToRebuild)
+		}
+		for _, i := range images {
+			image := Image{Email: i.Email, CreatedAt: i.CreatedAt, MovedTo: i.MovedTo}
+			if !image.live() {
+				continue
+			}
+			result.Users++
+			counts[domainOf(image.Email)]++
+			users = withUser(users, RecentUser{Email: image.Email, CreatedAt: image.CreatedAt})
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+	result.Domains = len(counts)
+
+	// Domains no user is at any more are set to zero rather than deleted,
+	// as the stream would have left them
+	existing, err := domainItems(userTableName, dynaClient)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToRebuild)
+	}
+	for _, item := range existing {
+		if _, ok := counts[item.SK]; !ok {
+			counts[item.SK] = 0
+		}
+	}
+	writes := []*dynamodb.WriteRequest{}
+	for domain, count := range counts {
+		av, err := dynamodbattribute.MarshalMap(domainItem{PK: domainsKey, SK: domain, Users: count})
+		if err != nil {
+			return nil, errors.New(ErrorFailedToRebuild)
+		}
+		writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+	}
+	if err := writeBatches(writes, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+
+	recent, err := fetchRecent(userTableName, dynaClient, true)
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToRebuild)
+	}
+	av, err := dynamodbattribute.MarshalMap(recentItem{PK: recentKey, SK: createdKey, Users: users, Version: recent.Version + 1})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToRebuild)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(TableName(userTableName))})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToRebuild)
+	}
+	return result, nil
+}
+
+// writeBatches makes writes to the projections table in batches, retrying
+// what is left unprocessed.
+func writeBatches(writes []*dynamodb.WriteRequest, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	table := TableName(userTableName)
+	for start := 0; start < len(writes); start += batchSize {
+		end := start + batchSize
+		if end > len(writes) {
+			end = len(writes)
+		}
+		request := map[string][]*dynamodb.WriteRequest{table: writes[start:end]}
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt == batchAttempts {
+				return errors.New(ErrorFailedToRebuild)
+			}
+			if attempt > 0 {
+				time.Sleep(batchRetryDelay << (attempt - 1))
+			}
+			output, err := dynaClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: request})
+			if err != nil {
+				return store.Error(err, ErrorFailedToRebuild)
+			}
+			request = output.UnprocessedItems
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package provision runs the steps of a Step Functions workflow onboarding a
+// user: validate, create, notify and verify. Each step is its own task state
+// so it can be retried on its own, and each takes the workflow's state and
+// returns it updated for the next. A step that fails returns a *StepError
+// whose Name is what the state machine's Retry and Catch clauses match.
+package provision
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+const (
+	StepValidate = "validate"
+	StepCreate   = "create"
+	StepNotify   = "notify"
+	StepVerify   = "verify"
+)
+
+// Steps are the workflow's steps in the order they run.
+var Steps = []string{StepValidate, StepCreate, StepNotify, StepVerify}
+
+// Error names, for the ErrorEquals of Retry and Catch clauses. Only
+// ErrorNameUnavailable and ErrorNameNotificationFailed are worth retrying.
+const (
+	ErrorNameInvalidUser        = "InvalidUser"
+	ErrorNameUserAlreadyExists  = "UserAlreadyExists"
+	ErrorNameUnavailable        = "DataStoreUnavailable"
+	ErrorNameNotificationFailed = "NotificationFailed"
+	ErrorNameVerificationFailed = "VerificationFailed"
+	ErrorNameUnknownStep        = "UnknownStep"
+	ErrorNameFailed             = "ProvisioningFailed"
+)
+
+var (
+	ErrorFailedToSendWelcomeEmail   = "failed to send welcome email"
+	ErrorUnknownStep                = "unknown provisioning step"
+	ErrorUserNotProvisioned         = "user was not provisioned"
+	ErrorWelcomeEmailNotConfigured  = "welcome emails are not configured"
+	ErrorProvisionedUserNotVerified = "provisioned user does not match the workflow"
+)
+
+// Config sets how the notify step emails a new user. Without a sender the
+// step fails with ErrorNameNotificationFailed.
+type Config struct {
+	SES    sesiface.SESAPI
+	Sender string
+}
+
+var notifications Config
+
+func Configure(config Config) {
+	notifications = config
+}
+
+// State is carried from step to step.
+type State struct {
+	User user.User `json:"user"`
+	// Notified is set once the welcome email has been sent
+	Notified bool `json:"notified,omitempty"`
+	// Verified is set once the user has been read back as created
+	Verified bool `json:"verified,omitempty"`
+}
+
+// Input is a task state's input: the step to run and the workflow's state,
+// e.g. {"step": "validate", "user": {...}}.
+type Input struct {
+	Step string `json:"step"`
+	State
+}
+
+// StepError is a step that failed, named for the state machine.
+type StepError struct {
+	Name    string
+	Message string
+	// Fields lists each invalid field when Name is ErrorNameInvalidUser
+	Fields validators.FieldErrors
+}
+
+func (e *StepError) Error() string {
+	return e.Message
+}
+
+// Run runs the input's step, returning the state for the next one.
+func Run(input Input, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*State, error) {
+	state := input.State
+	var err error
+	switch input.Step {
+	case StepValidate:
+		err = validate(&state)
+	case StepCreate:
+		err = create(&state, tableName, dynaClient)
+	case StepNotify:
+		err = notify(&state)
+	case StepVerify:
+		err = verify(&state, tableName, dynaClient)
+	default:
+		return nil, &StepError{Name: ErrorNameUnknownStep, Message: ErrorUnknownStep}
+	}
+	if err != nil {
+		return nil, stepError(err)
+	}
+	return &state, nil
+}
+
+func validate(state *State) error {
+	u, err := user.Validate(state.User)
+	if err != nil {
+		return err
+	}
+	state.User = *u
+	return nil
+}
+
+// create saves the user. A retry after a create that succeeded but was not
+// answered fails with ErrorNameUserAlreadyExists, which a Catch can send on
+// to verify.
+func create(state *State, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	u, err := user.Create(state.User, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	state.User = *u
+	return nil
+}
+
+func notify(state *State) error {
+	if notifications.SES == nil || notifications.Sender == "" {
+		return errors.New(ErrorWelcomeEmailNotConfigured)
+	}
+	name := state.User.FirstName
+	if name == "" {
+		name = "there"
+	}
+	body := strings.Join([]string{
+		"Hello " + name + ",",
+		"",
+		"An account has been set up for you with this email address.",
+	}, "\n")
+	_, err := notifications.SES.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(notifications.Sender),
+		Destination: &ses.Destination{ToAddresses: aws.StringSlice([]string{state.User.Email})},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String("Welcome")},
+			Body:    &ses.Body{Text: &ses.Content{Data: aws.String(body)}},
+		},
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToSendWelcomeEmail)
+	}
+	state.Notified = true
+	return nil
+}
+
+// verify reads the user back, consistently, and checks it is the one the
+// workflow created rather than one created before it.
+func verify(state *State, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	email := strings.ToLower(state.User.Email)
+	u, err := user.FetchUserWithOptions(email, tableName, dynaClient, user.FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return err
+	}
+	if u.Email == "" {
+		return errors.New(ErrorUserNotProvisioned)
+	}
+	if state.User.CreatedAt != "" && u.CreatedAt != state.User.CreatedAt {
+		return errors.New(ErrorProvisionedUserNotVerified)
+	}
+	state.User = *u
+	state.Verified = true
+	return nil
+}
+
+// stepError names err for the state machine.
+func stepError(err error) *StepError {
+	var fieldErrs validators.FieldErrors
+	if errors.As(err, &fieldErrs) {
+		return &StepError{Name: ErrorNameInvalidUser, Message: err.Error(), Fields: fieldErrs}
+	}
+	name := ErrorNameFailed
+	switch err.Error() {
+	case user.ErrorDisposableEmail, user.ErrorInvalidEmail, user.ErrorUndeliverableEmail:
+		name = ErrorNameInvalidUser
+	case user.ErrorUserAlreadyExists:
+		name = ErrorNameUserAlreadyExists
+	case store.ErrorTimeout, store.ErrorUnavailable:
+		name = ErrorNameUnavailable
+	case ErrorFailedToSendWelcomeEmail, ErrorWelcomeEmailNotConfigured:
+		name = ErrorNameNotificationFailed
+	case ErrorUserNotProvisioned, ErrorProvisionedUserNotVerified:
+		name = ErrorNameVerificationFailed
+	}
+	return &StepError{Name: name, Message: err.Error()}
+}
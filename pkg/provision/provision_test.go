@@ -0,0 +1,105 @@
+package provision
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+type mockSES struct {
+	sesiface.SESAPI
+	sent []*ses.SendEmailInput
+	err  error
+}
+
+func (m *mockSES) SendEmail(input *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+	m.sent = append(m.sent, input)
+	return &ses.SendEmailOutput{}, m.err
+}
+
+// newTable keeps the users table's one user in memory. Writes to any other
+// table, such as audit entries, are dropped.
+func newTable() *testutil.MockDynamoDB {
+	var stored map[string]*dynamodb.AttributeValue
+	return &testutil.MockDynamoDB{
+		GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if *input.TableName != "test" {
+				return &dynamodb.GetItemOutput{}, nil
+			}
+			return &dynamodb.GetItemOutput{Item: stored}, nil
+		},
+		PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if *input.TableName == "test" {
+				stored = input.Item
+			}
+			return &dynamodb.PutItemOutput{}, nil
+		},
+	}
+}
+
+func stepErrorName(err error) string {
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) {
+		return ""
+	}
+	return stepErr.Name
+}
+
+func TestRun(t *testing.T) {
+	t.Run("expect each step to hand the next its state", func(t *testing.T) {
+		sender := &mockSES{}
+		Configure(Config{SES: sender, Sender: "welcome@ecs.co.uk"})
+		defer Configure(Config{})
+		table := newTable()
+		state := State{User: user.User{Email: "Alan.Oliver@ecs.co.uk", FirstName: " Alan ", LastName: "Oliver"}}
+		for _, step := range Steps {
+			next, err := Run(Input{Step: step, State: state}, "test", table)
+			if err != nil {
+				t.Fatalf("Expected %s to succeed, got %s", step, err.Error())
+			}
+			state = *next
+		}
+		if state.User.Email != "alan.oliver@ecs.co.uk" || state.User.FirstName != "Alan" || state.User.CreatedAt == "" {
+			t.Errorf("Expected the created user, got %+v", state.User)
+		}
+		if !state.Notified || !state.Verified || len(sender.sent) != 1 {
+			t.Errorf("Expected the user notified once and verified, got %+v after %d emails", state, len(sender.sent))
+		}
+	})
+	t.Run("expect failures to be named for the state machine", func(t *testing.T) {
+		valid := State{User: user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver"}}
+		unavailable := &testutil.MockDynamoDB{GetItemErr: errors.New(store.ErrorUnavailable)}
+		cases := []struct {
+			name  string
+			input Input
+			db    *testutil.MockDynamoDB
+			want  string
+		}{
+			{"invalid user", Input{Step: StepValidate, State: State{User: user.User{Email: "alan"}}}, newTable(), ErrorNameInvalidUser},
+			{"unavailable table", Input{Step: StepCreate, State: valid}, unavailable, ErrorNameUnavailable},
+			{"unconfigured emails", Input{Step: StepNotify, State: valid}, newTable(), ErrorNameNotificationFailed},
+			{"user never created", Input{Step: StepVerify, State: valid}, newTable(), ErrorNameVerificationFailed},
+			{"unknown step", Input{Step: "activate", State: valid}, newTable(), ErrorNameUnknownStep},
+		}
+		for _, c := range cases {
+			if _, err := Run(c.input, "test", c.db); stepErrorName(err) != c.want {
+				t.Errorf("Expected %s for %s, got %v", c.want, c.name, err)
+			}
+		}
+	})
+	t.Run("expect a user created twice to be named as existing", func(t *testing.T) {
+		table := newTable()
+		input := Input{Step: StepCreate, State: State{User: user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver"}}}
+		Run(input, "test", table)
+		if _, err := Run(input, "test", table); stepErrorName(err) != ErrorNameUserAlreadyExists {
+			t.Errorf("Expected %s, got %v", ErrorNameUserAlreadyExists, err)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+// Package quota caps the writes each caller may make in a window, so one
+// noisy integration cannot use up the table's capacity for everyone else.
+// Callers are counted by the API key they present or, without one, by the
+// email of the user they write. Counts are kept in DynamoDB, incremented
+// atomically and expired by TTL, so every container enforces the same
+// quota.
+package quota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// DefaultKeyHeader carries the API key callers identify themselves by,
+	// when no other header is configured.
+	DefaultKeyHeader = "X-API-Key"
+	// DefaultWindow is the window quotas are counted over when none is
+	// configured.
+	DefaultWindow = time.Minute
+)
+
+var (
+	ErrorFailedToCountWrite = "failed to count write"
+	ErrorQuotaExceeded      = "write quota exceeded"
+)
+
+// Config sets the writes each caller may make per Window: KeyWrites for
+// each API key API Gateway identified and EmailWrites for each user written
+// without one. Either being 0 leaves those callers uncounted. KeyHeader
+// names the header callers identify themselves by for flag rollouts; it is
+// not trusted for quotas.
+type Config struct {
+	KeyWrites   int
+	EmailWrites int
+	Window      time.Duration
+	KeyHeader   string
+}
+
+var config = Config{Window: DefaultWindow, KeyHeader: DefaultKeyHeader}
+
+func Configure(c Config) {
+	if c.Window <= 0 {
+		c.Window = DefaultWindow
+	}
+	if c.KeyHeader == "" {
+		c.KeyHeader = DefaultKeyHeader
+	}
+	config = c
+}
+
+// KeyHeader returns the header API keys are read from.
+func KeyHeader() string {
+	return config.KeyHeader
+}
+
+// TableName returns the quota table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Quota"
+}
+
+// Identity is a caller writes are counted against.
+type Identity struct {
+	id    string
+	limit int
+}
+
+// Key returns the identity of the API key key. Keys are only stored hashed.
+func Key(key string) Identity {
+	sum := sha256.Sum256([]byte(key))
+	return Identity{id: "key#" + hex.EncodeToString(sum[:]), limit: config.KeyWrites}
+}
+
+// Email returns the identity of the user email.
+func Email(email string) Identity {
+	return Identity{id: "email#" + strings.ToLower(strings.TrimSpace(email)), limit: config.EmailWrites}
+}
+
+// Limited reports whether writes by the identity are counted.
+func (i Identity) Limited() bool {
+	return i.id != "" && i.limit > 0
+}
+
+// Usage is what remains of an identity's quota in the current window.
+type Usage struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// Take counts a write by identity, failing with ErrorQuotaExceeded once the
+// identity has made its limit of writes in the current window. The usage
+// is returned either way; it is nil when the identity is not limited.
+func Take(identity Identity, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Usage, error) {
+	if !identity.Limited() {
+		return nil, nil
+	}
+	start := time.Now().Truncate(config.Window)
+	usage := &Usage{Limit: identity.limit, Reset: start.Add(config.Window)}
+	// Counts are kept for a window after their own, as DynamoDB removes
+	// expired items some time after they expire
+	ttl := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(usage.Reset.Add(config.Window).Unix(), 10))}
+	update := store.NewUpdate().
+		Increment("count", 1).
+		SetIfNotExists("ttl", ttl).
+		Condition("attribute_not_exists(#count) OR #count < :limit", map[string]*dynamodb.AttributeValue{
+			":limit": {N: aws.String(strconv.Itoa(identity.limit))},
+		})
+	input := update.Input(map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(identity.id + "#" + strconv.FormatInt(start.Unix(), 10))},
+	}, TableName(userTableName))
+	input.ReturnValues = aws.String(dynamodb.ReturnValueUpdatedNew)
+	result, err := dynaClient.UpdateItem(input)
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return usage, errors.New(ErrorQuotaExceeded)
+		}
+		return nil, store.Error(err, ErrorFailedToCountWrite)
+	}
+	var count int
+	if attribute, ok := result.Attributes["count"]; ok {
+		count, _ = strconv.Atoi(aws.StringValue(attribute.N))
+	}
+	if usage.Remaining = identity.limit - count; usage.Remaining < 0 {
+		usage.Remaining = 0
+	}
+	return usage, nil
+}
@@ -0,0 +1,77 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(testutil.Table{Name: TableName("test"), PartitionKey: "id"})
+}
+
+func TestTake(t *testing.T) {
+	defer Configure(Config{})
+
+	t.Run("expect writes to be refused once the quota is used", func(t *testing.T) {
+		Configure(Config{EmailWrites: 2})
+		client := newClient()
+		for remaining := 1; remaining >= 0; remaining-- {
+			usage, err := Take(Email("Alan.Oliver@ecs.co.uk"), "test", client)
+			if err != nil || usage.Remaining != remaining || usage.Limit != 2 {
+				t.Fatalf("Expected %d writes to remain, got %+v %v", remaining, usage, err)
+			}
+		}
+		usage, err := Take(Email("alan.oliver@ecs.co.uk"), "test", client)
+		if err == nil || err.Error() != ErrorQuotaExceeded {
+			t.Errorf("Expected %q, got %v", ErrorQuotaExceeded, err)
+		}
+		if usage == nil || usage.Remaining != 0 || usage.Reset.IsZero() {
+			t.Errorf("Expected the usage with the refusal, got %+v", usage)
+		}
+		if _, err := Take(Email("someone.else@ecs.co.uk"), "test", client); err != nil {
+			t.Errorf("Expected other users to keep their quota, got %v", err)
+		}
+	})
+
+	t.Run("expect callers without a quota not to be counted", func(t *testing.T) {
+		Configure(Config{EmailWrites: 2})
+		client := newClient()
+		usage, err := Take(Key("integration"), "test", client)
+		if usage != nil || err != nil || client.Count("UpdateItem") != 0 {
+			t.Errorf("Expected API keys not to be counted, got %+v %v", usage, err)
+		}
+		if usage, err := Take(Identity{}, "test", client); usage != nil || err != nil {
+			t.Errorf("Expected no identity not to be counted, got %+v %v", usage, err)
+		}
+	})
+
+	t.Run("expect API keys to be stored hashed", func(t *testing.T) {
+		Configure(Config{KeyWrites: 10})
+		client := newClient()
+		Take(Key("secret-key"), "test", client)
+		for _, item := range client.Items(TableName("test")) {
+			if id := *item["id"].S; !strings.HasPrefix(id, "key#") || strings.Contains(id, "secret-key") {
+				t.Errorf("Expected the key to be hashed, got %q", id)
+			}
+		}
+	})
+
+	t.Run("expect throttling to be reported as such", func(t *testing.T) {
+		Configure(Config{KeyWrites: 10})
+		client := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil)}
+		if _, err := Take(Key("integration"), "test", client); err == nil || err.Error() != store.ErrorThrottled {
+			t.Errorf("Expected %q, got %v", store.ErrorThrottled, err)
+		}
+		client.UpdateItemErr = errors.New("boom")
+		if _, err := Take(Key("integration"), "test", client); err == nil || err.Error() != ErrorFailedToCountWrite {
+			t.Errorf("Expected %q, got %v", ErrorFailedToCountWrite, err)
+		}
+	})
+}
@@ -0,0 +1,289 @@
+// Package relation links users to one another, such as a user to their
+// manager, for org charts and similar. Relations are typed and directed, and
+// are only written between users that exist.
+package relation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// A relation is stored twice, under the partition key of each user it
+// links: under its user with the sort key linkPrefix followed by its type and
+// the other user's email, and under the other user with inversePrefix in
+// place of linkPrefix, so a single query reads every relation a user is on
+// either end of.
+const (
+	userPrefix    = "USER#"
+	linkPrefix    = "LINK#"
+	inversePrefix = "INVERSE#"
+)
+
+// TypeManager relates a user to their manager. A user has at most one, and
+// no user can be made their own manager through a chain of managers.
+const TypeManager = "manager"
+
+// maxChain bounds how far up a chain of managers a cycle is looked for.
+const maxChain = 100
+
+var (
+	ErrorFailedToFetchRelations    = "failed to fetch relations"
+	ErrorFailedToSaveRelation      = "failed to save relation"
+	ErrorFailedToUnmarshalRelation = "failed to unmarshal relation"
+	ErrorInvalidRelationData       = "invalid relation data"
+	ErrorInvalidRelationType       = "relation type must be 1 to 30 lowercase letters, digits or dashes"
+	ErrorRelationCycle             = "relation would make a user their own manager"
+	ErrorSelfRelation              = "a user cannot be related to themselves"
+)
+
+var validType = regexp.MustCompile(`^[a-z][a-z0-9-]{0,29}$`)
+
+// Relation records that the user at Target is the Type of the user at
+// Email, such as their manager.
+type Relation struct {
+	Email     string `json:"email"`
+	Type      string `json:"type"`
+	Target    string `json:"target"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type relationItem struct {
+	PK string `json:"pk"`
+	SK string `json:"sk"`
+	Relation
+}
+
+// TableName returns the relations table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Relation"
+}
+
+func linkKey(email string, relationType string, target string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(userPrefix + email)},
+		"sk": {S: aws.String(linkPrefix + relationType + "#" + target)},
+	}
+}
+
+func inverseKey(email string, relationType string, target string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(userPrefix + target)},
+		"sk": {S: aws.String(inversePrefix + relationType + "#" + email)},
+	}
+}
+
+// Relate makes target the relationType of email. Both users must exist when
+// it is written, which is checked in the same transaction. Relating a user
+// to a manager replaces the manager they had; relating them again otherwise
+// only updates when they were related.
+func Relate(email string, relationType string, target string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Relation, error) {
+	email, target = normaliseEmail(email), normaliseEmail(target)
+	if !validType.MatchString(relationType) {
+		return nil, errors.New(ErrorInvalidRelationType)
+	}
+	if email == "" || target == "" {
+		return nil, errors.New(user.ErrorEmailRequired)
+	}
+	if email == target {
+		return nil, errors.New(ErrorSelfRelation)
+	}
+
+	var replaced []Relation
+	if relationType == TypeManager {
+		if err := checkChain(email, target, userTableName, dynaClient); err != nil {
+			return nil, err
+		}
+		current, err := query(email, userTableName, dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range current {
+			if r.Email == email && r.Type == TypeManager && r.Target != target {
+				replaced = append(replaced, r)
+			}
+		}
+	}
+
+	r := Relation{Email: email, Type: relationType, Target: target, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	link, err := dynamodbattribute.MarshalMap(relationItem{PK: userPrefix + email, SK: linkPrefix + relationType + "#" + target, Relation: r})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveRelation)
+	}
+	inverse, err := dynamodbattribute.MarshalMap(relationItem{PK: userPrefix + target, SK: inversePrefix + relationType + "#" + email, Relation: r})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveRelation)
+	}
+	table := aws.String(TableName(userTableName))
+	writes := []*dynamodb.TransactWriteItem{
+		userExists(email, userTableName),
+		userExists(target, userTableName),
+		{Put: &dynamodb.Put{Item: link, TableName: table}},
+		{Put: &dynamodb.Put{Item: inverse, TableName: table}},
+	}
+	for _, old := range replaced {
+		writes = append(writes, deleteWrites(old, userTableName)...)
+	}
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	if err != nil {
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 {
+			if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" ||
+				aws.StringValue(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(user.ErrorUserNotFound)
+			}
+		}
+		return nil, store.Error(err, ErrorFailedToSaveRelation)
+	}
+	return &r, nil
+}
+
+// Unrelate removes target as the relationType of email. Removing a relation
+// that does not exist succeeds.
+func Unrelate(email string, relationType string, target string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	r := Relation{Email: normaliseEmail(email), Type: relationType, Target: normaliseEmail(target)}
+	_, err := dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: deleteWrites(r, userTableName)})
+	if err != nil {
+		return store.Error(err, ErrorFailedToSaveRelation)
+	}
+	return nil
+}
+
+// FetchRelations returns the relations of relationType, or of every type
+// when it is empty, that the user at email is on either end of: their own
+// first, then those of others to them, such as their reports. Relations with
+// a user who has since been deleted are left out.
+func FetchRelations(email string, relationType string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Relation, error) {
+	email = normaliseEmail(email)
+	if err := requireUser(email, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+	all, err := query(email, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	relations := []Relation{}
+	for _, r := range all {
+		if relationType != "" && r.Type != relationType {
+			continue
+		}
+		other := r.Target
+		if other == email {
+			other = r.Email
+		}
+		if _, ok := exists[other]; !ok {
+			u, err := user.FetchUser(other, userTableName, dynaClient)
+			if err != nil {
+				return nil, err
+			}
+			exists[other] = u.Email != ""
+		}
+		if exists[other] {
+			relations = append(relations, r)
+		}
+	}
+	return relations, nil
+}
+
+// checkChain refuses to make target the manager of email when email already
+// manages target, directly or through a chain of managers.
+func checkChain(email string, target string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	current := target
+	for i := 0; i < maxChain; i++ {
+		relations, err := query(current, userTableName, dynaClient)
+		if err != nil {
+			return err
+		}
+		manager := ""
+		for _, r := range relations {
+			if r.Email == current && r.Type == TypeManager {
+				manager = r.Target
+			}
+		}
+		if manager == "" {
+			return nil
+		}
+		if manager == email {
+			return errors.New(ErrorRelationCycle)
+		}
+		current = manager
+	}
+	return errors.New(ErrorRelationCycle)
+}
+
+// query reads every relation the user at email is on either end of, their
+// own before those of others to them.
+func query(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Relation, error) {
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(userPrefix + email)},
+		},
+		TableName: aws.String(TableName(userTableName)),
+	}
+	own, others := []Relation{}, []Relation{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchRelations)
+		}
+		page := []relationItem{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalRelation)
+		}
+		for _, item := range page {
+			if strings.HasPrefix(item.SK, linkPrefix) {
+				own = append(own, item.Relation)
+			} else {
+				others = append(others, item.Relation)
+			}
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return append(own, others...), nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func requireUser(email string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	u, err := user.FetchUser(email, userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if u.Email == "" {
+		return errors.New(user.ErrorUserNotFound)
+	}
+	return nil
+}
+
+// userExists is a transaction write that fails unless the user at email
+// exists and has not moved.
+func userExists(email string, userTableName string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{ConditionCheck: &dynamodb.ConditionCheck{
+		Key:                 map[string]*dynamodb.AttributeValue{"email": {S: aws.String(email)}},
+		TableName:           aws.String(userTableName),
+		ConditionExpression: aws.String("attribute_exists(email) AND attribute_not_exists(movedTo)"),
+	}}
+}
+
+func deleteWrites(r Relation, userTableName string) []*dynamodb.TransactWriteItem {
+	table := aws.String(TableName(userTableName))
+	return []*dynamodb.TransactWriteItem{
+		{Delete: &dynamodb.Delete{Key: linkKey(r.Email, r.Type, r.Target), TableName: table}},
+		{Delete: &dynamodb.Delete{Key: inverseKey(r.Email, r.Type, r.Target), TableName: table}},
+	}
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
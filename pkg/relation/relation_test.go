@@ -0,0 +1,116 @@
+package relation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newClient returns a client keeping the users and relations tables in
+// memory, with a user for each of emails.
+func newClient(emails ...string) *testutil.MemoryDynamoDB {
+	client := testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: TableName("test"), PartitionKey: "pk", SortKey: "sk"},
+	)
+	for _, email := range emails {
+		client.Put("test", map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String(email)},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		})
+	}
+	return client
+}
+
+func describe(relations []Relation) string {
+	described := []string{}
+	for _, r := range relations {
+		described = append(described, r.Email+" "+r.Type+" "+r.Target)
+	}
+	return strings.Join(described, ", ")
+}
+
+func TestRelations(t *testing.T) {
+	t.Run("expect a relation to be listed from both ends", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk", "boss@ecs.co.uk")
+		if _, err := Relate("Alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		relations, _ := FetchRelations("alan@ecs.co.uk", "", "test", client)
+		if describe(relations) != "alan@ecs.co.uk manager boss@ecs.co.uk" {
+			t.Errorf("Expected the user's manager, got %s", describe(relations))
+		}
+		reports, _ := FetchRelations("boss@ecs.co.uk", TypeManager, "test", client)
+		if describe(reports) != "alan@ecs.co.uk manager boss@ecs.co.uk" {
+			t.Errorf("Expected the manager's report, got %s", describe(reports))
+		}
+	})
+
+	t.Run("expect a new manager to replace the old one", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk", "boss@ecs.co.uk", "cto@ecs.co.uk")
+		Relate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client)
+		Relate("alan@ecs.co.uk", "mentor", "boss@ecs.co.uk", "test", client)
+		Relate("alan@ecs.co.uk", TypeManager, "cto@ecs.co.uk", "test", client)
+		relations, _ := FetchRelations("alan@ecs.co.uk", "", "test", client)
+		if describe(relations) != "alan@ecs.co.uk manager cto@ecs.co.uk, alan@ecs.co.uk mentor boss@ecs.co.uk" {
+			t.Errorf("Expected the new manager and the mentor, got %s", describe(relations))
+		}
+		if reports, _ := FetchRelations("boss@ecs.co.uk", TypeManager, "test", client); len(reports) != 0 {
+			t.Errorf("Expected the old manager to have no reports, got %s", describe(reports))
+		}
+	})
+
+	t.Run("expect a chain of managers not to loop", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk", "boss@ecs.co.uk", "cto@ecs.co.uk")
+		Relate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client)
+		Relate("boss@ecs.co.uk", TypeManager, "cto@ecs.co.uk", "test", client)
+		if _, err := Relate("cto@ecs.co.uk", TypeManager, "alan@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorRelationCycle {
+			t.Errorf("Expected %q, got %v", ErrorRelationCycle, err)
+		}
+		if _, err := Relate("cto@ecs.co.uk", "mentor", "alan@ecs.co.uk", "test", client); err != nil {
+			t.Errorf("Expected other relations to be free to loop, got %s", err.Error())
+		}
+	})
+
+	t.Run("expect relations only between existing users", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk")
+		if _, err := Relate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client); err == nil || err.Error() != user.ErrorUserNotFound {
+			t.Errorf("Expected %q, got %v", user.ErrorUserNotFound, err)
+		}
+		if _, err := Relate("alan@ecs.co.uk", TypeManager, "alan@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorSelfRelation {
+			t.Errorf("Expected %q, got %v", ErrorSelfRelation, err)
+		}
+		if _, err := Relate("alan@ecs.co.uk", "Line Manager", "boss@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorInvalidRelationType {
+			t.Errorf("Expected %q, got %v", ErrorInvalidRelationType, err)
+		}
+		if items := client.Items(TableName("test")); len(items) != 0 {
+			t.Errorf("Expected nothing written, got %d items", len(items))
+		}
+	})
+
+	t.Run("expect relations with deleted users to be left out", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk", "boss@ecs.co.uk")
+		Relate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client)
+		client.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String("test"), Key: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("boss@ecs.co.uk")}}})
+		if relations, _ := FetchRelations("alan@ecs.co.uk", "", "test", client); len(relations) != 0 {
+			t.Errorf("Expected no relations, got %s", describe(relations))
+		}
+	})
+
+	t.Run("expect a relation to be removed from both ends", func(t *testing.T) {
+		client := newClient("alan@ecs.co.uk", "boss@ecs.co.uk")
+		Relate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client)
+		if err := Unrelate("alan@ecs.co.uk", TypeManager, "boss@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if items := client.Items(TableName("test")); len(items) != 0 {
+			t.Errorf("Expected both items removed, got %d", len(items))
+		}
+	})
+}
@@ -0,0 +1,232 @@
+// Package s3import imports users from CSV and JSON Lines objects as they
+// land in an import bucket, driven by the bucket's object created events,
+// or as the API asks for them in an asynchronous invocation of the function.
+// Each object is streamed and its rows created one at a time, and the
+// outcome is written next to it as a manifest.
+package s3import
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	FormatCSV        = "csv"
+	FormatJSONLines  = "jsonl"
+	ManifestSuffix   = ".result.json"
+	DefaultKeyPrefix = "imports/"
+)
+
+var (
+	ErrorFailedToReadObject    = "failed to read import object"
+	ErrorFailedToWriteManifest = "failed to write import manifest"
+)
+
+// Config sets the client objects are read and manifests written with, and
+// Prefix, the keys imported. Objects outside it, or that are not .csv or
+// .jsonl, are ignored, so manifests never trigger an import of their own.
+// Bucket, Lambda and FunctionName let the API Start an import of any object
+// of Bucket in an asynchronous invocation of the function itself.
+type Config struct {
+	S3           s3iface.S3API
+	Prefix       string
+	Bucket       string
+	Lambda       lambdaiface.LambdaAPI
+	FunctionName string
+}
+
+var config = Config{Prefix: DefaultKeyPrefix}
+
+func Configure(c Config) {
+	config = c
+}
+
+// Manifest records the import of Key. Report is set when its rows were
+// read, and Error and Code when the object could not be read at all, such
+// as for a CSV header without the required columns.
+type Manifest struct {
+	Bucket     string             `json:"bucket"`
+	Key        string             `json:"key"`
+	Format     string             `json:"format"`
+	StartedAt  string             `json:"startedAt"`
+	FinishedAt string             `json:"finishedAt"`
+	Report     *user.ImportReport `json:"report,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Code       string             `json:"code,omitempty"`
+}
+
+// Request asks for the object Key of Bucket to be imported as Format into
+// Table. Manifest is the key its outcome is written to.
+type Request struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Format   string `json:"format"`
+	Table    string `json:"table"`
+	Manifest string `json:"manifest"`
+}
+
+type importEvent struct {
+	Import *Request `json:"import"`
+}
+
+// Format returns the format of the object key, reporting whether it is one
+// to import.
+func Format(key string) (string, bool) {
+	if !strings.HasPrefix(key, config.Prefix) || strings.HasSuffix(key, ManifestSuffix) {
+		return "", false
+	}
+	return formatOf(key)
+}
+
+func formatOf(key string) (string, bool) {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".csv":
+		return FormatCSV, true
+	case ".jsonl", ".ndjson":
+		return FormatJSONLines, true
+	}
+	return "", false
+}
+
+// Start asks for the object key of the import bucket to be imported into
+// tableName by an asynchronous invocation of the function, which imports it
+// as Import does. Keys that are not .jsonl are read as CSV.
+func Start(key string, tableName string) (*Request, error) {
+	if config.S3 == nil || config.Lambda == nil || config.Bucket == "" || config.FunctionName == "" {
+		return nil, errors.New(user.ErrorImportsNotConfigured)
+	}
+	if key == "" {
+		return nil, errors.New(user.ErrorImportNotFound)
+	}
+	_, err := config.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		// HeadObject has no body to name the error, so a missing key is NotFound
+		if errors.As(err, &awsErr) && (awsErr.Code() == "NotFound" || awsErr.Code() == s3.ErrCodeNoSuchKey) {
+			return nil, errors.New(user.ErrorImportNotFound)
+		}
+		return nil, errors.New(user.ErrorFailedToReadImport)
+	}
+	format, ok := formatOf(key)
+	if !ok {
+		format = FormatCSV
+	}
+	request := &Request{Bucket: config.Bucket, Key: key, Format: format, Table: tableName, Manifest: key + ManifestSuffix}
+	payload, err := json.Marshal(importEvent{Import: request})
+	if err != nil {
+		return nil, errors.New(user.ErrorFailedToStartImport)
+	}
+	_, err = config.Lambda.Invoke(&lambda.InvokeInput{
+		FunctionName:   aws.String(config.FunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	})
+	if err != nil {
+		return nil, errors.New(user.ErrorFailedToStartImport)
+	}
+	return request, nil
+}
+
+// ParseEvent returns the import an invocation started by Start asks for,
+// reporting whether payload is one.
+func ParseEvent(payload []byte) (Request, bool) {
+	var e importEvent
+	if err := json.Unmarshal(payload, &e); err != nil || e.Import == nil || e.Import.Key == "" {
+		return Request{}, false
+	}
+	return *e.Import, true
+}
+
+// Handle imports each object of event that is one to import, returning
+// their manifests. It stops at the first object that could not be read or
+// whose manifest could not be written, so the event is retried; rows
+// already created are skipped when it is.
+func Handle(event events.S3Event, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Manifest, error) {
+	manifests := []Manifest{}
+	for _, record := range event.Records {
+		key := record.S3.Object.URLDecodedKey
+		format, ok := Format(key)
+		if !ok {
+			continue
+		}
+		manifest, err := Import(record.S3.Bucket.Name, key, format, tableName, dynaClient)
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, *manifest)
+	}
+	return manifests, nil
+}
+
+// Import imports the object key of bucket, read as format, and writes its
+// manifest next to it as key followed by ManifestSuffix.
+func Import(bucket string, key string, format string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Manifest, error) {
+	manifest := &Manifest{
+		Bucket:    bucket,
+		Key:       key,
+		Format:    format,
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	object, err := config.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToReadObject)
+	}
+	defer object.Body.Close()
+
+	var report *user.ImportReport
+	if format == FormatJSONLines {
+		report, err = user.ImportJSONLines(object.Body, tableName, dynaClient)
+	} else {
+		report, err = user.ImportUsers(object.Body, tableName, dynaClient)
+	}
+	if err != nil {
+		if err.Error() == user.ErrorFailedToReadImport {
+			return nil, errors.New(ErrorFailedToReadObject)
+		}
+		manifest.Error = err.Error()
+		manifest.Code = i18n.Code(err.Error())
+	} else {
+		for i, row := range report.Rows {
+			report.Rows[i].Code = i18n.Code(row.Error)
+		}
+		manifest.Report = report
+	}
+	manifest.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.New(ErrorFailedToWriteManifest)
+	}
+	_, err = config.S3.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key + ManifestSuffix),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToWriteManifest)
+	}
+	return manifest, nil
+}
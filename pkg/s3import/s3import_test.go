@@ -0,0 +1,134 @@
+package s3import
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/i18n"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type bucketClient struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (b *bucketClient) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(b.objects[*input.Key]))}, nil
+}
+
+func (b *bucketClient) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if _, ok := b.objects[*input.Key]; !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (b *bucketClient) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, _ := io.ReadAll(input.Body)
+	b.objects[*input.Key] = string(body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// invoker keeps the payloads the function was invoked with.
+type invoker struct {
+	lambdaiface.LambdaAPI
+	payloads [][]byte
+}
+
+func (i *invoker) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	i.payloads = append(i.payloads, input.Payload)
+	return &lambda.InvokeOutput{}, nil
+}
+
+// created returns an object created event for keys, encoded as S3 sends them.
+func created(t *testing.T, keys ...string) events.S3Event {
+	records := make([]string, len(keys))
+	for i, key := range keys {
+		records[i] = `{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "imports"}, "object": {"key": "` + key + `"}}}`
+	}
+	var event events.S3Event
+	if err := json.Unmarshal([]byte(`{"Records": [`+strings.Join(records, ",")+`]}`), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	bucket := &bucketClient{objects: map[string]string{
+		"imports/new users.jsonl": `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}` + "\n" + `{"email": "alan"}`,
+		"imports/bad.csv":         "email,nickname\nalan.oliver@ecs.co.uk,Al",
+	}}
+	Configure(Config{S3: bucket, Prefix: DefaultKeyPrefix})
+	defer Configure(Config{Prefix: DefaultKeyPrefix})
+
+	event := created(t, "imports/new+users.jsonl", "imports/bad.csv", "imports/bad.csv.result.json", "exports/users.csv")
+	manifests, err := Handle(event, "test", &testutil.MockDynamoDB{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("Expected only the two imports under the prefix to be imported, got %+v", manifests)
+	}
+	var manifest Manifest
+	json.Unmarshal([]byte(bucket.objects["imports/new users.jsonl"+ManifestSuffix]), &manifest)
+	if manifest.Format != FormatJSONLines || manifest.Report == nil || manifest.Report.Created != 1 || manifest.Report.Failed != 1 {
+		t.Errorf("Expected a manifest of 1 created and 1 failed next to the object, got %+v", manifest)
+	}
+	if manifest.Report != nil && manifest.Report.Rows[0].Code != i18n.Code(user.ErrorInvalidUserData) {
+		t.Errorf("Expected the failed row to have a code, got %+v", manifest.Report.Rows[0])
+	}
+	if manifests[1].Code != "invalid_csv_header" || manifests[1].Report != nil {
+		t.Errorf("Expected the CSV to fail on its header, got %+v", manifests[1])
+	}
+}
+
+func TestStart(t *testing.T) {
+	bucket := &bucketClient{objects: map[string]string{
+		"uploads/users.jsonl": `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}`,
+	}}
+	functions := &invoker{}
+	Configure(Config{S3: bucket, Prefix: DefaultKeyPrefix, Bucket: "imports", Lambda: functions, FunctionName: "users"})
+	defer Configure(Config{Prefix: DefaultKeyPrefix})
+
+	t.Run("expect an import started to be run by the invocation it asks for", func(t *testing.T) {
+		request, err := Start("uploads/users.jsonl", "test")
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if request.Format != FormatJSONLines || request.Manifest != "uploads/users.jsonl"+ManifestSuffix || len(functions.payloads) != 1 {
+			t.Fatalf("Expected one invocation to import the object as JSON Lines, got %+v", *request)
+		}
+		event, ok := ParseEvent(functions.payloads[0])
+		if !ok || event != *request {
+			t.Fatalf("Expected the invocation to carry the import, got %+v", event)
+		}
+		manifest, err := Import(event.Bucket, event.Key, event.Format, event.Table, &testutil.MockDynamoDB{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if manifest.Report == nil || manifest.Report.Created != 1 || bucket.objects[request.Manifest] == "" {
+			t.Errorf("Expected the user created and the manifest written, got %+v", *manifest)
+		}
+	})
+	t.Run("expect a missing object not to be started", func(t *testing.T) {
+		if _, err := Start("uploads/missing.csv", "test"); err == nil || err.Error() != user.ErrorImportNotFound {
+			t.Errorf("Expected error %s, got %v", user.ErrorImportNotFound, err)
+		}
+	})
+	t.Run("expect other invocations not to be taken for an import", func(t *testing.T) {
+		if _, ok := ParseEvent([]byte(`{"export": {"id": "1"}}`)); ok {
+			t.Error("Expected an export not to be an import")
+		}
+	})
+}
@@ -0,0 +1,330 @@
+// Package schema keeps the registry of custom attributes users may carry in
+// their metadata: each attribute's name, type, whether it is required and,
+// for enums, the values it allows. Operators manage the registry through
+// the API, and the user package checks metadata against it whenever a user
+// is created or updated, so integrations can add attributes without code
+// changes.
+package schema
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/flags"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Types an attribute's values can have. Values are stored as strings, so a
+// number is anything that parses as one, a boolean is true or false and a
+// date has the form YYYY-MM-DD.
+const (
+	TypeBoolean = "boolean"
+	TypeDate    = "date"
+	TypeEnum    = "enum"
+	TypeNumber  = "number"
+	TypeString  = "string"
+)
+
+var (
+	ErrorAttributeNotFound          = "attribute not found"
+	ErrorFailedToDeleteAttribute    = "failed to delete attribute"
+	ErrorFailedToFetchAttributes    = "failed to fetch attributes"
+	ErrorFailedToSaveAttribute      = "failed to save attribute"
+	ErrorFailedToUnmarshalAttribute = "failed to unmarshal attribute"
+	ErrorInvalidAttributeData       = "invalid attribute data"
+)
+
+// Attribute defines a metadata key. Enum lists the values of an enum and is
+// dropped from attributes of any other type.
+type Attribute struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type" validate:"required,oneof=string number boolean date enum"`
+	Required    bool     `json:"required,omitempty"`
+	Enum        []string `json:"enum,omitempty" validate:"max=50"`
+	Description string   `json:"description,omitempty" validate:"max=500"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+}
+
+// Config sets how metadata is checked. With Strict set, metadata keys that
+// are not registered are refused; otherwise only registered keys are
+// checked. The registry is cached per container for CacheTTL, so changes
+// made by other containers apply once it expires. A zero CacheTTL takes the
+// default.
+type Config struct {
+	Strict   bool
+	CacheTTL time.Duration
+}
+
+const DefaultCacheTTL = time.Minute
+
+var config = Config{CacheTTL: DefaultCacheTTL}
+
+func Configure(c Config) {
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = DefaultCacheTTL
+	}
+	config = c
+	registries.clear()
+}
+
+// TableName returns the schema table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Schema"
+}
+
+func key(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {S: aws.String(name)},
+	}
+}
+
+// Put registers an attribute, replacing any registered under its name.
+// Making an attribute required does not change existing users, but they
+// cannot be updated without it.
+func Put(a Attribute, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Attribute, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	a.CreatedAt, a.UpdatedAt = now, now
+	existing, err := Fetch(a.Name, userTableName, dynaClient)
+	if err == nil {
+		a.CreatedAt = existing.CreatedAt
+	} else if err.Error() != ErrorAttributeNotFound {
+		return nil, err
+	}
+	av, err := dynamodbattribute.MarshalMap(a)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveAttribute)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(TableName(userTableName)),
+	})
+	registries.invalidate(userTableName)
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveAttribute)
+	}
+	return &a, nil
+}
+
+func Fetch(name string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Attribute, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:       key(name),
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchAttributes)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorAttributeNotFound)
+	}
+	a := new(Attribute)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, a); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalAttribute)
+	}
+	return a, nil
+}
+
+// FetchAll reads every registered attribute, ordered by name.
+func FetchAll(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Attribute, error) {
+	// The registry is small, read whole and cached, so a scan reads nothing
+	// a Query would not
+	store.RecordScan(TableName(userTableName), store.ScanList)
+	input := &dynamodb.ScanInput{TableName: aws.String(TableName(userTableName))}
+	attributes := []Attribute{}
+	for {
+		result, err := dynaClient.Scan(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchAttributes)
+		}
+		page := []Attribute{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalAttribute)
+		}
+		attributes = append(attributes, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Name < attributes[j].Name })
+	return attributes, nil
+}
+
+// Delete unregisters an attribute. Users keep any values they have for it.
+func Delete(name string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	result, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:          key(name),
+		TableName:    aws.String(TableName(userTableName)),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	registries.invalidate(userTableName)
+	if err != nil {
+		return store.Error(err, ErrorFailedToDeleteAttribute)
+	}
+	if len(result.Attributes) == 0 {
+		return errors.New(ErrorAttributeNotFound)
+	}
+	return nil
+}
+
+// Check checks metadata against the registry of the users table, returning
+// validators.FieldErrors, with fields named metadata.<key>, when it breaks
+// any attribute's rules. Checks are strict when configured to be, or when
+// the strict validation flag is on for the table.
+func Check(metadata map[string]string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	attributes, err := registries.get(userTableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	strict := config.Strict || flags.Enabled(flags.StrictValidation, userTableName)
+	if errs := check(metadata, attributes, strict); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func check(metadata map[string]string, attributes []Attribute, strict bool) validators.FieldErrors {
+	errs := validators.FieldErrors{}
+	registered := make(map[string]bool, len(attributes))
+	for _, a := range attributes {
+		registered[a.Name] = true
+		value, ok := metadata[a.Name]
+		if !ok || value == "" {
+			if a.Required {
+				errs = append(errs, &validators.FieldError{Field: "metadata." + a.Name, Rule: validators.RuleRequired, Code: validators.CodeRequired, Message: validators.ErrorFieldRequired})
+			}
+			continue
+		}
+		if fieldErr := a.checkValue(value); fieldErr != nil {
+			fieldErr.Field = "metadata." + a.Name
+			errs = append(errs, fieldErr)
+		}
+	}
+	if strict {
+		for name := range metadata {
+			if !registered[name] {
+				errs = append(errs, &validators.FieldError{Field: "metadata." + name, Rule: validators.RuleMetadata, Code: validators.CodeUnknownKey, Message: validators.ErrorUnknownMetadataKey})
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// checkValue returns a field error, without its field, when value is not of
+// the attribute's type.
+func (a Attribute) checkValue(value string) *validators.FieldError {
+	switch a.Type {
+	case TypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return &validators.FieldError{Rule: validators.RuleNumber, Code: validators.CodeInvalidFormat, Message: validators.ErrorInvalidNumberFormat}
+		}
+	case TypeBoolean:
+		if value != "true" && value != "false" {
+			return &validators.FieldError{Rule: validators.RuleBoolean, Code: validators.CodeInvalidFormat, Message: validators.ErrorInvalidBooleanFormat}
+		}
+	case TypeDate:
+		if _, err := time.Parse(validators.DateLayout, value); err != nil {
+			return &validators.FieldError{Rule: validators.RuleDate, Code: validators.CodeInvalidFormat, Message: validators.ErrorInvalidDateFormat}
+		}
+	case TypeEnum:
+		for _, allowed := range a.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		options := strings.Join(a.Enum, ", ")
+		return &validators.FieldError{Rule: validators.RuleOneOf, Code: validators.CodeNotAllowed, Message: "must be one of " + options, Param: options}
+	}
+	return nil
+}
+
+// validate normalises the attribute and checks it, returning
+// validators.FieldErrors when it is invalid. Its name must be usable as a
+// metadata key and an enum must list at least one value.
+func (a *Attribute) validate() error {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	a.Description = strings.TrimSpace(a.Description)
+	if a.Type != TypeEnum {
+		a.Enum = nil
+	}
+	errs := validators.ValidateStruct(a)
+	if !validators.IsMetadataKeyValid(a.Name) {
+		errs = append(validators.FieldErrors{{Field: "name", Rule: validators.RuleMetadata, Code: validators.CodeInvalidKey, Message: validators.ErrorInvalidMetadataKey}}, errs...)
+	}
+	if a.Type == TypeEnum {
+		for _, value := range a.Enum {
+			if value == "" || len([]rune(value)) > validators.MaxMetadataValueLength {
+				errs = append(errs, &validators.FieldError{Field: "enum", Rule: validators.RuleMetadata, Code: validators.CodeValueTooLong, Message: validators.ErrorMetadataValueTooLong})
+				break
+			}
+		}
+		if len(a.Enum) == 0 {
+			errs = append(errs, &validators.FieldError{Field: "enum", Rule: validators.RuleRequired, Code: validators.CodeRequired, Message: validators.ErrorFieldRequired})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// registryCache holds each users table's registry for the configured TTL.
+type registryCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]registryEntry
+}
+
+type registryEntry struct {
+	attributes []Attribute
+	expires    time.Time
+}
+
+var registries = &registryCache{now: time.Now, entries: map[string]registryEntry{}}
+
+func (c *registryCache) get(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Attribute, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userTableName]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.attributes, nil
+	}
+	attributes, err := FetchAll(userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[userTableName] = registryEntry{attributes: attributes, expires: c.now().Add(config.CacheTTL)}
+	c.mu.Unlock()
+	return attributes, nil
+}
+
+func (c *registryCache) invalidate(userTableName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userTableName)
+}
+
+func (c *registryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]registryEntry{}
+}
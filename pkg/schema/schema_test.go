@@ -0,0 +1,171 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newClient returns a client keeping the schema table in memory.
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(testutil.Table{Name: TableName("test"), PartitionKey: "name"})
+}
+
+func fieldRules(err error) map[string]string {
+	rules := map[string]string{}
+	if errs, ok := err.(validators.FieldErrors); ok {
+		for _, e := range errs {
+			rules[e.Field] = e.Rule + "/" + e.Code
+		}
+	}
+	return rules
+}
+
+func TestPut(t *testing.T) {
+	t.Run("expect an attribute to keep when it was first registered", func(t *testing.T) {
+		client := newClient()
+		first, err := Put(Attribute{Name: "department", Type: "String"}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if first.Type != TypeString {
+			t.Errorf("Expected the type to be normalised, got %q", first.Type)
+		}
+		client.Item(TableName("test"), key("department"))["createdAt"].S = aws.String("2020-01-01T00:00:00Z")
+		second, err := Put(Attribute{Name: "department", Type: TypeString, Required: true}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if second.CreatedAt != "2020-01-01T00:00:00Z" || !second.Required {
+			t.Errorf("Expected the attribute to be replaced keeping its creation, got %+v", second)
+		}
+	})
+
+	t.Run("expect values to be dropped from an attribute that is not an enum", func(t *testing.T) {
+		a, err := Put(Attribute{Name: "cost-centre", Type: TypeNumber, Enum: []string{"1"}}, "test", newClient())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if a.Enum != nil {
+			t.Errorf("Expected no values, got %v", a.Enum)
+		}
+	})
+
+	t.Run("expect an invalid attribute to be refused", func(t *testing.T) {
+		client := newClient()
+		_, err := Put(Attribute{Name: "has space", Type: TypeEnum}, "test", client)
+		expected := map[string]string{
+			"name": "metadata/invalid_key",
+			"enum": "required/required",
+		}
+		if got := fieldRules(err); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+		_, err = Put(Attribute{Name: "department", Type: "text"}, "test", client)
+		if got := fieldRules(err); got["type"] != "oneof/not_allowed" {
+			t.Errorf("Expected the type to be refused, got %v", got)
+		}
+		if items := client.Items(TableName("test")); len(items) != 0 {
+			t.Errorf("Expected nothing to be saved, got %v", items)
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	client := newClient()
+	if err := Delete("department", "test", client); err == nil || err.Error() != ErrorAttributeNotFound {
+		t.Errorf("Expected %q, got %v", ErrorAttributeNotFound, err)
+	}
+	if _, err := Put(Attribute{Name: "department", Type: TypeString}, "test", client); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := Delete("department", "test", client); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if _, err := Fetch("department", "test", client); err == nil || err.Error() != ErrorAttributeNotFound {
+		t.Errorf("Expected %q, got %v", ErrorAttributeNotFound, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	attributes := []Attribute{
+		{Name: "department", Type: TypeString, Required: true},
+		{Name: "headcount", Type: TypeNumber},
+		{Name: "contractor", Type: TypeBoolean},
+		{Name: "started", Type: TypeDate},
+		{Name: "tier", Type: TypeEnum, Enum: []string{"gold", "silver"}},
+	}
+
+	t.Run("expect metadata following every attribute to pass", func(t *testing.T) {
+		metadata := map[string]string{"department": "sales", "headcount": "12.5", "contractor": "false", "started": "2021-03-01", "tier": "gold", "other": "x"}
+		if errs := check(metadata, attributes, false); errs != nil {
+			t.Errorf("Expected no errors, got %v", errs)
+		}
+	})
+
+	t.Run("expect each broken rule to be reported under its key", func(t *testing.T) {
+		metadata := map[string]string{"headcount": "many", "contractor": "yes", "started": "01/03/2021", "tier": "bronze"}
+		expected := map[string]string{
+			"metadata.department": "required/required",
+			"metadata.headcount":  "number/invalid_format",
+			"metadata.contractor": "boolean/invalid_format",
+			"metadata.started":    "date/invalid_format",
+			"metadata.tier":       "oneof/not_allowed",
+		}
+		errs := check(metadata, attributes, false)
+		if got := fieldRules(errs); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v", expected, got)
+		}
+		if errs[len(errs)-1].Param != "gold, silver" {
+			t.Errorf("Expected the allowed values as the parameter, got %q", errs[len(errs)-1].Param)
+		}
+	})
+
+	t.Run("expect unregistered keys to be refused only when strict", func(t *testing.T) {
+		metadata := map[string]string{"department": "sales", "other": "x"}
+		errs := check(metadata, attributes, true)
+		if got := fieldRules(errs); !reflect.DeepEqual(got, map[string]string{"metadata.other": "metadata/unknown_key"}) {
+			t.Errorf("Expected the unregistered key to be refused, got %v", got)
+		}
+	})
+}
+
+func TestRegistryCache(t *testing.T) {
+	defer Configure(Config{})
+	Configure(Config{CacheTTL: time.Minute})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	registries.now = func() time.Time { return now }
+	defer func() { registries.now = time.Now }()
+
+	client := newClient()
+	for i := 0; i < 2; i++ {
+		if err := Check(nil, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if scans := client.Count("Scan"); scans != 1 {
+		t.Errorf("Expected the registry to be read once, got %d scans", scans)
+	}
+
+	if _, err := Put(Attribute{Name: "department", Type: TypeString, Required: true}, "test", client); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := Check(nil, "test", client); err == nil {
+		t.Error("Expected a change to the registry to apply at once")
+	}
+
+	client.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String(TableName("test")), Key: key("department")})
+	if err := Check(nil, "test", client); err == nil {
+		t.Error("Expected the cached registry to be used until it expires")
+	}
+	now = now.Add(time.Minute)
+	if err := Check(nil, "test", client); err != nil {
+		t.Errorf("Expected the registry to be read again once expired, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Package seal turns values into opaque tokens that only the holder of a key
+// can read or make: their JSON, encrypted and authenticated with AES-GCM, and
+// base64 URL encoded. Tokens handed to clients, such as pagination cursors
+// and email change links, are sealed so they neither reveal nor let a client
+// alter what they hold, as are secrets kept at rest, such as TOTP secrets.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrorInvalidToken = "invalid token"
+
+// Seal returns v as a token sealed with key for purpose. The AES-256 key is
+// the SHA-256 of key, so any secret may be configured, and purpose is
+// authenticated with the token, so one sealed for a purpose cannot be opened
+// for another with the same key.
+func Seal(key []byte, purpose string, v interface{}) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, payload, []byte(purpose))), nil
+}
+
+// Open reads a token sealed with key for purpose into v, rejecting any that
+// was altered, or sealed with another key or for another purpose.
+func Open(key []byte, purpose string, token string, v interface{}) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return errors.New(ErrorInvalidToken)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	payload, err := gcm.Open(nil, nonce, ciphertext, []byte(purpose))
+	if err != nil || json.Unmarshal(payload, v) != nil {
+		return errors.New(ErrorInvalidToken)
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,57 @@
+package seal
+
+import "testing"
+
+type claims struct {
+	Email string `json:"email"`
+}
+
+func TestSeal(t *testing.T) {
+	key := []byte("test key")
+
+	t.Run("should open what it sealed without revealing it", func(t *testing.T) {
+		token, err := Seal(key, "cursor", claims{Email: "alan.oliver@ecs.co.uk"})
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		var got claims
+		if err := Open(key, "cursor", token, &got); err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if got.Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected %s, got %s", "alan.oliver@ecs.co.uk", got.Email)
+		}
+		other, _ := Seal(key, "cursor", claims{Email: "alan.oliver@ecs.co.uk"})
+		if token == other {
+			t.Error("Expected each token to be sealed with its own nonce")
+		}
+	})
+	t.Run("expect error when the token is altered, or opened with another key or for another purpose", func(t *testing.T) {
+		token, _ := Seal(key, "cursor", claims{Email: "alan.oliver@ecs.co.uk"})
+		middle := len(token) / 2
+		replacement := "A"
+		if token[middle] == 'A' {
+			replacement = "B"
+		}
+		altered := token[:middle] + replacement + token[middle+1:]
+
+		cases := []struct {
+			name    string
+			key     []byte
+			purpose string
+			token   string
+		}{
+			{"altered", key, "cursor", altered},
+			{"not base64", key, "cursor", "!!!"},
+			{"too short", key, "cursor", "AAAA"},
+			{"another key", []byte("rotated key"), "cursor", token},
+			{"another purpose", key, "email change", token},
+		}
+		for _, c := range cases {
+			var got claims
+			if err := Open(c.key, c.purpose, c.token, &got); err == nil || err.Error() != ErrorInvalidToken {
+				t.Errorf("Expected error %s for %s, got %v", ErrorInvalidToken, c.name, err)
+			}
+		}
+	})
+}
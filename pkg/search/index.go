@@ -0,0 +1,224 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// reindexBatchSize is how many users Reindex sends in each bulk request.
+const reindexBatchSize = 500
+
+// Action is one write to the index: the user with Email is indexed as User,
+// or deleted from the index when User is nil.
+type Action struct {
+	Email string
+	User  *user.User
+}
+
+// FromStreamRecord reads the action a record of a stream that holds both
+// old and new images calls for, reporting whether it calls for one. A user
+// written is indexed, and one deleted, or left behind as the tombstone of an
+// email change, is deleted.
+func FromStreamRecord(record events.DynamoDBEventRecord) (Action, bool, error) {
+	before, err := imageOf(record.Change.OldImage)
+	if err != nil {
+		return Action{}, false, err
+	}
+	after, err := imageOf(record.Change.NewImage)
+	if err != nil {
+		return Action{}, false, err
+	}
+	switch {
+	case live(after):
+		return Action{Email: after.Email, User: after}, true, nil
+	case live(before):
+		return Action{Email: before.Email}, true, nil
+	}
+	return Action{}, false, nil
+}
+
+func imageOf(item map[string]events.DynamoDBAttributeValue) (*user.User, error) {
+	if len(item) == 0 {
+		return nil, nil
+	}
+	// Stream images marshal as the same JSON the SDK's attribute values read
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var av map[string]*dynamodb.AttributeValue
+	if err := json.Unmarshal(data, &av); err != nil {
+		return nil, err
+	}
+	var u user.User
+	if err := dynamodbattribute.UnmarshalMap(av, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func live(u *user.User) bool {
+	return u != nil && u.Email != "" && u.MovedTo == ""
+}
+
+// bulkResponse is what the bulk API answers: one item per action, keyed by
+// the action's type.
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+type bulkItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Index applies actions to the index in order with the bulk API, returning
+// how many were applied before the first that failed. Actions the domain
+// throttled or failed to apply are sent again, with backoff, up to the
+// configured attempts; actions it rejected are not.
+func Index(actions []Action) (int, error) {
+	if !Enabled() {
+		return 0, errors.New(ErrorNotConfigured)
+	}
+	applied := make([]bool, len(actions))
+	delay := config.RetryDelay
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		pending := []int{}
+		for i := range actions {
+			if !applied[i] {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if retry := bulk(actions, pending, applied); !retry {
+			break
+		}
+	}
+	for i := range actions {
+		if !applied[i] {
+			return i, errors.New(ErrorFailedToIndex)
+		}
+	}
+	return len(actions), nil
+}
+
+// bulk sends the pending actions in one request, marking those applied, and
+// reports whether any that were not are worth sending again.
+func bulk(actions []Action, pending []int, applied []bool) bool {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, i := range pending {
+		a := actions[i]
+		meta := map[string]string{"_index": config.Index, "_id": a.Email}
+		if a.User == nil {
+			encoder.Encode(map[string]interface{}{"delete": meta})
+			continue
+		}
+		encoder.Encode(map[string]interface{}{"index": meta})
+		encoder.Encode(documentOf(*a.User))
+	}
+	status, data, err := do(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return true
+	}
+	if status != http.StatusOK {
+		return retryable(status)
+	}
+	var response bulkResponse
+	if err := json.Unmarshal(data, &response); err != nil || len(response.Items) != len(pending) {
+		return true
+	}
+	retry := false
+	for n, item := range response.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300, result.Status == http.StatusNotFound && actions[pending[n]].User == nil:
+				// Deleting a user who was never indexed leaves nothing to do
+				applied[pending[n]] = true
+			case retryable(result.Status):
+				retry = true
+			}
+		}
+	}
+	return retry
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// ReindexResult reports the users a reindex sent to the index.
+type ReindexResult struct {
+	Users int `json:"users"`
+}
+
+// Reindex indexes every user in the table, for an index created after the
+// table's stream began or one whose mapping gained fields. Users deleted
+// while the stream was not consumed are left in the index.
+func Reindex(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ReindexResult, error) {
+	if !Enabled() {
+		return nil, errors.New(ErrorNotConfigured)
+	}
+	store.RecordScan(userTableName, store.ScanRebuild)
+	dynaClient = store.Background(dynaClient)
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(userTableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	result := &ReindexResult{}
+	actions := []Action{}
+	flush := func() error {
+		if len(actions) == 0 {
+			return nil
+		}
+		n, err := Index(actions)
+		result.Users += n
+		actions = actions[:0]
+		return err
+	}
+	for {
+		page, err := dynaClient.Scan(input)
+		if err != nil {
+			return result, errors.New(ErrorFailedToIndex)
+		}
+		users := []user.User{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &users); err != nil {
+			return result, errors.New(ErrorFailedToIndex)
+		}
+		for i := range users {
+			if !live(&users[i]) {
+				continue
+			}
+			actions = append(actions, Action{Email: users[i].Email, User: &users[i]})
+			if len(actions) == reindexBatchSize {
+				if err := flush(); err != nil {
+					return result, err
+				}
+			}
+		}
+		if len(page.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = page.LastEvaluatedKey
+	}
+	return result, flush()
+}
@@ -0,0 +1,138 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+)
+
+// MaxQueryLength bounds the characters a query may have.
+const MaxQueryLength = 200
+
+// MaxResults is the furthest into a query's results a page can reach, the
+// index's default result window.
+const MaxResults = 10000
+
+var (
+	ErrorFailedToSearch = "failed to search users"
+	ErrorInvalidQuery   = "q must be between 1 and 200 characters"
+	ErrorUnavailable    = "search index is unavailable"
+)
+
+// queryFields are the fields a query matches, weighted by how strongly a
+// match in each suggests the user sought, and highlighted.
+var queryFields = []string{"fullName^3", "firstName^2", "lastName^2", "email.text^2", "company", "jobTitle"}
+
+// Hit is a user matching a query, with its relevance and the fragments of
+// each field that matched, the matches wrapped in <em>.
+type Hit struct {
+	User       user.User
+	Score      float64
+	Highlights map[string][]string
+}
+
+// Results is a page of a query's hits, most relevant first, and how many
+// users match it in all.
+type Results struct {
+	Hits  []Hit
+	Total int
+}
+
+// Next returns where the page after one starting at from begins, or zero
+// when the results end with it.
+func (r *Results) Next(from int) int {
+	next := from + len(r.Hits)
+	if len(r.Hits) == 0 || next >= r.Total || next >= MaxResults {
+		return 0
+	}
+	return next
+}
+
+// ValidQuery returns q trimmed, or an error when it is empty or too long.
+func ValidQuery(q string) (string, error) {
+	q = strings.TrimSpace(q)
+	if q == "" || len([]rune(q)) > MaxQueryLength {
+		return "", errors.New(ErrorInvalidQuery)
+	}
+	return q, nil
+}
+
+// Query returns size of the users of tableName matching q, from the from'th
+// on. Names, email, company and job title are matched fuzzily, so a typo or
+// two still matches, and names and email by their beginning as well. An
+// index that cannot be reached, is throttling or failing, or is not
+// configured for tableName is reported as ErrorUnavailable, for the caller
+// to fall back on.
+func Query(q string, from int, size int, tableName string) (*Results, error) {
+	if !Indexes(tableName) {
+		return nil, errors.New(ErrorUnavailable)
+	}
+	if from+size > MaxResults {
+		size = MaxResults - from
+	}
+	if size <= 0 {
+		return &Results{Hits: []Hit{}}, nil
+	}
+	highlight := map[string]interface{}{}
+	for _, field := range queryFields {
+		highlight[strings.Split(field, "^")[0]] = map[string]interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"from": from,
+		"size": size,
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"multi_match": map[string]interface{}{
+					"query": q, "fields": queryFields, "fuzziness": "AUTO",
+				}},
+				map[string]interface{}{"multi_match": map[string]interface{}{
+					"query": q, "type": "bool_prefix", "fields": []string{"fullName", "fullName._2gram", "fullName._3gram"},
+				}},
+				map[string]interface{}{"prefix": map[string]interface{}{
+					"email": map[string]interface{}{"value": strings.ToLower(q)},
+				}},
+			},
+			"minimum_should_match": 1,
+		}},
+		"highlight": map[string]interface{}{"fields": highlight},
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	status, data, err := do(http.MethodPost, "/"+config.Index+"/_search", "application/json", body)
+	if err != nil || retryable(status) || status == http.StatusNotFound {
+		return nil, errors.New(ErrorUnavailable)
+	}
+	if status != http.StatusOK {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score     float64             `json:"_score"`
+				Source    user.User           `json:"_source"`
+				Highlight map[string][]string `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	results := &Results{Hits: []Hit{}, Total: response.Hits.Total.Value}
+	for _, h := range response.Hits.Hits {
+		highlights := map[string][]string{}
+		for field, fragments := range h.Highlight {
+			// Subfields are highlighted under the field they index
+			name := strings.Split(field, ".")[0]
+			highlights[name] = append(highlights[name], fragments...)
+		}
+		results.Hits = append(results.Hits, Hit{User: h.Source, Score: h.Score, Highlights: highlights})
+	}
+	return results, nil
+}
@@ -0,0 +1,175 @@
+// Package search keeps an OpenSearch index of users up to date from the
+// users table's stream of changes, for the full-text and fuzzy matching
+// DynamoDB cannot do. Changes are written with the bulk API and retried
+// with backoff while the domain is throttling or unavailable, and the
+// index's mapping is created and extended by EnsureIndex.
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+var (
+	ErrorFailedToEnsureIndex = "failed to create or update the search index"
+	ErrorFailedToIndex       = "failed to index users"
+	ErrorNotConfigured       = "search is not configured"
+)
+
+// HTTPClient sends requests to the domain. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config names the domain and index users are kept in. Requests are signed
+// by Signer, when set, for Service in Region. Each bulk request is attempted
+// up to MaxAttempts times, waiting RetryDelay before the first retry and
+// twice as long before each one after it. Table is the users table the
+// index is kept for, when set; searches of any other table, such as a
+// tenant's, are not answered from the index. Zero values take the defaults
+// below; search is disabled while Endpoint is empty.
+type Config struct {
+	Endpoint    string
+	Index       string
+	Table       string
+	Client      HTTPClient
+	Signer      *v4.Signer
+	Region      string
+	Service     string
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+const (
+	DefaultIndex       = "users"
+	DefaultService     = "es"
+	DefaultMaxAttempts = 3
+	DefaultRetryDelay  = 200 * time.Millisecond
+)
+
+var config Config
+
+func Configure(c Config) {
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	if c.Index == "" {
+		c.Index = DefaultIndex
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	if c.Service == "" {
+		c.Service = DefaultService
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = DefaultRetryDelay
+	}
+	config = c
+}
+
+// Enabled reports whether a domain is configured.
+func Enabled() bool {
+	return config.Endpoint != ""
+}
+
+// Indexes reports whether the index holds the users of tableName.
+func Indexes(tableName string) bool {
+	return Enabled() && (config.Table == "" || config.Table == tableName)
+}
+
+// Document is a user as indexed, with their full name for matching as it is
+// typed.
+type Document struct {
+	user.User
+	FullName string `json:"fullName"`
+}
+
+func documentOf(u user.User) Document {
+	return Document{User: u, FullName: strings.TrimSpace(u.FirstName + " " + u.LastName)}
+}
+
+// Mapping is the index's mapping. Fields may be added to it, and EnsureIndex
+// adds them to an existing index, but a field's type cannot be changed
+// without reindexing into a new index. Fields left out, such as metadata,
+// are kept in each document but not searchable.
+const Mapping = `{
+  "dynamic": false,
+  "properties": {
+    "email": {"type": "keyword", "fields": {"text": {"type": "text", "analyzer": "simple"}}},
+    "firstName": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+    "lastName": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+    "fullName": {"type": "search_as_you_type"},
+    "company": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+    "jobTitle": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+    "phone": {"type": "keyword"},
+    "tags": {"type": "keyword"},
+    "status": {"type": "keyword"},
+    "createdAt": {"type": "date"},
+    "lastLoginAt": {"type": "date"},
+    "lastSeenAt": {"type": "date"}
+  }
+}`
+
+// EnsureIndex creates the index with Mapping when it does not exist, and
+// otherwise puts Mapping onto it, adding any fields it was created without.
+func EnsureIndex() error {
+	if !Enabled() {
+		return errors.New(ErrorNotConfigured)
+	}
+	status, _, err := do(http.MethodHead, "/"+config.Index, "", nil)
+	if err != nil {
+		return errors.New(ErrorFailedToEnsureIndex)
+	}
+	switch status {
+	case http.StatusNotFound:
+		status, _, err = do(http.MethodPut, "/"+config.Index, "application/json", []byte(`{"mappings": `+Mapping+`}`))
+	case http.StatusOK:
+		status, _, err = do(http.MethodPut, "/"+config.Index+"/_mapping", "application/json", []byte(Mapping))
+	}
+	if err != nil || status != http.StatusOK {
+		return errors.New(ErrorFailedToEnsureIndex)
+	}
+	return nil
+}
+
+// do sends a request to the domain, signed when a signer is configured, and
+// returns the response's status and body.
+func do(method string, path string, contentType string, body []byte) (int, []byte, error) {
+	return doContext(context.Background(), method, path, contentType, body)
+}
+
+func doContext(ctx context.Context, method string, path string, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, config.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if config.Signer != nil {
+		if _, err := config.Signer.Sign(req, bytes.NewReader(body), config.Service, config.Region, time.Now()); err != nil {
+			return 0, nil, err
+		}
+	}
+	resp, err := config.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
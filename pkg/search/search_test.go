@@ -0,0 +1,328 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// domain answers each request with answer, keeping the requests and their
+// bodies.
+type domain struct {
+	answer   func(req *http.Request, body []byte) (int, string)
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (d *domain) Do(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	d.requests = append(d.requests, req)
+	d.bodies = append(d.bodies, body)
+	status, response := d.answer(req, body)
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(response))}, nil
+}
+
+func configure(t *testing.T, client HTTPClient) {
+	Configure(Config{Endpoint: "https://search.example.com/", Client: client, RetryDelay: time.Millisecond})
+	t.Cleanup(func() { config = Config{} })
+}
+
+// bulkAnswer answers a bulk request with statuses, one per action, taking
+// each in turn from the front of the list.
+func bulkAnswer(statuses ...int) func(*http.Request, []byte) (int, string) {
+	return func(req *http.Request, body []byte) (int, string) {
+		items := []map[string]bulkItem{}
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var line map[string]json.RawMessage
+			json.Unmarshal(scanner.Bytes(), &line)
+			for action := range line {
+				if action != "index" && action != "delete" {
+					continue
+				}
+				items = append(items, map[string]bulkItem{action: {Status: statuses[0]}})
+				statuses = statuses[1:]
+			}
+		}
+		out, _ := json.Marshal(bulkResponse{Items: items})
+		return http.StatusOK, string(out)
+	}
+}
+
+func TestEnsureIndex(t *testing.T) {
+	t.Run("expect a missing index created with the mapping", func(t *testing.T) {
+		d := &domain{answer: func(req *http.Request, body []byte) (int, string) {
+			if req.Method == http.MethodHead {
+				return http.StatusNotFound, ""
+			}
+			return http.StatusOK, "{}"
+		}}
+		configure(t, d)
+		if err := EnsureIndex(); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(d.requests) != 2 || d.requests[1].Method != http.MethodPut || d.requests[1].URL.Path != "/users" || !json.Valid(d.bodies[1]) {
+			t.Errorf("Expected the index put with its mapping, got %d requests", len(d.requests))
+		}
+	})
+	t.Run("expect an existing index to have its mapping put", func(t *testing.T) {
+		d := &domain{answer: func(*http.Request, []byte) (int, string) { return http.StatusOK, "{}" }}
+		configure(t, d)
+		if err := EnsureIndex(); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(d.requests) != 2 || d.requests[1].URL.Path != "/users/_mapping" {
+			t.Errorf("Expected the mapping put, got %v", d.requests[len(d.requests)-1].URL)
+		}
+	})
+	t.Run("expect a rejected mapping to be an error", func(t *testing.T) {
+		configure(t, &domain{answer: func(req *http.Request, body []byte) (int, string) {
+			if req.Method == http.MethodHead {
+				return http.StatusOK, ""
+			}
+			return http.StatusBadRequest, `{"error": {"type": "illegal_argument_exception"}}`
+		}})
+		if err := EnsureIndex(); err == nil || err.Error() != ErrorFailedToEnsureIndex {
+			t.Errorf("Expected %q, got %v", ErrorFailedToEnsureIndex, err)
+		}
+	})
+}
+
+func TestIndex(t *testing.T) {
+	alan := &user.User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver"}
+	actions := []Action{{Email: alan.Email, User: alan}, {Email: "gone@ecs.co.uk"}}
+
+	t.Run("expect users indexed and deleted in one bulk request", func(t *testing.T) {
+		d := &domain{answer: bulkAnswer(http.StatusCreated, http.StatusNotFound)}
+		configure(t, d)
+		if n, err := Index(actions); n != 2 || err != nil {
+			t.Fatalf("Expected both applied, got %d and %v", n, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(d.bodies[0])), "\n")
+		if len(lines) != 3 || !strings.Contains(lines[1], `"fullName":"Alan Oliver"`) || !strings.Contains(lines[2], `"delete"`) {
+			t.Errorf("Expected an index and a delete, got %v", lines)
+		}
+		if d.requests[0].Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Expected an ndjson body, got %q", d.requests[0].Header.Get("Content-Type"))
+		}
+	})
+	t.Run("expect throttled actions sent again", func(t *testing.T) {
+		d := &domain{answer: bulkAnswer(http.StatusCreated, http.StatusTooManyRequests, http.StatusOK)}
+		configure(t, d)
+		if n, err := Index(actions); n != 2 || err != nil {
+			t.Fatalf("Expected both applied, got %d and %v", n, err)
+		}
+		if len(d.requests) != 2 || strings.Count(string(d.bodies[1]), "\n") != 1 {
+			t.Errorf("Expected only the throttled delete sent again, got %d requests", len(d.requests))
+		}
+	})
+	t.Run("expect a rejected action reported without being sent again", func(t *testing.T) {
+		d := &domain{answer: bulkAnswer(http.StatusBadRequest, http.StatusOK)}
+		configure(t, d)
+		n, err := Index(actions)
+		if n != 0 || err == nil || err.Error() != ErrorFailedToIndex {
+			t.Errorf("Expected the first action reported failed, got %d and %v", n, err)
+		}
+		if len(d.requests) != 1 {
+			t.Errorf("Expected one request, got %d", len(d.requests))
+		}
+	})
+	t.Run("expect an unavailable domain retried up to the attempts", func(t *testing.T) {
+		d := &domain{answer: func(*http.Request, []byte) (int, string) { return http.StatusServiceUnavailable, "" }}
+		configure(t, d)
+		if n, err := Index(actions); n != 0 || err == nil {
+			t.Errorf("Expected nothing applied, got %d and %v", n, err)
+		}
+		if len(d.requests) != DefaultMaxAttempts {
+			t.Errorf("Expected %d attempts, got %d", DefaultMaxAttempts, len(d.requests))
+		}
+	})
+	t.Run("expect an unconfigured domain to be an error", func(t *testing.T) {
+		if _, err := Index(actions); err == nil || err.Error() != ErrorNotConfigured {
+			t.Errorf("Expected %q, got %v", ErrorNotConfigured, err)
+		}
+	})
+}
+
+func TestFromStreamRecord(t *testing.T) {
+	image := func(attributes map[string]string) map[string]events.DynamoDBAttributeValue {
+		item := map[string]events.DynamoDBAttributeValue{}
+		for name, value := range attributes {
+			item[name] = events.NewStringAttribute(value)
+		}
+		return item
+	}
+	record := func(before, after map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+		return events.DynamoDBEventRecord{Change: events.DynamoDBStreamRecord{OldImage: before, NewImage: after}}
+	}
+	alan := image(map[string]string{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"})
+	alan["tags"] = events.NewStringSetAttribute([]string{"admin"})
+	tombstone := image(map[string]string{"email": "alan.oliver@ecs.co.uk", "movedTo": "alan@ecs.co.uk"})
+
+	cases := []struct {
+		name   string
+		record events.DynamoDBEventRecord
+		ok     bool
+		index  bool
+	}{
+		{"created", record(nil, alan), true, true},
+		{"updated", record(alan, alan), true, true},
+		{"deleted", record(alan, nil), true, false},
+		{"moved", record(alan, tombstone), true, false},
+		{"tombstone deleted", record(tombstone, nil), false, false},
+	}
+	for _, c := range cases {
+		action, ok, err := FromStreamRecord(c.record)
+		if err != nil || ok != c.ok || (action.User != nil) != c.index {
+			t.Errorf("%s: expected ok %v and index %v, got %+v, %v and %v", c.name, c.ok, c.index, action, ok, err)
+		}
+		if c.index && (action.User.FirstName != "Alan" || len(action.User.Tags) != 1) {
+			t.Errorf("%s: expected the user read from the image, got %+v", c.name, *action.User)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	t.Run("expect hits with their scores and highlights", func(t *testing.T) {
+		d := &domain{answer: func(*http.Request, []byte) (int, string) {
+			return http.StatusOK, `{"hits": {"total": {"value": 21}, "hits": [{"_score": 1.5,` +
+				` "_source": {"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "fullName": "Alan Oliver"},` +
+				` "highlight": {"email.text": ["<em>alan</em>"], "fullName": ["<em>Alan</em> Oliver"]}}]}}`
+		}}
+		configure(t, d)
+		results, err := Query("alna", 20, 10, "test")
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if results.Total != 21 || len(results.Hits) != 1 || results.Hits[0].User.FirstName != "Alan" || results.Hits[0].Score != 1.5 {
+			t.Errorf("Expected the one hit, got %+v", results)
+		}
+		if results.Hits[0].Highlights["email"][0] != "<em>alan</em>" || len(results.Hits[0].Highlights["fullName"]) != 1 {
+			t.Errorf("Expected highlights by field, got %v", results.Hits[0].Highlights)
+		}
+		if next := results.Next(20); next != 0 {
+			t.Errorf("Expected the results to end, got next %d", next)
+		}
+		var body map[string]interface{}
+		json.Unmarshal(d.bodies[0], &body)
+		if d.requests[0].URL.Path != "/users/_search" || body["from"] != float64(20) || body["size"] != float64(10) {
+			t.Errorf("Expected a page of the index searched, got %s %s", d.requests[0].URL.Path, d.bodies[0])
+		}
+	})
+	t.Run("expect an index that cannot answer reported unavailable", func(t *testing.T) {
+		for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusNotFound} {
+			configure(t, &domain{answer: func(*http.Request, []byte) (int, string) { return status, "" }})
+			if _, err := Query("alan", 0, 10, "test"); err == nil || err.Error() != ErrorUnavailable {
+				t.Errorf("Expected %q for %d, got %v", ErrorUnavailable, status, err)
+			}
+		}
+		config = Config{}
+		if _, err := Query("alan", 0, 10, "test"); err == nil || err.Error() != ErrorUnavailable {
+			t.Errorf("Expected %q unconfigured, got %v", ErrorUnavailable, err)
+		}
+	})
+	t.Run("expect pages to stop at the result window", func(t *testing.T) {
+		d := &domain{answer: func(*http.Request, []byte) (int, string) {
+			return http.StatusOK, `{"hits": {"total": {"value": 20000}, "hits": []}}`
+		}}
+		configure(t, d)
+		results, err := Query("alan", MaxResults, 10, "test")
+		if err != nil || len(results.Hits) != 0 || len(d.requests) != 0 {
+			t.Errorf("Expected an empty page without a request, got %+v, %v and %d requests", results, err, len(d.requests))
+		}
+	})
+}
+
+func TestValidQuery(t *testing.T) {
+	if q, err := ValidQuery("  alan "); q != "alan" || err != nil {
+		t.Errorf("Expected the query trimmed, got %q and %v", q, err)
+	}
+	for _, q := range []string{"", "   ", strings.Repeat("a", MaxQueryLength+1)} {
+		if _, err := ValidQuery(q); err == nil || err.Error() != ErrorInvalidQuery {
+			t.Errorf("Expected %q for %d characters, got %v", ErrorInvalidQuery, len(q), err)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	answer := func(*http.Request, []byte) (int, string) {
+		return http.StatusOK, `{"hits": {"hits": [{"_source": {"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}}]}}`
+	}
+	t.Cleanup(func() { ConfigureSuggestions(SuggestConfig{}) })
+
+	t.Run("expect completions from the index, cached whatever the case", func(t *testing.T) {
+		ConfigureSuggestions(SuggestConfig{CacheSize: 10, CacheTTL: time.Minute})
+		d := &domain{answer: answer}
+		configure(t, d)
+		for _, q := range []string{"Ala", "ala"} {
+			found, backend, err := Suggest(q, 5, "test", &testutil.MockDynamoDB{})
+			if err != nil || backend != BackendIndex || len(found) != 1 || found[0] != (Suggestion{Email: "alan.oliver@ecs.co.uk", Name: "Alan Oliver"}) {
+				t.Errorf("Expected the one completion from the index, got %v from %q and %v", found, backend, err)
+			}
+		}
+		if len(d.requests) != 1 {
+			t.Errorf("Expected the second answered from the cache, got %d requests", len(d.requests))
+		}
+		var body map[string]interface{}
+		json.Unmarshal(d.bodies[0], &body)
+		if body["size"] != float64(5) || body["timeout"] != "300ms" {
+			t.Errorf("Expected a small query bounded in time, got %s", d.bodies[0])
+		}
+	})
+	t.Run("expect the table scanned by prefix while the index is unavailable", func(t *testing.T) {
+		ConfigureSuggestions(SuggestConfig{})
+		configure(t, &domain{answer: func(*http.Request, []byte) (int, string) { return http.StatusTooManyRequests, "" }})
+		table := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		}}}}
+		found, backend, err := Suggest("ala", 5, "test", table)
+		if err != nil || backend != BackendPrefix || len(found) != 1 || found[0].Name != "Alan Oliver" {
+			t.Errorf("Expected the one completion from the table, got %v from %q and %v", found, backend, err)
+		}
+		input := table.Calls()[0].Input.(*dynamodb.ScanInput)
+		if input.ProjectionExpression == nil || !strings.Contains(aws.StringValue(input.FilterExpression), "begins_with") {
+			t.Errorf("Expected a projected scan filtered by prefix, got %v", input)
+		}
+	})
+	t.Run("expect a rejected query to be an error", func(t *testing.T) {
+		ConfigureSuggestions(SuggestConfig{})
+		configure(t, &domain{answer: func(*http.Request, []byte) (int, string) { return http.StatusBadRequest, "" }})
+		if _, _, err := Suggest("ala", 5, "test", &testutil.MockDynamoDB{}); err == nil || err.Error() != ErrorFailedToSearch {
+			t.Errorf("Expected %q, got %v", ErrorFailedToSearch, err)
+		}
+	})
+}
+
+func TestSuggestionCache(t *testing.T) {
+	now := time.Now()
+	c := newSuggestionCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+	c.add("a", []Suggestion{{Email: "a@ecs.co.uk"}}, BackendIndex)
+	c.add("b", []Suggestion{}, BackendIndex)
+	c.get("a")
+	c.add("c", []Suggestion{}, BackendPrefix)
+	if _, _, ok := c.get("b"); ok {
+		t.Error("Expected the least recently used answer evicted")
+	}
+	if _, backend, ok := c.get("c"); !ok || backend != BackendPrefix {
+		t.Errorf("Expected the newest answer kept with its backend, got %q", backend)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, _, ok := c.get("a"); ok {
+		t.Error("Expected an expired answer missed")
+	}
+}
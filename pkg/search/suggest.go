@@ -0,0 +1,225 @@
+package search
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Where suggestions came from.
+const (
+	BackendIndex  = "index"
+	BackendPrefix = "prefix"
+)
+
+// MaxSuggestions bounds how many completions one request can ask for.
+const MaxSuggestions = 20
+
+// SuggestConfig bounds how long suggestions take. Each asks the index, or
+// scans the table when the index cannot answer, for at most Timeout, and
+// answers are cached per container for CacheTTL, the least recently used
+// evicted once CacheSize are held; a zero CacheSize disables the cache.
+// A zero Timeout takes the default.
+type SuggestConfig struct {
+	Timeout   time.Duration
+	CacheSize int
+	CacheTTL  time.Duration
+}
+
+const DefaultSuggestTimeout = 300 * time.Millisecond
+
+var suggesting = SuggestConfig{Timeout: DefaultSuggestTimeout}
+
+var suggestions *suggestionCache
+
+func ConfigureSuggestions(c SuggestConfig) {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultSuggestTimeout
+	}
+	suggesting = c
+	suggestions = nil
+	if c.CacheSize > 0 {
+		suggestions = newSuggestionCache(c.CacheSize, c.CacheTTL)
+	}
+}
+
+// Suggestion is a completion of what was typed: a user's email and name.
+type Suggestion struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// Suggest returns up to n users whose names or email begin with q, and
+// where they came from: the index, matching any word of the name as it is
+// typed, or, when it cannot answer in time or does not hold the table's
+// users, the beginning of the email, first or last name in the table.
+func Suggest(q string, n int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Suggestion, string, error) {
+	key := tableName + "\x00" + strconv.Itoa(n) + "\x00" + strings.ToLower(q)
+	if cached, backend, ok := suggestions.get(key); ok {
+		return cached, backend, nil
+	}
+	found, err := suggestFromIndex(q, n, tableName)
+	backend := BackendIndex
+	if err != nil {
+		if err.Error() != ErrorUnavailable {
+			return nil, "", err
+		}
+		found, err = suggestFromTable(q, n, tableName, dynaClient)
+		if err != nil {
+			return nil, "", err
+		}
+		backend = BackendPrefix
+	}
+	suggestions.add(key, found, backend)
+	return found, backend, nil
+}
+
+func suggestFromIndex(q string, n int, tableName string) ([]Suggestion, error) {
+	if !Indexes(tableName) {
+		return nil, errors.New(ErrorUnavailable)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"size":    n,
+		"timeout": strconv.FormatInt(suggesting.Timeout.Milliseconds(), 10) + "ms",
+		"_source": []string{"email", "firstName", "lastName"},
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"multi_match": map[string]interface{}{
+					"query": q, "type": "bool_prefix", "fields": []string{"fullName", "fullName._2gram", "fullName._3gram"},
+				}},
+				map[string]interface{}{"prefix": map[string]interface{}{
+					"email": map[string]interface{}{"value": strings.ToLower(q)},
+				}},
+			},
+			"minimum_should_match": 1,
+		}},
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), suggesting.Timeout)
+	defer cancel()
+	status, data, err := doContext(ctx, http.MethodPost, "/"+config.Index+"/_search", "application/json", body)
+	if err != nil || retryable(status) || status == http.StatusNotFound {
+		return nil, errors.New(ErrorUnavailable)
+	}
+	if status != http.StatusOK {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	var response struct {
+		Hits struct {
+			Hits []struct {
+				Source user.User `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, errors.New(ErrorFailedToSearch)
+	}
+	found := []Suggestion{}
+	for _, h := range response.Hits.Hits {
+		found = append(found, suggestionOf(h.Source))
+	}
+	return found, nil
+}
+
+func suggestFromTable(q string, n int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Suggestion, error) {
+	result, err := user.ScanUsersWithOptions(tableName, dynaClient, user.FetchOptions{
+		Prefix:      q,
+		Fields:      []string{"email", "firstName", "lastName"},
+		Limit:       n,
+		MaxDuration: suggesting.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := []Suggestion{}
+	for _, u := range result.Users {
+		found = append(found, suggestionOf(u))
+	}
+	return found, nil
+}
+
+func suggestionOf(u user.User) Suggestion {
+	return Suggestion{Email: u.Email, Name: strings.TrimSpace(u.FirstName + " " + u.LastName)}
+}
+
+type suggestionEntry struct {
+	key         string
+	suggestions []Suggestion
+	backend     string
+	expires     time.Time
+}
+
+// suggestionCache is an LRU of answers keyed by table, size and what was
+// typed, whatever its case. Users changed since an answer was cached are
+// seen once it expires.
+type suggestionCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newSuggestionCache(size int, ttl time.Duration) *suggestionCache {
+	return &suggestionCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *suggestionCache) get(key string) ([]Suggestion, string, bool) {
+	if c == nil {
+		return nil, "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, "", false
+	}
+	entry := element.Value.(*suggestionEntry)
+	if c.ttl > 0 && c.now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.suggestions, entry.backend, true
+}
+
+func (c *suggestionCache) add(key string, found []Suggestion, backend string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &suggestionEntry{key: key, suggestions: found, backend: backend, expires: c.now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*suggestionEntry).key)
+	}
+}
@@ -0,0 +1,156 @@
+package seed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// DefaultCount is how many fixture users a seed loads when not told.
+	DefaultCount = 25
+	// MaxCount is the most fixture users one seed loads, which keeps a
+	// reset within a single bulk delete.
+	MaxCount = user.MaxBulkDelete
+
+	// Tag is carried by every fixture user, so they can be listed and
+	// deleted together.
+	Tag = "seed"
+)
+
+var (
+	ErrorInvalidSeedCount = "seed count must be between 1 and 1000"
+	ErrorSeedingDisabled  = "seeding is disabled"
+)
+
+var (
+	firstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dennis", "Edsger", "Frances", "Grace", "Hedy", "Ken", "Katherine", "Linus", "Margaret", "Radia", "Tim"}
+	lastNames  = []string{"Lovelace", "Turing", "Liskov", "Shannon", "Ritchie", "Dijkstra", "Allen", "Hopper", "Lamarr", "Thompson", "Johnson", "Torvalds", "Hamilton", "Perlman", "Berners-Lee"}
+	companies  = []string{"", "Analytical Engines", "Bletchley Park", "Bell Labs"}
+	countries  = []string{"GB", "US", "FR", "ES"}
+)
+
+// Options adjusts what Load does with fixture users that already exist.
+type Options struct {
+	// Count is how many fixture users to load, DefaultCount when zero
+	Count int `json:"count,omitempty"`
+	// SkipExisting leaves fixture users that already exist as they are.
+	// Otherwise they are deleted and created again from the fixture.
+	SkipExisting bool `json:"skipExisting,omitempty"`
+}
+
+// Report counts the fixture users a seed created and skipped, and lists the
+// emails that failed with their reason.
+type Report struct {
+	Created int               `json:"created"`
+	Skipped int               `json:"skipped"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+type event struct {
+	Seed *Options `json:"seed"`
+}
+
+// ParseEvent returns the options of a payload asking for a seed, such as
+// {"seed": {"skipExisting": true}}, reporting whether it is one.
+func ParseEvent(payload []byte) (Options, bool) {
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil || e.Seed == nil {
+		return Options{}, false
+	}
+	return *e.Seed, true
+}
+
+// Users returns count fixture users. The same count always returns the same
+// users, and a larger count extends a smaller one.
+func Users(count int) []user.User {
+	users := make([]user.User, count)
+	for i := range users {
+		first := firstNames[i%len(firstNames)]
+		last := lastNames[(i/len(firstNames)+i)%len(lastNames)]
+		u := user.User{
+			Email:     fmt.Sprintf("%s.%s.%03d@example.com", strings.ToLower(first), strings.ToLower(strings.ReplaceAll(last, "-", "")), i+1),
+			FirstName: first,
+			LastName:  last,
+			Company:   user.Optional(companies[i%len(companies)]),
+			Tags:      []string{Tag},
+		}
+		if i%3 == 0 {
+			u.Phone = user.Optional(fmt.Sprintf("+4477009%05d", i+1))
+		}
+		if i%4 == 0 {
+			u.DateOfBirth = user.Optional(fmt.Sprintf("19%02d-%02d-%02d", 50+i%50, 1+i%12, 1+i%28))
+		}
+		if i%5 == 0 {
+			u.Address = &user.Address{
+				Line1:      fmt.Sprintf("%d High Street", i+1),
+				City:       "London",
+				PostalCode: "SW1A 1AA",
+				Country:    countries[i%len(countries)],
+			}
+		}
+		if i%2 == 0 {
+			u.Tags = append(u.Tags, "beta")
+		}
+		users[i] = u
+	}
+	return users
+}
+
+// Load creates the fixture users in tableName. Users are created through
+// user.Create, so they are validated and audited like any other.
+func Load(options Options, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Report, error) {
+	count := options.Count
+	if count == 0 {
+		count = DefaultCount
+	}
+	if count < 0 || count > MaxCount {
+		return nil, errors.New(ErrorInvalidSeedCount)
+	}
+	users := Users(count)
+
+	report := &Report{}
+	if !options.SkipExisting {
+		emails := make([]string, len(users))
+		for i, u := range users {
+			emails[i] = u.Email
+		}
+		deleted, err := user.DeleteUsers(user.BulkDelete{Emails: emails}, tableName, dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		for _, outcome := range deleted.Outcomes {
+			if outcome.Outcome == user.DeleteOutcomeFailed {
+				report.fail(outcome.Email, outcome.Error)
+			}
+		}
+	}
+
+	for _, u := range users {
+		if _, failed := report.Failed[u.Email]; failed {
+			continue
+		}
+		_, err := user.Create(u, tableName, dynaClient)
+		switch {
+		case err == nil:
+			report.Created++
+		case err.Error() == user.ErrorUserAlreadyExists:
+			report.Skipped++
+		default:
+			report.fail(u.Email, err.Error())
+		}
+	}
+	return report, nil
+}
+
+func (r *Report) fail(email string, reason string) {
+	if r.Failed == nil {
+		r.Failed = map[string]string{}
+	}
+	r.Failed[email] = reason
+}
@@ -0,0 +1,118 @@
+package seed
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: audit.TableName("test"), PartitionKey: "subject", SortKey: "timestamp"},
+		testutil.Table{Name: schema.TableName("test"), PartitionKey: "name"},
+		testutil.Table{Name: user.PreferencesTableName("test"), PartitionKey: "email"},
+		testutil.Table{Name: credentials.TableName("test"), PartitionKey: "email"},
+		testutil.Table{Name: session.TableName("test"), PartitionKey: "id", Indexes: []testutil.Index{{Name: session.Table("test").Index(session.EmailIndex), PartitionKey: "email"}}},
+	)
+}
+
+func fixture(client *testutil.MemoryDynamoDB) map[string]*dynamodb.AttributeValue {
+	return client.Item("test", map[string]*dynamodb.AttributeValue{"email": {S: aws.String(Users(1)[0].Email)}})
+}
+
+func TestUsers(t *testing.T) {
+	t.Run("expect the same count to return the same users", func(t *testing.T) {
+		if !reflect.DeepEqual(Users(10), Users(10)) {
+			t.Error("Expected fixture users to be deterministic")
+		}
+		if !reflect.DeepEqual(Users(10), Users(20)[:10]) {
+			t.Error("Expected a larger count to extend a smaller one")
+		}
+	})
+
+	t.Run("expect every email to be unique", func(t *testing.T) {
+		seen := map[string]bool{}
+		for _, u := range Users(MaxCount) {
+			if seen[u.Email] {
+				t.Fatalf("Expected unique emails, got %s twice", u.Email)
+			}
+			seen[u.Email] = true
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("expect every fixture user to be created", func(t *testing.T) {
+		client := newClient()
+		report, err := Load(Options{}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Created != DefaultCount || len(report.Failed) != 0 {
+			t.Errorf("Expected %d users created, got %+v", DefaultCount, *report)
+		}
+		if users := client.Items("test"); len(users) != DefaultCount {
+			t.Errorf("Expected %d users in the table, got %d", DefaultCount, len(users))
+		}
+	})
+
+	t.Run("expect existing users to be skipped when asked", func(t *testing.T) {
+		client := newClient()
+		Load(Options{Count: 5}, "test", client)
+		fixture(client)["firstName"] = &dynamodb.AttributeValue{S: aws.String("Changed")}
+		report, err := Load(Options{Count: 10, SkipExisting: true}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Created != 5 || report.Skipped != 5 {
+			t.Errorf("Expected 5 created and 5 skipped, got %+v", *report)
+		}
+		if got := *fixture(client)["firstName"].S; got != "Changed" {
+			t.Errorf("Expected the existing user to be left alone, got %s", got)
+		}
+	})
+
+	t.Run("expect existing users to be reset otherwise", func(t *testing.T) {
+		client := newClient()
+		Load(Options{Count: 5}, "test", client)
+		fixture(client)["firstName"] = &dynamodb.AttributeValue{S: aws.String("Changed")}
+		report, err := Load(Options{Count: 5}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Created != 5 || report.Skipped != 0 {
+			t.Errorf("Expected 5 created, got %+v", *report)
+		}
+		if got := *fixture(client)["firstName"].S; got != Users(1)[0].FirstName {
+			t.Errorf("Expected the user to be reset to the fixture, got %s", got)
+		}
+	})
+
+	t.Run("expect an out of range count to be refused", func(t *testing.T) {
+		if _, err := Load(Options{Count: MaxCount + 1}, "test", newClient()); err == nil || err.Error() != ErrorInvalidSeedCount {
+			t.Errorf("Expected %q, got %v", ErrorInvalidSeedCount, err)
+		}
+	})
+}
+
+func TestParseEvent(t *testing.T) {
+	if options, ok := ParseEvent([]byte(`{"seed": {"count": 3, "skipExisting": true}}`)); !ok || options.Count != 3 || !options.SkipExisting {
+		t.Errorf("Expected a seed event, got %+v %t", options, ok)
+	}
+	if _, ok := ParseEvent([]byte(`{"seed": {}}`)); !ok {
+		t.Error("Expected an empty seed to be a seed event")
+	}
+	if _, ok := ParseEvent([]byte(`{"httpMethod": "GET", "path": "/"}`)); ok {
+		t.Error("Expected an API Gateway request not to be a seed event")
+	}
+}
@@ -0,0 +1,224 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// EmailIndex is the global secondary index of the sessions table, keyed by
+// email, that a user's sessions are listed from. It is deployed under this
+// name unless Config.Table maps it to another.
+const EmailIndex = "email-index"
+
+var (
+	ErrorFailedToDeleteSession    = "failed to delete session"
+	ErrorFailedToFetchSession     = "failed to fetch session"
+	ErrorFailedToSaveSession      = "failed to save session"
+	ErrorFailedToUnmarshalSession = "failed to unmarshal session"
+	ErrorInvalidSession           = "invalid or expired session"
+	ErrorNotOwnSession            = "session belongs to another user"
+	ErrorSessionNotFound          = "session not found"
+)
+
+// Config sets how long sessions last and the options the sessions table is
+// built with by Table.
+type Config struct {
+	TTL   time.Duration
+	Table []store.TableOption
+}
+
+var config = Config{TTL: 24 * time.Hour}
+
+func Configure(c Config) {
+	config = c
+}
+
+// Device describes what a session was started from.
+type Device struct {
+	UserAgent string `json:"userAgent,omitempty"`
+	SourceIP  string `json:"sourceIp,omitempty"`
+}
+
+// Session is issued at login. It is stored under a hash of its token, which
+// is only returned when the session is created, and the hash is the id the
+// session is listed and revoked by.
+type Session struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Device    Device `json:"device"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+	Token     string `json:"token,omitempty" dynamodbav:"-"`
+	// TTL lets DynamoDB remove sessions once they expire
+	TTL int64 `json:"-" dynamodbav:"ttl"`
+}
+
+// TableName returns the sessions table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Session"
+}
+
+// Table returns the sessions table that accompanies a users table. Sessions
+// are read consistently, so a revoked session is never taken as valid,
+// unless the configured options say otherwise.
+func Table(userTableName string) store.Table {
+	options := append([]store.TableOption{store.WithConsistentRead(true)}, config.Table...)
+	return store.NewTable(TableName(userTableName), options...)
+}
+
+// Create starts a session for email, returning it with its token.
+func Create(email string, device Device, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) (*Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.New(ErrorFailedToSaveSession)
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now().UTC()
+	expires := now.Add(config.TTL)
+	s := Session{
+		ID:        hashToken(token),
+		Email:     email,
+		Device:    device,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: expires.Format(time.RFC3339),
+		TTL:       expires.Unix(),
+	}
+	av, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveSession)
+	}
+	_, err = table.Client(dynaClient).PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(table.Name),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveSession)
+	}
+	s.Token = token
+	return &s, nil
+}
+
+// Validate returns the session token belongs to, failing with
+// ErrorInvalidSession when it has expired or been revoked.
+func Validate(token string, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) (*Session, error) {
+	result, err := table.Client(dynaClient).GetItem(&dynamodb.GetItemInput{
+		Key:            key(hashToken(token)),
+		TableName:      aws.String(table.Name),
+		ConsistentRead: aws.Bool(table.ConsistentRead),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchSession)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorInvalidSession)
+	}
+	s := new(Session)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, s); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalSession)
+	}
+	// DynamoDB removes expired items lazily, so expiry is checked here too
+	expires, err := time.Parse(time.RFC3339, s.ExpiresAt)
+	if err != nil || !time.Now().Before(expires) {
+		return nil, errors.New(ErrorInvalidSession)
+	}
+	return s, nil
+}
+
+// FetchSessions lists the unexpired sessions of email.
+func FetchSessions(email string, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) ([]Session, error) {
+	all, err := query(email, table, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := []Session{}
+	for _, s := range all {
+		if expires, err := time.Parse(time.RFC3339, s.ExpiresAt); err == nil && now.Before(expires) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeAll ends every session of email, including expired sessions DynamoDB
+// has yet to remove.
+func RevokeAll(email string, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) error {
+	sessions, err := query(email, table, dynaClient)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if err := Revoke(email, s.ID, table, dynaClient); err != nil && err.Error() != ErrorSessionNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func query(email string, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) ([]Session, error) {
+	dynaClient = table.Client(dynaClient)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(table.Name),
+		IndexName:              aws.String(table.Index(EmailIndex)),
+		KeyConditionExpression: aws.String("email = :email"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":email": {S: aws.String(email)},
+		},
+	}
+	sessions := []Session{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchSession)
+		}
+		page := []Session{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalSession)
+		}
+		sessions = append(sessions, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return sessions, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Revoke ends the session with id, which must belong to email.
+func Revoke(email string, id string, table store.Table, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := table.Client(dynaClient).DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                       key(id),
+		TableName:                 aws.String(table.Name),
+		ConditionExpression:       aws.String("email = :email"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":email": {S: aws.String(email)}},
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrorSessionNotFound)
+		}
+		return store.Error(err, ErrorFailedToDeleteSession)
+	}
+	return nil
+}
+
+func key(id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(id)},
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
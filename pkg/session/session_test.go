@@ -0,0 +1,143 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// tableClient keeps the sessions table in memory.
+type tableClient struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newTableClient() *tableClient {
+	return &tableClient{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func (c *tableClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: c.items[*input.Key["id"].S]}, nil
+}
+
+func (c *tableClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	c.items[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *tableClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	email := *input.ExpressionAttributeValues[":email"].S
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, item := range c.items {
+		if *item["email"].S == email {
+			items = append(items, item)
+		}
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+func (c *tableClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	id := *input.Key["id"].S
+	item, ok := c.items[id]
+	if !ok || *item["email"].S != *input.ExpressionAttributeValues[":email"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+	}
+	delete(c.items, id)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestSessions(t *testing.T) {
+	device := Device{UserAgent: "curl/8.0", SourceIP: "192.0.2.1"}
+
+	t.Run("expect a session to be valid until it is revoked", func(t *testing.T) {
+		client := newTableClient()
+		s, err := Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, ok := client.items[s.ID]["token"]; ok {
+			t.Error("Expected the token not to be stored")
+		}
+		valid, err := Validate(s.Token, Table("test"), client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if valid.Email != "alan.oliver@ecs.co.uk" || valid.Device != device {
+			t.Errorf("Expected the session to be returned, got %+v", *valid)
+		}
+
+		if err := Revoke("alan.oliver@ecs.co.uk", s.ID, Table("test"), client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		_, err = Validate(s.Token, Table("test"), client)
+		if err == nil || err.Error() != ErrorInvalidSession {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidSession, err)
+		}
+	})
+	t.Run("expect another user's session not to be revoked", func(t *testing.T) {
+		client := newTableClient()
+		s, _ := Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+
+		err := Revoke("someone.else@ecs.co.uk", s.ID, Table("test"), client)
+		if err == nil || err.Error() != ErrorSessionNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorSessionNotFound, err)
+		}
+		if _, err := Validate(s.Token, Table("test"), client); err != nil {
+			t.Errorf("Expected the session to still be valid, got %s", err.Error())
+		}
+	})
+	t.Run("expect expired sessions to be invalid and not listed", func(t *testing.T) {
+		client := newTableClient()
+		Configure(Config{TTL: -time.Minute})
+		expired, _ := Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+		Configure(Config{TTL: 24 * time.Hour})
+		active, _ := Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+
+		_, err := Validate(expired.Token, Table("test"), client)
+		if err == nil || err.Error() != ErrorInvalidSession {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidSession, err)
+		}
+		sessions, err := FetchSessions("alan.oliver@ecs.co.uk", Table("test"), client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(sessions) != 1 || sessions[0].ID != active.ID {
+			t.Errorf("Expected only the active session to be listed, got %+v", sessions)
+		}
+	})
+	t.Run("expect every session of a user to be revoked", func(t *testing.T) {
+		client := newTableClient()
+		Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+		Create("alan.oliver@ecs.co.uk", device, Table("test"), client)
+		other, _ := Create("someone.else@ecs.co.uk", device, Table("test"), client)
+
+		if err := RevokeAll("alan.oliver@ecs.co.uk", Table("test"), client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(client.items) != 1 || client.items[other.ID] == nil {
+			t.Errorf("Expected only the other user's session to remain, got %d sessions", len(client.items))
+		}
+	})
+	t.Run("expect sessions to be listed from the configured index", func(t *testing.T) {
+		defer Configure(config)
+		Configure(Config{TTL: time.Hour, Table: []store.TableOption{store.WithIndex(EmailIndex, "email-index-v2")}})
+		client := &testutil.MockDynamoDB{}
+
+		if _, err := FetchSessions("alan.oliver@ecs.co.uk", Table("test"), client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		input := client.QueryInputs()[0]
+		if *input.TableName != "testSession" || *input.IndexName != "email-index-v2" {
+			t.Errorf("Expected the configured index of the sessions table, got %s on %s", *input.IndexName, *input.TableName)
+		}
+		if !Table("test").ConsistentRead {
+			t.Error("Expected sessions to be read consistently")
+		}
+	})
+}
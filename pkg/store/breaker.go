@@ -0,0 +1,140 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var ErrorUnavailable = "data store unavailable"
+
+// IsUnavailable reports whether err is a call rejected by an open breaker.
+func IsUnavailable(err error) bool {
+	return err != nil && err.Error() == ErrorUnavailable
+}
+
+// BreakerConfig sets when the breaker opens. Calls are counted over a fixed
+// Window; once at least MinRequests have been made and the failed fraction
+// reaches FailureRate the breaker opens and rejects every call for
+// OpenTimeout. It then lets HalfOpenRequests calls through and closes again
+// if all of them succeed.
+type BreakerConfig struct {
+	FailureRate      float64
+	MinRequests      int
+	Window           time.Duration
+	OpenTimeout      time.Duration
+	HalfOpenRequests int
+}
+
+// Breaker is a circuit breaker shared by every call a container makes to the
+// data store. Only failures of the store itself, such as throttling, server
+// errors and timeouts, count against it; a failed condition does not.
+type Breaker struct {
+	config BreakerConfig
+	now    func() time.Time
+
+	mu          sync.Mutex
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+func NewBreaker(config BreakerConfig) *Breaker {
+	if config.HalfOpenRequests < 1 {
+		config.HalfOpenRequests = 1
+	}
+	return &Breaker{config: config, now: time.Now, state: StateClosed}
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Allow returns an error when the call should be rejected without being
+// made. Every allowed call must be followed by Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	switch b.state {
+	case StateOpen:
+		return errors.New(ErrorUnavailable)
+	case StateHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return errors.New(ErrorUnavailable)
+		}
+		b.probes++
+	}
+	return nil
+}
+
+func (b *Breaker) Record(err error) {
+	failed := Retryable(err) || IsTimeout(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenRequests {
+			b.close()
+		}
+	case StateClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests && float64(b.failures) >= b.config.FailureRate*float64(b.requests) && b.failures > 0 {
+			b.open()
+		}
+	}
+}
+
+// advance moves the breaker on with time: a closed breaker starts a new
+// window and an open one turns half-open once its timeout has passed.
+func (b *Breaker) advance() {
+	now := b.now()
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	case StateOpen:
+		if now.Sub(b.openedAt) >= b.config.OpenTimeout {
+			b.state = StateHalfOpen
+			b.probes = 0
+			b.successes = 0
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = StateOpen
+	b.openedAt = b.now()
+}
+
+func (b *Breaker) close() {
+	b.state = StateClosed
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// BreakerClient rejects item reads and writes while its breaker is open, so
+// requests fail fast during an outage instead of waiting on the store.
+type BreakerClient struct {
+	dynamodbiface.DynamoDBAPI
+	breaker *Breaker
+}
+
+func NewBreakerClient(client dynamodbiface.DynamoDBAPI, breaker *Breaker) *BreakerClient {
+	return &BreakerClient{DynamoDBAPI: client, breaker: breaker}
+}
+
+func (c *BreakerClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.GetItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.BatchGetItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.BatchGetItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.Query(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.QueryWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.Scan(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.ScanWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.PutItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.PutItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.UpdateItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.UpdateItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.DeleteItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.DeleteItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.BatchWriteItem(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.BatchWriteItemWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) TransactGetItems(input *dynamodb.TransactGetItemsInput) (*dynamodb.TransactGetItemsOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.TransactGetItems(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) TransactGetItemsWithContext(ctx aws.Context, input *dynamodb.TransactGetItemsInput, opts ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.TransactGetItemsWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.TransactWriteItems(input)
+	c.breaker.Record(err)
+	return output, err
+}
+
+func (c *BreakerClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	output, err := c.DynamoDBAPI.TransactWriteItemsWithContext(ctx, input, opts...)
+	c.breaker.Record(err)
+	return output, err
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestBreaker(t *testing.T) {
+	throttled := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil)
+	newBreaker := func(now *time.Time) *Breaker {
+		b := NewBreaker(BreakerConfig{
+			FailureRate:      0.5,
+			MinRequests:      4,
+			Window:           time.Minute,
+			OpenTimeout:      10 * time.Second,
+			HalfOpenRequests: 2,
+		})
+		b.now = func() time.Time { return *now }
+		return b
+	}
+	call := func(b *Breaker, err error) error {
+		if allowErr := b.Allow(); allowErr != nil {
+			return allowErr
+		}
+		b.Record(err)
+		return err
+	}
+
+	t.Run("should open once the failure rate is reached", func(t *testing.T) {
+		now := time.Now()
+		b := newBreaker(&now)
+		call(b, nil)
+		call(b, throttled)
+		call(b, nil)
+		if b.State() != StateClosed {
+			t.Fatalf("expected the breaker to stay closed below the minimum requests, got %s", b.State())
+		}
+		call(b, throttled)
+		if b.State() != StateOpen {
+			t.Fatalf("expected the breaker to open, got %s", b.State())
+		}
+		if err := b.Allow(); !IsUnavailable(err) {
+			t.Errorf("expected calls to be rejected, got %v", err)
+		}
+	})
+	t.Run("should not count failures caused by the request", func(t *testing.T) {
+		now := time.Now()
+		b := newBreaker(&now)
+		for i := 0; i < 4; i++ {
+			call(b, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil))
+		}
+		if b.State() != StateClosed {
+			t.Errorf("expected the breaker to stay closed, got %s", b.State())
+		}
+	})
+	t.Run("should forget failures from earlier windows", func(t *testing.T) {
+		now := time.Now()
+		b := newBreaker(&now)
+		call(b, throttled)
+		call(b, throttled)
+		now = now.Add(2 * time.Minute)
+		call(b, nil)
+		call(b, nil)
+		call(b, nil)
+		call(b, throttled)
+		if b.State() != StateClosed {
+			t.Errorf("expected the breaker to stay closed, got %s", b.State())
+		}
+	})
+	t.Run("should close after the trial calls succeed", func(t *testing.T) {
+		now := time.Now()
+		b := newBreaker(&now)
+		for i := 0; i < 4; i++ {
+			call(b, throttled)
+		}
+		now = now.Add(10 * time.Second)
+		if b.State() != StateHalfOpen {
+			t.Fatalf("expected the breaker to be half-open, got %s", b.State())
+		}
+		b.Allow()
+		b.Allow()
+		if err := b.Allow(); !IsUnavailable(err) {
+			t.Errorf("expected calls beyond the trial calls to be rejected, got %v", err)
+		}
+		b.Record(nil)
+		b.Record(nil)
+		if b.State() != StateClosed {
+			t.Errorf("expected the breaker to close, got %s", b.State())
+		}
+	})
+	t.Run("should open again when a trial call fails", func(t *testing.T) {
+		now := time.Now()
+		b := newBreaker(&now)
+		for i := 0; i < 4; i++ {
+			call(b, throttled)
+		}
+		now = now.Add(10 * time.Second)
+		call(b, errors.New("connection reset by peer"))
+		call(b, throttled)
+		if b.State() != StateOpen {
+			t.Errorf("expected the breaker to open again, got %s", b.State())
+		}
+	})
+}
+
+func TestBreakerClient(t *testing.T) {
+	t.Run("should fail fast without calling the store while open", func(t *testing.T) {
+		b := NewBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 1, Window: time.Minute, OpenTimeout: time.Minute})
+		b.Record(awserr.New(dynamodb.ErrCodeInternalServerError, "", nil))
+		dynamo := &testutil.MockDynamoDB{}
+		client := NewBreakerClient(dynamo, b)
+
+		_, err := client.GetItem(&dynamodb.GetItemInput{})
+		if !IsUnavailable(err) {
+			t.Errorf("expected error %q, got %v", ErrorUnavailable, err)
+		}
+		if calls := dynamo.Calls(); len(calls) != 0 {
+			t.Errorf("expected no calls to the store, got %v", calls)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DAXClient sends item reads and writes to a DAX cluster, so reads are served
+// from its cache and writes go through it to keep the cache coherent. Every
+// other call, such as table management, goes to DynamoDB.
+type DAXClient struct {
+	dynamodbiface.DynamoDBAPI
+	dax dynamodbiface.DynamoDBAPI
+}
+
+func NewDAXClient(dynamo dynamodbiface.DynamoDBAPI, dax dynamodbiface.DynamoDBAPI) *DAXClient {
+	return &DAXClient{DynamoDBAPI: dynamo, dax: dax}
+}
+
+func (c *DAXClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return c.dax.GetItem(input)
+}
+
+func (c *DAXClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return c.dax.GetItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return c.dax.BatchGetItem(input)
+}
+
+func (c *DAXClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	return c.dax.BatchGetItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return c.dax.Query(input)
+}
+
+func (c *DAXClient) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return c.dax.QueryWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return c.dax.Scan(input)
+}
+
+func (c *DAXClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	return c.dax.ScanWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return c.dax.PutItem(input)
+}
+
+func (c *DAXClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return c.dax.PutItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return c.dax.UpdateItem(input)
+}
+
+func (c *DAXClient) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return c.dax.UpdateItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return c.dax.DeleteItem(input)
+}
+
+func (c *DAXClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return c.dax.DeleteItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return c.dax.BatchWriteItem(input)
+}
+
+func (c *DAXClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	return c.dax.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) TransactGetItems(input *dynamodb.TransactGetItemsInput) (*dynamodb.TransactGetItemsOutput, error) {
+	return c.dax.TransactGetItems(input)
+}
+
+func (c *DAXClient) TransactGetItemsWithContext(ctx aws.Context, input *dynamodb.TransactGetItemsInput, opts ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	return c.dax.TransactGetItemsWithContext(ctx, input, opts...)
+}
+
+func (c *DAXClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return c.dax.TransactWriteItems(input)
+}
+
+func (c *DAXClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	return c.dax.TransactWriteItemsWithContext(ctx, input, opts...)
+}
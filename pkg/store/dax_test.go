@@ -0,0 +1,37 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func operations(client *testutil.MockDynamoDB) []string {
+	operations := []string{}
+	for _, call := range client.Calls() {
+		operations = append(operations, call.Operation)
+	}
+	return operations
+}
+
+func TestDAXClient(t *testing.T) {
+	t.Run("should send item operations to DAX and everything else to DynamoDB", func(t *testing.T) {
+		dynamo := &testutil.MockDynamoDB{}
+		dax := &testutil.MockDynamoDB{}
+		client := NewDAXClient(dynamo, dax)
+
+		client.GetItem(&dynamodb.GetItemInput{})
+		client.Scan(&dynamodb.ScanInput{})
+		client.PutItem(&dynamodb.PutItemInput{})
+		client.DescribeTable(&dynamodb.DescribeTableInput{})
+
+		if calls := operations(dax); len(calls) != 3 || calls[0] != "GetItem" || calls[1] != "Scan" || calls[2] != "PutItem" {
+			t.Errorf("expected DAX to serve GetItem, Scan and PutItem, got %v", calls)
+		}
+		if calls := operations(dynamo); len(calls) != 1 || calls[0] != "DescribeTable" {
+			t.Errorf("expected DynamoDB to serve DescribeTable, got %v", calls)
+		}
+	})
+}
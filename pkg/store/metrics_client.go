@@ -0,0 +1,175 @@
+package store
+
+import (
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// MetricDynamoDBLatency is how long each item read and write took, by
+// operation and outcome.
+const MetricDynamoDBLatency = "DynamoDBLatency"
+
+// MetricsClient records how long each item read and write takes, retries
+// included, so slow operations show up in metrics.
+type MetricsClient struct {
+	dynamodbiface.DynamoDBAPI
+	now func() time.Time
+}
+
+func NewMetricsClient(client dynamodbiface.DynamoDBAPI) *MetricsClient {
+	return &MetricsClient{DynamoDBAPI: client, now: time.Now}
+}
+
+func (c *MetricsClient) record(operation string, start time.Time, err error) {
+	outcome := metrics.OutcomeSuccess
+	if err != nil {
+		outcome = metrics.OutcomeError
+	}
+	metrics.Duration(MetricDynamoDBLatency, c.now().Sub(start), metrics.Dimensions{"Operation": operation, "Outcome": outcome})
+}
+
+func (c *MetricsClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.GetItem(input)
+	c.record("GetItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	c.record("GetItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.BatchGetItem(input)
+	c.record("BatchGetItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.BatchGetItemWithContext(ctx, input, opts...)
+	c.record("BatchGetItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.Query(input)
+	c.record("Query", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.QueryWithContext(ctx, input, opts...)
+	c.record("Query", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.Scan(input)
+	c.record("Scan", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.ScanWithContext(ctx, input, opts...)
+	c.record("Scan", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.PutItem(input)
+	c.record("PutItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.PutItemWithContext(ctx, input, opts...)
+	c.record("PutItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.UpdateItem(input)
+	c.record("UpdateItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.UpdateItemWithContext(ctx, input, opts...)
+	c.record("UpdateItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.DeleteItem(input)
+	c.record("DeleteItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.DeleteItemWithContext(ctx, input, opts...)
+	c.record("DeleteItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.BatchWriteItem(input)
+	c.record("BatchWriteItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.BatchWriteItemWithContext(ctx, input, opts...)
+	c.record("BatchWriteItem", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) TransactGetItems(input *dynamodb.TransactGetItemsInput) (*dynamodb.TransactGetItemsOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.TransactGetItems(input)
+	c.record("TransactGetItems", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) TransactGetItemsWithContext(ctx aws.Context, input *dynamodb.TransactGetItemsInput, opts ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.TransactGetItemsWithContext(ctx, input, opts...)
+	c.record("TransactGetItems", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.TransactWriteItems(input)
+	c.record("TransactWriteItems", start, err)
+	return output, err
+}
+
+func (c *MetricsClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	start := c.now()
+	output, err := c.DynamoDBAPI.TransactWriteItemsWithContext(ctx, input, opts...)
+	c.record("TransactWriteItems", start, err)
+	return output, err
+}
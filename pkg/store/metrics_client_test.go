@@ -0,0 +1,46 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestMetricsClient(t *testing.T) {
+	var out bytes.Buffer
+	metrics.Configure(metrics.Config{Writer: &out})
+	defer metrics.Configure(metrics.Config{})
+
+	t.Run("should record each call's latency by operation and outcome", func(t *testing.T) {
+		inner := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+		client := NewMetricsClient(inner)
+		clock := time.Now()
+		client.now = func() time.Time {
+			clock = clock.Add(20 * time.Millisecond)
+			return clock
+		}
+		client.GetItem(&dynamodb.GetItemInput{})
+		inner.GetItemErr = errors.New("boom")
+		client.GetItem(&dynamodb.GetItemInput{})
+		metrics.Flush()
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		if len(lines) != 2 {
+			t.Fatalf("expected a line for each outcome, got %q", out.String())
+		}
+		for i, outcome := range []string{metrics.OutcomeSuccess, metrics.OutcomeError} {
+			var entry map[string]interface{}
+			json.Unmarshal(lines[i], &entry)
+			if entry["Operation"] != "GetItem" || entry["Outcome"] != outcome || entry[MetricDynamoDBLatency] != 20.0 {
+				t.Errorf("expected a %s GetItem taking 20ms, got %s", outcome, lines[i])
+			}
+		}
+	})
+}
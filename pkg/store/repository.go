@@ -0,0 +1,285 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorCouldNotMarshalItem   = "could not marshal item"
+	ErrorCouldNotUnmarshalItem = "could not unmarshal item"
+)
+
+// IsConditionFailed reports whether err is a write refused by its condition.
+func IsConditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// KeySpec describes how an entity's items are keyed: the attributes of the
+// table's partition key and, for a composite key, its sort key, and the
+// prefixes the values are stored with. Prefixes let several entities share
+// a table, such as a group and its members.
+type KeySpec struct {
+	PartitionKey    string
+	PartitionPrefix string
+	// SortKey is empty for a table keyed by its partition key alone
+	SortKey    string
+	SortPrefix string
+	// Index, when set, is the index List queries for the entity's items: one
+	// keyed by SortKey, such as SortKeyIndex, for an entity stored under a
+	// fixed sort key
+	Index string
+}
+
+// Key is the key of one item, as the entity knows it: without the prefixes
+// its KeySpec stores it with. An item whose sort key is always SortPrefix,
+// such as the one describing a group, leaves Sort empty.
+type Key struct {
+	Partition string
+	Sort      string
+}
+
+// Item returns key as the key attributes of an item.
+func (k KeySpec) Item(key Key) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		k.PartitionKey: {S: aws.String(k.PartitionPrefix + key.Partition)},
+	}
+	if k.SortKey != "" {
+		item[k.SortKey] = &dynamodb.AttributeValue{S: aws.String(k.SortPrefix + key.Sort)}
+	}
+	return item
+}
+
+// ItemErrors are the messages an entity reports an item that could not be
+// marshalled or unmarshalled with, such as "failed to marshal group".
+type ItemErrors struct {
+	Marshal   string
+	Unmarshal string
+}
+
+// itemError is an item the repository could not marshal or unmarshal. Error
+// reports it as it is, rather than as the message of the call that failed.
+type itemError struct{ message string }
+
+func (e *itemError) Error() string {
+	return e.message
+}
+
+// Repository reads and writes the items of one entity, T, which is marshalled
+// by its json tags as the rest of the store's items are. The key attributes
+// are added to T's own by the repository, so T need not carry them. New
+// entities are added as a struct and a KeySpec rather than another copy of
+// the Get, Put, Scan and Delete plumbing, and report what fails with Error.
+type Repository[T any] struct {
+	Table Table
+	Keys  KeySpec
+	// KeyOf returns the key an item is stored under
+	KeyOf func(item T) Key
+	// Errors, when set, replace ErrorCouldNotMarshalItem and
+	// ErrorCouldNotUnmarshalItem with the entity's own messages
+	Errors ItemErrors
+	client dynamodbiface.DynamoDBAPI
+}
+
+// NewRepository returns the repository of the items of table keyed by keys,
+// reached through client.
+func NewRepository[T any](table Table, keys KeySpec, keyOf func(item T) Key, client dynamodbiface.DynamoDBAPI) *Repository[T] {
+	return &Repository[T]{Table: table, Keys: keys, KeyOf: keyOf, client: table.Client(client)}
+}
+
+// Get reads the item at key, returning nil when there is none.
+func (r *Repository[T]) Get(key Key) (*T, error) {
+	result, err := r.client.GetItem(&dynamodb.GetItemInput{
+		Key:            r.Keys.Item(key),
+		TableName:      aws.String(r.Table.Name),
+		ConsistentRead: aws.Bool(r.Table.ConsistentRead),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	item := new(T)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, item); err != nil {
+		return nil, r.unmarshalError()
+	}
+	return item, nil
+}
+
+// Put writes item whole, only when condition holds if one is given.
+// Attributes are named in condition by their placeholders, such as #pk. A
+// refused write fails with an error IsConditionFailed reports.
+func (r *Repository[T]) Put(item T, condition string) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return r.marshalError()
+	}
+	for name, value := range r.Keys.Item(r.KeyOf(item)) {
+		av[name] = value
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(r.Table.Name),
+	}
+	if condition != "" {
+		input.ConditionExpression = aws.String(condition)
+		input.ExpressionAttributeNames = Names(condition)
+	}
+	_, err = r.client.PutItem(input)
+	return err
+}
+
+// Create writes item unless there is already one at its key.
+func (r *Repository[T]) Create(item T) error {
+	return r.Put(item, "attribute_not_exists(#"+r.Keys.PartitionKey+")")
+}
+
+// Replace writes item over the one at its key, failing when there is none.
+func (r *Repository[T]) Replace(item T) error {
+	return r.Put(item, "attribute_exists(#"+r.Keys.PartitionKey+")")
+}
+
+// Delete deletes the item at key. Deleting an item that is not there
+// succeeds.
+func (r *Repository[T]) Delete(key Key) error {
+	_, err := r.client.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:       r.Keys.Item(key),
+		TableName: aws.String(r.Table.Name),
+	})
+	return err
+}
+
+// List reads every item of the entity: with a Query of Keys.Index when it
+// has one, or else with a scan recorded as ScanList.
+func (r *Repository[T]) List() ([]T, error) {
+	if r.Keys.Index == "" {
+		return r.Scan(ScanList)
+	}
+	return r.query(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.Table.Name),
+		IndexName:                 aws.String(r.Table.Index(r.Keys.Index)),
+		KeyConditionExpression:    aws.String("#sk = :sk"),
+		ExpressionAttributeNames:  map[string]*string{"#sk": aws.String(r.Keys.SortKey)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":sk": {S: aws.String(r.Keys.SortPrefix)}},
+	})
+}
+
+// Scan reads every item of the entity, leaving out the items of others
+// sharing the table by their prefixes. The scan is recorded for reason, one
+// of the Scan reasons.
+func (r *Repository[T]) Scan(reason string) ([]T, error) {
+	RecordScan(r.Table.Name, reason)
+	input := &dynamodb.ScanInput{TableName: aws.String(r.Table.Name)}
+	if filter, names, values := r.prefixes(); filter != "" {
+		input.FilterExpression = aws.String(filter)
+		input.ExpressionAttributeNames = names
+		input.ExpressionAttributeValues = values
+	}
+	items := []T{}
+	for {
+		result, err := r.client.Scan(input)
+		if err != nil {
+			return nil, err
+		}
+		if items, err = appendItems(items, result.Items); err != nil {
+			return nil, r.unmarshalError()
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Query reads the items of the entity stored under partition, in order of
+// their sort keys.
+func (r *Repository[T]) Query(partition string) ([]T, error) {
+	condition := "#pk = :pk"
+	names := map[string]*string{"#pk": aws.String(r.Keys.PartitionKey)}
+	values := map[string]*dynamodb.AttributeValue{":pk": {S: aws.String(r.Keys.PartitionPrefix + partition)}}
+	if r.Keys.SortKey != "" && r.Keys.SortPrefix != "" {
+		condition += " AND begins_with(#sk, :sk)"
+		names["#sk"] = aws.String(r.Keys.SortKey)
+		values[":sk"] = &dynamodb.AttributeValue{S: aws.String(r.Keys.SortPrefix)}
+	}
+	return r.query(&dynamodb.QueryInput{
+		TableName:                 aws.String(r.Table.Name),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ConsistentRead:            aws.Bool(r.Table.ConsistentRead),
+	})
+}
+
+// query reads every page input queries.
+func (r *Repository[T]) query(input *dynamodb.QueryInput) ([]T, error) {
+	items := []T{}
+	for {
+		result, err := r.client.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		if items, err = appendItems(items, result.Items); err != nil {
+			return nil, r.unmarshalError()
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func (r *Repository[T]) marshalError() error {
+	if r.Errors.Marshal == "" {
+		return &itemError{ErrorCouldNotMarshalItem}
+	}
+	return &itemError{r.Errors.Marshal}
+}
+
+func (r *Repository[T]) unmarshalError() error {
+	if r.Errors.Unmarshal == "" {
+		return &itemError{ErrorCouldNotUnmarshalItem}
+	}
+	return &itemError{r.Errors.Unmarshal}
+}
+
+// prefixes returns the filter matching the entity's items by the prefixes
+// of their keys, or an empty filter when it has no prefixes.
+func (r *Repository[T]) prefixes() (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	filter := ""
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	for _, key := range []struct{ placeholder, name, prefix string }{
+		{"pk", r.Keys.PartitionKey, r.Keys.PartitionPrefix},
+		{"sk", r.Keys.SortKey, r.Keys.SortPrefix},
+	} {
+		if key.name == "" || key.prefix == "" {
+			continue
+		}
+		if filter != "" {
+			filter += " AND "
+		}
+		filter += "begins_with(#" + key.placeholder + ", :" + key.placeholder + ")"
+		names["#"+key.placeholder] = aws.String(key.name)
+		values[":"+key.placeholder] = &dynamodb.AttributeValue{S: aws.String(key.prefix)}
+	}
+	return filter, names, values
+}
+
+func appendItems[T any](items []T, page []map[string]*dynamodb.AttributeValue) ([]T, error) {
+	for _, av := range page {
+		var item T
+		if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
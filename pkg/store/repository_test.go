@@ -0,0 +1,130 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type widget struct {
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+}
+
+func TestRepository(t *testing.T) {
+	keys := KeySpec{PartitionKey: "pk", PartitionPrefix: "OWNER#", SortKey: "sk", SortPrefix: "WIDGET#"}
+	newRepository := func(client *testutil.MockDynamoDB) *Repository[widget] {
+		return NewRepository(NewTable("widgets"), keys, func(w widget) Key {
+			return Key{Partition: w.Owner, Sort: w.Name}
+		}, client)
+	}
+
+	t.Run("should put an item under its prefixed key", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		if err := newRepository(client).Create(widget{Owner: "alan", Name: "gear"}); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		input := client.PutItemInputs()[0]
+		if *input.Item["pk"].S != "OWNER#alan" || *input.Item["sk"].S != "WIDGET#gear" || *input.Item["name"].S != "gear" {
+			t.Errorf("expected the key alongside the item, got %v", input.Item)
+		}
+		if *input.ConditionExpression != "attribute_not_exists(#pk)" || *input.ExpressionAttributeNames["#pk"] != "pk" {
+			t.Errorf("expected the write conditioned on there being no item, got %s", *input.ConditionExpression)
+		}
+	})
+	t.Run("should report a refused write as a failed condition", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{PutItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil)}
+		if err := newRepository(client).Replace(widget{Owner: "alan", Name: "gear"}); !IsConditionFailed(err) {
+			t.Errorf("expected a failed condition, got %v", err)
+		}
+	})
+	t.Run("should read nil when there is no item", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		got, err := newRepository(client).Get(Key{Partition: "alan", Sort: "gear"})
+		if err != nil || got != nil {
+			t.Errorf("expected nothing, got %v and %v", got, err)
+		}
+		if key := client.GetItemInputs()[0].Key; *key["pk"].S != "OWNER#alan" || *key["sk"].S != "WIDGET#gear" {
+			t.Errorf("expected the prefixed key, got %v", key)
+		}
+	})
+	t.Run("should report an item that cannot be read as the entity's own error", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		client.GetItemFunc = func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"name": {L: []*dynamodb.AttributeValue{}}}}, nil
+		}
+		repository := newRepository(client)
+		repository.Errors = ItemErrors{Unmarshal: "failed to unmarshal widget"}
+		_, err := repository.Get(Key{Partition: "alan", Sort: "gear"})
+		if err = Error(err, "failed to fetch widget"); err == nil || err.Error() != "failed to unmarshal widget" {
+			t.Errorf("expected error %s, got %v", "failed to unmarshal widget", err)
+		}
+	})
+	t.Run("should scan every page of the entity's items", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		client.ScanFunc = func(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			if *input.FilterExpression != "begins_with(#pk, :pk) AND begins_with(#sk, :sk)" {
+				t.Errorf("expected the items filtered by their prefixes, got %s", *input.FilterExpression)
+			}
+			output := &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{{"name": {S: aws.String("gear")}}}}
+			if input.ExclusiveStartKey == nil {
+				output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"pk": {S: aws.String("OWNER#alan")}}
+			}
+			return output, nil
+		}
+		got, err := newRepository(client).Scan(ScanExport)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if len(got) != 2 || got[1].Name != "gear" {
+			t.Errorf("expected an item from each page, got %v", got)
+		}
+	})
+	t.Run("should list the items under a fixed sort key from its index", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		table := NewTable("widgets", WithIndex(SortKeyIndex, "sk-index-v2"))
+		listed := KeySpec{PartitionKey: "pk", PartitionPrefix: "WIDGET#", SortKey: "sk", SortPrefix: "METADATA", Index: SortKeyIndex}
+		if _, err := NewRepository(table, listed, func(w widget) Key { return Key{Partition: w.Name} }, client).List(); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if client.Count("Scan") != 0 {
+			t.Fatalf("expected no scan, got %d", client.Count("Scan"))
+		}
+		input := client.QueryInputs()[0]
+		if *input.IndexName != "sk-index-v2" || *input.KeyConditionExpression != "#sk = :sk" || *input.ExpressionAttributeValues[":sk"].S != "METADATA" {
+			t.Errorf("expected the items under METADATA from the deployed index, got %s", *input.KeyConditionExpression)
+		}
+	})
+	t.Run("should record a scan when there is no index to list from", func(t *testing.T) {
+		var out bytes.Buffer
+		metrics.Configure(metrics.Config{Writer: &out})
+		defer metrics.Configure(metrics.Config{})
+		client := &testutil.MockDynamoDB{}
+		if _, err := newRepository(client).List(); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		metrics.Flush()
+		var entry map[string]interface{}
+		json.Unmarshal(bytes.TrimSpace(out.Bytes()), &entry)
+		if client.Count("Scan") != 1 || entry["Table"] != "widgets" || entry["Reason"] != ScanList || entry[MetricScans] != 1.0 {
+			t.Errorf("expected the scan to be recorded as a list, got %s", out.String())
+		}
+	})
+	t.Run("should query the entity's items in a partition", func(t *testing.T) {
+		client := &testutil.MockDynamoDB{}
+		if _, err := newRepository(client).Query("alan"); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		input := client.QueryInputs()[0]
+		if *input.KeyConditionExpression != "#pk = :pk AND begins_with(#sk, :sk)" || *input.ExpressionAttributeValues[":sk"].S != "WIDGET#" {
+			t.Errorf("expected the widgets of the partition, got %s", *input.KeyConditionExpression)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var ErrorThrottled = "data store throttled"
+
+// IsThrottled reports whether err is a call DynamoDB refused for exceeding
+// the table's throughput or the account's request rate.
+func IsThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err.Error() == ErrorThrottled {
+		return true
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded,
+			"ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
+
+// maxDeadlineRetries bounds the retries of a throttled call made under a
+// deadline, however far off the deadline is.
+const maxDeadlineRetries = 50
+
+// RetryConfig is the retry policy for DynamoDB calls. The delay before retry
+// n is BaseDelay doubled n times, capped at MaxDelay, of which the Jitter
+// fraction is randomised: 0 waits exactly that long, 1 waits anywhere up to it.
+// With UntilDeadline, throttled calls made under a deadline are retried past
+// MaxAttempts for as long as the deadline leaves room for the next delay.
+type RetryConfig struct {
+	MaxAttempts   int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Jitter        float64
+	UntilDeadline bool
+}
+
+// Retryer applies a RetryConfig in place of the SDK's default retryer and
+// counts the retries it makes and the throttled calls it sees.
+type Retryer struct {
+	config    RetryConfig
+	random    func() float64
+	now       func() time.Time
+	retries   uint64
+	throttles uint64
+}
+
+func NewRetryer(config RetryConfig) *Retryer {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.Jitter < 0 {
+		config.Jitter = 0
+	}
+	if config.Jitter > 1 {
+		config.Jitter = 1
+	}
+	return &Retryer{config: config, random: rand.Float64, now: time.Now}
+}
+
+// MaxRetries is the most retries the SDK may make of a call. ShouldRetry
+// stops throttled calls retried until their deadline sooner.
+func (r *Retryer) MaxRetries() int {
+	if r.config.UntilDeadline {
+		return maxDeadlineRetries
+	}
+	return r.config.MaxAttempts - 1
+}
+
+// RetryRules is called by the SDK once for each retry it is about to make.
+func (r *Retryer) RetryRules(req *request.Request) time.Duration {
+	atomic.AddUint64(&r.retries, 1)
+	return r.delay(req.RetryCount)
+}
+
+func (r *Retryer) ShouldRetry(req *request.Request) bool {
+	throttled := IsThrottled(req.Error)
+	if throttled {
+		atomic.AddUint64(&r.throttles, 1)
+	}
+	if req.RetryCount >= r.config.MaxAttempts-1 {
+		return throttled && r.config.UntilDeadline && r.beforeDeadline(req)
+	}
+	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode >= 500 {
+		return true
+	}
+	return Retryable(req.Error)
+}
+
+// TakeRetries returns the number of retries made since it was last called.
+func (r *Retryer) TakeRetries() uint64 {
+	return atomic.SwapUint64(&r.retries, 0)
+}
+
+// TakeThrottles returns the number of throttled calls seen since it was last
+// called, whether or not they were retried.
+func (r *Retryer) TakeThrottles() uint64 {
+	return atomic.SwapUint64(&r.throttles, 0)
+}
+
+// beforeDeadline reports whether the longest delay before the next retry of
+// req ends before its deadline. A call without a deadline has no room.
+func (r *Retryer) beforeDeadline(req *request.Request) bool {
+	deadline, ok := req.Context().Deadline()
+	return ok && r.now().Add(r.backoff(req.RetryCount)).Before(deadline)
+}
+
+func (r *Retryer) delay(retryCount int) time.Duration {
+	delay := r.backoff(retryCount)
+	jitter := float64(delay) * r.config.Jitter
+	return delay - time.Duration(jitter) + time.Duration(jitter*r.random())
+}
+
+// backoff is the delay before retry retryCount before jitter is applied.
+func (r *Retryer) backoff(retryCount int) time.Duration {
+	delay := r.config.BaseDelay
+	for i := 0; i < retryCount && (r.config.MaxDelay <= 0 || delay < r.config.MaxDelay); i++ {
+		delay *= 2
+	}
+	if r.config.MaxDelay > 0 && delay > r.config.MaxDelay {
+		delay = r.config.MaxDelay
+	}
+	return delay
+}
+
+// Retryable reports whether a failed DynamoDB call may succeed if repeated:
+// throttling, transaction conflicts and server side or connection errors are
+// retried, while errors caused by the request itself are not.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded,
+			dynamodb.ErrCodeTransactionConflictException,
+			dynamodb.ErrCodeInternalServerError,
+			"ThrottlingException",
+			"ServiceUnavailable":
+			return true
+		case dynamodb.ErrCodeConditionalCheckFailedException,
+			dynamodb.ErrCodeResourceNotFoundException,
+			dynamodb.ErrCodeTransactionCanceledException,
+			"ValidationException",
+			"AccessDeniedException":
+			return false
+		}
+	}
+	return request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+}
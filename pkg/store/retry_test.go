@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"throttled":          {awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil), true},
+		"request limit":      {awserr.New(dynamodb.ErrCodeRequestLimitExceeded, "", nil), true},
+		"server error":       {awserr.New(dynamodb.ErrCodeInternalServerError, "", nil), true},
+		"conflict":           {awserr.New(dynamodb.ErrCodeTransactionConflictException, "", nil), true},
+		"condition failed":   {awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil), false},
+		"validation":         {awserr.New("ValidationException", "", nil), false},
+		"missing table":      {awserr.New(dynamodb.ErrCodeResourceNotFoundException, "", nil), false},
+		"connection":         {awserr.New(request.ErrCodeSerialization, "", errors.New("connection reset by peer")), true},
+		"unclassified error": {awserr.New("UnrecognizedClientException", "", nil), false},
+		"no error":           {nil, false},
+	}
+	for name, c := range cases {
+		if got := Retryable(c.err); got != c.retryable {
+			t.Errorf("%s: expected retryable %t, got %t", name, c.retryable, got)
+		}
+	}
+}
+
+func TestRetryer(t *testing.T) {
+	t.Run("should back off exponentially up to the maximum delay", func(t *testing.T) {
+		r := NewRetryer(RetryConfig{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+		expected := []time.Duration{10, 20, 40, 50, 50}
+		for i, delay := range expected {
+			if got := r.delay(i); got != delay*time.Millisecond {
+				t.Errorf("expected retry %d to wait %s, got %s", i, delay*time.Millisecond, got)
+			}
+		}
+	})
+	t.Run("should randomise the jitter fraction of the delay", func(t *testing.T) {
+		r := NewRetryer(RetryConfig{MaxAttempts: 2, BaseDelay: 100 * time.Millisecond, Jitter: 0.5})
+		r.random = func() float64 { return 0 }
+		if got := r.delay(0); got != 50*time.Millisecond {
+			t.Errorf("expected the shortest delay to be 50ms, got %s", got)
+		}
+		r.random = func() float64 { return 1 }
+		if got := r.delay(0); got != 100*time.Millisecond {
+			t.Errorf("expected the longest delay to be 100ms, got %s", got)
+		}
+	})
+	t.Run("should retry server errors and count retries", func(t *testing.T) {
+		r := NewRetryer(RetryConfig{MaxAttempts: 3})
+		if r.MaxRetries() != 2 {
+			t.Errorf("expected 2 retries, got %d", r.MaxRetries())
+		}
+		req := &request.Request{HTTPResponse: &http.Response{StatusCode: 503}}
+		if !r.ShouldRetry(req) {
+			t.Error("expected a 503 to be retried")
+		}
+		r.RetryRules(req)
+		r.RetryRules(req)
+		if got := r.TakeRetries(); got != 2 {
+			t.Errorf("expected 2 retries, got %d", got)
+		}
+		if got := r.TakeRetries(); got != 0 {
+			t.Errorf("expected the count to reset, got %d", got)
+		}
+	})
+	t.Run("should retry throttled calls until the deadline and count them", func(t *testing.T) {
+		now := time.Now()
+		r := NewRetryer(RetryConfig{MaxAttempts: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: 100 * time.Millisecond, UntilDeadline: true})
+		r.now = func() time.Time { return now }
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+		defer cancel()
+		req := &request.Request{HTTPRequest: &http.Request{}, Error: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil), RetryCount: 5}
+		req.SetContext(ctx)
+		if !r.ShouldRetry(req) {
+			t.Error("expected a throttled call to be retried before its deadline")
+		}
+		now = now.Add(950 * time.Millisecond)
+		if r.ShouldRetry(req) {
+			t.Error("expected a throttled call not to be retried past its deadline")
+		}
+		req.Error = awserr.New(dynamodb.ErrCodeInternalServerError, "", nil)
+		if r.ShouldRetry(req) {
+			t.Error("expected other errors to stop at the maximum attempts")
+		}
+		if got := r.TakeThrottles(); got != 2 {
+			t.Errorf("expected 2 throttles, got %d", got)
+		}
+	})
+	t.Run("should stop retrying throttled calls without a deadline", func(t *testing.T) {
+		r := NewRetryer(RetryConfig{MaxAttempts: 2, UntilDeadline: true})
+		req := &request.Request{Error: awserr.New("ThrottlingException", "", nil), RetryCount: 1}
+		if r.ShouldRetry(req) {
+			t.Error("expected a call without a deadline to stop at the maximum attempts")
+		}
+	})
+}
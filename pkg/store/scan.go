@@ -0,0 +1,36 @@
+package store
+
+import (
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/metrics"
+)
+
+// MetricScans counts the scans started of each table, by the reason they
+// were made. Lists are read with a Query wherever the keys allow one, so
+// scans are left to exports, rebuilds, migrations and the lists no key
+// narrows; a rise in them is worth a look before it shows up as throttling.
+const MetricScans = "Scans"
+
+// Reasons a table is scanned, recorded as the Reason dimension of
+// MetricScans.
+const (
+	// ScanList is a list whose items no key narrows, such as every user
+	ScanList = "list"
+	// ScanExport reads a table whole to copy it elsewhere
+	ScanExport = "export"
+	// ScanRebuild reads a table whole to rebuild what is derived from it
+	ScanRebuild = "rebuild"
+	// ScanMigrate reads a table whole to change its items
+	ScanMigrate = "migrate"
+)
+
+// SortKeyIndex is the index tables shared by several entities are deployed
+// with: keyed by their sort key and then their partition key, so the items
+// stored under the same fixed sort key, such as every group's METADATA, are
+// read with a Query rather than a scan filtering out everything else.
+const SortKeyIndex = "sk-index"
+
+// RecordScan records a scan of tableName starting for reason, one of the
+// Scan reasons. It is recorded once per scan rather than per page.
+func RecordScan(tableName string, reason string) {
+	metrics.Count(MetricScans, metrics.Dimensions{"Table": tableName, "Reason": reason})
+}
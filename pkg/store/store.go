@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/aws/aws-dax-go/dax"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type Config struct {
+	// DAXEndpoint is the cluster discovery endpoint, e.g.
+	// my-cluster.abc123.dax-clusters.eu-west-2.amazonaws.com:8111. When empty
+	// every call goes straight to DynamoDB.
+	DAXEndpoint string
+	// Retryer replaces the SDK's default retry policy when set
+	Retryer *Retryer
+	// Breaker, when set, guards item reads and writes
+	Breaker *Breaker
+	// Deadlines, when set, bounds item reads and writes by the invocation's
+	// deadline
+	Deadlines *Deadlines
+	// Transport, when set, replaces the SDK's default HTTP transport
+	Transport *TransportConfig
+	// Metrics records the latency of item reads and writes
+	Metrics bool
+}
+
+// New returns the client the handlers use to reach the users table. Callers
+// only see dynamodbiface.DynamoDBAPI whether or not DAX sits in front of it.
+func New(awsSession *session.Session, config Config) (dynamodbiface.DynamoDBAPI, error) {
+	awsConfig := aws.NewConfig()
+	if config.Transport != nil {
+		awsConfig = awsConfig.WithHTTPClient(newHTTPClient(*config.Transport))
+	}
+	if config.Retryer != nil {
+		awsConfig = request.WithRetryer(awsConfig, config.Retryer)
+	}
+	var client dynamodbiface.DynamoDBAPI = dynamodb.New(awsSession, awsConfig)
+	if config.DAXEndpoint != "" {
+		daxClient, err := newDAX(awsSession, config)
+		if err != nil {
+			return nil, err
+		}
+		client = NewDAXClient(client, daxClient)
+	}
+	if config.Deadlines != nil {
+		client = NewTimeoutClient(client, config.Deadlines)
+	}
+	if config.Metrics {
+		client = NewMetricsClient(client)
+	}
+	if config.Breaker != nil {
+		client = NewBreakerClient(client, config.Breaker)
+	}
+	return client, nil
+}
+
+func newDAX(awsSession *session.Session, config Config) (dynamodbiface.DynamoDBAPI, error) {
+	daxConfig := dax.DefaultConfig()
+	daxConfig.HostPorts = []string{config.DAXEndpoint}
+	daxConfig.Region = *awsSession.Config.Region
+	if config.Retryer != nil {
+		// DAX retries by count alone, so it is held to MaxAttempts
+		daxConfig.ReadRetries = config.Retryer.config.MaxAttempts - 1
+		daxConfig.WriteRetries = config.Retryer.config.MaxAttempts - 1
+	}
+	daxClient, err := dax.New(daxConfig)
+	if err != nil {
+		return nil, err
+	}
+	return daxClient, nil
+}
+
+// Error reports a failed call to the store as message, unless the store was
+// throttled, unavailable or ran out of time, which callers answer
+// differently, or a Repository could not marshal or unmarshal the item,
+// which it has already described.
+func Error(err error, message string) error {
+	var item *itemError
+	if errors.As(err, &item) {
+		return err
+	}
+	if IsThrottled(err) {
+		return errors.New(ErrorThrottled)
+	}
+	if IsUnavailable(err) || IsTimeout(err) {
+		return err
+	}
+	return errors.New(message)
+}
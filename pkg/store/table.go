@@ -0,0 +1,75 @@
+package store
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Table is a table as the code reading and writing it sees it: its name, the
+// names its indexes are deployed under, whether reads of it are strongly
+// consistent and how long each call to it may take. It is built by NewTable
+// from options, so what a table carries can grow without changing the
+// signatures it is passed through.
+type Table struct {
+	Name string
+	// Indexes maps the names code queries indexes by to the names they are
+	// deployed under. Indexes it does not map are deployed under the name
+	// they are queried by.
+	Indexes map[string]string
+	// ConsistentRead makes item reads strongly consistent. Index queries are
+	// eventually consistent whatever it is set to.
+	ConsistentRead bool
+	// Timeout, when set, bounds each call made through Client
+	Timeout time.Duration
+}
+
+// TableOption sets an attribute of a Table.
+type TableOption func(*Table)
+
+// NewTable returns the table called name, with options applied in order.
+func NewTable(name string, options ...TableOption) Table {
+	t := Table{Name: name, Indexes: map[string]string{}}
+	for _, option := range options {
+		option(&t)
+	}
+	return t
+}
+
+// WithIndex deploys the index queried as name under index.
+func WithIndex(name string, index string) TableOption {
+	return func(t *Table) {
+		t.Indexes[name] = index
+	}
+}
+
+// WithConsistentRead sets whether item reads are strongly consistent.
+func WithConsistentRead(consistent bool) TableOption {
+	return func(t *Table) {
+		t.ConsistentRead = consistent
+	}
+}
+
+// WithTimeout bounds each call made through Client by timeout.
+func WithTimeout(timeout time.Duration) TableOption {
+	return func(t *Table) {
+		t.Timeout = timeout
+	}
+}
+
+// Index returns the name the index queried as name is deployed under.
+func (t Table) Index(name string) string {
+	if index, ok := t.Indexes[name]; ok {
+		return index
+	}
+	return name
+}
+
+// Client returns client bounded by the table's Timeout, or client itself
+// when it has none. A call that runs out of time fails with ErrorTimeout.
+func (t Table) Client(client dynamodbiface.DynamoDBAPI) dynamodbiface.DynamoDBAPI {
+	if t.Timeout <= 0 {
+		return client
+	}
+	return NewTimeoutClient(client, NewDeadlines(TimeoutConfig{OperationTimeout: t.Timeout}))
+}
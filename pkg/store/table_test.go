@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestTable(t *testing.T) {
+	t.Run("should apply options in order", func(t *testing.T) {
+		table := NewTable("users", WithConsistentRead(true), WithIndex("email-index", "email-index-v2"), WithConsistentRead(false))
+		if table.Name != "users" || table.ConsistentRead {
+			t.Errorf("expected the last consistency set, got %+v", table)
+		}
+		if got := table.Index("email-index"); got != "email-index-v2" {
+			t.Errorf("expected the deployed index name, got %q", got)
+		}
+		if got := table.Index("name-index"); got != "name-index" {
+			t.Errorf("expected an unmapped index by its own name, got %q", got)
+		}
+	})
+	t.Run("should bound calls only when a timeout is set", func(t *testing.T) {
+		slow := &slowClient{delay: time.Second}
+		if NewTable("users").Client(slow) != slow {
+			t.Error("expected the client itself without a timeout")
+		}
+		_, err := NewTable("users", WithTimeout(10*time.Millisecond)).Client(slow).Scan(&dynamodb.ScanInput{})
+		if !IsTimeout(err) {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+	})
+}
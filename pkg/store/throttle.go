@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ScanThrottle paces scans to a target rate of read capacity units a second,
+// so that an export or rebuild reading a provisioned table whole leaves the
+// rest of its capacity to the requests being served. It is paced by the
+// capacity each page reports consuming, and is shared by every scan it
+// throttles, so jobs running at once keep to the rate between them.
+type ScanThrottle struct {
+	rate float64
+
+	mu   sync.Mutex
+	next time.Time
+	// perItem is the capacity the last page consumed for each item it read
+	perItem float64
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// NewScanThrottle returns a throttle keeping the scans it paces to
+// readCapacity units a second.
+func NewScanThrottle(readCapacity float64) *ScanThrottle {
+	return &ScanThrottle{rate: readCapacity, now: time.Now, sleep: sleep}
+}
+
+// limit returns the number of items a page should read for it to consume
+// about a second of the rate, learnt from the page before, or limit itself
+// when that is smaller. A page consuming more than a second's capacity at
+// once reaches the table as a burst however long the scan then waits.
+func (t *ScanThrottle) limit(limit *int64) *int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.perItem <= 0 {
+		return limit
+	}
+	items := int64(t.rate / t.perItem)
+	if items < 1 {
+		items = 1
+	}
+	if limit != nil && *limit <= items {
+		return limit
+	}
+	return aws.Int64(items)
+}
+
+// consumed records a page reading scanned items for capacity units, and
+// waits until the rate allows for them. A scan that spent nothing since the
+// last page carries on at once.
+func (t *ScanThrottle) consumed(ctx context.Context, capacity float64, scanned int64) error {
+	if capacity <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	if scanned > 0 {
+		t.perItem = capacity / float64(scanned)
+	}
+	now := t.now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(capacity / t.rate * float64(time.Second)))
+	wait := t.next.Sub(now)
+	t.mu.Unlock()
+	return t.sleep(ctx, wait)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ThrottledClient paces the scans made through it by its ScanThrottle,
+// asking for the capacity each page consumed. Other calls pass straight
+// through.
+type ThrottledClient struct {
+	dynamodbiface.DynamoDBAPI
+	throttle *ScanThrottle
+}
+
+func NewThrottledClient(client dynamodbiface.DynamoDBAPI, throttle *ScanThrottle) *ThrottledClient {
+	return &ThrottledClient{DynamoDBAPI: client, throttle: throttle}
+}
+
+func (c *ThrottledClient) input(input *dynamodb.ScanInput) *dynamodb.ScanInput {
+	throttled := *input
+	throttled.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	throttled.Limit = c.throttle.limit(input.Limit)
+	return &throttled
+}
+
+func (c *ThrottledClient) wait(ctx context.Context, output *dynamodb.ScanOutput) error {
+	if output.ConsumedCapacity == nil {
+		return nil
+	}
+	return c.throttle.consumed(ctx, aws.Float64Value(output.ConsumedCapacity.CapacityUnits), aws.Int64Value(output.ScannedCount))
+}
+
+func (c *ThrottledClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	output, err := c.DynamoDBAPI.Scan(c.input(input))
+	if err != nil {
+		return output, err
+	}
+	return output, c.wait(context.Background(), output)
+}
+
+func (c *ThrottledClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	output, err := c.DynamoDBAPI.ScanWithContext(ctx, c.input(input), opts...)
+	if err != nil {
+		return output, err
+	}
+	return output, c.wait(ctx, output)
+}
+
+// BackgroundConfig sets how hard the jobs reading tables whole, such as
+// exports, rebuilds and migrations, may read them.
+type BackgroundConfig struct {
+	// ReadCapacity is the read capacity units a second their scans keep to
+	// between them. Zero leaves them unthrottled, as suits an on-demand table.
+	ReadCapacity float64
+}
+
+var background struct {
+	mu       sync.RWMutex
+	throttle *ScanThrottle
+}
+
+func ConfigureBackground(config BackgroundConfig) {
+	background.mu.Lock()
+	defer background.mu.Unlock()
+	background.throttle = nil
+	if config.ReadCapacity > 0 {
+		background.throttle = NewScanThrottle(config.ReadCapacity)
+	}
+}
+
+// Background returns client for a job reading a table whole, with its scans
+// paced by the configured BackgroundConfig, or client itself when they are
+// unthrottled.
+func Background(client dynamodbiface.DynamoDBAPI) dynamodbiface.DynamoDBAPI {
+	background.mu.RLock()
+	defer background.mu.RUnlock()
+	if background.throttle == nil {
+		return client
+	}
+	return NewThrottledClient(client, background.throttle)
+}
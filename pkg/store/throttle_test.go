@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestScanThrottle(t *testing.T) {
+	newClient := func(capacity float64, scanned int64) (*testutil.MockDynamoDB, *ThrottledClient, *[]time.Duration) {
+		inner := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{
+			ConsumedCapacity: &dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(capacity)},
+			ScannedCount:     aws.Int64(scanned),
+		}}
+		throttle := NewScanThrottle(10)
+		clock := time.Now()
+		waits := []time.Duration{}
+		throttle.now = func() time.Time { return clock }
+		throttle.sleep = func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			clock = clock.Add(d)
+			return nil
+		}
+		return inner, NewThrottledClient(inner, throttle), &waits
+	}
+
+	t.Run("should wait for the capacity each page consumed at the target rate", func(t *testing.T) {
+		_, client, waits := newClient(5, 100)
+		for i := 0; i < 3; i++ {
+			if _, err := client.Scan(&dynamodb.ScanInput{TableName: aws.String("users")}); err != nil {
+				t.Fatalf("expected no error, got %s", err.Error())
+			}
+		}
+		if len(*waits) != 3 || (*waits)[0] != 500*time.Millisecond || (*waits)[2] != 500*time.Millisecond {
+			t.Errorf("expected half a second after each page of 5 units at 10 a second, got %v", *waits)
+		}
+	})
+	t.Run("should ask for the capacity consumed and size pages to a second of it", func(t *testing.T) {
+		inner, client, _ := newClient(20, 200)
+		client.Scan(&dynamodb.ScanInput{})
+		client.Scan(&dynamodb.ScanInput{})
+		client.Scan(&dynamodb.ScanInput{Limit: aws.Int64(50)})
+		inputs := inner.ScanInputs()
+		if *inputs[0].ReturnConsumedCapacity != dynamodb.ReturnConsumedCapacityTotal || inputs[0].Limit != nil {
+			t.Errorf("expected the first page to be read whole, got %v", inputs[0])
+		}
+		if *inputs[1].Limit != 100 {
+			t.Errorf("expected 100 items at a tenth of a unit each, got %d", *inputs[1].Limit)
+		}
+		if *inputs[2].Limit != 50 {
+			t.Errorf("expected a smaller limit to be kept, got %d", *inputs[2].Limit)
+		}
+	})
+	t.Run("should not wait for a page that consumed nothing", func(t *testing.T) {
+		_, client, waits := newClient(0, 0)
+		client.Scan(&dynamodb.ScanInput{})
+		if len(*waits) != 0 {
+			t.Errorf("expected no wait, got %v", *waits)
+		}
+	})
+	t.Run("should leave background clients unthrottled unless configured", func(t *testing.T) {
+		inner := &testutil.MockDynamoDB{}
+		if Background(inner) != inner {
+			t.Errorf("expected the client itself")
+		}
+		ConfigureBackground(BackgroundConfig{ReadCapacity: 25})
+		defer ConfigureBackground(BackgroundConfig{})
+		if _, ok := Background(inner).(*ThrottledClient); !ok {
+			t.Errorf("expected a throttled client")
+		}
+	})
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorTimeout = "data store timed out"
+
+// IsTimeout reports whether err is a call that ran out of time.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err.Error() == ErrorTimeout || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == request.CanceledErrorCode
+}
+
+// TimeoutConfig bounds each item read and write by OperationTimeout and by
+// the invocation's deadline less Margin, leaving the handler time to answer
+// before Lambda stops the function.
+type TimeoutConfig struct {
+	OperationTimeout time.Duration
+	Margin           time.Duration
+}
+
+// Deadlines holds the context of the invocation being served, which Bind
+// sets at the start of each request, and derives the deadline of each call
+// made while serving it.
+type Deadlines struct {
+	config TimeoutConfig
+
+	mu  sync.RWMutex
+	ctx context.Context
+}
+
+func NewDeadlines(config TimeoutConfig) *Deadlines {
+	return &Deadlines{config: config, ctx: context.Background()}
+}
+
+func (d *Deadlines) Bind(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ctx = ctx
+}
+
+func (d *Deadlines) invocation() context.Context {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.ctx
+}
+
+// call returns the context a call runs under, or an error when there is no
+// time left to make it.
+func (d *Deadlines) call(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	var deadline time.Time
+	if d.config.OperationTimeout > 0 {
+		deadline = time.Now().Add(d.config.OperationTimeout)
+	}
+	if invocation, ok := ctx.Deadline(); ok {
+		if limit := invocation.Add(-d.config.Margin); deadline.IsZero() || limit.Before(deadline) {
+			deadline = limit
+		}
+	}
+	if deadline.IsZero() {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	if !time.Now().Before(deadline) {
+		return nil, nil, errors.New(ErrorTimeout)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return ctx, cancel, nil
+}
+
+// TimeoutClient runs item reads and writes under the deadlines of the
+// invocation. A call that runs out of time fails with ErrorTimeout.
+type TimeoutClient struct {
+	dynamodbiface.DynamoDBAPI
+	deadlines *Deadlines
+}
+
+func NewTimeoutClient(client dynamodbiface.DynamoDBAPI, deadlines *Deadlines) *TimeoutClient {
+	return &TimeoutClient{DynamoDBAPI: client, deadlines: deadlines}
+}
+
+func timeoutError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return errors.New(ErrorTimeout)
+	}
+	return err
+}
+
+func (c *TimeoutClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return c.GetItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return c.BatchGetItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.BatchGetItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return c.QueryWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.QueryWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return c.ScanWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.ScanWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return c.PutItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.PutItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return c.UpdateItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.UpdateItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return c.DeleteItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.DeleteItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return c.BatchWriteItemWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.BatchWriteItemWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) TransactGetItems(input *dynamodb.TransactGetItemsInput) (*dynamodb.TransactGetItemsOutput, error) {
+	return c.TransactGetItemsWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) TransactGetItemsWithContext(ctx aws.Context, input *dynamodb.TransactGetItemsInput, opts ...request.Option) (*dynamodb.TransactGetItemsOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.TransactGetItemsWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
+
+func (c *TimeoutClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return c.TransactWriteItemsWithContext(c.deadlines.invocation(), input)
+}
+
+func (c *TimeoutClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	ctx, cancel, err := c.deadlines.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	output, err := c.DynamoDBAPI.TransactWriteItemsWithContext(ctx, input, opts...)
+	return output, timeoutError(ctx, err)
+}
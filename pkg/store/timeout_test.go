@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// slowClient answers once delay has passed, or fails the way the SDK does
+// when the call's context ends first.
+type slowClient struct {
+	dynamodbiface.DynamoDBAPI
+	delay    time.Duration
+	deadline time.Time
+}
+
+func (m *slowClient) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	m.deadline, _ = ctx.Deadline()
+	select {
+	case <-time.After(m.delay):
+		return &dynamodb.ScanOutput{}, nil
+	case <-ctx.Done():
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+}
+
+func TestTimeoutClient(t *testing.T) {
+	t.Run("should stop a call at the invocation deadline less the margin", func(t *testing.T) {
+		deadlines := NewDeadlines(TimeoutConfig{OperationTimeout: time.Minute, Margin: 50 * time.Millisecond})
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+		defer cancel()
+		deadlines.Bind(ctx)
+		slow := &slowClient{delay: time.Second}
+
+		start := time.Now()
+		_, err := NewTimeoutClient(slow, deadlines).Scan(&dynamodb.ScanInput{})
+		if err == nil || err.Error() != ErrorTimeout {
+			t.Fatalf("expected error %q, got %v", ErrorTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+			t.Errorf("expected the call to stop before the margin, took %s", elapsed)
+		}
+	})
+	t.Run("should bound a call by the operation timeout", func(t *testing.T) {
+		deadlines := NewDeadlines(TimeoutConfig{OperationTimeout: 10 * time.Millisecond})
+		slow := &slowClient{delay: time.Second}
+
+		_, err := NewTimeoutClient(slow, deadlines).Scan(&dynamodb.ScanInput{})
+		if !IsTimeout(err) {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+	})
+	t.Run("should not make a call once the margin has been reached", func(t *testing.T) {
+		deadlines := NewDeadlines(TimeoutConfig{Margin: time.Second})
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		deadlines.Bind(ctx)
+		slow := &slowClient{}
+
+		_, err := NewTimeoutClient(slow, deadlines).Scan(&dynamodb.ScanInput{})
+		if !IsTimeout(err) {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+		if !slow.deadline.IsZero() {
+			t.Error("expected no call to be made")
+		}
+	})
+	t.Run("should let calls that finish in time through", func(t *testing.T) {
+		deadlines := NewDeadlines(TimeoutConfig{OperationTimeout: time.Second})
+		slow := &slowClient{}
+
+		_, err := NewTimeoutClient(slow, deadlines).Scan(&dynamodb.ScanInput{})
+		if err != nil {
+			t.Errorf("expected no error, got %s", err.Error())
+		}
+		if slow.deadline.IsZero() {
+			t.Error("expected the call to have a deadline")
+		}
+	})
+}
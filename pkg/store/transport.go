@@ -0,0 +1,32 @@
+package store
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig tunes the HTTP transport of the DynamoDB client. Go's
+// default keeps only two idle connections per host, so a burst of parallel
+// calls, such as a segmented scan, pays for new TLS handshakes.
+type TransportConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	TLSHandshakeTimeout time.Duration
+	// KeepAlive is the interval of TCP keep-alive probes on open connections
+	KeepAlive time.Duration
+}
+
+func newHTTPClient(config TransportConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	transport.TLSHandshakeTimeout = config.TLSHandshakeTimeout
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: config.KeepAlive,
+	}).DialContext
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Run("should apply the transport settings", func(t *testing.T) {
+		client := newHTTPClient(TransportConfig{
+			MaxIdleConns:        50,
+			MaxIdleConnsPerHost: 25,
+			IdleConnTimeout:     time.Minute,
+			TLSHandshakeTimeout: 2 * time.Second,
+			KeepAlive:           15 * time.Second,
+		})
+		transport := client.Transport.(*http.Transport)
+		if transport.MaxIdleConns != 50 || transport.MaxIdleConnsPerHost != 25 {
+			t.Errorf("expected 50 idle connections and 25 per host, got %d and %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+		}
+		if transport.IdleConnTimeout != time.Minute || transport.TLSHandshakeTimeout != 2*time.Second {
+			t.Errorf("expected timeouts of 1m and 2s, got %s and %s", transport.IdleConnTimeout, transport.TLSHandshakeTimeout)
+		}
+		if transport.Proxy == nil {
+			t.Error("expected the proxy settings of the default transport to be kept")
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// rxName matches the attribute name placeholders of a condition.
+var rxName = regexp.MustCompile(`#([A-Za-z0-9_]+)`)
+
+// Update builds an UpdateItem request attribute by attribute, so a write
+// changes only the attributes it means to and concurrent writes to the
+// others are kept, where putting a whole item read earlier would undo them.
+// Each attribute is named by the placeholder # and its name and, when set,
+// valued by : and its name, so reserved words such as status need no care.
+type Update struct {
+	set        []string
+	remove     []string
+	add        []string
+	conditions []string
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+}
+
+func NewUpdate() *Update {
+	return &Update{names: map[string]*string{}, values: map[string]*dynamodb.AttributeValue{}}
+}
+
+// Set sets attribute name to value.
+func (u *Update) Set(name string, value *dynamodb.AttributeValue) *Update {
+	u.set = append(u.set, u.name(name)+" = "+u.value(name, value))
+	return u
+}
+
+// SetIfNotExists sets attribute name to value unless it already has one.
+func (u *Update) SetIfNotExists(name string, value *dynamodb.AttributeValue) *Update {
+	placeholder := u.name(name)
+	u.set = append(u.set, placeholder+" = if_not_exists("+placeholder+", "+u.value(name, value)+")")
+	return u
+}
+
+// Remove removes attribute name.
+func (u *Update) Remove(name string) *Update {
+	u.remove = append(u.remove, u.name(name))
+	return u
+}
+
+// Increment adds by to number attribute name, counting from zero when it
+// has no value yet. Concurrent increments are all counted.
+func (u *Update) Increment(name string, by int64) *Update {
+	u.add = append(u.add, u.name(name)+" "+u.value(name, &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(by, 10))}))
+	return u
+}
+
+// Condition requires expression to hold for the update to be made, along
+// with any other conditions. Attributes are named in it by their
+// placeholders, such as #lastSeenAt, and values by the placeholders given
+// in values, which must not be : and the name of an attribute the update
+// sets.
+func (u *Update) Condition(expression string, values map[string]*dynamodb.AttributeValue) *Update {
+	for placeholder, name := range Names(expression) {
+		u.names[placeholder] = name
+	}
+	for placeholder, value := range values {
+		u.values[placeholder] = value
+	}
+	u.conditions = append(u.conditions, "("+expression+")")
+	return u
+}
+
+// Exists requires the item to exist, so an update never creates one.
+func (u *Update) Exists(key string) *Update {
+	return u.Condition("attribute_exists(#"+key+")", nil)
+}
+
+// Empty reports whether the update changes nothing, which DynamoDB refuses.
+func (u *Update) Empty() bool {
+	return len(u.set) == 0 && len(u.remove) == 0 && len(u.add) == 0
+}
+
+// Input returns the request making the update to the item at key in
+// tableName.
+func (u *Update) Input(key map[string]*dynamodb.AttributeValue, tableName string) *dynamodb.UpdateItemInput {
+	input := &dynamodb.UpdateItemInput{
+		Key:                      key,
+		TableName:                aws.String(tableName),
+		UpdateExpression:         aws.String(u.expression()),
+		ExpressionAttributeNames: u.names,
+	}
+	if len(u.values) > 0 {
+		input.ExpressionAttributeValues = u.values
+	}
+	if len(u.conditions) > 0 {
+		input.ConditionExpression = aws.String(strings.Join(u.conditions, " AND "))
+	}
+	return input
+}
+
+// Transact returns the update as a write of a transaction.
+func (u *Update) Transact(key map[string]*dynamodb.AttributeValue, tableName string) *dynamodb.TransactWriteItem {
+	input := u.Input(key, tableName)
+	return &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+		Key:                       input.Key,
+		TableName:                 input.TableName,
+		UpdateExpression:          input.UpdateExpression,
+		ConditionExpression:       input.ConditionExpression,
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+	}}
+}
+
+func (u *Update) expression() string {
+	clauses := []string{}
+	for _, clause := range []struct {
+		action  string
+		actions []string
+	}{{"SET", u.set}, {"REMOVE", u.remove}, {"ADD", u.add}} {
+		if len(clause.actions) > 0 {
+			clauses = append(clauses, clause.action+" "+strings.Join(clause.actions, ", "))
+		}
+	}
+	return strings.Join(clauses, " ")
+}
+
+// Names returns the attribute names expression refers to by their
+// placeholders, such as #lastSeenAt, keyed by placeholder, as the
+// ExpressionAttributeNames of a request the expression is made in.
+func Names(expression string) map[string]*string {
+	names := map[string]*string{}
+	for _, match := range rxName.FindAllStringSubmatch(expression, -1) {
+		names[match[0]] = aws.String(match[1])
+	}
+	return names
+}
+
+func (u *Update) name(name string) string {
+	u.names["#"+name] = aws.String(name)
+	return "#" + name
+}
+
+func (u *Update) value(name string, value *dynamodb.AttributeValue) string {
+	u.values[":"+name] = value
+	return ":" + name
+}
+
+// Diff returns the update that turns item from into item to: the
+// attributes to has that from has not, or has with another value, are set
+// and those only from has are removed. Attributes are visited in order of
+// name, so the same change always makes the same update.
+func Diff(from map[string]*dynamodb.AttributeValue, to map[string]*dynamodb.AttributeValue) *Update {
+	u := NewUpdate()
+	names := make([]string, 0, len(from)+len(to))
+	for name := range to {
+		names = append(names, name)
+	}
+	for name := range from {
+		if _, ok := to[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, ok := to[name]
+		switch {
+		case !ok:
+			u.Remove(name)
+		case !reflect.DeepEqual(value, from[name]):
+			u.Set(name, value)
+		}
+	}
+	return u
+}
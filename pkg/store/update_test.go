@@ -0,0 +1,74 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestUpdate(t *testing.T) {
+	key := map[string]*dynamodb.AttributeValue{"email": {S: aws.String("alan.oliver@ecs.co.uk")}}
+
+	t.Run("expect attributes to be updated under placeholders", func(t *testing.T) {
+		input := NewUpdate().
+			Set("status", &dynamodb.AttributeValue{S: aws.String("active")}).
+			SetIfNotExists("createdAt", &dynamodb.AttributeValue{S: aws.String("2023-01-02T03:04:05Z")}).
+			Remove("pendingEmail").
+			Increment("logins", 1).
+			Exists("email").
+			Condition("attribute_not_exists(#lastSeenAt) OR #lastSeenAt < :stale", map[string]*dynamodb.AttributeValue{
+				":stale": {S: aws.String("2023-01-02T03:00:00Z")},
+			}).
+			Input(key, "test")
+		want := "SET #status = :status, #createdAt = if_not_exists(#createdAt, :createdAt) REMOVE #pendingEmail ADD #logins :logins"
+		if *input.UpdateExpression != want {
+			t.Errorf("Expected %s, got %s", want, *input.UpdateExpression)
+		}
+		if want := "(attribute_exists(#email)) AND (attribute_not_exists(#lastSeenAt) OR #lastSeenAt < :stale)"; *input.ConditionExpression != want {
+			t.Errorf("Expected %s, got %s", want, *input.ConditionExpression)
+		}
+		if len(input.ExpressionAttributeNames) != 6 || *input.ExpressionAttributeNames["#lastSeenAt"] != "lastSeenAt" {
+			t.Errorf("Expected a placeholder for each attribute, got %v", input.ExpressionAttributeNames)
+		}
+		if len(input.ExpressionAttributeValues) != 4 || *input.ExpressionAttributeValues[":logins"].N != "1" {
+			t.Errorf("Expected a placeholder for each value, got %v", input.ExpressionAttributeValues)
+		}
+	})
+
+	t.Run("expect a diff to set changed attributes and remove cleared ones", func(t *testing.T) {
+		from := map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"company":   {S: aws.String("ECS")},
+			"metadata":  {M: map[string]*dynamodb.AttributeValue{"crmId": {S: aws.String("12345")}}},
+		}
+		to := map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Allen")},
+			"jobTitle":  {S: aws.String("Engineer")},
+			"metadata":  {M: map[string]*dynamodb.AttributeValue{"crmId": {S: aws.String("12345")}}},
+		}
+		update := Diff(from, to)
+		if want := "SET #firstName = :firstName, #jobTitle = :jobTitle REMOVE #company"; *update.Input(key, "test").UpdateExpression != want {
+			t.Errorf("Expected %s, got %s", want, *update.Input(key, "test").UpdateExpression)
+		}
+		if !Diff(from, from).Empty() {
+			t.Error("Expected no change between an item and itself")
+		}
+	})
+
+	t.Run("expect an update to be written in a transaction", func(t *testing.T) {
+		write := NewUpdate().Remove("pendingEmail").Transact(key, "test")
+		if write.Update == nil || *write.Update.UpdateExpression != "REMOVE #pendingEmail" || write.Update.ExpressionAttributeValues != nil {
+			t.Errorf("Expected a transactional update without values, got %v", write)
+		}
+	})
+
+	t.Run("expect the names of an expression to be given placeholders", func(t *testing.T) {
+		names := Names("#status = :status AND attribute_not_exists(#pendingEmail)")
+		if len(names) != 2 || *names["#status"] != "status" || *names["#pendingEmail"] != "pendingEmail" {
+			t.Errorf("Expected a placeholder for each name, got %v", names)
+		}
+	})
+}
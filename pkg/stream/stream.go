@@ -0,0 +1,23 @@
+// Package stream answers the batches of the users table's stream for the
+// Lambdas consuming it with batch item failures reported.
+package stream
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Response reports the first record that failed, so the stream retries it
+// and every record after it, keeping changes to a user in order.
+type Response struct {
+	BatchItemFailures []events.DynamoDBBatchItemFailure `json:"batchItemFailures"`
+}
+
+// Done answers a batch whose every record was handled.
+func Done() Response {
+	return Response{BatchItemFailures: []events.DynamoDBBatchItemFailure{}}
+}
+
+// Failed answers a batch whose records from record on are to be retried.
+func Failed(record events.DynamoDBEventRecord) Response {
+	return Response{BatchItemFailures: []events.DynamoDBBatchItemFailure{{ItemIdentifier: record.Change.SequenceNumber}}}
+}
@@ -0,0 +1,24 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestResponse(t *testing.T) {
+	cases := map[string]Response{
+		`{"batchItemFailures":[]}`:                       Done(),
+		`{"batchItemFailures":[{"itemIdentifier":"2"}]}`: Failed(events.DynamoDBEventRecord{Change: events.DynamoDBStreamRecord{SequenceNumber: "2"}}),
+	}
+	for expected, response := range cases {
+		out, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if string(out) != expected {
+			t.Errorf("expected %s, got %s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package tenant maps the tenant a request names to the users table that
+// holds its users, so one deployment can serve customers or environments
+// from isolated tables. Tables are listed in configuration or kept in
+// Parameter Store, where tenants can be added without a redeploy.
+package tenant
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// DefaultHeader carries the tenant a request is made for, when no other
+// header is configured.
+const DefaultHeader = "X-Tenant-ID"
+
+var (
+	ErrorFailedToResolveTenant = "failed to resolve tenant"
+	ErrorInvalidTenant         = "tenant must be 1 to 64 lowercase letters, digits or dashes"
+	ErrorUnknownTenant         = "unknown tenant"
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Config sets where tenants' tables are found. Tables maps tenants to their
+// tables and is consulted first; tenants it does not list are looked up in
+// Parameter Store under ParameterPath, as the value of <ParameterPath>/<id>,
+// when both it and SSM are set. Lookups, including those finding no tenant,
+// are cached per container for CacheTTL. Tenancy is off while neither is
+// set, and every request is served from the default table.
+type Config struct {
+	Header        string
+	Tables        map[string]string
+	ParameterPath string
+	SSM           ssmiface.SSMAPI
+	CacheTTL      time.Duration
+}
+
+var config = Config{Header: DefaultHeader}
+
+func Configure(c Config) {
+	if c.Header == "" {
+		c.Header = DefaultHeader
+	}
+	c.ParameterPath = strings.TrimSuffix(c.ParameterPath, "/")
+	config = c
+	lookups.clear()
+}
+
+// Header returns the header requests name their tenant in.
+func Header() string {
+	return config.Header
+}
+
+// Enabled reports whether tenants are configured.
+func Enabled() bool {
+	return len(config.Tables) > 0 || config.ParameterPath != "" && config.SSM != nil
+}
+
+// Table returns the table of the tenant id, or defaultTable when id is empty
+// or tenancy is off.
+func Table(id string, defaultTable string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" || !Enabled() {
+		return defaultTable, nil
+	}
+	if !validID.MatchString(id) {
+		return "", errors.New(ErrorInvalidTenant)
+	}
+	if table, ok := config.Tables[id]; ok {
+		return table, nil
+	}
+	if config.ParameterPath == "" || config.SSM == nil {
+		return "", errors.New(ErrorUnknownTenant)
+	}
+	table, ok := lookups.get(id)
+	if !ok {
+		var err error
+		if table, err = lookup(id); err != nil {
+			return "", err
+		}
+		lookups.store(id, table)
+	}
+	if table == "" {
+		return "", errors.New(ErrorUnknownTenant)
+	}
+	return table, nil
+}
+
+// lookup reads the table of the tenant id from Parameter Store, returning ""
+// when it has none.
+func lookup(id string) (string, error) {
+	result, err := config.SSM.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(config.ParameterPath + "/" + id),
+	})
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == ssm.ErrCodeParameterNotFound {
+			return "", nil
+		}
+		return "", errors.New(ErrorFailedToResolveTenant)
+	}
+	return strings.TrimSpace(aws.StringValue(result.Parameter.Value)), nil
+}
+
+// lookupCache holds tenants' tables as read from Parameter Store. Failed
+// reads are not cached.
+type lookupCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]lookupEntry
+}
+
+type lookupEntry struct {
+	table   string
+	expires time.Time
+}
+
+var lookups = &lookupCache{now: time.Now, entries: map[string]lookupEntry{}}
+
+func (c *lookupCache) get(id string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || !c.now().Before(entry.expires) {
+		return "", false
+	}
+	return entry.table, true
+}
+
+func (c *lookupCache) store(id string, table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[id] = lookupEntry{table: table, expires: c.now().Add(config.CacheTTL)}
+}
+
+func (c *lookupCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]lookupEntry{}
+}
@@ -0,0 +1,97 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// parameterClient answers parameters from memory and counts its reads.
+type parameterClient struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+	err        error
+	reads      int
+}
+
+func (c *parameterClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	c.reads++
+	if c.err != nil {
+		return nil, c.err
+	}
+	value, ok := c.parameters[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func TestTable(t *testing.T) {
+	defer Configure(Config{})
+
+	t.Run("expect the default table while tenancy is off", func(t *testing.T) {
+		Configure(Config{})
+		table, err := Table("acme", "LambdaInGoUser")
+		if err != nil || table != "LambdaInGoUser" {
+			t.Errorf("Expected the default table, got %q %v", table, err)
+		}
+	})
+
+	t.Run("expect configured tenants to be answered before Parameter Store", func(t *testing.T) {
+		client := &parameterClient{parameters: map[string]string{"/tenants/acme": "AcmeFromParameter"}}
+		Configure(Config{Tables: map[string]string{"acme": "AcmeUser"}, ParameterPath: "/tenants/", SSM: client, CacheTTL: time.Minute})
+		table, err := Table("acme", "LambdaInGoUser")
+		if err != nil || table != "AcmeUser" {
+			t.Errorf("Expected the configured table, got %q %v", table, err)
+		}
+		if client.reads != 0 {
+			t.Errorf("Expected Parameter Store not to be read, got %d reads", client.reads)
+		}
+		if table, err := Table("", "LambdaInGoUser"); err != nil || table != "LambdaInGoUser" {
+			t.Errorf("Expected the default table without a tenant, got %q %v", table, err)
+		}
+	})
+
+	t.Run("expect tenants to be read from Parameter Store and cached", func(t *testing.T) {
+		client := &parameterClient{parameters: map[string]string{"/tenants/globex": " GlobexUser\n"}}
+		Configure(Config{ParameterPath: "/tenants", SSM: client, CacheTTL: time.Minute})
+		for i := 0; i < 2; i++ {
+			table, err := Table("globex", "LambdaInGoUser")
+			if err != nil || table != "GlobexUser" {
+				t.Errorf("Expected the table from Parameter Store, got %q %v", table, err)
+			}
+			if _, err := Table("initech", "LambdaInGoUser"); err == nil || err.Error() != ErrorUnknownTenant {
+				t.Errorf("Expected %q, got %v", ErrorUnknownTenant, err)
+			}
+		}
+		if client.reads != 2 {
+			t.Errorf("Expected each tenant to be read once, got %d reads", client.reads)
+		}
+	})
+
+	t.Run("expect failed reads not to be cached", func(t *testing.T) {
+		client := &parameterClient{err: errors.New("throttled")}
+		Configure(Config{ParameterPath: "/tenants", SSM: client, CacheTTL: time.Minute})
+		if _, err := Table("globex", "LambdaInGoUser"); err == nil || err.Error() != ErrorFailedToResolveTenant {
+			t.Errorf("Expected %q, got %v", ErrorFailedToResolveTenant, err)
+		}
+		client.err, client.parameters = nil, map[string]string{"/tenants/globex": "GlobexUser"}
+		if table, err := Table("globex", "LambdaInGoUser"); err != nil || table != "GlobexUser" {
+			t.Errorf("Expected the table once Parameter Store answers, got %q %v", table, err)
+		}
+	})
+
+	t.Run("expect an invalid tenant to be refused", func(t *testing.T) {
+		Configure(Config{Tables: map[string]string{"acme": "AcmeUser"}})
+		for _, id := range []string{"Acme", "acme/../other", "-acme"} {
+			if _, err := Table(id, "LambdaInGoUser"); err == nil || err.Error() != ErrorInvalidTenant {
+				t.Errorf("Expected %q for %q, got %v", ErrorInvalidTenant, id, err)
+			}
+		}
+	})
+}
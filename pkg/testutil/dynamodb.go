@@ -0,0 +1,246 @@
+// Package testutil provides DynamoDB fakes for tests of code that takes
+// dynamodbiface.DynamoDBAPI: MockDynamoDB, answering each operation as a
+// test configures it, and MemoryDynamoDB, keeping tables in memory for
+// tests that read back what they write.
+package testutil
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Call is one call made to a MockDynamoDB, with the operation's name, e.g.
+// "GetItem", and its input.
+type Call struct {
+	Operation string
+	Input     interface{}
+}
+
+// MockDynamoDB answers each operation with its Func when set, or else with
+// its canned Output and Err. Operations with neither answer an empty output,
+// so a test only configures what it is about. Operations the mock does not
+// implement panic through the embedded nil interface, as an unexpected call
+// should fail the test.
+//
+// Every call is recorded, whatever it answered, and the mock is safe for
+// concurrent use.
+type MockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	GetItemOutput *dynamodb.GetItemOutput
+	GetItemErr    error
+	// GetItemOutputs answers GetItem by table name, ahead of GetItemOutput
+	GetItemOutputs map[string]*dynamodb.GetItemOutput
+	PutItemErr     error
+	UpdateItemErr  error
+	DeleteItemErr  error
+	QueryOutput    *dynamodb.QueryOutput
+	QueryErr       error
+	ScanOutput     *dynamodb.ScanOutput
+	ScanErr        error
+
+	GetItemFunc            func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	PutItemFunc            func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	UpdateItemFunc         func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+	DeleteItemFunc         func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error)
+	QueryFunc              func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	ScanFunc               func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error)
+	BatchGetItemFunc       func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+	BatchWriteItemFunc     func(*dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error)
+	TransactWriteItemsFunc func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+	DescribeTableFunc      func(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error)
+
+	recorder
+}
+
+// recorder records the calls made to a fake, safely for concurrent use.
+type recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (r *recorder) record(operation string, input interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Operation: operation, Input: input})
+}
+
+// Calls returns every call made so far, in order.
+func (r *recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Count returns how many calls were made to operation.
+func (r *recorder) Count(operation string) int {
+	return len(r.inputs(operation))
+}
+
+// Reset forgets the calls made so far, leaving the configuration as it is.
+func (r *recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+func (r *recorder) inputs(operation string) []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inputs := []interface{}{}
+	for _, call := range r.calls {
+		if call.Operation == operation {
+			inputs = append(inputs, call.Input)
+		}
+	}
+	return inputs
+}
+
+func (m *MockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.record("GetItem", input)
+	if m.GetItemFunc != nil {
+		return m.GetItemFunc(input)
+	}
+	if output, ok := m.GetItemOutputs[aws.StringValue(input.TableName)]; ok {
+		return output, nil
+	}
+	if m.GetItemOutput == nil && m.GetItemErr == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return m.GetItemOutput, m.GetItemErr
+}
+
+func (m *MockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.record("PutItem", input)
+	if m.PutItemFunc != nil {
+		return m.PutItemFunc(input)
+	}
+	return &dynamodb.PutItemOutput{}, m.PutItemErr
+}
+
+func (m *MockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.record("UpdateItem", input)
+	if m.UpdateItemFunc != nil {
+		return m.UpdateItemFunc(input)
+	}
+	return &dynamodb.UpdateItemOutput{}, m.UpdateItemErr
+}
+
+func (m *MockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	m.record("DeleteItem", input)
+	if m.DeleteItemFunc != nil {
+		return m.DeleteItemFunc(input)
+	}
+	return &dynamodb.DeleteItemOutput{}, m.DeleteItemErr
+}
+
+func (m *MockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	m.record("Query", input)
+	if m.QueryFunc != nil {
+		return m.QueryFunc(input)
+	}
+	if m.QueryOutput == nil && m.QueryErr == nil {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	return m.QueryOutput, m.QueryErr
+}
+
+func (m *MockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	m.record("Scan", input)
+	if m.ScanFunc != nil {
+		return m.ScanFunc(input)
+	}
+	if m.ScanOutput == nil && m.ScanErr == nil {
+		return &dynamodb.ScanOutput{}, nil
+	}
+	return m.ScanOutput, m.ScanErr
+}
+
+func (m *MockDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	m.record("BatchGetItem", input)
+	if m.BatchGetItemFunc != nil {
+		return m.BatchGetItemFunc(input)
+	}
+	return &dynamodb.BatchGetItemOutput{}, nil
+}
+
+func (m *MockDynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	m.record("BatchWriteItem", input)
+	if m.BatchWriteItemFunc != nil {
+		return m.BatchWriteItemFunc(input)
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (m *MockDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.record("TransactWriteItems", input)
+	if m.TransactWriteItemsFunc != nil {
+		return m.TransactWriteItemsFunc(input)
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (m *MockDynamoDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	m.record("DescribeTable", input)
+	if m.DescribeTableFunc != nil {
+		return m.DescribeTableFunc(input)
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+// GetItemInputs returns the inputs of every GetItem call, in order.
+func (r *recorder) GetItemInputs() []*dynamodb.GetItemInput {
+	inputs := []*dynamodb.GetItemInput{}
+	for _, input := range r.inputs("GetItem") {
+		inputs = append(inputs, input.(*dynamodb.GetItemInput))
+	}
+	return inputs
+}
+
+// PutItemInputs returns the inputs of every PutItem call, in order.
+func (r *recorder) PutItemInputs() []*dynamodb.PutItemInput {
+	inputs := []*dynamodb.PutItemInput{}
+	for _, input := range r.inputs("PutItem") {
+		inputs = append(inputs, input.(*dynamodb.PutItemInput))
+	}
+	return inputs
+}
+
+// UpdateItemInputs returns the inputs of every UpdateItem call, in order.
+func (r *recorder) UpdateItemInputs() []*dynamodb.UpdateItemInput {
+	inputs := []*dynamodb.UpdateItemInput{}
+	for _, input := range r.inputs("UpdateItem") {
+		inputs = append(inputs, input.(*dynamodb.UpdateItemInput))
+	}
+	return inputs
+}
+
+// DeleteItemInputs returns the inputs of every DeleteItem call, in order.
+func (r *recorder) DeleteItemInputs() []*dynamodb.DeleteItemInput {
+	inputs := []*dynamodb.DeleteItemInput{}
+	for _, input := range r.inputs("DeleteItem") {
+		inputs = append(inputs, input.(*dynamodb.DeleteItemInput))
+	}
+	return inputs
+}
+
+// QueryInputs returns the inputs of every Query call, in order.
+func (r *recorder) QueryInputs() []*dynamodb.QueryInput {
+	inputs := []*dynamodb.QueryInput{}
+	for _, input := range r.inputs("Query") {
+		inputs = append(inputs, input.(*dynamodb.QueryInput))
+	}
+	return inputs
+}
+
+// ScanInputs returns the inputs of every Scan call, in order.
+func (r *recorder) ScanInputs() []*dynamodb.ScanInput {
+	inputs := []*dynamodb.ScanInput{}
+	for _, input := range r.inputs("Scan") {
+		inputs = append(inputs, input.(*dynamodb.ScanInput))
+	}
+	return inputs
+}
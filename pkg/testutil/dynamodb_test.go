@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestMockDynamoDB(t *testing.T) {
+	t.Run("expect an unconfigured operation to answer an empty output", func(t *testing.T) {
+		mock := &MockDynamoDB{}
+		output, err := mock.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test")})
+		if err != nil || output == nil || output.Item != nil {
+			t.Errorf("Expected an empty output, got %v %v", output, err)
+		}
+	})
+
+	t.Run("expect a Func to be preferred over canned answers", func(t *testing.T) {
+		mock := &MockDynamoDB{
+			PutItemErr: errors.New("canned"),
+			PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				return nil, errors.New("func")
+			},
+		}
+		if _, err := mock.PutItem(&dynamodb.PutItemInput{}); err == nil || err.Error() != "func" {
+			t.Errorf("Expected the Func's error, got %v", err)
+		}
+	})
+
+	t.Run("expect GetItem to answer by table when configured", func(t *testing.T) {
+		byTable := &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"email": {S: aws.String("a@b.com")}}}
+		mock := &MockDynamoDB{
+			GetItemOutput:  &dynamodb.GetItemOutput{},
+			GetItemOutputs: map[string]*dynamodb.GetItemOutput{"testAudit": byTable},
+		}
+		if output, _ := mock.GetItem(&dynamodb.GetItemInput{TableName: aws.String("testAudit")}); output != byTable {
+			t.Errorf("Expected the table's output, got %v", output)
+		}
+		if output, _ := mock.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test")}); output != mock.GetItemOutput {
+			t.Errorf("Expected the default output, got %v", output)
+		}
+	})
+
+	t.Run("expect calls to be recorded in order until reset", func(t *testing.T) {
+		mock := &MockDynamoDB{}
+		mock.PutItem(&dynamodb.PutItemInput{TableName: aws.String("first")})
+		mock.Scan(&dynamodb.ScanInput{})
+		mock.PutItem(&dynamodb.PutItemInput{TableName: aws.String("second")})
+
+		if calls := mock.Calls(); len(calls) != 3 || calls[1].Operation != "Scan" {
+			t.Errorf("Expected 3 calls with Scan second, got %+v", calls)
+		}
+		if mock.Count("PutItem") != 2 {
+			t.Errorf("Expected 2 PutItem calls, got %d", mock.Count("PutItem"))
+		}
+		if puts := mock.PutItemInputs(); *puts[0].TableName != "first" || *puts[1].TableName != "second" {
+			t.Errorf("Expected PutItem inputs in order, got %v", puts)
+		}
+
+		mock.Reset()
+		if len(mock.Calls()) != 0 {
+			t.Errorf("Expected no calls after Reset, got %d", len(mock.Calls()))
+		}
+	})
+}
@@ -0,0 +1,752 @@
+package testutil
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// item is the attributes of one item, as the SDK holds them.
+type item = map[string]*dynamodb.AttributeValue
+
+// expression reads the condition, filter, key condition, projection and
+// update expressions of a request, with its placeholders. It understands
+// the grammar DynamoDB documents short of nested attribute projection:
+// comparisons, BETWEEN, IN, AND, OR, NOT and the attribute_exists,
+// attribute_not_exists, attribute_type, begins_with, contains and size
+// functions; and SET, with + and -, if_not_exists and list_append, REMOVE,
+// ADD and DELETE. Expressions it cannot read fail the request with a
+// validation error, as DynamoDB's would.
+type expression struct {
+	tokens []string
+	at     int
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+// invalid is a request DynamoDB would refuse as invalid. It is raised as a
+// panic while an expression is read and recovered as a ValidationException.
+type invalid string
+
+func newExpression(text string, names map[string]*string, values map[string]*dynamodb.AttributeValue) *expression {
+	return &expression{tokens: tokenize(text), names: names, values: values}
+}
+
+func tokenize(text string) []string {
+	tokens := []string{}
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),.[]=+-", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			j := i + 1
+			if j < len(text) && (text[j] == '=' || (c == '<' && text[j] == '>')) {
+				j++
+			}
+			tokens = append(tokens, text[i:j])
+			i = j
+		case c == '#' || c == ':' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(text) && (text[j] == '_' || unicode.IsLetter(rune(text[j])) || unicode.IsDigit(rune(text[j]))) {
+				j++
+			}
+			tokens = append(tokens, text[i:j])
+			i = j
+		default:
+			panic(invalid("invalid character " + string(c) + " in expression " + text))
+		}
+	}
+	return tokens
+}
+
+func (e *expression) peek() string {
+	if e.at < len(e.tokens) {
+		return e.tokens[e.at]
+	}
+	return ""
+}
+
+func (e *expression) next() string {
+	token := e.peek()
+	e.at++
+	return token
+}
+
+func (e *expression) keyword(word string) bool {
+	if strings.EqualFold(e.peek(), word) {
+		e.at++
+		return true
+	}
+	return false
+}
+
+func (e *expression) expect(token string) {
+	if got := e.next(); got != token {
+		panic(invalid("expected " + token + ", got " + got))
+	}
+}
+
+func (e *expression) done() {
+	if e.at < len(e.tokens) {
+		panic(invalid("unexpected " + e.peek()))
+	}
+}
+
+// condition reads a whole condition, filter or key condition.
+func (e *expression) condition() func(item) bool {
+	condition := e.or()
+	e.done()
+	return condition
+}
+
+func (e *expression) or() func(item) bool {
+	left := e.and()
+	for e.keyword("OR") {
+		l, r := left, e.and()
+		left = func(it item) bool { return l(it) || r(it) }
+	}
+	return left
+}
+
+func (e *expression) and() func(item) bool {
+	left := e.not()
+	for e.keyword("AND") {
+		l, r := left, e.not()
+		left = func(it item) bool { return l(it) && r(it) }
+	}
+	return left
+}
+
+func (e *expression) not() func(item) bool {
+	if e.keyword("NOT") {
+		inner := e.not()
+		return func(it item) bool { return !inner(it) }
+	}
+	return e.predicate()
+}
+
+func (e *expression) predicate() func(item) bool {
+	if e.peek() == "(" {
+		e.next()
+		inner := e.or()
+		e.expect(")")
+		return inner
+	}
+	switch strings.ToLower(e.peek()) {
+	case "attribute_exists", "attribute_not_exists":
+		exists := strings.ToLower(e.next()) == "attribute_exists"
+		e.expect("(")
+		path := e.path()
+		e.expect(")")
+		return func(it item) bool { return (get(it, path) != nil) == exists }
+	case "attribute_type":
+		e.next()
+		e.expect("(")
+		path := e.path()
+		e.expect(",")
+		kind := e.operand()
+		e.expect(")")
+		return func(it item) bool {
+			v, k := get(it, path), kind(it)
+			return v != nil && k != nil && k.S != nil && typeOf(v) == *k.S
+		}
+	case "begins_with":
+		e.next()
+		e.expect("(")
+		path := e.path()
+		e.expect(",")
+		prefix := e.operand()
+		e.expect(")")
+		return func(it item) bool {
+			v, p := get(it, path), prefix(it)
+			switch {
+			case v == nil || p == nil:
+				return false
+			case v.S != nil && p.S != nil:
+				return strings.HasPrefix(*v.S, *p.S)
+			case v.B != nil && p.B != nil:
+				return bytes.HasPrefix(v.B, p.B)
+			}
+			return false
+		}
+	case "contains":
+		e.next()
+		e.expect("(")
+		path := e.path()
+		e.expect(",")
+		operand := e.operand()
+		e.expect(")")
+		return func(it item) bool { return contains(get(it, path), operand(it)) }
+	}
+	left := e.operand()
+	if e.keyword("BETWEEN") {
+		low := e.operand()
+		if !e.keyword("AND") {
+			panic(invalid("expected AND in BETWEEN"))
+		}
+		high := e.operand()
+		return func(it item) bool {
+			v := left(it)
+			l, lok := compare(v, low(it))
+			h, hok := compare(v, high(it))
+			return lok && hok && l >= 0 && h <= 0
+		}
+	}
+	if e.keyword("IN") {
+		e.expect("(")
+		list := []func(item) *dynamodb.AttributeValue{e.operand()}
+		for e.peek() == "," {
+			e.next()
+			list = append(list, e.operand())
+		}
+		e.expect(")")
+		return func(it item) bool {
+			v := left(it)
+			for _, candidate := range list {
+				if equal(v, candidate(it)) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	comparator := e.next()
+	right := e.operand()
+	switch comparator {
+	case "=":
+		return func(it item) bool { return equal(left(it), right(it)) }
+	case "<>":
+		return func(it item) bool {
+			l, r := left(it), right(it)
+			return l != nil && r != nil && !equal(l, r)
+		}
+	case "<", "<=", ">", ">=":
+		return func(it item) bool {
+			c, ok := compare(left(it), right(it))
+			if !ok {
+				return false
+			}
+			switch comparator {
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			}
+			return c >= 0
+		}
+	}
+	panic(invalid("unknown comparator " + comparator))
+}
+
+// operand reads a path, a value placeholder or size of a path.
+func (e *expression) operand() func(item) *dynamodb.AttributeValue {
+	token := e.peek()
+	if strings.HasPrefix(token, ":") {
+		e.next()
+		value, ok := e.values[token]
+		if !ok {
+			panic(invalid("value " + token + " is not defined"))
+		}
+		return func(item) *dynamodb.AttributeValue { return value }
+	}
+	if strings.EqualFold(token, "size") && e.at+1 < len(e.tokens) && e.tokens[e.at+1] == "(" {
+		e.next()
+		e.expect("(")
+		path := e.path()
+		e.expect(")")
+		return func(it item) *dynamodb.AttributeValue {
+			n, ok := size(get(it, path))
+			if !ok {
+				return nil
+			}
+			return &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(n))}
+		}
+	}
+	path := e.path()
+	return func(it item) *dynamodb.AttributeValue { return get(it, path) }
+}
+
+// step is one element of a path: a map key, or an index of a list when
+// name is empty.
+type step struct {
+	name  string
+	index int
+}
+
+// path reads an attribute path, such as #address.city or tags[0].
+func (e *expression) path() []step {
+	path := []step{{name: e.name()}}
+	for {
+		switch e.peek() {
+		case ".":
+			e.next()
+			path = append(path, step{name: e.name()})
+		case "[":
+			e.next()
+			index, err := strconv.Atoi(e.next())
+			if err != nil {
+				panic(invalid("invalid list index"))
+			}
+			e.expect("]")
+			path = append(path, step{index: index})
+		default:
+			return path
+		}
+	}
+}
+
+func (e *expression) name() string {
+	token := e.next()
+	if strings.HasPrefix(token, "#") {
+		name, ok := e.names[token]
+		if !ok {
+			panic(invalid("name " + token + " is not defined"))
+		}
+		return *name
+	}
+	if token == "" || strings.HasPrefix(token, ":") || !(unicode.IsLetter(rune(token[0])) || token[0] == '_') {
+		panic(invalid("expected an attribute name, got " + token))
+	}
+	return token
+}
+
+// projection reads the paths of a projection expression, returning the
+// top-level attributes they name.
+func (e *expression) projection() []string {
+	attributes := []string{e.path()[0].name}
+	for e.peek() == "," {
+		e.next()
+		attributes = append(attributes, e.path()[0].name)
+	}
+	e.done()
+	return attributes
+}
+
+// update reads an update expression, returning the function applying it to
+// a copy of an item. Every value it sets is read from the item as it was
+// before the update, as DynamoDB reads them.
+func (e *expression) update() func(item) item {
+	actions := []func(before item, after item){}
+	seen := map[string]bool{}
+	for e.peek() != "" {
+		clause := strings.ToUpper(e.next())
+		if seen[clause] {
+			panic(invalid(clause + " appears twice in the update expression"))
+		}
+		seen[clause] = true
+		for {
+			switch clause {
+			case "SET":
+				path := e.path()
+				e.expect("=")
+				value := e.setValue()
+				actions = append(actions, func(before item, after item) { set(after, path, value(before)) })
+			case "REMOVE":
+				path := e.path()
+				actions = append(actions, func(before item, after item) { remove(after, path) })
+			case "ADD", "DELETE":
+				path := e.path()
+				value := e.operand()
+				add := clause == "ADD"
+				actions = append(actions, func(before item, after item) {
+					current, change := get(before, path), value(before)
+					if add {
+						set(after, path, addValues(current, change))
+					} else if left := deleteValues(current, change); left != nil {
+						set(after, path, left)
+					} else {
+						remove(after, path)
+					}
+				})
+			default:
+				panic(invalid("unknown update clause " + clause))
+			}
+			if e.peek() != "," {
+				break
+			}
+			e.next()
+		}
+	}
+	if len(actions) == 0 {
+		panic(invalid("the update expression is empty"))
+	}
+	return func(before item) item {
+		after := copyItem(before)
+		for _, action := range actions {
+			action(before, after)
+		}
+		return after
+	}
+}
+
+// setValue reads the value of a SET action: an operand, a function of
+// operands, or two of them added or subtracted.
+func (e *expression) setValue() func(item) *dynamodb.AttributeValue {
+	left := e.setOperand()
+	if e.peek() != "+" && e.peek() != "-" {
+		return left
+	}
+	sign := e.next()
+	right := e.setOperand()
+	return func(it item) *dynamodb.AttributeValue {
+		l, r := left(it), right(it)
+		if l == nil || r == nil || l.N == nil || r.N == nil {
+			panic(invalid("an operand of " + sign + " is not a number"))
+		}
+		if sign == "-" {
+			return number(new(big.Rat).Sub(rat(*l.N), rat(*r.N)))
+		}
+		return number(new(big.Rat).Add(rat(*l.N), rat(*r.N)))
+	}
+}
+
+func (e *expression) setOperand() func(item) *dynamodb.AttributeValue {
+	switch strings.ToLower(e.peek()) {
+	case "if_not_exists":
+		e.next()
+		e.expect("(")
+		path := e.path()
+		e.expect(",")
+		otherwise := e.setOperand()
+		e.expect(")")
+		return func(it item) *dynamodb.AttributeValue {
+			if v := get(it, path); v != nil {
+				return v
+			}
+			return otherwise(it)
+		}
+	case "list_append":
+		e.next()
+		e.expect("(")
+		first := e.setOperand()
+		e.expect(",")
+		second := e.setOperand()
+		e.expect(")")
+		return func(it item) *dynamodb.AttributeValue {
+			a, b := first(it), second(it)
+			if a == nil || b == nil || a.L == nil || b.L == nil {
+				panic(invalid("an operand of list_append is not a list"))
+			}
+			return &dynamodb.AttributeValue{L: append(append([]*dynamodb.AttributeValue{}, a.L...), b.L...)}
+		}
+	}
+	return e.operand()
+}
+
+func get(it item, path []step) *dynamodb.AttributeValue {
+	v := it[path[0].name]
+	for _, s := range path[1:] {
+		switch {
+		case v == nil:
+			return nil
+		case s.name != "":
+			v = v.M[s.name]
+		case s.index < len(v.L):
+			v = v.L[s.index]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func set(it item, path []step, value *dynamodb.AttributeValue) {
+	if len(path) == 1 {
+		it[path[0].name] = copyValue(value)
+		return
+	}
+	parent := get(it, path[:len(path)-1])
+	last := path[len(path)-1]
+	switch {
+	case parent == nil:
+		panic(invalid("the document path provided in the update expression is invalid for update"))
+	case last.name != "" && parent.M != nil:
+		parent.M[last.name] = copyValue(value)
+	case last.name == "" && parent.L != nil && last.index < len(parent.L):
+		parent.L[last.index] = copyValue(value)
+	case last.name == "" && parent.L != nil:
+		parent.L = append(parent.L, copyValue(value))
+	default:
+		panic(invalid("the document path provided in the update expression is invalid for update"))
+	}
+}
+
+func remove(it item, path []step) {
+	if len(path) == 1 {
+		delete(it, path[0].name)
+		return
+	}
+	parent := get(it, path[:len(path)-1])
+	last := path[len(path)-1]
+	switch {
+	case parent == nil:
+	case last.name != "":
+		delete(parent.M, last.name)
+	case last.index < len(parent.L):
+		parent.L = append(parent.L[:last.index], parent.L[last.index+1:]...)
+	}
+}
+
+// addValues adds change to a number, or its members to a set; a missing
+// current value counts as zero or the empty set.
+func addValues(current *dynamodb.AttributeValue, change *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	switch {
+	case change == nil:
+		panic(invalid("ADD has no value"))
+	case change.N != nil:
+		if current == nil {
+			return change
+		}
+		if current.N == nil {
+			panic(invalid("ADD of a number to an attribute that is not one"))
+		}
+		return number(new(big.Rat).Add(rat(*current.N), rat(*change.N)))
+	case change.SS != nil:
+		if current == nil {
+			current = &dynamodb.AttributeValue{SS: []*string{}}
+		}
+		return &dynamodb.AttributeValue{SS: aws.StringSlice(union(aws.StringValueSlice(current.SS), aws.StringValueSlice(change.SS)))}
+	case change.NS != nil:
+		if current == nil {
+			current = &dynamodb.AttributeValue{NS: []*string{}}
+		}
+		return &dynamodb.AttributeValue{NS: aws.StringSlice(union(aws.StringValueSlice(current.NS), aws.StringValueSlice(change.NS)))}
+	}
+	panic(invalid("ADD takes a number or a set"))
+}
+
+// deleteValues removes change's members from the set current, returning nil
+// when none are left.
+func deleteValues(current *dynamodb.AttributeValue, change *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if current == nil {
+		return nil
+	}
+	without := func(from []*string, members []*string) []*string {
+		drop := map[string]bool{}
+		for _, m := range members {
+			drop[*m] = true
+		}
+		left := []*string{}
+		for _, m := range from {
+			if !drop[*m] {
+				left = append(left, m)
+			}
+		}
+		return left
+	}
+	switch {
+	case change != nil && change.SS != nil && current.SS != nil:
+		if left := without(current.SS, change.SS); len(left) > 0 {
+			return &dynamodb.AttributeValue{SS: left}
+		}
+		return nil
+	case change != nil && change.NS != nil && current.NS != nil:
+		if left := without(current.NS, change.NS); len(left) > 0 {
+			return &dynamodb.AttributeValue{NS: left}
+		}
+		return nil
+	}
+	panic(invalid("DELETE takes a set of the attribute's type"))
+}
+
+func union(a []string, b []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func contains(v *dynamodb.AttributeValue, operand *dynamodb.AttributeValue) bool {
+	switch {
+	case v == nil || operand == nil:
+		return false
+	case v.S != nil && operand.S != nil:
+		return strings.Contains(*v.S, *operand.S)
+	case v.SS != nil && operand.S != nil:
+		for _, s := range v.SS {
+			if *s == *operand.S {
+				return true
+			}
+		}
+	case v.NS != nil && operand.N != nil:
+		for _, n := range v.NS {
+			if rat(*n).Cmp(rat(*operand.N)) == 0 {
+				return true
+			}
+		}
+	case v.L != nil:
+		for _, element := range v.L {
+			if equal(element, operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func size(v *dynamodb.AttributeValue) (int, bool) {
+	switch {
+	case v == nil:
+		return 0, false
+	case v.S != nil:
+		return len(*v.S), true
+	case v.B != nil:
+		return len(v.B), true
+	case v.SS != nil:
+		return len(v.SS), true
+	case v.NS != nil:
+		return len(v.NS), true
+	case v.BS != nil:
+		return len(v.BS), true
+	case v.L != nil:
+		return len(v.L), true
+	case v.M != nil:
+		return len(v.M), true
+	}
+	return 0, false
+}
+
+func typeOf(v *dynamodb.AttributeValue) string {
+	switch {
+	case v.S != nil:
+		return "S"
+	case v.N != nil:
+		return "N"
+	case v.B != nil:
+		return "B"
+	case v.BOOL != nil:
+		return "BOOL"
+	case v.NULL != nil:
+		return "NULL"
+	case v.SS != nil:
+		return "SS"
+	case v.NS != nil:
+		return "NS"
+	case v.BS != nil:
+		return "BS"
+	case v.L != nil:
+		return "L"
+	}
+	return "M"
+}
+
+// compare orders two strings, numbers or binaries of the same type,
+// reporting false for any other pair, which no comparison holds for.
+func compare(a *dynamodb.AttributeValue, b *dynamodb.AttributeValue) (int, bool) {
+	switch {
+	case a == nil || b == nil:
+		return 0, false
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.N != nil && b.N != nil:
+		return rat(*a.N).Cmp(rat(*b.N)), true
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B), true
+	}
+	return 0, false
+}
+
+func equal(a *dynamodb.AttributeValue, b *dynamodb.AttributeValue) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	return reflect.DeepEqual(canonical(a), canonical(b))
+}
+
+// canonical returns v with its sets in order, as sets are equal whatever
+// the order of their members.
+func canonical(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	c := copyValue(v)
+	for _, set := range [][]*string{c.SS, c.NS} {
+		sort.Slice(set, func(i, j int) bool { return *set[i] < *set[j] })
+	}
+	return c
+}
+
+func rat(n string) *big.Rat {
+	r, ok := new(big.Rat).SetString(n)
+	if !ok {
+		panic(invalid("invalid number " + n))
+	}
+	return r
+}
+
+func number(r *big.Rat) *dynamodb.AttributeValue {
+	if r.IsInt() {
+		return &dynamodb.AttributeValue{N: aws.String(r.Num().String())}
+	}
+	return &dynamodb.AttributeValue{N: aws.String(strings.TrimRight(r.FloatString(38), "0"))}
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+	c := item{}
+	for name, v := range it {
+		c[name] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+	c := &dynamodb.AttributeValue{
+		S:    copyString(v.S),
+		N:    copyString(v.N),
+		BOOL: v.BOOL,
+		NULL: v.NULL,
+	}
+	if v.B != nil {
+		c.B = append([]byte{}, v.B...)
+	}
+	if v.SS != nil {
+		c.SS = aws.StringSlice(aws.StringValueSlice(v.SS))
+	}
+	if v.NS != nil {
+		c.NS = aws.StringSlice(aws.StringValueSlice(v.NS))
+	}
+	for _, b := range v.BS {
+		c.BS = append(c.BS, append([]byte{}, b...))
+	}
+	if v.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, len(v.L))
+		for i, element := range v.L {
+			c.L[i] = copyValue(element)
+		}
+	}
+	if v.M != nil {
+		c.M = copyItem(v.M)
+	}
+	return c
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	return aws.String(*s)
+}
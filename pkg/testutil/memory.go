@@ -0,0 +1,1013 @@
+package testutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Table declares a table of a MemoryDynamoDB by its key attributes and
+// global secondary indexes. A table keyed by its partition key alone
+// leaves SortKey empty, as does an index.
+type Table struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+	Indexes      []Index
+}
+
+// Index declares a global secondary index of a Table, which projects every
+// attribute.
+type Index struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+}
+
+// MemoryDynamoDB keeps tables in memory, reading and writing their items as
+// DynamoDB does: items are keyed by their tables' key attributes, queried
+// and scanned in key order a page at a time, and written only when their
+// conditions hold, with expressions read as expression describes. Tables
+// are declared up front or created as a deployment would create them, and
+// can be backed up and restored.
+//
+// Items are copied in and out, so what a test reads back is what was
+// written, except through Item and Items, which return the items
+// themselves for a test to change in place. Every call is recorded, as
+// MockDynamoDB records them, and operations the fake does not implement
+// panic through the embedded nil interface.
+type MemoryDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// Pending is how many times a table, index or backup created or restored
+	// from then on is described as still being created before it is
+	// active, or available
+	Pending int
+
+	recorder
+	state   sync.Mutex
+	tables  map[string]*memoryTable
+	backups []*memoryBackup
+	clock   time.Time
+}
+
+type memoryTable struct {
+	schema      Table
+	description *dynamodb.TableDescription
+	ttl         *dynamodb.TimeToLiveDescription
+	items       map[string]item
+	// pending counts down the descriptions of the table, and of each of its
+	// indexes by name, still to report them being created
+	pending        int
+	indexesPending map[string]int
+}
+
+type memoryBackup struct {
+	details *dynamodb.BackupDetails
+	source  *dynamodb.TableDescription
+	schema  Table
+	items   []item
+	pending int
+}
+
+// NewMemoryDynamoDB returns a MemoryDynamoDB with tables, which are active
+// and empty.
+func NewMemoryDynamoDB(tables ...Table) *MemoryDynamoDB {
+	m := &MemoryDynamoDB{tables: map[string]*memoryTable{}}
+	for _, t := range tables {
+		m.tables[t.Name] = newMemoryTable(t, describeTable(t), 0)
+	}
+	return m
+}
+
+func newMemoryTable(schema Table, description *dynamodb.TableDescription, pending int) *memoryTable {
+	t := &memoryTable{
+		schema:         schema,
+		description:    description,
+		ttl:            &dynamodb.TimeToLiveDescription{TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusDisabled)},
+		items:          map[string]item{},
+		pending:        pending,
+		indexesPending: map[string]int{},
+	}
+	for _, index := range schema.Indexes {
+		t.indexesPending[index.Name] = pending
+	}
+	return t
+}
+
+// describeTable returns the description of a table declared as schema,
+// whose key attributes are strings.
+func describeTable(schema Table) *dynamodb.TableDescription {
+	definitions := map[string]bool{}
+	define := func(names ...string) {
+		for _, name := range names {
+			if name != "" {
+				definitions[name] = true
+			}
+		}
+	}
+	description := &dynamodb.TableDescription{
+		TableName:   aws.String(schema.Name),
+		TableArn:    aws.String("arn:aws:dynamodb:local:000000000000:table/" + schema.Name),
+		TableStatus: aws.String(dynamodb.TableStatusActive),
+		KeySchema:   keySchema(schema.PartitionKey, schema.SortKey),
+	}
+	define(schema.PartitionKey, schema.SortKey)
+	for _, index := range schema.Indexes {
+		define(index.PartitionKey, index.SortKey)
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   aws.String(index.Name),
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+			KeySchema:   keySchema(index.PartitionKey, index.SortKey),
+			Projection:  &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		})
+	}
+	names := []string{}
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		description.AttributeDefinitions = append(description.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	return description
+}
+
+func keySchema(partitionKey string, sortKey string) []*dynamodb.KeySchemaElement {
+	schema := []*dynamodb.KeySchemaElement{{AttributeName: aws.String(partitionKey), KeyType: aws.String(dynamodb.KeyTypeHash)}}
+	if sortKey != "" {
+		schema = append(schema, &dynamodb.KeySchemaElement{AttributeName: aws.String(sortKey), KeyType: aws.String(dynamodb.KeyTypeRange)})
+	}
+	return schema
+}
+
+// keysOf returns the partition and sort key attributes of schema.
+func keysOf(schema []*dynamodb.KeySchemaElement) (string, string) {
+	var partitionKey, sortKey string
+	for _, element := range schema {
+		if aws.StringValue(element.KeyType) == dynamodb.KeyTypeHash {
+			partitionKey = aws.StringValue(element.AttributeName)
+		} else {
+			sortKey = aws.StringValue(element.AttributeName)
+		}
+	}
+	return partitionKey, sortKey
+}
+
+// Put writes items to tableName as they are, whatever is there already, for
+// a test to start from. It panics when there is no such table.
+func (m *MemoryDynamoDB) Put(tableName string, items ...map[string]*dynamodb.AttributeValue) {
+	m.state.Lock()
+	defer m.state.Unlock()
+	t := m.tables[tableName]
+	if t == nil {
+		panic("testutil: no table " + tableName)
+	}
+	for _, it := range items {
+		t.items[t.key(it)] = copyItem(it)
+	}
+}
+
+// Item returns the item at key in tableName, or nil when there is none.
+func (m *MemoryDynamoDB) Item(tableName string, key map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	m.state.Lock()
+	defer m.state.Unlock()
+	if t := m.tables[tableName]; t != nil {
+		return t.items[t.key(key)]
+	}
+	return nil
+}
+
+// Items returns the items of tableName in key order.
+func (m *MemoryDynamoDB) Items(tableName string) []map[string]*dynamodb.AttributeValue {
+	m.state.Lock()
+	defer m.state.Unlock()
+	t := m.tables[tableName]
+	if t == nil {
+		return nil
+	}
+	return t.sorted(t.schema.PartitionKey, t.schema.SortKey)
+}
+
+// Description returns the description of tableName as it stands, without
+// counting as a call to DescribeTable, or nil when there is no such table.
+func (m *MemoryDynamoDB) Description(tableName string) *dynamodb.TableDescription {
+	m.state.Lock()
+	defer m.state.Unlock()
+	if t := m.tables[tableName]; t != nil {
+		return t.status()
+	}
+	return nil
+}
+
+func (m *MemoryDynamoDB) lock() func() {
+	m.state.Lock()
+	return m.state.Unlock
+}
+
+// validate recovers an invalid request as the ValidationException DynamoDB
+// answers it with.
+func validate(err *error) {
+	if r := recover(); r != nil {
+		message, ok := r.(invalid)
+		if !ok {
+			panic(r)
+		}
+		*err = awserr.New("ValidationException", string(message), nil)
+	}
+}
+
+func notFound() error {
+	return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Requested resource not found", nil)
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+func (m *MemoryDynamoDB) table(name *string) (*memoryTable, error) {
+	t := m.tables[aws.StringValue(name)]
+	if t == nil {
+		return nil, notFound()
+	}
+	return t, nil
+}
+
+// key returns the key it is stored under of an item, or of the key of one,
+// which must have the table's key attributes and no others.
+func (t *memoryTable) key(it item) string {
+	return keyString(it, t.schema.PartitionKey, t.schema.SortKey)
+}
+
+func keyString(it item, partitionKey string, sortKey string) string {
+	key := ""
+	for _, name := range []string{partitionKey, sortKey} {
+		if name == "" {
+			continue
+		}
+		v := it[name]
+		switch {
+		case v == nil:
+			panic(invalid("missing the key " + name + " in the item"))
+		case v.S != nil:
+			key += "S" + *v.S
+		case v.N != nil:
+			key += "N" + rat(*v.N).RatString()
+		case v.B != nil:
+			key += "B" + base64.StdEncoding.EncodeToString(v.B)
+		default:
+			panic(invalid("the key " + name + " is not a string, number or binary"))
+		}
+		key += "\x00"
+	}
+	return key
+}
+
+// keyOnly checks that key holds the table's key attributes and no others.
+func (t *memoryTable) keyOnly(key item) {
+	want := 1
+	if t.schema.SortKey != "" {
+		want = 2
+	}
+	if len(key) != want {
+		panic(invalid("the provided key element does not match the schema"))
+	}
+	t.key(key)
+}
+
+// keyAttributes returns the attributes of it that key it in the table and,
+// when partitionKey names an index, in the index.
+func (t *memoryTable) keyAttributes(it item, partitionKey string, sortKey string) item {
+	key := item{}
+	for _, name := range []string{t.schema.PartitionKey, t.schema.SortKey, partitionKey, sortKey} {
+		if name != "" {
+			key[name] = copyValue(it[name])
+		}
+	}
+	return key
+}
+
+// sorted returns the items keyed by partitionKey and sortKey, which are the
+// table's or an index's, in order of those keys and then the table's. Items
+// without an index's keys are not in it.
+func (t *memoryTable) sorted(partitionKey string, sortKey string) []item {
+	items := []item{}
+	for _, it := range t.items {
+		if it[partitionKey] != nil && (sortKey == "" || it[sortKey] != nil) {
+			items = append(items, it)
+		}
+	}
+	less := t.less(partitionKey, sortKey)
+	sort.Slice(items, func(i, j int) bool { return less(items[i], items[j]) })
+	return items
+}
+
+// less orders items by partitionKey and sortKey, which are the table's or
+// an index's, and then by the table's.
+func (t *memoryTable) less(partitionKey string, sortKey string) func(a item, b item) bool {
+	order := []string{partitionKey, sortKey, t.schema.PartitionKey, t.schema.SortKey}
+	return func(a item, b item) bool {
+		for _, name := range order {
+			if name == "" {
+				continue
+			}
+			if c, _ := compare(a[name], b[name]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	}
+}
+
+// index returns the key attributes of the table, or of its index name.
+func (t *memoryTable) index(name *string) (string, string) {
+	if name == nil {
+		return t.schema.PartitionKey, t.schema.SortKey
+	}
+	for _, index := range t.schema.Indexes {
+		if index.Name == *name {
+			return index.PartitionKey, index.SortKey
+		}
+	}
+	panic(invalid("the table does not have the specified index: " + *name))
+}
+
+func (t *memoryTable) describe() *dynamodb.TableDescription {
+	description := awsutil.CopyOf(t.description).(*dynamodb.TableDescription)
+	description.ItemCount = aws.Int64(int64(len(t.items)))
+	return description
+}
+
+// holds reports whether condition holds for it, the item a write would
+// change, which is empty when there is none.
+func holds(condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue, it item) bool {
+	if condition == nil {
+		return true
+	}
+	if it == nil {
+		it = item{}
+	}
+	return newExpression(*condition, names, values).condition()(it)
+}
+
+func project(projection *string, names map[string]*string, it item) item {
+	if projection == nil || it == nil {
+		return copyItem(it)
+	}
+	projected := item{}
+	for _, name := range newExpression(*projection, names, nil).projection() {
+		if v, ok := it[name]; ok {
+			projected[name] = copyValue(v)
+		}
+	}
+	return projected
+}
+
+// page reads items in order, from the first that comes after start when
+// given, for a Query or Scan: up to limit items that match, of which those
+// that pass filter are kept. Items are in order by partitionKey and sortKey,
+// backwards when forward is false, so a page starts where the last ended
+// even when the item it ended with has since been deleted.
+func (t *memoryTable) page(items []item, start item, forward bool, limit *int64, match func(item) bool, filter func(item) bool, partitionKey string, sortKey string) (kept []item, scanned int64, last item) {
+	if start != nil {
+		less := t.less(partitionKey, sortKey)
+		for len(items) > 0 && (forward && !less(start, items[0]) || !forward && !less(items[0], start)) {
+			items = items[1:]
+		}
+	}
+	kept = []item{}
+	for i, it := range items {
+		if !match(it) {
+			continue
+		}
+		scanned++
+		if filter(it) {
+			kept = append(kept, it)
+		}
+		if limit != nil && scanned == *limit {
+			for _, rest := range items[i+1:] {
+				if match(rest) {
+					return kept, scanned, t.keyAttributes(it, partitionKey, sortKey)
+				}
+			}
+			break
+		}
+	}
+	return kept, scanned, nil
+}
+
+func (m *MemoryDynamoDB) GetItem(input *dynamodb.GetItemInput) (output *dynamodb.GetItemOutput, err error) {
+	m.record("GetItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	t.keyOnly(input.Key)
+	output = &dynamodb.GetItemOutput{}
+	if it, ok := t.items[t.key(input.Key)]; ok {
+		output.Item = project(input.ProjectionExpression, input.ExpressionAttributeNames, it)
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) PutItem(input *dynamodb.PutItemInput) (output *dynamodb.PutItemOutput, err error) {
+	m.record("PutItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	key := t.key(input.Item)
+	old := t.items[key]
+	if !holds(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, old) {
+		return nil, conditionFailed()
+	}
+	t.items[key] = copyItem(input.Item)
+	output = &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = copyItem(old)
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (output *dynamodb.DeleteItemOutput, err error) {
+	m.record("DeleteItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	t.keyOnly(input.Key)
+	key := t.key(input.Key)
+	old := t.items[key]
+	if !holds(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, old) {
+		return nil, conditionFailed()
+	}
+	delete(t.items, key)
+	output = &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = copyItem(old)
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (output *dynamodb.UpdateItemOutput, err error) {
+	m.record("UpdateItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	old, after, err := t.update(input.Key, input.UpdateExpression, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	t.items[t.key(input.Key)] = after
+	output = &dynamodb.UpdateItemOutput{}
+	switch aws.StringValue(input.ReturnValues) {
+	case dynamodb.ReturnValueAllOld:
+		output.Attributes = copyItem(old)
+	case dynamodb.ReturnValueAllNew:
+		output.Attributes = copyItem(after)
+	case dynamodb.ReturnValueUpdatedOld:
+		output.Attributes = changed(old, after, old)
+	case dynamodb.ReturnValueUpdatedNew:
+		output.Attributes = changed(old, after, after)
+	}
+	return output, nil
+}
+
+// update returns the item at key before and after expression is made to
+// it, failing when condition does not hold.
+func (t *memoryTable) update(key item, expression *string, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (item, item, error) {
+	t.keyOnly(key)
+	if expression == nil {
+		panic(invalid("the update expression is missing"))
+	}
+	old := t.items[t.key(key)]
+	if !holds(condition, names, values, old) {
+		return nil, nil, conditionFailed()
+	}
+	before := copyItem(old)
+	if before == nil {
+		before = copyItem(key)
+	}
+	after := newExpression(*expression, names, values).update()(before)
+	if t.key(after) != t.key(key) {
+		panic(invalid("an update cannot change the item's key"))
+	}
+	return old, after, nil
+}
+
+// changed returns the attributes of from that differ between before and
+// after.
+func changed(before item, after item, from item) item {
+	attributes := item{}
+	for _, name := range union(names(before), names(after)) {
+		if !equal(before[name], after[name]) && from[name] != nil {
+			attributes[name] = copyValue(from[name])
+		}
+	}
+	return attributes
+}
+
+func names(it item) []string {
+	names := []string{}
+	for name := range it {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *MemoryDynamoDB) Query(input *dynamodb.QueryInput) (output *dynamodb.QueryOutput, err error) {
+	m.record("Query", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if input.IndexName != nil && aws.BoolValue(input.ConsistentRead) {
+		panic(invalid("consistent reads are not supported on global secondary indexes"))
+	}
+	if input.KeyConditionExpression == nil {
+		panic(invalid("the key condition expression is missing"))
+	}
+	partitionKey, sortKey := t.index(input.IndexName)
+	match := newExpression(*input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues).condition()
+	items := t.sorted(partitionKey, sortKey)
+	forward := input.ScanIndexForward == nil || *input.ScanIndexForward
+	if !forward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	kept, scanned, last := t.page(items, input.ExclusiveStartKey, forward, input.Limit, match, filter(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues), partitionKey, sortKey)
+	output = &dynamodb.QueryOutput{Count: aws.Int64(int64(len(kept))), ScannedCount: aws.Int64(scanned), LastEvaluatedKey: last}
+	if aws.StringValue(input.Select) != dynamodb.SelectCount {
+		output.Items = []map[string]*dynamodb.AttributeValue{}
+		for _, it := range kept {
+			output.Items = append(output.Items, project(input.ProjectionExpression, input.ExpressionAttributeNames, it))
+		}
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) Scan(input *dynamodb.ScanInput) (output *dynamodb.ScanOutput, err error) {
+	m.record("Scan", input)
+	defer m.lock()()
+	defer validate(&err)
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	partitionKey, sortKey := t.index(input.IndexName)
+	items := t.sorted(partitionKey, sortKey)
+	if input.TotalSegments != nil {
+		segment := []item{}
+		for i, it := range items {
+			if int64(i)%*input.TotalSegments == aws.Int64Value(input.Segment) {
+				segment = append(segment, it)
+			}
+		}
+		items = segment
+	}
+	all := func(item) bool { return true }
+	kept, scanned, last := t.page(items, input.ExclusiveStartKey, true, input.Limit, all, filter(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues), partitionKey, sortKey)
+	output = &dynamodb.ScanOutput{Count: aws.Int64(int64(len(kept))), ScannedCount: aws.Int64(scanned), LastEvaluatedKey: last}
+	if aws.StringValue(input.Select) != dynamodb.SelectCount {
+		output.Items = []map[string]*dynamodb.AttributeValue{}
+		for _, it := range kept {
+			output.Items = append(output.Items, project(input.ProjectionExpression, input.ExpressionAttributeNames, it))
+		}
+	}
+	return output, nil
+}
+
+func filter(expression *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) func(item) bool {
+	if expression == nil {
+		return func(item) bool { return true }
+	}
+	return newExpression(*expression, names, values).condition()
+}
+
+func (m *MemoryDynamoDB) BatchGetItem(input *dynamodb.BatchGetItemInput) (output *dynamodb.BatchGetItemOutput, err error) {
+	m.record("BatchGetItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	output = &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+	count := 0
+	for tableName, read := range input.RequestItems {
+		t, err := m.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		found := []map[string]*dynamodb.AttributeValue{}
+		for _, key := range read.Keys {
+			if count++; count > 100 {
+				panic(invalid("too many items requested for the BatchGetItem call"))
+			}
+			t.keyOnly(key)
+			if it, ok := t.items[t.key(key)]; ok {
+				found = append(found, project(read.ProjectionExpression, read.ExpressionAttributeNames, it))
+			}
+		}
+		output.Responses[tableName] = found
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (output *dynamodb.BatchWriteItemOutput, err error) {
+	m.record("BatchWriteItem", input)
+	defer m.lock()()
+	defer validate(&err)
+	count := 0
+	for tableName, writes := range input.RequestItems {
+		t, err := m.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		for _, write := range writes {
+			if count++; count > 25 {
+				panic(invalid("too many items requested for the BatchWriteItem call"))
+			}
+			switch {
+			case write.PutRequest != nil:
+				t.items[t.key(write.PutRequest.Item)] = copyItem(write.PutRequest.Item)
+			case write.DeleteRequest != nil:
+				t.keyOnly(write.DeleteRequest.Key)
+				delete(t.items, t.key(write.DeleteRequest.Key))
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}, nil
+}
+
+// TransactWriteItems makes every write or none: when a condition fails, the
+// transaction is canceled with the reason of each write, in order.
+func (m *MemoryDynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (output *dynamodb.TransactWriteItemsOutput, err error) {
+	m.record("TransactWriteItems", input)
+	defer m.lock()()
+	defer validate(&err)
+	if len(input.TransactItems) > 100 {
+		panic(invalid("too many items in the transaction"))
+	}
+	type write struct {
+		table *memoryTable
+		key   string
+		after item
+	}
+	writes := []write{}
+	reasons := make([]*dynamodb.CancellationReason, len(input.TransactItems))
+	canceled := false
+	seen := map[string]bool{}
+	for i, w := range input.TransactItems {
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+		var tableName, condition *string
+		var key item
+		var names map[string]*string
+		var values map[string]*dynamodb.AttributeValue
+		switch {
+		case w.ConditionCheck != nil:
+			tableName, key, condition, names, values = w.ConditionCheck.TableName, w.ConditionCheck.Key, w.ConditionCheck.ConditionExpression, w.ConditionCheck.ExpressionAttributeNames, w.ConditionCheck.ExpressionAttributeValues
+		case w.Put != nil:
+			tableName, key, condition, names, values = w.Put.TableName, w.Put.Item, w.Put.ConditionExpression, w.Put.ExpressionAttributeNames, w.Put.ExpressionAttributeValues
+		case w.Delete != nil:
+			tableName, key, condition, names, values = w.Delete.TableName, w.Delete.Key, w.Delete.ConditionExpression, w.Delete.ExpressionAttributeNames, w.Delete.ExpressionAttributeValues
+		case w.Update != nil:
+			tableName, key, condition, names, values = w.Update.TableName, w.Update.Key, w.Update.ConditionExpression, w.Update.ExpressionAttributeNames, w.Update.ExpressionAttributeValues
+		default:
+			panic(invalid("a transaction write has no action"))
+		}
+		t, err := m.table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		k := t.key(key)
+		if seen[*tableName+"\x00"+k] {
+			panic(invalid("transaction request cannot include multiple operations on one item"))
+		}
+		seen[*tableName+"\x00"+k] = true
+		if w.Update != nil {
+			_, after, err := t.update(key, w.Update.UpdateExpression, condition, names, values)
+			if err != nil {
+				reasons[i].Code = aws.String("ConditionalCheckFailed")
+				canceled = true
+				continue
+			}
+			writes = append(writes, write{t, k, after})
+			continue
+		}
+		if w.Put == nil {
+			t.keyOnly(key)
+		}
+		if !holds(condition, names, values, t.items[k]) {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			canceled = true
+			continue
+		}
+		switch {
+		case w.Put != nil:
+			writes = append(writes, write{t, k, copyItem(w.Put.Item)})
+		case w.Delete != nil:
+			writes = append(writes, write{t, k, nil})
+		}
+	}
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_:            aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+	for _, w := range writes {
+		if w.after == nil {
+			delete(w.table.items, w.key)
+		} else {
+			w.table.items[w.key] = w.after
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// CreateTable creates a table with the keys and indexes of input, described
+// as being created Pending times.
+func (m *MemoryDynamoDB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.record("CreateTable", input)
+	defer m.lock()()
+	name := aws.StringValue(input.TableName)
+	if m.tables[name] != nil {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "Table already exists: "+name, nil)
+	}
+	schema := Table{Name: name}
+	schema.PartitionKey, schema.SortKey = keysOf(input.KeySchema)
+	description := &dynamodb.TableDescription{
+		TableName:            input.TableName,
+		TableArn:             aws.String("arn:aws:dynamodb:local:000000000000:table/" + name),
+		TableStatus:          aws.String(dynamodb.TableStatusActive),
+		KeySchema:            input.KeySchema,
+		AttributeDefinitions: input.AttributeDefinitions,
+		StreamSpecification:  input.StreamSpecification,
+	}
+	for _, index := range input.GlobalSecondaryIndexes {
+		partitionKey, sortKey := keysOf(index.KeySchema)
+		schema.Indexes = append(schema.Indexes, Index{Name: aws.StringValue(index.IndexName), PartitionKey: partitionKey, SortKey: sortKey})
+		description.GlobalSecondaryIndexes = append(description.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   index.IndexName,
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+			KeySchema:   index.KeySchema,
+			Projection:  index.Projection,
+		})
+	}
+	description = awsutil.CopyOf(description).(*dynamodb.TableDescription)
+	t := newMemoryTable(schema, description, m.Pending)
+	m.tables[name] = t
+	return &dynamodb.CreateTableOutput{TableDescription: t.status()}, nil
+}
+
+// status returns the table's description with the status it reports, before
+// counting a description of it.
+func (t *memoryTable) status() *dynamodb.TableDescription {
+	description := t.describe()
+	if t.pending > 0 {
+		description.TableStatus = aws.String(dynamodb.TableStatusCreating)
+		if description.RestoreSummary != nil {
+			description.RestoreSummary.RestoreInProgress = aws.Bool(true)
+		}
+	}
+	for _, index := range description.GlobalSecondaryIndexes {
+		if t.indexesPending[aws.StringValue(index.IndexName)] > 0 {
+			index.IndexStatus = aws.String(dynamodb.IndexStatusCreating)
+		}
+	}
+	return description
+}
+
+func (m *MemoryDynamoDB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	m.record("DescribeTable", input)
+	defer m.lock()()
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	description := t.status()
+	if t.pending > 0 {
+		t.pending--
+	}
+	for name, pending := range t.indexesPending {
+		if pending > 0 {
+			t.indexesPending[name]--
+		}
+	}
+	return &dynamodb.DescribeTableOutput{Table: description}, nil
+}
+
+// UpdateTable changes the stream of a table and creates indexes, described
+// as being created Pending times.
+func (m *MemoryDynamoDB) UpdateTable(input *dynamodb.UpdateTableInput) (*dynamodb.UpdateTableOutput, error) {
+	m.record("UpdateTable", input)
+	defer m.lock()()
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	input = awsutil.CopyOf(input).(*dynamodb.UpdateTableInput)
+	if input.StreamSpecification != nil {
+		t.description.StreamSpecification = input.StreamSpecification
+	}
+	for _, update := range input.GlobalSecondaryIndexUpdates {
+		if update.Create == nil {
+			continue
+		}
+		partitionKey, sortKey := keysOf(update.Create.KeySchema)
+		name := aws.StringValue(update.Create.IndexName)
+		t.schema.Indexes = append(t.schema.Indexes, Index{Name: name, PartitionKey: partitionKey, SortKey: sortKey})
+		t.description.GlobalSecondaryIndexes = append(t.description.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   aws.String(name),
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+			KeySchema:   update.Create.KeySchema,
+			Projection:  update.Create.Projection,
+		})
+		t.indexesPending[name] = m.Pending
+	}
+	t.description.AttributeDefinitions = append(t.description.AttributeDefinitions, input.AttributeDefinitions...)
+	return &dynamodb.UpdateTableOutput{TableDescription: t.status()}, nil
+}
+
+func (m *MemoryDynamoDB) DescribeTimeToLive(input *dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	m.record("DescribeTimeToLive", input)
+	defer m.lock()()
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: awsutil.CopyOf(t.ttl).(*dynamodb.TimeToLiveDescription)}, nil
+}
+
+func (m *MemoryDynamoDB) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	m.record("UpdateTimeToLive", input)
+	defer m.lock()()
+	t, err := m.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+	t.ttl = &dynamodb.TimeToLiveDescription{TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusDisabled)}
+	if aws.BoolValue(input.TimeToLiveSpecification.Enabled) {
+		t.ttl = &dynamodb.TimeToLiveDescription{
+			AttributeName:    input.TimeToLiveSpecification.AttributeName,
+			TimeToLiveStatus: aws.String(dynamodb.TimeToLiveStatusEnabled),
+		}
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: input.TimeToLiveSpecification}, nil
+}
+
+// now returns the time, later than any it returned before, so backups made
+// one after another are ordered by when they were made.
+func (m *MemoryDynamoDB) now() time.Time {
+	now := time.Now().UTC()
+	if !now.After(m.clock) {
+		now = m.clock.Add(time.Nanosecond)
+	}
+	m.clock = now
+	return now
+}
+
+// CreateBackup backs up the items of a table as they are, described as
+// being created Pending times.
+func (m *MemoryDynamoDB) CreateBackup(input *dynamodb.CreateBackupInput) (*dynamodb.CreateBackupOutput, error) {
+	m.record("CreateBackup", input)
+	defer m.lock()()
+	t := m.tables[aws.StringValue(input.TableName)]
+	if t == nil {
+		return nil, awserr.New(dynamodb.ErrCodeTableNotFoundException, "Table not found: "+aws.StringValue(input.TableName), nil)
+	}
+	created := m.now()
+	b := &memoryBackup{
+		details: &dynamodb.BackupDetails{
+			BackupArn:              aws.String(fmt.Sprintf("%s/backup/%020d", *t.description.TableArn, created.UnixNano())),
+			BackupName:             input.BackupName,
+			BackupStatus:           aws.String(dynamodb.BackupStatusAvailable),
+			BackupType:             aws.String(dynamodb.BackupTypeUser),
+			BackupCreationDateTime: &created,
+		},
+		source:  t.describe(),
+		schema:  t.schema,
+		pending: m.Pending,
+	}
+	for _, it := range t.sorted(t.schema.PartitionKey, t.schema.SortKey) {
+		b.items = append(b.items, copyItem(it))
+	}
+	m.backups = append(m.backups, b)
+	return &dynamodb.CreateBackupOutput{BackupDetails: b.status()}, nil
+}
+
+func (b *memoryBackup) status() *dynamodb.BackupDetails {
+	details := awsutil.CopyOf(b.details).(*dynamodb.BackupDetails)
+	if b.pending > 0 {
+		details.BackupStatus = aws.String(dynamodb.BackupStatusCreating)
+	}
+	return details
+}
+
+// ListBackups lists the backups of a table, or of every table, in the order
+// they were made.
+func (m *MemoryDynamoDB) ListBackups(input *dynamodb.ListBackupsInput) (*dynamodb.ListBackupsOutput, error) {
+	m.record("ListBackups", input)
+	defer m.lock()()
+	output := &dynamodb.ListBackupsOutput{BackupSummaries: []*dynamodb.BackupSummary{}}
+	for _, b := range m.backups {
+		if input.TableName != nil && *input.TableName != *b.source.TableName {
+			continue
+		}
+		details := b.status()
+		output.BackupSummaries = append(output.BackupSummaries, &dynamodb.BackupSummary{
+			BackupArn:              details.BackupArn,
+			BackupName:             details.BackupName,
+			BackupStatus:           details.BackupStatus,
+			BackupType:             details.BackupType,
+			BackupCreationDateTime: details.BackupCreationDateTime,
+			TableName:              b.source.TableName,
+			TableArn:               b.source.TableArn,
+		})
+	}
+	return output, nil
+}
+
+func (m *MemoryDynamoDB) backup(arn *string) *memoryBackup {
+	for _, b := range m.backups {
+		if *b.details.BackupArn == aws.StringValue(arn) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDynamoDB) DescribeBackup(input *dynamodb.DescribeBackupInput) (*dynamodb.DescribeBackupOutput, error) {
+	m.record("DescribeBackup", input)
+	defer m.lock()()
+	b := m.backup(input.BackupArn)
+	if b == nil {
+		return nil, awserr.New(dynamodb.ErrCodeBackupNotFoundException, "Backup not found", nil)
+	}
+	details := b.status()
+	if b.pending > 0 {
+		b.pending--
+	}
+	return &dynamodb.DescribeBackupOutput{BackupDescription: &dynamodb.BackupDescription{
+		BackupDetails: details,
+		SourceTableDetails: &dynamodb.SourceTableDetails{
+			TableName: b.source.TableName,
+			TableArn:  b.source.TableArn,
+			KeySchema: b.source.KeySchema,
+			ItemCount: aws.Int64(int64(len(b.items))),
+		},
+	}}, nil
+}
+
+// RestoreTableFromBackup creates a table holding the items of an available
+// backup, described as being restored Pending times.
+func (m *MemoryDynamoDB) RestoreTableFromBackup(input *dynamodb.RestoreTableFromBackupInput) (*dynamodb.RestoreTableFromBackupOutput, error) {
+	m.record("RestoreTableFromBackup", input)
+	defer m.lock()()
+	b := m.backup(input.BackupArn)
+	switch {
+	case b == nil:
+		return nil, awserr.New(dynamodb.ErrCodeBackupNotFoundException, "Backup not found", nil)
+	case b.pending > 0:
+		return nil, awserr.New(dynamodb.ErrCodeBackupInUseException, "Backup is being created", nil)
+	case m.tables[aws.StringValue(input.TargetTableName)] != nil:
+		return nil, awserr.New(dynamodb.ErrCodeTableAlreadyExistsException, "Table already exists: "+aws.StringValue(input.TargetTableName), nil)
+	}
+	schema := b.schema
+	schema.Name = aws.StringValue(input.TargetTableName)
+	description := awsutil.CopyOf(b.source).(*dynamodb.TableDescription)
+	description.TableName = input.TargetTableName
+	description.TableArn = aws.String("arn:aws:dynamodb:local:000000000000:table/" + schema.Name)
+	description.TableStatus = aws.String(dynamodb.TableStatusActive)
+	description.RestoreSummary = &dynamodb.RestoreSummary{
+		SourceBackupArn:   b.details.BackupArn,
+		SourceTableArn:    b.source.TableArn,
+		RestoreDateTime:   aws.Time(m.now()),
+		RestoreInProgress: aws.Bool(false),
+	}
+	t := newMemoryTable(schema, description, m.Pending)
+	for _, it := range b.items {
+		t.items[t.key(it)] = copyItem(it)
+	}
+	m.tables[schema.Name] = t
+	return &dynamodb.RestoreTableFromBackupOutput{TableDescription: t.status()}, nil
+}
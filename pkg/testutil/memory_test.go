@@ -0,0 +1,191 @@
+package testutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func s(value string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{S: aws.String(value)}
+}
+
+func n(value string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(value)}
+}
+
+func code(err error) string {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+func TestMemoryDynamoDB(t *testing.T) {
+	widgets := Table{Name: "widgets", PartitionKey: "pk", SortKey: "sk", Indexes: []Index{{Name: "sk-index", PartitionKey: "sk", SortKey: "pk"}}}
+
+	t.Run("expect a put to be read back only when its condition holds", func(t *testing.T) {
+		db := NewMemoryDynamoDB(widgets)
+		put := &dynamodb.PutItemInput{
+			TableName:                aws.String("widgets"),
+			Item:                     map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear"), "size": n("3")},
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: map[string]*string{"#pk": aws.String("pk")},
+		}
+		if _, err := db.PutItem(put); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := db.PutItem(put); code(err) != dynamodb.ErrCodeConditionalCheckFailedException {
+			t.Errorf("Expected the second put to fail its condition, got %v", err)
+		}
+		output, _ := db.GetItem(&dynamodb.GetItemInput{TableName: aws.String("widgets"), Key: map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear")}})
+		if output.Item == nil || *output.Item["size"].N != "3" {
+			t.Errorf("Expected the item put, got %v", output.Item)
+		}
+		if _, err := db.GetItem(&dynamodb.GetItemInput{TableName: aws.String("widgets"), Key: map[string]*dynamodb.AttributeValue{"pk": s("alan")}}); code(err) != "ValidationException" {
+			t.Errorf("Expected a key without its sort key to be refused, got %v", err)
+		}
+		if _, err := db.GetItem(&dynamodb.GetItemInput{TableName: aws.String("gadgets"), Key: map[string]*dynamodb.AttributeValue{"pk": s("alan")}}); code(err) != dynamodb.ErrCodeResourceNotFoundException {
+			t.Errorf("Expected an unknown table not to be found, got %v", err)
+		}
+	})
+
+	t.Run("expect an update to set, add and remove from the item as it was", func(t *testing.T) {
+		db := NewMemoryDynamoDB(widgets)
+		db.Put("widgets", map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear"), "size": n("3"), "colour": s("red")})
+		output, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                 aws.String("widgets"),
+			Key:                       map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear")},
+			UpdateExpression:          aws.String("SET previous = #size, #size = #size + :one, made = if_not_exists(made, :now) REMOVE colour ADD tags :tags, count :one"),
+			ConditionExpression:       aws.String("#size BETWEEN :one AND :ten AND NOT contains(colour, :blue)"),
+			ExpressionAttributeNames:  map[string]*string{"#size": aws.String("size")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":one": n("1"), ":ten": n("10"), ":now": s("today"), ":blue": s("blue"), ":tags": {SS: aws.StringSlice([]string{"new"})}},
+			ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		got := output.Attributes
+		if *got["previous"].N != "3" || *got["size"].N != "4" || *got["made"].S != "today" || got["colour"] != nil || *got["tags"].SS[0] != "new" || *got["count"].N != "1" {
+			t.Errorf("Expected every action made, got %v", got)
+		}
+		_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                 aws.String("widgets"),
+			Key:                       map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear")},
+			UpdateExpression:          aws.String("ADD tags :tags"),
+			ConditionExpression:       aws.String("size(tags) < :one"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":one": n("1"), ":tags": {SS: aws.StringSlice([]string{"more"})}},
+		})
+		if code(err) != dynamodb.ErrCodeConditionalCheckFailedException {
+			t.Errorf("Expected the update to fail its condition, got %v", err)
+		}
+	})
+
+	t.Run("expect a query to read an index in pages and in either direction", func(t *testing.T) {
+		db := NewMemoryDynamoDB(widgets)
+		for _, owner := range []string{"carol", "alan", "bob"} {
+			db.Put("widgets", map[string]*dynamodb.AttributeValue{"pk": s(owner), "sk": s("WIDGET#gear")})
+		}
+		db.Put("widgets", map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("GADGET#lever")})
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String("widgets"),
+			IndexName:                 aws.String("sk-index"),
+			KeyConditionExpression:    aws.String("sk = :sk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":sk": s("WIDGET#gear")},
+			Limit:                     aws.Int64(2),
+		}
+		owners := []string{}
+		for {
+			output, err := db.Query(input)
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+			for _, item := range output.Items {
+				owners = append(owners, *item["pk"].S)
+			}
+			if output.LastEvaluatedKey == nil {
+				break
+			}
+			input.ExclusiveStartKey = output.LastEvaluatedKey
+		}
+		if len(owners) != 3 || owners[0] != "alan" || owners[2] != "carol" {
+			t.Errorf("Expected each owner of a gear in order, got %v", owners)
+		}
+		output, _ := db.Query(&dynamodb.QueryInput{
+			TableName:                 aws.String("widgets"),
+			KeyConditionExpression:    aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": s("alan"), ":prefix": s("WIDGET#")},
+			ScanIndexForward:          aws.Bool(false),
+		})
+		if len(output.Items) != 1 || *output.Items[0]["sk"].S != "WIDGET#gear" {
+			t.Errorf("Expected alan's widgets alone, got %v", output.Items)
+		}
+		if _, err := db.Query(&dynamodb.QueryInput{TableName: aws.String("widgets"), IndexName: aws.String("sk-index"), KeyConditionExpression: input.KeyConditionExpression, ExpressionAttributeValues: input.ExpressionAttributeValues, ConsistentRead: aws.Bool(true)}); code(err) != "ValidationException" {
+			t.Errorf("Expected a consistent read of an index to be refused, got %v", err)
+		}
+	})
+
+	t.Run("expect a transaction to make every write or none", func(t *testing.T) {
+		db := NewMemoryDynamoDB(widgets)
+		db.Put("widgets", map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear")})
+		transaction := &dynamodb.TransactWriteItemsInput{TransactItems: []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{TableName: aws.String("widgets"), Item: map[string]*dynamodb.AttributeValue{"pk": s("bob"), "sk": s("gear")}}},
+			{Delete: &dynamodb.Delete{TableName: aws.String("widgets"), Key: map[string]*dynamodb.AttributeValue{"pk": s("alan"), "sk": s("gear")}, ConditionExpression: aws.String("attribute_exists(colour)")}},
+		}}
+		_, err := db.TransactWriteItems(transaction)
+		var canceled *dynamodb.TransactionCanceledException
+		if !errors.As(err, &canceled) || *canceled.CancellationReasons[0].Code != "None" || *canceled.CancellationReasons[1].Code != "ConditionalCheckFailed" {
+			t.Fatalf("Expected the transaction canceled by its delete, got %v", err)
+		}
+		if items := db.Items("widgets"); len(items) != 1 || *items[0]["pk"].S != "alan" {
+			t.Errorf("Expected nothing written, got %v", items)
+		}
+		transaction.TransactItems[1].Delete.ConditionExpression = nil
+		if _, err := db.TransactWriteItems(transaction); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if items := db.Items("widgets"); len(items) != 1 || *items[0]["pk"].S != "bob" {
+			t.Errorf("Expected both writes made, got %v", items)
+		}
+	})
+
+	t.Run("expect a table created, backed up and restored to be described as being created until it is not", func(t *testing.T) {
+		db := NewMemoryDynamoDB()
+		db.Pending = 1
+		_, err := db.CreateTable(&dynamodb.CreateTableInput{
+			TableName: aws.String("widgets"),
+			KeySchema: []*dynamodb.KeySchemaElement{{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)}},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		statuses := []string{}
+		for i := 0; i < 2; i++ {
+			output, _ := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String("widgets")})
+			statuses = append(statuses, *output.Table.TableStatus)
+		}
+		if statuses[0] != dynamodb.TableStatusCreating || statuses[1] != dynamodb.TableStatusActive {
+			t.Errorf("Expected the table created and then active, got %v", statuses)
+		}
+
+		db.Put("widgets", map[string]*dynamodb.AttributeValue{"pk": s("gear")})
+		backup, _ := db.CreateBackup(&dynamodb.CreateBackupInput{TableName: aws.String("widgets"), BackupName: aws.String("nightly")})
+		if _, err := db.RestoreTableFromBackup(&dynamodb.RestoreTableFromBackupInput{BackupArn: backup.BackupDetails.BackupArn, TargetTableName: aws.String("restored")}); code(err) != dynamodb.ErrCodeBackupInUseException {
+			t.Errorf("Expected a backup being created not to be restored, got %v", err)
+		}
+		db.DescribeBackup(&dynamodb.DescribeBackupInput{BackupArn: backup.BackupDetails.BackupArn})
+		if _, err := db.RestoreTableFromBackup(&dynamodb.RestoreTableFromBackupInput{BackupArn: backup.BackupDetails.BackupArn, TargetTableName: aws.String("restored")}); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if items := db.Items("restored"); len(items) != 1 || *items[0]["pk"].S != "gear" {
+			t.Errorf("Expected the backed up item restored, got %v", items)
+		}
+		if restoring := db.Description("restored").RestoreSummary; restoring == nil || *restoring.SourceBackupArn != *backup.BackupDetails.BackupArn {
+			t.Errorf("Expected the table restored from the backup, got %v", restoring)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToRecordActivity = "failed to record activity"
+	ErrorInvalidInactiveDays    = "inactiveDays must be a positive whole number"
+)
+
+// activityInterval is how stale lastSeenAt must be before activity is
+// recorded again, so busy users are not written to on every request.
+var activityInterval = 5 * time.Minute
+
+// RecordLogin sets lastLoginAt and lastSeenAt to now.
+func RecordLogin(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	now := &dynamodb.AttributeValue{S: aws.String(time.Now().UTC().Format(time.RFC3339))}
+	update := store.NewUpdate().Set("lastLoginAt", now).Set("lastSeenAt", now).Exists("email")
+	_, err := dynaClient.UpdateItem(update.Input(userKey(email), tableName))
+	cache.invalidate(tableName, email)
+	return activityError(err)
+}
+
+// RecordActivity sets lastSeenAt to now, unless it was set within the last
+// few minutes.
+func RecordActivity(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	now := time.Now().UTC()
+	update := store.NewUpdate().
+		Set("lastSeenAt", &dynamodb.AttributeValue{S: aws.String(now.Format(time.RFC3339))}).
+		Exists("email").
+		Condition("attribute_not_exists(#lastSeenAt) OR #lastSeenAt < :stale", map[string]*dynamodb.AttributeValue{
+			":stale": {S: aws.String(now.Add(-activityInterval).Format(time.RFC3339))},
+		})
+	_, err := dynaClient.UpdateItem(update.Input(userKey(email), tableName))
+	if err == nil {
+		cache.invalidate(tableName, email)
+	}
+	return activityError(err)
+}
+
+// activityError ignores failed conditions: the user is gone, or their
+// activity was recorded recently enough.
+func activityError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return store.Error(err, ErrorFailedToRecordActivity)
+}
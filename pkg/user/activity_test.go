@@ -0,0 +1,44 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestActivity(t *testing.T) {
+	t.Run("expect a login to set both timestamps", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		if err := RecordLogin("alan.oliver@ecs.co.uk", "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(mockDb.UpdateItemInputs()) != 1 || *mockDb.UpdateItemInputs()[0].UpdateExpression != "SET #lastLoginAt = :lastLoginAt, #lastSeenAt = :lastSeenAt" {
+			t.Errorf("Expected lastLoginAt and lastSeenAt to be set, got %+v", mockDb.UpdateItemInputs())
+		}
+	})
+	t.Run("expect recently recorded activity not to be an error", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)}
+		if err := RecordActivity("alan.oliver@ecs.co.uk", "test", mockDb); err != nil {
+			t.Errorf("Expected no error, got %s", err.Error())
+		}
+	})
+	t.Run("expect inactive users to be filtered alongside a tag", func(t *testing.T) {
+		mockDb := &tagScanClient{}
+		since := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		_, err := ScanUsersWithOptions("test", mockDb, FetchOptions{Tag: "beta", InactiveSince: since})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		expected := "contains(#tags, :tag) AND (attribute_not_exists(lastSeenAt) OR lastSeenAt < :inactiveSince)"
+		if mockDb.input.FilterExpression == nil || *mockDb.input.FilterExpression != expected {
+			t.Fatalf("Expected filter %s, got %v", expected, mockDb.input.FilterExpression)
+		}
+		if *mockDb.input.ExpressionAttributeValues[":inactiveSince"].S != "2023-01-02T03:04:05Z" {
+			t.Errorf("Expected the cutoff as RFC 3339, got %s", *mockDb.input.ExpressionAttributeValues[":inactiveSince"].S)
+		}
+	})
+}
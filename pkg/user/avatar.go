@@ -0,0 +1,347 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// AvatarContentTypes are the image types accepted as avatars, with the
+// extension their objects are stored under.
+var AvatarContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// AvatarConfig enables avatar uploads. Clients upload straight to Bucket
+// with a presigned POST that is valid for URLExpiry, so the image never
+// passes through the Lambda.
+type AvatarConfig struct {
+	Bucket    string
+	S3        s3iface.S3API
+	MaxSize   int64
+	URLExpiry time.Duration
+}
+
+var avatars AvatarConfig
+
+func ConfigureAvatars(config AvatarConfig) {
+	avatars = config
+}
+
+var (
+	ErrorAvatarNotUploaded     = "avatar has not been uploaded"
+	ErrorAvatarTooLarge        = "avatar is too large"
+	ErrorAvatarsNotConfigured  = "avatar uploads are not configured"
+	ErrorFailedToAttachAvatar  = "failed to attach avatar"
+	ErrorFailedToPresignAvatar = "failed to presign avatar upload"
+	ErrorInvalidAvatarKey      = "invalid avatar key"
+	ErrorUnsupportedAvatarType = "unsupported avatar content type"
+	ErrorUserNotFound          = "user not found"
+)
+
+type AvatarUploadRequest struct {
+	Email       string `json:"email"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// AvatarUpload tells the client where to POST the image: a multipart form
+// of Fields followed by the image as its file. The policy the fields are
+// signed with lets S3 take only that key and content type, up to MaxSize.
+type AvatarUpload struct {
+	Key       string            `json:"key"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt string            `json:"expiresAt"`
+}
+
+// RequestAvatarUpload presigns a POST of a new avatar for an existing user.
+// The object is only attached to the user by AttachAvatar once uploaded.
+func RequestAvatarUpload(request AvatarUploadRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*AvatarUpload, error) {
+	if avatars.S3 == nil {
+		return nil, errors.New(ErrorAvatarsNotConfigured)
+	}
+	extension, ok := AvatarContentTypes[request.ContentType]
+	if !ok {
+		return nil, errors.New(ErrorUnsupportedAvatarType)
+	}
+	if request.Size <= 0 || request.Size > avatars.MaxSize {
+		return nil, errors.New(ErrorAvatarTooLarge)
+	}
+	u, err := existingUser(request.Email, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.requireActive(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.New(ErrorFailedToPresignAvatar)
+	}
+	key := avatarPrefix(request.Email) + hex.EncodeToString(id) + extension
+	expires := time.Now().UTC().Add(avatars.URLExpiry)
+	url, fields, err := presignAvatarPost(key, request.ContentType, expires)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToPresignAvatar)
+	}
+	return &AvatarUpload{
+		Key:       key,
+		URL:       url,
+		Fields:    fields,
+		ExpiresAt: expires.Format(time.RFC3339),
+	}, nil
+}
+
+// presignAvatarPost returns the URL of the avatar bucket and the form fields
+// of a POST of key, signed as Signature Version 4 does for browser uploads.
+// The SDK presigns no POST, so the bucket's URL, region and credentials are
+// taken from a request built by the configured client.
+func presignAvatarPost(key string, contentType string, expires time.Time) (string, map[string]string, error) {
+	req, _ := avatars.S3.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(avatars.Bucket)})
+	if err := req.Build(); err != nil {
+		return "", nil, err
+	}
+	if req.Config.Credentials == nil {
+		return "", nil, errors.New(ErrorFailedToPresignAvatar)
+	}
+	creds, err := req.Config.Credentials.Get()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	scope := []string{date, aws.StringValue(req.Config.Region), "s3", "aws4_request"}
+	fields := map[string]string{
+		"key":              key,
+		"Content-Type":     contentType,
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": creds.AccessKeyID + "/" + strings.Join(scope, "/"),
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+
+	conditions := []interface{}{
+		map[string]string{"bucket": avatars.Bucket},
+		[]interface{}{"content-length-range", 1, avatars.MaxSize},
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conditions = append(conditions, map[string]string{name: fields[name]})
+	}
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": expires.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+
+	signingKey := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range scope {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, fields["policy"]))
+
+	url := *req.HTTPRequest.URL
+	url.RawQuery = ""
+	return url.String(), fields, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// AttachAvatar sets an uploaded object as the user's avatar. The object is
+// checked against the limits again, as one may have been uploaded by other
+// means, and removed if it breaks them. The avatar it replaces is removed
+// once it is attached, along with uploads of the user's that were never
+// attached.
+func AttachAvatar(email string, key string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return AttachAvatarAs(email, key, audit.Actor{}, tableName, dynaClient)
+}
+
+// AttachAvatarAs attaches the avatar as AttachAvatar does, recording actor
+// as who last changed the user.
+func AttachAvatarAs(email string, key string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	if avatars.S3 == nil {
+		return nil, errors.New(ErrorAvatarsNotConfigured)
+	}
+	if email == "" || !strings.HasPrefix(key, avatarPrefix(email)) {
+		return nil, errors.New(ErrorInvalidAvatarKey)
+	}
+	if err := RequireActive(email, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	head, err := avatars.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(avatars.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, errors.New(ErrorAvatarNotUploaded)
+	}
+	if _, ok := AvatarContentTypes[aws.StringValue(head.ContentType)]; !ok {
+		deleteAvatar(key)
+		return nil, errors.New(ErrorUnsupportedAvatarType)
+	}
+	if aws.Int64Value(head.ContentLength) > avatars.MaxSize {
+		deleteAvatar(key)
+		return nil, errors.New(ErrorAvatarTooLarge)
+	}
+
+	if eventSourcing.Enabled {
+		u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+		if err != nil {
+			return nil, err
+		}
+		if u.Email == "" {
+			return nil, errors.New(ErrorUserNotFound)
+		}
+		after := *u
+		after.Avatar = key
+		after.UpdatedBy = actor.String()
+		err = appendEvent(EventUpdated, *u, &after, tableName, dynaClient)
+		cache.invalidate(tableName, email)
+		if err != nil {
+			return nil, err
+		}
+		recordAudit(email, audit.ActionUpdated, map[string]string{"avatar": key}, actor, tableName, dynaClient)
+		removeStaleAvatars(email, key, u.Avatar)
+		return &after, nil
+	}
+	values := map[string]*dynamodb.AttributeValue{":avatar": {S: aws.String(key)}}
+	expression := audit.UpdatedBy("SET avatar = :avatar", values, actor)
+	if outbox.Enabled() {
+		u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+		if err != nil {
+			return nil, err
+		}
+		if u.Email == "" {
+			return nil, errors.New(ErrorUserNotFound)
+		}
+		after := *u
+		after.Avatar = key
+		after.UpdatedBy = actor.String()
+		write := &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			Key:                       userKey(u.Email),
+			TableName:                 aws.String(tableName),
+			UpdateExpression:          aws.String(expression),
+			ConditionExpression:       aws.String("attribute_exists(email)"),
+			ExpressionAttributeValues: values,
+		}}
+		conditionFailed, err := commitWithOutbox(write, outbox.EventUserUpdated, u.Email, after, tableName, dynaClient)
+		cache.invalidate(tableName, u.Email)
+		if conditionFailed {
+			return nil, errors.New(ErrorUserNotFound)
+		}
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToAttachAvatar)
+		}
+		recordAudit(u.Email, audit.ActionUpdated, map[string]string{"avatar": key}, actor, tableName, dynaClient)
+		removeStaleAvatars(u.Email, key, u.Avatar)
+		return &after, nil
+	}
+	updated, err := dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String(email)},
+		},
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String("attribute_exists(email)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedOld),
+	})
+	cache.invalidate(tableName, email)
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, errors.New(ErrorUserNotFound)
+		}
+		return nil, store.Error(err, ErrorFailedToAttachAvatar)
+	}
+	recordAudit(email, audit.ActionUpdated, map[string]string{"avatar": key}, actor, tableName, dynaClient)
+	previous := ""
+	if avatar, ok := updated.Attributes["avatar"]; ok {
+		previous = aws.StringValue(avatar.S)
+	}
+	removeStaleAvatars(email, key, previous)
+	return FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+}
+
+// avatarPrefix keys a user's avatars by their pseudonym rather than their
+// email, which would otherwise appear in the object URL.
+func avatarPrefix(email string) string {
+	return "avatars/" + ErasureID(email) + "/"
+}
+
+func existingUser(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	u, err := FetchUser(email, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Email) == 0 {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	return u, nil
+}
+
+// removeStaleAvatars removes previous, the avatar key replaced, and the
+// user's other objects older than an upload URL lives, which were uploaded
+// but never attached. Newer ones are left, as they may be about to be.
+func removeStaleAvatars(email string, key string, previous string) {
+	if previous != "" && previous != key {
+		deleteAvatar(previous)
+	}
+	cutoff := time.Now().Add(-avatars.URLExpiry)
+	avatars.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(avatars.Bucket),
+		Prefix: aws.String(avatarPrefix(email)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			stale := aws.StringValue(object.Key)
+			if stale != key && stale != previous && aws.TimeValue(object.LastModified).Before(cutoff) {
+				deleteAvatar(stale)
+			}
+		}
+		return true
+	})
+}
+
+// deleteAvatar is best effort, as an object left behind costs only its
+// storage.
+func deleteAvatar(key string) {
+	avatars.S3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(avatars.Bucket),
+		Key:    aws.String(key),
+	})
+}
@@ -0,0 +1,210 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// mockS3 builds requests with a real client, which needs no network, and
+// serves HeadObject and ListObjectsV2 from objects.
+type mockS3 struct {
+	*s3.S3
+	objects map[string]*s3.HeadObjectOutput
+	deleted []string
+}
+
+func (m *mockS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	page := &s3.ListObjectsV2Output{}
+	for key, head := range m.objects {
+		if strings.HasPrefix(key, *input.Prefix) {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key), LastModified: head.LastModified})
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func (m *mockS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if head, ok := m.objects[*input.Key]; ok {
+		return head, nil
+	}
+	return nil, awserr.New("NotFound", "not found", nil)
+}
+
+func (m *mockS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	m.deleted = append(m.deleted, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func configureTestAvatars() *mockS3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-2"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+	client := &mockS3{S3: s3.New(sess), objects: map[string]*s3.HeadObjectOutput{}}
+	ConfigureAvatars(AvatarConfig{Bucket: "avatars", S3: client, MaxSize: 1024, URLExpiry: time.Minute})
+	return client
+}
+
+func existingUserItem() *dynamodb.GetItemOutput {
+	return &dynamodb.GetItemOutput{Item: userItem("alan.oliver@ecs.co.uk")}
+}
+
+func TestRequestAvatarUpload(t *testing.T) {
+	configureTestAvatars()
+	defer ConfigureAvatars(AvatarConfig{})
+
+	t.Run("expect a presigned POST limited to the key, content type and size", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		upload, err := RequestAvatarUpload(AvatarUploadRequest{Email: "alan.oliver@ecs.co.uk", ContentType: "image/png", Size: 512}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if !strings.HasPrefix(upload.Key, avatarPrefix("alan.oliver@ecs.co.uk")) || !strings.HasSuffix(upload.Key, ".png") {
+			t.Errorf("Expected a png key under the user's prefix, got %s", upload.Key)
+		}
+		if upload.URL != "https://avatars.s3.eu-west-2.amazonaws.com/" {
+			t.Errorf("Expected the bucket's URL, got %s", upload.URL)
+		}
+		if upload.Fields["key"] != upload.Key || upload.Fields["Content-Type"] != "image/png" || !strings.HasPrefix(upload.Fields["x-amz-credential"], "AKID/") {
+			t.Errorf("Expected the key, content type and credential as fields, got %v", upload.Fields)
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(upload.Fields["policy"])
+		var policy struct {
+			Conditions []interface{} `json:"conditions"`
+		}
+		if err := json.Unmarshal(decoded, &policy); err != nil {
+			t.Fatalf("Expected a JSON policy, got %s", err.Error())
+		}
+		limited := false
+		for _, condition := range policy.Conditions {
+			if c, ok := condition.([]interface{}); ok && c[0] == "content-length-range" && c[2] == float64(1024) {
+				limited = true
+			}
+		}
+		if !limited || len(upload.Fields["x-amz-signature"]) != 64 {
+			t.Errorf("Expected a signed policy limiting the size to %d, got %s", 1024, decoded)
+		}
+	})
+	t.Run("expect error for an unsupported content type", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := RequestAvatarUpload(AvatarUploadRequest{Email: "alan.oliver@ecs.co.uk", ContentType: "image/gif", Size: 512}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUnsupportedAvatarType {
+			t.Errorf("Expected error %s, got %v", ErrorUnsupportedAvatarType, err)
+		}
+	})
+	t.Run("expect error for an image over the size limit", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := RequestAvatarUpload(AvatarUploadRequest{Email: "alan.oliver@ecs.co.uk", ContentType: "image/png", Size: 2048}, "test", mockDb)
+		if err == nil || err.Error() != ErrorAvatarTooLarge {
+			t.Errorf("Expected error %s, got %v", ErrorAvatarTooLarge, err)
+		}
+	})
+	t.Run("expect error when the user does not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+		_, err := RequestAvatarUpload(AvatarUploadRequest{Email: "alan.oliver@ecs.co.uk", ContentType: "image/png", Size: 512}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
+	t.Run("expect error when avatars are not configured", func(t *testing.T) {
+		ConfigureAvatars(AvatarConfig{})
+		defer configureTestAvatars()
+		_, err := RequestAvatarUpload(AvatarUploadRequest{Email: "alan.oliver@ecs.co.uk", ContentType: "image/png", Size: 512}, "test", &testutil.MockDynamoDB{})
+		if err == nil || err.Error() != ErrorAvatarsNotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorAvatarsNotConfigured, err)
+		}
+	})
+}
+
+func TestAttachAvatar(t *testing.T) {
+	client := configureTestAvatars()
+	defer ConfigureAvatars(AvatarConfig{})
+	key := avatarPrefix("alan.oliver@ecs.co.uk") + "abc.png"
+
+	t.Run("expect the uploaded key to be set on the user", func(t *testing.T) {
+		client.objects[key] = &s3.HeadObjectOutput{ContentType: aws.String("image/png"), ContentLength: aws.Int64(512)}
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+
+		_, err := AttachAvatar("alan.oliver@ecs.co.uk", key, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(mockDb.UpdateItemInputs()) != 1 || *mockDb.UpdateItemInputs()[0].ExpressionAttributeValues[":avatar"].S != key {
+			t.Errorf("Expected the avatar to be set to %s", key)
+		}
+	})
+	t.Run("expect the avatar replaced and stale uploads to be removed", func(t *testing.T) {
+		client.deleted = nil
+		previous, stale, pending := avatarPrefix("alan.oliver@ecs.co.uk")+"old.png", avatarPrefix("alan.oliver@ecs.co.uk")+"stale.png", avatarPrefix("alan.oliver@ecs.co.uk")+"pending.png"
+		client.objects[previous] = &s3.HeadObjectOutput{LastModified: aws.Time(time.Now())}
+		client.objects[stale] = &s3.HeadObjectOutput{LastModified: aws.Time(time.Now().Add(-time.Hour))}
+		client.objects[pending] = &s3.HeadObjectOutput{LastModified: aws.Time(time.Now())}
+		defer func() {
+			delete(client.objects, previous)
+			delete(client.objects, stale)
+			delete(client.objects, pending)
+		}()
+		mockDb := &testutil.MockDynamoDB{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			return &dynamodb.UpdateItemOutput{Attributes: map[string]*dynamodb.AttributeValue{"avatar": {S: aws.String(previous)}}}, nil
+		}}
+
+		if _, err := AttachAvatar("alan.oliver@ecs.co.uk", key, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(client.deleted) != 2 || client.deleted[0] != previous || client.deleted[1] != stale {
+			t.Errorf("Expected %s and %s to be deleted, got %v", previous, stale, client.deleted)
+		}
+	})
+	t.Run("expect error for a key of another user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := AttachAvatar("alan.shearer@ecs.co.uk", key, "test", mockDb)
+		if err == nil || err.Error() != ErrorInvalidAvatarKey {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidAvatarKey, err)
+		}
+	})
+	t.Run("expect error when nothing was uploaded", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := AttachAvatar("alan.oliver@ecs.co.uk", avatarPrefix("alan.oliver@ecs.co.uk")+"missing.png", "test", mockDb)
+		if err == nil || err.Error() != ErrorAvatarNotUploaded {
+			t.Errorf("Expected error %s, got %v", ErrorAvatarNotUploaded, err)
+		}
+	})
+	t.Run("expect an upload over the size limit to be removed", func(t *testing.T) {
+		client.deleted = nil
+		large := avatarPrefix("alan.oliver@ecs.co.uk") + "large.png"
+		client.objects[large] = &s3.HeadObjectOutput{ContentType: aws.String("image/png"), ContentLength: aws.Int64(4096)}
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+
+		_, err := AttachAvatar("alan.oliver@ecs.co.uk", large, "test", mockDb)
+		if err == nil || err.Error() != ErrorAvatarTooLarge {
+			t.Errorf("Expected error %s, got %v", ErrorAvatarTooLarge, err)
+		}
+		if len(client.deleted) != 1 || client.deleted[0] != large {
+			t.Errorf("Expected %s to be deleted, got %v", large, client.deleted)
+		}
+		if len(mockDb.UpdateItemInputs()) != 0 {
+			t.Error("Expected the user not to be updated")
+		}
+	})
+	t.Run("expect error when the user no longer exists", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", errors.New("condition"))}
+		_, err := AttachAvatar("alan.oliver@ecs.co.uk", key, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
+}
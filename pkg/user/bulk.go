@@ -0,0 +1,323 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// MaxBulkDelete is the most users one DeleteUsers call deletes.
+	MaxBulkDelete = 1000
+
+	DeleteOutcomeDeleted  = "deleted"
+	DeleteOutcomeFailed   = "failed"
+	DeleteOutcomeInvalid  = "invalid"
+	DeleteOutcomeNotFound = "notFound"
+
+	// batchGetSize and batchWriteSize are the most keys DynamoDB accepts in
+	// one BatchGetItem and one BatchWriteItem.
+	batchGetSize   = 100
+	batchWriteSize = 25
+	batchAttempts  = 5
+)
+
+var (
+	ErrorNoUsersSelected = "emails or a filter are required"
+	ErrorTooManyUsers    = "too many users to delete at once"
+)
+
+var batchRetryDelay = 50 * time.Millisecond
+
+// BulkDelete selects the users DeleteUsers deletes, either by email, in any
+// case, or by the same filters the list endpoint takes, of which a filter
+// must set at least one.
+type BulkDelete struct {
+	Emails []string          `json:"emails,omitempty"`
+	Filter *BulkDeleteFilter `json:"filter,omitempty"`
+}
+
+type BulkDeleteFilter struct {
+	Tag          string `json:"tag,omitempty"`
+	InactiveDays int    `json:"inactiveDays,omitempty"`
+}
+
+// DeleteOutcome reports what happened to one email, with the error when it
+// was not deleted. Code is left for the handler to derive from Error.
+type DeleteOutcome struct {
+	Email   string `json:"email"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+type BulkDeleteResult struct {
+	Outcomes []DeleteOutcome `json:"outcomes"`
+	// Truncated is set when a filter's scan stopped at a scan limit, so
+	// some of the users it selects were not deleted
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DeleteUsers deletes users along with their preferences, credentials and
+// sessions, in batches. Writes DynamoDB leaves unprocessed are retried with
+// backoff, and the emails whose writes never went through are reported as
+// failed. Event sourced users are each deleted in a transaction with their
+// deleted event instead.
+func DeleteUsers(selection BulkDelete, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*BulkDeleteResult, error) {
+	return DeleteUsersAs(selection, audit.Actor{}, tableName, dynaClient)
+}
+
+// DeleteUsersAs deletes the users selection selects as DeleteUsers does,
+// auditing each deletion as made by actor.
+func DeleteUsersAs(selection BulkDelete, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*BulkDeleteResult, error) {
+	result := &BulkDeleteResult{Outcomes: []DeleteOutcome{}}
+	emails := []string{}
+	switch {
+	case selection.Filter != nil:
+		if selection.Filter.InactiveDays < 0 {
+			return nil, errors.New(ErrorInvalidInactiveDays)
+		}
+		// An empty filter would select every user
+		if selection.Filter.Tag == "" && selection.Filter.InactiveDays == 0 {
+			return nil, errors.New(ErrorNoUsersSelected)
+		}
+		options := FetchOptions{Fields: []string{"email"}, Tag: selection.Filter.Tag}
+		if selection.Filter.InactiveDays > 0 {
+			options.InactiveSince = time.Now().AddDate(0, 0, -selection.Filter.InactiveDays)
+		}
+		scanned, err := ScanUsersWithOptions(tableName, dynaClient, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range scanned.Users {
+			emails = append(emails, u.Email)
+		}
+		result.Truncated = scanned.Truncated
+	case len(selection.Emails) > 0:
+		for _, email := range selection.Emails {
+			emails = append(emails, strings.ToLower(strings.TrimSpace(email)))
+		}
+	default:
+		return nil, errors.New(ErrorNoUsersSelected)
+	}
+	if len(emails) > MaxBulkDelete {
+		return nil, errors.New(ErrorTooManyUsers)
+	}
+
+	valid := []string{}
+	seen := map[string]bool{}
+	for _, email := range emails {
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		if !validators.IsEmailValid(email) {
+			result.Outcomes = append(result.Outcomes, DeleteOutcome{Email: email, Outcome: DeleteOutcomeInvalid, Error: ErrorInvalidEmail})
+			continue
+		}
+		valid = append(valid, email)
+	}
+
+	existing := valid
+	if selection.Filter == nil {
+		found, failed := existingEmails(valid, tableName, dynaClient)
+		existing = []string{}
+		for _, email := range valid {
+			switch {
+			case failed[email] != nil:
+				result.Outcomes = append(result.Outcomes, failedOutcome(email, failed[email]))
+			case found[email]:
+				existing = append(existing, email)
+			default:
+				result.Outcomes = append(result.Outcomes, DeleteOutcome{Email: email, Outcome: DeleteOutcomeNotFound})
+			}
+		}
+	}
+
+	// Each user's writes go in the same batch so a user is deleted along
+	// with their preferences and credentials
+	perBatch := batchWriteSize / 3
+	for start := 0; start < len(existing); start += perBatch {
+		end := start + perBatch
+		if end > len(existing) {
+			end = len(existing)
+		}
+		var failed map[string]error
+		if eventSourcing.Enabled || outbox.Enabled() {
+			failed = deleteEach(existing[start:end], tableName, dynaClient)
+		} else {
+			failed = deleteBatch(existing[start:end], tableName, dynaClient)
+		}
+		for _, email := range existing[start:end] {
+			cache.invalidate(tableName, email)
+			if err := failed[email]; err != nil {
+				result.Outcomes = append(result.Outcomes, failedOutcome(email, err))
+				continue
+			}
+			if err := session.RevokeAll(email, session.Table(tableName), dynaClient); err != nil {
+				result.Outcomes = append(result.Outcomes, failedOutcome(email, err))
+				continue
+			}
+			recordAudit(email, audit.ActionDeleted, nil, actor, tableName, dynaClient)
+			result.Outcomes = append(result.Outcomes, DeleteOutcome{Email: email, Outcome: DeleteOutcomeDeleted})
+		}
+	}
+	return result, nil
+}
+
+func failedOutcome(email string, err error) DeleteOutcome {
+	return DeleteOutcome{Email: email, Outcome: DeleteOutcomeFailed, Error: err.Error()}
+}
+
+// existingEmails reads which emails have users, and the error for each email
+// that could not be read.
+func existingEmails(emails []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (map[string]bool, map[string]error) {
+	found := map[string]bool{}
+	failed := batchGet(emails, &dynamodb.KeysAndAttributes{
+		ProjectionExpression: aws.String("email, movedTo"),
+		ConsistentRead:       aws.Bool(true),
+	}, tableName, dynaClient, func(item map[string]*dynamodb.AttributeValue) {
+		if stringAttribute(item, "movedTo") == "" {
+			found[stringAttribute(item, "email")] = true
+		}
+	})
+	return found, failed
+}
+
+// batchGet reads the items of emails with BatchGetItem, as read describes
+// bar its keys, retrying keys left unprocessed, and calls fn with each item
+// read. It returns the error for each email that could not be read.
+func batchGet(emails []string, read *dynamodb.KeysAndAttributes, tableName string, dynaClient dynamodbiface.DynamoDBAPI, fn func(item map[string]*dynamodb.AttributeValue)) map[string]error {
+	failed := map[string]error{}
+	for start := 0; start < len(emails); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(emails) {
+			end = len(emails)
+		}
+		keys := []map[string]*dynamodb.AttributeValue{}
+		for _, email := range emails[start:end] {
+			keys = append(keys, userKey(email))
+		}
+		batch := *read
+		batch.Keys = keys
+		request := map[string]*dynamodb.KeysAndAttributes{tableName: &batch}
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt == batchAttempts {
+				for _, key := range request[tableName].Keys {
+					failed[aws.StringValue(key["email"].S)] = errors.New(ErrorFailedToFetchRecord)
+				}
+				break
+			}
+			if attempt > 0 {
+				time.Sleep(batchRetryDelay << (attempt - 1))
+			}
+			output, err := dynaClient.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				for _, key := range request[tableName].Keys {
+					failed[aws.StringValue(key["email"].S)] = store.Error(err, ErrorFailedToFetchRecord)
+				}
+				break
+			}
+			for _, item := range output.Responses[tableName] {
+				fn(item)
+			}
+			request = output.UnprocessedKeys
+		}
+	}
+	return failed
+}
+
+// deleteBatch deletes the items of emails in one BatchWriteItem, retrying
+// what is left unprocessed, and returns the error for each email whose
+// writes did not all go through.
+func deleteBatch(emails []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) map[string]error {
+	tables := []string{tableName, PreferencesTableName(tableName), credentials.TableName(tableName)}
+	request := map[string][]*dynamodb.WriteRequest{}
+	for _, email := range emails {
+		for _, table := range tables {
+			request[table] = append(request[table], &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: userKey(email)},
+			})
+		}
+	}
+
+	failed := map[string]error{}
+	for attempt := 0; len(request) > 0; attempt++ {
+		if attempt == batchAttempts {
+			markFailed(request, errors.New(ErrorFailedToDeleteRecord), failed)
+			break
+		}
+		if attempt > 0 {
+			time.Sleep(batchRetryDelay << (attempt - 1))
+		}
+		output, err := dynaClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: request})
+		if err != nil {
+			markFailed(request, store.Error(err, ErrorFailedToDeleteRecord), failed)
+			break
+		}
+		request = output.UnprocessedItems
+	}
+	return failed
+}
+
+// deleteEach deletes each user of emails in a transaction of their own,
+// with the outbox message announcing it and, for event sourced users, the
+// event recording it, so their stream cannot rebuild them. It returns the
+// error for each email whose transaction did not go through.
+func deleteEach(emails []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) map[string]error {
+	failed := map[string]error{}
+	for _, email := range emails {
+		if err := deleteOne(email, tableName, dynaClient); err != nil {
+			failed[email] = err
+		}
+	}
+	return failed
+}
+
+func deleteOne(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	writes := []*dynamodb.TransactWriteItem{}
+	if eventSourcing.Enabled {
+		u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+		if err != nil || u.Email == "" {
+			return err
+		}
+		e, _, err := nextEvent(EventDeleted, *u, nil, tableName, dynaClient)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, e.put(tableName))
+	}
+	for _, table := range []string{tableName, PreferencesTableName(tableName), credentials.TableName(tableName)} {
+		writes = append(writes, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:       userKey(email),
+			TableName: aws.String(table),
+		}})
+	}
+	conditionFailed, err := commit(writes, outbox.EventUserDeleted, email, map[string]string{"email": email}, tableName, dynaClient)
+	if conditionFailed {
+		return errors.New(ErrorConcurrentUpdate)
+	}
+	if err != nil {
+		return store.Error(err, ErrorFailedToDeleteRecord)
+	}
+	return nil
+}
+
+func markFailed(request map[string][]*dynamodb.WriteRequest, err error, failed map[string]error) {
+	for _, writes := range request {
+		for _, write := range writes {
+			failed[aws.StringValue(write.DeleteRequest.Key["email"].S)] = err
+		}
+	}
+}
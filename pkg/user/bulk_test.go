@@ -0,0 +1,123 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// batchClient knows which users exist and leaves the first write of every
+// BatchWriteItem unprocessed until it has been sent unprocessedTimes times.
+type batchClient struct {
+	testutil.MockDynamoDB
+	existing         map[string]bool
+	unprocessedTimes int
+	writes           []*dynamodb.BatchWriteItemInput
+	deleted          map[string]int
+}
+
+func (c *batchClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	responses := map[string][]map[string]*dynamodb.AttributeValue{}
+	for table, keys := range input.RequestItems {
+		for _, key := range keys.Keys {
+			if c.existing[*key["email"].S] {
+				responses[table] = append(responses[table], key)
+			}
+		}
+	}
+	return &dynamodb.BatchGetItemOutput{Responses: responses}, nil
+}
+
+func (c *batchClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	c.writes = append(c.writes, input)
+	unprocessed := map[string][]*dynamodb.WriteRequest{}
+	for table, writes := range input.RequestItems {
+		for i, write := range writes {
+			if i == 0 && c.unprocessedTimes > 0 {
+				unprocessed[table] = append(unprocessed[table], write)
+				continue
+			}
+			c.deleted[*write.DeleteRequest.Key["email"].S]++
+		}
+	}
+	if c.unprocessedTimes > 0 {
+		c.unprocessedTimes--
+	}
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: unprocessed}, nil
+}
+
+func TestDeleteUsers(t *testing.T) {
+	batchRetryDelay = 0
+
+	outcomes := func(result *BulkDeleteResult) map[string]string {
+		byEmail := map[string]string{}
+		for _, outcome := range result.Outcomes {
+			byEmail[outcome.Email] = outcome.Outcome
+		}
+		return byEmail
+	}
+
+	t.Run("expect an outcome for every email", func(t *testing.T) {
+		client := &batchClient{existing: map[string]bool{"alan.oliver@ecs.co.uk": true}, deleted: map[string]int{}}
+		result, err := DeleteUsers(BulkDelete{Emails: []string{"alan.oliver@ecs.co.uk", "nobody@ecs.co.uk", "not-an-email"}}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		got := outcomes(result)
+		if got["alan.oliver@ecs.co.uk"] != DeleteOutcomeDeleted || got["nobody@ecs.co.uk"] != DeleteOutcomeNotFound || got["not-an-email"] != DeleteOutcomeInvalid {
+			t.Errorf("Expected deleted, notFound and invalid outcomes, got %v", got)
+		}
+		if client.deleted["alan.oliver@ecs.co.uk"] != 3 {
+			t.Errorf("Expected the user, preferences and credentials to be deleted, got %d deletes", client.deleted["alan.oliver@ecs.co.uk"])
+		}
+	})
+	t.Run("expect unprocessed writes to be retried", func(t *testing.T) {
+		client := &batchClient{existing: map[string]bool{"alan.oliver@ecs.co.uk": true}, unprocessedTimes: 2, deleted: map[string]int{}}
+		result, _ := DeleteUsers(BulkDelete{Emails: []string{"alan.oliver@ecs.co.uk"}}, "test", client)
+		if outcomes(result)["alan.oliver@ecs.co.uk"] != DeleteOutcomeDeleted || len(client.writes) != 3 {
+			t.Errorf("Expected the user to be deleted after 3 writes, got %v after %d", outcomes(result), len(client.writes))
+		}
+	})
+	t.Run("expect writes that stay unprocessed to fail", func(t *testing.T) {
+		client := &batchClient{existing: map[string]bool{"alan.oliver@ecs.co.uk": true}, unprocessedTimes: batchAttempts, deleted: map[string]int{}}
+		result, _ := DeleteUsers(BulkDelete{Emails: []string{"alan.oliver@ecs.co.uk"}}, "test", client)
+		if result.Outcomes[0].Outcome != DeleteOutcomeFailed || result.Outcomes[0].Error != ErrorFailedToDeleteRecord {
+			t.Errorf("Expected the deletion to fail, got %+v", result.Outcomes[0])
+		}
+	})
+	t.Run("expect a filter to select the users from a scan", func(t *testing.T) {
+		client := &batchClient{deleted: map[string]int{}}
+		client.ScanOutput = &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("alan.oliver@ecs.co.uk")}},
+		}}
+		result, err := DeleteUsers(BulkDelete{Filter: &BulkDeleteFilter{Tag: "beta"}}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if outcomes(result)["alan.oliver@ecs.co.uk"] != DeleteOutcomeDeleted {
+			t.Errorf("Expected the scanned user to be deleted, got %v", outcomes(result))
+		}
+	})
+	t.Run("expect a selection to be required", func(t *testing.T) {
+		for _, selection := range []BulkDelete{{}, {Filter: &BulkDeleteFilter{}}} {
+			client := &batchClient{}
+			_, err := DeleteUsers(selection, "test", client)
+			if err == nil || err.Error() != ErrorNoUsersSelected {
+				t.Errorf("Expected error %s for %+v, got %v", ErrorNoUsersSelected, selection, err)
+			}
+			if len(client.Calls()) != 0 {
+				t.Errorf("Expected nothing to be read, got %v", client.Calls())
+			}
+		}
+	})
+	t.Run("expect emails to be selected whatever their case", func(t *testing.T) {
+		client := &batchClient{existing: map[string]bool{"alan.oliver@ecs.co.uk": true}, deleted: map[string]int{}}
+		result, _ := DeleteUsers(BulkDelete{Emails: []string{" Alan.Oliver@ECS.co.uk"}}, "test", client)
+		if outcomes(result)["alan.oliver@ecs.co.uk"] != DeleteOutcomeDeleted || client.deleted["alan.oliver@ecs.co.uk"] != 3 {
+			t.Errorf("Expected the user to be deleted, got %v", outcomes(result))
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheConfig sizes the per-container cache in front of FetchUser. Entries
+// live for TTL and the least recently used user is evicted once Size users
+// are cached; a zero Size disables the cache.
+type CacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+var cache *userCache
+
+func ConfigureCache(config CacheConfig) {
+	if config.Size <= 0 {
+		cache = nil
+		return
+	}
+	cache = newUserCache(config.Size, config.TTL)
+}
+
+type cacheEntry struct {
+	key     string
+	user    User
+	expires time.Time
+}
+
+// userCache is an LRU of users keyed by table and email. It only holds users
+// that exist, so a user created by another container is never hidden behind
+// a cached miss. Writes made by this container invalidate their entry; writes
+// made elsewhere are seen once the entry expires.
+type userCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newUserCache(size int, ttl time.Duration) *userCache {
+	return &userCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func cacheKey(tableName string, email string) string {
+	return tableName + "\x00" + email
+}
+
+func (c *userCache) get(tableName string, email string) (*User, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[cacheKey(tableName, email)]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.ttl > 0 && c.now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	u := entry.user
+	return &u, true
+}
+
+func (c *userCache) add(tableName string, u User) {
+	if c == nil || u.Email == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(tableName, u.Email)
+	expires := c.now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key: key, user: u, expires: expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, user: u, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *userCache) invalidate(tableName string, email string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[cacheKey(tableName, email)]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *userCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
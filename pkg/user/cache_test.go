@@ -0,0 +1,124 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestUserCache(t *testing.T) {
+	t.Run("should evict the least recently used user", func(t *testing.T) {
+		c := newUserCache(2, time.Minute)
+		c.add("test", User{Email: "a@ecs.co.uk"})
+		c.add("test", User{Email: "b@ecs.co.uk"})
+		c.get("test", "a@ecs.co.uk")
+		c.add("test", User{Email: "c@ecs.co.uk"})
+
+		if _, ok := c.get("test", "b@ecs.co.uk"); ok {
+			t.Error("expected b@ecs.co.uk to be evicted")
+		}
+		for _, email := range []string{"a@ecs.co.uk", "c@ecs.co.uk"} {
+			if _, ok := c.get("test", email); !ok {
+				t.Errorf("expected %s to be cached", email)
+			}
+		}
+	})
+	t.Run("should expire entries after the TTL", func(t *testing.T) {
+		now := time.Now()
+		c := newUserCache(2, time.Minute)
+		c.now = func() time.Time { return now }
+		c.add("test", User{Email: "a@ecs.co.uk"})
+
+		now = now.Add(2 * time.Minute)
+		if _, ok := c.get("test", "a@ecs.co.uk"); ok {
+			t.Error("expected the entry to have expired")
+		}
+	})
+	t.Run("should keep tables apart", func(t *testing.T) {
+		c := newUserCache(2, time.Minute)
+		c.add("test", User{Email: "a@ecs.co.uk"})
+
+		if _, ok := c.get("other", "a@ecs.co.uk"); ok {
+			t.Error("expected a miss for another table")
+		}
+	})
+}
+
+func TestFetchUserCache(t *testing.T) {
+	ConfigureCache(CacheConfig{Size: 10, TTL: time.Minute})
+	defer ConfigureCache(CacheConfig{})
+
+	item := &dynamodb.GetItemOutput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+		},
+	}
+
+	t.Run("should serve repeat reads from the cache", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: item}
+
+		FetchUser("alan.oliver@ecs.co.uk", "cached", mockDb)
+		u, err := FetchUser("alan.oliver@ecs.co.uk", "cached", mockDb)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if mockDb.Count("GetItem") != 1 {
+			t.Errorf("expected 1 GetItem call, got %d", mockDb.Count("GetItem"))
+		}
+		if u.FirstName != "Alan" {
+			t.Errorf("expected firstName %q, got %q", "Alan", u.FirstName)
+		}
+	})
+	t.Run("should not cache users that do not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+
+		FetchUser("alan.shearer@ecs.co.uk", "cached", mockDb)
+		FetchUser("alan.shearer@ecs.co.uk", "cached", mockDb)
+		if mockDb.Count("GetItem") != 2 {
+			t.Errorf("expected 2 GetItem calls, got %d", mockDb.Count("GetItem"))
+		}
+	})
+	t.Run("should read again after the user is updated", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: item}
+
+		FetchUser("alan.oliver@ecs.co.uk", "updated", mockDb)
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Smith"}`,
+		}, "updated", mockDb)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		gets := mockDb.Count("GetItem")
+		FetchUser("alan.oliver@ecs.co.uk", "updated", mockDb)
+		if mockDb.Count("GetItem") != gets+1 {
+			t.Error("expected the update to invalidate the cached user")
+		}
+	})
+	t.Run("should read again after the user is deleted", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{
+			GetItemOutput: item,
+			DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+				return &dynamodb.DeleteItemOutput{Attributes: item.Item}, nil
+			},
+		}
+
+		FetchUser("alan.oliver@ecs.co.uk", "deleted", mockDb)
+		err := DeleteUser(events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"},
+		}, "deleted", mockDb)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		FetchUser("alan.oliver@ecs.co.uk", "deleted", mockDb)
+		if mockDb.Count("GetItem") != 2 {
+			t.Errorf("expected 2 GetItem calls, got %d", mockDb.Count("GetItem"))
+		}
+	})
+}
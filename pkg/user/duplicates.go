@@ -0,0 +1,152 @@
+package user
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Why two users are flagged as probable duplicates.
+const (
+	// DuplicateEmail flags users whose emails are the same address once
+	// normalised: compared whatever their case, without any +suffix, and
+	// without the dots Gmail ignores.
+	DuplicateEmail = "email"
+	// DuplicateName flags users with the same last name whose first names are
+	// the same or one edit apart.
+	DuplicateName = "name"
+)
+
+// Duplicate is a pair of users that are probably the same person, in order
+// of their emails, and why they were flagged.
+type Duplicate struct {
+	Users  [2]string `json:"users"`
+	Reason string    `json:"reason"`
+}
+
+// DuplicateReport lists the probable duplicates among the users scanned.
+// Truncated is set when the scan stopped at a limit, so duplicates among the
+// users it did not read are missing.
+type DuplicateReport struct {
+	Duplicates []Duplicate `json:"duplicates"`
+	Truncated  bool        `json:"truncated"`
+}
+
+// FindDuplicates scans the users, within the limits of the scan
+// configuration, for pairs that are probably the same person, for an
+// operator to review and merge with MergeUsers. A pair flagged for its
+// emails is not flagged again for its names.
+func FindDuplicates(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*DuplicateReport, error) {
+	result, err := ScanUsersWithOptions(tableName, dynaClient, FetchOptions{Fields: []string{"email", "firstName", "lastName"}})
+	if err != nil {
+		return nil, err
+	}
+	users := result.Users
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+
+	byEmail := map[string][]int{}
+	byLastName := map[string][]int{}
+	for i, u := range users {
+		email := normaliseEmail(u.Email)
+		byEmail[email] = append(byEmail[email], i)
+		if name := normaliseName(u.LastName); name != "" {
+			byLastName[name] = append(byLastName[name], i)
+		}
+	}
+
+	report := &DuplicateReport{Duplicates: []Duplicate{}, Truncated: result.Truncated}
+	flagged := map[[2]int]bool{}
+	for _, group := range byEmail {
+		for a := 0; a < len(group); a++ {
+			for b := a + 1; b < len(group); b++ {
+				flagged[[2]int{group[a], group[b]}] = true
+				report.Duplicates = append(report.Duplicates, Duplicate{Users: [2]string{users[group[a]].Email, users[group[b]].Email}, Reason: DuplicateEmail})
+			}
+		}
+	}
+	for _, group := range byLastName {
+		for a := 0; a < len(group); a++ {
+			for b := a + 1; b < len(group); b++ {
+				if flagged[[2]int{group[a], group[b]}] || !similarNames(users[group[a]].FirstName, users[group[b]].FirstName) {
+					continue
+				}
+				report.Duplicates = append(report.Duplicates, Duplicate{Users: [2]string{users[group[a]].Email, users[group[b]].Email}, Reason: DuplicateName})
+			}
+		}
+	}
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		x, y := report.Duplicates[i].Users, report.Duplicates[j].Users
+		return x[0] < y[0] || x[0] == y[0] && x[1] < y[1]
+	})
+	return report, nil
+}
+
+// normaliseEmail returns the address email delivers to as most mail
+// providers read it.
+func normaliseEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	local, _, _ = strings.Cut(local, "+")
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local, domain = strings.ReplaceAll(local, ".", ""), "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// normaliseName returns name lowercase with only its letters, so names
+// differing only in case, spacing or punctuation compare equal.
+func normaliseName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// similarNames reports whether two first names are the same once
+// normalised, or one edit apart when both are long enough for a typo to be
+// likelier than a different name.
+func similarNames(a string, b string) bool {
+	x, y := []rune(normaliseName(a)), []rune(normaliseName(b))
+	if len(x) == 0 || len(y) == 0 {
+		return false
+	}
+	if string(x) == string(y) {
+		return true
+	}
+	return len(x) >= 4 && len(y) >= 4 && editDistance(x, y) <= 1
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	stored := func(email string, firstName string, lastName string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String(email)},
+			"firstName": {S: aws.String(firstName)},
+			"lastName":  {S: aws.String(lastName)},
+		}
+	}
+	client := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+		stored("alan.oliver@gmail.com", "Alan", "Oliver"),
+		stored("alanoliver+work@googlemail.com", "Al", "Oliver"),
+		stored("alan@ecs.co.uk", "Alan", "Oliver"),
+		stored("jonathan@ecs.co.uk", "Jonathan", "O'Brien"),
+		stored("jonathon@ecs.co.uk", "Jonathon", "OBrien"),
+		stored("jo@ecs.co.uk", "Jo", "Smith"),
+		stored("jay@ecs.co.uk", "Jay", "Smith"),
+	}}}
+	report, err := FindDuplicates("test", client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	expected := []Duplicate{
+		{Users: [2]string{"alan.oliver@gmail.com", "alan@ecs.co.uk"}, Reason: DuplicateName},
+		{Users: [2]string{"alan.oliver@gmail.com", "alanoliver+work@googlemail.com"}, Reason: DuplicateEmail},
+		{Users: [2]string{"jonathan@ecs.co.uk", "jonathon@ecs.co.uk"}, Reason: DuplicateName},
+	}
+	if !reflect.DeepEqual(report.Duplicates, expected) || report.Truncated {
+		t.Errorf("Expected %v, got %v", expected, report)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"alan", "alan", 0},
+		{"alan", "allan", 1},
+		{"jonathan", "jonathon", 1},
+		{"kitten", "sitting", 3},
+		{"", "al", 2},
+	}
+	for _, c := range cases {
+		if d := editDistance([]rune(c.a), []rune(c.b)); d != c.distance {
+			t.Errorf("Expected %q and %q %d apart, got %d", c.a, c.b, c.distance, d)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package user
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/seal"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+// maxTransactItems is the most writes DynamoDB accepts in one transaction.
+const maxTransactItems = 100
+
+var (
+	ErrorEmailChangeIncomplete         = "email change incomplete, retry to resume"
+	ErrorEmailChangeNotConfigured      = "email change is not configured"
+	ErrorEmailUnchanged                = "new email is the same as the current email"
+	ErrorFailedToChangeEmail           = "failed to change email"
+	ErrorFailedToSendEmailConfirmation = "failed to send email change confirmation"
+	ErrorInvalidEmailChangeToken       = "invalid or expired email change token"
+)
+
+// EmailChangeConfig sets how email changes are confirmed. Tokens are sealed
+// with Key and the link emailed to the new address opens URL with the token
+// in its query.
+type EmailChangeConfig struct {
+	Key    []byte
+	SES    sesiface.SESAPI
+	Sender string
+	URL    string
+	TTL    time.Duration
+}
+
+var emailChanges EmailChangeConfig
+
+func ConfigureEmailChange(config EmailChangeConfig) {
+	emailChanges = config
+}
+
+// emailChangeClaims are what an email change token is signed over.
+type emailChangeClaims struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Expires int64  `json:"exp"`
+}
+
+// RequestEmailChange emails a confirmation link to newEmail. Nothing moves
+// until the link is followed, and requesting another change replaces the
+// pending one so only the latest link works.
+func RequestEmailChange(email string, newEmail string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if len(emailChanges.Key) == 0 || emailChanges.SES == nil {
+		return errors.New(ErrorEmailChangeNotConfigured)
+	}
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if !validators.IsEmailValid(newEmail) {
+		return errors.New(ErrorInvalidEmail)
+	}
+	if newEmail == email {
+		return errors.New(ErrorEmailUnchanged)
+	}
+	u, err := existingUser(email, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if err := u.requireActive(); err != nil {
+		return err
+	}
+	taken, err := FetchUserWithOptions(newEmail, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return err
+	}
+	if len(taken.Email) != 0 {
+		return errors.New(ErrorUserAlreadyExists)
+	}
+
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       userKey(email),
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String("SET pendingEmail = :to"),
+		ConditionExpression:       aws.String("attribute_exists(email) AND attribute_not_exists(movedTo)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":to": {S: aws.String(newEmail)}},
+	})
+	cache.invalidate(tableName, email)
+	if err != nil {
+		return store.Error(err, ErrorFailedToChangeEmail)
+	}
+	token, err := sealEmailChange(emailChangeClaims{
+		From:    email,
+		To:      newEmail,
+		Expires: time.Now().Add(emailChanges.TTL).Unix(),
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToChangeEmail)
+	}
+	return sendEmailConfirmation(newEmail, token)
+}
+
+// ConfirmEmailChange moves the user to the email the token was issued for.
+// In one transaction the user is written under the new email, the old item
+// is replaced by a tombstone and their preferences, credentials and history
+// are copied across. History too long to fit is copied once the transaction
+// has committed; if that fails, confirming again resumes it.
+func ConfirmEmailChange(token string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return ConfirmEmailChangeAs(token, audit.Actor{}, tableName, dynaClient)
+}
+
+// ConfirmEmailChangeAs confirms the change token was issued for as
+// ConfirmEmailChange does, recording actor as who last changed the user.
+func ConfirmEmailChangeAs(token string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	claims, ok := openEmailChange(token)
+	if !ok {
+		return nil, errors.New(ErrorInvalidEmailChangeToken)
+	}
+	item, err := fetchItem(userKey(claims.From), tableName, dynaClient, ErrorFailedToFetchRecord)
+	if err != nil {
+		return nil, err
+	}
+	if eventSourcing.Enabled {
+		if item, err = catchUp(claims.From, item, tableName, dynaClient); err != nil {
+			return nil, err
+		}
+	}
+	history, err := audit.FetchEntries(claims.From, audit.TableName(tableName), dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if stringAttribute(item, "movedTo") == claims.To {
+		if err := copyHistory(history, claims.To, ErrorEmailChangeIncomplete, tableName, dynaClient); err != nil {
+			return nil, err
+		}
+		return FetchUserWithOptions(claims.To, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	}
+	if stringAttribute(item, "pendingEmail") != claims.To {
+		return nil, errors.New(ErrorInvalidEmailChangeToken)
+	}
+
+	if actor.ID != "" {
+		item["updatedBy"] = &dynamodb.AttributeValue{S: aws.String(actor.String())}
+	} else {
+		delete(item, "updatedBy")
+	}
+	writes, err := emailChangeWrites(item, claims, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	// The events, when there are any, follow the writes that move the user
+	sourced := len(writes)
+	if eventSourcing.Enabled {
+		moved, err := movedEvents(item, writes[0].Put.Item, tableName, dynaClient)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, moved...)
+	}
+	// The user is announced as leaving their old email and arriving at the
+	// new one, as their events record them
+	var arrived User
+	if err := dynamodbattribute.UnmarshalMap(writes[0].Put.Item, &arrived); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	left, err := announce(outbox.EventUserDeleted, claims.From, map[string]string{"email": claims.From}, tableName)
+	if err != nil {
+		return nil, err
+	}
+	created, err := announce(outbox.EventUserCreated, claims.To, arrived, tableName)
+	if err != nil {
+		return nil, err
+	}
+	writes = append(append(writes, left...), created...)
+	copied := history
+	if room := maxTransactItems - len(writes); len(copied) > room {
+		copied = copied[:room]
+	}
+	for _, entry := range copied {
+		entry.Subject = claims.To
+		av, err := dynamodbattribute.MarshalMap(entry)
+		if err != nil {
+			return nil, errors.New(audit.ErrorFailedToMarshalEntry)
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			Item:      av,
+			TableName: aws.String(audit.TableName(tableName)),
+		}})
+	}
+
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	cache.invalidate(tableName, claims.From)
+	cache.invalidate(tableName, claims.To)
+	if err != nil {
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 {
+			if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorUserAlreadyExists)
+			}
+			if aws.StringValue(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorInvalidEmailChangeToken)
+			}
+			for _, reason := range canceled.CancellationReasons[sourced:] {
+				if aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+					return nil, errors.New(ErrorConcurrentUpdate)
+				}
+			}
+		}
+		return nil, store.Error(err, ErrorFailedToChangeEmail)
+	}
+	recordAudit(claims.To, audit.ActionUpdated, map[string]string{"previousEmail": claims.From}, actor, tableName, dynaClient)
+	if err := session.RevokeAll(claims.From, session.Table(tableName), dynaClient); err != nil {
+		return nil, err
+	}
+	if err := copyHistory(history[len(copied):], claims.To, ErrorEmailChangeIncomplete, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return FetchUserWithOptions(claims.To, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+}
+
+// emailChangeWrites returns the writes that move the user, their preferences
+// and their credentials. The first two are the user under the new email and
+// the tombstone, whose conditions ConfirmEmailChange reports on.
+func emailChangeWrites(item map[string]*dynamodb.AttributeValue, claims emailChangeClaims, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	writes, err := moveWrites(item, claims.From, claims.To, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	tombstone := &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		Item: map[string]*dynamodb.AttributeValue{
+			"email":   {S: aws.String(claims.From)},
+			"movedTo": {S: aws.String(claims.To)},
+			"movedAt": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String("pendingEmail = :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":to": {S: aws.String(claims.To)}},
+	}}
+	return append([]*dynamodb.TransactWriteItem{writes[0], tombstone}, writes[1:]...), nil
+}
+
+// movedEvents returns the writes appending the events that record an event
+// sourced user, item, leaving their old email, and arriving at the new one
+// as moved, which starts a stream of its own. moved is given the version of
+// that stream's event.
+func movedEvents(item map[string]*dynamodb.AttributeValue, moved map[string]*dynamodb.AttributeValue, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	var before, after User
+	if err := dynamodbattribute.UnmarshalMap(item, &before); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	if err := dynamodbattribute.UnmarshalMap(moved, &after); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	left, _, err := nextEvent(EventDeleted, before, nil, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	arrived, _, err := nextEvent(EventCreated, User{}, &after, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	moved["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(arrived.Sequence, 10))}
+	return []*dynamodb.TransactWriteItem{left.put(tableName), arrived.put(tableName)}, nil
+}
+
+// moveWrites returns the writes that put the user, their preferences and
+// their credentials under to, starting with the user, and remove the
+// preferences and credentials left under from. What becomes of the user
+// item under from is up to the caller.
+func moveWrites(item map[string]*dynamodb.AttributeValue, from string, to string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	moved := map[string]*dynamodb.AttributeValue{}
+	for name, value := range item {
+		moved[name] = value
+	}
+	moved["email"] = &dynamodb.AttributeValue{S: aws.String(to)}
+	delete(moved, "pendingEmail")
+	// Events stay with the address they were appended under, so the user
+	// starts a new stream under their new one
+	delete(moved, "version")
+
+	writes := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{
+			Item:                moved,
+			TableName:           aws.String(tableName),
+			ConditionExpression: aws.String("attribute_not_exists(email)"),
+		}},
+	}
+
+	preferences, err := fetchItem(userKey(from), PreferencesTableName(tableName), dynaClient, ErrorFailedToFetchPreferences)
+	if err != nil {
+		return nil, err
+	}
+	if len(preferences) > 0 {
+		preferences["email"] = &dynamodb.AttributeValue{S: aws.String(to)}
+		writes = append(writes,
+			&dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				Item:      preferences,
+				TableName: aws.String(PreferencesTableName(tableName)),
+			}},
+			&dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+				Key:       userKey(from),
+				TableName: aws.String(PreferencesTableName(tableName)),
+			}},
+		)
+	}
+
+	creds, err := credentials.MoveItems(from, to, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	return append(writes, creds...), nil
+}
+
+// copyHistory writes entries again under subject, reporting a failure as
+// incomplete. Entries keep their timestamps, so copying an entry twice leaves
+// one copy.
+func copyHistory(entries []audit.Entry, subject string, incomplete string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	for _, entry := range entries {
+		entry.Subject = subject
+		if err := audit.Put(entry, audit.TableName(tableName), dynaClient); err != nil {
+			return errors.New(incomplete)
+		}
+	}
+	return nil
+}
+
+func fetchItem(key map[string]*dynamodb.AttributeValue, tableName string, dynaClient dynamodbiface.DynamoDBAPI, failure string) (map[string]*dynamodb.AttributeValue, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:            key,
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, store.Error(err, failure)
+	}
+	return result.Item, nil
+}
+
+func userKey(email string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"email": {S: aws.String(email)},
+	}
+}
+
+func stringAttribute(item map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := item[name]; ok {
+		return aws.StringValue(value.S)
+	}
+	return ""
+}
+
+// emailChangePurpose is what email change tokens are sealed for.
+const emailChangePurpose = "email change"
+
+func sealEmailChange(claims emailChangeClaims) (string, error) {
+	return seal.Seal(emailChanges.Key, emailChangePurpose, claims)
+}
+
+func openEmailChange(token string) (emailChangeClaims, bool) {
+	var claims emailChangeClaims
+	if len(emailChanges.Key) == 0 || seal.Open(emailChanges.Key, emailChangePurpose, token, &claims) != nil {
+		return claims, false
+	}
+	return claims, time.Now().Unix() < claims.Expires
+}
+
+func sendEmailConfirmation(email string, token string) error {
+	link, err := url.Parse(emailChanges.URL)
+	if err != nil {
+		return errors.New(ErrorEmailChangeNotConfigured)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	body := strings.Join([]string{
+		"Someone asked to change the email address of their account to this one.",
+		"",
+		"Follow this link within " + emailChanges.TTL.String() + " to confirm the change:",
+		link.String(),
+		"",
+		"If you did not ask for this you can ignore this email.",
+	}, "\n")
+	_, err = emailChanges.SES.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(emailChanges.Sender),
+		Destination: &ses.Destination{ToAddresses: aws.StringSlice([]string{email})},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String("Confirm your new email address")},
+			Body:    &ses.Body{Text: &ses.Content{Data: aws.String(body)}},
+		},
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToSendEmailConfirmation)
+	}
+	return nil
+}
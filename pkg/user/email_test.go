@@ -0,0 +1,274 @@
+package user
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+type mockSES struct {
+	sesiface.SESAPI
+	sent []*ses.SendEmailInput
+}
+
+func (m *mockSES) SendEmail(input *ses.SendEmailInput) (*ses.SendEmailOutput, error) {
+	m.sent = append(m.sent, input)
+	return &ses.SendEmailOutput{}, nil
+}
+
+// token reads the token from the link in the last email sent.
+func (m *mockSES) token(t *testing.T) string {
+	body := *m.sent[len(m.sent)-1].Message.Body.Text.Data
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "https://") {
+			link, err := url.Parse(line)
+			if err != nil {
+				t.Fatalf("Expected a valid link, got %s", line)
+			}
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("Expected a link in %q", body)
+	return ""
+}
+
+// emailTableClient keeps every table in memory, keyed by table and then by
+// the item's key attributes.
+type emailTableClient struct {
+	dynamodbiface.DynamoDBAPI
+	tables map[string]map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newEmailTableClient() *emailTableClient {
+	return &emailTableClient{tables: map[string]map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func itemKey(item map[string]*dynamodb.AttributeValue) string {
+	for _, name := range []string{"email", "id"} {
+		if value, ok := item[name]; ok {
+			return *value.S
+		}
+	}
+	return *item["subject"].S + "#" + *item["timestamp"].S
+}
+
+func (c *emailTableClient) table(name string) map[string]map[string]*dynamodb.AttributeValue {
+	if c.tables[name] == nil {
+		c.tables[name] = map[string]map[string]*dynamodb.AttributeValue{}
+	}
+	return c.tables[name]
+}
+
+func (c *emailTableClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: c.table(*input.TableName)[itemKey(input.Key)]}, nil
+}
+
+func (c *emailTableClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	c.table(*input.TableName)[itemKey(input.Item)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (c *emailTableClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	item := c.table(*input.TableName)[itemKey(input.Key)]
+	if *input.UpdateExpression == "SET pendingEmail = :to" {
+		item["pendingEmail"] = input.ExpressionAttributeValues[":to"]
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (c *emailTableClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	subject, ok := input.ExpressionAttributeValues[":subject"]
+	if !ok {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, item := range c.table(*input.TableName) {
+		if *item["subject"].S == *subject.S {
+			items = append(items, item)
+		}
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+// TransactWriteItems checks the conditions email changes, merges and the
+// outbox use before applying any write.
+func (c *emailTableClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	reasons := make([]*dynamodb.CancellationReason, len(input.TransactItems))
+	failed := false
+	for i, write := range input.TransactItems {
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+		var existing map[string]*dynamodb.AttributeValue
+		var condition *string
+		switch {
+		case write.Put != nil:
+			existing = c.table(*write.Put.TableName)[itemKey(write.Put.Item)]
+			condition = write.Put.ConditionExpression
+		case write.Delete != nil:
+			existing = c.table(*write.Delete.TableName)[itemKey(write.Delete.Key)]
+			condition = write.Delete.ConditionExpression
+		case write.Update != nil:
+			existing = c.table(*write.Update.TableName)[itemKey(write.Update.Key)]
+			condition = write.Update.ConditionExpression
+		}
+		if condition == nil {
+			continue
+		}
+		holds := true
+		switch *condition {
+		case "attribute_not_exists(email)":
+			holds = existing == nil
+		case "attribute_exists(email)":
+			holds = existing != nil
+		case "attribute_exists(email) AND attribute_not_exists(movedTo)":
+			holds = existing != nil && stringAttribute(existing, "movedTo") == ""
+		case "pendingEmail = :to":
+			holds = stringAttribute(existing, "pendingEmail") == *write.Put.ExpressionAttributeValues[":to"].S
+		}
+		if !holds {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			failed = true
+		}
+	}
+	if failed {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+	for _, write := range input.TransactItems {
+		if write.Put != nil {
+			c.table(*write.Put.TableName)[itemKey(write.Put.Item)] = write.Put.Item
+		}
+		if write.Delete != nil {
+			delete(c.table(*write.Delete.TableName), itemKey(write.Delete.Key))
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (c *emailTableClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	responses := map[string][]map[string]*dynamodb.AttributeValue{}
+	for name, request := range input.RequestItems {
+		for _, key := range request.Keys {
+			if item, ok := c.table(name)[itemKey(key)]; ok {
+				responses[name] = append(responses[name], item)
+			}
+		}
+	}
+	return &dynamodb.BatchGetItemOutput{Responses: responses}, nil
+}
+
+func (c *emailTableClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(c.table(*input.TableName), itemKey(input.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func configureTestEmailChange() *mockSES {
+	client := &mockSES{}
+	ConfigureEmailChange(EmailChangeConfig{
+		Key:    []byte("test signing key"),
+		SES:    client,
+		Sender: "no-reply@ecs.co.uk",
+		URL:    "https://example.com/confirm-email",
+		TTL:    time.Hour,
+	})
+	return client
+}
+
+func seedEmailChangeUser(client *emailTableClient) {
+	client.table("test")["alan.oliver@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{
+		"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+		"firstName": {S: aws.String("Al")},
+		"lastName":  {S: aws.String("Oliver")},
+	}
+	client.table(PreferencesTableName("test"))["alan.oliver@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{
+		"email": {S: aws.String("alan.oliver@ecs.co.uk")},
+		"theme": {S: aws.String("dark")},
+	}
+	audit.Put(audit.Entry{Subject: "alan.oliver@ecs.co.uk", Timestamp: "2023-01-01T00:00:00Z", Action: audit.ActionCreated}, audit.TableName("test"), client)
+}
+
+func TestEmailChange(t *testing.T) {
+	mail := configureTestEmailChange()
+	defer ConfigureEmailChange(EmailChangeConfig{})
+
+	t.Run("expect the user, preferences and history to move once confirmed", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		if err := RequestEmailChange("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if *mail.sent[len(mail.sent)-1].Destination.ToAddresses[0] != "al@ecs.co.uk" {
+			t.Fatal("Expected the confirmation to be sent to the new email")
+		}
+		if len(client.table("test")) != 1 {
+			t.Fatal("Expected nothing to move before the change is confirmed")
+		}
+
+		moved, err := ConfirmEmailChange(mail.token(t), "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if moved.Email != "al@ecs.co.uk" || moved.FirstName != "Al" || moved.PendingEmail != "" {
+			t.Errorf("Expected the user under the new email, got %+v", *moved)
+		}
+		old, _ := FetchUserWithOptions("alan.oliver@ecs.co.uk", "test", client, FetchOptions{ConsistentRead: true})
+		if len(old.Email) != 0 {
+			t.Errorf("Expected the old email to read as no user, got %+v", *old)
+		}
+		if _, ok := client.table(PreferencesTableName("test"))["al@ecs.co.uk"]; !ok {
+			t.Error("Expected the preferences to move")
+		}
+		copied := false
+		history, _ := audit.FetchEntries("al@ecs.co.uk", audit.TableName("test"), client)
+		for _, entry := range history {
+			copied = copied || entry.Timestamp == "2023-01-01T00:00:00Z"
+		}
+		if !copied {
+			t.Errorf("Expected the history to be copied, got %+v", history)
+		}
+	})
+	t.Run("expect a tampered token to be rejected", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		RequestEmailChange("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client)
+		token := mail.token(t)
+
+		_, err := ConfirmEmailChange(token[:len(token)-2]+"xx", "test", client)
+		if err == nil || err.Error() != ErrorInvalidEmailChangeToken {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidEmailChangeToken, err)
+		}
+	})
+	t.Run("expect only the latest requested change to be confirmed", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		RequestEmailChange("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client)
+		first := mail.token(t)
+		RequestEmailChange("alan.oliver@ecs.co.uk", "alan@ecs.co.uk", "test", client)
+
+		_, err := ConfirmEmailChange(first, "test", client)
+		if err == nil || err.Error() != ErrorInvalidEmailChangeToken {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidEmailChangeToken, err)
+		}
+	})
+	t.Run("expect the change to fail when the new email was taken meanwhile", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		RequestEmailChange("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client)
+		client.table("test")["al@ecs.co.uk"] = userItem("al@ecs.co.uk")
+
+		_, err := ConfirmEmailChange(mail.token(t), "test", client)
+		if err == nil || err.Error() != ErrorUserAlreadyExists {
+			t.Errorf("Expected error %s, got %v", ErrorUserAlreadyExists, err)
+		}
+		if stringAttribute(client.table("test")["alan.oliver@ecs.co.uk"], "movedTo") != "" {
+			t.Error("Expected the old item to be left alone")
+		}
+	})
+}
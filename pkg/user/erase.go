@@ -0,0 +1,199 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	ErasureStatusPending   = "pending"
+	ErasureStatusCompleted = "completed"
+
+	ErasureStepDeleteUser    = "deleteUser"
+	ErasureStepScrubAudit    = "scrubAudit"
+	ErasureStepEmitTombstone = "emitTombstone"
+
+	erasureStepAttempts = 3
+)
+
+var (
+	ErrorErasureIncomplete        = "erasure incomplete, retry to resume"
+	ErrorErasureNotFound          = "erasure not found"
+	ErrorFailedToFetchErasure     = "failed to fetch erasure"
+	ErrorFailedToSaveErasure      = "failed to save erasure"
+	ErrorFailedToUnmarshalErasure = "failed to unmarshal erasure"
+)
+
+var (
+	erasureSteps      = []string{ErasureStepDeleteUser, ErasureStepScrubAudit, ErasureStepEmitTombstone}
+	erasureRetryDelay = 100 * time.Millisecond
+)
+
+// Erasure tracks a right-to-be-forgotten request. The email is only kept
+// until every step has completed so that a failed request can be resumed.
+type Erasure struct {
+	ID             string   `json:"id"`
+	Email          string   `json:"email,omitempty"`
+	Status         string   `json:"status"`
+	CompletedSteps []string `json:"completedSteps"`
+	Attempts       int      `json:"attempts"`
+	LastError      string   `json:"lastError,omitempty"`
+	RequestedAt    string   `json:"requestedAt"`
+	UpdatedAt      string   `json:"updatedAt"`
+}
+
+// ErasureTableName returns the table that tracks erasures for a users table.
+func ErasureTableName(tableName string) string {
+	return tableName + "Erasure"
+}
+
+// ErasureID is the pseudonym an erased user is known by in audit records.
+func ErasureID(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:])
+}
+
+func (e *Erasure) hasCompleted(step string) bool {
+	for _, s := range e.CompletedSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// EraseUser deletes the user, scrubs their audit entries and emits a tombstone
+// event. Calling it again for the same email resumes from the first step that
+// has not yet completed.
+func EraseUser(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Erasure, error) {
+	if !validators.IsEmailValid(email) {
+		return nil, errors.New(ErrorInvalidEmail)
+	}
+
+	id := ErasureID(email)
+	erasure, err := FetchErasure(id, tableName, dynaClient)
+	if err != nil && err.Error() != ErrorErasureNotFound {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if erasure == nil {
+		erasure = &Erasure{
+			ID:             id,
+			Status:         ErasureStatusPending,
+			CompletedSteps: []string{},
+			RequestedAt:    now,
+		}
+	}
+	if erasure.Status == ErasureStatusCompleted {
+		return erasure, nil
+	}
+	erasure.Email = email
+	erasure.Attempts++
+	erasure.UpdatedAt = now
+	if err := saveErasure(erasure, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+
+	for _, step := range erasureSteps {
+		if erasure.hasCompleted(step) {
+			continue
+		}
+		if err := runErasureStep(step, erasure, tableName, dynaClient); err != nil {
+			erasure.LastError = err.Error()
+			erasure.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+			if err := saveErasure(erasure, tableName, dynaClient); err != nil {
+				return nil, err
+			}
+			return erasure, errors.New(ErrorErasureIncomplete)
+		}
+		erasure.CompletedSteps = append(erasure.CompletedSteps, step)
+		if err := saveErasure(erasure, tableName, dynaClient); err != nil {
+			return nil, err
+		}
+	}
+
+	erasure.Email = ""
+	erasure.LastError = ""
+	erasure.Status = ErasureStatusCompleted
+	erasure.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := saveErasure(erasure, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
+
+func runErasureStep(step string, erasure *Erasure, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	var err error
+	for attempt := 0; attempt < erasureStepAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(erasureRetryDelay * time.Duration(attempt))
+		}
+		switch step {
+		case ErasureStepDeleteUser:
+			_, err = deleteUserRecord(erasure.Email, true, Options{}, tableName, dynaClient)
+			if err == nil && eventSourcing.Enabled {
+				err = deleteEvents(erasure.Email, tableName, dynaClient)
+			}
+		case ErasureStepScrubAudit:
+			err = audit.Scrub(erasure.Email, erasure.ID, audit.TableName(tableName), dynaClient)
+		case ErasureStepEmitTombstone:
+			err = audit.Record(erasure.ID, audit.ActionErased, nil, audit.TableName(tableName), dynaClient)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func FetchErasure(id string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Erasure, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		TableName: aws.String(ErasureTableName(tableName)),
+	}
+	result, err := dynaClient.GetItem(input)
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchErasure)
+	}
+	if result == nil || len(result.Item) == 0 {
+		return nil, errors.New(ErrorErasureNotFound)
+	}
+
+	erasure := new(Erasure)
+	err = dynamodbattribute.UnmarshalMap(result.Item, erasure)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalErasure)
+	}
+	return erasure, nil
+}
+
+func saveErasure(erasure *Erasure, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	av, err := dynamodbattribute.MarshalMap(erasure)
+	if err != nil {
+		return errors.New(ErrorFailedToSaveErasure)
+	}
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ErasureTableName(tableName)),
+	}
+	_, err = dynaClient.PutItem(input)
+	if err != nil {
+		return store.Error(err, ErrorFailedToSaveErasure)
+	}
+	return nil
+}
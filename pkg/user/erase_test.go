@@ -0,0 +1,110 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestEraseUser(t *testing.T) {
+	erasureRetryDelay = 0
+
+	t.Run("expect error when invalid email is provided", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := EraseUser("invalid-email", "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorInvalidEmail {
+			t.Errorf("Expected error %s, got %s", ErrorInvalidEmail, err.Error())
+		}
+	})
+	t.Run("expect error when the erasure cannot be saved", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.PutItemErr = errors.New("put error")
+
+		_, err := EraseUser("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorFailedToSaveErasure {
+			t.Errorf("Expected error %s, got %s", ErrorFailedToSaveErasure, err.Error())
+		}
+	})
+	t.Run("expect erasure to be left pending when a step fails", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if *input.TableName == "testAudit" {
+				return nil, errors.New("put error")
+			}
+			return &dynamodb.PutItemOutput{}, nil
+		}}
+
+		erasure, err := EraseUser("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorErasureIncomplete {
+			t.Errorf("Expected error %s, got %s", ErrorErasureIncomplete, err.Error())
+		}
+		if erasure.Status != ErasureStatusPending {
+			t.Errorf("Expected status %s, got %s", ErasureStatusPending, erasure.Status)
+		}
+		if len(erasure.CompletedSteps) != 2 {
+			t.Errorf("Expected %d completed steps, got %d", 2, len(erasure.CompletedSteps))
+		}
+		if erasure.Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected email %s to be kept for retry, got %s", "alan.oliver@ecs.co.uk", erasure.Email)
+		}
+		if erasure.LastError != "failed to write audit entry" {
+			t.Errorf("Expected last error %s, got %s", "failed to write audit entry", erasure.LastError)
+		}
+	})
+	t.Run("expect completed erasure to be returned without running again", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutputs = map[string]*dynamodb.GetItemOutput{
+			"testErasure": {
+				Item: map[string]*dynamodb.AttributeValue{
+					"id": {
+						S: aws.String(ErasureID("alan.oliver@ecs.co.uk")),
+					},
+					"status": {
+						S: aws.String(ErasureStatusCompleted),
+					},
+				},
+			},
+		}
+
+		erasure, err := EraseUser("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if erasure.Status != ErasureStatusCompleted {
+			t.Errorf("Expected status %s, got %s", ErasureStatusCompleted, erasure.Status)
+		}
+		if len(mockDb.PutItemInputs()) != 0 {
+			t.Errorf("Expected no writes, got %d", len(mockDb.PutItemInputs()))
+		}
+	})
+	t.Run("expect user to be erased", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		erasure, err := EraseUser("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if erasure.Status != ErasureStatusCompleted {
+			t.Errorf("Expected status %s, got %s", ErasureStatusCompleted, erasure.Status)
+		}
+		if len(erasure.CompletedSteps) != 3 {
+			t.Errorf("Expected %d completed steps, got %d", 3, len(erasure.CompletedSteps))
+		}
+		if erasure.Email != "" {
+			t.Errorf("Expected email to be cleared, got %s", erasure.Email)
+		}
+	})
+}
@@ -0,0 +1,417 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// EventSourcingConfig switches users to being stored as the events that
+// changed them. Creating, updating and deleting users, one at a time or in
+// bulk, and changing their status, tags, avatar or email append an event to
+// the user's stream, keyed by email and sequence number, and the users table
+// holds a snapshot of each user as of an event, which reads bring up to date
+// with any events after it. Merges, moves made by operators, activity
+// timestamps and pending email changes are made to the snapshot alone and
+// so are not part of the user's history.
+type EventSourcingConfig struct {
+	Enabled bool
+}
+
+var eventSourcing EventSourcingConfig
+
+func ConfigureEventSourcing(config EventSourcingConfig) {
+	eventSourcing = config
+}
+
+// Types of event.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+var (
+	ErrorConcurrentUpdate           = "user was changed by another request"
+	ErrorEventSourcingNotConfigured = "event sourcing is not configured"
+	ErrorFailedToAppendEvent        = "failed to append user event"
+	ErrorFailedToFetchEvents        = "failed to fetch user events"
+	ErrorFailedToUnmarshalEvent     = "failed to unmarshal user event"
+)
+
+// Event is one change to a user. Changes holds the attributes it set, by
+// their JSON name, and Removed those it cleared. A user's first event sets
+// every attribute they had, and a deleted event clears them all.
+type Event struct {
+	Email      string                 `json:"email"`
+	Sequence   int64                  `json:"sequence"`
+	Type       string                 `json:"type"`
+	OccurredAt string                 `json:"occurredAt"`
+	Changes    map[string]interface{} `json:"changes,omitempty"`
+	Removed    []string               `json:"removed,omitempty"`
+}
+
+// EventTableName returns the table that holds the event streams of a users
+// table.
+func EventTableName(tableName string) string {
+	return tableName + "Event"
+}
+
+// eventItem is an event as stored. Set keeps the attributes it set as they
+// are stored on the user, so replaying an event is copying them.
+type eventItem struct {
+	Email      string
+	Sequence   int64
+	Type       string
+	OccurredAt string
+	Set        map[string]*dynamodb.AttributeValue
+	Remove     []string
+}
+
+func (e eventItem) attributes() map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"email":      {S: aws.String(e.Email)},
+		"sequence":   {N: aws.String(strconv.FormatInt(e.Sequence, 10))},
+		"type":       {S: aws.String(e.Type)},
+		"occurredAt": {S: aws.String(e.OccurredAt)},
+	}
+	if len(e.Set) > 0 {
+		item["set"] = &dynamodb.AttributeValue{M: e.Set}
+	}
+	if len(e.Remove) > 0 {
+		item["remove"] = &dynamodb.AttributeValue{SS: aws.StringSlice(e.Remove)}
+	}
+	return item
+}
+
+func eventFromAttributes(item map[string]*dynamodb.AttributeValue) (eventItem, error) {
+	sequence, ok := numberAttribute(item, "sequence")
+	if !ok {
+		return eventItem{}, errors.New(ErrorFailedToUnmarshalEvent)
+	}
+	e := eventItem{
+		Email:      stringAttribute(item, "email"),
+		Sequence:   sequence,
+		Type:       stringAttribute(item, "type"),
+		OccurredAt: stringAttribute(item, "occurredAt"),
+	}
+	if set, ok := item["set"]; ok {
+		e.Set = set.M
+	}
+	if remove, ok := item["remove"]; ok {
+		e.Remove = aws.StringValueSlice(remove.SS)
+	}
+	return e, nil
+}
+
+func numberAttribute(item map[string]*dynamodb.AttributeValue, name string) (int64, bool) {
+	value, ok := item[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+	return n, err == nil
+}
+
+// apply replays e onto state, the user's attributes before it.
+func (e eventItem) apply(state map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if e.Type == EventDeleted {
+		return map[string]*dynamodb.AttributeValue{}
+	}
+	next := map[string]*dynamodb.AttributeValue{}
+	for name, value := range state {
+		next[name] = value
+	}
+	for _, name := range e.Remove {
+		delete(next, name)
+	}
+	for name, value := range e.Set {
+		next[name] = value
+	}
+	next["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(e.Sequence, 10))}
+	return next
+}
+
+// changes returns the attributes after sets that differ from before and
+// those it no longer has. The version is not a change of its own.
+func changes(before map[string]*dynamodb.AttributeValue, after map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, []string) {
+	set := map[string]*dynamodb.AttributeValue{}
+	for name, value := range after {
+		if name != "version" && !reflect.DeepEqual(before[name], value) {
+			set[name] = value
+		}
+	}
+	removed := []string{}
+	for name := range before {
+		if _, ok := after[name]; !ok && name != "version" {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return set, removed
+}
+
+// appendEvent records the change from before, the user as last read, to
+// after, or the user's deletion when after is nil, as the next event in
+// their stream. The event is only appended if no other has been since before
+// was read. The user's snapshot is then brought up to date; should that
+// fail, the next read catches it up instead.
+func appendEvent(eventType string, before User, after *User, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	e, current, err := nextEvent(eventType, before, after, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if outbox.Enabled() {
+		var data interface{} = map[string]string{"email": e.Email}
+		if after != nil {
+			data = after
+		}
+		conditionFailed, err := commitWithOutbox(e.put(tableName), outboxEvents[eventType], e.Email, data, tableName, dynaClient)
+		if conditionFailed {
+			return errors.New(ErrorConcurrentUpdate)
+		}
+		if err != nil {
+			return store.Error(err, ErrorFailedToAppendEvent)
+		}
+	} else {
+		put := e.put(tableName).Put
+		_, err := dynaClient.PutItem(&dynamodb.PutItemInput{
+			Item:                put.Item,
+			TableName:           put.TableName,
+			ConditionExpression: put.ConditionExpression,
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				return errors.New(ErrorConcurrentUpdate)
+			}
+			return store.Error(err, ErrorFailedToAppendEvent)
+		}
+	}
+	if after != nil {
+		saveSnapshot(current, tableName, dynaClient)
+	}
+	return nil
+}
+
+// nextEvent returns the event that follows before in the user's stream,
+// recording the change to after, or the user's deletion when after is nil,
+// along with after's attributes as of the event. after's version is set to
+// the event's sequence.
+func nextEvent(eventType string, before User, after *User, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (eventItem, map[string]*dynamodb.AttributeValue, error) {
+	email := before.Email
+	if after != nil {
+		email = after.Email
+	}
+	previous := map[string]*dynamodb.AttributeValue{}
+	sequence := before.Version
+	if sequence > 0 {
+		var err error
+		if previous, err = dynamodbattribute.MarshalMap(before); err != nil {
+			return eventItem{}, nil, errors.New(ErrorCouldNotMarshalItem)
+		}
+	} else {
+		// A user from before event sourcing was enabled, or one created again
+		// after being deleted, is not stored as of an event. Their first
+		// event records all of them, carrying on from any stream they had
+		newest, err := newestEvent(email, tableName, dynaClient)
+		if err != nil {
+			return eventItem{}, nil, err
+		}
+		sequence = newest.Sequence
+	}
+	e := eventItem{
+		Email:      email,
+		Sequence:   sequence + 1,
+		Type:       eventType,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	current := map[string]*dynamodb.AttributeValue{}
+	if after != nil {
+		after.Version = e.Sequence
+		var err error
+		if current, err = dynamodbattribute.MarshalMap(after); err != nil {
+			return eventItem{}, nil, errors.New(ErrorCouldNotMarshalItem)
+		}
+		e.Set, e.Remove = changes(previous, current)
+	}
+	return e, current, nil
+}
+
+// put writes e unless its stream already holds an event with its sequence,
+// which is how two changes made from the same version are told apart.
+func (e eventItem) put(tableName string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		Item:                e.attributes(),
+		TableName:           aws.String(EventTableName(tableName)),
+		ConditionExpression: aws.String("attribute_not_exists(email)"),
+	}}
+}
+
+// saveSnapshot stores item as the user's snapshot unless it already holds a
+// later one. It is best effort: the events are what was changed.
+func saveSnapshot(item map[string]*dynamodb.AttributeValue, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	_, _ = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:                      item,
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String("attribute_not_exists(#version) OR #version < :version"),
+		ExpressionAttributeNames:  map[string]*string{"#version": aws.String("version")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":version": item["version"]},
+	})
+}
+
+// catchUp replays onto item, a user's snapshot, the events appended after
+// it, and saves the result as the new snapshot when there were any. A user
+// without a snapshot is rebuilt from their whole stream, unless it ends with
+// their deletion, and a tombstone left by an email change has nothing to
+// catch up with.
+func catchUp(email string, item map[string]*dynamodb.AttributeValue, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (map[string]*dynamodb.AttributeValue, error) {
+	if stringAttribute(item, "movedTo") != "" {
+		return item, nil
+	}
+	if len(item) == 0 {
+		newest, err := newestEvent(email, tableName, dynaClient)
+		if err != nil || newest.Sequence == 0 || newest.Type == EventDeleted {
+			return item, err
+		}
+	}
+	version, _ := numberAttribute(item, "version")
+	after := streamQuery(email, tableName)
+	after.KeyConditionExpression = aws.String("email = :email AND #sequence > :version")
+	after.ExpressionAttributeNames = map[string]*string{"#sequence": aws.String("sequence")}
+	after.ExpressionAttributeValues[":version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(version, 10))}
+	events, err := queryEvents(after, dynaClient, false)
+	if err != nil || len(events) == 0 {
+		return item, err
+	}
+	for _, e := range events {
+		item = e.apply(item)
+	}
+	if len(item) > 0 {
+		saveSnapshot(item, tableName, dynaClient)
+	}
+	return item, nil
+}
+
+// queryEvents reads the events input selects, stopping after the first page
+// when once is set.
+func queryEvents(input *dynamodb.QueryInput, dynaClient dynamodbiface.DynamoDBAPI, once bool) ([]eventItem, error) {
+	events := []eventItem{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchEvents)
+		}
+		for _, item := range result.Items {
+			e, err := eventFromAttributes(item)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+		if once || len(result.LastEvaluatedKey) == 0 {
+			return events, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// newestEvent reads the last event in a user's stream, which is empty when
+// they have none.
+func newestEvent(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (eventItem, error) {
+	input := streamQuery(email, tableName)
+	input.ScanIndexForward = aws.Bool(false)
+	input.Limit = aws.Int64(1)
+	newest, err := queryEvents(input, dynaClient, true)
+	if err != nil || len(newest) == 0 {
+		return eventItem{}, err
+	}
+	return newest[0], nil
+}
+
+func streamQuery(email string, tableName string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		KeyConditionExpression:    aws.String("email = :email"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":email": {S: aws.String(email)}},
+		ConsistentRead:            aws.Bool(true),
+		TableName:                 aws.String(EventTableName(tableName)),
+	}
+}
+
+// FetchEvents reads the history of a user, oldest first.
+func FetchEvents(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Event, error) {
+	if !eventSourcing.Enabled {
+		return nil, errors.New(ErrorEventSourcingNotConfigured)
+	}
+	stream, err := queryEvents(streamQuery(email, tableName), dynaClient, false)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]Event, len(stream))
+	for i, e := range stream {
+		history[i] = Event{Email: e.Email, Sequence: e.Sequence, Type: e.Type, OccurredAt: e.OccurredAt, Removed: e.Remove}
+		if len(e.Set) > 0 {
+			if err := dynamodbattribute.UnmarshalMap(e.Set, &history[i].Changes); err != nil {
+				return nil, errors.New(ErrorFailedToUnmarshalEvent)
+			}
+		}
+	}
+	return history, nil
+}
+
+// FetchUserAsOf rebuilds a user as they were at, from the events up to then.
+// The user is empty if they did not exist at the time.
+func FetchUserAsOf(email string, at time.Time, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	if !eventSourcing.Enabled {
+		return nil, errors.New(ErrorEventSourcingNotConfigured)
+	}
+	stream, err := queryEvents(streamQuery(email, tableName), dynaClient, false)
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]*dynamodb.AttributeValue{}
+	for _, e := range stream {
+		occurred, err := time.Parse(time.RFC3339Nano, e.OccurredAt)
+		if err != nil || occurred.After(at) {
+			break
+		}
+		state = e.apply(state)
+	}
+	u := new(User)
+	if err := dynamodbattribute.UnmarshalMap(state, u); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	return u, nil
+}
+
+// deleteEvents removes a user's whole stream, for erasures.
+func deleteEvents(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	stream, err := queryEvents(streamQuery(email, tableName), dynaClient, false)
+	if err != nil {
+		return err
+	}
+	for _, e := range stream {
+		_, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"email":    {S: aws.String(email)},
+				"sequence": {N: aws.String(strconv.FormatInt(e.Sequence, 10))},
+			},
+			TableName: aws.String(EventTableName(tableName)),
+		})
+		if err != nil {
+			return store.Error(err, ErrorFailedToDeleteRecord)
+		}
+	}
+	return nil
+}
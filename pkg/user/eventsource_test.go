@@ -0,0 +1,219 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newEventClient returns a client keeping the users table, its event
+// stream and every other table a user write touches in memory.
+func newEventClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(
+		testutil.Table{Name: "test", PartitionKey: "email"},
+		testutil.Table{Name: EventTableName("test"), PartitionKey: "email", SortKey: "sequence"},
+		testutil.Table{Name: audit.TableName("test"), PartitionKey: "subject", SortKey: "timestamp"},
+		testutil.Table{Name: schema.TableName("test"), PartitionKey: "name"},
+		testutil.Table{Name: PreferencesTableName("test"), PartitionKey: "email"},
+		testutil.Table{Name: credentials.TableName("test"), PartitionKey: "email"},
+		testutil.Table{Name: session.TableName("test"), PartitionKey: "id", Indexes: []testutil.Index{{Name: session.Table("test").Index(session.EmailIndex), PartitionKey: "email"}}},
+	)
+}
+
+func configureTestEventSourcing(t *testing.T) {
+	ConfigureEventSourcing(EventSourcingConfig{Enabled: true})
+	t.Cleanup(func() { ConfigureEventSourcing(EventSourcingConfig{}) })
+}
+
+func updateRequest(body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{Body: body}
+}
+
+func TestEventSourcing(t *testing.T) {
+	email := "alan.oliver@ecs.co.uk"
+
+	t.Run("expect every change to be appended as an event", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		if _, err := Create(User{Email: email, FirstName: "Alan", LastName: "Oliver", JobTitle: aws.String("Engineer")}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		updated, err := UpdateUser(updateRequest(`{"email":"`+email+`","lastName":"Shearer","jobTitle":""}`), "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if updated.LastName != "Shearer" || updated.Version != 2 {
+			t.Errorf("Expected the updated user as of the second event, got %+v", *updated)
+		}
+		if err := DeleteUser(events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": email}}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+
+		history, err := FetchEvents(email, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		types := []string{}
+		for _, e := range history {
+			types = append(types, e.Type)
+		}
+		if strings.Join(types, ",") != "created,updated,deleted" {
+			t.Fatalf("Expected created, updated and deleted events, got %v", types)
+		}
+		if history[0].Changes["firstName"] != "Alan" || history[0].Changes["email"] != email {
+			t.Errorf("Expected the created event to hold the whole user, got %v", history[0].Changes)
+		}
+		if len(history[1].Changes) != 1 || history[1].Changes["lastName"] != "Shearer" {
+			t.Errorf("Expected the updated event to hold only the changed name, got %v", history[1].Changes)
+		}
+		if strings.Join(history[1].Removed, ",") != "jobTitle" {
+			t.Errorf("Expected the cleared job title to be removed, got %v", history[1].Removed)
+		}
+		u, _ := FetchUser(email, "test", client)
+		if u.Email != "" {
+			t.Errorf("Expected the deleted user to be read as no user, got %+v", *u)
+		}
+	})
+	t.Run("expect status, tag and email changes to be appended as events", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		mail := configureTestEmailChange()
+		defer ConfigureEmailChange(EmailChangeConfig{})
+		client := newEventClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		if _, err := SetStatus(email, StatusSuspended, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := SetStatus(email, StatusActive, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := AddTags(email, []string{"beta", "staff"}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := RemoveTags(email, []string{"staff"}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if err := RequestEmailChange(email, "al@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		moved, err := ConfirmEmailChange(mail.token(t), "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+
+		history, _ := FetchEvents(email, "test", client)
+		types := []string{}
+		for _, e := range history {
+			types = append(types, e.Type)
+		}
+		if strings.Join(types, ",") != "created,updated,updated,updated,updated,deleted" {
+			t.Fatalf("Expected each change and the move away to be events, got %v", types)
+		}
+		if history[1].Changes["status"] != StatusSuspended || len(history[4].Changes["tags"].([]string)) != 1 {
+			t.Errorf("Expected the status and tags to be changed, got %v and %v", history[1].Changes, history[4].Changes)
+		}
+		arrived, _ := FetchEvents("al@ecs.co.uk", "test", client)
+		if len(arrived) != 1 || arrived[0].Type != EventCreated || moved.Version != 1 {
+			t.Fatalf("Expected the new email to start a stream, got %+v and version %d", arrived, moved.Version)
+		}
+		client.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String("test"), Key: userKey("al@ecs.co.uk")})
+		if rebuilt, _ := FetchUser("al@ecs.co.uk", "test", client); rebuilt.Status != StatusActive || len(rebuilt.Tags) != 1 {
+			t.Errorf("Expected the moved user to be rebuilt from their stream, got %+v", *rebuilt)
+		}
+	})
+	t.Run("expect a bulk deletion to be appended as an event", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver", Tags: []string{"beta"}}, "test", client)
+		result, err := DeleteUsers(BulkDelete{Filter: &BulkDeleteFilter{Tag: "beta"}}, "test", client)
+		if err != nil || result.Outcomes[0].Outcome != DeleteOutcomeDeleted {
+			t.Fatalf("Expected the user to be deleted, got %+v %v", result, err)
+		}
+		history, _ := FetchEvents(email, "test", client)
+		if len(history) != 2 || history[1].Type != EventDeleted {
+			t.Fatalf("Expected the deletion to be an event, got %+v", history)
+		}
+		if u, _ := FetchUser(email, "test", client); u.Email != "" {
+			t.Errorf("Expected the user not to be rebuilt from their stream, got %+v", *u)
+		}
+	})
+	t.Run("expect a user to be read as they were at a time", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		created, _ := FetchEvents(email, "test", client)
+		UpdateUser(updateRequest(`{"email":"`+email+`","lastName":"Shearer"}`), "test", client)
+
+		at, _ := time.Parse(time.RFC3339Nano, created[0].OccurredAt)
+		then, err := FetchUserAsOf(email, at, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if then.LastName != "Oliver" {
+			t.Errorf("Expected the user as created, got %+v", *then)
+		}
+		before, _ := FetchUserAsOf(email, at.Add(-time.Second), "test", client)
+		if before.Email != "" {
+			t.Errorf("Expected no user before they were created, got %+v", *before)
+		}
+	})
+	t.Run("expect reads to catch a stale snapshot up with later events", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		stale, _ := client.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test"), Key: userKey(email)})
+		UpdateUser(updateRequest(`{"email":"`+email+`","lastName":"Shearer"}`), "test", client)
+		client.Put("test", stale.Item)
+
+		u, err := FetchUser(email, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if u.LastName != "Shearer" || u.Version != 2 {
+			t.Errorf("Expected the user as of the latest event, got %+v", *u)
+		}
+		if version, _ := numberAttribute(client.Item("test", userKey(email)), "version"); version != 2 {
+			t.Errorf("Expected the snapshot to be caught up, got version %d", version)
+		}
+	})
+	t.Run("expect a user created again to carry on their stream", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		DeleteUser(events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": email}}, "test", client)
+		again, err := Create(User{Email: email, FirstName: "Alan", LastName: "Shearer"}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if again.Version != 3 {
+			t.Errorf("Expected the third event, got version %d", again.Version)
+		}
+	})
+	t.Run("expect a change made from a stale read to conflict", func(t *testing.T) {
+		configureTestEventSourcing(t)
+		client := newEventClient()
+		created, _ := Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		stale := *created
+		UpdateUser(updateRequest(`{"email":"`+email+`","lastName":"Shearer"}`), "test", client)
+
+		changed := stale
+		changed.FirstName = "Al"
+		if err := appendEvent(EventUpdated, stale, &changed, "test", client); err == nil || err.Error() != ErrorConcurrentUpdate {
+			t.Errorf("Expected error %s, got %v", ErrorConcurrentUpdate, err)
+		}
+	})
+	t.Run("expect history to need event sourcing", func(t *testing.T) {
+		if _, err := FetchEvents(email, "test", newEventClient()); err == nil || err.Error() != ErrorEventSourcingNotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorEventSourcingNotConfigured, err)
+		}
+	})
+}
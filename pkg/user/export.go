@@ -0,0 +1,374 @@
+package user
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// DefaultExportPartSize is the size of the parts exports are uploaded
+	// in when none is configured.
+	DefaultExportPartSize = 8 * 1024 * 1024
+	// MinExportPartSize is the smallest part size S3 takes for every part of
+	// a multipart upload but the last.
+	MinExportPartSize = 5 * 1024 * 1024
+)
+
+var (
+	ErrorExportNotFound          = "export not found"
+	ErrorExportsNotConfigured    = "exports are not configured"
+	ErrorFailedToExport          = "failed to export users"
+	ErrorFailedToFetchExport     = "failed to fetch export"
+	ErrorFailedToMarshalExport   = "failed to marshal export"
+	ErrorFailedToSaveExport      = "failed to save export"
+	ErrorFailedToUnmarshalExport = "failed to unmarshal export"
+	ErrorUnknownExportFormat     = "unknown export format"
+)
+
+// Statuses of an export job.
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// exportJobTTL is how long an export job is kept after it was requested.
+const exportJobTTL = 7 * 24 * time.Hour
+
+// Formats users can be exported in.
+const (
+	// ExportJSON writes every user to one newline-delimited JSON object
+	ExportJSON = "jsonl"
+	// ExportParquet writes every user to Parquet files under a partition of
+	// the day the export ran, for Athena and Glue to query
+	ExportParquet = "parquet"
+)
+
+// ExportConfig enables exports to Bucket. An export takes longer than an
+// API request may, so StartExport runs it by invoking FunctionName, the
+// function itself, asynchronously through Lambda.
+type ExportConfig struct {
+	Bucket       string
+	S3           s3iface.S3API
+	PartSize     int
+	Lambda       lambdaiface.LambdaAPI
+	FunctionName string
+}
+
+var exports ExportConfig
+
+func ConfigureExports(config ExportConfig) {
+	exports = config
+}
+
+// Export describes a finished export.
+type Export struct {
+	Bucket string `json:"bucket"`
+	// Key is the object a JSON export was written to, or the prefix of the
+	// files of a Parquet export
+	Key    string `json:"key"`
+	Format string `json:"format"`
+	Users  int    `json:"users"`
+	Parts  int    `json:"parts,omitempty"`
+	Files  int    `json:"files,omitempty"`
+}
+
+// ExportJob tracks an export run in the background, holding the Export once
+// it has completed, or the Error it failed with.
+type ExportJob struct {
+	ID          string  `json:"id"`
+	Format      string  `json:"format"`
+	Status      string  `json:"status"`
+	Export      *Export `json:"export,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	RequestedAt string  `json:"requestedAt"`
+	UpdatedAt   string  `json:"updatedAt"`
+	// TTL is when DynamoDB may remove the job, in Unix seconds
+	TTL int64 `json:"-" dynamodbav:"ttl"`
+}
+
+// ExportRequest is the event an export job is run with: the job and the
+// users table it exports, which differs between tenants.
+type ExportRequest struct {
+	ID    string `json:"id"`
+	Table string `json:"table"`
+}
+
+type exportEvent struct {
+	Export *ExportRequest `json:"export"`
+}
+
+// ExportTableName returns the table that tracks exports for a users table.
+func ExportTableName(tableName string) string {
+	return tableName + "Export"
+}
+
+func exportJobs(tableName string, dynaClient dynamodbiface.DynamoDBAPI) *store.Repository[ExportJob] {
+	r := store.NewRepository(store.NewTable(ExportTableName(tableName)), store.KeySpec{PartitionKey: "id"}, func(j ExportJob) store.Key {
+		return store.Key{Partition: j.ID}
+	}, dynaClient)
+	r.Errors = store.ItemErrors{Marshal: ErrorFailedToMarshalExport, Unmarshal: ErrorFailedToUnmarshalExport}
+	return r
+}
+
+// StartExport records an export of every user in format and starts it in
+// the background, returning the pending job to be followed with
+// FetchExport.
+func StartExport(format string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ExportJob, error) {
+	if exports.S3 == nil || exports.Lambda == nil || exports.FunctionName == "" {
+		return nil, errors.New(ErrorExportsNotConfigured)
+	}
+	if format == "" {
+		format = ExportJSON
+	}
+	if format != ExportJSON && format != ExportParquet {
+		return nil, errors.New(ErrorUnknownExportFormat)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.New(ErrorFailedToExport)
+	}
+	now := time.Now().UTC()
+	job := ExportJob{
+		ID:          hex.EncodeToString(b),
+		Format:      format,
+		Status:      ExportStatusPending,
+		RequestedAt: now.Format(time.RFC3339),
+		UpdatedAt:   now.Format(time.RFC3339),
+		TTL:         now.Add(exportJobTTL).Unix(),
+	}
+	if err := exportJobs(tableName, dynaClient).Create(job); err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveExport)
+	}
+	payload, err := json.Marshal(exportEvent{Export: &ExportRequest{ID: job.ID, Table: tableName}})
+	if err == nil {
+		_, err = exports.Lambda.Invoke(&lambda.InvokeInput{
+			FunctionName:   aws.String(exports.FunctionName),
+			InvocationType: aws.String(lambda.InvocationTypeEvent),
+			Payload:        payload,
+		})
+	}
+	if err != nil {
+		job.Status = ExportStatusFailed
+		job.Error = ErrorFailedToExport
+		saveExportJob(&job, tableName, dynaClient)
+		return nil, errors.New(ErrorFailedToExport)
+	}
+	return &job, nil
+}
+
+// FetchExport returns the export job id.
+func FetchExport(id string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ExportJob, error) {
+	job, err := exportJobs(tableName, dynaClient).Get(store.Key{Partition: id})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchExport)
+	}
+	if job == nil {
+		return nil, errors.New(ErrorExportNotFound)
+	}
+	return job, nil
+}
+
+// ParseExportEvent returns the export request a payload carries, reporting
+// whether it is one.
+func ParseExportEvent(payload []byte) (ExportRequest, bool) {
+	var e exportEvent
+	if err := json.Unmarshal(payload, &e); err != nil || e.Export == nil || e.Export.ID == "" {
+		return ExportRequest{}, false
+	}
+	return *e.Export, true
+}
+
+// RunExport runs the pending export job request names and records how it
+// ended. A job that is no longer pending is left as it is, as Lambda may
+// deliver an asynchronous invocation more than once.
+func RunExport(request ExportRequest, dynaClient dynamodbiface.DynamoDBAPI) (*ExportJob, error) {
+	job, err := FetchExport(request.ID, request.Table, dynaClient)
+	if err != nil || job.Status != ExportStatusPending {
+		return job, err
+	}
+	export, err := ExportUsers(job.Format, request.Table, dynaClient)
+	job.Status, job.Export = ExportStatusCompleted, export
+	if err != nil {
+		job.Status, job.Error = ExportStatusFailed, err.Error()
+	}
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := saveExportJob(job, request.Table, dynaClient); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func saveExportJob(job *ExportJob, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if err := exportJobs(tableName, dynaClient).Put(*job, ""); err != nil {
+		return store.Error(err, ErrorFailedToSaveExport)
+	}
+	return nil
+}
+
+// ExportUsers writes every user to the export bucket in format, one of the
+// Export formats, or as JSON when it is empty. It takes as long as the
+// table takes to read, so the API starts exports with StartExport instead.
+func ExportUsers(format string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Export, error) {
+	if exports.S3 == nil {
+		return nil, errors.New(ErrorExportsNotConfigured)
+	}
+	if format == "" {
+		format = ExportJSON
+	}
+	if format != ExportJSON && format != ExportParquet {
+		return nil, errors.New(ErrorUnknownExportFormat)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.New(ErrorFailedToExport)
+	}
+	now := time.Now().UTC()
+	name := now.Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+	size := exports.PartSize
+	if size <= 0 {
+		size = DefaultExportPartSize
+	}
+	if format == ExportParquet {
+		return exportParquet("exports/parquet/date="+now.Format("2006-01-02")+"/", name, size, tableName, dynaClient)
+	}
+	return exportJSON("exports/"+name+".jsonl", size, tableName, dynaClient)
+}
+
+// exportJSON writes every user to a newline-delimited JSON object at key.
+// Scan pages are written to an S3 multipart upload as they are read, so no
+// more than one part is held in memory however large the table is. A failed
+// export aborts its upload.
+func exportJSON(key string, size int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Export, error) {
+	upload, err := exports.S3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(exports.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToExport)
+	}
+
+	writer := &partWriter{upload: upload, size: size}
+	export := &Export{Bucket: exports.Bucket, Key: key, Format: ExportJSON}
+	err = scanExport(tableName, dynaClient, func(u User) error {
+		line, err := json.Marshal(u)
+		if err != nil {
+			return errors.New(ErrorFailedToExport)
+		}
+		export.Users++
+		return writer.write(append(line, '\n'))
+	})
+	if err == nil {
+		err = writer.complete()
+	}
+	if err != nil {
+		exports.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   upload.Bucket,
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		})
+		return nil, err
+	}
+	export.Parts = len(writer.parts)
+	return export, nil
+}
+
+// scanExport calls fn with every user in the table, leaving out the
+// tombstones left by email changes.
+func scanExport(tableName string, dynaClient dynamodbiface.DynamoDBAPI, fn func(User) error) error {
+	store.RecordScan(tableName, store.ScanExport)
+	dynaClient = store.Background(dynaClient)
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	for {
+		result, err := dynaClient.Scan(input)
+		if err != nil {
+			return store.Error(err, ErrorFailedToFetchRecord)
+		}
+		for _, item := range result.Items {
+			var u User
+			if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+				return errors.New(ErrorFailedToUnmarshalRecord)
+			}
+			if u.MovedTo != "" {
+				continue
+			}
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// partWriter buffers lines until a part is full and uploads it.
+type partWriter struct {
+	upload *s3.CreateMultipartUploadOutput
+	size   int
+	buffer bytes.Buffer
+	parts  []*s3.CompletedPart
+}
+
+func (w *partWriter) write(line []byte) error {
+	w.buffer.Write(line)
+	if w.buffer.Len() >= w.size {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *partWriter) flush() error {
+	number := aws.Int64(int64(len(w.parts) + 1))
+	part, err := exports.S3.UploadPart(&s3.UploadPartInput{
+		Bucket:     w.upload.Bucket,
+		Key:        w.upload.Key,
+		UploadId:   w.upload.UploadId,
+		PartNumber: number,
+		Body:       bytes.NewReader(w.buffer.Bytes()),
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToExport)
+	}
+	w.parts = append(w.parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: number})
+	w.buffer.Reset()
+	return nil
+}
+
+// complete uploads what is left as the last part, which may be smaller than
+// the others, and completes the upload. An empty table still uploads one
+// empty part, as an upload needs at least one.
+func (w *partWriter) complete() error {
+	if w.buffer.Len() > 0 || len(w.parts) == 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	_, err := exports.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          w.upload.Bucket,
+		Key:             w.upload.Key,
+		UploadId:        w.upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToExport)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/parquet"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ExportColumns are the columns users are written to for analytics, such as
+// in a Parquet export, named by the fields of a user's JSON. Fields that are
+// not text, such as an address or tags, are written as their JSON. A field
+// added to User is left out until it is added here, and to the tables
+// declared over the data.
+var ExportColumns = []string{
+	"email",
+	"firstName",
+	"lastName",
+	"phone",
+	"dateOfBirth",
+	"address",
+	"jobTitle",
+	"company",
+	"metadata",
+	"tags",
+	"avatar",
+	"lastLoginAt",
+	"lastSeenAt",
+	"status",
+	"statusChangedAt",
+	"pendingEmail",
+	"createdAt",
+	"createdBy",
+	"updatedBy",
+	"identity",
+}
+
+// exportParquet writes every user to Parquet files named after name under
+// prefix, a partition of the day the export ran. A file is written once the
+// users in it reach size, so no more than one file is held in memory. A
+// failed export deletes the files it wrote.
+func exportParquet(prefix string, name string, size int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Export, error) {
+	writer := &fileWriter{prefix: prefix, name: name, size: size, file: parquet.New(ExportColumns...)}
+	export := &Export{Bucket: exports.Bucket, Key: prefix, Format: ExportParquet}
+	err := scanExport(tableName, dynaClient, func(u User) error {
+		row, err := ExportRow(u)
+		if err != nil {
+			return err
+		}
+		export.Users++
+		return writer.write(row)
+	})
+	if err == nil && writer.file.Rows() > 0 {
+		err = writer.flush()
+	}
+	if err != nil {
+		for _, key := range writer.keys {
+			exports.S3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(exports.Bucket), Key: aws.String(key)})
+		}
+		return nil, err
+	}
+	export.Files = len(writer.keys)
+	return export, nil
+}
+
+// ExportRow returns the value of each of the ExportColumns for u.
+func ExportRow(u User) ([]*string, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, errors.New(ErrorFailedToExport)
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, errors.New(ErrorFailedToExport)
+	}
+	row := make([]*string, len(ExportColumns))
+	for i, column := range ExportColumns {
+		raw, ok := fields[column]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		value := string(raw)
+		if raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, errors.New(ErrorFailedToExport)
+			}
+		}
+		row[i] = &value
+	}
+	return row, nil
+}
+
+// fileWriter buffers rows until a file is full and writes it.
+type fileWriter struct {
+	prefix string
+	name   string
+	size   int
+	file   *parquet.File
+	keys   []string
+}
+
+func (w *fileWriter) write(row []*string) error {
+	if err := w.file.Add(row); err != nil {
+		return errors.New(ErrorFailedToExport)
+	}
+	if w.file.Size() >= w.size {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *fileWriter) flush() error {
+	key := fmt.Sprintf("%s%s-%05d.parquet", w.prefix, w.name, len(w.keys)+1)
+	_, err := exports.S3.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(exports.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(w.file.Bytes()),
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	})
+	if err != nil {
+		return errors.New(ErrorFailedToExport)
+	}
+	w.keys = append(w.keys, key)
+	w.file = parquet.New(ExportColumns...)
+	return nil
+}
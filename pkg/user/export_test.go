@@ -0,0 +1,243 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// multipartS3 keeps the parts of one multipart upload.
+type multipartS3 struct {
+	s3iface.S3API
+	parts     [][]byte
+	partErr   error
+	completed *s3.CompleteMultipartUploadInput
+	aborted   bool
+	// objects holds what PutObject wrote, by key
+	objects map[string][]byte
+	deleted []string
+}
+
+func (m *multipartS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if m.partErr != nil {
+		return nil, m.partErr
+	}
+	body, _ := io.ReadAll(input.Body)
+	if m.objects == nil {
+		m.objects = map[string][]byte{}
+	}
+	m.objects[*input.Key] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *multipartS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	m.deleted = append(m.deleted, *input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *multipartS3) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: aws.String("upload")}, nil
+}
+
+func (m *multipartS3) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	if m.partErr != nil {
+		return nil, m.partErr
+	}
+	body, _ := io.ReadAll(input.Body)
+	m.parts = append(m.parts, body)
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (m *multipartS3) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	m.completed = input
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *multipartS3) AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	m.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// pagedScanClient returns one user per page.
+type pagedScanClient struct {
+	testutil.MockDynamoDB
+	emails []string
+}
+
+func (c *pagedScanClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		for i, email := range c.emails {
+			if email == *input.ExclusiveStartKey["email"].S {
+				page = i + 1
+			}
+		}
+	}
+	output := &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{userItem(c.emails[page])}}
+	if page < len(c.emails)-1 {
+		output.LastEvaluatedKey = userItem(c.emails[page])
+	}
+	return output, nil
+}
+
+func TestExportUsers(t *testing.T) {
+	defer ConfigureExports(ExportConfig{})
+
+	t.Run("expect every page to be written as JSON lines in parts", func(t *testing.T) {
+		storage := &multipartS3{}
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: storage, PartSize: 60})
+		client := &pagedScanClient{emails: []string{"a@ecs.co.uk", "b@ecs.co.uk", "c@ecs.co.uk"}}
+
+		export, err := ExportUsers("", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if export.Users != 3 || export.Parts != 2 || len(storage.completed.MultipartUpload.Parts) != 2 {
+			t.Fatalf("Expected 3 users in 2 parts, got %+v", *export)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(bytes.Join(storage.parts, nil)), "\n"), "\n")
+		if len(lines) != 3 || !strings.Contains(lines[2], `"email":"c@ecs.co.uk"`) {
+			t.Errorf("Expected a line per user, got %q", lines)
+		}
+	})
+	t.Run("expect the upload to be aborted when a part fails", func(t *testing.T) {
+		storage := &multipartS3{partErr: errors.New("test error")}
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: storage})
+
+		_, err := ExportUsers("", "test", &pagedScanClient{emails: []string{"a@ecs.co.uk"}})
+		if err == nil || err.Error() != ErrorFailedToExport {
+			t.Errorf("Expected error %s, got %v", ErrorFailedToExport, err)
+		}
+		if !storage.aborted || storage.completed != nil {
+			t.Error("Expected the upload to be aborted")
+		}
+	})
+	t.Run("expect a Parquet export to be written in files under the day's partition", func(t *testing.T) {
+		storage := &multipartS3{}
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: storage, PartSize: 20})
+		client := &pagedScanClient{emails: []string{"a@ecs.co.uk", "b@ecs.co.uk", "c@ecs.co.uk"}}
+
+		export, err := ExportUsers(ExportParquet, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		prefix := "exports/parquet/date=" + time.Now().UTC().Format("2006-01-02") + "/"
+		if export.Users != 3 || export.Files != 3 || export.Key != prefix || len(storage.objects) != 3 {
+			t.Fatalf("Expected a file per user under %s, got %+v", prefix, *export)
+		}
+		for key, body := range storage.objects {
+			if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, ".parquet") || !bytes.HasPrefix(body, []byte("PAR1")) {
+				t.Errorf("Expected a Parquet file in the partition, got %s", key)
+			}
+		}
+	})
+	t.Run("expect the values of a Parquet row to be text", func(t *testing.T) {
+		row, err := ExportRow(User{Email: "a@ecs.co.uk", Tags: []string{"beta"}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if *row[0] != "a@ecs.co.uk" || *row[9] != `["beta"]` || row[3] != nil {
+			t.Errorf("Expected the email as text, tags as JSON and no phone, got %v", row)
+		}
+	})
+	t.Run("expect an unknown format to be refused", func(t *testing.T) {
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: &multipartS3{}})
+		if _, err := ExportUsers("csv", "test", &pagedScanClient{}); err == nil || err.Error() != ErrorUnknownExportFormat {
+			t.Errorf("Expected error %s, got %v", ErrorUnknownExportFormat, err)
+		}
+	})
+}
+
+// invoker keeps the payloads the function was invoked with.
+type invoker struct {
+	lambdaiface.LambdaAPI
+	inputs []*lambda.InvokeInput
+	err    error
+}
+
+func (i *invoker) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	i.inputs = append(i.inputs, input)
+	return &lambda.InvokeOutput{}, i.err
+}
+
+func TestExportJobs(t *testing.T) {
+	defer ConfigureExports(ExportConfig{})
+	newClient := func() *testutil.MemoryDynamoDB {
+		client := testutil.NewMemoryDynamoDB(
+			testutil.Table{Name: "test", PartitionKey: "email"},
+			testutil.Table{Name: ExportTableName("test"), PartitionKey: "id"},
+		)
+		client.Put("test", userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk"))
+		return client
+	}
+
+	t.Run("expect an export to be started in the background and completed by its event", func(t *testing.T) {
+		storage, functions := &multipartS3{}, &invoker{}
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: storage, Lambda: functions, FunctionName: "users"})
+		client := newClient()
+
+		job, err := StartExport("", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if job.Status != ExportStatusPending || job.Format != ExportJSON || len(storage.parts) != 0 {
+			t.Errorf("Expected a pending JSON export with nothing written yet, got %+v", *job)
+		}
+		if len(functions.inputs) != 1 || *functions.inputs[0].InvocationType != lambda.InvocationTypeEvent || *functions.inputs[0].FunctionName != "users" {
+			t.Fatalf("Expected the function to be invoked asynchronously, got %v", functions.inputs)
+		}
+
+		request, ok := ParseExportEvent(functions.inputs[0].Payload)
+		if !ok || request.ID != job.ID || request.Table != "test" {
+			t.Fatalf("Expected the event to name the job and table, got %+v", request)
+		}
+		if _, err := RunExport(request, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		done, _ := FetchExport(job.ID, "test", client)
+		if done.Status != ExportStatusCompleted || done.Export == nil || done.Export.Users != 2 {
+			t.Errorf("Expected the export of both users to be recorded, got %+v", *done)
+		}
+		if again, _ := RunExport(request, client); again.Export.Key != done.Export.Key || len(storage.completed.MultipartUpload.Parts) != 1 {
+			t.Errorf("Expected an event delivered again not to export again, got %+v", *again)
+		}
+	})
+	t.Run("expect a failed export to be recorded with its error", func(t *testing.T) {
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: &multipartS3{partErr: errors.New("test error")}, Lambda: &invoker{}, FunctionName: "users"})
+		client := newClient()
+		job, _ := StartExport("", "test", client)
+		RunExport(ExportRequest{ID: job.ID, Table: "test"}, client)
+		if failed, _ := FetchExport(job.ID, "test", client); failed.Status != ExportStatusFailed || failed.Error != ErrorFailedToExport {
+			t.Errorf("Expected the export to have failed, got %+v", *failed)
+		}
+	})
+	t.Run("expect an export that cannot be started to be refused", func(t *testing.T) {
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: &multipartS3{}, Lambda: &invoker{err: errors.New("test error")}, FunctionName: "users"})
+		client := newClient()
+		if _, err := StartExport("", "test", client); err == nil || err.Error() != ErrorFailedToExport {
+			t.Errorf("Expected error %s, got %v", ErrorFailedToExport, err)
+		}
+		if jobs := client.Items(ExportTableName("test")); len(jobs) != 1 || *jobs[0]["status"].S != ExportStatusFailed {
+			t.Errorf("Expected the job to be recorded as failed, got %v", jobs)
+		}
+		ConfigureExports(ExportConfig{Bucket: "exports", S3: &multipartS3{}})
+		if _, err := StartExport("", "test", client); err == nil || err.Error() != ErrorExportsNotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorExportsNotConfigured, err)
+		}
+		if _, err := FetchExport("missing", "test", client); err == nil || err.Error() != ErrorExportNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorExportNotFound, err)
+		}
+	})
+}
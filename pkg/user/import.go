@@ -0,0 +1,215 @@
+package user
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// MaxImportRows is the most rows one import reads.
+	MaxImportRows = 5000
+	// MaxRequestImportRows is the most rows an import sent as the body of a
+	// request reads, few enough to be created well within API Gateway's 29
+	// seconds. Larger imports are uploaded to the import bucket instead.
+	MaxRequestImportRows = 100
+
+	ImportOutcomeCreated = "created"
+	ImportOutcomeFailed  = "failed"
+	ImportOutcomeSkipped = "skipped"
+)
+
+var (
+	ErrorFailedToReadImport   = "failed to read import"
+	ErrorFailedToStartImport  = "failed to start import"
+	ErrorImportNotFound       = "import not found"
+	ErrorImportsNotConfigured = "imports from S3 are not configured"
+	ErrorInvalidCSVHeader     = "invalid CSV header"
+	ErrorInvalidCSVRow        = "invalid CSV row"
+	ErrorInvalidJSONRow       = "invalid JSON row"
+)
+
+// importColumns maps the CSV columns an import may have to the user field
+// they set. Tags are separated by semicolons, and empty cells are left unset.
+var importColumns = map[string]func(u *User, value string){
+	"email":              func(u *User, v string) { u.Email = v },
+	"firstName":          func(u *User, v string) { u.FirstName = v },
+	"lastName":           func(u *User, v string) { u.LastName = v },
+	"phone":              func(u *User, v string) { u.Phone = Optional(v) },
+	"dateOfBirth":        func(u *User, v string) { u.DateOfBirth = Optional(v) },
+	"jobTitle":           func(u *User, v string) { u.JobTitle = Optional(v) },
+	"company":            func(u *User, v string) { u.Company = Optional(v) },
+	"tags":               func(u *User, v string) { u.Tags = normaliseTags(strings.Split(v, ";")) },
+	"address.line1":      func(u *User, v string) { address(u).Line1 = v },
+	"address.line2":      func(u *User, v string) { address(u).Line2 = v },
+	"address.city":       func(u *User, v string) { address(u).City = v },
+	"address.region":     func(u *User, v string) { address(u).Region = v },
+	"address.postalCode": func(u *User, v string) { address(u).PostalCode = v },
+	"address.country":    func(u *User, v string) { address(u).Country = v },
+}
+
+// address returns the user's address, adding one for a column to set.
+func address(u *User) *Address {
+	if u.Address == nil {
+		u.Address = &Address{}
+	}
+	return u.Address
+}
+
+// ImportRow reports a row that was not created. Row counts from 1 for the
+// first row after the header.
+type ImportRow struct {
+	Row     int                    `json:"row"`
+	Email   string                 `json:"email,omitempty"`
+	Outcome string                 `json:"outcome"`
+	Error   string                 `json:"error,omitempty"`
+	Code    string                 `json:"code,omitempty"`
+	Fields  validators.FieldErrors `json:"fields,omitempty"`
+}
+
+type ImportReport struct {
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+	// Truncated is set when the import had more rows than it reads
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ImportUsers creates a user from every row of the CSV read from r, one row
+// at a time so large imports are never held in memory. The header names the
+// columns, which must include email, firstName and lastName. Rows whose user
+// already exists are skipped, and rows that cannot be created are failed
+// with their reason; neither stops the import.
+func ImportUsers(r io.Reader, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ImportReport, error) {
+	return ImportUsersAs(r, audit.Actor{}, MaxImportRows, tableName, dynaClient)
+}
+
+// ImportUsersAs imports the CSV read from r as ImportUsers does, recording
+// actor as who created each user and reading up to maxRows rows.
+func ImportUsersAs(r io.Reader, actor audit.Actor, maxRows int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ImportReport, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New(ErrorInvalidCSVHeader)
+	}
+	setters := make([]func(u *User, value string), len(header))
+	seen := map[string]bool{}
+	for i, column := range header {
+		// Spreadsheets often save CSV with a byte order mark
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		setter, ok := importColumns[column]
+		if !ok || seen[column] {
+			return nil, errors.New(ErrorInvalidCSVHeader)
+		}
+		seen[column] = true
+		setters[i] = setter
+	}
+	if !seen["email"] || !seen["firstName"] || !seen["lastName"] {
+		return nil, errors.New(ErrorInvalidCSVHeader)
+	}
+
+	report := &ImportReport{Rows: []ImportRow{}}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		if row > maxRows {
+			report.Truncated = true
+			return report, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, errors.New(ErrorFailedToReadImport)
+			}
+			report.fail(ImportRow{Row: row, Error: ErrorInvalidCSVRow})
+			continue
+		}
+
+		var u User
+		for i, value := range record {
+			if value = strings.TrimSpace(value); value != "" {
+				setters[i](&u, value)
+			}
+		}
+		report.create(u, row, actor, tableName, dynaClient)
+	}
+}
+
+// ImportJSONLines creates a user from every line of the JSON Lines read
+// from r, one line at a time, as ImportUsers does for CSV. Each line is a
+// user as the body creating one would be, and blank lines are ignored.
+func ImportJSONLines(r io.Reader, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ImportReport, error) {
+	return ImportJSONLinesAs(r, audit.Actor{}, MaxImportRows, tableName, dynaClient)
+}
+
+// ImportJSONLinesAs imports the JSON Lines read from r as ImportJSONLines
+// does, recording actor as who created each user and reading up to maxRows
+// rows.
+func ImportJSONLinesAs(r io.Reader, actor audit.Actor, maxRows int, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ImportReport, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), jsonbody.MaxBytes)
+	report := &ImportReport{Rows: []ImportRow{}}
+	row := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		row++
+		if row > maxRows {
+			report.Truncated = true
+			return report, nil
+		}
+		var u User
+		if err := jsonbody.Decode(line, &u); err != nil {
+			report.fail(ImportRow{Row: row, Error: ErrorInvalidJSONRow})
+			continue
+		}
+		report.create(u, row, actor, tableName, dynaClient)
+	}
+	// Lines too long to be a user end the import too
+	if scanner.Err() != nil {
+		return nil, errors.New(ErrorFailedToReadImport)
+	}
+	return report, nil
+}
+
+// create creates the user read from row as actor. A user that already
+// exists is skipped, and one that cannot be created is failed with its
+// reason.
+func (r *ImportReport) create(u User, row int, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	_, err := CreateAs(u, actor, tableName, dynaClient)
+	switch {
+	case err == nil:
+		r.Created++
+	case err.Error() == ErrorUserAlreadyExists:
+		r.Skipped++
+		r.Rows = append(r.Rows, ImportRow{Row: row, Email: u.Email, Outcome: ImportOutcomeSkipped, Error: err.Error()})
+	default:
+		failed := ImportRow{Row: row, Email: u.Email, Error: err.Error()}
+		var fieldErrs validators.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			failed.Error = ErrorInvalidUserData
+			failed.Fields = fieldErrs
+		}
+		r.fail(failed)
+	}
+}
+
+func (r *ImportReport) fail(row ImportRow) {
+	row.Outcome = ImportOutcomeFailed
+	r.Failed++
+	r.Rows = append(r.Rows, row)
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+)
+
+func TestImportUsers(t *testing.T) {
+	t.Run("expect rows to be created, skipped or failed with a reason", func(t *testing.T) {
+		client := newEmailTableClient()
+		client.table("test")["al@ecs.co.uk"] = userItem("al@ecs.co.uk")
+		csv := strings.Join([]string{
+			"email,firstName,lastName,tags,address.city",
+			"alan.oliver@ecs.co.uk,Alan,Oliver,Beta;plan:pro,",
+			"al@ecs.co.uk,Al,Oliver,,",
+			"not-an-email,Al,Oliver,,",
+			"too,few",
+		}, "\n")
+
+		report, err := ImportUsers(strings.NewReader(csv), "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Created != 1 || report.Skipped != 1 || report.Failed != 2 {
+			t.Fatalf("Expected 1 created, 1 skipped and 2 failed, got %+v", *report)
+		}
+		if report.Rows[0].Row != 2 || report.Rows[0].Outcome != ImportOutcomeSkipped {
+			t.Errorf("Expected row 2 to be skipped, got %+v", report.Rows[0])
+		}
+		if report.Rows[1].Error != ErrorInvalidUserData || report.Rows[1].Fields[0].Field != "email" {
+			t.Errorf("Expected row 3 to fail on its email, got %+v", report.Rows[1])
+		}
+		if report.Rows[2].Error != ErrorInvalidCSVRow {
+			t.Errorf("Expected row 4 to be an invalid row, got %+v", report.Rows[2])
+		}
+		created, _ := FetchUserWithOptions("alan.oliver@ecs.co.uk", "test", client, FetchOptions{ConsistentRead: true})
+		if len(created.Tags) != 2 || created.Tags[0] != "beta" || created.Address != nil {
+			t.Errorf("Expected normalised tags and no address, got %+v", *created)
+		}
+	})
+	t.Run("expect imported users to record who created them", func(t *testing.T) {
+		client := newEmailTableClient()
+		actor := audit.Actor{Kind: audit.ActorAPIKey, ID: "importer"}
+		if _, err := ImportUsersAs(strings.NewReader("email,firstName,lastName\nalan.oliver@ecs.co.uk,Alan,Oliver"), actor, MaxImportRows, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		created, _ := FetchUserWithOptions("alan.oliver@ecs.co.uk", "test", client, FetchOptions{ConsistentRead: true})
+		if created.CreatedBy != actor.String() {
+			t.Errorf("Expected the user to be created by %s, got %+v", actor.String(), *created)
+		}
+	})
+	t.Run("expect rows past the most to be read to truncate the import", func(t *testing.T) {
+		csv := "email,firstName,lastName\nalan.oliver@ecs.co.uk,Alan,Oliver\nal@ecs.co.uk,Al,Oliver"
+		report, err := ImportUsersAs(strings.NewReader(csv), audit.Actor{}, 1, "test", newEmailTableClient())
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if report.Created != 1 || !report.Truncated {
+			t.Errorf("Expected 1 created and the import truncated, got %+v", *report)
+		}
+	})
+	t.Run("expect a header without the required columns to be rejected", func(t *testing.T) {
+		_, err := ImportUsers(strings.NewReader("email,nickname\nalan.oliver@ecs.co.uk,Al"), "test", newEmailTableClient())
+		if err == nil || err.Error() != ErrorInvalidCSVHeader {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidCSVHeader, err)
+		}
+	})
+}
+
+func TestImportJSONLines(t *testing.T) {
+	client := newEmailTableClient()
+	client.table("test")["al@ecs.co.uk"] = userItem("al@ecs.co.uk")
+	lines := strings.Join([]string{
+		`{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "tags": ["Beta"]}`,
+		``,
+		`{"email": "al@ecs.co.uk", "firstName": "Al", "lastName": "Oliver"}`,
+		`{"email": "alan.shearer@ecs.co.uk", "nickname": "Al"}`,
+		`{"email": "alan`,
+	}, "\n")
+
+	report, err := ImportJSONLines(strings.NewReader(lines), "test", client)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if report.Created != 1 || report.Skipped != 1 || report.Failed != 2 {
+		t.Fatalf("Expected 1 created, 1 skipped and 2 failed, got %+v", *report)
+	}
+	if report.Rows[0].Row != 2 || report.Rows[1].Error != ErrorInvalidJSONRow || report.Rows[2].Row != 4 {
+		t.Errorf("Expected rows 3 and 4 to be invalid rows after row 2 was skipped, got %+v", report.Rows)
+	}
+}
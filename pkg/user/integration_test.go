@@ -0,0 +1,150 @@
+//go:build integration
+
+package user_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/internal/testsupport"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testsupport.Run(m))
+}
+
+func TestIntegrationCRUD(t *testing.T) {
+	client := testsupport.Client(t)
+	table := testsupport.CreateTables(t, client)
+	email := "alan.oliver@ecs.co.uk"
+
+	t.Run("expect a created user to read back whole", func(t *testing.T) {
+		_, err := user.Create(user.User{
+			Email:     email,
+			FirstName: "Alan",
+			LastName:  "Oliver",
+			Phone:     aws.String("+44 7700 900123"),
+			Address:   &user.Address{Line1: "1 High Street", City: "London", PostalCode: "SW1A 1AA", Country: "gb"},
+			Metadata:  map[string]string{"crmId": "12345"},
+			Tags:      []string{"beta", "plan:pro"},
+		}, table, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u, err := user.FetchUserWithOptions(email, table, client, user.FetchOptions{ConsistentRead: true})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(u.Phone) != "+447700900123" || u.Address == nil || u.Address.Country != "GB" || u.Metadata["crmId"] != "12345" || len(u.Tags) != 2 {
+			t.Errorf("Expected every field to read back, got %+v", *u)
+		}
+		if u.Status != user.StatusActive || u.CreatedAt == "" {
+			t.Errorf("Expected the user to start active with createdAt, got %+v", *u)
+		}
+		if _, err := user.Create(user.User{Email: email, FirstName: "Alan", LastName: "Oliver"}, table, client); err == nil || err.Error() != user.ErrorUserAlreadyExists {
+			t.Errorf("Expected %q, got %v", user.ErrorUserAlreadyExists, err)
+		}
+	})
+
+	t.Run("expect an update to keep the fields it leaves out", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Al", "lastName": "Oliver", "company": "ECS"}`}
+		if _, err := user.UpdateUser(req, table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u, _ := user.FetchUserWithOptions(email, table, client, user.FetchOptions{ConsistentRead: true})
+		if u.FirstName != "Al" || aws.StringValue(u.Company) != "ECS" || aws.StringValue(u.Phone) != "+447700900123" || len(u.Tags) != 2 {
+			t.Errorf("Expected the update to merge onto the stored user, got %+v", *u)
+		}
+	})
+
+	t.Run("expect tags to be added and removed as a string set", func(t *testing.T) {
+		if _, err := user.AddTags(email, []string{"trial", "beta"}, table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u, err := user.RemoveTags(email, []string{"plan:pro"}, table, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(u.Tags) != 2 {
+			t.Errorf("Expected beta and trial, got %v", u.Tags)
+		}
+	})
+
+	t.Run("expect the list to filter by tag and inactivity", func(t *testing.T) {
+		if _, err := user.Create(user.User{Email: "al@ecs.co.uk", FirstName: "Al", LastName: "Oliver"}, table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		all, err := user.ScanUsersWithOptions(table, client, user.FetchOptions{ConsistentRead: true})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(all.Users) != 2 {
+			t.Errorf("Expected 2 users, got %d", len(all.Users))
+		}
+		tagged, _ := user.ScanUsersWithOptions(table, client, user.FetchOptions{ConsistentRead: true, Tag: "trial"})
+		if len(tagged.Users) != 1 || tagged.Users[0].Email != email {
+			t.Errorf("Expected only the tagged user, got %v", tagged.Users)
+		}
+		if err := user.RecordLogin("al@ecs.co.uk", table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		inactive, _ := user.ScanUsersWithOptions(table, client, user.FetchOptions{ConsistentRead: true, InactiveSince: time.Now().Add(-time.Hour)})
+		if len(inactive.Users) != 1 || inactive.Users[0].Email != email {
+			t.Errorf("Expected only the user never seen, got %v", inactive.Users)
+		}
+	})
+
+	t.Run("expect preferences to save and read back", func(t *testing.T) {
+		saved, err := user.SavePreferences(email, user.Preferences{Theme: "dark", Language: "fr"}, table, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		read, err := user.FetchPreferences(email, table, client)
+		if err != nil || read.Theme != saved.Theme || read.Language != "fr" {
+			t.Errorf("Expected the saved preferences, got %+v %v", read, err)
+		}
+	})
+
+	t.Run("expect suspending to revoke sessions found through the email index", func(t *testing.T) {
+		if _, err := session.Create(email, session.Device{UserAgent: "test"}, session.Table(table), client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := user.SetStatus(email, user.StatusSuspended, table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		sessions, err := session.FetchSessions(email, session.Table(table), client)
+		if err != nil || len(sessions) != 0 {
+			t.Errorf("Expected no sessions, got %v %v", sessions, err)
+		}
+	})
+
+	t.Run("expect a deleted user to read as empty", func(t *testing.T) {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"email": email}}
+		if err := user.DeleteUser(req, table, client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		u, err := user.FetchUserWithOptions(email, table, client, user.FetchOptions{ConsistentRead: true})
+		if err != nil || u.Email != "" {
+			t.Errorf("Expected no user, got %+v %v", u, err)
+		}
+		result, err := user.DeleteUsers(user.BulkDelete{Emails: []string{"al@ecs.co.uk", email}}, table, client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		for _, outcome := range result.Outcomes {
+			expected := user.DeleteOutcomeDeleted
+			if outcome.Email == email {
+				expected = user.DeleteOutcomeNotFound
+			}
+			if outcome.Outcome != expected {
+				t.Errorf("Expected %s for %s, got %s", expected, outcome.Email, outcome.Outcome)
+			}
+		}
+	})
+}
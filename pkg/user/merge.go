@@ -0,0 +1,230 @@
+package user
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorFailedToMergeUsers = "failed to merge users"
+	ErrorMergeIncomplete    = "merge incomplete, retry to resume"
+	ErrorMergeSameUser      = "cannot merge a user into themselves"
+)
+
+// MergeUsers consolidates the user at from into the user at into, for
+// operators resolving duplicates. In one transaction into is written with
+// what only from knew about them, from is replaced by a tombstone pointing
+// at into, from's preferences and credentials are kept where into has none
+// and deleted otherwise, from's history is copied to into and, when the
+// outbox is enabled, a user.merged message is written. History too long to
+// fit is copied once the transaction has committed; if that fails, merging
+// again resumes it. Sessions under from are revoked.
+func MergeUsers(from string, into string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return MergeUsersAs(from, into, audit.Actor{}, tableName, dynaClient)
+}
+
+// MergeUsersAs merges from into into as MergeUsers does, recording actor as
+// who last changed the merged user.
+func MergeUsersAs(from string, into string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	if from == into {
+		return nil, errors.New(ErrorMergeSameUser)
+	}
+	item, err := fetchItem(userKey(from), tableName, dynaClient, ErrorFailedToFetchRecord)
+	if err != nil {
+		return nil, err
+	}
+	history, err := audit.FetchEntries(from, audit.TableName(tableName), dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if stringAttribute(item, "mergedInto") == into {
+		if err := copyHistory(history, into, ErrorMergeIncomplete, tableName, dynaClient); err != nil {
+			return nil, err
+		}
+		return FetchUserWithOptions(into, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	}
+	target, err := fetchItem(userKey(into), tableName, dynaClient, ErrorFailedToFetchRecord)
+	if err != nil {
+		return nil, err
+	}
+	if len(item) == 0 || len(target) == 0 || stringAttribute(item, "movedTo") != "" || stringAttribute(target, "movedTo") != "" {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	var source, kept User
+	if dynamodbattribute.UnmarshalMap(item, &source) != nil || dynamodbattribute.UnmarshalMap(target, &kept) != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	merged := kept.absorb(source)
+	merged.UpdatedBy = actor.String()
+	if err := merged.validate(); err != nil {
+		return nil, err
+	}
+
+	writes, err := mergeWrites(merged, from, into, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	copied := history
+	if room := maxTransactItems - len(writes); len(copied) > room {
+		copied = copied[:room]
+	}
+	for _, entry := range copied {
+		entry.Subject = into
+		av, err := dynamodbattribute.MarshalMap(entry)
+		if err != nil {
+			return nil, errors.New(audit.ErrorFailedToMarshalEntry)
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			Item:      av,
+			TableName: aws.String(audit.TableName(tableName)),
+		}})
+	}
+
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	cache.invalidate(tableName, from)
+	cache.invalidate(tableName, into)
+	if err != nil {
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 {
+			// Either user was deleted, moved or merged since it was read
+			if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" ||
+				aws.StringValue(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorUserNotFound)
+			}
+		}
+		return nil, store.Error(err, ErrorFailedToMergeUsers)
+	}
+	recordAudit(into, audit.ActionMerged, map[string]string{"mergedFrom": from}, actor, tableName, dynaClient)
+	if err := session.RevokeAll(from, session.Table(tableName), dynaClient); err != nil {
+		return nil, err
+	}
+	if err := copyHistory(history[len(copied):], into, ErrorMergeIncomplete, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return FetchUserWithOptions(into, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+}
+
+// mergeWrites returns the writes that merge from into merged, stored at into.
+// The first two are merged and from's tombstone, whose conditions
+// MergeUsers reports on.
+func mergeWrites(merged User, from string, into string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]*dynamodb.TransactWriteItem, error) {
+	av, err := dynamodbattribute.MarshalMap(merged)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	live := aws.String("attribute_exists(email) AND attribute_not_exists(movedTo)")
+	writes := []*dynamodb.TransactWriteItem{
+		{Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(tableName),
+			ConditionExpression: live,
+		}},
+		{Put: &dynamodb.Put{
+			Item: map[string]*dynamodb.AttributeValue{
+				"email":      {S: aws.String(from)},
+				"movedTo":    {S: aws.String(into)},
+				"mergedInto": {S: aws.String(into)},
+				"movedAt":    {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			},
+			TableName:           aws.String(tableName),
+			ConditionExpression: live,
+		}},
+	}
+
+	preferences, err := fetchItem(userKey(from), PreferencesTableName(tableName), dynaClient, ErrorFailedToFetchPreferences)
+	if err != nil {
+		return nil, err
+	}
+	if len(preferences) > 0 {
+		existing, err := fetchItem(userKey(into), PreferencesTableName(tableName), dynaClient, ErrorFailedToFetchPreferences)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) == 0 {
+			preferences["email"] = &dynamodb.AttributeValue{S: aws.String(into)}
+			writes = append(writes, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				Item:      preferences,
+				TableName: aws.String(PreferencesTableName(tableName)),
+			}})
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:       userKey(from),
+			TableName: aws.String(PreferencesTableName(tableName)),
+		}})
+	}
+
+	creds, err := credentials.MergeItems(from, into, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	writes = append(writes, creds...)
+
+	if outbox.Enabled() {
+		m, err := outbox.New(outbox.EventUserMerged, into, map[string]string{"email": into, "mergedFrom": from})
+		if err != nil {
+			return nil, errors.New(ErrorCouldNotMarshalItem)
+		}
+		put, err := outbox.Put(m, tableName)
+		if err != nil {
+			return nil, errors.New(ErrorCouldNotMarshalItem)
+		}
+		writes = append(writes, put)
+	}
+	return writes, nil
+}
+
+// absorb returns the user with what only other knows about them added: the
+// profile fields and avatar u leaves empty, the metadata keys and tags it
+// lacks, the earlier of their creations and the later of their logins and
+// activity. Everything else, including the status, is u's.
+func (u User) absorb(other User) User {
+	for field, value := range map[**string]*string{
+		&u.Phone:       other.Phone,
+		&u.DateOfBirth: other.DateOfBirth,
+		&u.JobTitle:    other.JobTitle,
+		&u.Company:     other.Company,
+	} {
+		if *field == nil {
+			*field = value
+		}
+	}
+	if u.Avatar == "" {
+		u.Avatar = other.Avatar
+	}
+	if u.Address == nil {
+		u.Address = other.Address
+	}
+	if len(other.Metadata) > 0 {
+		metadata := make(map[string]string, len(u.Metadata)+len(other.Metadata))
+		for key, value := range other.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range u.Metadata {
+			metadata[key] = value
+		}
+		u.Metadata = metadata
+	}
+	// Tags are deduplicated when the user is validated
+	u.Tags = append(append([]string{}, u.Tags...), other.Tags...)
+	if other.CreatedAt != "" && (u.CreatedAt == "" || other.CreatedAt < u.CreatedAt) {
+		u.CreatedAt = other.CreatedAt
+	}
+	if other.LastLoginAt > u.LastLoginAt {
+		u.LastLoginAt = other.LastLoginAt
+	}
+	if other.LastSeenAt > u.LastSeenAt {
+		u.LastSeenAt = other.LastSeenAt
+	}
+	return u
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func seedDuplicateUser(client *emailTableClient) {
+	client.table("test")["alan@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{
+		"email":     {S: aws.String("alan@ecs.co.uk")},
+		"firstName": {S: aws.String("Alan")},
+		"lastName":  {S: aws.String("Oliver")},
+		"company":   {S: aws.String("ECS")},
+		"tags":      {SS: aws.StringSlice([]string{"vip"})},
+		"createdAt": {S: aws.String("2024-01-01T00:00:00Z")},
+	}
+}
+
+func TestMergeUsers(t *testing.T) {
+	t.Run("expect the duplicate folded into the user it is merged into", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		seedDuplicateUser(client)
+		client.table("test")["alan.oliver@ecs.co.uk"]["phone"] = &dynamodb.AttributeValue{S: aws.String("+441234567890")}
+		client.table("test")["alan.oliver@ecs.co.uk"]["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"beta"})}
+		client.table("test")["alan.oliver@ecs.co.uk"]["createdAt"] = &dynamodb.AttributeValue{S: aws.String("2023-01-01T00:00:00Z")}
+
+		merged, err := MergeUsers("alan.oliver@ecs.co.uk", "alan@ecs.co.uk", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if merged.FirstName != "Alan" || aws.StringValue(merged.Company) != "ECS" || aws.StringValue(merged.Phone) != "+441234567890" {
+			t.Errorf("Expected the kept user's fields with the duplicate's filling the gaps, got %+v", *merged)
+		}
+		if !reflect.DeepEqual(merged.Tags, []string{"beta", "vip"}) || merged.CreatedAt != "2023-01-01T00:00:00Z" {
+			t.Errorf("Expected the tags of both and the earlier creation, got %v and %q", merged.Tags, merged.CreatedAt)
+		}
+		if tombstone := client.table("test")["alan.oliver@ecs.co.uk"]; stringAttribute(tombstone, "movedTo") != "alan@ecs.co.uk" {
+			t.Errorf("Expected a tombstone pointing at the merged user, got %v", tombstone)
+		}
+		if _, ok := client.table(PreferencesTableName("test"))["alan@ecs.co.uk"]; !ok {
+			t.Error("Expected the preferences to move to a user without any")
+		}
+		history, _ := audit.FetchEntries("alan@ecs.co.uk", audit.TableName("test"), client)
+		actions := map[string]bool{}
+		for _, entry := range history {
+			actions[entry.Action] = true
+		}
+		if !actions[audit.ActionCreated] || !actions[audit.ActionMerged] {
+			t.Errorf("Expected the duplicate's history and the merge, got %v", history)
+		}
+	})
+
+	t.Run("expect merging again to return the merged user", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		seedDuplicateUser(client)
+		if _, err := MergeUsers("alan.oliver@ecs.co.uk", "alan@ecs.co.uk", "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		merged, err := MergeUsers("alan.oliver@ecs.co.uk", "alan@ecs.co.uk", "test", client)
+		if err != nil || merged.Email != "alan@ecs.co.uk" {
+			t.Errorf("Expected the merged user, got %v and %v", merged, err)
+		}
+	})
+
+	t.Run("expect a missing user to fail", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		if _, err := MergeUsers("alan.oliver@ecs.co.uk", "alan@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected %q, got %v", ErrorUserNotFound, err)
+		}
+		if _, err := MergeUsers("alan@ecs.co.uk", "alan@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorMergeSameUser {
+			t.Errorf("Expected %q, got %v", ErrorMergeSameUser, err)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorFailedToMoveUser = "failed to move user"
+
+// MoveUser rekeys the user at from, with their preferences, credentials and
+// history, to to without leaving a tombstone, for operators correcting an
+// email rather than users changing theirs. History is copied before the move,
+// so a failed move can be retried, and sessions under from are revoked.
+func MoveUser(from string, to string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	item, err := fetchItem(userKey(from), tableName, dynaClient, ErrorFailedToFetchRecord)
+	if err != nil {
+		return nil, err
+	}
+	if len(item) == 0 || stringAttribute(item, "movedTo") != "" {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	existing, err := fetchItem(userKey(to), tableName, dynaClient, ErrorFailedToFetchRecord)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, errors.New(ErrorUserAlreadyExists)
+	}
+	history, err := audit.FetchEntries(from, audit.TableName(tableName), dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		entry.Subject = to
+		if err := audit.Put(entry, audit.TableName(tableName), dynaClient); err != nil {
+			return nil, errors.New(ErrorFailedToMoveUser)
+		}
+	}
+
+	writes, err := moveWrites(item, from, to, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	remove := &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+		Key:                 userKey(from),
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_exists(email)"),
+	}}
+	writes = append([]*dynamodb.TransactWriteItem{writes[0], remove}, writes[1:]...)
+	var moved User
+	if err := dynamodbattribute.UnmarshalMap(writes[0].Put.Item, &moved); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+	}
+	left, err := announce(outbox.EventUserDeleted, from, map[string]string{"email": from}, tableName)
+	if err != nil {
+		return nil, err
+	}
+	arrived, err := announce(outbox.EventUserCreated, to, moved, tableName)
+	if err != nil {
+		return nil, err
+	}
+	writes = append(append(writes, left...), arrived...)
+
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	cache.invalidate(tableName, from)
+	cache.invalidate(tableName, to)
+	if err != nil {
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 {
+			if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorUserAlreadyExists)
+			}
+			if aws.StringValue(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+				return nil, errors.New(ErrorUserNotFound)
+			}
+		}
+		return nil, store.Error(err, ErrorFailedToMoveUser)
+	}
+	recordAudit(to, audit.ActionUpdated, map[string]string{"previousEmail": from}, audit.Actor{}, tableName, dynaClient)
+	if err := session.RevokeAll(from, session.Table(tableName), dynaClient); err != nil {
+		return nil, err
+	}
+	return FetchUserWithOptions(to, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+}
@@ -0,0 +1,40 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestMoveUser(t *testing.T) {
+	t.Run("expect the user and preferences to move without a tombstone", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		moved, err := MoveUser("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if moved.Email != "al@ecs.co.uk" || moved.FirstName != "Al" {
+			t.Errorf("Expected the user under the new email, got %+v", *moved)
+		}
+		if _, ok := client.table("test")["alan.oliver@ecs.co.uk"]; ok {
+			t.Error("Expected nothing to be left under the old email")
+		}
+		if _, ok := client.table(PreferencesTableName("test"))["al@ecs.co.uk"]; !ok {
+			t.Error("Expected the preferences to move")
+		}
+	})
+
+	t.Run("expect a move onto an existing user to fail", func(t *testing.T) {
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		client.table("test")["al@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{"email": {S: aws.String("al@ecs.co.uk")}}
+		if _, err := MoveUser("alan.oliver@ecs.co.uk", "al@ecs.co.uk", "test", client); err == nil || err.Error() != ErrorUserAlreadyExists {
+			t.Errorf("Expected %q, got %v", ErrorUserAlreadyExists, err)
+		}
+		if _, ok := client.table("test")["alan.oliver@ecs.co.uk"]; !ok {
+			t.Error("Expected the user to stay where they were")
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package user
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+)
+
+// Optional returns s as an optional profile field: nil when s is blank, so
+// the field is left out of the user, or a pointer to s trimmed.
+func Optional(s string) *string {
+	return optional(&s, strings.TrimSpace)
+}
+
+// optional normalises the optional field p, returning nil when it is nil or
+// blank. An empty field is never stored, so a user either has a field or
+// does not.
+func optional(p *string, normalise func(string) string) *string {
+	if p == nil {
+		return nil
+	}
+	s := normalise(*p)
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return &s
+}
+
+// formatPhone gives phone in E.164 form when it can be parsed, leaving it
+// for the phone rule to report otherwise.
+func formatPhone(phone string) string {
+	if formatted, ok := validators.FormatPhone(phone); ok {
+		return formatted
+	}
+	return strings.TrimSpace(phone)
+}
+
+// presentFields returns the top-level fields of the JSON object body,
+// lowercased, as they are matched regardless of case when decoded. A field
+// is present even when it is null, which decodes to the same nil pointer as
+// a field left out, so this is how an update tells the two apart.
+func presentFields(body string) map[string]bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return nil
+	}
+	present := make(map[string]bool, len(fields))
+	for field := range fields {
+		present[strings.ToLower(field)] = true
+	}
+	return present
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	ErrorConditionFailed       = "condition not met"
+	ErrorConditionNotSupported = "conditions are not supported for event sourced users"
+)
+
+// Option adjusts one call that reads or writes users, such as FetchUser or
+// UpdateUser. Calls take options last, so one can be added to a call without
+// changing any other, and ignore options they have no use for, as
+// FetchUser ignores a limit.
+type Option func(*Options)
+
+// Options are the adjustments a call's options make.
+type Options struct {
+	FetchOptions
+	// Condition must hold for the stored user for a write to be made. It
+	// names attributes by placeholders such as #status and values by those
+	// given in ConditionValues.
+	Condition       string
+	ConditionValues map[string]*dynamodb.AttributeValue
+}
+
+// WithConsistentRead reads users strongly consistently, bypassing the cache.
+func WithConsistentRead() Option {
+	return func(o *Options) {
+		o.ConsistentRead = true
+	}
+}
+
+// WithLimit stops a list after limit users.
+func WithLimit(limit int) Option {
+	return func(o *Options) {
+		o.Limit = limit
+	}
+}
+
+// WithProjection reads only the fields named, by their JSON name, leaving
+// the rest empty.
+func WithProjection(fields ...string) Option {
+	return func(o *Options) {
+		o.Fields = fields
+	}
+}
+
+// WithCondition makes a write only when expression holds for the stored
+// user, failing with ErrorConditionFailed otherwise, e.g.
+//
+//	WithCondition("#status = :status", map[string]*dynamodb.AttributeValue{":status": {S: aws.String(StatusActive)}})
+//
+// Conditions given more than once must all hold. They cannot be checked
+// against event sourced users, whose writes fail with
+// ErrorConditionNotSupported instead.
+func WithCondition(expression string, values map[string]*dynamodb.AttributeValue) Option {
+	return func(o *Options) {
+		if o.Condition != "" {
+			o.Condition = "(" + o.Condition + ") AND (" + expression + ")"
+		} else {
+			o.Condition = expression
+		}
+		if len(values) > 0 && o.ConditionValues == nil {
+			o.ConditionValues = map[string]*dynamodb.AttributeValue{}
+		}
+		for placeholder, value := range values {
+			o.ConditionValues[placeholder] = value
+		}
+	}
+}
+
+func applyOptions(options []Option) Options {
+	var o Options
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// checkable fails when the options carry a condition that cannot be checked.
+func (o Options) checkable() error {
+	if o.Condition != "" && eventSourcing.Enabled {
+		return errors.New(ErrorConditionNotSupported)
+	}
+	return nil
+}
+
+// condition returns the condition expression, names and values a write is
+// made with, which must also satisfy required when it is not empty.
+func (o Options) condition(required string) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	var expression string
+	switch {
+	case o.Condition == "" && required == "":
+		return nil, nil, nil
+	case o.Condition == "":
+		return aws.String(required), nil, nil
+	case required == "":
+		expression = o.Condition
+	default:
+		expression = "(" + required + ") AND (" + o.Condition + ")"
+	}
+	var names map[string]*string
+	if placeholders := store.Names(o.Condition); len(placeholders) > 0 {
+		names = placeholders
+	}
+	return aws.String(expression), names, o.ConditionValues
+}
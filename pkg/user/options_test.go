@@ -0,0 +1,112 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestOptions(t *testing.T) {
+	active := map[string]*dynamodb.AttributeValue{":status": {S: aws.String(StatusActive)}}
+	stored := func() *testutil.MockDynamoDB {
+		return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+			"firstName": {S: aws.String("Alan")},
+			"lastName":  {S: aws.String("Oliver")},
+			"status":    {S: aws.String(StatusActive)},
+		}}}
+	}
+	alan := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}
+
+	t.Run("expect options to adjust how a user is read", func(t *testing.T) {
+		mockDb := stored()
+		if _, err := FetchUser("alan.oliver@ecs.co.uk", "options", mockDb, WithConsistentRead(), WithProjection("firstName")); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		input := mockDb.GetItemInputs()[0]
+		if !aws.BoolValue(input.ConsistentRead) || input.ProjectionExpression == nil {
+			t.Errorf("Expected a consistent, projected read, got %v", input)
+		}
+	})
+	t.Run("expect a list to stop at its limit", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{ScanOutput: &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{
+			{"email": {S: aws.String("alan.oliver@ecs.co.uk")}},
+			{"email": {S: aws.String("ada.lovelace@ecs.co.uk")}},
+		}}}
+		users, err := FetchAllUsers("options", mockDb, WithLimit(1))
+		if err != nil || len(*users) != 1 {
+			t.Fatalf("Expected one user, got %v and %v", users, err)
+		}
+	})
+	t.Run("expect conditions to be combined and sent with an update", func(t *testing.T) {
+		mockDb := stored()
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen"}`,
+		}, "options", mockDb, WithCondition("#status = :status", active), WithCondition("attribute_not_exists(#pendingEmail)", nil))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		input := mockDb.UpdateItemInputs()[0]
+		want := "(attribute_exists(#email)) AND ((#status = :status) AND (attribute_not_exists(#pendingEmail)))"
+		if *input.ConditionExpression != want {
+			t.Errorf("Expected %s, got %s", want, *input.ConditionExpression)
+		}
+		if input.ExpressionAttributeNames["#pendingEmail"] == nil || input.ExpressionAttributeValues[":status"] == nil {
+			t.Errorf("Expected the condition's placeholders, got %v and %v", input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		}
+	})
+	t.Run("expect a failed condition to be reported", func(t *testing.T) {
+		mockDb := stored()
+		mockDb.UpdateItemErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen"}`,
+		}, "options", mockDb, WithCondition("#status = :status", active))
+		if err == nil || err.Error() != ErrorConditionFailed {
+			t.Fatalf("Expected error %s, got %v", ErrorConditionFailed, err)
+		}
+
+		mockDb = stored()
+		mockDb.DeleteItemErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+		if err := DeleteUser(alan, "options", mockDb, WithCondition("#status = :status", active)); err == nil || err.Error() != ErrorConditionFailed {
+			t.Fatalf("Expected error %s, got %v", ErrorConditionFailed, err)
+		}
+		if mockDb.Count("Query") != 0 {
+			t.Error("Expected nothing else to be deleted")
+		}
+	})
+	t.Run("expect a create to be conditional only when asked", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		u := User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver"}
+		if _, err := Create(u, "options", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := Create(u, "options", mockDb, WithCondition("attribute_not_exists(#email)", nil)); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		puts := []*dynamodb.PutItemInput{}
+		for _, input := range mockDb.PutItemInputs() {
+			if *input.TableName == "options" {
+				puts = append(puts, input)
+			}
+		}
+		if puts[0].ConditionExpression != nil || aws.StringValue(puts[1].ConditionExpression) != "attribute_not_exists(#email)" {
+			t.Errorf("Expected only the second write to be conditional, got %v and %v", puts[0].ConditionExpression, puts[1].ConditionExpression)
+		}
+	})
+	t.Run("expect conditions to be refused for event sourced users", func(t *testing.T) {
+		ConfigureEventSourcing(EventSourcingConfig{Enabled: true})
+		defer ConfigureEventSourcing(EventSourcingConfig{})
+		mockDb := stored()
+		if err := DeleteUser(alan, "options", mockDb, WithCondition("#status = :status", active)); err == nil || err.Error() != ErrorConditionNotSupported {
+			t.Fatalf("Expected error %s, got %v", ErrorConditionNotSupported, err)
+		}
+		if len(mockDb.Calls()) != 0 {
+			t.Errorf("Expected nothing to be read or written, got %d calls", len(mockDb.Calls()))
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// outboxEvents names the message announcing each kind of user event.
+var outboxEvents = map[string]string{
+	EventCreated: outbox.EventUserCreated,
+	EventUpdated: outbox.EventUserUpdated,
+	EventDeleted: outbox.EventUserDeleted,
+}
+
+// commitWithOutbox makes write in one transaction with the outbox message
+// announcing it, so the message is kept if and only if the write is. It
+// reports whether the transaction was refused because write's condition
+// failed.
+func commitWithOutbox(write *dynamodb.TransactWriteItem, event string, subject string, data interface{}, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, error) {
+	return commit([]*dynamodb.TransactWriteItem{write}, event, subject, data, tableName, dynaClient)
+}
+
+// announce returns the write adding the outbox message announcing event
+// about subject, with data as its payload, when the outbox is enabled, and
+// none otherwise.
+func announce(event string, subject string, data interface{}, tableName string) ([]*dynamodb.TransactWriteItem, error) {
+	if !outbox.Enabled() {
+		return nil, nil
+	}
+	m, err := outbox.New(event, subject, data)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	put, err := outbox.Put(m, tableName)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
+	}
+	return []*dynamodb.TransactWriteItem{put}, nil
+}
+
+// commit makes writes in one transaction, along with the outbox message
+// announcing them when the outbox is enabled. It reports whether the
+// transaction was refused because the first write's condition failed.
+func commit(writes []*dynamodb.TransactWriteItem, event string, subject string, data interface{}, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, error) {
+	announced, err := announce(event, subject, data, tableName)
+	if err != nil {
+		return false, err
+	}
+	writes = append(writes[:len(writes):len(writes)], announced...)
+	_, err = dynaClient.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: writes,
+	})
+	if err != nil {
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+			aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return true, err
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,117 @@
+package user
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func configureTestOutbox(t *testing.T) {
+	outbox.Configure(outbox.Config{Enabled: true})
+	t.Cleanup(func() { outbox.Configure(outbox.Config{}) })
+}
+
+// outboxMessages returns the messages waiting in the outbox, oldest first.
+func outboxMessages(t *testing.T, client *emailTableClient) []outbox.Message {
+	messages := []outbox.Message{}
+	for _, item := range client.table(outbox.TableName("test")) {
+		var m outbox.Message
+		if err := dynamodbattribute.UnmarshalMap(item, &m); err != nil {
+			t.Fatalf("Expected an outbox message, got %v", item)
+		}
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages
+}
+
+func TestOutbox(t *testing.T) {
+	email := "alan.oliver@ecs.co.uk"
+
+	t.Run("expect each change to be written with its message", func(t *testing.T) {
+		configureTestOutbox(t)
+		client := newEmailTableClient()
+		if _, err := Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := UpdateUser(events.APIGatewayProxyRequest{Body: `{"email":"` + email + `","lastName":"Shearer"}`}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if err := DeleteUser(events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": email}}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if client.table("test")[email] != nil {
+			t.Errorf("Expected the user to be deleted")
+		}
+
+		messages := outboxMessages(t, client)
+		names := []string{}
+		for _, m := range messages {
+			names = append(names, m.Event)
+		}
+		if strings.Join(names, ",") != "user.created,user.updated,user.deleted" {
+			t.Fatalf("Expected created, updated and deleted messages, got %v", names)
+		}
+		if messages[1].Subject != email || !strings.Contains(messages[1].Payload, `"lastName":"Shearer"`) {
+			t.Errorf("Expected the update to carry the updated user, got %+v", messages[1])
+		}
+	})
+	t.Run("expect status, tag, email changes and bulk deletes to be announced", func(t *testing.T) {
+		configureTestOutbox(t)
+		mail := configureTestEmailChange()
+		defer ConfigureEmailChange(EmailChangeConfig{})
+		client := newEmailTableClient()
+		seedEmailChangeUser(client)
+		client.table("test")["ada@ecs.co.uk"] = userItem("ada@ecs.co.uk")
+
+		if _, err := SetStatus(email, StatusSuspended, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := AddTags(email, []string{"VIP"}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		RequestEmailChange(email, "al@ecs.co.uk", "test", client)
+		if _, err := ConfirmEmailChange(mail.token(t), "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if _, err := DeleteUsers(BulkDelete{Emails: []string{"ada@ecs.co.uk"}}, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+
+		announced := map[string]string{}
+		for _, m := range outboxMessages(t, client) {
+			announced[m.Event+" "+m.Subject] += m.Payload
+		}
+		updates := announced["user.updated "+email]
+		if !strings.Contains(updates, `"status":"suspended"`) || !strings.Contains(updates, `"tags":["vip"]`) {
+			t.Errorf("Expected the status and tags to be announced, got %v", announced)
+		}
+		for _, want := range []string{"user.deleted " + email, "user.created al@ecs.co.uk", "user.deleted ada@ecs.co.uk"} {
+			if _, ok := announced[want]; !ok {
+				t.Errorf("Expected %s to be announced, got %v", want, announced)
+			}
+		}
+	})
+	t.Run("expect no message for a change that was not made", func(t *testing.T) {
+		configureTestOutbox(t)
+		client := newEmailTableClient()
+		if err := DeleteUser(events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": email}}, "test", client); err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+		if messages := outboxMessages(t, client); len(messages) != 0 {
+			t.Errorf("Expected no messages, got %+v", messages)
+		}
+	})
+	t.Run("expect nothing in the outbox when it is not enabled", func(t *testing.T) {
+		client := newEmailTableClient()
+		Create(User{Email: email, FirstName: "Alan", LastName: "Oliver"}, "test", client)
+		if messages := outboxMessages(t, client); len(messages) != 0 {
+			t.Errorf("Expected no messages, got %+v", messages)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ChangePassword changes the user's password, which can only be done by
+// giving the current one. A user without a password yet is refused: their
+// first password is set with a password reset token, or when they redeem
+// their invitation, so nobody can claim an imported or provisioned user by
+// setting it for them.
+func ChangePassword(email string, currentPassword string, newPassword string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if _, err := existingUser(email, tableName, dynaClient); err != nil {
+		return err
+	}
+	hasPassword, err := credentials.HasPassword(email, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if !hasPassword {
+		return errors.New(credentials.ErrorPasswordNotSet)
+	}
+	if err := credentials.VerifyPassword(email, currentPassword, tableName, dynaClient); err != nil {
+		return err
+	}
+	if err := credentials.SetPassword(email, newPassword, tableName, dynaClient); err != nil {
+		return err
+	}
+	// Like the audit trail, activity is best effort once the change is made
+	_ = RecordActivity(email, tableName, dynaClient)
+	return nil
+}
+
+// RequestPasswordReset emails the user a link to reset their password.
+// Emails without a user, or whose account is not active, succeed without
+// sending anything, so the response does not tell which emails have users.
+func RequestPasswordReset(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if !credentials.ResetEnabled() {
+		return errors.New(credentials.ErrorPasswordResetNotConfigured)
+	}
+	u, err := FetchUser(email, tableName, dynaClient)
+	if err != nil {
+		return err
+	}
+	if len(u.Email) == 0 || u.requireActive() != nil {
+		return nil
+	}
+	return credentials.RequestReset(u.Email, tableName, dynaClient)
+}
+
+// ResetPassword sets a new password with a password reset token, ending the
+// user's sessions as whoever asked for the reset may not have been the only
+// one with access to the account.
+func ResetPassword(email string, token string, newPassword string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := credentials.ConfirmReset(email, token, newPassword, tableName, dynaClient); err != nil {
+		return err
+	}
+	return session.RevokeAll(email, session.Table(tableName), dynaClient)
+}
+
+// Login checks the user's password, and their TOTP code when they have
+// enabled multi-factor authentication, returning the user. Only active users
+// may log in.
+func Login(email string, password string, totp string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := credentials.Authenticate(email, password, totp, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	u, err := existingUser(email, tableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.requireActive(); err != nil {
+		return nil, err
+	}
+	if err := RecordLogin(email, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	return FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+}
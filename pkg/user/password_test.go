@@ -0,0 +1,40 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestChangePassword(t *testing.T) {
+	t.Run("expect a first password to be refused", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String("alan.oliver@ecs.co.uk")},
+		}}}
+		err := ChangePassword("alan.oliver@ecs.co.uk", "", "correct horse battery", "test", mockDb)
+		if err == nil || err.Error() != credentials.ErrorPasswordNotSet {
+			t.Fatalf("Expected error %s, got %v", credentials.ErrorPasswordNotSet, err)
+		}
+		if len(mockDb.UpdateItemInputs()) != 0 {
+			t.Errorf("Expected no password to be set, got %+v", mockDb.UpdateItemInputs())
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Run("expect the credentials to be looked up by the lowercased email", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		err := ResetPassword(" Alan.Oliver@ecs.co.uk ", "token", "correct horse battery", "test", mockDb)
+		if err == nil || err.Error() != credentials.ErrorInvalidResetToken {
+			t.Fatalf("Expected error %s, got %v", credentials.ErrorInvalidResetToken, err)
+		}
+		inputs := mockDb.GetItemInputs()
+		if len(inputs) != 1 || *inputs[0].Key["email"].S != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected the lowercased email to be read, got %+v", inputs)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Preferences are the settings a user keeps alongside their profile.
+type Preferences struct {
+	Notifications NotificationPreferences `json:"notifications"`
+	Theme         string                  `json:"theme" validate:"oneof=light dark system"`
+	Language      string                  `json:"language" validate:"oneof=en es fr"`
+}
+
+// NotificationPreferences are the channels a user agrees to be notified on.
+type NotificationPreferences struct {
+	Email bool `json:"email"`
+	SMS   bool `json:"sms"`
+	Push  bool `json:"push"`
+}
+
+// DefaultPreferences apply to users who have not saved any, and fill in the
+// fields a saved set leaves empty.
+var DefaultPreferences = Preferences{
+	Notifications: NotificationPreferences{Email: true},
+	Theme:         "system",
+	Language:      "en",
+}
+
+var (
+	ErrorFailedToFetchPreferences     = "failed to fetch preferences"
+	ErrorFailedToMarshalPreferences   = "failed to marshal preferences"
+	ErrorFailedToSavePreferences      = "failed to save preferences"
+	ErrorFailedToUnmarshalPreferences = "failed to unmarshal preferences"
+)
+
+// preferencesRecord is an item of the preferences table. UpdatedBy is who
+// last saved them, when known.
+type preferencesRecord struct {
+	Email       string      `json:"email"`
+	Preferences Preferences `json:"preferences"`
+	UpdatedAt   string      `json:"updatedAt"`
+	UpdatedBy   string      `json:"updatedBy,omitempty"`
+}
+
+// PreferencesTableName returns the table that stores preferences for a
+// users table, keyed by the user's email.
+func PreferencesTableName(tableName string) string {
+	return tableName + "Preferences"
+}
+
+func FetchPreferences(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Preferences, error) {
+	if _, err := existingUser(email, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String(email)},
+		},
+		TableName: aws.String(PreferencesTableName(tableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchPreferences)
+	}
+	if len(result.Item) == 0 {
+		preferences := DefaultPreferences
+		return &preferences, nil
+	}
+	record := new(preferencesRecord)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, record); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalPreferences)
+	}
+	return &record.Preferences, nil
+}
+
+// SavePreferences replaces the user's preferences, returning
+// validators.FieldErrors when any are invalid.
+func SavePreferences(email string, preferences Preferences, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Preferences, error) {
+	return SavePreferencesAs(email, preferences, audit.Actor{}, tableName, dynaClient)
+}
+
+// SavePreferencesAs saves preferences as SavePreferences does, recording
+// actor as who saved them.
+func SavePreferencesAs(email string, preferences Preferences, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Preferences, error) {
+	preferences.Theme = strings.ToLower(strings.TrimSpace(preferences.Theme))
+	preferences.Language = strings.ToLower(strings.TrimSpace(preferences.Language))
+	if errs := validators.ValidateStruct(preferences); errs != nil {
+		return nil, errs
+	}
+	if preferences.Theme == "" {
+		preferences.Theme = DefaultPreferences.Theme
+	}
+	if preferences.Language == "" {
+		preferences.Language = DefaultPreferences.Language
+	}
+	if _, err := existingUser(email, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(preferencesRecord{
+		Email:       email,
+		Preferences: preferences,
+		UpdatedAt:   time.Now().UTC().Format(time.RFC3339),
+		UpdatedBy:   actor.String(),
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToMarshalPreferences)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(PreferencesTableName(tableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToSavePreferences)
+	}
+	recordAudit(email, audit.ActionUpdated, map[string]string{"theme": preferences.Theme, "language": preferences.Language}, actor, tableName, dynaClient)
+	_ = RecordActivity(email, tableName, dynaClient)
+	return &preferences, nil
+}
+
+func deletePreferences(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String(email)},
+		},
+		TableName: aws.String(PreferencesTableName(tableName)),
+	})
+	return err
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFetchPreferences(t *testing.T) {
+	t.Run("expect defaults when none have been saved", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutputs: map[string]*dynamodb.GetItemOutput{
+			"test":                       existingUserItem(),
+			PreferencesTableName("test"): {},
+		}}
+		preferences, err := FetchPreferences("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if *preferences != DefaultPreferences {
+			t.Errorf("Expected %+v, got %+v", DefaultPreferences, *preferences)
+		}
+	})
+	t.Run("expect saved preferences to be returned", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutputs: map[string]*dynamodb.GetItemOutput{
+			"test": existingUserItem(),
+			PreferencesTableName("test"): {Item: map[string]*dynamodb.AttributeValue{
+				"email": {S: aws.String("alan.oliver@ecs.co.uk")},
+				"preferences": {M: map[string]*dynamodb.AttributeValue{
+					"theme":    {S: aws.String("dark")},
+					"language": {S: aws.String("fr")},
+				}},
+			}},
+		}}
+		preferences, err := FetchPreferences("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if preferences.Theme != "dark" || preferences.Language != "fr" {
+			t.Errorf("Expected the saved preferences, got %+v", *preferences)
+		}
+	})
+	t.Run("expect error when the user does not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+		_, err := FetchPreferences("alan.oliver@ecs.co.uk", "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
+}
+
+func TestSavePreferences(t *testing.T) {
+	t.Run("expect preferences to be stored in the preferences table", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		preferences, err := SavePreferences("alan.oliver@ecs.co.uk", Preferences{Theme: "Dark"}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if preferences.Theme != "dark" || preferences.Language != DefaultPreferences.Language {
+			t.Errorf("Expected the theme to be normalised and the language defaulted, got %+v", *preferences)
+		}
+		puts := mockDb.PutItemInputs()
+		if len(puts) != 2 || *puts[0].TableName != PreferencesTableName("test") {
+			t.Fatal("Expected the preferences to be put in the preferences table")
+		}
+		if *puts[1].TableName != audit.TableName("test") {
+			t.Errorf("Expected the change to be audited, got %v", puts[1])
+		}
+	})
+	t.Run("expect validation errors for an unknown theme", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := SavePreferences("alan.oliver@ecs.co.uk", Preferences{Theme: "neon"}, "test", mockDb)
+		var fieldErrs validators.FieldErrors
+		if !errors.As(err, &fieldErrs) || fieldErrs[0].Field != "theme" {
+			t.Errorf("Expected a theme validation error, got %v", err)
+		}
+		if len(mockDb.PutItemInputs()) != 0 {
+			t.Error("Expected nothing to be saved")
+		}
+	})
+	t.Run("expect error when the user does not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+		_, err := SavePreferences("alan.oliver@ecs.co.uk", Preferences{}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// attributes holds the JSON name of every User field, which dynamodbattribute
+// also stores the field under.
+var attributes = userAttributes()
+
+func userAttributes() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(User{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// projection builds the ProjectionExpression for the requested fields. Every
+// attribute goes through a placeholder so names that are DynamoDB reserved
+// words can be projected. It returns a nil expression when no fields were
+// requested, which reads the whole item. movedTo is always read so tombstones
+// can be told apart from users.
+func (o FetchOptions) projection() (*string, map[string]*string, error) {
+	if len(o.Fields) == 0 {
+		return nil, nil, nil
+	}
+	placeholders := []string{}
+	names := map[string]*string{}
+	seen := map[string]bool{}
+	for _, field := range o.Fields {
+		if !attributes[field] {
+			return nil, nil, errors.New(ErrorUnknownField)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		placeholder := fmt.Sprintf("#f%d", len(placeholders))
+		placeholders = append(placeholders, placeholder)
+		names[placeholder] = aws.String(field)
+	}
+	placeholders = append(placeholders, "#movedTo")
+	names["#movedTo"] = aws.String("movedTo")
+	return aws.String(strings.Join(placeholders, ", ")), names, nil
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type projectionClient struct {
+	dynamodbiface.DynamoDBAPI
+	get  *dynamodb.GetItemInput
+	scan *dynamodb.ScanInput
+}
+
+func (m *projectionClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.get = input
+	return &dynamodb.GetItemOutput{Item: userItem("alan.oliver@ecs.co.uk")}, nil
+}
+
+func (m *projectionClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	m.scan = input
+	return &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{userItem("alan.oliver@ecs.co.uk")}}, nil
+}
+
+func TestProjection(t *testing.T) {
+	t.Run("should project only the requested fields", func(t *testing.T) {
+		mockDb := &projectionClient{}
+		_, err := FetchUserWithOptions("alan.oliver@ecs.co.uk", "test", mockDb, FetchOptions{
+			Fields: []string{"email", "lastName", "email"},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(mockDb.get.ProjectionExpression) != "#f0, #f1, #movedTo" {
+			t.Errorf("expected projection %q, got %q", "#f0, #f1, #movedTo", aws.StringValue(mockDb.get.ProjectionExpression))
+		}
+		if aws.StringValue(mockDb.get.ExpressionAttributeNames["#f1"]) != "lastName" {
+			t.Errorf("expected #f1 to name lastName, got %q", aws.StringValue(mockDb.get.ExpressionAttributeNames["#f1"]))
+		}
+	})
+	t.Run("should read whole items when no fields are requested", func(t *testing.T) {
+		mockDb := &projectionClient{}
+		FetchAllUsers("test", mockDb)
+		if mockDb.scan.ProjectionExpression != nil || mockDb.scan.ExpressionAttributeNames != nil {
+			t.Errorf("expected no projection, got %q", aws.StringValue(mockDb.scan.ProjectionExpression))
+		}
+	})
+	t.Run("should project scans", func(t *testing.T) {
+		mockDb := &projectionClient{}
+		_, err := FetchAllUsersWithOptions("test", mockDb, FetchOptions{Fields: []string{"firstName"}})
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(mockDb.scan.ExpressionAttributeNames["#f0"]) != "firstName" {
+			t.Errorf("expected #f0 to name firstName, got %q", aws.StringValue(mockDb.scan.ExpressionAttributeNames["#f0"]))
+		}
+	})
+	t.Run("expect error for a field users do not have", func(t *testing.T) {
+		mockDb := &projectionClient{}
+		_, err := FetchUserWithOptions("alan.oliver@ecs.co.uk", "test", mockDb, FetchOptions{
+			Fields: []string{"password"},
+		})
+		if err == nil || err.Error() != ErrorUnknownField {
+			t.Errorf("expected error %q, got %v", ErrorUnknownField, err)
+		}
+		if mockDb.get != nil {
+			t.Error("expected no read")
+		}
+	})
+}
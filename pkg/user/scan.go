@@ -0,0 +1,304 @@
+package user
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ScanConfig controls how FetchAllUsers reads the table. With TotalSegments
+// above one the table is read as a parallel scan, Concurrency segments at a
+// time. Pages are followed until MaxItems users have been read or MaxDuration
+// has passed, whichever comes first; zero disables either limit.
+type ScanConfig struct {
+	TotalSegments int
+	Concurrency   int
+	MaxItems      int
+	MaxDuration   time.Duration
+}
+
+var scanning ScanConfig
+
+func ConfigureScan(config ScanConfig) {
+	scanning = config
+}
+
+type ScanResult struct {
+	Users []User
+	// Truncated is set when the scan stopped at a limit before reading the
+	// whole table
+	Truncated bool
+	// Next is where a truncated scan stopped, for FetchOptions.Start to
+	// resume from
+	Next *ScanPosition
+}
+
+// ScanPosition is where a scan stopped: for each segment it had not
+// finished, the key of the last item read, or nil when the segment had not
+// been started. TotalSegments is the number of segments the scan was split
+// into, zero when it was not.
+type ScanPosition struct {
+	TotalSegments int                                         `json:"segments,omitempty"`
+	After         map[int]map[string]*dynamodb.AttributeValue `json:"after"`
+}
+
+// Valid reports whether the scan configured now can resume from p, which
+// needs it to be split into the same number of segments.
+func (p ScanPosition) Valid() bool {
+	if len(p.After) == 0 || p.TotalSegments != totalSegments() {
+		return false
+	}
+	segments := p.TotalSegments
+	if segments == 0 {
+		segments = 1
+	}
+	for segment := range p.After {
+		if segment < 0 || segment >= segments {
+			return false
+		}
+	}
+	return true
+}
+
+// startPosition is the position of a scan that has read nothing yet.
+func startPosition() *ScanPosition {
+	position := &ScanPosition{TotalSegments: totalSegments(), After: map[int]map[string]*dynamodb.AttributeValue{0: nil}}
+	for segment := 1; segment < position.TotalSegments; segment++ {
+		position.After[segment] = nil
+	}
+	return position
+}
+
+func totalSegments() int {
+	if scanning.TotalSegments > 1 {
+		return scanning.TotalSegments
+	}
+	return 0
+}
+
+// ScanUsers reads the whole table, following pagination within the limits of
+// the scan configuration.
+func ScanUsers(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*ScanResult, error) {
+	return ScanUsersWithOptions(tableName, dynaClient, FetchOptions{})
+}
+
+func ScanUsersWithOptions(tableName string, dynaClient dynamodbiface.DynamoDBAPI, options FetchOptions) (*ScanResult, error) {
+	projection, names, err := options.projection()
+	if err != nil {
+		return nil, err
+	}
+	input := dynamodb.ScanInput{
+		TableName:                aws.String(tableName),
+		ProjectionExpression:     projection,
+		ExpressionAttributeNames: names,
+	}
+	if options.ConsistentRead {
+		input.ConsistentRead = aws.Bool(true)
+	}
+	filters := []string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	if options.Tag != "" {
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = map[string]*string{}
+		}
+		input.ExpressionAttributeNames["#tags"] = aws.String("tags")
+		filters = append(filters, "contains(#tags, :tag)")
+		values[":tag"] = &dynamodb.AttributeValue{S: aws.String(strings.ToLower(strings.TrimSpace(options.Tag)))}
+	}
+	if !options.InactiveSince.IsZero() {
+		// Timestamps are stored as UTC RFC 3339, which sorts as it reads
+		filters = append(filters, "(attribute_not_exists(lastSeenAt) OR lastSeenAt < :inactiveSince)")
+		values[":inactiveSince"] = &dynamodb.AttributeValue{S: aws.String(options.InactiveSince.UTC().Format(time.RFC3339))}
+	}
+	if prefix := strings.TrimSpace(options.Prefix); prefix != "" {
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = map[string]*string{}
+		}
+		input.ExpressionAttributeNames["#firstName"] = aws.String("firstName")
+		input.ExpressionAttributeNames["#lastName"] = aws.String("lastName")
+		filters = append(filters, "(begins_with(email, :emailPrefix) OR begins_with(#firstName, :prefix) OR begins_with(#lastName, :prefix)"+
+			" OR begins_with(#firstName, :namePrefix) OR begins_with(#lastName, :namePrefix))")
+		first, size := utf8.DecodeRuneInString(prefix)
+		values[":emailPrefix"] = &dynamodb.AttributeValue{S: aws.String(strings.ToLower(prefix))}
+		values[":prefix"] = &dynamodb.AttributeValue{S: aws.String(prefix)}
+		values[":namePrefix"] = &dynamodb.AttributeValue{S: aws.String(string(unicode.ToUpper(first)) + prefix[size:])}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+		input.ExpressionAttributeValues = values
+	}
+
+	maxItems := scanning.MaxItems
+	if options.Limit > 0 && (maxItems == 0 || options.Limit < maxItems) {
+		maxItems = options.Limit
+		if input.FilterExpression == nil {
+			// Unfiltered, a page need not read more than the list can return
+			input.Limit = aws.Int64(int64(options.Limit))
+		}
+	}
+	maxDuration := scanning.MaxDuration
+	if options.MaxDuration > 0 && (maxDuration == 0 || options.MaxDuration < maxDuration) {
+		maxDuration = options.MaxDuration
+	}
+	start := options.Start
+	if start == nil {
+		start = startPosition()
+	}
+	// No key narrows a list of users, so the table is scanned
+	store.RecordScan(tableName, store.ScanList)
+	return scanSegments(input, dynaClient, *start, scanning.Concurrency, newScanBudget(maxItems, maxDuration))
+}
+
+// scanSegments reads the segments start has not finished, concurrency at a
+// time, and merges their users in segment order.
+func scanSegments(input dynamodb.ScanInput, dynaClient dynamodbiface.DynamoDBAPI, start ScanPosition, concurrency int, budget *scanBudget) (*ScanResult, error) {
+	pending := make([]int, 0, len(start.After))
+	for segment := range start.After {
+		pending = append(pending, segment)
+	}
+	sort.Ints(pending)
+	if concurrency < 1 || concurrency > len(pending) {
+		concurrency = len(pending)
+	}
+
+	results := make([][]User, len(pending))
+	stops := make([]map[string]*dynamodb.AttributeValue, len(pending))
+	unfinished := make([]bool, len(pending))
+	errs := make([]error, len(pending))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], stops[i], unfinished[i], errs[i] = scanSegment(input, dynaClient, pending[i], start.TotalSegments, start.After[pending[i]], budget)
+			}
+		}()
+	}
+	for i := range pending {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := &ScanResult{Users: []User{}}
+	next := &ScanPosition{TotalSegments: start.TotalSegments, After: map[int]map[string]*dynamodb.AttributeValue{}}
+	for i, segment := range pending {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result.Users = append(result.Users, results[i]...)
+		if unfinished[i] {
+			next.After[segment] = stops[i]
+		}
+	}
+	if len(next.After) > 0 {
+		result.Truncated = true
+		result.Next = next
+	}
+	return result, nil
+}
+
+// scanSegment reads the pages of one segment of a parallel scan, or of the
+// whole table when totalSegments is zero, from after the key given until the
+// budget runs out. When it runs out first, scanSegment reports the segment
+// unfinished with the key of the last item read. The input is copied so
+// segments can page independently.
+func scanSegment(input dynamodb.ScanInput, dynaClient dynamodbiface.DynamoDBAPI, segment int, totalSegments int, after map[string]*dynamodb.AttributeValue, budget *scanBudget) ([]User, map[string]*dynamodb.AttributeValue, bool, error) {
+	if totalSegments > 0 {
+		input.Segment = aws.Int64(int64(segment))
+		input.TotalSegments = aws.Int64(int64(totalSegments))
+	}
+	if len(after) > 0 {
+		input.ExclusiveStartKey = after
+	}
+
+	users := []User{}
+	for {
+		if budget.exhausted() {
+			return users, input.ExclusiveStartKey, true, nil
+		}
+		result, err := dynaClient.Scan(&input)
+		if err != nil {
+			return nil, nil, false, store.Error(err, ErrorFailedToFetchRecord)
+		}
+		page := []User{}
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, nil, false, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+		page = withoutTombstones(page)
+		if keep := budget.take(len(page)); keep < len(page) {
+			users = append(users, page[:keep]...)
+			if keep == 0 {
+				return users, input.ExclusiveStartKey, true, nil
+			}
+			return users, map[string]*dynamodb.AttributeValue{"email": {S: aws.String(page[keep-1].Email)}}, true, nil
+		}
+		users = append(users, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return users, nil, false, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// withoutTombstones drops the items email changes leave behind.
+func withoutTombstones(page []User) []User {
+	users := page[:0]
+	for _, u := range page {
+		if u.MovedTo == "" {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// scanBudget is shared by every segment of a scan so the limits apply to the
+// scan as a whole.
+type scanBudget struct {
+	mu       sync.Mutex
+	maxItems int
+	deadline time.Time
+	items    int
+}
+
+func newScanBudget(maxItems int, maxDuration time.Duration) *scanBudget {
+	budget := &scanBudget{maxItems: maxItems}
+	if maxDuration > 0 {
+		budget.deadline = time.Now().Add(maxDuration)
+	}
+	return budget
+}
+
+func (b *scanBudget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxItems > 0 && b.items >= b.maxItems {
+		return true
+	}
+	return !b.deadline.IsZero() && time.Now().After(b.deadline)
+}
+
+// take reserves room for n more items and returns how many may be kept.
+func (b *scanBudget) take(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxItems > 0 && b.items+n > b.maxItems {
+		n = b.maxItems - b.items
+	}
+	b.items += n
+	return n
+}
@@ -0,0 +1,332 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// segmentedScanClient serves pages per scan segment, using the page number as
+// the LastEvaluatedKey.
+type segmentedScanClient struct {
+	dynamodbiface.DynamoDBAPI
+	mu          sync.Mutex
+	pages       map[int64][][]map[string]*dynamodb.AttributeValue
+	failSegment *int64
+	calls       int
+}
+
+func (m *segmentedScanClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+
+	segment := aws.Int64Value(input.Segment)
+	if m.failSegment != nil && *m.failSegment == segment {
+		return nil, errors.New("scan error")
+	}
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		page, _ = strconv.Atoi(*input.ExclusiveStartKey["page"].N)
+	}
+	output := &dynamodb.ScanOutput{}
+	if page < len(m.pages[segment]) {
+		output.Items = m.pages[segment][page]
+	}
+	if page+1 < len(m.pages[segment]) {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{
+			"page": {N: aws.String(strconv.Itoa(page + 1))},
+		}
+	}
+	return output, nil
+}
+
+func userItem(email string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"email": {
+			S: aws.String(email),
+		},
+	}
+}
+
+func TestFetchAllUsersParallel(t *testing.T) {
+	ConfigureScan(ScanConfig{TotalSegments: 3, Concurrency: 2})
+	defer ConfigureScan(ScanConfig{})
+
+	t.Run("should merge every page of every segment in segment order", func(t *testing.T) {
+		mockDb := &segmentedScanClient{
+			pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+				0: {{userItem("a@ecs.co.uk")}, {userItem("b@ecs.co.uk")}},
+				1: {},
+				2: {{userItem("c@ecs.co.uk"), userItem("d@ecs.co.uk")}},
+			},
+		}
+
+		users, err := FetchAllUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(*users) != 4 {
+			t.Fatalf("Expected length %d, got %d", 4, len(*users))
+		}
+		for i, email := range []string{"a@ecs.co.uk", "b@ecs.co.uk", "c@ecs.co.uk", "d@ecs.co.uk"} {
+			if (*users)[i].Email != email {
+				t.Errorf("Expected user %d to be %s, got %s", i, email, (*users)[i].Email)
+			}
+		}
+		if mockDb.calls != 4 {
+			t.Errorf("Expected %d scan calls, got %d", 4, mockDb.calls)
+		}
+	})
+	t.Run("expect error when any segment fails", func(t *testing.T) {
+		mockDb := &segmentedScanClient{
+			pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+				0: {{userItem("a@ecs.co.uk")}},
+			},
+			failSegment: aws.Int64(2),
+		}
+
+		_, err := FetchAllUsers("test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorFailedToFetchRecord {
+			t.Errorf("Expected error %s, got %s", ErrorFailedToFetchRecord, err.Error())
+		}
+	})
+}
+
+func TestScanUsers(t *testing.T) {
+	pages := map[int64][][]map[string]*dynamodb.AttributeValue{
+		0: {{userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk")}, {userItem("c@ecs.co.uk")}},
+	}
+
+	t.Run("should follow pagination to the end of the table", func(t *testing.T) {
+		mockDb := &segmentedScanClient{pages: pages}
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(result.Users) != 3 {
+			t.Errorf("Expected length %d, got %d", 3, len(result.Users))
+		}
+		if result.Truncated {
+			t.Errorf("Expected result not to be truncated")
+		}
+	})
+	t.Run("should stop and report truncation at the item limit", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxItems: 1})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: pages}
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(result.Users) != 1 {
+			t.Errorf("Expected length %d, got %d", 1, len(result.Users))
+		}
+		if !result.Truncated {
+			t.Errorf("Expected result to be truncated")
+		}
+		if mockDb.calls != 1 {
+			t.Errorf("Expected %d scan call, got %d", 1, mockDb.calls)
+		}
+	})
+	t.Run("should not report truncation when the limit matches the table size", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxItems: 3})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: pages}
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(result.Users) != 3 || result.Truncated {
+			t.Errorf("Expected %d users without truncation, got %d truncated=%t", 3, len(result.Users), result.Truncated)
+		}
+	})
+	t.Run("should stop and report truncation when the time budget is spent", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxDuration: time.Nanosecond})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: pages}
+		time.Sleep(time.Millisecond)
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if !result.Truncated {
+			t.Errorf("Expected result to be truncated")
+		}
+	})
+	t.Run("should stop at the requested limit when it is below the item limit", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxItems: 3})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: pages}
+
+		result, err := ScanUsersWithOptions("test", mockDb, FetchOptions{Limit: 2})
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(result.Users) != 2 || !result.Truncated {
+			t.Errorf("Expected %d users with truncation, got %d truncated=%t", 2, len(result.Users), result.Truncated)
+		}
+	})
+	t.Run("should share the item limit across parallel segments", func(t *testing.T) {
+		ConfigureScan(ScanConfig{TotalSegments: 2, Concurrency: 2, MaxItems: 2})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{
+			pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+				0: {{userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk")}},
+				1: {{userItem("c@ecs.co.uk"), userItem("d@ecs.co.uk")}},
+			},
+		}
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(result.Users) != 2 {
+			t.Errorf("Expected length %d, got %d", 2, len(result.Users))
+		}
+		if !result.Truncated {
+			t.Errorf("Expected result to be truncated")
+		}
+	})
+}
+
+func TestScanUsersResume(t *testing.T) {
+	t.Run("should resume from where a truncated scan stopped", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxItems: 2})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+			0: {{userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk")}, {userItem("c@ecs.co.uk")}},
+		}}
+
+		first, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if first.Next == nil || !first.Next.Valid() {
+			t.Fatalf("Expected a valid position to resume from, got %+v", first.Next)
+		}
+		rest, err := ScanUsersWithOptions("test", mockDb, FetchOptions{Start: first.Next})
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(rest.Users) != 1 || rest.Users[0].Email != "c@ecs.co.uk" {
+			t.Errorf("Expected only %s, got %+v", "c@ecs.co.uk", rest.Users)
+		}
+		if rest.Next != nil || rest.Truncated {
+			t.Errorf("Expected the scan to finish, got %+v", rest.Next)
+		}
+	})
+	t.Run("should stop after the last user kept from a partly read page", func(t *testing.T) {
+		ConfigureScan(ScanConfig{MaxItems: 1})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+			0: {{userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk")}},
+		}}
+
+		result, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if result.Next == nil || aws.StringValue(result.Next.After[0]["email"].S) != "a@ecs.co.uk" {
+			t.Errorf("Expected to resume after %s, got %+v", "a@ecs.co.uk", result.Next)
+		}
+	})
+	t.Run("should resume only the segments a parallel scan did not finish", func(t *testing.T) {
+		ConfigureScan(ScanConfig{TotalSegments: 2, Concurrency: 1, MaxItems: 2})
+		defer ConfigureScan(ScanConfig{})
+		mockDb := &segmentedScanClient{pages: map[int64][][]map[string]*dynamodb.AttributeValue{
+			0: {{userItem("a@ecs.co.uk"), userItem("b@ecs.co.uk")}},
+			1: {{userItem("c@ecs.co.uk"), userItem("d@ecs.co.uk")}},
+		}}
+
+		first, err := ScanUsers("test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if first.Next == nil || len(first.Next.After) != 1 {
+			t.Fatalf("Expected one unfinished segment, got %+v", first.Next)
+		}
+		ConfigureScan(ScanConfig{TotalSegments: 2, Concurrency: 1})
+		rest, err := ScanUsersWithOptions("test", mockDb, FetchOptions{Start: first.Next})
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if len(rest.Users) != 2 || rest.Users[0].Email != "c@ecs.co.uk" {
+			t.Errorf("Expected the users of segment 1, got %+v", rest.Users)
+		}
+	})
+}
+
+func TestScanPositionValid(t *testing.T) {
+	ConfigureScan(ScanConfig{TotalSegments: 2})
+	defer ConfigureScan(ScanConfig{})
+
+	tests := []struct {
+		name     string
+		position ScanPosition
+		want     bool
+	}{
+		{"unfinished segment", ScanPosition{TotalSegments: 2, After: map[int]map[string]*dynamodb.AttributeValue{1: nil}}, true},
+		{"no unfinished segments", ScanPosition{TotalSegments: 2}, false},
+		{"different segment count", ScanPosition{TotalSegments: 3, After: map[int]map[string]*dynamodb.AttributeValue{1: nil}}, false},
+		{"segment out of range", ScanPosition{TotalSegments: 2, After: map[int]map[string]*dynamodb.AttributeValue{2: nil}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.position.Valid(); got != tt.want {
+				t.Errorf("Expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+// BenchmarkScanUsers measures unmarshalling a scan of 1000 users with full
+// profiles, served as 10 pages.
+func BenchmarkScanUsers(b *testing.B) {
+	pages := make([][]map[string]*dynamodb.AttributeValue, 10)
+	for p := range pages {
+		for i := 0; i < 100; i++ {
+			item, err := dynamodbattribute.MarshalMap(User{
+				Email:       "user" + strconv.Itoa(p*100+i) + "@ecs.co.uk",
+				FirstName:   "Alan",
+				LastName:    "Oliver",
+				Phone:       aws.String("+447700900123"),
+				DateOfBirth: aws.String("1980-01-01"),
+				Address:     &Address{Line1: "1 High Street", City: "London", PostalCode: "SW1A 1AA", Country: "GB"},
+				Company:     aws.String("ECS"),
+				Metadata:    map[string]string{"source": "benchmark"},
+				Tags:        []string{"beta", "staff"},
+				Status:      StatusActive,
+				CreatedAt:   "2021-01-01T00:00:00Z",
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			pages[p] = append(pages[p], item)
+		}
+	}
+	mockDb := &segmentedScanClient{pages: map[int64][][]map[string]*dynamodb.AttributeValue{0: pages}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := ScanUsersWithOptions("test", mockDb, FetchOptions{})
+		if err != nil || len(result.Users) != 1000 {
+			b.Fatalf("Expected 1000 users, got %v", err)
+		}
+	}
+}
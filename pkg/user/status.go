@@ -0,0 +1,181 @@
+package user
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	StatusActive      = "active"
+	StatusSuspended   = "suspended"
+	StatusDeactivated = "deactivated"
+)
+
+var (
+	ErrorAccountDeactivated      = "account is deactivated"
+	ErrorAccountSuspended        = "account is suspended"
+	ErrorFailedToUpdateStatus    = "failed to update status"
+	ErrorInvalidStatusTransition = "invalid status transition"
+)
+
+// statusTransitions lists the statuses each status may change to.
+var statusTransitions = map[string][]string{
+	StatusActive:      {StatusSuspended, StatusDeactivated},
+	StatusSuspended:   {StatusActive, StatusDeactivated},
+	StatusDeactivated: {StatusActive},
+}
+
+// CurrentStatus is the user's status. Users saved before statuses were
+// introduced have none and are active.
+func (u *User) CurrentStatus() string {
+	if u.Status == "" {
+		return StatusActive
+	}
+	return u.Status
+}
+
+func canTransition(from string, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus moves the user to status if the transition is allowed. Leaving
+// the active status ends the user's sessions.
+func SetStatus(email string, status string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return SetStatusAs(email, status, audit.Actor{}, tableName, dynaClient)
+}
+
+// SetStatusAs moves the user to status as SetStatus does, recording actor as
+// who last changed them.
+func SetStatusAs(email string, status string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Email) == 0 {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	from := u.CurrentStatus()
+	if !canTransition(from, status) {
+		return nil, errors.New(ErrorInvalidStatusTransition)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if eventSourcing.Enabled {
+		// The event conflicts with any other change since the user was read
+		after := *u
+		after.Status = status
+		after.StatusChangedAt = now
+		after.UpdatedBy = actor.String()
+		err = appendEvent(EventUpdated, *u, &after, tableName, dynaClient)
+		cache.invalidate(tableName, email)
+		if err != nil {
+			return nil, err
+		}
+		return statusChanged(&after, actor, tableName, dynaClient)
+	}
+
+	// The condition fails if the status changed since it was read
+	condition := "#status = :from"
+	if from == StatusActive {
+		condition = "attribute_exists(email) AND (attribute_not_exists(#status) OR #status = :from)"
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":from": {S: aws.String(from)},
+		":to":   {S: aws.String(status)},
+		":now":  {S: aws.String(now)},
+	}
+	expression := audit.UpdatedBy("SET #status = :to, statusChangedAt = :now", values, actor)
+	names := map[string]*string{"#status": aws.String("status")}
+	if outbox.Enabled() {
+		after := *u
+		after.Status = status
+		after.StatusChangedAt = now
+		after.UpdatedBy = actor.String()
+		write := &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			Key:                       userKey(u.Email),
+			TableName:                 aws.String(tableName),
+			UpdateExpression:          aws.String(expression),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}}
+		conditionFailed, err := commitWithOutbox(write, outbox.EventUserUpdated, u.Email, after, tableName, dynaClient)
+		cache.invalidate(tableName, u.Email)
+		if conditionFailed {
+			return nil, errors.New(ErrorInvalidStatusTransition)
+		}
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToUpdateStatus)
+		}
+		return statusChanged(&after, actor, tableName, dynaClient)
+	}
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       userKey(u.Email),
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	cache.invalidate(tableName, email)
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, errors.New(ErrorInvalidStatusTransition)
+		}
+		return nil, store.Error(err, ErrorFailedToUpdateStatus)
+	}
+	u, err = FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	return statusChanged(u, actor, tableName, dynaClient)
+}
+
+// statusChanged audits the change of u's status and, when they are no longer
+// active, ends their sessions.
+func statusChanged(u *User, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	recordAudit(u.Email, audit.ActionUpdated, map[string]string{"status": u.Status}, actor, tableName, dynaClient)
+	if u.Status != StatusActive {
+		if err := session.RevokeAll(u.Email, session.Table(tableName), dynaClient); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// RequireActive fails with ErrorAccountSuspended or ErrorAccountDeactivated
+// unless the user is active. Users that do not exist pass, so the operation
+// can answer for itself.
+func RequireActive(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return err
+	}
+	return u.requireActive()
+}
+
+func (u *User) requireActive() error {
+	switch u.CurrentStatus() {
+	case StatusSuspended:
+		return errors.New(ErrorAccountSuspended)
+	case StatusDeactivated:
+		return errors.New(ErrorAccountDeactivated)
+	}
+	return nil
+}
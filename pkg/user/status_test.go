@@ -0,0 +1,50 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func userWithStatus(status string) *dynamodb.GetItemOutput {
+	item := userItem("alan.oliver@ecs.co.uk")
+	if status != "" {
+		item["status"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	}
+	return &dynamodb.GetItemOutput{Item: item}
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("expect users without a status to be suspended as active users", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: userWithStatus("")}
+		if _, err := SetStatus("alan.oliver@ecs.co.uk", StatusSuspended, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		update := mockDb.UpdateItemInputs()[0]
+		if *update.ExpressionAttributeValues[":from"].S != StatusActive || *update.ExpressionAttributeValues[":to"].S != StatusSuspended {
+			t.Errorf("Expected an update from active to suspended, got %v", update.ExpressionAttributeValues)
+		}
+	})
+	t.Run("expect transitions that are not allowed to be rejected", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: userWithStatus(StatusDeactivated)}
+		_, err := SetStatus("alan.oliver@ecs.co.uk", StatusSuspended, "test", mockDb)
+		if err == nil || err.Error() != ErrorInvalidStatusTransition {
+			t.Errorf("Expected error %s, got %v", ErrorInvalidStatusTransition, err)
+		}
+		if len(mockDb.UpdateItemInputs()) != 0 {
+			t.Error("Expected the status not to be updated")
+		}
+	})
+	t.Run("expect suspended users to be refused", func(t *testing.T) {
+		err := RequireActive("alan.oliver@ecs.co.uk", "test", &testutil.MockDynamoDB{GetItemOutput: userWithStatus(StatusSuspended)})
+		if err == nil || err.Error() != ErrorAccountSuspended {
+			t.Errorf("Expected error %s, got %v", ErrorAccountSuspended, err)
+		}
+		if err := RequireActive("alan.oliver@ecs.co.uk", "test", &testutil.MockDynamoDB{GetItemOutput: userWithStatus(StatusActive)}); err != nil {
+			t.Errorf("Expected active users to pass, got %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrorFailedToUpdateTags = "failed to update tags"
+
+// MaxTags is the most tags a user carries, as the max rule of their tags.
+const MaxTags = 50
+
+// tagsRequest validates the tags sent to AddTags and RemoveTags, and those a
+// user carries once tags are added.
+type tagsRequest struct {
+	Tags []string `json:"tags" validate:"required,max=50,tags"`
+}
+
+// AddTags adds tags to the user, leaving the tags they already carry. Tags
+// that would leave the user with more than MaxTags are refused.
+func AddTags(email string, tags []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return AddTagsAs(email, tags, audit.Actor{}, tableName, dynaClient)
+}
+
+// AddTagsAs adds tags as AddTags does, recording actor as who last changed
+// the user.
+func AddTagsAs(email string, tags []string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return updateTags(email, tags, true, actor, tableName, dynaClient)
+}
+
+// RemoveTags removes tags from the user. Tags the user does not carry are
+// ignored.
+func RemoveTags(email string, tags []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return RemoveTagsAs(email, tags, audit.Actor{}, tableName, dynaClient)
+}
+
+// RemoveTagsAs removes tags as RemoveTags does, recording actor as who last
+// changed the user.
+func RemoveTagsAs(email string, tags []string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	return updateTags(email, tags, false, actor, tableName, dynaClient)
+}
+
+// updateTags adds tags to the user's tags, or removes them unless add is
+// set, with a set update. Set updates leave concurrent changes to the other
+// tags in place. A set update cannot refuse to grow the set past MaxTags
+// itself, so only the tags the user lacks as read are added, on condition
+// there is still room for them.
+func updateTags(email string, tags []string, add bool, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	req := tagsRequest{Tags: normaliseTags(tags)}
+	if errs := validators.ValidateStruct(req); errs != nil {
+		return nil, errs
+	}
+	if eventSourcing.Enabled {
+		return updateSourcedTags(email, req.Tags, add, actor, tableName, dynaClient)
+	}
+
+	expression := "DELETE #tags :tags"
+	condition := "attribute_exists(email)"
+	values := map[string]*dynamodb.AttributeValue{":tags": {SS: aws.StringSlice(req.Tags)}}
+	// The outbox's message announces the user with their new tags, so they
+	// are worked out from the user as read, as are the tags to add. Only
+	// active users' tags are changed.
+	read, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	if read.Email == "" {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	if err := read.requireActive(); err != nil {
+		return nil, err
+	}
+	if add {
+		if errs := validators.ValidateStruct(tagsRequest{Tags: withTags(*read, req.Tags, true, actor).Tags}); errs != nil {
+			return nil, errs
+		}
+		added := missingTags(read.Tags, req.Tags)
+		if len(added) == 0 {
+			return read, nil
+		}
+		expression = "ADD #tags :tags"
+		condition += " AND (attribute_not_exists(#tags) OR size(#tags) <= :room)"
+		values[":tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice(added)}
+		values[":room"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(MaxTags - len(added)))}
+	}
+	expression = audit.UpdatedBy(expression, values, actor)
+	names := map[string]*string{"#tags": aws.String("tags")}
+	if outbox.Enabled() {
+		after := withTags(*read, req.Tags, add, actor)
+		write := &dynamodb.TransactWriteItem{Update: &dynamodb.Update{
+			Key:                       userKey(read.Email),
+			TableName:                 aws.String(tableName),
+			UpdateExpression:          aws.String(expression),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}}
+		conditionFailed, err := commitWithOutbox(write, outbox.EventUserUpdated, read.Email, after, tableName, dynaClient)
+		cache.invalidate(tableName, read.Email)
+		if conditionFailed {
+			return nil, tagsConditionFailed(read.Email, tableName, dynaClient)
+		}
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToUpdateTags)
+		}
+		recordAudit(read.Email, audit.ActionUpdated, map[string]string{"tags": strings.Join(after.Tags, ",")}, actor, tableName, dynaClient)
+		return &after, nil
+	}
+	_, err = dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String(email)},
+		},
+		TableName:                 aws.String(tableName),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	cache.invalidate(tableName, email)
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, tagsConditionFailed(email, tableName, dynaClient)
+		}
+		return nil, store.Error(err, ErrorFailedToUpdateTags)
+	}
+	u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(email, audit.ActionUpdated, map[string]string{"tags": strings.Join(u.Tags, ",")}, actor, tableName, dynaClient)
+	return u, nil
+}
+
+// updateSourcedTags changes an event sourced user's tags as updateTags does,
+// appending the user with their new tags as an event. Unlike a set update,
+// the event conflicts with any other change since the user was read.
+func updateSourcedTags(email string, tags []string, add bool, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+	u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	if u.Email == "" {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	if err := u.requireActive(); err != nil {
+		return nil, err
+	}
+	after := withTags(*u, tags, add, actor)
+	if add {
+		if errs := validators.ValidateStruct(tagsRequest{Tags: after.Tags}); errs != nil {
+			return nil, errs
+		}
+	}
+	err = appendEvent(EventUpdated, *u, &after, tableName, dynaClient)
+	cache.invalidate(tableName, email)
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(email, audit.ActionUpdated, map[string]string{"tags": strings.Join(after.Tags, ",")}, actor, tableName, dynaClient)
+	return &after, nil
+}
+
+// tagsConditionFailed explains a tags update whose condition failed: the
+// user is gone, or their tags changed since they were read and may no
+// longer have room for those added.
+func tagsConditionFailed(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	u, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+	if err != nil {
+		return err
+	}
+	if u.Email == "" {
+		return errors.New(ErrorUserNotFound)
+	}
+	return errors.New(ErrorConcurrentUpdate)
+}
+
+// missingTags returns the tags of tags that held lacks.
+func missingTags(held []string, tags []string) []string {
+	carried := map[string]bool{}
+	for _, tag := range held {
+		carried[tag] = true
+	}
+	missing := []string{}
+	for _, tag := range tags {
+		if !carried[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
+
+// withTags returns u with tags added to their tags, or removed from them
+// unless add is set, as changed by actor.
+func withTags(u User, tags []string, add bool, actor audit.Actor) User {
+	before := u.Tags
+	if add {
+		u.Tags = normaliseTags(append(append([]string{}, before...), tags...))
+	} else {
+		removed := map[string]bool{}
+		for _, tag := range tags {
+			removed[tag] = true
+		}
+		u.Tags = nil
+		for _, tag := range before {
+			if !removed[tag] {
+				u.Tags = append(u.Tags, tag)
+			}
+		}
+	}
+	u.UpdatedBy = actor.String()
+	return u
+}
+
+// normaliseTags lowercases, trims and sorts tags, dropping empty and repeated
+// ones, as a string set holds each tag once.
+func normaliseTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	normalised := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalised = append(normalised, tag)
+	}
+	sort.Strings(normalised)
+	return normalised
+}
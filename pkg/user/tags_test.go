@@ -0,0 +1,135 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+type tagScanClient struct {
+	testutil.MockDynamoDB
+	input *dynamodb.ScanInput
+}
+
+func (m *tagScanClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	m.input = input
+	return &dynamodb.ScanOutput{}, nil
+}
+
+func TestTags(t *testing.T) {
+	t.Run("expect tags to be normalised and added as a set", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := AddTags("alan.oliver@ecs.co.uk", []string{" Beta", "plan:pro", "beta"}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(mockDb.UpdateItemInputs()) != 1 {
+			t.Fatalf("Expected one update, got %d", len(mockDb.UpdateItemInputs()))
+		}
+		update := mockDb.UpdateItemInputs()[0]
+		tags := update.ExpressionAttributeValues[":tags"].SS
+		if *update.UpdateExpression != "ADD #tags :tags REMOVE updatedBy" || len(tags) != 2 || *tags[0] != "beta" || *tags[1] != "plan:pro" {
+			t.Errorf("Expected beta and plan:pro to be added, got %s %v", *update.UpdateExpression, tags)
+		}
+	})
+	t.Run("expect tags to be removed from the set", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		_, err := RemoveTags("alan.oliver@ecs.co.uk", []string{"beta"}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if *mockDb.UpdateItemInputs()[0].UpdateExpression != "DELETE #tags :tags REMOVE updatedBy" {
+			t.Errorf("Expected a set delete, got %s", *mockDb.UpdateItemInputs()[0].UpdateExpression)
+		}
+	})
+	t.Run("expect the actor to be recorded as who last changed the user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+		actor := audit.Actor{Kind: audit.ActorAPIKey, ID: "a1b2c3"}
+		if _, err := AddTagsAs("alan.oliver@ecs.co.uk", []string{"beta"}, actor, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		update := mockDb.UpdateItemInputs()[0]
+		if *update.UpdateExpression != "ADD #tags :tags SET updatedBy = :updatedBy" || *update.ExpressionAttributeValues[":updatedBy"].S != "apikey:a1b2c3" {
+			t.Errorf("Expected updatedBy to be set, got %s", *update.UpdateExpression)
+		}
+		var entry audit.Entry
+		dynamodbattribute.UnmarshalMap(mockDb.PutItemInputs()[0].Item, &entry)
+		if entry.Actor != "apikey:a1b2c3" {
+			t.Errorf("Expected the audit entry to name the actor, got %q", entry.Actor)
+		}
+	})
+	t.Run("expect validation errors for invalid or missing tags", func(t *testing.T) {
+		for _, tags := range [][]string{{"two words"}, {}} {
+			mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem()}
+			_, err := AddTags("alan.oliver@ecs.co.uk", tags, "test", mockDb)
+			var fieldErrs validators.FieldErrors
+			if !errors.As(err, &fieldErrs) {
+				t.Errorf("Expected validation errors for %v, got %v", tags, err)
+			}
+			if len(mockDb.UpdateItemInputs()) != 0 {
+				t.Error("Expected the user not to be updated")
+			}
+		}
+	})
+	t.Run("expect error when the user does not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", errors.New("condition"))}
+		_, err := AddTags("alan.oliver@ecs.co.uk", []string{"beta"}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
+	t.Run("expect tags past the most a user carries to be refused", func(t *testing.T) {
+		held := make([]string, MaxTags-1)
+		for i := range held {
+			held[i] = "tag" + strconv.Itoa(i)
+		}
+		item := userItem("alan.oliver@ecs.co.uk")
+		item["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice(held)}
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: item}}
+
+		_, err := AddTags("alan.oliver@ecs.co.uk", []string{"tag0", "beta", "plan:pro"}, "test", mockDb)
+		var fieldErrs validators.FieldErrors
+		if !errors.As(err, &fieldErrs) || fieldErrs[0].Field != "tags" || len(mockDb.UpdateItemInputs()) != 0 {
+			t.Fatalf("Expected a validation error for the tags and no update, got %v", err)
+		}
+		if _, err := AddTags("alan.oliver@ecs.co.uk", []string{"tag0", "beta"}, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		update := mockDb.UpdateItemInputs()[0]
+		if tags := update.ExpressionAttributeValues[":tags"].SS; len(tags) != 1 || *tags[0] != "beta" {
+			t.Errorf("Expected only the tag the user lacks to be added, got %v", tags)
+		}
+		if *update.ExpressionAttributeValues[":room"].N != strconv.Itoa(MaxTags-1) {
+			t.Errorf("Expected the update to need room for the tag added, got %s", *update.ExpressionAttributeValues[":room"].N)
+		}
+	})
+	t.Run("expect a conflict when the tags grew since they were read", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: existingUserItem(), UpdateItemErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", errors.New("condition"))}
+		_, err := AddTags("alan.oliver@ecs.co.uk", []string{"beta"}, "test", mockDb)
+		if err == nil || err.Error() != ErrorConcurrentUpdate {
+			t.Errorf("Expected error %s, got %v", ErrorConcurrentUpdate, err)
+		}
+	})
+	t.Run("expect listing by tag to filter the scan", func(t *testing.T) {
+		mockDb := &tagScanClient{}
+		_, err := ScanUsersWithOptions("test", mockDb, FetchOptions{Tag: "Beta"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if mockDb.input.FilterExpression == nil || *mockDb.input.FilterExpression != "contains(#tags, :tag)" {
+			t.Fatalf("Expected a contains filter, got %v", mockDb.input.FilterExpression)
+		}
+		if *mockDb.input.ExpressionAttributeValues[":tag"].S != "beta" {
+			t.Errorf("Expected the tag to be normalised, got %s", *mockDb.input.ExpressionAttributeValues[":tag"].S)
+		}
+	})
+}
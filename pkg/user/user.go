@@ -1,47 +1,187 @@
 package user
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"strings"
+	"time"
 
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/credentials"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/jsonbody"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/session"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
 	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 type User struct {
-	Email     string `json:"email"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	Email     string `json:"email" validate:"required,email"`
+	FirstName string `json:"firstName" validate:"required,max=50,name"`
+	LastName  string `json:"lastName" validate:"required,max=50,name"`
+	// Optional profile fields are nil when the user has none, so they are
+	// left out of the stored item and the JSON rather than kept empty
+	Phone       *string  `json:"phone,omitempty" validate:"phone"`
+	DateOfBirth *string  `json:"dateOfBirth,omitempty" validate:"date,past"`
+	Address     *Address `json:"address,omitempty"`
+	JobTitle    *string  `json:"jobTitle,omitempty" validate:"max=100"`
+	Company     *string  `json:"company,omitempty" validate:"max=100"`
+	// Metadata is custom key/value data attached by integrators
+	Metadata map[string]string `json:"metadata,omitempty" validate:"max=20,metadata"`
+	// Tags segment users for operators and are stored as a string set
+	Tags []string `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty" validate:"max=50,tags"`
+	// Avatar is the S3 key of the user's avatar, only set by AttachAvatar
+	Avatar string `json:"avatar,omitempty"`
+	// LastLoginAt and LastSeenAt are only set by RecordLogin and RecordActivity
+	LastLoginAt string `json:"lastLoginAt,omitempty"`
+	LastSeenAt  string `json:"lastSeenAt,omitempty"`
+	// Status and StatusChangedAt are only set by SetStatus, apart from new
+	// users starting active
+	Status          string `json:"status,omitempty"`
+	StatusChangedAt string `json:"statusChangedAt,omitempty"`
+	// PendingEmail is the address RequestEmailChange is waiting to confirm
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	// CreatedAt is only set by Create
+	CreatedAt string `json:"createdAt,omitempty"`
+	// CreatedBy and UpdatedBy are the actors that created and last changed
+	// the user, as audit.Actor.String gives them, when they were known
+	CreatedBy string `json:"createdBy,omitempty"`
+	UpdatedBy string `json:"updatedBy,omitempty"`
+	// Identity is the verified OpenID Connect identity that created or last
+	// updated the user, only set by CreateUserAs and UpdateUserAs
+	Identity *Identity `json:"identity,omitempty"`
+	// MovedTo is only set on the tombstone left behind by an email change,
+	// which is read as no user at all
+	MovedTo string `json:"-" dynamodbav:"movedTo,omitempty"`
+	// Version is the sequence number of the last event the user was stored
+	// as of, when event sourcing is enabled
+	Version int64 `json:"-" dynamodbav:"version,omitempty"`
+}
+
+// Identity is an OpenID Connect identity: the subject an issuer knows a
+// person by.
+type Identity struct {
+	Subject string `json:"sub"`
+	Issuer  string `json:"iss"`
+}
+
+type Address struct {
+	Line1      string `json:"line1" validate:"required,max=100"`
+	Line2      string `json:"line2,omitempty" validate:"max=100"`
+	City       string `json:"city" validate:"required,max=50"`
+	Region     string `json:"region,omitempty" validate:"max=50"`
+	PostalCode string `json:"postalCode" validate:"required,max=20"`
+	Country    string `json:"country" validate:"required,country"`
+}
+
+// ValidationConfig enables the optional checks applied when creating users.
+type ValidationConfig struct {
+	RejectDisposableEmails bool
+	// MXVerifier, when set, rejects emails whose domain has no MX records
+	MXVerifier *validators.MXVerifier
+	// Validators check users after TagValidator, in order, as they are
+	// created and updated
+	Validators []Validator
+}
+
+var validation ValidationConfig
+
+func ConfigureValidation(config ValidationConfig) {
+	validation = config
 }
 
 var (
 	ErrorCouldNotDynamoPutItem   = "could not update record"
 	ErrorCouldNotMarshalItem     = "fail to marshal record"
+	ErrorDisposableEmail         = "disposable email addresses are not allowed"
+	ErrorEmailRequired           = "email is required"
 	ErrorFailedToDeleteRecord    = "failed to delete record"
 	ErrorFailedToFetchRecord     = "failed to fetch record"
 	ErrorFailedToUnmarshalRecord = "failed to unmarshal record"
 	ErrorInvalidEmail            = "invalid email"
 	ErrorInvalidUserData         = "invalid user data"
+	ErrorUndeliverableEmail      = "email domain does not accept mail"
+	ErrorUnknownField            = "unknown field"
 	ErrorUserAlreadyExists       = "user already exists"
 )
 
-func FetchUser(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+// FetchOptions adjusts how users are read.
+type FetchOptions struct {
+	// ConsistentRead requests a strongly consistent read, so a write that has
+	// just succeeded is always observed. It bypasses the cache.
+	ConsistentRead bool
+	// Fields limits the attributes read to those named, by their JSON name.
+	// The rest are left empty. When empty the whole user is read.
+	Fields []string
+	// Tag limits the users listed to those carrying the tag.
+	Tag string
+	// InactiveSince limits the users listed to those not seen since then,
+	// including users never seen at all.
+	InactiveSince time.Time
+	// Prefix limits the users listed to those whose email, first name or
+	// last name begins with it. Emails are matched whatever its case, names
+	// as it is given or with its first letter capitalised.
+	Prefix string
+	// Limit stops a list after that many users, as if it were the scan's
+	// item limit when that is higher. Zero leaves only the scan limits.
+	Limit int
+	// MaxDuration bounds how long a list scans for, when it is shorter than
+	// the scan configuration's.
+	MaxDuration time.Duration
+	// Start resumes a list from where an earlier one stopped, as given by its
+	// ScanResult.Next.
+	Start *ScanPosition
+}
+
+// FetchUser reads the user with email, adjusted by options such as
+// WithConsistentRead and WithProjection.
+func FetchUser(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	return FetchUserWithOptions(email, tableName, dynaClient, applyOptions(options).FetchOptions)
+}
+
+func FetchUserWithOptions(email string, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options FetchOptions) (*User, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	projection, names, err := options.projection()
+	if err != nil {
+		return nil, err
+	}
+	// Only whole users are cached
+	cacheable := projection == nil
+	if cacheable && !options.ConsistentRead {
+		if u, ok := cache.get(tableName, email); ok {
+			return u, nil
+		}
+	}
 	input := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"email": {
 				S: aws.String(email),
 			},
 		},
-		TableName: aws.String(tableName),
+		TableName:                aws.String(tableName),
+		ProjectionExpression:     projection,
+		ExpressionAttributeNames: names,
+	}
+	if options.ConsistentRead {
+		input.ConsistentRead = aws.Bool(true)
 	}
 	result, err := dynaClient.GetItem(input)
 	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
+		return nil, store.Error(err, ErrorFailedToFetchRecord)
+	}
+	// Only whole users are caught up with their events
+	if eventSourcing.Enabled && projection == nil {
+		if result.Item, err = catchUp(email, result.Item, tableName, dynaClient); err != nil {
+			return nil, err
+		}
 	}
 
 	item := new(User)
@@ -49,108 +189,498 @@ func FetchUser(email string, tableName string, dynaClient dynamodbiface.DynamoDB
 	if err != nil {
 		return nil, errors.New(ErrorFailedToUnmarshalRecord)
 	}
+	if item.MovedTo != "" {
+		item = new(User)
+	}
+	if cacheable {
+		cache.add(tableName, *item)
+	}
 	return item, nil
 }
 
-func FetchAllUsers(tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*[]User, error) {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	}
-	result, err := dynaClient.Scan(input)
-	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
+// FetchUsers reads the users of emails, in their order, with as few
+// BatchGetItem calls as there are hundreds of them. Emails without a user are
+// left out.
+func FetchUsers(emails []string, tableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]User, error) {
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+	failed := batchGet(emails, &dynamodb.KeysAndAttributes{}, tableName, dynaClient, func(item map[string]*dynamodb.AttributeValue) {
+		items[stringAttribute(item, "email")] = item
+	})
+	users := []User{}
+	for _, email := range emails {
+		if err, ok := failed[email]; ok {
+			return nil, err
+		}
+		item, ok := items[email]
+		if !ok {
+			continue
+		}
+		if eventSourcing.Enabled {
+			var err error
+			if item, err = catchUp(email, item, tableName, dynaClient); err != nil {
+				return nil, err
+			}
+		}
+		var u User
+		if err := dynamodbattribute.UnmarshalMap(item, &u); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalRecord)
+		}
+		if u.Email != "" && u.MovedTo == "" {
+			users = append(users, u)
+		}
 	}
+	return users, nil
+}
+
+// FetchAllUsers lists users, adjusted by options such as WithLimit and
+// WithProjection.
+func FetchAllUsers(tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*[]User, error) {
+	return FetchAllUsersWithOptions(tableName, dynaClient, applyOptions(options).FetchOptions)
+}
 
-	item := new([]User)
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &item)
+func FetchAllUsersWithOptions(tableName string, dynaClient dynamodbiface.DynamoDBAPI, options FetchOptions) (*[]User, error) {
+	result, err := ScanUsersWithOptions(tableName, dynaClient, options)
 	if err != nil {
-		return nil, errors.New(ErrorFailedToUnmarshalRecord)
+		return nil, err
 	}
-	return item, nil
+	return &result.Users, nil
 }
 
-func CreateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
+func CreateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	return CreateUserAs(req, nil, tableName, dynaClient, options...)
+}
+
+// CreateUserAs creates the user req describes as CreateUser does, recording
+// identity, when it is not nil, as the identity that created them. An
+// identity sent in the body is never kept. The user is created by the actor
+// that made req.
+func CreateUserAs(req events.APIGatewayProxyRequest, identity *Identity, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
 	var u User
-	err := json.Unmarshal([]byte(req.Body), &u)
+	err := jsonbody.Decode(req.Body, &u)
 	if err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
-	if !validators.IsEmailValid(u.Email) {
-		return nil, errors.New(ErrorInvalidEmail)
+	u.Identity = identity
+	return CreateAs(u, audit.ActorOf(req), tableName, dynaClient, options...)
+}
+
+// Validate normalises and checks a new user as Create would, without reading
+// or saving anything, so a user can be checked before it is created.
+func Validate(u User) (*User, error) {
+	u.Email = strings.ToLower(u.Email)
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	if validation.RejectDisposableEmails && validators.IsEmailDisposable(u.Email) {
+		return nil, errors.New(ErrorDisposableEmail)
+	}
+	if validation.MXVerifier != nil {
+		if result := <-validation.MXVerifier.VerifyEmail(context.Background(), u.Email); result.Err == nil && !result.HasMX {
+			return nil, errors.New(ErrorUndeliverableEmail)
+		}
 	}
+	return &u, nil
+}
 
-	// Check if user already exists
-	existingUser, err := FetchUser(u.Email, tableName, dynaClient)
+// Create validates and saves a new user, for callers that have already read
+// the user from their own request.
+func Create(u User, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	return CreateAs(u, audit.Actor{}, tableName, dynaClient, options...)
+}
+
+// CreateAs creates u as Create does, recording actor as who created them.
+func CreateAs(u User, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	o := applyOptions(options)
+	if err := o.checkable(); err != nil {
+		return nil, err
+	}
+	// Emails are stored lowercase so that one address cannot be registered
+	// twice in different cases
+	u.Email = strings.ToLower(u.Email)
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	if err := schema.Check(u.Metadata, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	u.Avatar = ""
+	u.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	u.LastLoginAt, u.LastSeenAt = "", ""
+	u.Status, u.StatusChangedAt = StatusActive, ""
+	u.PendingEmail = ""
+	u.CreatedBy, u.UpdatedBy = actor.String(), ""
+	if validation.RejectDisposableEmails && validators.IsEmailDisposable(u.Email) {
+		return nil, errors.New(ErrorDisposableEmail)
+	}
+	var mx <-chan validators.MXResult
+	if validation.MXVerifier != nil {
+		mx = validation.MXVerifier.VerifyEmail(context.Background(), u.Email)
+	}
+
+	// Check if user already exists. An event sourced user is read as of
+	// their latest event, which the new one must follow
+	existingUser, err := FetchUserWithOptions(u.Email, tableName, dynaClient, FetchOptions{ConsistentRead: eventSourcing.Enabled})
 	if err != nil {
-		return nil, errors.New(ErrorFailedToFetchRecord)
+		return nil, err
 	}
 	if existingUser != nil && len(existingUser.Email) != 0 {
 		return nil, errors.New(ErrorUserAlreadyExists)
 	}
+	// Lookup failures such as DNS timeouts let the address through
+	if mx != nil {
+		if result := <-mx; result.Err == nil && !result.HasMX {
+			return nil, errors.New(ErrorUndeliverableEmail)
+		}
+	}
+	if eventSourcing.Enabled {
+		err := appendEvent(EventCreated, *existingUser, &u, tableName, dynaClient)
+		cache.invalidate(tableName, u.Email)
+		if err != nil {
+			return nil, err
+		}
+		recordAudit(u.Email, audit.ActionCreated, u.auditData(), actor, tableName, dynaClient)
+		return &u, nil
+	}
 	// Save user
 	av, err := dynamodbattribute.MarshalMap(u)
 	if err != nil {
 		return nil, errors.New(ErrorCouldNotMarshalItem)
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	condition, names, values := o.condition("")
+	conditionFailed := false
+	if outbox.Enabled() {
+		write := &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			Item:                      av,
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}}
+		conditionFailed, err = commitWithOutbox(write, outbox.EventUserCreated, u.Email, u, tableName, dynaClient)
+	} else {
+		input := &dynamodb.PutItemInput{
+			Item:                      av,
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+		_, err = dynaClient.PutItem(input)
+		conditionFailed = isConditionFailed(err)
+	}
+	cache.invalidate(tableName, u.Email)
+	if conditionFailed {
+		return nil, errors.New(ErrorConditionFailed)
 	}
-	_, err = dynaClient.PutItem(input)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		return nil, store.Error(err, ErrorCouldNotDynamoPutItem)
 	}
+	recordAudit(u.Email, audit.ActionCreated, u.auditData(), actor, tableName, dynaClient)
 	return &u, nil
 }
 
-func UpdateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (*User, error) {
-	var u User
+// UpdateUser merges the fields sent in the request body onto the stored user
+// named by the body's email. Fields left out keep their stored values and
+// fields sent empty are cleared. Fields only this package sets, such as the
+// status and avatar, are never taken from the body.
+func UpdateUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	return UpdateUserAs(req, nil, tableName, dynaClient, options...)
+}
 
-	if err := json.Unmarshal([]byte(req.Body), &u); err != nil {
+// UpdateUserAs updates the user req describes as UpdateUser does, recording
+// identity, when it is not nil, as the identity that last updated them.
+// Otherwise the identity already recorded is kept. The user is updated by
+// the actor that made req. An update that changes nothing is not written, so
+// a condition it is made with is not checked.
+func UpdateUserAs(req events.APIGatewayProxyRequest, identity *Identity, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) (*User, error) {
+	o := applyOptions(options)
+	if err := o.checkable(); err != nil {
+		return nil, err
+	}
+	var sent User
+	if err := jsonbody.Decode(req.Body, &sent); err != nil {
 		return nil, errors.New(ErrorInvalidUserData)
 	}
+	email := strings.ToLower(strings.TrimSpace(sent.Email))
+	if email == "" {
+		return nil, errors.New(ErrorEmailRequired)
+	}
 
-	// Check if user already exists
-	existingUser, err := FetchUser(u.Email, tableName, dynaClient)
+	// Merge onto the latest version, not a cached one
+	existingUser, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
 	if err != nil {
 		return nil, err
 	}
-	if existingUser == nil && len(existingUser.Email) == 0 {
-		return nil, errors.New(ErrorUserAlreadyExists)
+	if existingUser.Email == "" {
+		return nil, errors.New(ErrorUserNotFound)
+	}
+	if err := existingUser.requireActive(); err != nil {
+		return nil, err
+	}
+	u := existingUser.merge(sent, req.Body)
+	if identity != nil {
+		u.Identity = identity
+	}
+	actor := audit.ActorOf(req)
+	u.UpdatedBy = actor.String()
+	if err := u.validate(); err != nil {
+		return nil, err
+	}
+	if err := schema.Check(u.Metadata, tableName, dynaClient); err != nil {
+		return nil, err
+	}
+	if eventSourcing.Enabled {
+		err := appendEvent(EventUpdated, *existingUser, &u, tableName, dynaClient)
+		cache.invalidate(tableName, u.Email)
+		if err != nil {
+			return nil, err
+		}
+		recordAudit(u.Email, audit.ActionUpdated, u.auditData(), actor, tableName, dynaClient)
+		return &u, nil
 	}
 
-	// Save user
-	av, err := dynamodbattribute.MarshalMap(u)
+	// Only the attributes this update changes are written, so changes made
+	// to the others since the user was read are kept
+	before, err := dynamodbattribute.MarshalMap(existingUser)
 	if err != nil {
 		return nil, errors.New(ErrorCouldNotMarshalItem)
 	}
-
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+	after, err := dynamodbattribute.MarshalMap(u)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotMarshalItem)
 	}
-
-	_, err = dynaClient.PutItem(input)
+	update := store.Diff(before, after)
+	if update.Empty() {
+		return &u, nil
+	}
+	// A user deleted since it was read is not created again
+	update.Exists("email")
+	// The user was there when read, so a failed check is most likely the
+	// caller's condition
+	conditionError := ErrorUserNotFound
+	if o.Condition != "" {
+		update.Condition(o.Condition, o.ConditionValues)
+		conditionError = ErrorConditionFailed
+	}
+	if outbox.Enabled() {
+		write := update.Transact(userKey(u.Email), tableName)
+		conditionFailed, err := commitWithOutbox(write, outbox.EventUserUpdated, u.Email, u, tableName, dynaClient)
+		cache.invalidate(tableName, u.Email)
+		if conditionFailed {
+			return nil, errors.New(conditionError)
+		}
+		if err != nil {
+			return nil, store.Error(err, ErrorCouldNotDynamoPutItem)
+		}
+		recordAudit(u.Email, audit.ActionUpdated, u.auditData(), actor, tableName, dynaClient)
+		return &u, nil
+	}
+	input := update.Input(userKey(u.Email), tableName)
+	input.ReturnValues = aws.String(dynamodb.ReturnValueAllNew)
+	result, err := dynaClient.UpdateItem(input)
+	cache.invalidate(tableName, u.Email)
 	if err != nil {
-		return nil, errors.New(ErrorCouldNotDynamoPutItem)
+		if isConditionFailed(err) {
+			return nil, errors.New(conditionError)
+		}
+		return nil, store.Error(err, ErrorCouldNotDynamoPutItem)
 	}
+	// The user as written, with any changes made alongside this one
+	if len(result.Attributes) > 0 {
+		var written User
+		if dynamodbattribute.UnmarshalMap(result.Attributes, &written) == nil {
+			u = written
+		}
+	}
+	recordAudit(u.Email, audit.ActionUpdated, u.auditData(), actor, tableName, dynaClient)
 	return &u, nil
 }
 
-func DeleteUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
-	email := req.QueryStringParameters["email"]
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"email": {
-				S: aws.String(email),
-			},
-		},
-		TableName: aws.String(tableName),
+// DeleteUser deletes the user named by the "email" path parameter, or the
+// query string parameter of the same name, along with their preferences,
+// credentials and sessions. The deletion is audited as made by the actor
+// that made req. When it is made WithCondition, a user that is not there
+// fails the condition too.
+func DeleteUser(req events.APIGatewayProxyRequest, tableName string, dynaClient dynamodbiface.DynamoDBAPI, options ...Option) error {
+	o := applyOptions(options)
+	if err := o.checkable(); err != nil {
+		return err
+	}
+	email := req.PathParameters["email"]
+	if email == "" {
+		email = req.QueryStringParameters["email"]
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return errors.New(ErrorEmailRequired)
+	}
+	if eventSourcing.Enabled {
+		existing, err := FetchUserWithOptions(email, tableName, dynaClient, FetchOptions{ConsistentRead: true})
+		if err != nil {
+			return err
+		}
+		if existing.Email == "" {
+			return errors.New(ErrorUserNotFound)
+		}
+		if err := appendEvent(EventDeleted, *existing, nil, tableName, dynaClient); err != nil {
+			return err
+		}
 	}
-	_, err := dynaClient.DeleteItem(input)
+	// An event sourced deletion was announced with its event
+	deleted, err := deleteUserRecord(email, !eventSourcing.Enabled, o, tableName, dynaClient)
 	if err != nil {
-		return errors.New(ErrorFailedToDeleteRecord)
+		return err
 	}
+	if !deleted {
+		return errors.New(ErrorUserNotFound)
+	}
+	recordAudit(email, audit.ActionDeleted, nil, audit.ActorOf(req), tableName, dynaClient)
 	return nil
 }
+
+// deleteUserRecord deletes the user and everything kept about them in other
+// tables, reporting whether there was a user to delete. The other tables are
+// cleared either way, so a delete interrupted part way can be retried. When
+// announce is set and the outbox is enabled, the deletion is announced in the
+// same transaction. When o carries a condition and the user fails it, nothing
+// is deleted.
+func deleteUserRecord(email string, announce bool, o Options, tableName string, dynaClient dynamodbiface.DynamoDBAPI) (bool, error) {
+	key := map[string]*dynamodb.AttributeValue{
+		"email": {
+			S: aws.String(email),
+		},
+	}
+	deleted := false
+	if announce && outbox.Enabled() {
+		condition, names, values := o.condition("attribute_exists(email)")
+		write := &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			Key:                       key,
+			TableName:                 aws.String(tableName),
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}}
+		conditionFailed, err := commitWithOutbox(write, outbox.EventUserDeleted, email, map[string]string{"email": email}, tableName, dynaClient)
+		cache.invalidate(tableName, email)
+		if conditionFailed && o.Condition != "" {
+			return false, errors.New(ErrorConditionFailed)
+		}
+		if err != nil && !conditionFailed {
+			return false, store.Error(err, ErrorFailedToDeleteRecord)
+		}
+		deleted = !conditionFailed
+	} else {
+		condition, names, values := o.condition("")
+		input := &dynamodb.DeleteItemInput{
+			Key:                       key,
+			TableName:                 aws.String(tableName),
+			ReturnValues:              aws.String(dynamodb.ReturnValueAllOld),
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		}
+		result, err := dynaClient.DeleteItem(input)
+		cache.invalidate(tableName, email)
+		if isConditionFailed(err) {
+			return false, errors.New(ErrorConditionFailed)
+		}
+		if err != nil {
+			return false, store.Error(err, ErrorFailedToDeleteRecord)
+		}
+		deleted = result != nil && len(result.Attributes) > 0
+	}
+	if err := deletePreferences(email, tableName, dynaClient); err != nil {
+		return false, store.Error(err, ErrorFailedToDeleteRecord)
+	}
+	if err := credentials.Delete(email, tableName, dynaClient); err != nil {
+		return false, err
+	}
+	if err := session.RevokeAll(email, session.Table(tableName), dynaClient); err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// validate normalises the user's fields and checks them with TagValidator
+// and the configured validators, returning validators.FieldErrors when any
+// are invalid.
+func (u *User) validate() error {
+	u.FirstName = strings.TrimSpace(u.FirstName)
+	u.LastName = strings.TrimSpace(u.LastName)
+	u.Phone = optional(u.Phone, formatPhone)
+	u.DateOfBirth = optional(u.DateOfBirth, strings.TrimSpace)
+	u.JobTitle = optional(u.JobTitle, strings.TrimSpace)
+	u.Company = optional(u.Company, strings.TrimSpace)
+	u.Tags = normaliseTags(u.Tags)
+	if u.Address != nil {
+		u.Address.Country = strings.ToUpper(strings.TrimSpace(u.Address.Country))
+	}
+	return check(*u)
+}
+
+// merge returns the user with the names, profile fields, metadata and tags
+// present in body taken from sent, so clients can update a user one field at
+// a time. A field sent empty or null is cleared.
+func (u User) merge(sent User, body string) User {
+	present := presentFields(body)
+	if present["firstname"] {
+		u.FirstName = sent.FirstName
+	}
+	if present["lastname"] {
+		u.LastName = sent.LastName
+	}
+	if present["phone"] {
+		u.Phone = sent.Phone
+	}
+	if present["dateofbirth"] {
+		u.DateOfBirth = sent.DateOfBirth
+	}
+	if present["address"] {
+		u.Address = sent.Address
+	}
+	if present["jobtitle"] {
+		u.JobTitle = sent.JobTitle
+	}
+	if present["company"] {
+		u.Company = sent.Company
+	}
+	if present["metadata"] {
+		u.Metadata = sent.Metadata
+	}
+	if present["tags"] {
+		u.Tags = sent.Tags
+	}
+	return u
+}
+
+func (u User) auditData() map[string]string {
+	data := map[string]string{
+		"firstName": u.FirstName,
+		"lastName":  u.LastName,
+	}
+	optional := map[string]*string{
+		"phone":       u.Phone,
+		"dateOfBirth": u.DateOfBirth,
+		"jobTitle":    u.JobTitle,
+		"company":     u.Company,
+	}
+	for field, value := range optional {
+		if value != nil {
+			data[field] = *value
+		}
+	}
+	return data
+}
+
+// isConditionFailed reports whether err is a write refused by its condition.
+func isConditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// recordAudit is best effort: the user mutation has already been committed
+// and should not be reported as failed because the audit write was.
+func recordAudit(email string, action string, data map[string]string, actor audit.Actor, tableName string, dynaClient dynamodbiface.DynamoDBAPI) {
+	_ = audit.RecordAs(email, action, data, actor, audit.TableName(tableName), dynaClient)
+}
@@ -1,44 +1,36 @@
 package user
 
 import (
+	"context"
 	"errors"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/audit"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/schema"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
-type mockDynamoDBClient struct {
-	dynamodbiface.DynamoDBAPI
-	deleteErr   error
-	fetchedUser *dynamodb.GetItemOutput
-	fetchErr    error
-	putErr      error
-	scanRes     *dynamodb.ScanOutput
-	scanErr     error
-}
-
-func (m *mockDynamoDBClient) GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
-	return m.fetchedUser, m.fetchErr
-}
-
-func (m *mockDynamoDBClient) PutItem(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
-	return nil, m.putErr
-}
-
-func (m *mockDynamoDBClient) Scan(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
-	return m.scanRes, m.scanErr
-}
+type mockResolver map[string]bool
 
-func (m *mockDynamoDBClient) DeleteItem(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
-	return nil, m.deleteErr
+func (m mockResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if !m[name] {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return []*net.MX{{Host: "mx." + name + ".", Pref: 10}}, nil
 }
 
 func TestCreateUser(t *testing.T) {
 	t.Run("expect error when invalid body is provided", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
+		mockDb := &testutil.MockDynamoDB{}
 
 		_, err := CreateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": , "name": ""}`,
@@ -51,7 +43,7 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 	t.Run("expect error when invalid email is provided", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
+		mockDb := &testutil.MockDynamoDB{}
 
 		_, err := CreateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "invalid-email", "firstName": "test", "lastName": "test"}`,
@@ -59,13 +51,13 @@ func TestCreateUser(t *testing.T) {
 		if err == nil {
 			t.Fatal("Expected error, got nil")
 		}
-		if err.Error() != ErrorInvalidEmail {
-			t.Errorf("Expected error %s, got %s", ErrorInvalidEmail, err.Error())
+		if err.Error() != "email "+validators.ErrorInvalidEmailFormat {
+			t.Errorf("Expected error %s, got %s", "email "+validators.ErrorInvalidEmailFormat, err.Error())
 		}
 	})
 	t.Run("expect error when fetching user to see if it already exists fails", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchErr = errors.New("test error")
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemErr = errors.New("test error")
 
 		_, err := CreateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "alan.shearer@ecs.co.uk", "firstName": "Alan", "lastName": "Shearer"}`,
@@ -78,8 +70,8 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 	t.Run("expect error when user already exists", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchedUser = &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{
 				"email": {
 					S: aws.String("alan.shearer@ecs.co.uk"),
@@ -98,11 +90,11 @@ func TestCreateUser(t *testing.T) {
 		}
 	})
 	t.Run("expect error when creating user fails", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchedUser = &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{},
 		}
-		mockDb.putErr = errors.New("test error")
+		mockDb.PutItemErr = errors.New("test error")
 
 		_, err := CreateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}`,
@@ -114,9 +106,131 @@ func TestCreateUser(t *testing.T) {
 			t.Errorf("Expected error %s, got %s", ErrorCouldNotDynamoPutItem, err.Error())
 		}
 	})
+	t.Run("expect error when a disposable email is provided and they are rejected", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{RejectDisposableEmails: true})
+		defer ConfigureValidation(ValidationConfig{})
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan@mailinator.com", "firstName": "Alan", "lastName": "Oliver"}`,
+		}, "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorDisposableEmail {
+			t.Errorf("Expected error %s, got %s", ErrorDisposableEmail, err.Error())
+		}
+	})
+	t.Run("expect disposable email to be accepted when they are not rejected", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{},
+		}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan@mailinator.com", "firstName": "Alan", "lastName": "Oliver"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+	})
+	t.Run("expect error when the email domain has no MX records", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{MXVerifier: validators.NewMXVerifier(mockResolver{}, time.Second, time.Minute)})
+		defer ConfigureValidation(ValidationConfig{})
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{},
+		}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan@nomail.example", "firstName": "Alan", "lastName": "Oliver"}`,
+		}, "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != ErrorUndeliverableEmail {
+			t.Errorf("Expected error %s, got %s", ErrorUndeliverableEmail, err.Error())
+		}
+		if len(mockDb.PutItemInputs()) != 0 {
+			t.Errorf("Expected no writes, got %d", len(mockDb.PutItemInputs()))
+		}
+	})
+	t.Run("expect user to be created when the email domain has MX records", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{MXVerifier: validators.NewMXVerifier(mockResolver{"ecs.co.uk": true}, time.Second, time.Minute)})
+		defer ConfigureValidation(ValidationConfig{})
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{},
+		}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+	})
+	t.Run("expect error when a name is missing", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "  "}`,
+		}, "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != "lastName "+validators.ErrorNameRequired {
+			t.Errorf("Expected error %s, got %s", "lastName "+validators.ErrorNameRequired, err.Error())
+		}
+	})
+	t.Run("expect error when a name contains invalid characters", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Al4n", "lastName": "Oliver"}`,
+		}, "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != "firstName "+validators.ErrorNameInvalidCharacters {
+			t.Errorf("Expected error %s, got %s", "firstName "+validators.ErrorNameInvalidCharacters, err.Error())
+		}
+	})
+	t.Run("expect error when invalid phone is provided", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "phone": "07700 900123"}`,
+		}, "test", mockDb)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if err.Error() != "phone "+validators.ErrorInvalidPhoneFormat {
+			t.Errorf("Expected error %s, got %s", "phone "+validators.ErrorInvalidPhoneFormat, err.Error())
+		}
+	})
+	t.Run("expect phone to be stored in E.164 format", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{},
+		}
+
+		createdUser, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "phone": "+44 (7700) 900-123"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		if aws.StringValue(createdUser.Phone) != "+447700900123" {
+			t.Errorf("Expected phone %s, got %s", "+447700900123", aws.StringValue(createdUser.Phone))
+		}
+		if *mockDb.PutItemInputs()[0].Item["phone"].S != "+447700900123" {
+			t.Errorf("Expected stored phone %s, got %s", "+447700900123", *mockDb.PutItemInputs()[0].Item["phone"].S)
+		}
+	})
 	t.Run("expect user to be created", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchedUser = &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{},
 		}
 
@@ -136,12 +250,32 @@ func TestCreateUser(t *testing.T) {
 			t.Errorf("Expected lastName %s, got %s", "Oliver", createdUser.LastName)
 		}
 	})
+	t.Run("expect error when metadata breaks a registered attribute", func(t *testing.T) {
+		defer schema.Configure(schema.Config{})
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.ScanOutput = &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{{
+			"name":     {S: aws.String("department")},
+			"type":     {S: aws.String(schema.TypeString)},
+			"required": {BOOL: aws.Bool(true)},
+		}}}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver"}`,
+		}, "schema-test", mockDb)
+		errs, ok := err.(validators.FieldErrors)
+		if !ok || len(errs) != 1 || errs[0].Field != "metadata.department" || errs[0].Rule != validators.RuleRequired {
+			t.Fatalf("Expected the required attribute to be reported, got %v", err)
+		}
+		if mockDb.Count("PutItem") != 0 {
+			t.Error("Expected the user not to be saved")
+		}
+	})
 }
 
 func TestFetchAllUsers(t *testing.T) {
 	t.Run("expect error when fetching users fails", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.scanErr = errors.New("scan error")
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.ScanErr = errors.New("scan error")
 
 		_, err := FetchAllUsers("test", mockDb)
 		if err == nil {
@@ -152,8 +286,8 @@ func TestFetchAllUsers(t *testing.T) {
 		}
 	})
 	t.Run("should return empty list when no users are found", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.scanRes = &dynamodb.ScanOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.ScanOutput = &dynamodb.ScanOutput{
 			Items: []map[string]*dynamodb.AttributeValue{},
 		}
 
@@ -166,8 +300,8 @@ func TestFetchAllUsers(t *testing.T) {
 		}
 	})
 	t.Run("should return list of users", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.scanRes = &dynamodb.ScanOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.ScanOutput = &dynamodb.ScanOutput{
 			Items: []map[string]*dynamodb.AttributeValue{
 				{
 					"email": {
@@ -220,7 +354,7 @@ func TestFetchAllUsers(t *testing.T) {
 
 func TestUpdate(t *testing.T) {
 	t.Run("expect error when request body is invalid", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
+		mockDb := &testutil.MockDynamoDB{}
 
 		_, err := UpdateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "alan.oliver@ecs.co.uk", "firstNam": , "lastName": "Oliver"}`,
@@ -233,8 +367,8 @@ func TestUpdate(t *testing.T) {
 		}
 	})
 	t.Run("expect error when there is an error fetching the user", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchErr = errors.New("fetch error")
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemErr = errors.New("fetch error")
 
 		_, err := UpdateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen", "lastName": "Oliver"}`,
@@ -247,8 +381,8 @@ func TestUpdate(t *testing.T) {
 		}
 	})
 	t.Run("expect error when there is an error updating the user", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchedUser = &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{
 				"email": {
 					S: aws.String("alan.oliver@ecs.co.uk"),
@@ -261,7 +395,7 @@ func TestUpdate(t *testing.T) {
 				},
 			},
 		}
-		mockDb.putErr = errors.New("update error")
+		mockDb.UpdateItemErr = errors.New("update error")
 
 		_, err := UpdateUser(events.APIGatewayProxyRequest{
 			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen", "lastName": "Oliver"}`,
@@ -274,8 +408,8 @@ func TestUpdate(t *testing.T) {
 		}
 	})
 	t.Run("expect to update the user", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.fetchedUser = &dynamodb.GetItemOutput{
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
 			Item: map[string]*dynamodb.AttributeValue{
 				"email": {
 					S: aws.String("alan.oliver@ecs.co.uk"),
@@ -307,12 +441,135 @@ func TestUpdate(t *testing.T) {
 	})
 }
 
+func TestUpdateMerge(t *testing.T) {
+	stored := func() *testutil.MockDynamoDB {
+		return &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+				"firstName": {S: aws.String("Alan")},
+				"lastName":  {S: aws.String("Oliver")},
+				"company":   {S: aws.String("ECS")},
+				"status":    {S: aws.String(StatusActive)},
+				"avatar":    {S: aws.String("avatars/alan.png")},
+				"createdAt": {S: aws.String("2021-01-01T00:00:00Z")},
+			},
+		}}
+	}
+
+	t.Run("expect error when the user does not exist", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen"}`,
+		}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Fatalf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+		if mockDb.Count("PutItem") != 0 {
+			t.Errorf("Expected no user to be written, got %d", mockDb.Count("PutItem"))
+		}
+	})
+	t.Run("expect error when no email is given", func(t *testing.T) {
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"firstName": "Allen"}`,
+		}, "test", stored())
+		if err == nil || err.Error() != ErrorEmailRequired {
+			t.Fatalf("Expected error %s, got %v", ErrorEmailRequired, err)
+		}
+	})
+	t.Run("expect error when the user is deleted before the write", func(t *testing.T) {
+		mockDb := stored()
+		mockDb.UpdateItemErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen"}`,
+		}, "test", mockDb)
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Fatalf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+		if mockDb.UpdateItemInputs()[0].ConditionExpression == nil {
+			t.Error("Expected the write to be conditional on the user existing")
+		}
+	})
+	t.Run("expect only the fields sent to change", func(t *testing.T) {
+		mockDb := stored()
+
+		updatedUser, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "Alan.Oliver@ecs.co.uk", "firstName": "Allen", "status": "suspended", "avatar": "avatars/other.png"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if updatedUser.FirstName != "Allen" || updatedUser.LastName != "Oliver" || aws.StringValue(updatedUser.Company) != "ECS" {
+			t.Errorf("Expected the first name alone to change, got %+v", *updatedUser)
+		}
+		if updatedUser.Status != StatusActive || updatedUser.Avatar != "avatars/alan.png" || updatedUser.CreatedAt != "2021-01-01T00:00:00Z" {
+			t.Errorf("Expected fields only the service sets to be kept, got %+v", *updatedUser)
+		}
+		if !aws.BoolValue(mockDb.GetItemInputs()[0].ConsistentRead) {
+			t.Error("Expected the stored user to be read consistently")
+		}
+		if mockDb.Count("PutItem") != 1 || mockDb.Count("UpdateItem") != 1 {
+			t.Fatalf("Expected the user to be updated in place and audited, got %v", mockDb.Calls())
+		}
+		update := mockDb.UpdateItemInputs()[0]
+		if *update.UpdateExpression != "SET #firstName = :firstName" || *update.ExpressionAttributeValues[":firstName"].S != "Allen" {
+			t.Errorf("Expected the first name alone to be written, got %s", *update.UpdateExpression)
+		}
+	})
+	t.Run("expect a suspended user not to be updated", func(t *testing.T) {
+		mockDb := stored()
+		mockDb.GetItemOutput.Item["status"] = &dynamodb.AttributeValue{S: aws.String(StatusSuspended)}
+
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Allen"}`,
+		}, "test", mockDb)
+		if err == nil || err.Error() != ErrorAccountSuspended {
+			t.Fatalf("Expected error %s, got %v", ErrorAccountSuspended, err)
+		}
+		if mockDb.Count("UpdateItem") != 0 || mockDb.Count("PutItem") != 0 {
+			t.Errorf("Expected nothing to be written, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("expect an unchanged user not to be written", func(t *testing.T) {
+		mockDb := stored()
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "company": "ECS"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if mockDb.Count("UpdateItem") != 0 || mockDb.Count("PutItem") != 0 {
+			t.Errorf("Expected nothing to be written, got %v", mockDb.Calls())
+		}
+	})
+	t.Run("expect a required field sent empty to be refused", func(t *testing.T) {
+		_, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "lastName": ""}`,
+		}, "test", stored())
+		var fieldErrs validators.FieldErrors
+		if !errors.As(err, &fieldErrs) {
+			t.Fatalf("Expected field errors, got %v", err)
+		}
+	})
+}
+
 func TestDeleteUser(t *testing.T) {
+	deleted := func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+		return &dynamodb.DeleteItemOutput{Attributes: map[string]*dynamodb.AttributeValue{
+			"email": {S: aws.String("alan.oliver@ecs.co.uk")},
+		}}, nil
+	}
+
 	t.Run("expect error when there is an error deleting the user", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
-		mockDb.deleteErr = errors.New("delete error")
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.DeleteItemErr = errors.New("delete error")
 
-		err := DeleteUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+		err := DeleteUser(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{
+				"email": "alan.oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
 
 		if err == nil {
 			t.Fatal("Expected error, got nil")
@@ -321,11 +578,56 @@ func TestDeleteUser(t *testing.T) {
 			t.Errorf("Expected error %s, got %s", ErrorFailedToDeleteRecord, err.Error())
 		}
 	})
+	t.Run("expect error when no email is given", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		err := DeleteUser(events.APIGatewayProxyRequest{}, "test", mockDb)
+
+		if err == nil || err.Error() != ErrorEmailRequired {
+			t.Fatalf("Expected error %s, got %v", ErrorEmailRequired, err)
+		}
+		if mockDb.Count("DeleteItem") != 0 {
+			t.Errorf("Expected no delete to be issued, got %d", mockDb.Count("DeleteItem"))
+		}
+	})
+	t.Run("expect error when there was no user to delete", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+
+		err := DeleteUser(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{
+				"email": "alan.oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
+
+		if err == nil || err.Error() != ErrorUserNotFound {
+			t.Fatalf("Expected error %s, got %v", ErrorUserNotFound, err)
+		}
+	})
 	t.Run("expect to delete the user", func(t *testing.T) {
-		mockDb := &mockDynamoDBClient{}
+		mockDb := &testutil.MockDynamoDB{DeleteItemFunc: deleted}
 
 		err := DeleteUser(events.APIGatewayProxyRequest{
 			PathParameters: map[string]string{
+				"email": "Alan.Oliver@ecs.co.uk",
+			},
+		}, "test", mockDb)
+
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err.Error())
+		}
+		input := mockDb.DeleteItemInputs()[0]
+		if *input.Key["email"].S != "alan.oliver@ecs.co.uk" {
+			t.Errorf("Expected the lowercase email to be deleted, got %s", *input.Key["email"].S)
+		}
+		if aws.StringValue(input.ReturnValues) != dynamodb.ReturnValueAllOld {
+			t.Errorf("Expected the deleted item to be returned, got %v", input.ReturnValues)
+		}
+	})
+	t.Run("expect the email query string parameter to be accepted", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{DeleteItemFunc: deleted}
+
+		err := DeleteUser(events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{
 				"email": "alan.oliver@ecs.co.uk",
 			},
 		}, "test", mockDb)
@@ -335,3 +637,257 @@ func TestDeleteUser(t *testing.T) {
 		}
 	})
 }
+
+func TestFetchUserConsistentRead(t *testing.T) {
+	ConfigureCache(CacheConfig{Size: 10, TTL: time.Minute})
+	defer ConfigureCache(CacheConfig{})
+
+	t.Run("should request a consistent read that bypasses the cache", func(t *testing.T) {
+		mockDb := &consistentReadClient{}
+		FetchUser("alan.oliver@ecs.co.uk", "consistent", mockDb)
+		FetchUserWithOptions("alan.oliver@ecs.co.uk", "consistent", mockDb, FetchOptions{ConsistentRead: true})
+
+		if len(mockDb.reads) != 2 {
+			t.Fatalf("expected 2 GetItem calls, got %d", len(mockDb.reads))
+		}
+		if mockDb.reads[0] {
+			t.Error("expected the default read to be eventually consistent")
+		}
+		if !mockDb.reads[1] {
+			t.Error("expected a consistent read")
+		}
+	})
+	t.Run("should look up the user by their lowercased email", func(t *testing.T) {
+		mockDb := &consistentReadClient{}
+		u, err := FetchUserWithOptions(" Alan.Oliver@ECS.co.uk", "consistent", mockDb, FetchOptions{ConsistentRead: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if u.Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("expected alan.oliver@ecs.co.uk to be fetched, got %s", u.Email)
+		}
+	})
+}
+
+func TestFetchUsers(t *testing.T) {
+	t.Run("should read the users in one batch, in order, leaving out emails without one", func(t *testing.T) {
+		client := newEmailTableClient()
+		client.table("test")["alan.oliver@ecs.co.uk"] = userItem("alan.oliver@ecs.co.uk")
+		client.table("test")["ada@ecs.co.uk"] = userItem("ada@ecs.co.uk")
+		client.table("test")["al@ecs.co.uk"] = map[string]*dynamodb.AttributeValue{
+			"email":   {S: aws.String("al@ecs.co.uk")},
+			"movedTo": {S: aws.String("alan.oliver@ecs.co.uk")},
+		}
+		users, err := FetchUsers([]string{"ada@ecs.co.uk", "missing@ecs.co.uk", "al@ecs.co.uk", "alan.oliver@ecs.co.uk"}, "test", client)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if len(users) != 2 || users[0].Email != "ada@ecs.co.uk" || users[1].Email != "alan.oliver@ecs.co.uk" {
+			t.Errorf("expected ada and alan in order, got %+v", users)
+		}
+	})
+	t.Run("should fail when a batch cannot be read", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{BatchGetItemFunc: func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+			return nil, errors.New("test error")
+		}}
+		if _, err := FetchUsers([]string{"alan.oliver@ecs.co.uk"}, "test", mockDb); err == nil || err.Error() != ErrorFailedToFetchRecord {
+			t.Errorf("expected error %s, got %v", ErrorFailedToFetchRecord, err)
+		}
+	})
+}
+
+type consistentReadClient struct {
+	testutil.MockDynamoDB
+	reads []bool
+}
+
+func (m *consistentReadClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.reads = append(m.reads, aws.BoolValue(input.ConsistentRead))
+	return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"email": {S: input.Key["email"].S},
+	}}, nil
+}
+
+func TestProfile(t *testing.T) {
+	t.Run("expect invalid profile fields to be reported", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+
+		_, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "dateOfBirth": "01/02/1980", "address": {"line1": "1 High Street", "postalCode": "SW1A 1AA", "country": "GBR"}}`,
+		}, "test", mockDb)
+		expected := "dateOfBirth " + validators.ErrorInvalidDateFormat + "; address.city " + validators.ErrorFieldRequired + "; address.country " + validators.ErrorInvalidCountryFormat
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %s, got %v", expected, err)
+		}
+	})
+	t.Run("expect profile fields to be stored", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{}}
+
+		createdUser, err := CreateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "dateOfBirth": "1980-02-01", "jobTitle": " Engineer ", "company": "ECS", "address": {"line1": "1 High Street", "city": "London", "postalCode": "SW1A 1AA", "country": "gb"}, "metadata": {"crmId": "12345"}}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(createdUser.JobTitle) != "Engineer" || createdUser.Address.Country != "GB" {
+			t.Errorf("Expected normalised profile, got %q and %q", aws.StringValue(createdUser.JobTitle), createdUser.Address.Country)
+		}
+		stored := mockDb.PutItemInputs()[0].Item
+		if *stored["dateOfBirth"].S != "1980-02-01" {
+			t.Errorf("Expected stored dateOfBirth %s, got %s", "1980-02-01", *stored["dateOfBirth"].S)
+		}
+		if *stored["metadata"].M["crmId"].S != "12345" {
+			t.Errorf("Expected stored metadata crmId %s, got %s", "12345", *stored["metadata"].M["crmId"].S)
+		}
+		if *stored["address"].M["city"].S != "London" {
+			t.Errorf("Expected stored city %s, got %s", "London", *stored["address"].M["city"].S)
+		}
+	})
+	t.Run("expect profile fields left out of an update to be kept", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+				"firstName": {S: aws.String("Alan")},
+				"lastName":  {S: aws.String("Oliver")},
+				"jobTitle":  {S: aws.String("Engineer")},
+				"company":   {S: aws.String("ECS")},
+			},
+		}
+
+		updatedUser, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Oliver", "jobTitle": "Architect", "company": ""}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(updatedUser.JobTitle) != "Architect" {
+			t.Errorf("Expected jobTitle %s, got %s", "Architect", aws.StringValue(updatedUser.JobTitle))
+		}
+		if updatedUser.Company != nil {
+			t.Errorf("Expected company to be cleared, got %s", *updatedUser.Company)
+		}
+
+		mockDb.Reset()
+		updatedUser, err = UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "firstName": "Alan", "lastName": "Smith"}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if aws.StringValue(updatedUser.JobTitle) != "Engineer" || aws.StringValue(updatedUser.Company) != "ECS" {
+			t.Errorf("Expected the stored profile to be kept, got %q and %q", aws.StringValue(updatedUser.JobTitle), aws.StringValue(updatedUser.Company))
+		}
+	})
+	t.Run("expect a profile field sent null to be removed and one left out to be untouched", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		mockDb.GetItemOutput = &dynamodb.GetItemOutput{
+			Item: map[string]*dynamodb.AttributeValue{
+				"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+				"firstName": {S: aws.String("Alan")},
+				"lastName":  {S: aws.String("Oliver")},
+				"jobTitle":  {S: aws.String("Engineer")},
+				"company":   {S: aws.String("ECS")},
+			},
+		}
+
+		updatedUser, err := UpdateUser(events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.oliver@ecs.co.uk", "company": null, "phone": "  "}`,
+		}, "test", mockDb)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if updatedUser.Company != nil || updatedUser.Phone != nil || aws.StringValue(updatedUser.JobTitle) != "Engineer" {
+			t.Errorf("Expected only the company to be cleared, got %+v", updatedUser)
+		}
+		input := mockDb.UpdateItemInputs()[0]
+		expression := aws.StringValue(input.UpdateExpression)
+		if expression != "REMOVE #company" {
+			t.Errorf("Expected only the company to be removed, got %s", expression)
+		}
+	})
+}
+
+func TestActors(t *testing.T) {
+	actorOf := func(item map[string]*dynamodb.AttributeValue, name string) string {
+		if item[name] == nil {
+			return ""
+		}
+		return aws.StringValue(item[name].S)
+	}
+
+	t.Run("expect the Cognito user to be recorded as who created the user", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		req := events.APIGatewayProxyRequest{
+			Body: `{"email": "alan.shearer@ecs.co.uk", "firstName": "Alan", "lastName": "Shearer", "createdBy": "someone"}`,
+		}
+		req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"sub": "0f7e1c"}}
+		req.RequestContext.Identity.APIKeyID = "a1b2c3"
+		if _, err := CreateUser(req, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		puts := mockDb.PutItemInputs()
+		if len(puts) != 2 || actorOf(puts[0].Item, "createdBy") != "cognito:0f7e1c" || actorOf(puts[1].Item, "actor") != "cognito:0f7e1c" {
+			t.Errorf("Expected the user and audit entry to name the Cognito user, got %v", puts)
+		}
+	})
+	t.Run("expect the request a change was made with to be audited", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+			return &dynamodb.DeleteItemOutput{Attributes: existingUserItem().Item}, nil
+		}}
+		req := events.APIGatewayProxyRequest{PathParameters: map[string]string{"email": "alan.oliver@ecs.co.uk"}}
+		req.Headers = map[string]string{"user-agent": "curl/8.4.0"}
+		req.RequestContext.RequestID = "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"
+		req.RequestContext.Identity.SourceIP = "203.0.113.7"
+		if err := DeleteUser(req, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		puts := mockDb.PutItemInputs()
+		var entry audit.Entry
+		dynamodbattribute.UnmarshalMap(puts[len(puts)-1].Item, &entry)
+		want := audit.Request{ID: "c6af9ac6-7b61-11e6-9a41-93e8deadbeef", SourceIP: "203.0.113.7", UserAgent: "curl/8.4.0"}
+		if entry.Action != audit.ActionDeleted || entry.Request == nil || *entry.Request != want {
+			t.Errorf("Expected the deletion to be audited with %+v, got %+v", want, entry)
+		}
+	})
+	t.Run("expect no request to be audited for a change made without one", func(t *testing.T) {
+		mockDb := &testutil.MockDynamoDB{}
+		if _, err := Create(User{Email: "alan.shearer@ecs.co.uk", FirstName: "Alan", LastName: "Shearer"}, "test", mockDb); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if item := mockDb.PutItemInputs()[1].Item; item["request"] != nil || item["actor"] != nil {
+			t.Errorf("Expected no actor or request, got %v", item)
+		}
+	})
+	t.Run("expect the API key, then the source IP, to be recorded as who updated the user", func(t *testing.T) {
+		for identity, want := range map[events.APIGatewayRequestIdentity]string{
+			{APIKeyID: "a1b2c3", SourceIP: "203.0.113.7"}: "apikey:a1b2c3",
+			{SourceIP: "203.0.113.7"}:                     "ip:203.0.113.7",
+			{}:                                            "",
+		} {
+			mockDb := &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+				"email":     {S: aws.String("alan.oliver@ecs.co.uk")},
+				"firstName": {S: aws.String("Alan")},
+				"lastName":  {S: aws.String("Oliver")},
+				"createdBy": {S: aws.String("ip:198.51.100.1")},
+				"updatedBy": {S: aws.String("ip:198.51.100.1")},
+			}}}
+			req := events.APIGatewayProxyRequest{Body: `{"email": "alan.oliver@ecs.co.uk", "updatedBy": "someone"}`}
+			req.RequestContext.Identity = identity
+			if _, err := UpdateUser(req, "test", mockDb); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+			update := mockDb.UpdateItemInputs()[0]
+			expression := "SET #updatedBy = :updatedBy"
+			if want == "" {
+				expression = "REMOVE #updatedBy"
+			}
+			if *update.UpdateExpression != expression || actorOf(update.ExpressionAttributeValues, ":updatedBy") != want {
+				t.Errorf("Expected the user to name %q, got %s", want, *update.UpdateExpression)
+			}
+			if actorOf(mockDb.PutItemInputs()[0].Item, "actor") != want {
+				t.Errorf("Expected the audit entry to name %q, got %v", want, mockDb.PutItemInputs())
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"strings"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+)
+
+// Validator checks a user as it is created or updated, returning an entry
+// for each field it finds invalid, or nil when the user is valid to it.
+// Deployments add their own rules, such as which domains users may sign up
+// from, as Validators in ValidationConfig rather than by changing this
+// package.
+type Validator interface {
+	Validate(u User) validators.FieldErrors
+}
+
+// ValidatorFunc is a Validator written as a function.
+type ValidatorFunc func(u User) validators.FieldErrors
+
+func (f ValidatorFunc) Validate(u User) validators.FieldErrors {
+	return f(u)
+}
+
+// TagValidator checks a user against the rules in its struct tags. Users
+// are always checked by it first.
+var TagValidator Validator = ValidatorFunc(func(u User) validators.FieldErrors {
+	return validators.ValidateStruct(u)
+})
+
+// EmailDomains accepts only emails at one of domains or a subdomain of one,
+// such as a company's own.
+func EmailDomains(domains ...string) Validator {
+	allowed := validators.NewDomainBlocklist(domains)
+	return ValidatorFunc(func(u User) validators.FieldErrors {
+		i := strings.LastIndex(u.Email, "@")
+		if i >= 0 && allowed.Contains(u.Email[i+1:]) {
+			return nil
+		}
+		return validators.FieldErrors{{Field: "email", Rule: validators.RuleDomain, Code: validators.CodeNotAllowed, Message: validators.ErrorDomainNotAllowed}}
+	})
+}
+
+// BannedNames refuses first and last names that are one of names, whatever
+// their case.
+func BannedNames(names ...string) Validator {
+	banned := make(map[string]bool, len(names))
+	for _, name := range names {
+		banned[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return ValidatorFunc(func(u User) validators.FieldErrors {
+		errs := validators.FieldErrors{}
+		for _, field := range []struct{ name, value string }{{"firstName", u.FirstName}, {"lastName", u.LastName}} {
+			if banned[strings.ToLower(field.value)] {
+				errs = append(errs, &validators.FieldError{Field: field.name, Rule: validators.RuleName, Code: validators.CodeNotAllowed, Message: validators.ErrorNameNotAllowed})
+			}
+		}
+		if len(errs) == 0 {
+			return nil
+		}
+		return errs
+	})
+}
+
+// check runs u through TagValidator and then the configured validators in
+// order, reporting the first error found for each field.
+func check(u User) error {
+	errs := validators.FieldErrors{}
+	reported := map[string]bool{}
+	for _, v := range append([]Validator{TagValidator}, validation.Validators...) {
+		for _, fieldErr := range v.Validate(u) {
+			if !reported[fieldErr.Field] {
+				reported[fieldErr.Field] = true
+				errs = append(errs, fieldErr)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,75 @@
+package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+)
+
+func TestValidators(t *testing.T) {
+	defer ConfigureValidation(ValidationConfig{})
+	alan := User{Email: "alan.oliver@ecs.co.uk", FirstName: "Alan", LastName: "Oliver"}
+	fieldErrors := func(t *testing.T, err error) validators.FieldErrors {
+		var errs validators.FieldErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("Expected field errors, got %v", err)
+		}
+		return errs
+	}
+
+	t.Run("expect only emails at the allowed domains and their subdomains", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{Validators: []Validator{EmailDomains("ecs.co.uk")}})
+		if _, err := Validate(alan); err != nil {
+			t.Errorf("Expected no error, got %s", err.Error())
+		}
+		sub := alan
+		sub.Email = "alan.oliver@london.ecs.co.uk"
+		if _, err := Validate(sub); err != nil {
+			t.Errorf("Expected a subdomain to be allowed, got %s", err.Error())
+		}
+		other := alan
+		other.Email = "alan.oliver@example.com"
+		_, err := Validate(other)
+		errs := fieldErrors(t, err)
+		if len(errs) != 1 || errs[0].Field != "email" || errs[0].Rule != validators.RuleDomain || errs[0].Code != validators.CodeNotAllowed {
+			t.Errorf("Expected the email's domain to be refused, got %v", errs)
+		}
+	})
+	t.Run("expect banned names to be refused whatever their case", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{Validators: []Validator{BannedNames("Admin", "root")}})
+		banned := alan
+		banned.FirstName, banned.LastName = "ADMIN", "Root"
+		_, err := Validate(banned)
+		if errs := fieldErrors(t, err); len(errs) != 2 || errs[0].Field != "firstName" || errs[1].Field != "lastName" {
+			t.Errorf("Expected both names to be refused, got %v", errs)
+		}
+	})
+	t.Run("expect validators to run in order after the struct tags with one error per field", func(t *testing.T) {
+		calls := 0
+		ConfigureValidation(ValidationConfig{Validators: []Validator{
+			ValidatorFunc(func(u User) validators.FieldErrors {
+				calls++
+				return validators.FieldErrors{{Field: "firstName", Rule: "custom", Code: "custom", Message: "is custom"}}
+			}),
+		}})
+		invalid := alan
+		invalid.FirstName = "Al4n"
+		_, err := Validate(invalid)
+		errs := fieldErrors(t, err)
+		if calls != 1 || len(errs) != 1 || errs[0].Rule != validators.RuleName {
+			t.Errorf("Expected the struct tag's error alone, got %v", errs)
+		}
+	})
+	t.Run("expect a user refused by a validator not to be created", func(t *testing.T) {
+		ConfigureValidation(ValidationConfig{Validators: []Validator{EmailDomains("example.com")}})
+		mockDb := &testutil.MockDynamoDB{}
+		if _, err := Create(alan, "test", mockDb); err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+		if mockDb.Count("PutItem") != 0 {
+			t.Errorf("Expected nothing to be written, got %d writes", mockDb.Count("PutItem"))
+		}
+	})
+}
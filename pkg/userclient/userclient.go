@@ -0,0 +1,140 @@
+// Package userclient is for other Lambdas to call the users function
+// directly, sending it the commands pkg/command answers.
+package userclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/command"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/user"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/validators"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// Error is a command the function answered as failed. Code is the one the
+// REST endpoints answer for the same error, e.g. "user_not_found", and
+// Fields lists each invalid field of a user that failed validation.
+type Error struct {
+	Message string
+	Code    string
+	Fields  validators.FieldErrors
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Client invokes the users function by name or ARN.
+type Client struct {
+	Lambda       lambdaiface.LambdaAPI
+	FunctionName string
+}
+
+func New(lambdaClient lambdaiface.LambdaAPI, functionName string) *Client {
+	return &Client{Lambda: lambdaClient, FunctionName: functionName}
+}
+
+// ListOptions picks the users ListUsers lists.
+type ListOptions struct {
+	Tag    string
+	Fields []string
+	// Limit stops the list after that many users
+	Limit int
+	// After resumes a list from the Next of an earlier page
+	After *user.ScanPosition
+}
+
+// Page is one page of users. Next is set when there are more.
+type Page struct {
+	Users []user.User
+	Next  *user.ScanPosition
+}
+
+// GetUser returns the user with email. A user that does not exist is an
+// *Error with code "user_not_found".
+func (c *Client) GetUser(ctx context.Context, email string, fields ...string) (*user.User, error) {
+	result, err := c.send(ctx, command.Command{Op: command.OpGetUser, Email: email, Fields: fields})
+	if err != nil {
+		return nil, err
+	}
+	return result.User, nil
+}
+
+func (c *Client) ListUsers(ctx context.Context, options ListOptions) (*Page, error) {
+	result, err := c.send(ctx, command.Command{
+		Op:     command.OpListUsers,
+		Tag:    options.Tag,
+		Fields: options.Fields,
+		Limit:  options.Limit,
+		After:  options.After,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Users: result.Users, Next: result.Next}, nil
+}
+
+func (c *Client) CreateUser(ctx context.Context, u user.User) (*user.User, error) {
+	body, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.send(ctx, command.Command{Op: command.OpCreateUser, User: body})
+	if err != nil {
+		return nil, err
+	}
+	return result.User, nil
+}
+
+// UpdateUser merges fields, keyed by their JSON name and including the
+// user's email, onto the stored user. Fields left out keep their values and
+// fields sent empty are cleared.
+func (c *Client) UpdateUser(ctx context.Context, fields map[string]interface{}) (*user.User, error) {
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.send(ctx, command.Command{Op: command.OpUpdateUser, User: body})
+	if err != nil {
+		return nil, err
+	}
+	return result.User, nil
+}
+
+func (c *Client) DeleteUser(ctx context.Context, email string) error {
+	_, err := c.send(ctx, command.Command{Op: command.OpDeleteUser, Email: email})
+	return err
+}
+
+// send invokes the function with cmd and waits for its result. A failed
+// command is returned as an *Error; any other error means the command may
+// not have run.
+func (c *Client) send(ctx context.Context, cmd command.Command) (*command.Result, error) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.Lambda.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(c.FunctionName),
+		Payload:      payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.FunctionError != nil {
+		return nil, fmt.Errorf("%s invocation failed: %s: %s", c.FunctionName, *output.FunctionError, output.Payload)
+	}
+	var result command.Result
+	if err := json.Unmarshal(output.Payload, &result); err != nil {
+		return nil, err
+	}
+	if result.Code != "" {
+		return nil, &Error{Message: result.Error, Code: result.Code, Fields: result.Errors}
+	}
+	return &result, nil
+}
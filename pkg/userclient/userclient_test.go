@@ -0,0 +1,68 @@
+package userclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/command"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// lambdaClient answers invocations as the users function would, from a
+// table faked by db.
+type lambdaClient struct {
+	lambdaiface.LambdaAPI
+	db       *testutil.MockDynamoDB
+	function string
+}
+
+func (c *lambdaClient) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	c.function = *input.FunctionName
+	cmd, ok := command.ParseEvent(input.Payload)
+	if !ok {
+		return &lambda.InvokeOutput{FunctionError: aws.String("Unhandled"), Payload: []byte(`{"errorMessage":"not a command"}`)}, nil
+	}
+	payload, _ := json.Marshal(command.Handle(cmd, "test", c.db))
+	return &lambda.InvokeOutput{Payload: payload}, nil
+}
+
+func TestGetUser(t *testing.T) {
+	invoker := &lambdaClient{db: &testutil.MockDynamoDB{GetItemOutput: &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"email":    {S: aws.String("alan.oliver@ecs.co.uk")},
+		"lastName": {S: aws.String("Oliver")},
+	}}}}
+	u, err := New(invoker, "users").GetUser(context.Background(), "alan.oliver@ecs.co.uk")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if u.LastName != "Oliver" || invoker.function != "users" {
+		t.Errorf("Expected the user from the users function, got %+v from %s", u, invoker.function)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Run("expect a failed command to be returned with its code", func(t *testing.T) {
+		client := New(&lambdaClient{db: &testutil.MockDynamoDB{}}, "users")
+		_, err := client.GetUser(context.Background(), "alan.oliver@ecs.co.uk")
+		var cmdErr *Error
+		if !errors.As(err, &cmdErr) || cmdErr.Code != "user_not_found" {
+			t.Errorf("Expected user_not_found, got %v", err)
+		}
+	})
+	t.Run("expect a failed invocation not to be taken for a failed command", func(t *testing.T) {
+		client := New(&lambdaClient{db: &testutil.MockDynamoDB{}}, "users")
+		_, err := client.send(context.Background(), command.Command{})
+		var cmdErr *Error
+		if err == nil || errors.As(err, &cmdErr) {
+			t.Errorf("Expected the invocation to fail, got %v", err)
+		}
+	})
+}
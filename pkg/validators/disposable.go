@@ -0,0 +1,73 @@
+package validators
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomainsFile string
+
+// DisposableEmailDomains is the blocklist used by IsEmailDisposable. It starts
+// with the embedded list and can be extended at runtime with Add.
+var DisposableEmailDomains = NewDomainBlocklist(parseDomainList(disposableDomainsFile))
+
+type DomainBlocklist struct {
+	mu      sync.RWMutex
+	domains map[string]bool
+}
+
+func NewDomainBlocklist(domains []string) *DomainBlocklist {
+	b := &DomainBlocklist{domains: map[string]bool{}}
+	b.Add(domains...)
+	return b
+}
+
+func (b *DomainBlocklist) Add(domains ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			b.domains[domain] = true
+		}
+	}
+}
+
+// Contains reports whether domain, or any parent domain of it, is listed.
+func (b *DomainBlocklist) Contains(domain string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	domain = strings.ToLower(domain)
+	for {
+		if b.domains[domain] {
+			return true
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+func IsEmailDisposable(email string) bool {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	return DisposableEmailDomains.Contains(email[i+1:])
+}
+
+func parseDomainList(list string) []string {
+	domains := []string{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains
+}
@@ -0,0 +1,109 @@
+package validators
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+type MXResult struct {
+	HasMX bool
+	Err   error
+}
+
+type mxCacheEntry struct {
+	hasMX   bool
+	expires time.Time
+}
+
+// MXVerifier checks that an email domain publishes MX records. Definitive
+// answers are cached for the TTL so warm containers don't repeat lookups;
+// timeouts and other transient DNS failures are returned but not cached.
+type MXVerifier struct {
+	resolver MXResolver
+	timeout  time.Duration
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]mxCacheEntry
+}
+
+func NewMXVerifier(resolver MXResolver, timeout time.Duration, ttl time.Duration) *MXVerifier {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &MXVerifier{
+		resolver: resolver,
+		timeout:  timeout,
+		ttl:      ttl,
+		now:      time.Now,
+		cache:    map[string]mxCacheEntry{},
+	}
+}
+
+func (v *MXVerifier) HasMX(ctx context.Context, domain string) (bool, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if hasMX, ok := v.cached(domain); ok {
+		return hasMX, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+	records, err := v.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false, err
+		}
+	}
+
+	hasMX := false
+	for _, record := range records {
+		// A single "." host is a null MX (RFC 7505): the domain accepts no mail
+		if record.Host != "." && record.Host != "" {
+			hasMX = true
+		}
+	}
+	v.store(domain, hasMX)
+	return hasMX, nil
+}
+
+// VerifyEmail starts the MX lookup for the email's domain in the background
+// so callers can overlap it with other work.
+func (v *MXVerifier) VerifyEmail(ctx context.Context, email string) <-chan MXResult {
+	result := make(chan MXResult, 1)
+	go func() {
+		i := strings.LastIndex(email, "@")
+		if i < 0 {
+			result <- MXResult{}
+			return
+		}
+		hasMX, err := v.HasMX(ctx, email[i+1:])
+		result <- MXResult{HasMX: hasMX, Err: err}
+	}()
+	return result
+}
+
+func (v *MXVerifier) cached(domain string) (bool, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.cache[domain]
+	if !ok || v.now().After(entry.expires) {
+		return false, false
+	}
+	return entry.hasMX, true
+}
+
+func (v *MXVerifier) store(domain string, hasMX bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cache[domain] = mxCacheEntry{hasMX: hasMX, expires: v.now().Add(v.ttl)}
+}
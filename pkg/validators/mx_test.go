@@ -0,0 +1,93 @@
+package validators
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type mockResolver struct {
+	records map[string][]*net.MX
+	err     error
+	calls   int
+}
+
+func (m *mockResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	records, ok := m.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestMXVerifier(t *testing.T) {
+	t.Run("should report domains with MX records", func(t *testing.T) {
+		resolver := &mockResolver{records: map[string][]*net.MX{
+			"ecs.co.uk": {{Host: "mx.ecs.co.uk.", Pref: 10}},
+		}}
+		verifier := NewMXVerifier(resolver, time.Second, time.Minute)
+
+		result := <-verifier.VerifyEmail(context.Background(), "alan.oliver@ECS.co.uk")
+		if result.Err != nil {
+			t.Fatalf("expected nil, got %s", result.Err.Error())
+		}
+		if !result.HasMX {
+			t.Errorf("expected ecs.co.uk to have MX records")
+		}
+	})
+	t.Run("should report missing and null MX records", func(t *testing.T) {
+		resolver := &mockResolver{records: map[string][]*net.MX{
+			"nomail.example": {{Host: ".", Pref: 0}},
+		}}
+		verifier := NewMXVerifier(resolver, time.Second, time.Minute)
+
+		for _, domain := range []string{"nomail.example", "missing.example"} {
+			hasMX, err := verifier.HasMX(context.Background(), domain)
+			if err != nil {
+				t.Fatalf("expected nil for %s, got %s", domain, err.Error())
+			}
+			if hasMX {
+				t.Errorf("expected %s not to have MX records", domain)
+			}
+		}
+	})
+	t.Run("should cache answers until the TTL expires", func(t *testing.T) {
+		resolver := &mockResolver{records: map[string][]*net.MX{
+			"ecs.co.uk": {{Host: "mx.ecs.co.uk.", Pref: 10}},
+		}}
+		verifier := NewMXVerifier(resolver, time.Second, time.Minute)
+		now := time.Now()
+		verifier.now = func() time.Time { return now }
+
+		verifier.HasMX(context.Background(), "ecs.co.uk")
+		verifier.HasMX(context.Background(), "ecs.co.uk")
+		if resolver.calls != 1 {
+			t.Errorf("expected %d lookup, got %d", 1, resolver.calls)
+		}
+
+		now = now.Add(2 * time.Minute)
+		verifier.HasMX(context.Background(), "ecs.co.uk")
+		if resolver.calls != 2 {
+			t.Errorf("expected %d lookups after expiry, got %d", 2, resolver.calls)
+		}
+	})
+	t.Run("should return and not cache lookup failures", func(t *testing.T) {
+		resolver := &mockResolver{err: errors.New("i/o timeout")}
+		verifier := NewMXVerifier(resolver, time.Second, time.Minute)
+
+		for i := 0; i < 2; i++ {
+			if _, err := verifier.HasMX(context.Background(), "ecs.co.uk"); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		}
+		if resolver.calls != 2 {
+			t.Errorf("expected %d lookups, got %d", 2, resolver.calls)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package validators
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxNameLength = 50
+
+var (
+	ErrorNameControlCharacters = "must not contain control characters"
+	ErrorNameInvalidCharacters = "may only contain letters, spaces, hyphens and apostrophes"
+	ErrorNameNotAllowed        = "is not allowed"
+	ErrorNameRequired          = "is required"
+	ErrorNameTooLong           = "must be at most 50 characters"
+)
+
+// FieldError reports which field of a record failed validation, the rule it
+// broke and why.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Param is the rule's parameter, e.g. the limit of a max rule
+	Param string `json:"-"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidateName checks a first or last name, returning a *FieldError for field
+// when it is invalid. Surrounding whitespace is expected to be trimmed already.
+func ValidateName(field string, name string) error {
+	if len(name) == 0 {
+		return &FieldError{Field: field, Rule: RuleRequired, Code: CodeRequired, Message: ErrorNameRequired}
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return &FieldError{Field: field, Rule: RuleMax, Code: CodeTooLong, Message: ErrorNameTooLong, Param: strconv.Itoa(MaxNameLength)}
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return &FieldError{Field: field, Rule: RuleName, Code: CodeControlCharacters, Message: ErrorNameControlCharacters}
+		}
+	}
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) || r == ' ' || r == '-' || r == '\'' || r == '’' {
+			continue
+		}
+		return &FieldError{Field: field, Rule: RuleName, Code: CodeInvalidCharacters, Message: ErrorNameInvalidCharacters}
+	}
+	return nil
+}
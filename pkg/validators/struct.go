@@ -0,0 +1,382 @@
+package validators
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	RuleBoolean  = "boolean"
+	RuleCountry  = "country"
+	RuleDate     = "date"
+	RuleDomain   = "domain"
+	RuleEmail    = "email"
+	RuleMax      = "max"
+	RuleMetadata = "metadata"
+	RuleMin      = "min"
+	RuleName     = "name"
+	RuleNumber   = "number"
+	RuleOneOf    = "oneof"
+	RulePast     = "past"
+	RulePhone    = "phone"
+	RuleRequired = "required"
+	RuleTags     = "tags"
+)
+
+// Machine readable codes reported alongside each field error.
+const (
+	CodeControlCharacters = "control_characters"
+	CodeInFuture          = "in_future"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeInvalidFormat     = "invalid_format"
+	CodeInvalidKey        = "invalid_key"
+	CodeNotAllowed        = "not_allowed"
+	CodeRequired          = "required"
+	CodeTooLarge          = "too_large"
+	CodeTooLong           = "too_long"
+	CodeTooShort          = "too_short"
+	CodeTooSmall          = "too_small"
+	CodeUnknownKey        = "unknown_key"
+	CodeValueTooLong      = "value_too_long"
+)
+
+var (
+	ErrorDateInFuture         = "must not be in the future"
+	ErrorFieldRequired        = "is required"
+	ErrorInvalidBooleanFormat = "must be true or false"
+	ErrorInvalidCountryFormat = "must be an ISO 3166-1 alpha-2 country code"
+	ErrorDomainNotAllowed     = "must be at an allowed domain"
+	ErrorInvalidDateFormat    = "must be a date in the form YYYY-MM-DD"
+	ErrorInvalidEmailFormat   = "must be a valid email address"
+	ErrorInvalidNumberFormat  = "must be a number"
+	ErrorInvalidPhoneFormat   = "must be a valid E.164 phone number"
+)
+
+// Limits of the metadata rule. The size counts the bytes of every key and
+// value, keeping the map well inside DynamoDB's item size limit.
+const (
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 256
+	MaxMetadataSize        = 4096
+)
+
+var (
+	ErrorInvalidMetadataKey   = "keys may only contain letters, digits, '_', '-' and '.' and be at most 64 characters"
+	ErrorMetadataTooLarge     = "must be at most 4096 bytes in total"
+	ErrorMetadataValueTooLong = "values must be at most 256 characters"
+	ErrorUnknownMetadataKey   = "is not a registered attribute"
+)
+
+// MaxTagLength is the longest tag the tags rule accepts.
+const MaxTagLength = 32
+
+var ErrorInvalidTag = "tags may only contain lowercase letters, digits, '_', '-' and ':' and be at most 32 characters"
+
+// DateLayout is the form of dates checked by the date and past rules.
+const DateLayout = "2006-01-02"
+
+// A rule returns the code and message describing a failure, and whether the
+// value passed.
+type rule func(value reflect.Value, param string) (string, string, bool)
+
+var rules = map[string]rule{
+	RuleCountry: stringRule(func(s string, _ string) (string, string, bool) {
+		return CodeInvalidFormat, ErrorInvalidCountryFormat, IsCountryValid(s)
+	}),
+	RuleDate: stringRule(func(s string, _ string) (string, string, bool) {
+		_, err := time.Parse(DateLayout, s)
+		return CodeInvalidFormat, ErrorInvalidDateFormat, err == nil
+	}),
+	RuleEmail: stringRule(func(s string, _ string) (string, string, bool) {
+		return CodeInvalidFormat, ErrorInvalidEmailFormat, IsEmailValid(s)
+	}),
+	RuleMax:      maxRule,
+	RuleMetadata: metadataRule,
+	RuleMin:      minRule,
+	RuleName:     stringRule(nameRule),
+	RuleOneOf:    stringRule(oneOfRule),
+	RulePast:     stringRule(pastRule),
+	RulePhone: stringRule(func(s string, _ string) (string, string, bool) {
+		return CodeInvalidFormat, ErrorInvalidPhoneFormat, IsPhoneValid(s)
+	}),
+	RuleTags: tagsRule,
+}
+
+// FieldErrors is the result of validating a struct, one entry per invalid
+// field in declaration order.
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateStruct checks every field of s against the rules in its validate
+// tag, e.g. `validate:"required,max=50"`. Rules other than required are
+// skipped for empty values, and only the first failing rule of a field is
+// reported. Nested structs are validated with their fields prefixed by the
+// parent's name. It returns nil when s is valid.
+func ValidateStruct(s interface{}) FieldErrors {
+	errs := validateStruct(reflect.ValueOf(s), "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStruct(v reflect.Value, prefix string) FieldErrors {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	errs := FieldErrors{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := prefix + fieldName(field)
+		value := v.Field(i)
+
+		tag := field.Tag.Get("validate")
+		if tag != "" && tag != "-" {
+			if fieldErr := validateField(name, value, tag); fieldErr != nil {
+				errs = append(errs, fieldErr)
+				continue
+			}
+		}
+		if indirectKind(value) == reflect.Struct {
+			errs = append(errs, validateStruct(value, name+".")...)
+		}
+	}
+	return errs
+}
+
+func validateField(name string, value reflect.Value, tag string) *FieldError {
+	// Optional fields are pointers, checked by the value they point to
+	if value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() != reflect.Struct {
+		value = value.Elem()
+	}
+	for _, r := range strings.Split(tag, ",") {
+		ruleName, param := r, ""
+		if i := strings.Index(r, "="); i >= 0 {
+			ruleName, param = r[:i], r[i+1:]
+		}
+
+		if ruleName == RuleRequired {
+			if isEmpty(value) {
+				return &FieldError{Field: name, Rule: RuleRequired, Code: CodeRequired, Message: ErrorFieldRequired}
+			}
+			continue
+		}
+		if isEmpty(value) {
+			return nil
+		}
+
+		check, ok := rules[ruleName]
+		if !ok {
+			panic(fmt.Sprintf("validators: unknown rule %q on field %s", ruleName, name))
+		}
+		if code, message, ok := check(value, param); !ok {
+			if ruleName == RuleOneOf {
+				param = strings.Join(strings.Fields(param), ", ")
+			}
+			return &FieldError{Field: name, Rule: ruleName, Code: code, Message: message, Param: param}
+		}
+	}
+	return nil
+}
+
+func stringRule(check func(s string, param string) (string, string, bool)) rule {
+	return func(value reflect.Value, param string) (string, string, bool) {
+		if value.Kind() != reflect.String {
+			return "", "", true
+		}
+		return check(value.String(), param)
+	}
+}
+
+func nameRule(s string, _ string) (string, string, bool) {
+	if err := ValidateName("", s); err != nil {
+		fieldErr := err.(*FieldError)
+		return fieldErr.Code, fieldErr.Message, false
+	}
+	return "", "", true
+}
+
+// pastRule passes dates that cannot be parsed, which the date rule reports.
+func pastRule(s string, _ string) (string, string, bool) {
+	date, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return "", "", true
+	}
+	return CodeInFuture, ErrorDateInFuture, !date.After(time.Now().UTC())
+}
+
+// metadataRule checks a map of custom string key/value data against the
+// metadata limits.
+func metadataRule(value reflect.Value, _ string) (string, string, bool) {
+	if value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String || value.Type().Elem().Kind() != reflect.String {
+		return "", "", true
+	}
+	keys := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+
+	size := 0
+	for _, key := range keys {
+		v := value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key())).String()
+		if !IsMetadataKeyValid(key) {
+			return CodeInvalidKey, ErrorInvalidMetadataKey, false
+		}
+		if utf8.RuneCountInString(v) > MaxMetadataValueLength {
+			return CodeValueTooLong, ErrorMetadataValueTooLong, false
+		}
+		size += len(key) + len(v)
+	}
+	if size > MaxMetadataSize {
+		return CodeTooLarge, ErrorMetadataTooLarge, false
+	}
+	return "", "", true
+}
+
+// IsMetadataKeyValid reports whether key may name a metadata entry.
+func IsMetadataKeyValid(key string) bool {
+	if key == "" || len(key) > MaxMetadataKeyLength {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// tagsRule checks every tag in a list of strings.
+func tagsRule(value reflect.Value, _ string) (string, string, bool) {
+	if value.Kind() != reflect.Slice || value.Type().Elem().Kind() != reflect.String {
+		return "", "", true
+	}
+	for i := 0; i < value.Len(); i++ {
+		if !IsTag(value.Index(i).String()) {
+			return CodeInvalidFormat, ErrorInvalidTag, false
+		}
+	}
+	return "", "", true
+}
+
+// IsTag reports whether tag is a valid user tag, e.g. "beta" or "plan:pro".
+func IsTag(tag string) bool {
+	if tag == "" || len(tag) > MaxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func oneOfRule(s string, param string) (string, string, bool) {
+	options := strings.Fields(param)
+	for _, option := range options {
+		if s == option {
+			return "", "", true
+		}
+	}
+	return CodeNotAllowed, "must be one of " + strings.Join(options, ", "), false
+}
+
+func maxRule(value reflect.Value, param string) (string, string, bool) {
+	limit, size := ruleLimit(value, param)
+	if value.Kind() == reflect.String {
+		return CodeTooLong, fmt.Sprintf("must be at most %d characters", limit), size <= limit
+	}
+	return CodeTooLarge, fmt.Sprintf("must be at most %d", limit), size <= limit
+}
+
+func minRule(value reflect.Value, param string) (string, string, bool) {
+	limit, size := ruleLimit(value, param)
+	if value.Kind() == reflect.String {
+		return CodeTooShort, fmt.Sprintf("must be at least %d characters", limit), size >= limit
+	}
+	return CodeTooSmall, fmt.Sprintf("must be at least %d", limit), size >= limit
+}
+
+// ruleLimit parses the min/max parameter and measures value against it:
+// strings by character count, collections by length and numbers by value.
+func ruleLimit(value reflect.Value, param string) (int64, int64) {
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validators: invalid limit %q", param))
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return limit, int64(utf8.RuneCountInString(value.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return limit, int64(value.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return limit, value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return limit, int64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return limit, int64(value.Float())
+	}
+	return limit, limit
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+func indirectKind(value reflect.Value) reflect.Kind {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return reflect.Invalid
+		}
+		return value.Elem().Kind()
+	}
+	return value.Kind()
+}
+
+func fieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" || tag == "-" {
+		return field.Name
+	}
+	return tag
+}
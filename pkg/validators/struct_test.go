@@ -0,0 +1,166 @@
+package validators
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	Country string `json:"country" validate:"required,oneof=GB FR ES"`
+}
+
+type testEntity struct {
+	Email    string       `json:"email" validate:"required,email"`
+	Nickname string       `json:"nickname,omitempty" validate:"min=2,max=5"`
+	Role     string       `json:"role" validate:"oneof=admin member"`
+	Tags     []string     `json:"tags" validate:"max=2"`
+	Age      int          `json:"age" validate:"min=18"`
+	Address  *testAddress `json:"address,omitempty"`
+	internal string       `validate:"required"`
+}
+
+type testProfile struct {
+	Born    string `json:"born" validate:"date,past"`
+	Country string `json:"country" validate:"country"`
+}
+
+func TestValidateStruct(t *testing.T) {
+	t.Run("should return nil for a valid struct", func(t *testing.T) {
+		errs := ValidateStruct(&testEntity{Email: "alan.oliver@ecs.co.uk", Role: "admin", Age: 30})
+		if errs != nil {
+			t.Fatalf("expected nil, got %s", errs.Error())
+		}
+	})
+	t.Run("should report every invalid field in declaration order", func(t *testing.T) {
+		errs := ValidateStruct(testEntity{
+			Nickname: "a",
+			Role:     "owner",
+			Tags:     []string{"a", "b", "c"},
+			Age:      17,
+			Address:  &testAddress{Country: "DE"},
+		})
+		expected := []FieldError{
+			{Field: "email", Rule: RuleRequired, Code: CodeRequired, Message: "is required"},
+			{Field: "nickname", Rule: RuleMin, Code: CodeTooShort, Message: "must be at least 2 characters", Param: "2"},
+			{Field: "role", Rule: RuleOneOf, Code: CodeNotAllowed, Message: "must be one of admin, member", Param: "admin, member"},
+			{Field: "tags", Rule: RuleMax, Code: CodeTooLarge, Message: "must be at most 2", Param: "2"},
+			{Field: "age", Rule: RuleMin, Code: CodeTooSmall, Message: "must be at least 18", Param: "18"},
+			{Field: "address.country", Rule: RuleOneOf, Code: CodeNotAllowed, Message: "must be one of GB, FR, ES", Param: "GB, FR, ES"},
+		}
+		if len(errs) != len(expected) {
+			t.Fatalf("expected %d errors, got %d: %s", len(expected), len(errs), errs.Error())
+		}
+		for i, fieldErr := range errs {
+			if *fieldErr != expected[i] {
+				t.Errorf("expected error %d to be %+v, got %+v", i, expected[i], *fieldErr)
+			}
+		}
+	})
+	t.Run("should only report the first failing rule of a field", func(t *testing.T) {
+		errs := ValidateStruct(testEntity{Email: "not-an-email", Age: 18})
+		if len(errs) != 1 {
+			t.Fatalf("expected %d error, got %d", 1, len(errs))
+		}
+		if errs.Error() != "email must be a valid email address" {
+			t.Errorf("expected %q, got %q", "email must be a valid email address", errs.Error())
+		}
+	})
+	t.Run("should check dates and country codes", func(t *testing.T) {
+		tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(DateLayout)
+		cases := []struct {
+			value    testProfile
+			expected string
+		}{
+			{testProfile{Born: "1980-02-01", Country: "GB"}, ""},
+			{testProfile{Born: "1980-02-30"}, "born " + ErrorInvalidDateFormat},
+			{testProfile{Born: tomorrow}, "born " + ErrorDateInFuture},
+			{testProfile{Country: "gb"}, "country " + ErrorInvalidCountryFormat},
+		}
+		for _, c := range cases {
+			errs := ValidateStruct(c.value)
+			if c.expected == "" && errs != nil {
+				t.Errorf("expected %+v to be valid, got %s", c.value, errs.Error())
+			}
+			if c.expected != "" && (errs == nil || errs.Error() != c.expected) {
+				t.Errorf("expected %q for %+v, got %v", c.expected, c.value, errs)
+			}
+		}
+	})
+	t.Run("should check metadata keys, values and size", func(t *testing.T) {
+		type entity struct {
+			Metadata map[string]string `json:"metadata" validate:"metadata"`
+		}
+		large := map[string]string{}
+		for i := 0; i < 20; i++ {
+			large[strings.Repeat("k", i+1)] = strings.Repeat("v", 250)
+		}
+		cases := []struct {
+			metadata map[string]string
+			code     string
+		}{
+			{map[string]string{"crm.id": "12345", "source_system": "salesforce"}, ""},
+			{map[string]string{"crm id": "12345"}, CodeInvalidKey},
+			{map[string]string{strings.Repeat("k", 65): "v"}, CodeInvalidKey},
+			{map[string]string{"notes": strings.Repeat("é", 257)}, CodeValueTooLong},
+			{large, CodeTooLarge},
+		}
+		for _, c := range cases {
+			errs := ValidateStruct(entity{Metadata: c.metadata})
+			if c.code == "" && errs != nil {
+				t.Errorf("expected metadata to be valid, got %s", errs.Error())
+			}
+			if c.code != "" && (len(errs) != 1 || errs[0].Code != c.code) {
+				t.Errorf("expected code %q, got %v", c.code, errs)
+			}
+		}
+	})
+	t.Run("should check every tag", func(t *testing.T) {
+		type entity struct {
+			Tags []string `json:"tags" validate:"tags"`
+		}
+		cases := []struct {
+			tags  []string
+			valid bool
+		}{
+			{[]string{"beta", "plan:pro", "early_adopter"}, true},
+			{[]string{"beta", "Beta"}, false},
+			{[]string{"two words"}, false},
+			{[]string{strings.Repeat("t", 33)}, false},
+		}
+		for _, c := range cases {
+			errs := ValidateStruct(entity{Tags: c.tags})
+			if c.valid && errs != nil {
+				t.Errorf("expected %v to be valid, got %s", c.tags, errs.Error())
+			}
+			if !c.valid && (len(errs) != 1 || errs[0].Code != CodeInvalidFormat) {
+				t.Errorf("expected %v to be invalid, got %v", c.tags, errs)
+			}
+		}
+	})
+	t.Run("should check optional fields by the value they point to", func(t *testing.T) {
+		type entity struct {
+			Nickname *string `json:"nickname,omitempty" validate:"min=2,max=5"`
+		}
+		short, long := "al", "alexander"
+		if errs := ValidateStruct(entity{}); errs != nil {
+			t.Errorf("expected a missing field to be valid, got %s", errs.Error())
+		}
+		if errs := ValidateStruct(entity{Nickname: &short}); errs != nil {
+			t.Errorf("expected %q to be valid, got %s", short, errs.Error())
+		}
+		if errs := ValidateStruct(entity{Nickname: &long}); len(errs) != 1 || errs[0].Rule != RuleMax {
+			t.Errorf("expected %q to be too long, got %v", long, errs)
+		}
+	})
+	t.Run("should panic on an unknown rule", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected a panic")
+			}
+		}()
+		ValidateStruct(struct {
+			Name string `validate:"uppercase"`
+		}{Name: "alan"})
+	})
+}
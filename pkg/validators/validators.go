@@ -1,12 +1,78 @@
 package validators
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
 
-func IsEmailValid(email string) bool {
-	var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]{1,64}@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+// The patterns are compiled on first use rather than at init, keeping them
+// off the cold start of containers that never validate.
+var (
+	compileOnce sync.Once
+	rxEmail     *regexp.Regexp
+	rxPhone     *regexp.Regexp
+)
+
+func compile() {
+	compileOnce.Do(func() {
+		rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]{1,64}@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+		rxPhone = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	})
+}
 
+func IsEmailValid(email string) bool {
+	compile()
 	if len(email) < 3 || len(email) > 254 || !rxEmail.MatchString(email) {
 		return false
 	}
 	return true
 }
+
+// IsCountryValid checks the form of an ISO 3166-1 alpha-2 code, such as GB.
+func IsCountryValid(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, r := range country {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func IsPhoneValid(phone string) bool {
+	_, ok := FormatPhone(phone)
+	return ok
+}
+
+// FormatPhone normalises a phone number written with common separators, or an
+// international 00 prefix, into E.164 form such as +447700900123.
+func FormatPhone(phone string) (string, bool) {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+
+	var b strings.Builder
+	for i, r := range phone {
+		switch {
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			continue
+		default:
+			return "", false
+		}
+	}
+
+	formatted := b.String()
+	compile()
+	if !rxPhone.MatchString(formatted) {
+		return "", false
+	}
+	return formatted, true
+}
@@ -0,0 +1,98 @@
+package validators
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatPhone(t *testing.T) {
+	valid := map[string]string{
+		"+447700900123":      "+447700900123",
+		"+44 7700 900123":    "+447700900123",
+		"+1 (415) 555-2671":  "+14155552671",
+		"0044 7700.900.123":  "+447700900123",
+		" +33 1 23 45 67 89": "+33123456789",
+	}
+	for input, expected := range valid {
+		got, ok := FormatPhone(input)
+		if !ok {
+			t.Errorf("expected %q to be valid", input)
+		}
+		if got != expected {
+			t.Errorf("expected %q to format as %q, got %q", input, expected, got)
+		}
+	}
+
+	invalid := []string{"", "07700900123", "+0447700900123", "+4", "+44 7700 900123 ext 1", "+1234567890123456", "44+7700900123"}
+	for _, input := range invalid {
+		if IsPhoneValid(input) {
+			t.Errorf("expected %q to be invalid", input)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"Alan", "Jean-Luc", "O'Brien", "D’Angelo", "Zoë", "Mary Ann", "Łukasz", "José", "张伟"}
+	for _, name := range valid {
+		if err := ValidateName("firstName", name); err != nil {
+			t.Errorf("expected %q to be valid, got %s", name, err.Error())
+		}
+	}
+
+	invalid := map[string]string{
+		"":                      ErrorNameRequired,
+		"Alan\x00":              ErrorNameControlCharacters,
+		"Al\tan":                ErrorNameControlCharacters,
+		"Alan1":                 ErrorNameInvalidCharacters,
+		"<script>":              ErrorNameInvalidCharacters,
+		"Alan!":                 ErrorNameInvalidCharacters,
+		strings.Repeat("a", 51): ErrorNameTooLong,
+		strings.Repeat("ö", 51): ErrorNameTooLong,
+	}
+	for name, message := range invalid {
+		err := ValidateName("lastName", name)
+		if err == nil {
+			t.Errorf("expected %q to be invalid", name)
+			continue
+		}
+		if err.Error() != "lastName "+message {
+			t.Errorf("expected error %q for %q, got %q", "lastName "+message, name, err.Error())
+		}
+	}
+	if err := ValidateName("lastName", strings.Repeat("ö", 50)); err != nil {
+		t.Errorf("expected a 50 character name to be valid, got %s", err.Error())
+	}
+}
+
+func TestIsEmailDisposable(t *testing.T) {
+	disposable := []string{"someone@mailinator.com", "someone@YOPMAIL.com", "someone@inbox.guerrillamail.com"}
+	for _, email := range disposable {
+		if !IsEmailDisposable(email) {
+			t.Errorf("expected %q to be disposable", email)
+		}
+	}
+
+	real := []string{"alan.oliver@ecs.co.uk", "someone@notmailinator.com", "someone@mailinator.com.example.org", "not-an-email"}
+	for _, email := range real {
+		if IsEmailDisposable(email) {
+			t.Errorf("expected %q not to be disposable", email)
+		}
+	}
+}
+
+func TestDomainBlocklist(t *testing.T) {
+	blocklist := NewDomainBlocklist([]string{"example.com"})
+	if blocklist.Contains("example.org") {
+		t.Fatalf("expected example.org not to be blocked")
+	}
+	blocklist.Add(" Example.ORG ")
+	if !blocklist.Contains("mail.example.org") {
+		t.Errorf("expected mail.example.org to be blocked once example.org is added")
+	}
+}
+
+func BenchmarkIsEmailValid(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		IsEmailValid("alan.oliver@ecs.co.uk")
+	}
+}
@@ -0,0 +1,45 @@
+package warmup
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// SourceScheduled is the source of EventBridge and CloudWatch Events
+	// scheduled rules
+	SourceScheduled = "aws.events"
+	SourcePlugin    = "serverless-plugin-warmup"
+)
+
+type event struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+	Warmup     bool   `json:"warmup"`
+}
+
+// IsEvent reports whether payload is a keep-alive ping rather than an API
+// Gateway request: a scheduled rule, the serverless warmup plugin, or any
+// payload carrying "warmup": true.
+func IsEvent(payload []byte) bool {
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return false
+	}
+	if e.Warmup || e.Source == SourcePlugin {
+		return true
+	}
+	return e.Source == SourceScheduled && e.DetailType == "Scheduled Event"
+}
+
+// Touch makes a cheap call to the table so the container's connections to
+// DynamoDB are open before the next request arrives.
+func Touch(tableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	_, err := dynaClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	return err
+}
@@ -0,0 +1,47 @@
+package warmup
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestIsEvent(t *testing.T) {
+	cases := map[string]bool{
+		`{"source": "aws.events", "detail-type": "Scheduled Event", "detail": {}}`: true,
+		`{"source": "serverless-plugin-warmup"}`:                                   true,
+		`{"warmup": true}`:                                                         true,
+		`{"source": "aws.events", "detail-type": "EC2 Instance State-change"}`:     false,
+		`{"httpMethod": "GET", "path": "/", "body": "{\"warmup\": true}"}`:         false,
+		`{"warmup": false}`: false,
+		`not json`:          false,
+	}
+	for payload, expected := range cases {
+		if got := IsEvent([]byte(payload)); got != expected {
+			t.Errorf("expected %t for %s, got %t", expected, payload, got)
+		}
+	}
+}
+
+type describeClient struct {
+	dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func (m *describeClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	m.table = *input.TableName
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func TestTouch(t *testing.T) {
+	t.Run("should describe the table", func(t *testing.T) {
+		mockDb := &describeClient{}
+		if err := Touch("test", mockDb); err != nil {
+			t.Fatalf("expected no error, got %s", err.Error())
+		}
+		if mockDb.table != "test" {
+			t.Errorf("expected table %q, got %q", "test", mockDb.table)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256,
+// keyed with the webhook's secret, of the timestamp, a dot and the body, so
+// receivers can reject deliveries that were altered or replayed.
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+)
+
+// Outcomes of a delivery.
+const (
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrorFailedToFetchDeliveries = "failed to fetch webhook deliveries"
+	ErrorFailedToLogDelivery     = "failed to log webhook delivery"
+)
+
+// HTTPClient sends deliveries. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config enables webhooks and controls their delivery. Each delivery is
+// attempted up to MaxAttempts times, waiting RetryDelay before the first
+// retry and twice as long before each one after it, and each attempt is
+// given Timeout. Deliveries are logged for LogTTL. Zero values take the
+// defaults below.
+type Config struct {
+	Enabled     bool
+	Client      HTTPClient
+	MaxAttempts int
+	RetryDelay  time.Duration
+	Timeout     time.Duration
+	LogTTL      time.Duration
+}
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultRetryDelay  = 200 * time.Millisecond
+	DefaultTimeout     = 5 * time.Second
+	DefaultLogTTL      = 30 * 24 * time.Hour
+)
+
+var config Config
+
+// Enabled reports whether webhooks are delivered.
+func Enabled() bool {
+	return config.Enabled
+}
+
+func Configure(c Config) {
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = DefaultRetryDelay
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.LogTTL <= 0 {
+		c.LogTTL = DefaultLogTTL
+	}
+	config = c
+}
+
+// Payload is the body of a delivery.
+type Payload struct {
+	ID         string      `json:"id"`
+	Event      string      `json:"event"`
+	OccurredAt string      `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+// Delivery is the log entry of one payload sent to one webhook.
+type Delivery struct {
+	ID             string `json:"id"`
+	Event          string `json:"event"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus int    `json:"responseStatus,omitempty"`
+	Error          string `json:"error,omitempty"`
+	DeliveredAt    string `json:"deliveredAt"`
+}
+
+type deliveryItem struct {
+	PK string `json:"pk"`
+	SK string `json:"sk"`
+	Delivery
+	// TTL lets DynamoDB remove the entry once it has been kept for LogTTL
+	TTL int64 `json:"-" dynamodbav:"ttl"`
+}
+
+// Publisher delivers the messages the outbox relay drains to the webhooks
+// subscribed to them, so a delivery is only made once the change it
+// announces has been committed, and never holds up the request that made
+// it. It is an outbox.Publisher.
+type Publisher struct {
+	TableName string
+	Client    dynamodbiface.DynamoDBAPI
+}
+
+// Publish delivers each message in turn to every webhook subscribed to its
+// event, at the same time, and logs each delivery. Deliveries are best
+// effort: Publish only fails when the webhooks cannot be read, so the relay
+// publishes the batch again. A message published again is delivered under
+// the same ID, its own, so receivers can ignore deliveries they have seen.
+func (p Publisher) Publish(messages []outbox.Message) error {
+	if !config.Enabled {
+		return nil
+	}
+	webhooks, err := fetchAll(p.TableName, p.Client)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		var wg sync.WaitGroup
+		for _, w := range webhooks {
+			if !w.subscribes(m.Event) {
+				continue
+			}
+			wg.Add(1)
+			go func(w Webhook) {
+				defer wg.Done()
+				delivery := deliver(w, m)
+				_ = logDelivery(w.ID, delivery, p.TableName, p.Client)
+			}(w)
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// deliver posts m's payload to w, retrying network errors, throttling and
+// server errors. Other client errors are not retried, as they would only
+// fail again.
+func deliver(w Webhook, m outbox.Message) Delivery {
+	delivery := Delivery{ID: m.ID, Event: m.Event, Status: StatusFailed, DeliveredAt: time.Now().UTC().Format(time.RFC3339Nano)}
+	occurredAt := m.CreatedAt
+	if created, err := time.Parse(time.RFC3339Nano, m.CreatedAt); err == nil {
+		occurredAt = created.Format(time.RFC3339)
+	}
+	data := json.RawMessage(m.Payload)
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	body, err := json.Marshal(Payload{ID: m.ID, Event: m.Event, OccurredAt: occurredAt, Data: data})
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	delay := config.RetryDelay
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		delivery.Attempts = attempt
+		status, err := post(w, m.Event, m.ID, body)
+		delivery.ResponseStatus = status
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+			continue
+		}
+		if status >= 200 && status < 300 {
+			delivery.Status = StatusDelivered
+			return delivery
+		}
+		if status != http.StatusTooManyRequests && status < 500 {
+			return delivery
+		}
+	}
+	return delivery
+}
+
+func post(w Webhook, event string, id string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, id)
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(w.Secret, timestamp, body))
+	resp, err := config.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature of a delivery of body at timestamp, for
+// receivers to compare with the X-Webhook-Signature header.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func logDelivery(id string, delivery Delivery, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	item := deliveryItem{
+		PK:       webhookPrefix + id,
+		SK:       deliveryPrefix + delivery.DeliveredAt + "#" + delivery.ID,
+		Delivery: delivery,
+		TTL:      time.Now().Add(config.LogTTL).Unix(),
+	}
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return errors.New(ErrorFailedToLogDelivery)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return store.Error(err, ErrorFailedToLogDelivery)
+	}
+	return nil
+}
+
+// FetchDeliveries reads the delivery log of a webhook, newest first.
+func FetchDeliveries(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Delivery, error) {
+	if !config.Enabled {
+		return nil, errors.New(ErrorWebhooksNotConfigured)
+	}
+	if _, err := fetch(id, userTableName, dynaClient); err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :delivery)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk":       {S: aws.String(webhookPrefix + id)},
+			":delivery": {S: aws.String(deliveryPrefix)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		TableName:        aws.String(TableName(userTableName)),
+	}
+	deliveries := []Delivery{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchDeliveries)
+		}
+		page := []deliveryItem{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalWebhook)
+		}
+		for _, item := range page {
+			deliveries = append(deliveries, item.Delivery)
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return deliveries, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
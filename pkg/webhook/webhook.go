@@ -0,0 +1,261 @@
+// Package webhook lets integrations register URLs to be told when users
+// are created, updated, deleted or merged. Deliveries are made by the outbox
+// relay from the messages written with each change, so webhooks need the
+// outbox enabled. Each delivery is signed with the webhook's secret, retried
+// while the receiver fails, and logged.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+	EventUserMerged  = "user.merged"
+)
+
+var events = map[string]bool{EventUserCreated: true, EventUserUpdated: true, EventUserDeleted: true, EventUserMerged: true}
+
+// Webhooks and their deliveries share a table. Every item of a webhook is
+// stored under the webhook's partition key: the webhook itself with the sort
+// key metadataKey and each delivery with deliveryPrefix followed by when it
+// was made, so a single query reads a webhook's log in order.
+const (
+	webhookPrefix  = "WEBHOOK#"
+	deliveryPrefix = "DELIVERY#"
+	metadataKey    = "METADATA"
+)
+
+var (
+	ErrorFailedToDeleteWebhook    = "failed to delete webhook"
+	ErrorFailedToFetchWebhook     = "failed to fetch webhook"
+	ErrorFailedToMarshalWebhook   = "failed to marshal webhook"
+	ErrorFailedToSaveWebhook      = "failed to save webhook"
+	ErrorFailedToUnmarshalWebhook = "failed to unmarshal webhook"
+	ErrorInvalidWebhookData       = "invalid webhook data"
+	ErrorInvalidWebhookEvents     = "unknown or missing webhook events"
+	ErrorInvalidWebhookURL        = "webhook url must be an absolute https url"
+	ErrorWebhookNotFound          = "webhook not found"
+	ErrorWebhooksNotConfigured    = "webhooks are not configured"
+)
+
+// Webhook is a URL deliveries of Events are posted to. Secret signs them; it
+// is only returned when the webhook is registered.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+type webhookItem struct {
+	PK string `json:"pk"`
+	SK string `json:"sk"`
+	Webhook
+}
+
+// TableName returns the webhooks table that accompanies a users table.
+func TableName(userTableName string) string {
+	return userTableName + "Webhook"
+}
+
+func webhookKey(id string, sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(webhookPrefix + id)},
+		"sk": {S: aws.String(sk)},
+	}
+}
+
+// Register stores a new webhook. A secret is generated when none is given,
+// and returned along with the webhook so the receiver can check signatures.
+func Register(w Webhook, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Webhook, error) {
+	if !config.Enabled {
+		return nil, errors.New(ErrorWebhooksNotConfigured)
+	}
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, errors.New(ErrorFailedToSaveWebhook)
+	}
+	if w.Secret == "" {
+		if w.Secret, err = newID(); err != nil {
+			return nil, errors.New(ErrorFailedToSaveWebhook)
+		}
+	}
+	w.ID = id
+	w.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	av, err := dynamodbattribute.MarshalMap(webhookItem{
+		PK:      webhookPrefix + id,
+		SK:      metadataKey,
+		Webhook: w,
+	})
+	if err != nil {
+		return nil, errors.New(ErrorFailedToMarshalWebhook)
+	}
+	_, err = dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToSaveWebhook)
+	}
+	return &w, nil
+}
+
+// Fetch reads a webhook, without its secret.
+func Fetch(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Webhook, error) {
+	if !config.Enabled {
+		return nil, errors.New(ErrorWebhooksNotConfigured)
+	}
+	w, err := fetch(id, userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+func fetch(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) (*Webhook, error) {
+	result, err := dynaClient.GetItem(&dynamodb.GetItemInput{
+		Key:       webhookKey(id, metadataKey),
+		TableName: aws.String(TableName(userTableName)),
+	})
+	if err != nil {
+		return nil, store.Error(err, ErrorFailedToFetchWebhook)
+	}
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorWebhookNotFound)
+	}
+	item := new(webhookItem)
+	if err := dynamodbattribute.UnmarshalMap(result.Item, item); err != nil {
+		return nil, errors.New(ErrorFailedToUnmarshalWebhook)
+	}
+	return &item.Webhook, nil
+}
+
+// FetchAll reads every webhook, without their secrets.
+func FetchAll(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Webhook, error) {
+	if !config.Enabled {
+		return nil, errors.New(ErrorWebhooksNotConfigured)
+	}
+	webhooks, err := fetchAll(userTableName, dynaClient)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func fetchAll(userTableName string, dynaClient dynamodbiface.DynamoDBAPI) ([]Webhook, error) {
+	// Every webhook is stored under the sort key metadataKey, so they are
+	// read from the index keyed by it rather than by scanning past their
+	// deliveries
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName(userTableName)),
+		IndexName:              aws.String(store.SortKeyIndex),
+		KeyConditionExpression: aws.String("sk = :sk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sk": {S: aws.String(metadataKey)},
+		},
+	}
+	webhooks := []Webhook{}
+	for {
+		result, err := dynaClient.Query(input)
+		if err != nil {
+			return nil, store.Error(err, ErrorFailedToFetchWebhook)
+		}
+		page := []webhookItem{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, errors.New(ErrorFailedToUnmarshalWebhook)
+		}
+		for _, item := range page {
+			webhooks = append(webhooks, item.Webhook)
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return webhooks, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// Delete removes a webhook. Its delivery log is left to expire.
+func Delete(id string, userTableName string, dynaClient dynamodbiface.DynamoDBAPI) error {
+	if !config.Enabled {
+		return errors.New(ErrorWebhooksNotConfigured)
+	}
+	result, err := dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:          webhookKey(id, metadataKey),
+		TableName:    aws.String(TableName(userTableName)),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		return store.Error(err, ErrorFailedToDeleteWebhook)
+	}
+	if len(result.Attributes) == 0 {
+		return errors.New(ErrorWebhookNotFound)
+	}
+	return nil
+}
+
+// validate requires an https URL, as deliveries carry user data, and at
+// least one known event. Events are sorted and deduplicated.
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New(ErrorInvalidWebhookURL)
+	}
+	if len(w.Events) == 0 {
+		return errors.New(ErrorInvalidWebhookEvents)
+	}
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, event := range w.Events {
+		if !events[event] {
+			return errors.New(ErrorInvalidWebhookEvents)
+		}
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+	sort.Strings(unique)
+	w.Events = unique
+	return nil
+}
+
+func (w Webhook) subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/outbox"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/store"
+	"github.com/alrobwilloliver/aws-lambda-in-golang/pkg/testutil"
+)
+
+// newClient returns a client keeping the webhooks table in memory.
+func newClient() *testutil.MemoryDynamoDB {
+	return testutil.NewMemoryDynamoDB(testutil.Table{
+		Name:         TableName("test"),
+		PartitionKey: "pk",
+		SortKey:      "sk",
+		Indexes:      []testutil.Index{{Name: store.SortKeyIndex, PartitionKey: "sk", SortKey: "pk"}},
+	})
+}
+
+// receiver answers deliveries with statuses in turn, repeating the last,
+// and keeps the requests it was sent.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	err      error
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) Do(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.err != nil {
+		return nil, r.err
+	}
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func configure(t *testing.T, client HTTPClient) {
+	Configure(Config{Enabled: true, Client: client, RetryDelay: time.Millisecond})
+	t.Cleanup(func() { config = Config{} })
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Run("expect a registered webhook to be read back without its secret", func(t *testing.T) {
+		configure(t, &receiver{statuses: []int{http.StatusOK}})
+		client := newClient()
+		w, err := Register(Webhook{URL: "https://example.com/hooks", Events: []string{EventUserUpdated, EventUserCreated, EventUserUpdated}}, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if w.ID == "" || w.Secret == "" {
+			t.Errorf("Expected an id and a generated secret, got %+v", *w)
+		}
+		if strings.Join(w.Events, ",") != "user.created,user.updated" {
+			t.Errorf("Expected sorted unique events, got %v", w.Events)
+		}
+		fetched, err := Fetch(w.ID, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if fetched.Secret != "" || fetched.URL != w.URL {
+			t.Errorf("Expected the webhook without its secret, got %+v", *fetched)
+		}
+		webhooks, _ := FetchAll("test", client)
+		if len(webhooks) != 1 || webhooks[0].Secret != "" {
+			t.Errorf("Expected one webhook without its secret, got %+v", webhooks)
+		}
+		if err := Delete(w.ID, "test", client); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if err := Delete(w.ID, "test", client); err == nil || err.Error() != ErrorWebhookNotFound {
+			t.Errorf("Expected error %s, got %v", ErrorWebhookNotFound, err)
+		}
+	})
+	t.Run("expect invalid webhooks to be refused", func(t *testing.T) {
+		configure(t, &receiver{statuses: []int{http.StatusOK}})
+		cases := map[string]Webhook{
+			ErrorInvalidWebhookURL:    {URL: "http://example.com/hooks", Events: []string{EventUserCreated}},
+			ErrorInvalidWebhookEvents: {URL: "https://example.com/hooks", Events: []string{"user.renamed"}},
+		}
+		for expected, w := range cases {
+			if _, err := Register(w, "test", newClient()); err == nil || err.Error() != expected {
+				t.Errorf("Expected error %s, got %v", expected, err)
+			}
+		}
+	})
+	t.Run("expect error when webhooks are not enabled", func(t *testing.T) {
+		if _, err := FetchAll("test", newClient()); err == nil || err.Error() != ErrorWebhooksNotConfigured {
+			t.Errorf("Expected error %s, got %v", ErrorWebhooksNotConfigured, err)
+		}
+	})
+}
+
+// message builds the outbox message announcing event with data.
+func message(t *testing.T, event string, data interface{}) outbox.Message {
+	m, err := outbox.New(event, "alan.oliver@ecs.co.uk", data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	return m
+}
+
+func TestPublish(t *testing.T) {
+	t.Run("expect subscribed webhooks to be sent a signed payload", func(t *testing.T) {
+		r := &receiver{statuses: []int{http.StatusNoContent}}
+		configure(t, r)
+		client := newClient()
+		w, _ := Register(Webhook{URL: "https://example.com/hooks", Secret: "shh", Events: []string{EventUserCreated}}, "test", client)
+		Register(Webhook{URL: "https://example.com/deletions", Events: []string{EventUserDeleted}}, "test", client)
+
+		m := message(t, EventUserCreated, map[string]string{"email": "alan.oliver@ecs.co.uk"})
+		if err := (Publisher{TableName: "test", Client: client}).Publish([]outbox.Message{m}); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(r.requests) != 1 || r.requests[0].URL.String() != w.URL {
+			t.Fatalf("Expected one delivery to %s, got %d", w.URL, len(r.requests))
+		}
+		req := r.requests[0]
+		expected := "sha256=" + Sign("shh", req.Header.Get(HeaderTimestamp), r.bodies[0])
+		if req.Header.Get(HeaderSignature) != expected {
+			t.Errorf("Expected signature %s, got %s", expected, req.Header.Get(HeaderSignature))
+		}
+		var payload Payload
+		if err := json.Unmarshal(r.bodies[0], &payload); err != nil {
+			t.Fatalf("Expected a JSON payload, got %s", r.bodies[0])
+		}
+		if payload.Event != EventUserCreated || payload.ID != m.ID || req.Header.Get(HeaderDelivery) != m.ID {
+			t.Errorf("Expected the event and the message's id, got %+v", payload)
+		}
+		if data, _ := json.Marshal(payload.Data); string(data) != m.Payload {
+			t.Errorf("Expected the message's payload, got %s", data)
+		}
+		deliveries, err := FetchDeliveries(w.ID, "test", client)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		if len(deliveries) != 1 || deliveries[0].Status != StatusDelivered || deliveries[0].Attempts != 1 {
+			t.Errorf("Expected one successful delivery, got %+v", deliveries)
+		}
+	})
+	t.Run("expect server errors to be retried and client errors not", func(t *testing.T) {
+		cases := []struct {
+			statuses []int
+			err      error
+			status   string
+			attempts int
+		}{
+			{statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK}, status: StatusDelivered, attempts: 3},
+			{statuses: []int{http.StatusInternalServerError}, status: StatusFailed, attempts: 3},
+			{statuses: []int{http.StatusGone}, status: StatusFailed, attempts: 1},
+			{err: errors.New("connection refused"), status: StatusFailed, attempts: 3},
+		}
+		for _, c := range cases {
+			configure(t, &receiver{statuses: c.statuses, err: c.err})
+			client := newClient()
+			w, _ := Register(Webhook{URL: "https://example.com/hooks", Events: []string{EventUserUpdated}}, "test", client)
+			(Publisher{TableName: "test", Client: client}).Publish([]outbox.Message{message(t, EventUserUpdated, nil)})
+			deliveries, _ := FetchDeliveries(w.ID, "test", client)
+			if len(deliveries) != 1 || deliveries[0].Status != c.status || deliveries[0].Attempts != c.attempts {
+				t.Errorf("Expected a %s delivery after %d attempts, got %+v", c.status, c.attempts, deliveries)
+			}
+		}
+	})
+	t.Run("expect nothing to be sent when webhooks are not enabled", func(t *testing.T) {
+		client := newClient()
+		if err := (Publisher{TableName: "test", Client: client}).Publish([]outbox.Message{message(t, EventUserCreated, nil)}); err != nil {
+			t.Errorf("Expected no error, got %s", err.Error())
+		}
+	})
+	t.Run("expect a message published again to be delivered under the same id", func(t *testing.T) {
+		r := &receiver{statuses: []int{http.StatusOK}}
+		configure(t, r)
+		client := newClient()
+		Register(Webhook{URL: "https://example.com/hooks", Events: []string{EventUserDeleted}}, "test", client)
+		m := message(t, EventUserDeleted, map[string]string{"email": "alan.oliver@ecs.co.uk"})
+		publisher := Publisher{TableName: "test", Client: client}
+		publisher.Publish([]outbox.Message{m})
+		publisher.Publish([]outbox.Message{m})
+		if len(r.requests) != 2 || r.requests[0].Header.Get(HeaderDelivery) != r.requests[1].Header.Get(HeaderDelivery) {
+			t.Errorf("Expected both deliveries to share an id, got %d deliveries", len(r.requests))
+		}
+	})
+}